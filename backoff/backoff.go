@@ -0,0 +1,112 @@
+// Package backoff retries operations with capped exponential backoff and jitter, so that every subsystem that
+// talks to something unreliable retries the same way.
+package backoff
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+)
+
+// Policy says how long to wait between attempts and how many attempts to make.
+type Policy struct {
+	// InitialDelay is the wait after the first failed attempt.
+	InitialDelay time.Duration
+	// MaxDelay caps the wait between attempts.
+	MaxDelay time.Duration
+	// Multiplier grows the delay after each failed attempt.
+	Multiplier float64
+	// Jitter randomizes each delay by up to this fraction of it, in either direction.
+	Jitter float64
+	// MaxAttempts bounds the number of attempts. Zero retries until the context is done.
+	MaxAttempts int
+}
+
+var DefaultPolicy = Policy{
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     10 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.2,
+	MaxAttempts:  5,
+}
+
+// Delay returns the wait after the given failed attempt, counting from 1, before jitter is applied.
+func (p Policy) Delay(attempt int) time.Duration {
+	delay := float64(p.InitialDelay)
+	for i := 1; i < attempt; i++ {
+		delay *= p.Multiplier
+		if p.MaxDelay > 0 && delay >= float64(p.MaxDelay) {
+			return p.MaxDelay
+		}
+	}
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+func (p Policy) jittered(delay time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return delay
+	}
+	spread := float64(delay) * p.Jitter
+	return time.Duration(float64(delay) - spread + rand.Float64()*2*spread)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps an error to stop Retry from trying again, even once callers have wrapped it further. Retry returns
+// the wrapped error, or the error op returned if that wraps a Permanent error in turn.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Retry calls op until it succeeds, returns a Permanent error, runs out of attempts or ctx is done. It returns the
+// last error from op, or the context's error if ctx finished first.
+func Retry(ctx context.Context, logger lager.Logger, clk clock.Clock, policy Policy, op func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			if err == error(permanent) {
+				return permanent.err
+			}
+			return err
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			logger.Error("retries-exhausted", err, lager.Data{"attempts": attempt})
+			return err
+		}
+
+		delay := policy.jittered(policy.Delay(attempt))
+		logger.Info("retrying", lager.Data{"attempt": attempt, "delay": delay.String(), "error": err.Error()})
+
+		timer := clk.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
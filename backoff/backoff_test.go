@@ -0,0 +1,152 @@
+package backoff_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/backoff"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Policy", func() {
+	var policy backoff.Policy
+
+	BeforeEach(func() {
+		policy = backoff.Policy{InitialDelay: time.Second, MaxDelay: 10 * time.Second, Multiplier: 2}
+	})
+
+	It("should grow the delay exponentially", func() {
+		Expect(policy.Delay(1)).To(Equal(time.Second))
+		Expect(policy.Delay(2)).To(Equal(2 * time.Second))
+		Expect(policy.Delay(3)).To(Equal(4 * time.Second))
+	})
+
+	It("should cap the delay", func() {
+		Expect(policy.Delay(5)).To(Equal(10 * time.Second))
+		Expect(policy.Delay(500)).To(Equal(10 * time.Second))
+	})
+})
+
+var _ = Describe("Retry", func() {
+	var (
+		ctx       context.Context
+		cancel    context.CancelFunc
+		logger    *lagertest.TestLogger
+		fakeClock *fakeclock.FakeClock
+		policy    backoff.Policy
+		attempts  int
+		failures  int
+		opErr     error
+		done      chan error
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		logger = lagertest.NewTestLogger("test-backoff")
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		policy = backoff.Policy{InitialDelay: time.Second, MaxDelay: time.Minute, Multiplier: 2, Jitter: 0.5, MaxAttempts: 3}
+		attempts = 0
+		failures = 0
+		opErr = errors.New("unavailable")
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	JustBeforeEach(func() {
+		done = make(chan error, 1)
+		go func() {
+			done <- backoff.Retry(ctx, logger, fakeClock, policy, func(ctx context.Context) error {
+				attempts++
+				if attempts <= failures {
+					return opErr
+				}
+				return nil
+			})
+		}()
+	})
+
+	Context("when the operation succeeds straight away", func() {
+		It("should not wait", func() {
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(attempts).To(Equal(1))
+		})
+	})
+
+	Context("when the operation succeeds after failing", func() {
+		BeforeEach(func() {
+			failures = 2
+		})
+
+		It("should retry after a delay", func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(attempts).To(Equal(3))
+			Expect(logger).To(gbytes.Say("retrying"))
+		})
+	})
+
+	Context("when the operation keeps failing", func() {
+		BeforeEach(func() {
+			failures = 10
+		})
+
+		It("should give up after the maximum number of attempts", func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Eventually(done).Should(Receive(Equal(opErr)))
+			Expect(attempts).To(Equal(3))
+		})
+	})
+
+	Context("when the operation fails permanently", func() {
+		BeforeEach(func() {
+			failures = 10
+			opErr = backoff.Permanent(errors.New("bad credentials"))
+		})
+
+		It("should not retry", func() {
+			Eventually(done).Should(Receive(MatchError("bad credentials")))
+			Expect(attempts).To(Equal(1))
+		})
+	})
+
+	Context("when the operation wraps a permanent failure", func() {
+		var credentialsErr error
+
+		BeforeEach(func() {
+			failures = 10
+			credentialsErr = errors.New("bad credentials")
+			opErr = fmt.Errorf("failed to connect: %w", backoff.Permanent(credentialsErr))
+		})
+
+		It("should not retry", func() {
+			var err error
+			Eventually(done).Should(Receive(&err))
+			Expect(err).To(MatchError("failed to connect: bad credentials"))
+			Expect(errors.Is(err, credentialsErr)).To(BeTrue())
+			Expect(attempts).To(Equal(1))
+		})
+	})
+
+	Context("when the context is cancelled while waiting", func() {
+		BeforeEach(func() {
+			failures = 10
+		})
+
+		It("should stop retrying", func() {
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+			cancel()
+			Eventually(done).Should(Receive(Equal(context.Canceled)))
+			Expect(attempts).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,13 @@
+package cfapi_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestCfapi(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cfapi Suite")
+}
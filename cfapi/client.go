@@ -0,0 +1,163 @@
+// Package cfapi is a minimal Cloud Controller client for the few lookups the broker makes on its own behalf, and for
+// the egress policies it can create for bound apps.
+package cfapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+)
+
+// tokenExpiryMargin refreshes tokens a little before the UAA would reject them.
+const tokenExpiryMargin = 30 * time.Second
+
+type Client struct {
+	apiURL       string
+	clientID     string
+	clientSecret string
+	httpClient   *http.Client
+	clock        clock.Clock
+
+	mutex         sync.Mutex
+	tokenEndpoint string
+	token         string
+	tokenExpiry   time.Time
+}
+
+// NewClient returns a client that authenticates to the Cloud Controller at apiURL with the UAA client credentials grant.
+func NewClient(apiURL, clientID, clientSecret string, httpClient *http.Client, clock clock.Clock) *Client {
+	return &Client{
+		apiURL:       strings.TrimRight(apiURL, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		httpClient:   httpClient,
+		clock:        clock,
+	}
+}
+
+func (c *Client) OrganizationName(ctx context.Context, guid string) (string, error) {
+	return c.entityName(ctx, "/v2/organizations/"+url.PathEscape(guid))
+}
+
+func (c *Client) SpaceName(ctx context.Context, guid string) (string, error) {
+	return c.entityName(ctx, "/v2/spaces/"+url.PathEscape(guid))
+}
+
+// BindingInstance returns the GUID of the service instance a service credential binding was made for.
+func (c *Client) BindingInstance(ctx context.Context, guid string) (string, error) {
+	var binding struct {
+		Relationships struct {
+			ServiceInstance struct {
+				Data struct {
+					GUID string `json:"guid"`
+				} `json:"data"`
+			} `json:"service_instance"`
+		} `json:"relationships"`
+	}
+	if err := c.get(ctx, "/v3/service_credential_bindings/"+url.PathEscape(guid), &binding); err != nil {
+		return "", err
+	}
+	return binding.Relationships.ServiceInstance.Data.GUID, nil
+}
+
+func (c *Client) entityName(ctx context.Context, path string) (string, error) {
+	var resource struct {
+		Entity struct {
+			Name string `json:"name"`
+		} `json:"entity"`
+	}
+	if err := c.get(ctx, path, &resource); err != nil {
+		return "", err
+	}
+	return resource.Entity.Name, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, result interface{}) error {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", c.apiURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+
+	return c.do(req.WithContext(ctx), result)
+}
+
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.token != "" && c.clock.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	if c.tokenEndpoint == "" {
+		var info struct {
+			TokenEndpoint string `json:"token_endpoint"`
+		}
+		req, err := http.NewRequest("GET", c.apiURL+"/v2/info", nil)
+		if err != nil {
+			return "", err
+		}
+		if err := c.do(req.WithContext(ctx), &info); err != nil {
+			return "", fmt.Errorf("failed to discover token endpoint: %w", err)
+		}
+		c.tokenEndpoint = strings.TrimRight(info.TokenEndpoint, "/")
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequest("POST", c.tokenEndpoint+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(c.clientID, c.clientSecret)
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := c.do(req.WithContext(ctx), &token); err != nil {
+		return "", fmt.Errorf("failed to fetch access token: %w", err)
+	}
+
+	c.token = token.AccessToken
+	c.tokenExpiry = c.clock.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return c.token, nil
+}
+
+func (c *Client) do(req *http.Request, result interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case resp.StatusCode == http.StatusConflict:
+		return ErrConflict
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("%s %s returned status %d", req.Method, req.URL.Path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,237 @@
+package cfapi_test
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/nfsbroker/cfapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		server    *ghttp.Server
+		fakeClock *fakeclock.FakeClock
+		client    *cfapi.Client
+		ctx       context.Context
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		client = cfapi.NewClient(server.URL(), "broker-client", "broker-secret", http.DefaultClient, fakeClock)
+		ctx = context.TODO()
+
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v2/info"),
+				ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]string{"token_endpoint": server.URL() + "/uaa"}),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/uaa/oauth/token"),
+				ghttp.VerifyBasicAuth("broker-client", "broker-secret"),
+				ghttp.VerifyFormKV("grant_type", "client_credentials"),
+				ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"access_token": "some-token", "expires_in": 600}),
+			),
+		)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("OrganizationName", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/v2/organizations/org-guid"),
+					ghttp.VerifyHeaderKV("Authorization", "bearer some-token"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"entity": map[string]string{"name": "my-org"}}),
+				),
+			)
+		})
+
+		It("returns the organization's name", func() {
+			name, err := client.OrganizationName(ctx, "org-guid")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(Equal("my-org"))
+		})
+
+		Context("when called again before the token expires", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/v2/spaces/space-guid"),
+						ghttp.VerifyHeaderKV("Authorization", "bearer some-token"),
+						ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"entity": map[string]string{"name": "my-space"}}),
+					),
+				)
+			})
+
+			It("reuses the token", func() {
+				_, err := client.OrganizationName(ctx, "org-guid")
+				Expect(err).NotTo(HaveOccurred())
+				name, err := client.SpaceName(ctx, "space-guid")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(name).To(Equal("my-space"))
+				Expect(server.ReceivedRequests()).To(HaveLen(4))
+			})
+		})
+
+		Context("when the token has expired", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/uaa/oauth/token"),
+						ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"access_token": "new-token", "expires_in": 600}),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/v2/organizations/org-guid"),
+						ghttp.VerifyHeaderKV("Authorization", "bearer new-token"),
+						ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"entity": map[string]string{"name": "my-org"}}),
+					),
+				)
+			})
+
+			It("fetches a new one", func() {
+				_, err := client.OrganizationName(ctx, "org-guid")
+				Expect(err).NotTo(HaveOccurred())
+				fakeClock.Increment(10 * time.Minute)
+				_, err = client.OrganizationName(ctx, "org-guid")
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("BindingInstance", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/v3/service_credential_bindings/binding-guid"),
+					ghttp.VerifyHeaderKV("Authorization", "bearer some-token"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+						"guid": "binding-guid",
+						"relationships": map[string]interface{}{
+							"service_instance": map[string]interface{}{"data": map[string]string{"guid": "instance-guid"}},
+						},
+					}),
+				),
+			)
+		})
+
+		It("returns the GUID of the binding's service instance", func() {
+			instanceGUID, err := client.BindingInstance(ctx, "binding-guid")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instanceGUID).To(Equal("instance-guid"))
+		})
+	})
+
+	Context("when the organization does not exist", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, `{}`))
+		})
+
+		It("returns ErrNotFound", func() {
+			_, err := client.OrganizationName(ctx, "missing-guid")
+			Expect(err).To(Equal(cfapi.ErrNotFound))
+		})
+	})
+
+	Context("when the UAA rejects the credentials", func() {
+		BeforeEach(func() {
+			server.SetHandler(1, ghttp.RespondWith(http.StatusUnauthorized, `{}`))
+		})
+
+		It("returns an error", func() {
+			_, err := client.OrganizationName(ctx, "org-guid")
+			Expect(err).To(MatchError(ContainSubstring("failed to fetch access token")))
+		})
+	})
+
+	Describe("AllowEgress", func() {
+		var destination cfapi.Destination
+
+		BeforeEach(func() {
+			destination = cfapi.Destination{
+				Name:     "nfs-tcp-10.0.0.1-111-2049",
+				Protocol: "tcp",
+				IPs:      []string{"10.0.0.1"},
+				Ports:    []int{111, 2049},
+			}
+		})
+
+		Context("when the destination does not exist", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/networking/v1/external/destinations"),
+						ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"destinations": []interface{}{}}),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/networking/v1/external/destinations"),
+						ghttp.VerifyHeaderKV("Authorization", "bearer some-token"),
+						ghttp.VerifyJSON(`{"destinations":[{"name":"nfs-tcp-10.0.0.1-111-2049","protocol":"tcp",
+							"ips":[{"start":"10.0.0.1","end":"10.0.0.1"}],
+							"ports":[{"start":111,"end":111},{"start":2049,"end":2049}]}]}`),
+						ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+							"destinations": []interface{}{map[string]string{"id": "destination-guid"}},
+						}),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/networking/v1/external/egress_policies"),
+						ghttp.VerifyJSON(`{"egress_policies":[{"source":{"id":"app-guid","type":"app"},"destination":{"id":"destination-guid"}}]}`),
+						ghttp.RespondWith(http.StatusOK, `{}`),
+					),
+				)
+			})
+
+			It("creates it before the policy", func() {
+				Expect(client.AllowEgress(ctx, "app-guid", destination)).To(Succeed())
+				Expect(server.ReceivedRequests()).To(HaveLen(5))
+			})
+		})
+
+		Context("when the destination exists", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/networking/v1/external/destinations"),
+						ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+							"destinations": []interface{}{map[string]string{"id": "destination-guid", "name": destination.Name}},
+						}),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/networking/v1/external/egress_policies"),
+						ghttp.VerifyJSON(`{"egress_policies":[{"source":{"id":"app-guid","type":"app"},"destination":{"id":"destination-guid"}}]}`),
+						ghttp.RespondWith(http.StatusConflict, `{}`),
+					),
+				)
+			})
+
+			It("reuses it, and accepts a policy that exists already", func() {
+				Expect(client.AllowEgress(ctx, "app-guid", destination)).To(Succeed())
+				Expect(server.ReceivedRequests()).To(HaveLen(4))
+			})
+		})
+
+		Context("when the policy cannot be created", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+						"destinations": []interface{}{map[string]string{"id": "destination-guid", "name": destination.Name}},
+					}),
+					ghttp.RespondWith(http.StatusForbidden, `{}`),
+				)
+			})
+
+			It("returns an error", func() {
+				err := client.AllowEgress(ctx, "app-guid", destination)
+				Expect(err).To(MatchError(ContainSubstring("failed to create egress policy for app app-guid")))
+			})
+		})
+	})
+})
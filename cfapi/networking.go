@@ -0,0 +1,126 @@
+package cfapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	destinationsPath    = "/networking/v1/external/destinations"
+	egressPoliciesPath  = "/networking/v1/external/egress_policies"
+	egressSourceTypeApp = "app"
+)
+
+// Destination is an external destination of the networking policy API: a set of addresses and the ports apps may
+// reach on them.
+type Destination struct {
+	Name        string
+	Description string
+	Protocol    string
+	IPs         []string
+	Ports       []int
+}
+
+type destinationRange struct {
+	Start interface{} `json:"start"`
+	End   interface{} `json:"end"`
+}
+
+type destinationResource struct {
+	ID          string             `json:"id,omitempty"`
+	Name        string             `json:"name"`
+	Description string             `json:"description,omitempty"`
+	Protocol    string             `json:"protocol"`
+	IPs         []destinationRange `json:"ips"`
+	Ports       []destinationRange `json:"ports"`
+}
+
+type destinationsResource struct {
+	Destinations []destinationResource `json:"destinations"`
+}
+
+// AllowEgress creates a dynamic egress policy from the app to destination through the networking policy API.  The
+// destination is created unless one with the same name exists, and policies that exist already are left alone, so
+// calling it again for the same app and destination succeeds.
+func (c *Client) AllowEgress(ctx context.Context, appGUID string, destination Destination) error {
+	destinationID, err := c.destinationID(ctx, destination)
+	if err != nil {
+		return err
+	}
+
+	policies := map[string]interface{}{
+		"egress_policies": []interface{}{map[string]interface{}{
+			"source":      map[string]string{"id": appGUID, "type": egressSourceTypeApp},
+			"destination": map[string]string{"id": destinationID},
+		}},
+	}
+	err = c.post(ctx, egressPoliciesPath, policies, nil)
+	if err != nil && !errors.Is(err, ErrConflict) {
+		return fmt.Errorf("failed to create egress policy for app %s: %w", appGUID, err)
+	}
+	return nil
+}
+
+// destinationID returns the ID of the destination with the given destination's name, creating it if there is none.
+func (c *Client) destinationID(ctx context.Context, destination Destination) (string, error) {
+	var existing destinationsResource
+	if err := c.get(ctx, destinationsPath, &existing); err != nil {
+		return "", fmt.Errorf("failed to list egress destinations: %w", err)
+	}
+	for _, resource := range existing.Destinations {
+		if resource.Name == destination.Name {
+			return resource.ID, nil
+		}
+	}
+
+	resource := destinationResource{
+		Name:        destination.Name,
+		Description: destination.Description,
+		Protocol:    destination.Protocol,
+		IPs:         []destinationRange{},
+		Ports:       []destinationRange{},
+	}
+	for _, ip := range destination.IPs {
+		resource.IPs = append(resource.IPs, destinationRange{Start: ip, End: ip})
+	}
+	for _, port := range destination.Ports {
+		resource.Ports = append(resource.Ports, destinationRange{Start: port, End: port})
+	}
+
+	var created destinationsResource
+	err := c.post(ctx, destinationsPath, destinationsResource{Destinations: []destinationResource{resource}}, &created)
+	if err != nil {
+		return "", fmt.Errorf("failed to create egress destination %s: %w", destination.Name, err)
+	}
+	if len(created.Destinations) != 1 || created.Destinations[0].ID == "" {
+		return "", fmt.Errorf("creating egress destination %s returned no destination", destination.Name)
+	}
+	return created.Destinations[0].ID, nil
+}
+
+func (c *Client) post(ctx context.Context, path string, body, result interface{}) error {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.apiURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	if result == nil {
+		result = &json.RawMessage{}
+	}
+	return c.do(req.WithContext(ctx), result)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+// catalogSetting is the config file setting that holds a service catalog, in place of -catalogPath.
+const catalogSetting = "catalog"
+
+// catalogData is the service catalog given in the config file, if any.
+var catalogData []byte
+
+// givenFlags are the flags given on the command line or set by environment variables, which the config file does not
+// override.
+var givenFlags map[string]bool
+
+// setFlags returns the names of the flags of flags that have been set.
+func setFlags(flags *flag.FlagSet) map[string]bool {
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// loadConfigFile sets the flags of flags from the settings in the YAML or JSON file at path, which are keyed by flag
+// name, and returns the service catalog the file holds, if any.  Flags that are given keep their values.  Lists are
+// joined with commas, for flags such as -allowedCIDRs that take comma separated values.
+func loadConfigFile(flags *flag.FlagSet, path string, given map[string]bool) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var catalog []byte
+	for _, name := range names {
+		setting := settings[name]
+		if name == catalogSetting {
+			if _, ok := settings["catalogPath"]; ok {
+				return nil, fmt.Errorf("invalid config file %s: catalog and catalogPath cannot both be set", path)
+			}
+			if given["catalogPath"] {
+				continue
+			}
+			if catalog, err = yaml.Marshal(setting); err != nil {
+				return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+			}
+			continue
+		}
+
+		if flags.Lookup(name) == nil || name == "configPath" {
+			return nil, fmt.Errorf("invalid config file %s: unknown setting %s", path, name)
+		}
+		if given[name] {
+			continue
+		}
+		value, err := configValue(setting)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config file %s: setting %s %w", path, name, err)
+		}
+		if err := flags.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: setting %s: %w", path, name, err)
+		}
+	}
+	return catalog, nil
+}
+
+// configValue formats a setting as the command line value of its flag.
+func configValue(setting interface{}) (string, error) {
+	switch setting := setting.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		values := make([]string, 0, len(setting))
+		for _, element := range setting {
+			switch element.(type) {
+			case []interface{}, map[interface{}]interface{}:
+				return "", fmt.Errorf("must be a list of single values")
+			}
+			values = append(values, fmt.Sprint(element))
+		}
+		return strings.Join(values, ","), nil
+	case map[interface{}]interface{}:
+		return "", fmt.Errorf("must be a single value or a list")
+	}
+	return fmt.Sprint(setting), nil
+}
+
+// environmentPrefix starts the names of the environment variables that set flags.
+const environmentPrefix = "NFSBROKER_"
+
+// loadEnvironmentFlags sets each flag of flags that was not given on the command line from its environment variable, if
+// lookup finds one: -listenAddr from NFSBROKER_LISTEN_ADDR, -dbCACert from NFSBROKER_DB_CA_CERT and so on.
+func loadEnvironmentFlags(flags *flag.FlagSet, lookup func(string) (string, bool)) error {
+	given := setFlags(flags)
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		name := environmentVariable(f.Name)
+		value, ok := lookup(name)
+		if !ok || given[f.Name] || err != nil {
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid %s: %w", name, setErr)
+		}
+	})
+	return err
+}
+
+// environmentVariable names the environment variable of a flag, splitting its camel case words with underscores.
+// Acronyms are kept whole, including plurals such as allowedCIDRs.
+func environmentVariable(flagName string) string {
+	name := []rune(flagName)
+	variable := environmentPrefix
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			previous := name[i-1]
+			endsAcronym := i+1 < len(name) && unicode.IsLower(name[i+1]) && !isPlural(name, i+1)
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && endsAcronym) {
+				variable += "_"
+			}
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			variable += string(unicode.ToUpper(r))
+		} else {
+			variable += "_"
+		}
+	}
+	return variable
+}
+
+// isPlural reports whether the letter at i of name is an s that pluralizes the acronym before it.
+func isPlural(name []rune, i int) bool {
+	return name[i] == 's' && (i+1 == len(name) || unicode.IsUpper(name[i+1]))
+}
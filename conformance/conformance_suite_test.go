@@ -0,0 +1,69 @@
+package conformance_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+
+	"testing"
+)
+
+var binaryPath string
+
+func TestConformance(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OSB Conformance Suite")
+}
+
+var _ = SynchronizedBeforeSuite(func() []byte {
+	var err error
+	binaryPath, err = gexec.Build("code.cloudfoundry.org/nfsbroker")
+	Expect(err).NotTo(HaveOccurred())
+
+	return []byte(binaryPath)
+}, func(bytes []byte) {
+	binaryPath = string(bytes)
+})
+
+// report is the conformance report written to $CONFORMANCE_REPORT, one result per check.  The suite is meant to be
+// run on a single node, as each node would overwrite the others' report.
+type report struct {
+	BrokerAPIVersion string        `json:"broker_api_version"`
+	Passed           bool          `json:"passed"`
+	Checks           []checkResult `json:"checks"`
+}
+
+type checkResult struct {
+	Check    string  `json:"check"`
+	Passed   bool    `json:"passed"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+var results = report{BrokerAPIVersion: brokerAPIVersion, Passed: true, Checks: []checkResult{}}
+
+var _ = AfterEach(func() {
+	description := CurrentGinkgoTestDescription()
+	results.Checks = append(results.Checks, checkResult{
+		Check:    description.FullTestText,
+		Passed:   !description.Failed,
+		Duration: description.Duration.Round(time.Millisecond).Seconds(),
+	})
+	results.Passed = results.Passed && !description.Failed
+})
+
+var _ = SynchronizedAfterSuite(func() {
+	path := os.Getenv("CONFORMANCE_REPORT")
+	if path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(results, "", "  ")
+	Expect(err).NotTo(HaveOccurred())
+	Expect(ioutil.WriteFile(path, data, 0644)).To(Succeed())
+}, func() {
+	gexec.CleanupBuildArtifacts()
+})
@@ -0,0 +1,297 @@
+package conformance_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/ifrit/ginkgomon"
+)
+
+const (
+	brokerAPIVersion = "2.14"
+
+	username = "admin"
+	password = "password"
+
+	serviceID  = "nfsbroker"
+	planID     = "Existing"
+	instanceID = "conformance-instance"
+	bindingID  = "conformance-binding"
+	appGUID    = "conformance-app"
+)
+
+var _ = Describe("Open Service Broker API conformance", func() {
+	var (
+		listenAddr string
+		dataDir    string
+		process    ifrit.Process
+	)
+
+	BeforeEach(func() {
+		listenAddr = "127.0.0.1:" + strconv.Itoa(9199+GinkgoParallelNode())
+
+		var err error
+		dataDir, err = ioutil.TempDir("", "conformance")
+		Expect(err).NotTo(HaveOccurred())
+
+		command := exec.Command(binaryPath, "-listenAddr", listenAddr, "-dataDir", dataDir, "-serviceId", serviceID)
+		command.Env = append(os.Environ(), "USERNAME="+username, "PASSWORD="+password)
+		process = ginkgomon.Invoke(ginkgomon.New(ginkgomon.Config{
+			Name:       "nfsbroker",
+			Command:    command,
+			StartCheck: "started",
+		}))
+	})
+
+	AfterEach(func() {
+		ginkgomon.Kill(process)
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
+	request := func(method, path string, body interface{}) (int, map[string]interface{}) {
+		var reader *bytes.Reader
+		if body == nil {
+			reader = bytes.NewReader(nil)
+		} else {
+			data, err := json.Marshal(body)
+			Expect(err).NotTo(HaveOccurred())
+			reader = bytes.NewReader(data)
+		}
+
+		req, err := http.NewRequest(method, "http://"+listenAddr+path, reader)
+		Expect(err).NotTo(HaveOccurred())
+		req.SetBasicAuth(username, password)
+		req.Header.Set("X-Broker-API-Version", brokerAPIVersion)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+
+		data, err := ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		var response map[string]interface{}
+		if len(data) > 0 {
+			Expect(json.Unmarshal(data, &response)).To(Succeed(), "response body is not a JSON object: %s", data)
+		}
+		return resp.StatusCode, response
+	}
+
+	provisionBody := func(parameters map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"service_id":        serviceID,
+			"plan_id":           planID,
+			"organization_guid": "conformance-org",
+			"space_guid":        "conformance-space",
+			"parameters":        parameters,
+		}
+	}
+
+	bindBody := map[string]interface{}{
+		"service_id":    serviceID,
+		"plan_id":       planID,
+		"app_guid":      appGUID,
+		"bind_resource": map[string]interface{}{"app_guid": appGUID},
+	}
+
+	instancePath := "/v2/service_instances/" + instanceID
+	bindingPath := instancePath + "/service_bindings/" + bindingID
+	removalQuery := "?service_id=" + serviceID + "&plan_id=" + planID
+
+	provision := func() {
+		status, _ := request("PUT", instancePath, provisionBody(map[string]interface{}{"share": "server/some-share"}))
+		Expect(status).To(Equal(http.StatusCreated))
+	}
+
+	bind := func() {
+		status, _ := request("PUT", bindingPath, bindBody)
+		Expect(status).To(Equal(http.StatusCreated))
+	}
+
+	Describe("catalog", func() {
+		It("requires authentication", func() {
+			resp, err := http.Get("http://" + listenAddr + "/v2/catalog")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("requires a supported X-Broker-API-Version header", func() {
+			for _, version := range []string{"", "1.13", "2.10"} {
+				req, err := http.NewRequest("GET", "http://"+listenAddr+"/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				req.SetBasicAuth(username, password)
+				if version != "" {
+					req.Header.Set("X-Broker-API-Version", version)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusPreconditionFailed), "version %q", version)
+			}
+		})
+
+		It("describes bindable services that require volume mounts", func() {
+			status, catalog := request("GET", "/v2/catalog", nil)
+			Expect(status).To(Equal(http.StatusOK))
+
+			services, ok := catalog["services"].([]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(services).NotTo(BeEmpty())
+			for _, service := range services {
+				service := service.(map[string]interface{})
+				Expect(service).To(HaveKey("id"))
+				Expect(service).To(HaveKey("name"))
+				Expect(service).To(HaveKey("description"))
+				Expect(service).To(HaveKeyWithValue("bindable", true))
+				Expect(service).To(HaveKeyWithValue("requires", ContainElement("volume_mount")))
+
+				plans, ok := service["plans"].([]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(plans).NotTo(BeEmpty())
+				for _, plan := range plans {
+					Expect(plan).To(HaveKey("id"))
+					Expect(plan).To(HaveKey("name"))
+					Expect(plan).To(HaveKey("description"))
+				}
+			}
+		})
+	})
+
+	Describe("provisioning", func() {
+		It("creates a service instance", func() {
+			status, body := request("PUT", instancePath, provisionBody(map[string]interface{}{"share": "server/some-share"}))
+			Expect(status).To(Equal(http.StatusCreated))
+			Expect(body).NotTo(BeNil())
+		})
+
+		It("conflicts with an existing instance provisioned with other parameters", func() {
+			provision()
+
+			status, _ := request("PUT", instancePath, provisionBody(map[string]interface{}{"share": "server/other-share"}))
+			Expect(status).To(Equal(http.StatusConflict))
+		})
+
+		It("rejects a request without required parameters", func() {
+			status, body := request("PUT", instancePath, provisionBody(map[string]interface{}{}))
+			Expect(status).To(Equal(http.StatusBadRequest))
+			Expect(body).To(HaveKey("description"))
+		})
+
+		It("rejects a plan that is not in the catalog", func() {
+			requestBody := provisionBody(map[string]interface{}{"share": "server/some-share"})
+			requestBody["plan_id"] = "not-a-plan"
+
+			status, body := request("PUT", instancePath, requestBody)
+			Expect(status).To(Equal(http.StatusBadRequest))
+			Expect(body).To(HaveKey("description"))
+		})
+	})
+
+	Describe("fetching a service instance", func() {
+		It("returns the instance's service, plan and parameters", func() {
+			provision()
+
+			status, body := request("GET", instancePath, nil)
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(HaveKeyWithValue("service_id", serviceID))
+			Expect(body).To(HaveKeyWithValue("plan_id", planID))
+			Expect(body).To(HaveKeyWithValue("parameters", HaveKeyWithValue("share", "server/some-share")))
+		})
+
+		It("returns 404 for an unknown instance", func() {
+			status, _ := request("GET", "/v2/service_instances/does-not-exist", nil)
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("updating a service instance", func() {
+		It("changes the instance's parameters", func() {
+			provision()
+
+			status, _ := request("PATCH", instancePath, map[string]interface{}{
+				"service_id": serviceID,
+				"parameters": map[string]interface{}{"share": "server/other-share"},
+			})
+			Expect(status).To(Equal(http.StatusOK))
+
+			status, body := request("GET", instancePath, nil)
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(HaveKeyWithValue("parameters", HaveKeyWithValue("share", "server/other-share")))
+		})
+	})
+
+	Describe("binding", func() {
+		It("returns credentials and volume mounts", func() {
+			provision()
+
+			status, body := request("PUT", bindingPath, bindBody)
+			Expect(status).To(Equal(http.StatusCreated))
+			Expect(body).To(HaveKey("credentials"))
+
+			mounts, ok := body["volume_mounts"].([]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(mounts).To(HaveLen(1))
+			mount := mounts[0].(map[string]interface{})
+			Expect(mount).To(HaveKey("driver"))
+			Expect(mount).To(HaveKey("container_dir"))
+			Expect(mount).To(HaveKeyWithValue("mode", BeElementOf("r", "rw")))
+			Expect(mount).To(HaveKeyWithValue("device_type", "shared"))
+			Expect(mount).To(HaveKeyWithValue("device", HaveKey("volume_id")))
+		})
+
+		It("can be fetched", func() {
+			provision()
+			bind()
+
+			status, body := request("GET", bindingPath, nil)
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(HaveKey("credentials"))
+			Expect(body).To(HaveKeyWithValue("volume_mounts", HaveLen(1)))
+		})
+
+		It("returns 404 when fetching an unknown binding", func() {
+			provision()
+
+			status, _ := request("GET", instancePath+"/service_bindings/does-not-exist", nil)
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("unbinding", func() {
+		It("removes the binding, and returns 410 once it is gone", func() {
+			provision()
+			bind()
+
+			status, body := request("DELETE", bindingPath+removalQuery, nil)
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).NotTo(BeNil())
+
+			status, _ = request("DELETE", bindingPath+removalQuery, nil)
+			Expect(status).To(Equal(http.StatusGone))
+		})
+	})
+
+	Describe("deprovisioning", func() {
+		It("removes the instance, and returns 410 once it is gone", func() {
+			provision()
+
+			status, body := request("DELETE", instancePath+removalQuery, nil)
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).NotTo(BeNil())
+
+			status, _ = request("DELETE", instancePath+removalQuery, nil)
+			Expect(status).To(Equal(http.StatusGone))
+
+			status, _ = request("GET", instancePath, nil)
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+})
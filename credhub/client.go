@@ -0,0 +1,108 @@
+// Package credhub is a client for CredHub, which resolves references to the credentials it stores so that they need
+// not be interpolated into the broker's manifest.
+package credhub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client reads credentials from the CredHub at its URL.  CredHub authenticates the broker by the client certificate
+// of its HTTP client.
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewClient returns a client for the CredHub at url, such as https://credhub.service.cf.internal:8844.
+func NewClient(url string, httpClient *http.Client) *Client {
+	return &Client{
+		url:        strings.TrimRight(url, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// ParseReference returns the credential name and field of a reference such as ((/nfsbroker/admin.password)), as BOSH
+// writes them.  The field is "" when the reference does not name one.  It returns false for values that are not
+// references.
+func ParseReference(value string) (string, string, bool) {
+	if !strings.HasPrefix(value, "((") || !strings.HasSuffix(value, "))") {
+		return "", "", false
+	}
+	name := strings.TrimSpace(value[2 : len(value)-2])
+	if name == "" {
+		return "", "", false
+	}
+	// fields follow the first dot of the name's last path segment
+	base := strings.LastIndex(name, "/") + 1
+	if i := strings.Index(name[base:], "."); i >= 0 {
+		return name[:base+i], name[base+i+1:], true
+	}
+	return name, "", true
+}
+
+// Resolve returns the credential value references, or value itself when it is not a reference.
+func (c *Client) Resolve(ctx context.Context, value string) (string, error) {
+	name, field, ok := ParseReference(value)
+	if !ok {
+		return value, nil
+	}
+	return c.Get(ctx, name, field)
+}
+
+// Get returns the current value of the named credential.  Credentials with fields, such as user credentials, need
+// the field to return; values and passwords need none.
+func (c *Client) Get(ctx context.Context, name, field string) (string, error) {
+	req, err := http.NewRequest("GET", c.url+"/api/v1/data?"+url.Values{"name": {name}, "current": {"true"}}.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("failed to get credential %s from CredHub: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("credential %s is not in CredHub", name)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get credential %s from CredHub: status %d", name, resp.StatusCode)
+	}
+
+	var body struct {
+		Data []struct {
+			Type  string          `json:"type"`
+			Value json.RawMessage `json:"value"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid CredHub response for credential %s: %w", name, err)
+	}
+	if len(body.Data) == 0 {
+		return "", fmt.Errorf("credential %s is not in CredHub", name)
+	}
+	credential := body.Data[0]
+
+	if field == "" {
+		var value string
+		if err := json.Unmarshal(credential.Value, &value); err != nil {
+			return "", fmt.Errorf("credential %s is a %s credential; name one of its fields, such as ((%s.password))", name, credential.Type, name)
+		}
+		return value, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(credential.Value, &fields); err != nil {
+		return "", fmt.Errorf("credential %s is a %s credential, which has no fields", name, credential.Type)
+	}
+	value, ok := fields[field].(string)
+	if !ok {
+		return "", fmt.Errorf("credential %s has no %s field", name, field)
+	}
+	return value, nil
+}
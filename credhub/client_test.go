@@ -0,0 +1,106 @@
+package credhub_test
+
+import (
+	"context"
+	"net/http"
+
+	"code.cloudfoundry.org/nfsbroker/credhub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		server *ghttp.Server
+		client *credhub.Client
+		ctx    context.Context
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		client = credhub.NewClient(server.URL(), http.DefaultClient)
+		ctx = context.TODO()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("ParseReference", func() {
+		It("parses names and fields", func() {
+			name, field, ok := credhub.ParseReference("((/nfsbroker/admin.password))")
+			Expect(ok).To(BeTrue())
+			Expect(name).To(Equal("/nfsbroker/admin"))
+			Expect(field).To(Equal("password"))
+
+			name, field, ok = credhub.ParseReference("((/nfs.broker/db_password))")
+			Expect(ok).To(BeTrue())
+			Expect(name).To(Equal("/nfs.broker/db_password"))
+			Expect(field).To(BeEmpty())
+		})
+
+		It("does not take other values for references", func() {
+			_, _, ok := credhub.ParseReference("p((ass))")
+			Expect(ok).To(BeFalse())
+			_, _, ok = credhub.ParseReference("(())")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("Resolve", func() {
+		It("gets the current value of referenced credentials", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/v1/data", "current=true&name=%2Fnfsbroker%2Fdb_password"),
+					ghttp.RespondWith(http.StatusOK, `{"data":[{"type":"password","value":"s3cret"}]}`),
+				),
+			)
+
+			value, err := client.Resolve(ctx, "((/nfsbroker/db_password))")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal("s3cret"))
+		})
+
+		It("gets fields of user credentials", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/v1/data", "current=true&name=%2Fnfsbroker%2Fadmin"),
+					ghttp.RespondWith(http.StatusOK, `{"data":[{"type":"user","value":{"username":"admin","password":"s3cret"}}]}`),
+				),
+			)
+
+			value, err := client.Resolve(ctx, "((/nfsbroker/admin.username))")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal("admin"))
+		})
+
+		It("returns values that are not references as they are", func() {
+			value, err := client.Resolve(ctx, "plain-password")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal("plain-password"))
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("fails for credentials that are not in CredHub", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, `{"error":"not found"}`))
+
+			_, err := client.Resolve(ctx, "((/nfsbroker/missing))")
+			Expect(err).To(MatchError("credential /nfsbroker/missing is not in CredHub"))
+		})
+
+		It("fails for credentials with fields when none is named", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusOK, `{"data":[{"type":"user","value":{"username":"admin","password":"s3cret"}}]}`))
+
+			_, err := client.Resolve(ctx, "((/nfsbroker/admin))")
+			Expect(err).To(MatchError(ContainSubstring("name one of its fields, such as ((/nfsbroker/admin.password))")))
+		})
+
+		It("fails for fields the credential does not have", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusOK, `{"data":[{"type":"user","value":{"username":"admin","password":"s3cret"}}]}`))
+
+			_, err := client.Resolve(ctx, "((/nfsbroker/admin.token))")
+			Expect(err).To(MatchError("credential /nfsbroker/admin has no token field"))
+		})
+	})
+})
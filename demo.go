@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// DemoCommand runs the broker as a development sandbox, like DevServerCommand, next to a stub NFS server, and walks
+// through the lifecycle of an instance of the stub's export once the broker has started, so that the broker can be
+// tried out without Cloud Foundry or a filer.
+const DemoCommand = "demo"
+
+var demoMode bool
+
+// The parts of ONC RPC (RFC 5531) the stub NFS server speaks.
+const (
+	nfsProgram = 100003
+
+	rpcCall            = 0
+	rpcReply           = 1
+	rpcMsgAccepted     = 0
+	rpcSuccess         = 0
+	rpcProgUnavailable = 1
+	rpcProcUnavailable = 3
+	rpcLastFragment    = 1 << 31
+	rpcMaxRecordSize   = 1 << 16
+)
+
+const (
+	demoExportPath     = "/demo"
+	demoInstanceID     = "demo-instance"
+	demoBindingID      = "demo-binding"
+	demoAPIVersion     = "2.14"
+	demoRequestTimeout = 10 * time.Second
+)
+
+// demoRunner serves the stub NFS server and runs the walkthrough.  As a member of the ordered group after the
+// broker API, it starts once the broker is listening.
+type demoRunner struct {
+	logger     lager.Logger
+	brokerAddr string
+}
+
+func (d *demoRunner) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	go serveNFSStub(d.logger.Session("nfs-stub"), listener)
+	d.logger.Info("nfs-stub-listening", lager.Data{"address": listener.Addr().String(), "export": demoExportPath})
+
+	close(ready)
+	go d.walkthrough(listener.Addr().String())
+
+	<-signals
+	return nil
+}
+
+// serveNFSStub answers NULL procedure calls to the NFS program, which is all that checking a server is up takes, and
+// refuses every other call.
+func serveNFSStub(logger lager.Logger, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				if err := answerRPC(conn); err != nil {
+					if err != io.EOF {
+						logger.Error("failed-to-answer-call", err)
+					}
+					return
+				}
+			}
+		}()
+	}
+}
+
+// answerRPC reads one ONC RPC call record (RFC 5531) and writes its reply.
+func answerRPC(conn io.ReadWriter) error {
+	var marker uint32
+	if err := binary.Read(conn, binary.BigEndian, &marker); err != nil {
+		return err
+	}
+	length := marker &^ rpcLastFragment
+	if length < 24 || length > rpcMaxRecordSize {
+		return fmt.Errorf("unexpected record of %d bytes", length)
+	}
+	record := make([]byte, length)
+	if _, err := io.ReadFull(conn, record); err != nil {
+		return err
+	}
+
+	// xid, message type, RPC version, program, program version, procedure
+	var call [6]uint32
+	if err := binary.Read(bytes.NewReader(record), binary.BigEndian, &call); err != nil {
+		return err
+	}
+	if call[1] != rpcCall {
+		return fmt.Errorf("unexpected message type %d", call[1])
+	}
+
+	status := uint32(rpcSuccess)
+	switch {
+	case call[3] != nfsProgram:
+		status = rpcProgUnavailable
+	case call[5] != 0:
+		status = rpcProcUnavailable
+	}
+	// xid, reply, accepted, null verifier flavor and length, accept status
+	reply := []uint32{call[0], rpcReply, rpcMsgAccepted, 0, 0, status}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(rpcLastFragment|4*len(reply)))
+	binary.Write(&buf, binary.BigEndian, reply)
+	_, err := conn.Write(buf.Bytes())
+	return err
+}
+
+// pingNFS calls the NULL procedure of version 3 of the NFS program at addr.
+func pingNFS(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, demoRequestTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(demoRequestTimeout))
+
+	// xid, call, RPC version 2, NFS, version 3, NULL, null credentials and verifier
+	call := []uint32{1, rpcCall, 2, nfsProgram, 3, 0, 0, 0, 0, 0}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(rpcLastFragment|4*len(call)))
+	binary.Write(&buf, binary.BigEndian, call)
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	var reply [7]uint32
+	if err := binary.Read(conn, binary.BigEndian, &reply); err != nil {
+		return err
+	}
+	if reply[1] != 1 || reply[2] != rpcReply || reply[3] != rpcMsgAccepted || reply[6] != rpcSuccess {
+		return errors.New("the NFS server did not accept the NULL call")
+	}
+	return nil
+}
+
+type demoStep struct {
+	name   string
+	method string
+	path   string
+	body   string
+	status int
+}
+
+// walkthrough provisions an instance of the stub's export with the catalog's first plan, binds an app to it, fetches
+// the binding and then unbinds and deprovisions, logging each request and response.
+func (d *demoRunner) walkthrough(nfsAddr string) {
+	logger := d.logger.Session("walkthrough")
+
+	if err := pingNFS(nfsAddr); err != nil {
+		logger.Error("demo-step-failed", err, lager.Data{"step": "ping-nfs-server"})
+		return
+	}
+	logger.Info("demo-step", lager.Data{"step": "ping-nfs-server", "address": nfsAddr})
+
+	status, body, err := d.do(demoStep{method: "GET", path: "/v2/catalog"})
+	var catalog brokerapi.CatalogResponse
+	if err == nil {
+		err = json.Unmarshal([]byte(body), &catalog)
+	}
+	if err == nil && (status != http.StatusOK || len(catalog.Services) == 0 || len(catalog.Services[0].Plans) == 0) {
+		err = fmt.Errorf("expected a catalog with a plan, got status %d", status)
+	}
+	if err != nil {
+		logger.Error("demo-step-failed", err, lager.Data{"step": "catalog", "response_body": body})
+		return
+	}
+	serviceID, planID := catalog.Services[0].ID, catalog.Services[0].Plans[0].ID
+	logger.Info("demo-step", lager.Data{"step": "catalog", "status": status, "service_id": serviceID, "plan_id": planID})
+
+	host, _, _ := net.SplitHostPort(nfsAddr)
+	instancePath := "/v2/service_instances/" + demoInstanceID
+	bindingPath := instancePath + "/service_bindings/" + demoBindingID
+	query := "?" + url.Values{"service_id": {serviceID}, "plan_id": {planID}}.Encode()
+	ids := fmt.Sprintf(`"service_id":%q,"plan_id":%q`, serviceID, planID)
+
+	steps := []demoStep{
+		{name: "provision", method: "PUT", path: instancePath,
+			body:   fmt.Sprintf(`{%s,"organization_guid":"demo-org","space_guid":"demo-space","parameters":{"share":"%s:%s"}}`, ids, host, demoExportPath),
+			status: http.StatusCreated},
+		{name: "bind", method: "PUT", path: bindingPath,
+			body:   fmt.Sprintf(`{%s,"app_guid":"demo-app"}`, ids),
+			status: http.StatusCreated},
+		{name: "fetch-binding", method: "GET", path: bindingPath, status: http.StatusOK},
+		{name: "unbind", method: "DELETE", path: bindingPath + query, status: http.StatusOK},
+		{name: "deprovision", method: "DELETE", path: instancePath + query, status: http.StatusOK},
+	}
+	for _, step := range steps {
+		status, body, err := d.do(step)
+		if err == nil && status != step.status {
+			err = fmt.Errorf("expected status %d, got %d", step.status, status)
+		}
+		if err != nil {
+			logger.Error("demo-step-failed", err, lager.Data{"step": step.name, "response_body": body})
+			return
+		}
+		logger.Info("demo-step", lager.Data{"step": step.name, "status": status, "response_body": body})
+	}
+	logger.Info("demo-complete", lager.Data{"broker": d.brokerAddr})
+}
+
+func (d *demoRunner) do(step demoStep) (int, string, error) {
+	req, err := http.NewRequest(step.method, "http://"+d.brokerAddr+step.path, strings.NewReader(step.body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.SetBasicAuth("demo", "demo")
+	req.Header.Set("X-Broker-API-Version", demoAPIVersion)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: demoRequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), err
+}
+
+// demoBrokerAddr is the address the walkthrough reaches the broker at, given the address it listens on.
+func demoBrokerAddr(listenAddr string) string {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return listenAddr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// DevServerCommand runs the broker as a local development sandbox: state is kept in memory, any credentials are
+// accepted, and every request and response is logged along with a report on the volume mounts in bind responses.
+const DevServerCommand = "devserver"
+
+var devServer bool
+
+var bindingPath = regexp.MustCompile(`^/v2/service_instances/[^/]+/service_bindings/[^/]+$`)
+
+// devServerHandler logs each request with its response, and accepts requests whatever their credentials by replacing
+// them with the broker's own.
+func devServerHandler(logger lager.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestBody, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+		r.SetBasicAuth(username, password)
+
+		recorder := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		logger.Info("request", lager.Data{
+			"method":        r.Method,
+			"url":           r.URL.String(),
+			"request_body":  string(requestBody),
+			"status":        recorder.status,
+			"response_body": recorder.body.String(),
+		})
+
+		if r.Method == "PUT" && bindingPath.MatchString(r.URL.Path) && recorder.status < 300 {
+			reportVolumeMounts(logger, recorder.body.Bytes())
+		}
+	})
+}
+
+func reportVolumeMounts(logger lager.Logger, responseBody []byte) {
+	var binding brokerapi.Binding
+	if err := json.Unmarshal(responseBody, &binding); err != nil {
+		logger.Error("volume-mount-report-failed", err)
+		return
+	}
+	for i, mount := range binding.VolumeMounts {
+		problems := nfsbroker.ValidateVolumeMount(mount)
+		logger.Info("volume-mount-report", lager.Data{
+			"volume_mount": i,
+			"driver":       mount.Driver,
+			"valid":        len(problems) == 0,
+			"problems":     problems,
+		})
+	}
+}
+
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingResponseWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
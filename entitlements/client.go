@@ -0,0 +1,69 @@
+// Package entitlements is a client for an external storage entitlement service, which decides whether an
+// organization may use a share.
+package entitlements
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Client asks the entitlement service at its URL about each share.  The service is sent a POST with a JSON body
+// holding organization_guid, server and path, and answers with a JSON body holding a boolean entitled.
+type Client struct {
+	url        string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient returns a client for the entitlement service at url.  If token is not empty it is sent as a bearer token.
+func NewClient(url, token string, httpClient *http.Client) *Client {
+	return &Client{
+		url:        url,
+		token:      token,
+		httpClient: httpClient,
+	}
+}
+
+func (c *Client) Entitled(ctx context.Context, orgGUID, server, path string) (bool, error) {
+	body, err := json.Marshal(map[string]string{
+		"organization_guid": orgGUID,
+		"server":            server,
+		"path":              path,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return false, fmt.Errorf("entitlement service returned status %d", resp.StatusCode)
+	}
+
+	var decision struct {
+		Entitled *bool `json:"entitled"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return false, fmt.Errorf("invalid entitlement service response: %w", err)
+	}
+	if decision.Entitled == nil {
+		return false, fmt.Errorf("invalid entitlement service response: no entitled field")
+	}
+	return *decision.Entitled, nil
+}
@@ -0,0 +1,66 @@
+package entitlements_test
+
+import (
+	"context"
+	"net/http"
+
+	"code.cloudfoundry.org/nfsbroker/entitlements"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		server *ghttp.Server
+		client *entitlements.Client
+		ctx    context.Context
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		client = entitlements.NewClient(server.URL()+"/entitlements", "some-token", http.DefaultClient)
+		ctx = context.TODO()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("asks the service about the organization and share", func() {
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/entitlements"),
+				ghttp.VerifyHeaderKV("Authorization", "bearer some-token"),
+				ghttp.VerifyJSON(`{"organization_guid":"org-guid","server":"filer","path":"/export"}`),
+				ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]bool{"entitled": true}),
+			),
+		)
+
+		entitled, err := client.Entitled(ctx, "org-guid", "filer", "/export")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entitled).To(BeTrue())
+	})
+
+	It("reports denials", func() {
+		server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]bool{"entitled": false}))
+
+		entitled, err := client.Entitled(ctx, "org-guid", "filer", "/export")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entitled).To(BeFalse())
+	})
+
+	It("fails when the service fails", func() {
+		server.AppendHandlers(ghttp.RespondWith(http.StatusInternalServerError, ""))
+
+		_, err := client.Entitled(ctx, "org-guid", "filer", "/export")
+		Expect(err).To(MatchError("entitlement service returned status 500"))
+	})
+
+	It("fails when the service gives no decision", func() {
+		server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]string{}))
+
+		_, err := client.Entitled(ctx, "org-guid", "filer", "/export")
+		Expect(err).To(MatchError(ContainSubstring("no entitled field")))
+	})
+})
@@ -0,0 +1,13 @@
+package entitlements_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestEntitlements(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Entitlements Suite")
+}
@@ -0,0 +1,111 @@
+package fakes_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+func newBroker(store nfsbroker.Store) *nfsbroker.Broker {
+	return nfsbroker.New(lager.NewLogger("fakes-test"), "service-name", "service-id", "/fake-dir", nil, clock.NewClock(), store,
+		nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+}
+
+func provisionDetails(orgGUID string) brokerapi.ProvisionDetails {
+	parameters, _ := json.Marshal(map[string]interface{}{"share": "server:/export"})
+	return brokerapi.ProvisionDetails{ServiceID: "service-id", PlanID: "Existing", OrganizationGUID: orgGUID, RawParameters: parameters}
+}
+
+func TestStoreKeepsRecordsAndChanges(t *testing.T) {
+	ctx := nfsbroker.WithRequestIdentity(context.Background(), "admin", "cloudfoundry eyJ1c2VyX2lkIjoiNjgzZWE3NDgifQ==")
+	store := fakes.NewStore()
+	broker := newBroker(store)
+
+	if _, err := broker.Provision(ctx, "instance-1", provisionDetails("org-1"), false); err != nil {
+		t.Fatalf("provision failed: %s", err)
+	}
+	if _, err := broker.Bind(ctx, "instance-1", "binding-1", brokerapi.BindDetails{AppGUID: "app-1", ServiceID: "service-id", PlanID: "Existing"}); err != nil {
+		t.Fatalf("bind failed: %s", err)
+	}
+
+	if count, _ := store.CountBindings(ctx); count != 1 {
+		t.Errorf("expected 1 binding, got %d", count)
+	}
+	if store.Calls("CreateInstanceDetails") != 1 || store.Calls("CreateBindingDetails") != 1 {
+		t.Errorf("expected one create of each record, got %d and %d", store.Calls("CreateInstanceDetails"), store.Calls("CreateBindingDetails"))
+	}
+
+	page, err := broker.Changes(ctx, 1, 10)
+	if err != nil {
+		t.Fatalf("listing changes failed: %s", err)
+	}
+	if len(page.Changes) != 1 || page.Changes[0].RecordID != "binding-1" || page.Next != 2 {
+		t.Errorf("expected the binding's creation after the first change, got %+v", page)
+	}
+	entries, _ := store.ListChanges(ctx, 0, 1)
+	if len(entries) != 1 || entries[0].OriginatingUser != "683ea748" {
+		t.Errorf("expected the first change to be attributed to the originating user, got %+v", entries)
+	}
+}
+
+func TestStoreFail(t *testing.T) {
+	ctx := context.Background()
+	store := fakes.NewStore()
+	broker := newBroker(store)
+
+	store.Fail("CreateInstanceDetails", errors.New("disk full"))
+	if _, err := broker.Provision(ctx, "instance-1", provisionDetails("org-1"), false); err == nil {
+		t.Fatal("expected provision to fail")
+	}
+	if count, _ := store.CountInstances(ctx); count != 0 {
+		t.Errorf("expected the failed create to store nothing, got %d instances", count)
+	}
+
+	store.Fail("CreateInstanceDetails", nil)
+	if _, err := broker.Provision(ctx, "instance-1", provisionDetails("org-1"), false); err != nil {
+		t.Fatalf("expected provision to succeed once the failure is cleared: %s", err)
+	}
+}
+
+func TestEntitlementChecker(t *testing.T) {
+	ctx := context.Background()
+	checker := &fakes.EntitlementChecker{Denied: map[string]bool{"org-2": true}}
+	broker := newBroker(fakes.NewStore())
+	broker.SetEntitlementChecker(checker, false)
+
+	if _, err := broker.Provision(ctx, "instance-1", provisionDetails("org-1"), false); err != nil {
+		t.Errorf("expected org-1 to be entitled: %s", err)
+	}
+	if _, err := broker.Provision(ctx, "instance-2", provisionDetails("org-2"), false); err == nil {
+		t.Error("expected org-2 to be refused")
+	}
+
+	checks := checker.Checks()
+	if len(checks) != 2 || checks[1] != (fakes.EntitlementCheck{OrgGUID: "org-2", Server: "server", Path: "/export"}) {
+		t.Errorf("expected both provisions to be checked, got %+v", checks)
+	}
+
+	checker.Err = errors.New("entitlement service down")
+	if entitled, err := checker.Entitled(ctx, "org-1", "server", "/export"); entitled || err == nil {
+		t.Error("expected checks to fail once Err is set")
+	}
+}
+
+func TestNameLookup(t *testing.T) {
+	ctx := context.Background()
+	lookup := &fakes.NameLookup{Organizations: map[string]string{"org-1": "research"}}
+
+	if name, err := lookup.OrganizationName(ctx, "org-1"); err != nil || name != "research" {
+		t.Errorf("expected research, got %q (%v)", name, err)
+	}
+	if _, err := lookup.SpaceName(ctx, "space-1"); err == nil {
+		t.Error("expected unknown spaces to fail")
+	}
+}
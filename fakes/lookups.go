@@ -0,0 +1,73 @@
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+)
+
+var (
+	_ nfsbroker.EntitlementChecker = (*EntitlementChecker)(nil)
+	_ nfsbroker.NameLookup         = (*NameLookup)(nil)
+)
+
+// EntitlementCheck is the arguments of a call to EntitlementChecker.Entitled.
+type EntitlementCheck struct {
+	OrgGUID string
+	Server  string
+	Path    string
+}
+
+// EntitlementChecker entitles every organization to every share unless Denied lists the organization, or Err is
+// set.  It is safe for concurrent use once configured.
+type EntitlementChecker struct {
+	// Denied lists the GUIDs of organizations that are not entitled to any share.
+	Denied map[string]bool
+
+	// Err is returned by every check when set, as if the entitlement service could not be reached.
+	Err error
+
+	mutex  sync.Mutex
+	checks []EntitlementCheck
+}
+
+func (c *EntitlementChecker) Entitled(ctx context.Context, orgGUID, server, path string) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.checks = append(c.checks, EntitlementCheck{OrgGUID: orgGUID, Server: server, Path: path})
+	if c.Err != nil {
+		return false, c.Err
+	}
+	return !c.Denied[orgGUID], nil
+}
+
+// Checks returns the checks made so far, in order.
+func (c *EntitlementChecker) Checks() []EntitlementCheck {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]EntitlementCheck{}, c.checks...)
+}
+
+// NameLookup looks organization and space names up in maps keyed by GUID, and fails for GUIDs that are not in them.
+type NameLookup struct {
+	Organizations map[string]string
+	Spaces        map[string]string
+}
+
+func (l *NameLookup) OrganizationName(ctx context.Context, guid string) (string, error) {
+	name, ok := l.Organizations[guid]
+	if !ok {
+		return "", fmt.Errorf("organization %s not found", guid)
+	}
+	return name, nil
+}
+
+func (l *NameLookup) SpaceName(ctx context.Context, guid string) (string, error) {
+	name, ok := l.Spaces[guid]
+	if !ok {
+		return "", fmt.Errorf("space %s not found", guid)
+	}
+	return name, nil
+}
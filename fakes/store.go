@@ -0,0 +1,344 @@
+// Package fakes provides hand-written test doubles for the broker's extension points, for tests written with the
+// standard testing package.  Unlike the generated fakes in nfsbrokerfakes, they behave like the real thing by default
+// and only need configuring for the behaviour a test is about.
+package fakes
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var (
+	_ nfsbroker.Store      = (*Store)(nil)
+	_ nfsbroker.ChangeFeed = (*Store)(nil)
+)
+
+// Store is an nfsbroker.Store that keeps records in memory.  It counts calls to each method, can be made to fail
+// any method that returns an error, and serves its mutations as a change feed.  It is safe for concurrent use.
+type Store struct {
+	mutex   sync.Mutex
+	records nfsbroker.Store
+	calls   map[string]int
+	errors  map[string]error
+	changes []nfsbroker.AuditEntry
+}
+
+func NewStore() *Store {
+	return &Store{
+		records: nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize),
+		calls:   map[string]int{},
+		errors:  map[string]error{},
+	}
+}
+
+// Fail makes calls to the named method, such as "CreateInstanceDetails", return err without touching the stored
+// records.  A nil err makes the method succeed again.
+func (s *Store) Fail(method string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err == nil {
+		delete(s.errors, method)
+		return
+	}
+	s.errors[method] = err
+}
+
+// Calls returns how many times the named method has been called.
+func (s *Store) Calls(method string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.calls[method]
+}
+
+// call counts a call to method and returns the error it was configured to fail with.  The caller must hold the mutex.
+func (s *Store) call(method string) error {
+	s.calls[method]++
+	return s.errors[method]
+}
+
+// change records a mutation for the change feed.  The caller must hold the mutex.
+func (s *Store) change(ctx context.Context, action, recordType, recordID string) {
+	s.changes = append(s.changes, nfsbroker.AuditEntry{
+		Sequence:            int64(len(s.changes) + 1),
+		OccurredAt:          time.Now().UTC().Format(time.RFC3339Nano),
+		Actor:               nfsbroker.RequestActor(ctx),
+		OriginatingIdentity: nfsbroker.OriginatingIdentity(ctx),
+		OriginatingUser:     nfsbroker.OriginatingUser(ctx),
+		Action:              action,
+		RecordType:          recordType,
+		RecordID:            recordID,
+	})
+}
+
+func (s *Store) RetrieveInstanceDetails(ctx context.Context, id string) (nfsbroker.ServiceInstance, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("RetrieveInstanceDetails"); err != nil {
+		return nfsbroker.ServiceInstance{}, err
+	}
+	return s.records.RetrieveInstanceDetails(ctx, id)
+}
+
+func (s *Store) RetrieveBindingDetails(ctx context.Context, id string) (brokerapi.BindDetails, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("RetrieveBindingDetails"); err != nil {
+		return brokerapi.BindDetails{}, err
+	}
+	return s.records.RetrieveBindingDetails(ctx, id)
+}
+
+func (s *Store) CreateInstanceDetails(ctx context.Context, id string, details nfsbroker.ServiceInstance) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("CreateInstanceDetails"); err != nil {
+		return err
+	}
+	if err := s.records.CreateInstanceDetails(ctx, id, details); err != nil {
+		return err
+	}
+	s.change(ctx, nfsbroker.AuditActionCreate, nfsbroker.AuditRecordInstance, id)
+	return nil
+}
+
+func (s *Store) CreateBindingDetails(ctx context.Context, instanceID, id string, details brokerapi.BindDetails) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("CreateBindingDetails"); err != nil {
+		return err
+	}
+	if err := s.records.CreateBindingDetails(ctx, instanceID, id, details); err != nil {
+		return err
+	}
+	s.change(ctx, nfsbroker.AuditActionCreate, nfsbroker.AuditRecordBinding, id)
+	return nil
+}
+
+func (s *Store) UpdateInstanceDetails(ctx context.Context, id string, details nfsbroker.ServiceInstance) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("UpdateInstanceDetails"); err != nil {
+		return err
+	}
+	if err := s.records.UpdateInstanceDetails(ctx, id, details); err != nil {
+		return err
+	}
+	s.change(ctx, nfsbroker.AuditActionUpdate, nfsbroker.AuditRecordInstance, id)
+	return nil
+}
+
+func (s *Store) DeleteInstanceDetails(ctx context.Context, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("DeleteInstanceDetails"); err != nil {
+		return err
+	}
+	if err := s.records.DeleteInstanceDetails(ctx, id); err != nil {
+		return err
+	}
+	s.change(ctx, nfsbroker.AuditActionDelete, nfsbroker.AuditRecordInstance, id)
+	return nil
+}
+
+func (s *Store) DeleteBindingDetails(ctx context.Context, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("DeleteBindingDetails"); err != nil {
+		return err
+	}
+	if err := s.records.DeleteBindingDetails(ctx, id); err != nil {
+		return err
+	}
+	s.change(ctx, nfsbroker.AuditActionDelete, nfsbroker.AuditRecordBinding, id)
+	return nil
+}
+
+func (s *Store) ListInstanceDetails(ctx context.Context, opts nfsbroker.ListOptions) (map[string]nfsbroker.ServiceInstance, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("ListInstanceDetails"); err != nil {
+		return nil, err
+	}
+	return s.records.ListInstanceDetails(ctx, opts)
+}
+
+func (s *Store) ListBindingDetails(ctx context.Context, opts nfsbroker.ListOptions) (map[string]brokerapi.BindDetails, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("ListBindingDetails"); err != nil {
+		return nil, err
+	}
+	return s.records.ListBindingDetails(ctx, opts)
+}
+
+func (s *Store) ListBindingInstances(ctx context.Context) (map[string]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("ListBindingInstances"); err != nil {
+		return nil, err
+	}
+	return s.records.ListBindingInstances(ctx)
+}
+
+func (s *Store) CountInstances(ctx context.Context) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("CountInstances"); err != nil {
+		return 0, err
+	}
+	return s.records.CountInstances(ctx)
+}
+
+func (s *Store) CountBindings(ctx context.Context) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("CountBindings"); err != nil {
+		return 0, err
+	}
+	return s.records.CountBindings(ctx)
+}
+
+func (s *Store) RetrieveJobNextRun(ctx context.Context, name string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("RetrieveJobNextRun"); err != nil {
+		return time.Time{}, err
+	}
+	return s.records.RetrieveJobNextRun(ctx, name)
+}
+
+func (s *Store) SaveJobNextRun(ctx context.Context, name string, next time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("SaveJobNextRun"); err != nil {
+		return err
+	}
+	return s.records.SaveJobNextRun(ctx, name, next)
+}
+
+func (s *Store) RetrieveOperation(ctx context.Context, instanceID string) (nfsbroker.Operation, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("RetrieveOperation"); err != nil {
+		return nfsbroker.Operation{}, err
+	}
+	return s.records.RetrieveOperation(ctx, instanceID)
+}
+
+func (s *Store) SaveOperation(ctx context.Context, instanceID string, operation nfsbroker.Operation) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("SaveOperation"); err != nil {
+		return err
+	}
+	return s.records.SaveOperation(ctx, instanceID, operation)
+}
+
+func (s *Store) ListRetiredPlans(ctx context.Context) (map[string]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("ListRetiredPlans"); err != nil {
+		return nil, err
+	}
+	return s.records.ListRetiredPlans(ctx)
+}
+
+func (s *Store) RetirePlan(ctx context.Context, planID, hint string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("RetirePlan"); err != nil {
+		return err
+	}
+	return s.records.RetirePlan(ctx, planID, hint)
+}
+
+func (s *Store) ReinstatePlan(ctx context.Context, planID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("ReinstatePlan"); err != nil {
+		return err
+	}
+	return s.records.ReinstatePlan(ctx, planID)
+}
+
+func (s *Store) ListShareReservations(ctx context.Context) (map[string]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("ListShareReservations"); err != nil {
+		return nil, err
+	}
+	return s.records.ListShareReservations(ctx)
+}
+
+func (s *Store) ReserveShares(ctx context.Context, prefix, orgGUID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("ReserveShares"); err != nil {
+		return err
+	}
+	return s.records.ReserveShares(ctx, prefix, orgGUID)
+}
+
+func (s *Store) ReleaseShares(ctx context.Context, prefix string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("ReleaseShares"); err != nil {
+		return err
+	}
+	return s.records.ReleaseShares(ctx, prefix)
+}
+
+func (s *Store) IsInstanceConflict(ctx context.Context, id string, details nfsbroker.ServiceInstance) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.call("IsInstanceConflict")
+	return s.records.IsInstanceConflict(ctx, id, details)
+}
+
+func (s *Store) IsBindingConflict(ctx context.Context, id string, details brokerapi.BindDetails) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.call("IsBindingConflict")
+	return s.records.IsBindingConflict(ctx, id, details)
+}
+
+func (s *Store) Restore(logger lager.Logger) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.call("Restore")
+}
+
+func (s *Store) Save(logger lager.Logger) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.call("Save")
+}
+
+func (s *Store) Cleanup() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.call("Cleanup")
+}
+
+// ListChanges returns up to limit of the store's mutations numbered after since.  Failing "ListChanges" makes the
+// store look like one without a change feed when the error is nfsbroker.ErrNoChangeFeed.
+func (s *Store) ListChanges(ctx context.Context, since int64, limit int) ([]nfsbroker.AuditEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.call("ListChanges"); err != nil {
+		return nil, err
+	}
+
+	entries := []nfsbroker.AuditEntry{}
+	for _, entry := range s.changes {
+		if entry.Sequence > since && len(entries) < limit {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
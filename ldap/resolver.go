@@ -0,0 +1,136 @@
+// Package ldap resolves the usernames and passwords given to bindings into the uid and gid of the user in an LDAP
+// directory, as the NFS volume driver does for its experimental LDAP mounts.
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+// Config describes the directory users are looked up in.
+type Config struct {
+	Host string
+	Port int
+
+	// UserFQDN is the base DN users are searched for under, such as "ou=Users,dc=corp,dc=test,dc=com".
+	UserFQDN string
+
+	// ServiceUser and ServicePassword are the credentials the resolver searches the directory with.
+	ServiceUser     string
+	ServicePassword string
+
+	// CACert is a PEM encoded certificate authority for the directory.  When given, the resolver connects with TLS.
+	CACert []byte
+
+	Timeout time.Duration
+}
+
+// Conn is the part of an LDAP connection the resolver uses.
+type Conn interface {
+	Bind(username, password string) error
+	Search(request *goldap.SearchRequest) (*goldap.SearchResult, error)
+	Close()
+}
+
+// Resolver looks users up by common name, checks their passwords by binding as them, and returns their uidNumber and
+// gidNumber.
+type Resolver struct {
+	config Config
+	dial   func() (Conn, error)
+}
+
+// NewResolver returns a resolver that connects to the directory described by config for each lookup.
+func NewResolver(config Config) (*Resolver, error) {
+	var tlsConfig *tls.Config
+	if len(config.CACert) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(config.CACert) {
+			return nil, errors.New("the LDAP CA certificate is not a PEM encoded certificate")
+		}
+		tlsConfig = &tls.Config{RootCAs: pool, ServerName: config.Host}
+	}
+
+	return NewResolverWithDial(config, func() (Conn, error) {
+		dialer := &net.Dialer{Timeout: config.Timeout}
+		address := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+		var (
+			conn *goldap.Conn
+			err  error
+		)
+		if tlsConfig != nil {
+			conn, err = goldap.DialURL("ldaps://"+address, goldap.DialWithDialer(dialer), goldap.DialWithTLSConfig(tlsConfig))
+		} else {
+			conn, err = goldap.DialURL("ldap://"+address, goldap.DialWithDialer(dialer))
+		}
+		if err != nil {
+			return nil, err
+		}
+		conn.SetTimeout(config.Timeout)
+		return conn, nil
+	}), nil
+}
+
+// NewResolverWithDial returns a resolver that opens its connections with dial.
+func NewResolverWithDial(config Config, dial func() (Conn, error)) *Resolver {
+	return &Resolver{config: config, dial: dial}
+}
+
+func (r *Resolver) Resolve(ctx context.Context, username, password string) (string, string, error) {
+	// Directories treat binds without a password as anonymous binds, which succeed.
+	if password == "" {
+		return "", "", nfsbroker.ErrInvalidUserCredentials
+	}
+
+	conn, err := r.dial()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to connect to LDAP: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.Bind(r.config.ServiceUser, r.config.ServicePassword); err != nil {
+		return "", "", fmt.Errorf("failed to bind as the LDAP service user: %w", err)
+	}
+
+	request := goldap.NewSearchRequest(
+		r.config.UserFQDN,
+		goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 0, int(r.config.Timeout/time.Second), false,
+		fmt.Sprintf("(&(objectClass=User)(cn=%s))", goldap.EscapeFilter(username)),
+		[]string{"dn", "uidNumber", "gidNumber"},
+		nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to search LDAP for %s: %w", username, err)
+	}
+	if len(result.Entries) == 0 {
+		return "", "", nfsbroker.ErrInvalidUserCredentials
+	}
+	if len(result.Entries) > 1 {
+		return "", "", fmt.Errorf("LDAP has %d users named %s", len(result.Entries), username)
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+			return "", "", nfsbroker.ErrInvalidUserCredentials
+		}
+		return "", "", fmt.Errorf("failed to bind as %s: %w", username, err)
+	}
+
+	uid, gid := entry.GetAttributeValue("uidNumber"), entry.GetAttributeValue("gidNumber")
+	if uid == "" || gid == "" {
+		return "", "", fmt.Errorf("LDAP user %s has no uidNumber or gidNumber", username)
+	}
+	return uid, gid, nil
+}
+
+var _ nfsbroker.IDResolver = &Resolver{}
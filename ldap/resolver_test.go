@@ -0,0 +1,111 @@
+package ldap_test
+
+import (
+	"context"
+	"errors"
+
+	"code.cloudfoundry.org/nfsbroker/ldap"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	goldap "github.com/go-ldap/ldap/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeConn struct {
+	binds    [][2]string
+	bindErrs map[string]error
+	requests []*goldap.SearchRequest
+	entries  []*goldap.Entry
+	closed   bool
+}
+
+func (c *fakeConn) Bind(username, password string) error {
+	c.binds = append(c.binds, [2]string{username, password})
+	return c.bindErrs[username]
+}
+
+func (c *fakeConn) Search(request *goldap.SearchRequest) (*goldap.SearchResult, error) {
+	c.requests = append(c.requests, request)
+	return &goldap.SearchResult{Entries: c.entries}, nil
+}
+
+func (c *fakeConn) Close() {
+	c.closed = true
+}
+
+var _ = Describe("Resolver", func() {
+	var (
+		conn     *fakeConn
+		resolver *ldap.Resolver
+		ctx      context.Context
+	)
+
+	BeforeEach(func() {
+		conn = &fakeConn{
+			bindErrs: map[string]error{},
+			entries: []*goldap.Entry{goldap.NewEntry("cn=user1,ou=Users,dc=corp", map[string][]string{
+				"uidNumber": {"1001"},
+				"gidNumber": {"2001"},
+			})},
+		}
+		resolver = ldap.NewResolverWithDial(ldap.Config{
+			UserFQDN:        "ou=Users,dc=corp",
+			ServiceUser:     "cn=svc,dc=corp",
+			ServicePassword: "svc-password",
+		}, func() (ldap.Conn, error) { return conn, nil })
+		ctx = context.Background()
+	})
+
+	It("looks the user up as the service user and checks their password", func() {
+		uid, gid, err := resolver.Resolve(ctx, "user1", "secret")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(uid).To(Equal("1001"))
+		Expect(gid).To(Equal("2001"))
+
+		Expect(conn.binds).To(Equal([][2]string{{"cn=svc,dc=corp", "svc-password"}, {"cn=user1,ou=Users,dc=corp", "secret"}}))
+		Expect(conn.requests).To(HaveLen(1))
+		Expect(conn.requests[0].BaseDN).To(Equal("ou=Users,dc=corp"))
+		Expect(conn.requests[0].Filter).To(Equal("(&(objectClass=User)(cn=user1))"))
+		Expect(conn.closed).To(BeTrue())
+	})
+
+	It("escapes usernames in the search filter", func() {
+		resolver.Resolve(ctx, "user*)(cn=admin", "secret")
+		Expect(conn.requests[0].Filter).To(Equal(`(&(objectClass=User)(cn=user\2a\29\28cn=admin))`))
+	})
+
+	It("refuses wrong passwords", func() {
+		conn.bindErrs["cn=user1,ou=Users,dc=corp"] = goldap.NewError(goldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+		_, _, err := resolver.Resolve(ctx, "user1", "wrong")
+		Expect(err).To(Equal(nfsbroker.ErrInvalidUserCredentials))
+	})
+
+	It("refuses empty passwords without binding", func() {
+		_, _, err := resolver.Resolve(ctx, "user1", "")
+		Expect(err).To(Equal(nfsbroker.ErrInvalidUserCredentials))
+		Expect(conn.binds).To(BeEmpty())
+	})
+
+	It("refuses unknown users", func() {
+		conn.entries = nil
+		_, _, err := resolver.Resolve(ctx, "nobody", "secret")
+		Expect(err).To(Equal(nfsbroker.ErrInvalidUserCredentials))
+	})
+
+	It("fails when the service user cannot bind", func() {
+		conn.bindErrs["cn=svc,dc=corp"] = errors.New("connection reset")
+		_, _, err := resolver.Resolve(ctx, "user1", "secret")
+		Expect(err).To(MatchError(ContainSubstring("failed to bind as the LDAP service user")))
+	})
+
+	It("fails for users without IDs", func() {
+		conn.entries = []*goldap.Entry{goldap.NewEntry("cn=user1,ou=Users,dc=corp", map[string][]string{})}
+		_, _, err := resolver.Resolve(ctx, "user1", "secret")
+		Expect(err).To(MatchError("LDAP user user1 has no uidNumber or gidNumber"))
+	})
+
+	It("rejects CA certificates that are not PEM", func() {
+		_, err := ldap.NewResolver(ldap.Config{CACert: []byte("not a certificate")})
+		Expect(err).To(HaveOccurred())
+	})
+})
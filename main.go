@@ -1,28 +1,64 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"expvar"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log/syslog"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"time"
 
 	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/debugserver"
 	"code.cloudfoundry.org/goshims/osshim"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagerflags"
+	"code.cloudfoundry.org/nfsbroker/backoff"
+	"code.cloudfoundry.org/nfsbroker/cfapi"
+	"code.cloudfoundry.org/nfsbroker/credhub"
+	"code.cloudfoundry.org/nfsbroker/entitlements"
+	"code.cloudfoundry.org/nfsbroker/ldap"
 	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfscheck"
+	"code.cloudfoundry.org/nfsbroker/provisioner"
+	"code.cloudfoundry.org/nfsbroker/scheduler"
+	"code.cloudfoundry.org/nfsbroker/uaa"
 	"code.cloudfoundry.org/nfsbroker/utils"
+	"code.cloudfoundry.org/nfsbroker/validity"
+	"code.cloudfoundry.org/nfsbroker/webhook"
 
 	"path/filepath"
+	"strings"
+	"syscall"
 
 	"encoding/json"
 	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
 	"github.com/lib/pq"
 	"github.com/pivotal-cf/brokerapi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/tedsuo/ifrit"
 	"github.com/tedsuo/ifrit/grouper"
 	"github.com/tedsuo/ifrit/http_server"
+	"github.com/tedsuo/ifrit/sigmon"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/zipkin"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var dataDir = flag.String(
@@ -31,12 +67,152 @@ var dataDir = flag.String(
 	"[REQUIRED] - Broker's state will be stored here to persist across reboots",
 )
 
+var bindingParameterKeyFile = flag.String(
+	"bindingParameterKeyFile",
+	"",
+	"(optional) path to a file of keys, one per line, that binding parameters are encrypted with when bindings are stored, in place of hashing them, so that fetched bindings are mounted with their secrets. The first key encrypts parameters; the others only decrypt them. Keys can instead be given in BINDING_PARAMETER_KEY, with older keys in BINDING_PARAMETER_KEY_2 and so on",
+)
+
+var bcryptCost = flag.Int(
+	"bcryptCost",
+	bcrypt.DefaultCost,
+	fmt.Sprintf("(optional) bcrypt cost binding parameters are hashed with when bindings are stored, between %d and %d. Each step down halves the time each bind spends hashing", nfsbroker.MinBcryptCost, nfsbroker.MaxBcryptCost),
+)
+
+var stateEncryptionKeyFile = flag.String(
+	"stateEncryptionKeyFile",
+	"",
+	"(optional) path to a file of keys, one per line, that the state file in dataDir is encrypted with. The first key encrypts the state; the others only decrypt it, so that keys can be rotated. Keys can instead be given in STATE_ENCRYPTION_KEY, with older keys in STATE_ENCRYPTION_KEY_2 and so on",
+)
+
 var atAddress = flag.String(
 	"listenAddr",
 	"0.0.0.0:8999",
 	"host:port to serve service broker API",
 )
 
+var drainTimeout = flag.Duration(
+	"drainTimeout",
+	nfsbroker.DefaultDrainTimeout,
+	"(optional) how long the broker waits on SIGTERM or SIGINT for in-flight requests and asynchronous operations to finish before saving its store and exiting",
+)
+
+var tlsCertFile = flag.String(
+	"tlsCertFile",
+	"",
+	"(optional) path to a PEM certificate, with any intermediates, to serve the broker API over HTTPS with. Requires tlsKeyFile",
+)
+var tlsKeyFile = flag.String(
+	"tlsKeyFile",
+	"",
+	"(optional) path to the PEM private key of tlsCertFile",
+)
+var tlsClientCAFile = flag.String(
+	"tlsClientCAFile",
+	"",
+	"(optional) path to a PEM bundle of the CAs that issue client certificates. When set, clients must present a certificate one of them issued. Requires tlsCertFile",
+)
+var tlsClientNames = flag.String(
+	"tlsClientNames",
+	"",
+	"(optional) comma-separated common names or DNS names of the client certificates allowed, such as cloud controller's. Requires tlsClientCAFile",
+)
+var disableBasicAuth = flag.Bool(
+	"disableBasicAuth",
+	false,
+	"(optional) authenticate clients by their certificates or UAA tokens alone, without USERNAME and PASSWORD. Requires tlsClientCAFile or uaaURL",
+)
+var rateLimit = flag.Float64(
+	"rateLimit",
+	0,
+	"(optional) requests per second each client IP address may make to the broker on average, refused with 429 Too Many Requests above it. 0 disables the limit",
+)
+var rateLimitBurst = flag.Int(
+	"rateLimitBurst",
+	20,
+	"(optional) requests each client IP address, or each username, may make at once under rateLimit and credentialRateLimit",
+)
+var credentialRateLimit = flag.Float64(
+	"credentialRateLimit",
+	0,
+	"(optional) requests per second that may be made with each basic auth username on average, counting only requests that authenticate with it. 0 disables the limit",
+)
+var authFailureLimit = flag.Int(
+	"authFailureLimit",
+	0,
+	"(optional) failed authentications in a row that lock a client IP address out for authLockout, doubling with each lockout up to an hour. 0 disables lockouts",
+)
+var authLockout = flag.Duration(
+	"authLockout",
+	time.Minute,
+	"(optional) how long a client IP address is first locked out for after authFailureLimit failed authentications",
+)
+var allowedCIDRs = flag.String(
+	"allowedCIDRs",
+	"",
+	"(optional) comma separated networks, such as the Cloud Controller's subnets, that may reach the broker API, as CIDRs like 10.0.16.0/20 or single addresses. Requests from other addresses are refused with 403 Forbidden",
+)
+var usernameFile = flag.String(
+	"usernameFile",
+	"",
+	"(optional) path to a file holding the broker's username, read in place of the USERNAME environment variable and read again when it changes",
+)
+var passwordFile = flag.String(
+	"passwordFile",
+	"",
+	"(optional) path to a file holding the broker's password, read in place of the PASSWORD environment variable and read again when it changes",
+)
+var dbPasswordFile = flag.String(
+	"dbPasswordFile",
+	"",
+	"(optional) path to a file holding the database password, read in place of the DB_PASSWORD environment variable when the broker connects to the database, and again when it receives SIGHUP",
+)
+var credhubURL = flag.String(
+	"credhubURL",
+	"",
+	"(optional) URL of a CredHub to resolve references such as ((/nfsbroker/admin.password)) in USERNAME, PASSWORD and the DB_ and STANDBY_DB_ credentials with at startup, and the DB_ and STANDBY_DB_ credentials again on SIGHUP, in place of interpolating them into the manifest",
+)
+var credhubCACert = flag.String(
+	"credhubCACert",
+	"",
+	"(optional) path to a PEM certificate authority for CredHub's certificate",
+)
+var credhubClientCert = flag.String(
+	"credhubClientCert",
+	"",
+	"(optional) path to a PEM client certificate the broker authenticates to CredHub with, such as its instance identity certificate",
+)
+var credhubClientKey = flag.String(
+	"credhubClientKey",
+	"",
+	"(optional) path to the PEM private key of credhubClientCert",
+)
+var uaaURL = flag.String(
+	"uaaURL",
+	"",
+	"(optional) base URL of a UAA, such as https://uaa.example.com, whose access tokens clients can authenticate with as bearer tokens. Requires uaaAudience or uaaScopes",
+)
+var uaaAudience = flag.String(
+	"uaaAudience",
+	"",
+	"(optional) audience UAA tokens must be for",
+)
+var uaaScopes = flag.String(
+	"uaaScopes",
+	"",
+	"(optional) comma-separated scopes UAA tokens must all grant",
+)
+var uaaCACert = flag.String(
+	"uaaCACert",
+	"",
+	"(optional) path to a PEM certificate authority for the UAA's certificate",
+)
+var clockSkewTolerance = flag.Duration(
+	"clockSkewTolerance",
+	validity.DefaultTolerance,
+	"(optional) how far the broker's clock may be from those of the services that issue tokens and client certificates before their validity periods are enforced",
+)
+
 var serviceName = flag.String(
 	"serviceName",
 	"nfsvolume",
@@ -47,6 +223,51 @@ var serviceId = flag.String(
 	"service-guid",
 	"ID of the service to register with cloud controller",
 )
+var shareType = flag.String(
+	"shareType",
+	"nfs",
+	"(optional) kind of existing filesystem offered by the broker: nfs, cephfs or smb",
+)
+var volumeDriver = flag.String(
+	"volumeDriver",
+	"",
+	"(optional) volume driver named in binding volume mounts in place of the share type's, such as a forked nfsv3driver",
+)
+var containerDir = flag.String(
+	"containerDir",
+	nfsbroker.DefaultContainerPath,
+	"(optional) directory in app containers under which shares are mounted at <containerDir>/<instance_id> unless bindings choose their own mount path",
+)
+var defaultMountMode = flag.String(
+	"defaultMountMode",
+	"rw",
+	"(optional) mode of bindings that do not set the readonly parameter: rw or r",
+)
+var subdirectoryBase = flag.String(
+	"subdirectoryBase",
+	"",
+	"(optional) export, such as server:/export, in which instances provisioned without a share get a new directory named after the instance. Requires subdirectoryMountPath",
+)
+var subdirectoryMountPath = flag.String(
+	"subdirectoryMountPath",
+	"",
+	"(optional) where subdirectoryBase is mounted on the broker's VM, for creating and removing instance directories",
+)
+var removeSubdirectories = flag.Bool(
+	"removeSubdirectories",
+	false,
+	"(optional) remove the directory the broker created for an instance, and everything in it, when the instance is deprovisioned; the same as -subdirectoryData=delete",
+)
+var subdirectoryData = flag.String(
+	"subdirectoryData",
+	"",
+	"(optional) what happens to the directory the broker created for an instance when the instance is deprovisioned: keep (the default), delete, or archive into the .archive directory of subdirectoryBase. Plans can override it with data_on_deprovision",
+)
+var shareTemplate = flag.String(
+	"shareTemplate",
+	"",
+	"(optional) share, such as server:/exports/{org}/{space}, of instances provisioned without one. {org} and {space} are filled in with organization and space names, {org_guid}, {space_guid} and {instance_id} with GUIDs. Cannot be used with subdirectoryBase",
+)
 var dbDriver = flag.String(
 	"dbDriver",
 	"",
@@ -76,6 +297,372 @@ var dbCACert = flag.String(
 	"(optional) CA Cert to verify SSL connection",
 )
 
+var standbyDataDir = flag.String(
+	"standbyDataDir",
+	"",
+	"(optional) directory holding the state of a standby store that can be promoted through the admin API",
+)
+
+var standbyDbDriver = flag.String(
+	"standbyDbDriver",
+	"",
+	"(optional) database driver name of a standby SQL store that can be promoted through the admin API. Credentials are read from STANDBY_DB_USERNAME and STANDBY_DB_PASSWORD",
+)
+
+var standbyDbHostname = flag.String(
+	"standbyDbHostname",
+	"",
+	"(optional) database hostname of the standby SQL store",
+)
+
+var standbyDbPort = flag.String(
+	"standbyDbPort",
+	"",
+	"(optional) database port of the standby SQL store",
+)
+
+var standbyDbName = flag.String(
+	"standbyDbName",
+	"",
+	"(optional) database name of the standby SQL store",
+)
+
+var standbyDbCACert = flag.String(
+	"standbyDbCACert",
+	"",
+	"(optional) CA Cert to verify SSL connection to the standby SQL store",
+)
+
+var fromDataDir = flag.String(
+	"from-dataDir",
+	"",
+	"(optional) with migrate, directory holding the state file to copy records from, instead of the store named by -dataDir or -dbDriver",
+)
+
+var toDb = flag.String(
+	"to-db",
+	"",
+	"(optional) with migrate, database driver name of the SQL store to copy records into, instead of the standby store. The database is named by -dbHostname and the other database flags, so that the broker can be pointed at it once its records are migrated",
+)
+
+var maxValueSize = flag.Int(
+	"maxValueSize",
+	nfsbroker.DefaultMaxValueSize,
+	"(optional) maximum size in bytes of a serialized service instance or binding record. When using SQL, new tables are created with value columns of this width",
+)
+
+var dbQueryTimeout = flag.Duration(
+	"dbQueryTimeout",
+	10*time.Second,
+	"(optional) maximum time to wait for a single database query before failing the broker request",
+)
+
+var dbSlowQueryThreshold = flag.Duration(
+	"dbSlowQueryThreshold",
+	nfsbroker.DefaultSlowQueryThreshold,
+	"(optional) log database statements that take longer than this, with their duration. 0 disables slow query logging",
+)
+
+var orphanedBindingCleanupInterval = flag.Duration(
+	"orphanedBindingCleanupInterval",
+	time.Hour,
+	"(optional) how often to delete bindings whose service instance no longer exists. 0 disables the periodic cleanup",
+)
+
+var leaderLockInterval = flag.Duration(
+	"leaderLockInterval",
+	10*time.Second,
+	"(optional) when using SQL, how often broker instances sharing the database compete for the lock that lets one of them run scheduled jobs",
+)
+
+var dbStatsInterval = flag.Duration(
+	"dbStatsInterval",
+	time.Minute,
+	"(optional) when using SQL, how often to log and send to -metricsAddr or -statsdHost the statistics of each store's connection pool, or 0 not to",
+)
+
+var sloProbeInterval = flag.Duration(
+	"sloProbeInterval",
+	0,
+	"(optional) how often to provision, bind, unbind and deprovision an instance of a hidden probe plan to measure the broker's end-to-end latency, reported at /admin/slo. Probe instances are stored like any other but never reach share provisioner plugins. 0 disables the probe",
+)
+
+var sloProbeObjective = flag.Duration(
+	"sloProbeObjective",
+	5*time.Second,
+	"(optional) latency within which the SLO probe's cycle is expected to finish",
+)
+
+var disabledJobs = flag.String(
+	"disabledJobs",
+	"",
+	"(optional) comma separated list of scheduled jobs to disable, e.g. orphaned-binding-cleanup",
+)
+
+var defaultShareServers = flag.String(
+	"defaultShareServers",
+	"",
+	"(optional) path to a JSON file mapping organization GUIDs or names to the NFS server used when a share is provisioned without one",
+)
+
+var configPath = flag.String(
+	"configPath",
+	"",
+	"(optional) path to a YAML or JSON file of settings keyed by flag name, such as listenAddr, dbDriver or allowedCIDRs, with lists for comma separated values. The service catalog can be given in the file as catalog, in place of -catalogPath. Flags given on the command line or as environment variables override the file's settings",
+)
+
+var catalogPath = flag.String(
+	"catalogPath",
+	"",
+	"(optional) path to a YAML or JSON service catalog describing the services and their plans, in place of -serviceName, -serviceId and -plans. Each service can name the volume driver its bindings use with driver and the kind of share it offers with share_type, so that one broker can offer shares through two drivers, or both NFS and SMB shares",
+)
+
+var plans = flag.String(
+	"plans",
+	"",
+	"(optional) path to a JSON file listing the plans offered, with their names, optional ids (derived from the service id and plan name if left out), descriptions, default and locked mount options, read-only mode, instance limits, NFS versions, credentials templates rendered into bind responses for legacy apps and data_on_deprovision, in place of the single Existing plan",
+)
+
+var uidRange = flag.String(
+	"uidRange",
+	"",
+	"(optional) range of uid bind parameters allowed, as min-max",
+)
+
+var gidRange = flag.String(
+	"gidRange",
+	"",
+	"(optional) range of gid bind parameters allowed, as min-max",
+)
+
+var requireNonRootIDs = flag.Bool(
+	"requireNonRootIDs",
+	false,
+	"(optional) refuse bindings that would mount shares as root, with a uid or gid of 0 or without a uid and gid",
+)
+
+var foundations = flag.String(
+	"foundations",
+	"",
+	"(optional) path to a JSON file listing other foundations served by the broker, each with a name, username, password and optional data_dir or db_name for its own store. Requests are routed by their credentials or the X-Broker-Foundation header, and other foundations accept only their own credentials, not UAA tokens. Background jobs only run for the default foundation",
+)
+
+var quotas = flag.String(
+	"quotas",
+	"",
+	"(optional) path to a JSON file limiting how many instances organizations, spaces and plans can have, with default_organization_limit, default_space_limit, organizations and spaces objects mapping GUIDs to their own limits, a plans object mapping plan IDs or names to limits in place of their max_instances, and max_instances capping the instances of the whole broker",
+)
+
+var maintenanceInfoVersion = flag.String(
+	"maintenanceInfoVersion",
+	"",
+	"(optional) semantic version advertised as every plan's maintenance_info. Change it when default mount behavior changes so that platforms can upgrade existing instances",
+)
+
+var maintenanceInfoDescription = flag.String(
+	"maintenanceInfoDescription",
+	"",
+	"(optional) description of what changed in maintenanceInfoVersion",
+)
+
+var minBrokerAPIVersion = flag.String(
+	"minBrokerAPIVersion",
+	nfsbroker.DefaultMinimumAPIVersion.String(),
+	"oldest service broker API version accepted. Requests without an X-Broker-API-Version header, or with an older version, are rejected with 412 Precondition Failed",
+)
+
+var dashboardUrl = flag.String(
+	"dashboardUrl",
+	"",
+	"(optional) URL of each service instance's dashboard page, in which {instance_id}, {plan_id}, {organization_guid} and {space_guid} are replaced with the instance's values",
+)
+
+var pluginsDir = flag.String(
+	"pluginsDir",
+	"",
+	"(optional) directory of share provisioner plugins, which are started with the broker and asked to create and remove the share of every instance. Provisioning is asynchronous when plugins are loaded",
+)
+
+var stateDumpPath = flag.String(
+	"stateDumpPath",
+	"",
+	"(optional) file to write a state dump to, in addition to the log, when the broker receives SIGQUIT",
+)
+
+var cfApiUrl = flag.String(
+	"cfApiUrl",
+	"",
+	"(optional) Cloud Controller URL, used to look up organization and space names, and the instances of bindings stored without one so that orphaned binding cleanup can remove them. Requires cfClientId and the CF_CLIENT_SECRET environment variable",
+)
+
+var cfNameCacheTTL = flag.Duration(
+	"cfNameCacheTTL",
+	10*time.Minute,
+	"(optional) how long organization and space names looked up through the Cloud Controller are cached",
+)
+
+var entitlementApiUrl = flag.String(
+	"entitlementApiUrl",
+	"",
+	"(optional) URL of an entitlement service asked whether an organization may use a share when it is provisioned or bound. A bearer token can be given in the ENTITLEMENT_API_TOKEN environment variable",
+)
+
+var entitlementCacheTTL = flag.Duration(
+	"entitlementCacheTTL",
+	5*time.Minute,
+	"(optional) how long entitlement decisions are cached",
+)
+
+var networkRulePorts = flag.String(
+	"networkRulePorts",
+	"",
+	"(optional) comma-separated TCP ports apps need to reach on share servers, such as 111,2049 and the port mountd listens on. When set, bind responses and /admin/network_rules include the egress rules each app needs",
+)
+
+var createEgressPolicies = flag.Bool(
+	"createEgressPolicies",
+	false,
+	"(optional) allow bound apps to reach their share servers by creating egress policies through the Cloud Foundry networking policy API. Requires networkRulePorts and cfApiUrl",
+)
+
+var ldapSvcUser = flag.String(
+	"ldapSvcUser",
+	"",
+	"(optional) LDAP service user the broker searches the directory with, to let bindings give a username and password in place of a uid and gid. The password is given in the LDAP_SVC_PASS environment variable",
+)
+
+var ldapHost = flag.String(
+	"ldapHost",
+	"",
+	"(optional) LDAP server host name",
+)
+
+var ldapPort = flag.Int(
+	"ldapPort",
+	389,
+	"(optional) LDAP server port",
+)
+
+var ldapUserFqdn = flag.String(
+	"ldapUserFqdn",
+	"",
+	"(optional) LDAP base DN users are searched for under, such as ou=Users,dc=corp,dc=test,dc=com",
+)
+
+var ldapCACert = flag.String(
+	"ldapCACert",
+	"",
+	"(optional) path to a PEM encoded CA certificate for the LDAP server. When set, the broker connects with TLS",
+)
+
+var ldapTimeout = flag.Duration(
+	"ldapTimeout",
+	10*time.Second,
+	"(optional) how long the broker waits for the LDAP server when resolving a binding's uid and gid",
+)
+
+var checkShares = flag.Bool(
+	"checkShares",
+	false,
+	"(optional) check on provision that each share's server answers, through its portmapper or on the NFS port, and exports the share. Only for the nfs share type",
+)
+
+var checkSharesTimeout = flag.Duration(
+	"checkSharesTimeout",
+	5*time.Second,
+	"(optional) how long provision waits for a share's server to answer when checkShares is set",
+)
+
+var allowExperimental = flag.Bool(
+	"allowExperimental",
+	false,
+	"(optional) let bindings ask for experimental mounts, which the nfs driver makes with the kernel's NFS client and mapfs rather than fuse-nfs, with the experimental bind parameter",
+)
+
+var entitlementFailOpen = flag.Bool(
+	"entitlementFailOpen",
+	false,
+	"(optional) allow requests when the entitlement service cannot be reached, instead of refusing them",
+)
+
+var legacyNotFound = flag.Bool(
+	"legacyNotFound",
+	false,
+	"(optional) answer unbind and deprovision requests for unknown bindings and instances with 404 Not Found, as earlier versions did, instead of 410 Gone",
+)
+
+var auditLogFile = flag.String(
+	"auditLogFile",
+	"",
+	"(optional) file to append a JSON record of every provision, update, bind, unbind and deprovision request to, with its instance, organization, space, caller, originating identity and result",
+)
+
+var auditSyslog = flag.String(
+	"auditSyslog",
+	"",
+	"(optional) syslog server to send the records of auditLogFile to, as udp://host:port or tcp://host:port, or local for the local syslog daemon. Can be used with or without auditLogFile",
+)
+
+var webhookURL = flag.String(
+	"webhookURL",
+	"",
+	"(optional) URL to POST a JSON event to whenever a provision, update, bind, unbind or deprovision finishes. Events are signed with the secret in the WEBHOOK_SECRET environment variable and retried with backoff",
+)
+
+var metricsAddr = flag.String(
+	"metricsAddr",
+	"",
+	"(optional) host:port to serve Prometheus metrics on at /metrics, apart from the broker API: operations by outcome, request latency by broker API endpoint and store call durations",
+)
+
+var statsdHost = flag.String(
+	"statsdHost",
+	"",
+	"(optional) StatsD server, such as a Datadog agent, to send the metrics of metricsAddr to over UDP",
+)
+
+var statsdPort = flag.String(
+	"statsdPort",
+	"8125",
+	"(optional) port of the StatsD server",
+)
+
+var statsdPrefix = flag.String(
+	"statsdPrefix",
+	"nfsbroker",
+	"(optional) prefix of the names of the metrics sent to the StatsD server",
+)
+
+var otlpTracesURL = flag.String(
+	"otlpTracesURL",
+	"",
+	"(optional) OTLP/HTTP collector URL to export traces of the broker's requests, store calls and share checks to, such as http://collector:4318/v1/traces. Incoming W3C trace context is continued",
+)
+
+var zipkinURL = flag.String(
+	"zipkinURL",
+	"",
+	"(optional) Zipkin collector URL to export traces to, such as http://zipkin:9411/api/v2/spans. Can be used with or without otlpTracesURL",
+)
+
+var traceSampleRatio = flag.Float64(
+	"traceSampleRatio",
+	1,
+	"(optional) fraction of the traces started by the broker that are exported, from 0 to 1. Requests whose callers sampled their traces are always exported",
+)
+
+var redactLogKeys = flag.String(
+	"redactLogKeys",
+	"",
+	"(optional) comma separated regular expressions matching further keys of log data whose values are scrubbed from the logs, besides those of passwords, secrets, tokens, keytabs and request parameters",
+)
+
+var cfClientId = flag.String(
+	"cfClientId",
+	"",
+	"(optional) UAA client used to authenticate to the Cloud Controller",
+)
+
 var cfServiceName = flag.String(
 	"cfServiceName",
 	"",
@@ -85,20 +672,37 @@ var cfServiceName = flag.String(
 var allowedOptions = flag.String(
 	"allowedOptions",
 	"auto_cache,uid,gid",
-	"A comma separated list of parameters allowed to be set in config.",
+	"A comma separated list of mount options that bind parameters and share options given at provision time are allowed to set. Others are rejected unless sloppy_mount is set",
 )
 
 var defaultOptions = flag.String(
 	"defaultOptions",
 	"auto_cache:true",
-	"A comma separated list of defaults specified as param:value. If a parameter has a default value and is not in the allowed list, this default value becomes a fixed value that cannot be overridden",
+	"A comma separated list of defaults specified as param:value. If a parameter has a default value and is not in the allowed list, this default value becomes a fixed value that cannot be overridden. The options each binding ends up with are recorded with the binding",
 )
 
 var (
-	username   string
-	password   string
-	dbUsername string
-	dbPassword string
+	username       string
+	password       string
+	dbUsername     string
+	dbPassword     string
+	cfClientSecret string
+
+	entitlementApiToken string
+	ldapSvcPassword     string
+	webhookSecret       string
+
+	standbyDbUsername string
+	standbyDbPassword string
+
+	// extraCredentials are the further usernames and passwords of USERNAME_2 and PASSWORD_2, USERNAME_3 and
+	// PASSWORD_3, and so on, accepted alongside USERNAME and PASSWORD while credentials are rotated.
+	extraCredentials []nfsbroker.BrokerCredential
+
+	// stateEncryptionKeys are the keys of STATE_ENCRYPTION_KEY, STATE_ENCRYPTION_KEY_2 and so on, and
+	// bindingParameterKeys those of BINDING_PARAMETER_KEY and so on.
+	stateEncryptionKeys  []string
+	bindingParameterKeys []string
 )
 
 func main() {
@@ -107,49 +711,149 @@ func main() {
 
 	checkParams()
 
-	sink, err := lager.NewRedactingWriterSink(os.Stdout, lager.DEBUG, nil, nil)
+	sink, err := newRedactingSink(os.Stdout, lager.DEBUG)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "\nERROR: Invalid redactLogKeys: %s\n\n", err)
+		os.Exit(1)
 	}
 	logger, logSink := lagerflags.NewFromSink("nfsbroker", sink)
 	logger.Info("starting")
 	defer logger.Info("ends")
 
-	server := createServer(logger)
+	if migrateMode {
+		runMigration(logger)
+		return
+	}
+
+	server := createServer(logger, logSink)
 
 	if dbgAddr := debugserver.DebugAddress(flag.CommandLine); dbgAddr != "" {
-		server = utils.ProcessRunnerFor(grouper.Members{
-			{"debug-server", debugserver.Runner(dbgAddr, logSink)},
+		if err := checkLoopbackAddress(dbgAddr); err != nil {
+			logger.Fatal("invalid-debug-addr", err)
+		}
+		server = grouper.NewOrdered(os.Interrupt, grouper.Members{
+			{"debug-server", http_server.New(dbgAddr, debugHandler(logSink))},
 			{"broker-api", server},
 		})
 	}
 
-	process := ifrit.Invoke(server)
+	// SIGTERM and SIGINT shut the broker down gracefully, draining requests and saving its store
+	process := ifrit.Invoke(sigmon.New(server))
 	logger.Info("started")
 	utils.UntilTerminated(logger, process)
 }
 
+// debugHandler serves the pprof profiles and log level of debugserver, along with expvar's variables at /debug/vars.
+func debugHandler(sink debugserver.ReconfigurableSinkInterface) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/", debugserver.Handler(sink))
+	return mux
+}
+
+// checkLoopbackAddress checks that address, a host:port, can only be reached from the broker's own machine, so that
+// profiles and the log level are not exposed to the network.
+func checkLoopbackAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+	return fmt.Errorf("-debugAddr %s must be on localhost or a loopback address, such as 127.0.0.1:17017", address)
+}
+
 func parseCommandLine() {
 	lagerflags.AddFlags(flag.CommandLine)
 	debugserver.AddFlags(flag.CommandLine)
-	flag.Parse()
+
+	args := os.Args[1:]
+	if len(args) > 0 && (args[0] == DevServerCommand || args[0] == DemoCommand) {
+		devServer = true
+		demoMode = args[0] == DemoCommand
+		args = args[1:]
+	} else if len(args) > 0 && args[0] == MigrateCommand {
+		migrateMode = true
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
+
+	if err := loadEnvironmentFlags(flag.CommandLine, os.LookupEnv); err != nil {
+		fmt.Fprintf(os.Stderr, "\nERROR: %s\n\n", err)
+		os.Exit(1)
+	}
+	givenFlags = setFlags(flag.CommandLine)
+	if *configPath != "" {
+		var err error
+		if catalogData, err = loadConfigFile(flag.CommandLine, *configPath, givenFlags); err != nil {
+			fmt.Fprintf(os.Stderr, "\nERROR: %s\n\n", err)
+			os.Exit(1)
+		}
+	}
 }
 
 func parseEnvironment() {
 	username, _ = os.LookupEnv("USERNAME")
 	password, _ = os.LookupEnv("PASSWORD")
+	for i := 2; ; i++ {
+		extraUsername, ok := os.LookupEnv(fmt.Sprintf("USERNAME_%d", i))
+		if !ok {
+			break
+		}
+		extraPassword, _ := os.LookupEnv(fmt.Sprintf("PASSWORD_%d", i))
+		extraCredentials = append(extraCredentials, nfsbroker.BrokerCredential{Username: extraUsername, Password: extraPassword})
+	}
 	dbUsername, _ = os.LookupEnv("DB_USERNAME")
 	dbPassword, _ = os.LookupEnv("DB_PASSWORD")
+	cfClientSecret, _ = os.LookupEnv("CF_CLIENT_SECRET")
+	entitlementApiToken, _ = os.LookupEnv("ENTITLEMENT_API_TOKEN")
+	ldapSvcPassword, _ = os.LookupEnv("LDAP_SVC_PASS")
+	webhookSecret, _ = os.LookupEnv("WEBHOOK_SECRET")
+	standbyDbUsername, _ = os.LookupEnv("STANDBY_DB_USERNAME")
+	standbyDbPassword, _ = os.LookupEnv("STANDBY_DB_PASSWORD")
+	stateEncryptionKeys = lookupKeys("STATE_ENCRYPTION_KEY")
+	bindingParameterKeys = lookupKeys("BINDING_PARAMETER_KEY")
+}
+
+// lookupKeys returns the key in the named environment variable followed by those in name_2, name_3 and so on.
+func lookupKeys(name string) []string {
+	key, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	keys := []string{key}
+	for i := 2; ; i++ {
+		key, ok := os.LookupEnv(fmt.Sprintf("%s_%d", name, i))
+		if !ok {
+			return keys
+		}
+		keys = append(keys, key)
+	}
 }
 
 func checkParams() {
-	if *dataDir == "" && *dbDriver == "" {
+	// a migration that copies the state file of -from-dataDir names its stores with the migration flags
+	migratingFromDataDir := migrateMode && *fromDataDir != ""
+	if *dataDir == "" && *dbDriver == "" && !devServer && !migratingFromDataDir {
 		fmt.Fprint(os.Stderr, "\nERROR: Either dataDir or db parameters must be provided.\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 }
 
+// newRedactingSink returns a sink that scrubs secrets and request parameters from the logs it writes, as well as the
+// values of any keys matching -redactLogKeys.
+func newRedactingSink(writer io.Writer, minLogLevel lager.LogLevel) (lager.Sink, error) {
+	keys := append([]string{}, nfsbroker.RedactedLogKeys...)
+	for _, key := range strings.Split(*redactLogKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return lager.NewRedactingWriterSink(writer, minLogLevel, keys, nfsbroker.RedactedLogValues)
+}
+
 func parseVcapServices(logger lager.Logger, os osshim.Os) {
 	if *dbDriver == "" {
 		logger.Fatal("missing-db-driver-parameter", errors.New("dbDriver parameter is required for cf deployed broker"))
@@ -186,30 +890,1069 @@ func parseVcapServices(logger lager.Logger, os osshim.Os) {
 	*dbName = credentials["name"].(string)
 }
 
-func createServer(logger lager.Logger) ifrit.Runner {
+// loadCatalog reads the service catalog of -catalogPath or the config file, if there is one, and names the broker's
+// service after its first.
+func loadCatalog(logger lager.Logger) []nfsbroker.CatalogService {
+	if *catalogPath == "" && catalogData == nil {
+		return nil
+	}
+	if *plans != "" {
+		logger.Fatal("conflicting-catalog-flags", errors.New("-plans cannot be used with -catalogPath or a catalog in -configPath"))
+	}
+	var err error
+	data := catalogData
+	if *catalogPath != "" {
+		if data, err = ioutil.ReadFile(*catalogPath); err != nil {
+			logger.Fatal("failed-to-read-catalog", err)
+		}
+	}
+	catalogServices, err := nfsbroker.ParseCatalog(data)
+	if err != nil {
+		logger.Fatal("failed-to-parse-catalog", err)
+	}
+	*serviceName = catalogServices[0].Name
+	*serviceId = catalogServices[0].ID
+	return catalogServices
+}
+
+func createServer(logger lager.Logger, logSink *lager.ReconfigurableSink) ifrit.Runner {
+	catalogServices := loadCatalog(logger)
+
 	fileName := filepath.Join(*dataDir, fmt.Sprintf("%s-services.json", *serviceName))
 
+	var credhubClient *credhub.Client
+	if *credhubURL != "" {
+		credhubClient = newCredHubClient(logger)
+		resolveCredHubReferences(logger, credhubClient)
+	}
+
 	// if we are CF pushed
 	if *cfServiceName != "" {
+		if *dbPasswordFile != "" {
+			logger.Fatal("conflicting-db-flags", errors.New("-dbPasswordFile cannot be used with -cfServiceName, which takes the password from the service binding"))
+		}
 		parseVcapServices(logger, &osshim.OsShim{})
 	}
+	dbPasswordValue := secretValue(logger, *dbPasswordFile, dbPassword)
+	dbPassword = dbPasswordValue()
 
-	store := nfsbroker.NewStore(logger, *dbDriver, dbUsername, dbPassword, *dbHostname, *dbPort, *dbName, *dbCACert, fileName)
+	brokerShareType, err := nfsbroker.LookupShareType(*shareType)
+	if err != nil {
+		logger.Fatal("invalid-share-type", err)
+	}
+	if *volumeDriver != "" {
+		// registered again so that volume mounts naming the driver are recognized as the share type's
+		brokerShareType.Driver = *volumeDriver
+		nfsbroker.RegisterShareType(brokerShareType)
+	}
+	for _, service := range catalogServices {
+		if service.Driver != "" && service.Driver != brokerShareType.Driver {
+			// registered under the service's name so that volume mounts naming its driver are recognized too
+			serviceShareType := brokerShareType
+			serviceShareType.Name = brokerShareType.Name + "/" + service.Name
+			serviceShareType.Driver = service.Driver
+			nfsbroker.RegisterShareType(serviceShareType)
+		}
+	}
+	if *checkShares && brokerShareType.Name != nfsbroker.NFSShareType.Name {
+		logger.Fatal("invalid-share-type", fmt.Errorf("checkShares only checks %s shares", nfsbroker.NFSShareType.Name))
+	}
+	volumeMountDefaults, err := nfsbroker.NewVolumeMountDefaults(*containerDir, *defaultMountMode)
+	if err != nil {
+		logger.Fatal("invalid-volume-mount-defaults", err)
+	}
+	var subdirectories *nfsbroker.Subdirectories
+	if *subdirectoryBase != "" || *subdirectoryMountPath != "" {
+		data := *subdirectoryData
+		if *removeSubdirectories {
+			if data != "" && data != nfsbroker.DataDelete {
+				logger.Fatal("conflicting-subdirectory-flags", errors.New("-removeSubdirectories cannot be used with -subdirectoryData="+data))
+			}
+			data = nfsbroker.DataDelete
+		}
+		subdirectories, err = nfsbroker.NewSubdirectories(*subdirectoryBase, *subdirectoryMountPath, data)
+		if err != nil {
+			logger.Fatal("invalid-subdirectories", err)
+		}
+	}
+	var brokerShareTemplate *nfsbroker.ShareTemplate
+	if *shareTemplate != "" {
+		if subdirectories != nil {
+			logger.Fatal("conflicting-share-flags", errors.New("-shareTemplate cannot be used with -subdirectoryBase"))
+		}
+		if brokerShareTemplate, err = nfsbroker.NewShareTemplate(*shareTemplate); err != nil {
+			logger.Fatal("invalid-share-template", err)
+		}
+	}
+
+	if err := nfsbroker.CheckBcryptCost(*bcryptCost); err != nil {
+		logger.Fatal("invalid-bcrypt-cost", err)
+	}
+	stateEncryption := newStateEncryption(logger)
+	parameterEncryption := newParameterEncryption(logger)
+
+	brokerMetrics, metricsHandler := newMetrics(logger)
+	tracerProvider := newTracerProvider(logger)
+
+	var primaryStore nfsbroker.Store
+	if devServer {
+		primaryStore = nfsbroker.NewMemoryStore(*maxValueSize)
+	} else {
+		primaryStore = nfsbroker.NewStore(logger, *dbDriver, dbUsername, dbPassword, *dbHostname, *dbPort, *dbName, *dbCACert, fileName, *maxValueSize, *dbQueryTimeout, stateEncryption, parameterEncryption, *bcryptCost)
+	}
+	store := primaryStore
+	rotations := sqlStoreRotations{}.add(primaryStore, false, *dbDriver, *dbHostname, *dbPort, *dbName, *dbCACert)
+	var dbStatsReporters grouper.Members
+	var sqlStores []io.Closer
+	instrumentSQLStore := func(name string, store nfsbroker.Store) {
+		sqlStore, ok := store.(*nfsbroker.SqlStore)
+		if !ok {
+			return
+		}
+		// closed once the broker API has shut down
+		sqlStores = append(sqlStores, sqlStore)
+		sqlStore.SlowQueryThreshold = *dbSlowQueryThreshold
+		if *dbStatsInterval > 0 {
+			reporter := sqlStore.DBStatsReporter(logger, brokerMetrics, clock.NewClock(), name, *dbStatsInterval)
+			dbStatsReporters = append(dbStatsReporters, grouper.Member{"db-stats-" + name, reporter})
+		}
+	}
+	instrumentSQLStore("primary", primaryStore)
+	if *standbyDbDriver != "" || *standbyDataDir != "" {
+		standbyFileName := filepath.Join(*standbyDataDir, fmt.Sprintf("%s-services.json", *serviceName))
+		standbyStore := nfsbroker.NewStore(logger.Session("standby-store"), *standbyDbDriver, standbyDbUsername, standbyDbPassword, *standbyDbHostname, *standbyDbPort, *standbyDbName, *standbyDbCACert, standbyFileName, *maxValueSize, *dbQueryTimeout, stateEncryption, parameterEncryption, *bcryptCost)
+		store = nfsbroker.NewSwitchableStore(primaryStore, standbyStore)
+		rotations = rotations.add(standbyStore, true, *standbyDbDriver, *standbyDbHostname, *standbyDbPort, *standbyDbName, *standbyDbCACert)
+		instrumentSQLStore("standby", standbyStore)
+	}
+	if brokerMetrics != nil {
+		store = nfsbroker.NewMetricsStore(store, brokerMetrics)
+	}
 
 	mounts := nfsbroker.NewNfsBrokerConfigDetails()
 	mounts.ReadConf(*allowedOptions, *defaultOptions)
 	logger.Debug("nfsbroker-startup-config", lager.Data{"config": mounts})
 
-	config := nfsbroker.NewNfsBrokerConfig(mounts)
+	var brokerPlans []nfsbroker.Plan
+	if *plans != "" {
+		data, err := ioutil.ReadFile(*plans)
+		if err != nil {
+			logger.Fatal("failed-to-read-plans", err)
+		}
+		brokerPlans, err = nfsbroker.ParsePlans(data)
+		if err != nil {
+			logger.Fatal("failed-to-parse-plans", err)
+		}
+	}
+	uids, gids := nfsbroker.DefaultIDRange, nfsbroker.DefaultIDRange
+	if *uidRange != "" {
+		if uids, err = nfsbroker.ParseIDRange(*uidRange); err != nil {
+			logger.Fatal("failed-to-parse-uid-range", err)
+		}
+	}
+	if *gidRange != "" {
+		if gids, err = nfsbroker.ParseIDRange(*gidRange); err != nil {
+			logger.Fatal("failed-to-parse-gid-range", err)
+		}
+	}
+	var brokerQuotas *nfsbroker.Quotas
+	if *quotas != "" {
+		data, err := ioutil.ReadFile(*quotas)
+		if err != nil {
+			logger.Fatal("failed-to-read-quotas", err)
+		}
+		parsed, err := nfsbroker.ParseQuotas(data)
+		if err != nil {
+			logger.Fatal("failed-to-parse-quotas", err)
+		}
+		brokerQuotas = &parsed
+	}
+	var dashboard nfsbroker.DashboardURL
+	if *dashboardUrl != "" {
+		if dashboard, err = nfsbroker.ParseDashboardURL(*dashboardUrl); err != nil {
+			logger.Fatal("failed-to-parse-dashboard-url", err)
+		}
+	}
 
-	serviceBroker := nfsbroker.New(logger,
-		*serviceName, *serviceId,
-		*dataDir, &osshim.OsShim{}, clock.NewClock(), store, config)
+	minAPIVersion, err := nfsbroker.ParseAPIVersion(*minBrokerAPIVersion)
+	if err != nil {
+		logger.Fatal("failed-to-parse-min-broker-api-version", err)
+	}
 
-	credentials := brokerapi.BrokerCredentials{Username: username, Password: password}
-	handler := brokerapi.New(serviceBroker, logger.Session("broker-api"), credentials)
+	var nameLookup nfsbroker.NameLookup
+	cfClient := newCFClient()
+	if cfClient != nil {
+		nameLookup = nfsbroker.NewNameCache(cfClient, clock.NewClock(), *cfNameCacheTTL)
+	}
 
-	return http_server.New(*atAddress, handler)
+	var rulePorts []int
+	var egressPolicyCreator nfsbroker.EgressPolicyCreator
+	if *networkRulePorts != "" {
+		if rulePorts, err = nfsbroker.ParseNetworkRulePorts(*networkRulePorts); err != nil {
+			logger.Fatal("failed-to-parse-network-rule-ports", err)
+		}
+	}
+	if *createEgressPolicies {
+		if rulePorts == nil || cfClient == nil {
+			logger.Fatal("invalid-egress-policy-configuration", errors.New("createEgressPolicies requires networkRulePorts and cfApiUrl"))
+		}
+		egressPolicyCreator = egressPolicies{client: cfClient}
+	}
+	var entitlementChecker nfsbroker.EntitlementChecker
+	if *entitlementApiUrl != "" {
+		entitlementClient := entitlements.NewClient(*entitlementApiUrl, entitlementApiToken, &http.Client{Timeout: 30 * time.Second})
+		entitlementChecker = nfsbroker.NewEntitlementCache(entitlementClient, clock.NewClock(), *entitlementCacheTTL)
+	}
+	var idResolver nfsbroker.IDResolver
+	if *ldapHost != "" {
+		if *ldapSvcUser == "" || ldapSvcPassword == "" || *ldapUserFqdn == "" {
+			logger.Fatal("invalid-ldap-configuration", errors.New("ldapHost requires ldapSvcUser, ldapUserFqdn and LDAP_SVC_PASS"))
+		}
+		config := ldap.Config{
+			Host:            *ldapHost,
+			Port:            *ldapPort,
+			UserFQDN:        *ldapUserFqdn,
+			ServiceUser:     *ldapSvcUser,
+			ServicePassword: ldapSvcPassword,
+			Timeout:         *ldapTimeout,
+		}
+		if *ldapCACert != "" {
+			if config.CACert, err = ioutil.ReadFile(*ldapCACert); err != nil {
+				logger.Fatal("failed-to-read-ldap-ca-cert", err)
+			}
+		}
+		if idResolver, err = ldap.NewResolver(config); err != nil {
+			logger.Fatal("invalid-ldap-configuration", err)
+		}
+	}
+
+	var provisionSteps []nfsbroker.ProvisionStep
+	var deprovisionSteps []nfsbroker.DeprovisionStep
+	if *pluginsDir != "" {
+		plugins, err := provisioner.Load(logger, *pluginsDir)
+		if err != nil {
+			logger.Fatal("failed-to-load-plugins", err)
+		}
+		provisionSteps, deprovisionSteps = pluginSteps(plugins)
+	}
+
+	var shareServers *nfsbroker.DefaultShareServers
+	if *defaultShareServers != "" {
+		data, err := ioutil.ReadFile(*defaultShareServers)
+		if err != nil {
+			logger.Fatal("failed-to-read-default-share-servers", err)
+		}
+		servers, err := nfsbroker.ParseDefaultShareServers(data)
+		if err != nil {
+			logger.Fatal("failed-to-parse-default-share-servers", err)
+		}
+		var lookup nfsbroker.OrgNameLookup
+		if nameLookup != nil {
+			lookup = nameLookup
+		}
+		shareServers = nfsbroker.NewDefaultShareServers(servers, lookup)
+	}
+
+	auditLog := newAuditLog(logger)
+
+	var webhookSender *webhook.Sender
+	if *webhookURL != "" {
+		if webhookSecret == "" {
+			logger.Fatal("missing-webhook-secret", errors.New("-webhookURL requires a WEBHOOK_SECRET to sign events with"))
+		}
+		webhookSender = webhook.NewSender(logger.Session("webhook"), *webhookURL, []byte(webhookSecret), &http.Client{Timeout: 30 * time.Second}, clock.NewClock(), backoff.DefaultPolicy)
+	}
+
+	// newBroker configures a broker for the default foundation or one of the others, which differ only in their store
+	newBroker := func(logger lager.Logger, store nfsbroker.Store) *nfsbroker.Broker {
+		serviceBroker := nfsbroker.New(logger,
+			*serviceName, *serviceId,
+			*dataDir, &osshim.OsShim{}, clock.NewClock(), store, nfsbroker.NewNfsBrokerConfig(mounts))
+		serviceBroker.SetShareType(brokerShareType)
+		serviceBroker.SetVolumeMountDefaults(volumeMountDefaults)
+		if subdirectories != nil {
+			serviceBroker.SetSubdirectories(subdirectories)
+		}
+		if brokerShareTemplate != nil {
+			serviceBroker.SetShareTemplate(brokerShareTemplate)
+		}
+		if catalogServices != nil {
+			serviceBroker.SetCatalogServices(catalogServices)
+		}
+		if brokerPlans != nil {
+			serviceBroker.SetPlans(brokerPlans)
+		}
+		serviceBroker.SetIDRanges(uids, gids)
+		serviceBroker.SetRequireNonRootIDs(*requireNonRootIDs)
+		serviceBroker.SetMinimumAPIVersion(minAPIVersion)
+		serviceBroker.SetLegacyNotFound(*legacyNotFound)
+		if *sloProbeInterval > 0 {
+			serviceBroker.SetSLOObjective(*sloProbeObjective)
+		}
+		if brokerQuotas != nil {
+			serviceBroker.SetQuotas(*brokerQuotas)
+		}
+		if dashboard != "" {
+			serviceBroker.SetDashboardURL(dashboard)
+		}
+		if *maintenanceInfoVersion != "" {
+			serviceBroker.SetMaintenanceInfo(nfsbroker.MaintenanceInfo{Version: *maintenanceInfoVersion, Description: *maintenanceInfoDescription})
+		}
+		if nameLookup != nil {
+			serviceBroker.SetNameLookup(nameLookup)
+		}
+		if entitlementChecker != nil {
+			serviceBroker.SetEntitlementChecker(entitlementChecker, *entitlementFailOpen)
+		}
+		if idResolver != nil {
+			serviceBroker.SetIDResolver(idResolver)
+		}
+		serviceBroker.SetAllowExperimental(*allowExperimental)
+		if *checkShares {
+			serviceBroker.SetShareChecker(nfscheck.NewChecker(*checkSharesTimeout))
+		}
+		if len(provisionSteps) > 0 {
+			serviceBroker.SetProvisionSteps(provisionSteps...)
+			serviceBroker.SetDeprovisionSteps(deprovisionSteps...)
+		}
+		if shareServers != nil {
+			serviceBroker.SetDefaultShareServers(shareServers)
+		}
+		if rulePorts != nil {
+			serviceBroker.SetNetworkRules(net.DefaultResolver, rulePorts, egressPolicyCreator)
+		}
+		if auditLog != nil {
+			serviceBroker.SetAuditLog(auditLog)
+		}
+		if webhookSender != nil {
+			serviceBroker.SetWebhook(webhookSender)
+		}
+		if brokerMetrics != nil {
+			serviceBroker.SetMetrics(brokerMetrics)
+		}
+		return serviceBroker
+	}
+
+	if (*tlsClientCAFile != "" || *tlsClientNames != "") && *tlsCertFile == "" {
+		logger.Fatal("invalid-tls-flags", errors.New("client certificates need -tlsCertFile and -tlsKeyFile"))
+	}
+	if *tlsClientNames != "" && *tlsClientCAFile == "" {
+		logger.Fatal("invalid-tls-flags", errors.New("-tlsClientNames requires -tlsClientCAFile"))
+	}
+	if *disableBasicAuth && *tlsClientCAFile == "" && *uaaURL == "" {
+		logger.Fatal("invalid-auth-flags", errors.New("-disableBasicAuth requires -tlsClientCAFile or -uaaURL"))
+	}
+	tokenVerifier := newTokenVerifier(logger)
+
+	for i, credential := range extraCredentials {
+		if credential.Username == "" || credential.Password == "" {
+			logger.Fatal("invalid-credentials", fmt.Errorf("USERNAME_%d and PASSWORD_%d must both be set", i+2, i+2))
+		}
+	}
+	brokerCredentials := newBrokerCredentials(logger)
+	credentials := brokerCredentials.Credentials
+
+	serviceBroker := newBroker(logger, store)
+	if cfClient != nil {
+		// other foundations' bindings are known to their own Cloud Controllers
+		serviceBroker.SetBindingInstanceLookup(cfClient)
+	}
+	var handler http.Handler = brokerHandler(logger, serviceBroker, tokenVerifier, credentials)
+	healthCheckers := []nfsbroker.HealthChecker{serviceBroker}
+	brokers := []*nfsbroker.Broker{serviceBroker}
+
+	if *foundations != "" {
+		if *disableBasicAuth {
+			logger.Fatal("invalid-tls-flags", errors.New("foundations are told apart by their credentials, which -disableBasicAuth does without"))
+		}
+		data, err := ioutil.ReadFile(*foundations)
+		if err != nil {
+			logger.Fatal("failed-to-read-foundations", err)
+		}
+		brokerFoundations, err := nfsbroker.ParseFoundations(data)
+		if err != nil {
+			logger.Fatal("failed-to-parse-foundations", err)
+		}
+
+		routes := []nfsbroker.FoundationRoute{}
+		for _, foundation := range brokerFoundations {
+			for _, credential := range credentials() {
+				if foundation.Username == credential.Username {
+					logger.Fatal("conflicting-foundation-credentials", fmt.Errorf("foundation %q uses the default foundation's username", foundation.Name))
+				}
+			}
+			foundationLogger := logger.Session("foundation", lager.Data{"foundation": foundation.Name})
+			brokerStore := foundationStore(foundationLogger, foundation, stateEncryption, parameterEncryption)
+			rotations = rotations.add(brokerStore, false, *dbDriver, *dbHostname, *dbPort, foundationDBName(foundation), *dbCACert)
+			instrumentSQLStore("foundation-"+foundation.Name, brokerStore)
+			if brokerMetrics != nil {
+				brokerStore = nfsbroker.NewMetricsStore(brokerStore, brokerMetrics)
+			}
+			foundationBroker := newBroker(foundationLogger, brokerStore)
+			healthCheckers = append(healthCheckers, foundationBroker)
+			brokers = append(brokers, foundationBroker)
+			if auditLog != nil {
+				foundationBroker.SetAuditLog(auditLog.WithData(lager.Data{"foundation": foundation.Name}))
+			}
+			routes = append(routes, nfsbroker.FoundationRoute{
+				Name:     foundation.Name,
+				Username: foundation.Username,
+				Password: foundation.Password,
+				// a UAA token is not tied to a foundation, so only the foundation's own credentials are accepted
+				Handler: brokerHandler(foundationLogger, foundationBroker, nil, staticCredentials(foundation.Username, foundation.Password)),
+			})
+		}
+		handler = nfsbroker.NewFoundationRouter(routes, handler)
+	}
+
+	handler = nfsbroker.RequestIdentityHandler(logger.Session("request-identity"), handler)
+	if *rateLimit > 0 || *credentialRateLimit > 0 || *authFailureLimit > 0 {
+		limits := nfsbroker.RateLimits{
+			ClientRate:     *rateLimit,
+			ClientBurst:    *rateLimitBurst,
+			CredentialRate: *credentialRateLimit,
+			AuthFailures:   *authFailureLimit,
+			AuthLockout:    *authLockout,
+		}
+		handler = nfsbroker.NewRateLimiter(logger.Session("rate-limiter"), clock.NewClock(), limits).Wrap(handler)
+	}
+	if brokerMetrics != nil {
+		handler = nfsbroker.MetricsHandler(brokerMetrics, handler)
+	}
+	handler = nfsbroker.RequestLogHandler(logger.Session("request-log"), handler)
+	if tracerProvider != nil {
+		handler = nfsbroker.TracingHandler(handler)
+	}
+	if devServer {
+		handler = devServerHandler(logger.Session("devserver"), handler)
+	}
+	if *allowedCIDRs != "" {
+		networks, err := nfsbroker.ParseAllowedCIDRs(*allowedCIDRs)
+		if err != nil {
+			logger.Fatal("invalid-allowed-cidrs", err)
+		}
+		handler = nfsbroker.AllowedNetworksHandler(logger.Session("allowed-networks"), networks, handler)
+	}
+	// load balancers check the broker's health from wherever they are, without credentials
+	handler = nfsbroker.HealthRoute(nfsbroker.NewHealthHandler(logger.Session("health"), healthCheckers...), handler)
+
+	disabled := map[string]bool{}
+	for _, name := range strings.Split(*disabledJobs, ",") {
+		disabled[strings.TrimSpace(name)] = true
+	}
+	var jobs []scheduler.Job
+	if *orphanedBindingCleanupInterval > 0 && !disabled[nfsbroker.OrphanedBindingCleanupJob] {
+		jobs = append(jobs, serviceBroker.OrphanedBindingCleanup(*orphanedBindingCleanupInterval))
+	}
+	if *sloProbeInterval > 0 && !disabled[nfsbroker.SLOProbeJob] {
+		jobs = append(jobs, serviceBroker.SLOProbe(*sloProbeInterval))
+	}
+
+	var tlsConfig *tls.Config
+	if *tlsCertFile != "" || *tlsKeyFile != "" {
+		if demoMode {
+			logger.Fatal("invalid-tls-flags", errors.New("the demo talks to the broker over plain HTTP; leave out -tlsCertFile and -tlsKeyFile"))
+		}
+		var clientNames []string
+		for _, name := range strings.Split(*tlsClientNames, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				clientNames = append(clientNames, name)
+			}
+		}
+		checker := validity.NewChecker(clock.NewClock(), *clockSkewTolerance, logger.Session("client-certificates"))
+		tlsConfig, err = serverTLSConfig(*tlsCertFile, *tlsKeyFile, *tlsClientCAFile, clientNames, checker)
+		if err != nil {
+			logger.Fatal("invalid-tls-flags", err)
+		}
+	}
+
+	apiServer := nfsbroker.NewAPIServer(logger, *atAddress, handler, tlsConfig, *drainTimeout, brokers, sqlStores...)
+
+	jobScheduler := scheduler.New(logger.Session("scheduler"), clock.NewClock(), serviceBroker, jobs)
+	members := grouper.Members{
+		{"broker-api", apiServer},
+		{"state-dump", nfsbroker.NewStateDumper(logger, serviceBroker, *stateDumpPath, syscall.SIGQUIT)},
+	}
+	if sqlStore, ok := primaryStore.(*nfsbroker.SqlStore); ok {
+		if leaderLock := sqlStore.LeaderLock(logger, clock.NewClock(), nfsbroker.LeaderLockName, *leaderLockInterval); leaderLock != nil {
+			jobScheduler.RequireLeadership(leaderLock)
+			members = append(members, grouper.Member{"leader-lock", leaderLock})
+		}
+	}
+	members = append(members, grouper.Member{"scheduler", jobScheduler})
+	members = append(members, dbStatsReporters...)
+	if metricsHandler != nil {
+		members = append(members, grouper.Member{"metrics-server", http_server.New(*metricsAddr, metricsHandler)})
+	}
+	if len(rotations) > 0 && *cfServiceName == "" {
+		members = append(members, grouper.Member{"credential-rotator", nfsbroker.NewCredentialRotator(logger, rotations.rotate(credhubClient, dbPasswordValue), syscall.SIGHUP)})
+	}
+	members = append(members, grouper.Member{"settings-reloader", &settingsReloader{
+		logger:        logger,
+		logSink:       logSink,
+		brokers:       brokers,
+		credentials:   brokerCredentials,
+		credhubClient: credhubClient,
+	}})
+	if webhookSender != nil {
+		// before the broker API, so that it is stopped only once the API has drained and the events of its operations
+		// are queued
+		members = append(grouper.Members{{"webhook", webhookSender}}, members...)
+	}
+	if tracerProvider != nil {
+		// first, so that it is stopped last, once the spans of the other members have ended
+		members = append(grouper.Members{{"tracing", tracingRunner(tracerProvider)}}, members...)
+	}
+	if demoMode {
+		members = append(members, grouper.Member{"demo", &demoRunner{
+			logger:     logger.Session("demo"),
+			brokerAddr: demoBrokerAddr(*atAddress),
+		}})
+	}
+
+	return grouper.NewOrdered(os.Interrupt, members)
+}
+
+// brokerHandler serves the broker API and the broker's own endpoints to clients with any of the given credentials
+// or, when tokenVerifier is given, a valid UAA token.  When basic auth is disabled, client certificates or tokens
+// alone authenticate clients.
+func brokerHandler(logger lager.Logger, serviceBroker *nfsbroker.Broker, tokenVerifier *uaa.Verifier, credentials func() []nfsbroker.BrokerCredential) http.Handler {
+	basicAuth := func(handler http.Handler) http.Handler {
+		return nfsbroker.BasicAuth(logger.Session("basic-auth"), credentials, handler)
+	}
+	authenticate := basicAuth
+	switch {
+	case tokenVerifier != nil && *disableBasicAuth:
+		authenticate = func(handler http.Handler) http.Handler { return tokenVerifier.Wrap(handler, nil) }
+	case tokenVerifier != nil:
+		authenticate = func(handler http.Handler) http.Handler { return tokenVerifier.Wrap(handler, basicAuth(handler)) }
+	case *disableBasicAuth:
+		authenticate = func(handler http.Handler) http.Handler { return handler }
+	}
+	router := mux.NewRouter()
+	brokerapi.AttachRoutes(router, serviceBroker, logger.Session("broker-api"))
+
+	routes := http.NewServeMux()
+	routes.Handle("/admin/", authenticate(nfsbroker.NewAdminHandler(logger.Session("admin"), serviceBroker)))
+	routes.Handle(nfsbroker.ParametersPath, authenticate(nfsbroker.NewParametersHandler(serviceBroker)))
+	brokerAPI := nfsbroker.NewMaintenanceInfoHandler(serviceBroker, router)
+	brokerAPI = nfsbroker.NewBindingOperationHandler(logger.Session("broker-api"), serviceBroker, brokerAPI)
+	brokerAPI = nfsbroker.NewBindingMetadataHandler(logger.Session("broker-api"), serviceBroker, brokerAPI)
+	brokerAPI = nfsbroker.NewInstanceHandler(logger.Session("broker-api"), serviceBroker, brokerAPI)
+	routes.Handle("/", authenticate(nfsbroker.NewAPIVersionHandler(serviceBroker, brokerAPI)))
+	return routes
+}
+
+// serverTLSConfig serves with the certificate and key in the given PEM files, over TLS 1.2 or later.  When clientCAFile
+// is given, clients must present a certificate issued by one of its CAs and, when clientNames are given, named by
+// one of them.  Client certificates are accepted within the clock skew checker tolerates of their validity periods.
+func serverTLSConfig(certFile, keyFile, clientCAFile string, clientNames []string, checker validity.Checker) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("-tlsCertFile and -tlsKeyFile must be given together")
+	}
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile == "" {
+		return config, nil
+	}
+
+	bundle, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the client CA bundle: %w", err)
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("client CA bundle %s has no PEM certificates", clientCAFile)
+	}
+	// the client's certificate is verified here rather than by crypto/tls, which allows no clock skew
+	config.ClientAuth = tls.RequireAnyClientCert
+	allowed := map[string]bool{}
+	for _, name := range clientNames {
+		allowed[name] = true
+	}
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		certificates := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			certificate, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("invalid client certificate: %w", err)
+			}
+			certificates[i] = certificate
+		}
+		client := certificates[0]
+		if err := checker.CheckCertificate(client); err != nil {
+			return err
+		}
+		intermediates := x509.NewCertPool()
+		for _, certificate := range certificates[1:] {
+			intermediates.AddCert(certificate)
+		}
+		if _, err := client.Verify(x509.VerifyOptions{
+			Roots:         config.ClientCAs,
+			Intermediates: intermediates,
+			CurrentTime:   withinValidityPeriod(client, checker.Clock.Now()),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}); err != nil {
+			return fmt.Errorf("client certificate %q is not trusted: %w", client.Subject.CommonName, err)
+		}
+		if len(allowed) == 0 {
+			return nil
+		}
+		for _, name := range append([]string{client.Subject.CommonName}, client.DNSNames...) {
+			if allowed[name] {
+				return nil
+			}
+		}
+		return fmt.Errorf("client certificate %q is not one of %s", client.Subject.CommonName, strings.Join(clientNames, ", "))
+	}
+	return config, nil
+}
+
+// withinValidityPeriod moves now into the validity period of a certificate whose period has already been checked, so
+// that its chain can be verified as of a time it is valid.
+func withinValidityPeriod(certificate *x509.Certificate, now time.Time) time.Time {
+	if now.Before(certificate.NotBefore) {
+		return certificate.NotBefore
+	}
+	if now.After(certificate.NotAfter) {
+		return certificate.NotAfter
+	}
+	return now
+}
+
+// newStateEncryption returns the encryption of file store state files with the keys given, or nil when none are.
+func newStateEncryption(logger lager.Logger) *nfsbroker.StateEncryption {
+	keys := readKeys(logger, "stateEncryptionKeyFile", *stateEncryptionKeyFile, "STATE_ENCRYPTION_KEY", stateEncryptionKeys)
+	if len(keys) == 0 {
+		return nil
+	}
+	if *dbDriver != "" && (*standbyDbDriver != "" || *standbyDataDir == "") {
+		logger.Fatal("invalid-state-encryption-keys", errors.New("state encryption keys encrypt state files, which are not used with -dbDriver"))
+	}
+	stateEncryption, err := nfsbroker.NewStateEncryption(keys)
+	if err != nil {
+		logger.Fatal("invalid-state-encryption-keys", err)
+	}
+	return stateEncryption
+}
+
+// newParameterEncryption returns the encryption of stored binding parameters with the keys given, or nil when none
+// are, in which case parameters are hashed.
+func newParameterEncryption(logger lager.Logger) *nfsbroker.ParameterEncryption {
+	keys := readKeys(logger, "bindingParameterKeyFile", *bindingParameterKeyFile, "BINDING_PARAMETER_KEY", bindingParameterKeys)
+	if len(keys) == 0 {
+		return nil
+	}
+	parameterEncryption, err := nfsbroker.NewParameterEncryption(keys)
+	if err != nil {
+		logger.Fatal("invalid-binding-parameter-keys", err)
+	}
+	return parameterEncryption
+}
+
+// readKeys returns the keys in keyFile, one per line, or else those given in the environment.
+func readKeys(logger lager.Logger, flagName, keyFile, envName string, envKeys []string) []string {
+	if keyFile == "" {
+		return envKeys
+	}
+	if len(envKeys) > 0 {
+		logger.Fatal("conflicting-keys", fmt.Errorf("-%s cannot be used with %s", flagName, envName))
+	}
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		logger.Fatal("failed-to-read-keys", err, lager.Data{"path": keyFile})
+	}
+	keys := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if key := strings.TrimSpace(line); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// secretValue returns the secret in path, read again whenever the file changes, or value when no path is given.
+func secretValue(logger lager.Logger, path, value string) func() string {
+	if path == "" {
+		return func() string { return value }
+	}
+	secret, err := nfsbroker.NewSecretFile(logger, path)
+	if err != nil {
+		logger.Fatal("failed-to-read-secret-file", err)
+	}
+	return secret.Value
+}
+
+// staticCredentials returns credentials that never change, such as those of other foundations.
+func staticCredentials(username, password string) func() []nfsbroker.BrokerCredential {
+	return func() []nfsbroker.BrokerCredential {
+		return []nfsbroker.BrokerCredential{{Username: username, Password: password}}
+	}
+}
+
+// foundationStore opens a foundation's own store: a file named after it, or its own database.
+func foundationStore(logger lager.Logger, foundation nfsbroker.Foundation, stateEncryption *nfsbroker.StateEncryption, parameterEncryption *nfsbroker.ParameterEncryption) nfsbroker.Store {
+	dir := *dataDir
+	if foundation.DataDir != "" {
+		dir = foundation.DataDir
+	}
+	fileName := filepath.Join(dir, fmt.Sprintf("%s-%s-services.json", *serviceName, foundation.Name))
+	return nfsbroker.NewStore(logger, *dbDriver, dbUsername, dbPassword, *dbHostname, *dbPort, foundationDBName(foundation), *dbCACert, fileName, *maxValueSize, *dbQueryTimeout, stateEncryption, parameterEncryption, *bcryptCost)
+}
+
+// foundationDBName is the database of a foundation's SQL store.
+func foundationDBName(foundation nfsbroker.Foundation) string {
+	if foundation.DBName != "" {
+		return foundation.DBName
+	}
+	return *dbName + "_" + foundation.Name
+}
+
+// sqlStoreRotation is a SQL store whose database credentials are rotated on SIGHUP, and the database it connects to.
+type sqlStoreRotation struct {
+	store   *nfsbroker.SqlStore
+	standby bool
+
+	driver, hostname, port, name, caCert string
+}
+
+type sqlStoreRotations []sqlStoreRotation
+
+// add adds store to the rotations if it is a SQL store.
+func (r sqlStoreRotations) add(store nfsbroker.Store, standby bool, driver, hostname, port, name, caCert string) sqlStoreRotations {
+	sqlStore, ok := store.(*nfsbroker.SqlStore)
+	if !ok {
+		return r
+	}
+	return append(r, sqlStoreRotation{store: sqlStore, standby: standby, driver: driver, hostname: hostname, port: port, name: name, caCert: caCert})
+}
+
+// rotate re-reads the database credentials, from the environment, CredHub and -dbPasswordFile as at startup, and
+// reconnects the stores with them.  Every store is tried, and the first failure returned.
+func (r sqlStoreRotations) rotate(credhubClient *credhub.Client, dbPasswordValue func() string) func(lager.Logger) error {
+	return func(logger lager.Logger) error {
+		username, password, err := currentDBCredentials(credhubClient, "DB_")
+		if err != nil {
+			return err
+		}
+		if *dbPasswordFile != "" {
+			password = dbPasswordValue()
+		}
+		standbyUsername, standbyPassword, err := currentDBCredentials(credhubClient, "STANDBY_DB_")
+		if err != nil {
+			return err
+		}
+
+		var failure error
+		for _, rotation := range r {
+			u, p := username, password
+			if rotation.standby {
+				u, p = standbyUsername, standbyPassword
+			}
+			variant, err := nfsbroker.NewSqlVariant(rotation.driver, u, p, rotation.hostname, rotation.port, rotation.name, rotation.caCert)
+			if err == nil {
+				err = rotation.store.Reconnect(logger, variant)
+			}
+			if err != nil {
+				logger.Error("failed-to-reconnect-store", err, lager.Data{"database": rotation.name})
+				if failure == nil {
+					failure = err
+				}
+			}
+		}
+		return failure
+	}
+}
+
+// currentDBCredentials returns the database username and password of the environment variables with prefix, resolved
+// in CredHub if they are references.
+func currentDBCredentials(credhubClient *credhub.Client, prefix string) (string, string, error) {
+	username, password := os.Getenv(prefix+"USERNAME"), os.Getenv(prefix+"PASSWORD")
+	if credhubClient == nil {
+		return username, password, nil
+	}
+	username, err := credhubClient.Resolve(context.Background(), username)
+	if err != nil {
+		return "", "", err
+	}
+	password, err = credhubClient.Resolve(context.Background(), password)
+	if err != nil {
+		return "", "", err
+	}
+	return username, password, nil
+}
+
+// newTokenVerifier verifies the tokens of the UAA at -uaaURL, if one is given.
+func newTokenVerifier(logger lager.Logger) *uaa.Verifier {
+	if *uaaURL == "" {
+		return nil
+	}
+	if *uaaAudience == "" && *uaaScopes == "" {
+		logger.Fatal("invalid-uaa-flags", errors.New("-uaaURL requires -uaaAudience or -uaaScopes, or any UAA user could call the broker"))
+	}
+	var scopes []string
+	for _, scope := range strings.Split(*uaaScopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if *uaaCACert != "" {
+		pem, err := ioutil.ReadFile(*uaaCACert)
+		if err != nil {
+			logger.Fatal("failed-to-read-uaa-ca-cert", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			logger.Fatal("invalid-uaa-ca-cert", errors.New("the UAA CA certificate is not a PEM encoded certificate"))
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	config := uaa.Config{URL: *uaaURL, Audience: *uaaAudience, Scopes: scopes}
+	httpClient := &http.Client{Timeout: 30 * time.Second, Transport: transport}
+	return uaa.NewVerifier(config, httpClient, clock.NewClock(), *clockSkewTolerance, logger.Session("uaa"))
+}
+
+// newAuditLog returns the logger the broker records requests in for -auditLogFile and -auditSyslog, apart from its own
+// log, or nil without them.
+func newAuditLog(logger lager.Logger) lager.Logger {
+	if *auditLogFile == "" && *auditSyslog == "" {
+		return nil
+	}
+	auditLog := lager.NewLogger("nfsbroker-audit")
+	if *auditLogFile != "" {
+		file, err := os.OpenFile(*auditLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			logger.Fatal("failed-to-open-audit-log", err)
+		}
+		sink, _ := newRedactingSink(file, lager.INFO)
+		auditLog.RegisterSink(sink)
+	}
+	if *auditSyslog != "" {
+		writer, err := dialSyslog(*auditSyslog)
+		if err != nil {
+			logger.Fatal("failed-to-connect-to-audit-syslog", err)
+		}
+		sink, _ := newRedactingSink(writer, lager.INFO)
+		auditLog.RegisterSink(sink)
+	}
+	return auditLog
+}
+
+// newMetrics returns the metrics the broker records for -metricsAddr and -statsdHost, or nil without them, and the
+// handler the Prometheus metrics of -metricsAddr are scraped from, along with those of the Go runtime and the process.
+func newMetrics(logger lager.Logger) (nfsbroker.Metrics, http.Handler) {
+	var metrics nfsbroker.MultiMetrics
+	var handler http.Handler
+	if *metricsAddr != "" {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		metrics = append(metrics, nfsbroker.NewPrometheusMetrics(registry))
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		handler = mux
+	}
+	if *statsdHost != "" {
+		statsd, err := nfsbroker.NewStatsdMetrics(net.JoinHostPort(*statsdHost, *statsdPort), *statsdPrefix)
+		if err != nil {
+			logger.Fatal("failed-to-connect-to-statsd", err)
+		}
+		metrics = append(metrics, statsd)
+	}
+
+	switch len(metrics) {
+	case 0:
+		return nil, handler
+	case 1:
+		return metrics[0], handler
+	}
+	return metrics, handler
+}
+
+// newTracerProvider exports the broker's traces to -otlpTracesURL and -zipkinURL, or returns nil without them.
+func newTracerProvider(logger lager.Logger) *sdktrace.TracerProvider {
+	var options []sdktrace.TracerProviderOption
+	if *otlpTracesURL != "" {
+		exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(*otlpTracesURL))
+		if err != nil {
+			logger.Fatal("invalid-otlp-traces-url", err)
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+	if *zipkinURL != "" {
+		exporter, err := zipkin.New(*zipkinURL)
+		if err != nil {
+			logger.Fatal("invalid-zipkin-url", err)
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	if *traceSampleRatio < 0 || *traceSampleRatio > 1 {
+		logger.Fatal("invalid-trace-sample-ratio", fmt.Errorf("-traceSampleRatio %g must be from 0 to 1", *traceSampleRatio))
+	}
+
+	provider := sdktrace.NewTracerProvider(append(options,
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*traceSampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "nfsbroker"))),
+	)...)
+	otel.SetTracerProvider(provider)
+	return provider
+}
+
+// tracingRunner flushes the spans that have not been exported yet when the broker stops.
+func tracingRunner(provider *sdktrace.TracerProvider) ifrit.Runner {
+	return ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+		close(ready)
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return provider.Shutdown(ctx)
+	})
+}
+
+// dialSyslog connects to the syslog server at address, which is udp://host:port, tcp://host:port or local.
+func dialSyslog(address string) (*syslog.Writer, error) {
+	priority := syslog.LOG_INFO | syslog.LOG_AUTH
+	if address == "local" {
+		return syslog.New(priority, "nfsbroker")
+	}
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+		return nil, fmt.Errorf("syslog address %q must be udp://host:port, tcp://host:port or local", address)
+	}
+	return syslog.Dial(u.Scheme, u.Host, priority, "nfsbroker")
+}
+
+func newCredHubClient(logger lager.Logger) *credhub.Client {
+	if *credhubClientCert == "" || *credhubClientKey == "" {
+		logger.Fatal("invalid-credhub-flags", errors.New("-credhubURL requires -credhubClientCert and -credhubClientKey"))
+	}
+	certificate, err := tls.LoadX509KeyPair(*credhubClientCert, *credhubClientKey)
+	if err != nil {
+		logger.Fatal("invalid-credhub-client-cert", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	if *credhubCACert != "" {
+		pem, err := ioutil.ReadFile(*credhubCACert)
+		if err != nil {
+			logger.Fatal("failed-to-read-credhub-ca-cert", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			logger.Fatal("invalid-credhub-ca-cert", errors.New("the CredHub CA certificate is not a PEM encoded certificate"))
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return credhub.NewClient(*credhubURL, &http.Client{Timeout: 30 * time.Second, Transport: transport})
+}
+
+// resolveCredHubReferences replaces the credentials given in the environment that are CredHub references with the
+// credentials they reference.
+func resolveCredHubReferences(logger lager.Logger, client *credhub.Client) {
+	logger = logger.Session("resolve-credhub-references")
+	resolve := func(name string, value *string) {
+		resolved, err := client.Resolve(context.Background(), *value)
+		if err != nil {
+			logger.Fatal("failed-to-resolve-credential", err, lager.Data{"variable": name})
+		}
+		if resolved != *value {
+			logger.Info("resolved-credential", lager.Data{"variable": name})
+		}
+		*value = resolved
+	}
+	resolve("USERNAME", &username)
+	resolve("PASSWORD", &password)
+	for i := range extraCredentials {
+		resolve(fmt.Sprintf("USERNAME_%d", i+2), &extraCredentials[i].Username)
+		resolve(fmt.Sprintf("PASSWORD_%d", i+2), &extraCredentials[i].Password)
+	}
+	resolve("DB_USERNAME", &dbUsername)
+	resolve("DB_PASSWORD", &dbPassword)
+	resolve("STANDBY_DB_USERNAME", &standbyDbUsername)
+	resolve("STANDBY_DB_PASSWORD", &standbyDbPassword)
+}
+
+func newCFClient() *cfapi.Client {
+	if *cfApiUrl == "" {
+		return nil
+	}
+	return cfapi.NewClient(*cfApiUrl, *cfClientId, cfClientSecret, &http.Client{Timeout: 30 * time.Second}, clock.NewClock())
+}
+
+// egressPolicies allows apps to reach their share servers with one networking policy API destination per server
+// address and ports.
+type egressPolicies struct {
+	client *cfapi.Client
+}
+
+func (p egressPolicies) AllowEgress(ctx context.Context, appGUID string, rules []nfsbroker.NetworkRule) error {
+	for _, rule := range rules {
+		ports, err := nfsbroker.ParseNetworkRulePorts(rule.Ports)
+		if err != nil {
+			return err
+		}
+		err = p.client.AllowEgress(ctx, appGUID, cfapi.Destination{
+			Name:        fmt.Sprintf("%s-%s-%s-%s", *serviceName, rule.Protocol, rule.Destination, strings.Replace(rule.Ports, ",", "-", -1)),
+			Description: rule.Description,
+			Protocol:    rule.Protocol,
+			IPs:         []string{rule.Destination},
+			Ports:       ports,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pluginSteps asks every plugin, in turn, to create the share of each new instance and remove the share of each
+// deprovisioned one.
+func pluginSteps(plugins []*provisioner.Client) ([]nfsbroker.ProvisionStep, []nfsbroker.DeprovisionStep) {
+	var provisionSteps []nfsbroker.ProvisionStep
+	var deprovisionSteps []nfsbroker.DeprovisionStep
+	for _, plugin := range plugins {
+		plugin := plugin
+		provisionSteps = append(provisionSteps, func(ctx context.Context, instanceID string, details nfsbroker.ServiceInstance) error {
+			return plugin.Provision(ctx, provisioner.ProvisionRequest{
+				InstanceID:       instanceID,
+				PlanID:           details.PlanID,
+				OrganizationGUID: details.OrganizationGUID,
+				SpaceGUID:        details.SpaceGUID,
+				Share:            pluginShare(details),
+			})
+		})
+		deprovisionSteps = append(deprovisionSteps, func(ctx context.Context, instanceID string, details nfsbroker.ServiceInstance) error {
+			return plugin.Deprovision(ctx, provisioner.DeprovisionRequest{
+				InstanceID: instanceID,
+				PlanID:     details.PlanID,
+				Share:      pluginShare(details),
+			})
+		})
+	}
+	return provisionSteps, deprovisionSteps
+}
+
+func pluginShare(details nfsbroker.ServiceInstance) provisioner.Share {
+	share := provisioner.Share{
+		Server:  details.ShareServer,
+		Path:    details.SharePath,
+		Version: details.ShareVersion,
+		Options: details.ShareOptions,
+	}
+	if share.Server == "" {
+		share.Path = details.Share
+	}
+	return share
 }
 
 func ConvertPostgresError(err *pq.Error) string {
@@ -1,10 +1,22 @@
 package main
 
 import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"io"
+	"math/big"
 	"net/http"
 	"os/exec"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 
 	"encoding/json"
 	"io/ioutil"
@@ -16,13 +28,19 @@ import (
 
 	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
+	"github.com/onsi/gomega/ghttp"
 	"github.com/pivotal-cf/brokerapi"
 	"github.com/tedsuo/ifrit"
 	"github.com/tedsuo/ifrit/ginkgomon"
 
+	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/goshims/osshim/os_fake"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/provisioner"
+	"code.cloudfoundry.org/nfsbroker/validity"
+	"code.cloudfoundry.org/nfsbroker/webhook"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -130,7 +148,7 @@ var _ = Describe("nfsbroker Main", func() {
 			logger = lagertest.NewTestLogger("test-broker-main")
 		})
 		JustBeforeEach(func() {
-			env:=fmt.Sprintf(`
+			env := fmt.Sprintf(`
 				{
 					"postgresql":[
 						{
@@ -155,8 +173,8 @@ var _ = Describe("nfsbroker Main", func() {
 							"volume_mounts":[]
 						}
 					]
-				}`,port)
-			fakeOs.LookupEnvReturns(env,true)
+				}`, port)
+			fakeOs.LookupEnvReturns(env, true)
 		})
 
 		Context("when port is a string", func() {
@@ -203,6 +221,27 @@ var _ = Describe("nfsbroker Main", func() {
 
 		})
 
+		It("refuses a debug address reachable from the network", func() {
+			args := []string{"-dataDir", os.TempDir(), "-debugAddr", "0.0.0.0:17017"}
+			volmanRunner := failRunner{
+				Name:       "nfsbroker",
+				Command:    exec.Command(binaryPath, args...),
+				StartCheck: "invalid-debug-addr",
+			}
+			process = ifrit.Invoke(volmanRunner)
+		})
+
+		It("refuses unknown settings in a config file", func() {
+			configPath := filepath.Join(os.TempDir(), "nfsbroker-unknown-config.yml")
+			Expect(ioutil.WriteFile(configPath, []byte("dataDir: /tmp\nlistenAddress: 0.0.0.0:8999\n"), 0600)).To(Succeed())
+			volmanRunner := failRunner{
+				Name:       "nfsbroker",
+				Command:    exec.Command(binaryPath, "-configPath", configPath),
+				StartCheck: "unknown setting listenAddress",
+			}
+			process = ifrit.Invoke(volmanRunner)
+		})
+
 		AfterEach(func() {
 			ginkgomon.Kill(process) // this is only if incorrect implementation leaves process running
 		})
@@ -227,8 +266,7 @@ var _ = Describe("nfsbroker Main", func() {
 			os.Setenv("USERNAME", username)
 			os.Setenv("PASSWORD", password)
 
-			args = append(args, "-listenAddr", listenAddr)
-			args = append(args, "-dataDir", tempDir)
+			args = []string{"-listenAddr", listenAddr, "-dataDir", tempDir}
 
 		})
 
@@ -250,6 +288,7 @@ var _ = Describe("nfsbroker Main", func() {
 			Expect(err).NotTo(HaveOccurred())
 
 			req.SetBasicAuth(username, password)
+			req.Header.Set("X-Broker-API-Version", "2.14")
 			return http.DefaultClient.Do(req)
 		}
 
@@ -260,6 +299,36 @@ var _ = Describe("nfsbroker Main", func() {
 			Expect(resp.StatusCode).To(Equal(200))
 		})
 
+		It("serves fetch service instance requests", func() {
+			resp, err := httpDoWithAuth("GET", "/v2/service_instances/does-not-exist", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+
+			resp, err = http.Get("http://" + listenAddr + "/v2/service_instances/does-not-exist")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("reports its health without authentication", func() {
+			resp, err := http.Get("http://" + listenAddr + "/health")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("shuts down cleanly on SIGTERM, having saved its store", func() {
+			body := ioutil.NopCloser(strings.NewReader(`{"service_id":"service-guid","plan_id":"Existing","parameters":{"share":"server/sigterm-export"}}`))
+			resp, err := httpDoWithAuth("PUT", "/v2/service_instances/sigterm-instance-id", body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+
+			process.Signal(syscall.SIGTERM)
+			Eventually(process.Wait(), 10*time.Second).Should(Receive(BeNil()))
+
+			state, err := ioutil.ReadFile(filepath.Join(tempDir, "nfsvolume-services.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(state)).To(ContainSubstring("sigterm-instance-id"))
+		})
+
 		Context("given arguments", func() {
 			BeforeEach(func() {
 				args = append(args, "-serviceName", "something")
@@ -285,5 +354,912 @@ var _ = Describe("nfsbroker Main", func() {
 				Expect(catalog.Services[0].Plans[0].Description).To(Equal("A preexisting filesystem"))
 			})
 		})
+
+		Context("given further credentials", func() {
+			BeforeEach(func() {
+				os.Setenv("USERNAME_2", "new-admin")
+				os.Setenv("PASSWORD_2", "new-password")
+			})
+
+			AfterEach(func() {
+				os.Unsetenv("USERNAME_2")
+				os.Unsetenv("PASSWORD_2")
+			})
+
+			It("accepts the old and new credentials alike", func() {
+				resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				username, password = "new-admin", "new-password"
+				resp, err = httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				password = "password"
+				resp, err = httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			})
+		})
+
+		Context("given a password file", func() {
+			var passwordFile string
+
+			BeforeEach(func() {
+				passwordFile = filepath.Join(tempDir, "nfsbroker-password")
+				Expect(ioutil.WriteFile(passwordFile, []byte("file-password\n"), 0600)).To(Succeed())
+				args = append(args, "-passwordFile", passwordFile)
+			})
+
+			AfterEach(func() {
+				os.Remove(passwordFile)
+			})
+
+			It("uses the password in the file, and reads it again when it changes", func() {
+				resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+				password = "file-password"
+				resp, err = httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				Expect(ioutil.WriteFile(passwordFile, []byte("rotated-password"), 0600)).To(Succeed())
+				later := time.Now().Add(time.Minute)
+				Expect(os.Chtimes(passwordFile, later, later)).To(Succeed())
+				password = "rotated-password"
+				resp, err = httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("given allowed CIDRs", func() {
+			Context("that exclude the client", func() {
+				BeforeEach(func() {
+					args = append(args, "-allowedCIDRs", "10.0.16.0/20")
+				})
+
+				It("refuses its requests", func() {
+					resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+				})
+			})
+
+			Context("that include the client", func() {
+				BeforeEach(func() {
+					args = append(args, "-allowedCIDRs", "10.0.16.0/20,127.0.0.0/8")
+				})
+
+				It("serves its requests", func() {
+					resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+		})
+
+		Context("given a debug address", func() {
+			var debugAddr string
+
+			BeforeEach(func() {
+				debugAddr = "127.0.0.1:" + strconv.Itoa(9399+GinkgoParallelNode())
+				args = append(args, "-debugAddr", debugAddr)
+			})
+
+			It("serves expvar's variables", func() {
+				resp, err := http.Get("http://" + debugAddr + "/debug/vars")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(body)).To(ContainSubstring(`"memstats"`))
+			})
+		})
+
+		Context("given a metrics address", func() {
+			var metricsAddr string
+
+			BeforeEach(func() {
+				metricsAddr = "127.0.0.1:" + strconv.Itoa(9299+GinkgoParallelNode())
+				args = append(args, "-metricsAddr", metricsAddr)
+			})
+
+			It("serves Prometheus metrics of the broker API's requests", func() {
+				resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				resp, err = http.Get("http://" + metricsAddr + "/metrics")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(body)).To(ContainSubstring(`nfsbroker_http_request_duration_seconds_count{endpoint="catalog",status="200"} 1`))
+			})
+		})
+
+		Context("given a webhook URL", func() {
+			var webhookServer *ghttp.Server
+
+			BeforeEach(func() {
+				webhookServer = ghttp.NewServer()
+				os.Setenv("WEBHOOK_SECRET", "webhook-secret")
+				args = append(args, "-webhookURL", webhookServer.URL()+"/events")
+			})
+
+			AfterEach(func() {
+				os.Unsetenv("WEBHOOK_SECRET")
+				webhookServer.Close()
+			})
+
+			It("sends a signed event for each finished operation", func() {
+				webhookServer.AppendHandlers(func(w http.ResponseWriter, req *http.Request) {
+					body, err := ioutil.ReadAll(req.Body)
+					Expect(err).NotTo(HaveOccurred())
+					checker := validity.NewChecker(clock.NewClock(), validity.DefaultTolerance, nil)
+					Expect(webhook.Verify(checker, []byte("webhook-secret"), webhook.DefaultMaxAge, req.Header, body)).To(Succeed())
+					Expect(string(body)).To(ContainSubstring(`"operation":"provision","instance_id":"webhook-instance-id"`))
+				})
+
+				body := ioutil.NopCloser(strings.NewReader(`{"service_id":"service-guid","plan_id":"Existing","parameters":{"share":"server/webhook-export"}}`))
+				resp, err := httpDoWithAuth("PUT", "/v2/service_instances/webhook-instance-id", body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+				Eventually(webhookServer.ReceivedRequests).Should(HaveLen(1))
+			})
+		})
+
+		Context("given settings in the environment", func() {
+			BeforeEach(func() {
+				os.Setenv("NFSBROKER_SERVICE_NAME", "environment-service")
+				os.Setenv("NFSBROKER_SERVICE_ID", "environment-service-id")
+				args = append(args, "-serviceId", "flag-service-id")
+			})
+
+			AfterEach(func() {
+				os.Unsetenv("NFSBROKER_SERVICE_NAME")
+				os.Unsetenv("NFSBROKER_SERVICE_ID")
+			})
+
+			It("takes flags from their NFSBROKER_ variables, unless they are given on the command line", func() {
+				resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+
+				var catalog brokerapi.CatalogResponse
+				Expect(json.NewDecoder(resp.Body).Decode(&catalog)).To(Succeed())
+				Expect(catalog.Services[0].Name).To(Equal("environment-service"))
+				Expect(catalog.Services[0].ID).To(Equal("flag-service-id"))
+			})
+		})
+
+		Context("given a config file", func() {
+			BeforeEach(func() {
+				configPath := filepath.Join(tempDir, "nfsbroker-config.yml")
+				Expect(ioutil.WriteFile(configPath, []byte(`
+listenAddr: 127.0.0.1:1
+serviceName: config-service
+serviceId: config-service-id
+allowedCIDRs: [127.0.0.0/8, "::1/128"]
+`), 0600)).To(Succeed())
+				args = append(args, "-configPath", configPath, "-serviceId", "flag-service-id")
+			})
+
+			It("takes its settings from the file, unless they are given as flags", func() {
+				resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+
+				var catalog brokerapi.CatalogResponse
+				Expect(json.NewDecoder(resp.Body).Decode(&catalog)).To(Succeed())
+				Expect(catalog.Services[0].Name).To(Equal("config-service"))
+				Expect(catalog.Services[0].ID).To(Equal("flag-service-id"))
+			})
+		})
+
+		Context("given a config file with a catalog", func() {
+			BeforeEach(func() {
+				configPath := filepath.Join(tempDir, "nfsbroker-catalog-config.yml")
+				Expect(ioutil.WriteFile(configPath, []byte(`
+catalog:
+  services:
+  - id: config-catalog-service-id
+    name: config-catalog-nfs
+    description: NFS shares
+    plans:
+    - id: config-plan-id
+      name: general
+      description: General purpose mounts
+`), 0600)).To(Succeed())
+				args = append(args, "-configPath", configPath)
+			})
+
+			It("serves the catalog from the file", func() {
+				resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+
+				var catalog brokerapi.CatalogResponse
+				Expect(json.NewDecoder(resp.Body).Decode(&catalog)).To(Succeed())
+				Expect(catalog.Services[0].ID).To(Equal("config-catalog-service-id"))
+				Expect(catalog.Services[0].Plans[0].ID).To(Equal("config-plan-id"))
+			})
+		})
+
+		Context("given a catalog file", func() {
+			var catalogPath string
+
+			BeforeEach(func() {
+				catalogPath = filepath.Join(tempDir, "nfsbroker-catalog.yml")
+				Expect(ioutil.WriteFile(catalogPath, []byte(`
+services:
+- id: catalog-service-id
+  name: catalog-nfs
+  description: NFS shares
+  tags: [nfs, catalog]
+  metadata:
+    displayName: NFS
+  plans:
+  - id: general-id
+    name: general
+    description: General purpose mounts
+    metadata:
+      displayName: General
+`), 0600)).To(Succeed())
+				args = append(args, "-catalogPath", catalogPath)
+			})
+
+			It("serves the catalog from the file", func() {
+				resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+
+				var catalog brokerapi.CatalogResponse
+				Expect(json.NewDecoder(resp.Body).Decode(&catalog)).To(Succeed())
+				Expect(catalog.Services[0].ID).To(Equal("catalog-service-id"))
+				Expect(catalog.Services[0].Name).To(Equal("catalog-nfs"))
+				Expect(catalog.Services[0].Tags).To(Equal([]string{"nfs", "catalog"}))
+				Expect(catalog.Services[0].Metadata.DisplayName).To(Equal("NFS"))
+				Expect(catalog.Services[0].Plans).To(HaveLen(1))
+				Expect(catalog.Services[0].Plans[0].ID).To(Equal("general-id"))
+				Expect(catalog.Services[0].Plans[0].Metadata.DisplayName).To(Equal("General"))
+			})
+
+			It("reloads the catalog on SIGHUP", func() {
+				Expect(ioutil.WriteFile(catalogPath, []byte(`
+services:
+- id: catalog-service-id
+  name: catalog-nfs
+  description: Reloaded NFS shares
+  plans:
+  - id: general-id
+    name: general
+    description: General purpose mounts
+`), 0600)).To(Succeed())
+				process.Signal(syscall.SIGHUP)
+
+				Eventually(func() string {
+					resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+					Expect(err).NotTo(HaveOccurred())
+					var catalog brokerapi.CatalogResponse
+					Expect(json.NewDecoder(resp.Body).Decode(&catalog)).To(Succeed())
+					return catalog.Services[0].Description
+				}).Should(Equal("Reloaded NFS shares"))
+				Consistently(process.Wait()).ShouldNot(Receive())
+			})
+		})
+	})
+
+	Context("Running as a devserver", func() {
+		var (
+			listenAddr string
+			runner     *ginkgomon.Runner
+			process    ifrit.Process
+		)
+
+		BeforeEach(func() {
+			listenAddr = "127.0.0.1:" + strconv.Itoa(9099+GinkgoParallelNode())
+			runner = ginkgomon.New(ginkgomon.Config{
+				Name:       "nfsbroker",
+				Command:    exec.Command(binaryPath, "devserver", "-listenAddr", listenAddr),
+				StartCheck: "started",
+			})
+			process = ginkgomon.Invoke(runner)
+		})
+
+		AfterEach(func() {
+			ginkgomon.Kill(process)
+		})
+
+		httpDo := func(method, endpoint, body string) *http.Response {
+			req, err := http.NewRequest(method, "http://"+listenAddr+endpoint, strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			req.SetBasicAuth("anyone", "anything")
+			req.Header.Set("X-Broker-API-Version", "2.14")
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			return resp
+		}
+
+		It("accepts any credentials and reports on the volume mounts it generates", func() {
+			resp := httpDo("PUT", "/v2/service_instances/instance-id", `{"service_id":"service-id","plan_id":"Existing","parameters":{"share":"server/export"}}`)
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+
+			resp = httpDo("PUT", "/v2/service_instances/instance-id/service_bindings/binding-id", `{"service_id":"service-id","plan_id":"Existing","app_guid":"app-guid"}`)
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+
+			Eventually(runner.Buffer()).Should(gbytes.Say(`"response_body"`))
+			Eventually(runner.Buffer()).Should(gbytes.Say(`volume-mount-report.*"valid":true`))
+		})
+
+		It("dumps its state on SIGQUIT and keeps running", func() {
+			process.Signal(syscall.SIGQUIT)
+			Eventually(runner.Buffer()).Should(gbytes.Say(`state-dump.dump`))
+			Consistently(process.Wait()).ShouldNot(Receive())
+		})
+	})
+
+	Context("Running the demo", func() {
+		var (
+			runner  *ginkgomon.Runner
+			process ifrit.Process
+		)
+
+		BeforeEach(func() {
+			listenAddr := "127.0.0.1:" + strconv.Itoa(9199+GinkgoParallelNode())
+			runner = ginkgomon.New(ginkgomon.Config{
+				Name:       "nfsbroker",
+				Command:    exec.Command(binaryPath, "demo", "-listenAddr", listenAddr),
+				StartCheck: "started",
+			})
+			process = ginkgomon.Invoke(runner)
+		})
+
+		AfterEach(func() {
+			ginkgomon.Kill(process)
+		})
+
+		It("walks an instance of the stub NFS server's export through its lifecycle", func() {
+			Eventually(runner.Buffer(), 10*time.Second).Should(gbytes.Say(`demo-step.*"step":"ping-nfs-server"`))
+			Eventually(runner.Buffer(), 10*time.Second).Should(gbytes.Say(`volume-mount-report.*"valid":true`))
+			Eventually(runner.Buffer(), 10*time.Second).Should(gbytes.Say(`demo-complete`))
+			Expect(runner.Buffer()).NotTo(gbytes.Say(`demo-step-failed`))
+		})
+	})
+
+	Context("Migrating the store", func() {
+		var dataDir, standbyDataDir string
+
+		BeforeEach(func() {
+			var err error
+			dataDir, err = ioutil.TempDir("", "migrate-from")
+			Expect(err).NotTo(HaveOccurred())
+			standbyDataDir, err = ioutil.TempDir("", "migrate-to")
+			Expect(err).NotTo(HaveOccurred())
+
+			listenAddr := "127.0.0.1:" + strconv.Itoa(9399+GinkgoParallelNode())
+			command := exec.Command(binaryPath, "-listenAddr", listenAddr, "-dataDir", dataDir)
+			command.Env = append(os.Environ(), "USERNAME=admin", "PASSWORD=password")
+			process := ginkgomon.Invoke(ginkgomon.New(ginkgomon.Config{
+				Name:       "nfsbroker",
+				Command:    command,
+				StartCheck: "started",
+			}))
+			req, err := http.NewRequest("PUT", "http://"+listenAddr+"/v2/service_instances/migrated-instance-id", strings.NewReader(`{"service_id":"service-guid","plan_id":"Existing","parameters":{"share":"server/export"}}`))
+			Expect(err).NotTo(HaveOccurred())
+			req.SetBasicAuth("admin", "password")
+			req.Header.Set("X-Broker-API-Version", "2.14")
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+			ginkgomon.Interrupt(process, 10*time.Second)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dataDir)
+			os.RemoveAll(standbyDataDir)
+		})
+
+		migrate := func(args ...string) *gexec.Session {
+			session, err := gexec.Start(exec.Command(binaryPath, append([]string{"migrate"}, args...)...), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			return session
+		}
+
+		It("copies the records into the standby store, and leaves them alone when run again", func() {
+			session := migrate("-dataDir", dataDir, "-standbyDataDir", standbyDataDir)
+			Eventually(session, 10*time.Second).Should(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say(`migrate-store.migrated.*"instances":{"copied":1,"unchanged":0}`))
+
+			state, err := ioutil.ReadFile(filepath.Join(standbyDataDir, "nfsvolume-services.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(state)).To(ContainSubstring("migrated-instance-id"))
+
+			session = migrate("-dataDir", dataDir, "-standbyDataDir", standbyDataDir)
+			Eventually(session, 10*time.Second).Should(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say(`migrate-store.migrated.*"instances":{"copied":0,"unchanged":1}`))
+		})
+
+		It("refuses to run without a standby store", func() {
+			session := migrate("-dataDir", dataDir)
+			Eventually(session, 10*time.Second).Should(gexec.Exit())
+			Expect(session.ExitCode()).NotTo(Equal(0))
+			Expect(session.Out).To(gbytes.Say(`missing-migration-target`))
+		})
+
+		It("copies the state file named by --from-dataDir", func() {
+			session := migrate("--from-dataDir", dataDir, "-standbyDataDir", standbyDataDir)
+			Eventually(session, 10*time.Second).Should(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say(`migrate-store.migrated.*"instances":{"copied":1,"unchanged":0}`))
+
+			state, err := ioutil.ReadFile(filepath.Join(standbyDataDir, "nfsvolume-services.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(state)).To(ContainSubstring("migrated-instance-id"))
+		})
+
+		It("copies into the database named by --to-db and the database flags", func() {
+			session := migrate("--from-dataDir", dataDir, "--to-db", "mysql", "-dbHostname", "127.0.0.1", "-dbPort", "1", "-dbName", "nfsbroker")
+			Eventually(session, 30*time.Second).Should(gexec.Exit())
+			Expect(session.ExitCode()).NotTo(Equal(0))
+			Expect(session.Out).To(gbytes.Say(`migrate.target-store.failed-creating-sql-store`))
+		})
+
+		It("refuses --to-db without --from-dataDir", func() {
+			session := migrate("-dataDir", dataDir, "--to-db", "mysql")
+			Eventually(session, 10*time.Second).Should(gexec.Exit())
+			Expect(session.ExitCode()).NotTo(Equal(0))
+			Expect(session.Out).To(gbytes.Say(`missing-migration-source`))
+		})
+
+		It("refuses --to-db with a standby store", func() {
+			session := migrate("--from-dataDir", dataDir, "--to-db", "mysql", "-standbyDataDir", standbyDataDir)
+			Eventually(session, 10*time.Second).Should(gexec.Exit())
+			Expect(session.ExitCode()).NotTo(Equal(0))
+			Expect(session.Out).To(gbytes.Say(`conflicting-migration-flags`))
+		})
+	})
+
+	Context("Serving several foundations", func() {
+		var (
+			listenAddr string
+			tempDir    string
+			process    ifrit.Process
+		)
+
+		BeforeEach(func() {
+			listenAddr = "127.0.0.1:" + strconv.Itoa(9299+GinkgoParallelNode())
+			var err error
+			tempDir, err = ioutil.TempDir("", "foundations")
+			Expect(err).NotTo(HaveOccurred())
+
+			foundationsPath := filepath.Join(tempDir, "foundations.json")
+			Expect(ioutil.WriteFile(foundationsPath, []byte(`[{"name": "east", "username": "east-user", "password": "east-password"}]`), 0600)).To(Succeed())
+
+			command := exec.Command(binaryPath, "-listenAddr", listenAddr, "-dataDir", tempDir, "-foundations", foundationsPath)
+			command.Env = append(os.Environ(), "USERNAME=admin", "PASSWORD=password")
+			process = ginkgomon.Invoke(ginkgomon.New(ginkgomon.Config{
+				Name:       "nfsbroker",
+				Command:    command,
+				StartCheck: "started",
+			}))
+		})
+
+		AfterEach(func() {
+			ginkgomon.Kill(process)
+			os.RemoveAll(tempDir)
+		})
+
+		httpDo := func(username, password, method, endpoint, body string) *http.Response {
+			req, err := http.NewRequest(method, "http://"+listenAddr+endpoint, strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			req.SetBasicAuth(username, password)
+			req.Header.Set("X-Broker-API-Version", "2.14")
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			return resp
+		}
+
+		It("keeps each foundation's instances in its own store", func() {
+			resp := httpDo("east-user", "east-password", "PUT", "/v2/service_instances/instance-id", `{"service_id":"service-id","plan_id":"Existing","parameters":{"share":"server/export"}}`)
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+
+			resp = httpDo("east-user", "east-password", "GET", "/v2/service_instances/instance-id", "")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			resp = httpDo("admin", "password", "GET", "/v2/service_instances/instance-id", "")
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+
+			Expect(filepath.Join(tempDir, "nfsvolume-east-services.json")).To(BeAnExistingFile())
+		})
+
+		It("requires each foundation's own credentials", func() {
+			resp := httpDo("admin", "password", "GET", "/v2/catalog", "")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			req, err := http.NewRequest("GET", "http://"+listenAddr+"/v2/catalog", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.SetBasicAuth("admin", "password")
+			req.Header.Set("X-Broker-Foundation", "east")
+			resp, err = http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Context("Serving HTTPS", func() {
+		var (
+			listenAddr string
+			tempDir    string
+			ca         *testCA
+			args       []string
+			runner     *ginkgomon.Runner
+			process    ifrit.Process
+		)
+
+		BeforeEach(func() {
+			listenAddr = "127.0.0.1:" + strconv.Itoa(9399+GinkgoParallelNode())
+			var err error
+			tempDir, err = ioutil.TempDir("", "tls")
+			Expect(err).NotTo(HaveOccurred())
+			ca = newTestCA("broker-ca")
+			certFile, keyFile := ca.issue(tempDir, "broker", x509.ExtKeyUsageServerAuth)
+
+			os.Setenv("USERNAME", "admin")
+			os.Setenv("PASSWORD", "password")
+			args = []string{"-listenAddr", listenAddr, "-dataDir", tempDir, "-tlsCertFile", certFile, "-tlsKeyFile", keyFile}
+		})
+
+		JustBeforeEach(func() {
+			runner = ginkgomon.New(ginkgomon.Config{
+				Name:       "nfsbroker",
+				Command:    exec.Command(binaryPath, args...),
+				StartCheck: "started",
+			})
+			process = ginkgomon.Invoke(runner)
+		})
+
+		AfterEach(func() {
+			ginkgomon.Kill(process)
+			os.RemoveAll(tempDir)
+		})
+
+		It("serves the broker API over TLS", func() {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool()}}}
+			req, err := http.NewRequest("GET", "https://"+listenAddr+"/v2/catalog", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.SetBasicAuth("admin", "password")
+			req.Header.Set("X-Broker-API-Version", "2.14")
+			resp, err := client.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.TLS.Version).To(BeNumerically(">=", tls.VersionTLS12))
+		})
+
+		It("does not serve plain HTTP", func() {
+			resp, err := http.Get("http://" + listenAddr + "/v2/catalog")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+
+		Context("when clients must present certificates", func() {
+			var clientCA *testCA
+
+			getCatalogWith := func(certificate *tls.Certificate, withAuth bool) (*http.Response, error) {
+				tlsConfig := &tls.Config{RootCAs: ca.pool()}
+				if certificate != nil {
+					tlsConfig.Certificates = []tls.Certificate{*certificate}
+				}
+				client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+				req, err := http.NewRequest("GET", "https://"+listenAddr+"/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				if withAuth {
+					req.SetBasicAuth("admin", "password")
+				}
+				req.Header.Set("X-Broker-API-Version", "2.14")
+				return client.Do(req)
+			}
+
+			getCatalog := func(clientName string, withAuth bool) (*http.Response, error) {
+				if clientName == "" {
+					return getCatalogWith(nil, withAuth)
+				}
+				certificate, err := tls.LoadX509KeyPair(clientCA.issue(tempDir, clientName, x509.ExtKeyUsageClientAuth))
+				Expect(err).NotTo(HaveOccurred())
+				return getCatalogWith(&certificate, withAuth)
+			}
+
+			BeforeEach(func() {
+				clientCA = newTestCA("client-ca")
+				clientCAFile := filepath.Join(tempDir, "client-ca.crt")
+				Expect(ioutil.WriteFile(clientCAFile, clientCA.pem, 0600)).To(Succeed())
+				args = append(args, "-tlsClientCAFile", clientCAFile, "-tlsClientNames", "cloud-controller")
+			})
+
+			It("serves clients with an allowed certificate and credentials", func() {
+				resp, err := getCatalog("cloud-controller", true)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				resp, err = getCatalog("cloud-controller", false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			})
+
+			It("refuses clients without a certificate or with another name", func() {
+				_, err := getCatalog("", true)
+				Expect(err).To(HaveOccurred())
+
+				_, err = getCatalog("someone-else", true)
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("accepts certificates that are not yet valid by less than the clock skew tolerated", func() {
+				certificate, err := tls.LoadX509KeyPair(clientCA.issueValidFrom(tempDir, "cloud-controller", x509.ExtKeyUsageClientAuth, time.Now().Add(10*time.Second)))
+				Expect(err).NotTo(HaveOccurred())
+				resp, err := getCatalogWith(&certificate, true)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				certificate, err = tls.LoadX509KeyPair(clientCA.issueValidFrom(tempDir, "cloud-controller", x509.ExtKeyUsageClientAuth, time.Now().Add(5*time.Minute)))
+				Expect(err).NotTo(HaveOccurred())
+				_, err = getCatalogWith(&certificate, true)
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("refuses certificates from other CAs", func() {
+				otherCA := newTestCA("other-ca")
+				certificate, err := tls.LoadX509KeyPair(otherCA.issue(tempDir, "cloud-controller", x509.ExtKeyUsageClientAuth))
+				Expect(err).NotTo(HaveOccurred())
+				_, err = getCatalogWith(&certificate, true)
+				Expect(err).To(HaveOccurred())
+			})
+
+			Context("when basic auth is disabled", func() {
+				BeforeEach(func() {
+					args = append(args, "-disableBasicAuth")
+				})
+
+				It("serves clients by their certificates alone", func() {
+					resp, err := getCatalog("cloud-controller", false)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+		})
+	})
+
+	Context("Authenticating with UAA tokens", func() {
+		var (
+			listenAddr string
+			tempDir    string
+			uaaServer  *ghttp.Server
+			key        *rsa.PrivateKey
+			args       []string
+			process    ifrit.Process
+		)
+
+		token := func(scopes ...string) string {
+			header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"key-1"}`))
+			claims, err := json.Marshal(map[string]interface{}{
+				"iss":   uaaServer.URL() + "/oauth/token",
+				"aud":   []string{"nfsbroker"},
+				"scope": scopes,
+				"exp":   time.Now().Add(time.Hour).Unix(),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			signed := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+			digest := sha256.Sum256([]byte(signed))
+			signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+			Expect(err).NotTo(HaveOccurred())
+			return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+		}
+
+		getCatalog := func(authorize func(*http.Request)) int {
+			req, err := http.NewRequest("GET", "http://"+listenAddr+"/v2/catalog", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("X-Broker-API-Version", "2.14")
+			authorize(req)
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			return resp.StatusCode
+		}
+
+		bearer := func(token string) func(*http.Request) {
+			return func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }
+		}
+		basic := func(req *http.Request) { req.SetBasicAuth("admin", "password") }
+
+		BeforeEach(func() {
+			listenAddr = "127.0.0.1:" + strconv.Itoa(9499+GinkgoParallelNode())
+			var err error
+			tempDir, err = ioutil.TempDir("", "uaa")
+			Expect(err).NotTo(HaveOccurred())
+			key, err = rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).NotTo(HaveOccurred())
+
+			uaaServer = ghttp.NewServer()
+			uaaServer.RouteToHandler("GET", "/token_keys", ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+				"keys": []map[string]string{{
+					"kty": "RSA",
+					"kid": "key-1",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				}},
+			}))
+
+			os.Setenv("USERNAME", "admin")
+			os.Setenv("PASSWORD", "password")
+			args = []string{"-listenAddr", listenAddr, "-dataDir", tempDir, "-uaaURL", uaaServer.URL(), "-uaaAudience", "nfsbroker", "-uaaScopes", "nfsbroker.admin"}
+		})
+
+		JustBeforeEach(func() {
+			process = ginkgomon.Invoke(ginkgomon.New(ginkgomon.Config{
+				Name:       "nfsbroker",
+				Command:    exec.Command(binaryPath, args...),
+				StartCheck: "started",
+			}))
+		})
+
+		AfterEach(func() {
+			ginkgomon.Kill(process)
+			uaaServer.Close()
+			os.RemoveAll(tempDir)
+		})
+
+		It("serves clients with a token that grants the scopes, or with credentials", func() {
+			Expect(getCatalog(bearer(token("nfsbroker.admin")))).To(Equal(http.StatusOK))
+			Expect(getCatalog(bearer(token("uaa.none")))).To(Equal(http.StatusUnauthorized))
+			Expect(getCatalog(basic)).To(Equal(http.StatusOK))
+		})
+
+		Context("when basic auth is disabled", func() {
+			BeforeEach(func() {
+				args = append(args, "-disableBasicAuth")
+			})
+
+			It("serves clients with a token alone", func() {
+				Expect(getCatalog(bearer(token("nfsbroker.admin")))).To(Equal(http.StatusOK))
+				Expect(getCatalog(basic)).To(Equal(http.StatusUnauthorized))
+			})
+		})
+
+		Context("when serving several foundations", func() {
+			BeforeEach(func() {
+				foundationsPath := filepath.Join(tempDir, "foundations.json")
+				Expect(ioutil.WriteFile(foundationsPath, []byte(`[{"name": "east", "username": "east-user", "password": "east-password"}]`), 0600)).To(Succeed())
+				args = append(args, "-foundations", foundationsPath)
+			})
+
+			It("does not let a token reach another foundation", func() {
+				Expect(getCatalog(bearer(token("nfsbroker.admin")))).To(Equal(http.StatusOK))
+
+				east := func(authorize func(*http.Request)) func(*http.Request) {
+					return func(req *http.Request) {
+						authorize(req)
+						req.Header.Set("X-Broker-Foundation", "east")
+					}
+				}
+				Expect(getCatalog(east(bearer(token("nfsbroker.admin"))))).To(Equal(http.StatusUnauthorized))
+				Expect(getCatalog(east(func(req *http.Request) { req.SetBasicAuth("east-user", "east-password") }))).To(Equal(http.StatusOK))
+			})
+		})
+	})
+
+	Context("Resolving credentials from CredHub", func() {
+		var (
+			listenAddr    string
+			tempDir       string
+			credhubServer *ghttp.Server
+			args          []string
+			process       ifrit.Process
+		)
+
+		BeforeEach(func() {
+			listenAddr = "127.0.0.1:" + strconv.Itoa(9599+GinkgoParallelNode())
+			var err error
+			tempDir, err = ioutil.TempDir("", "credhub")
+			Expect(err).NotTo(HaveOccurred())
+
+			ca := newTestCA("credhub-ca")
+			serverCertFile, serverKeyFile := ca.issue(tempDir, "credhub", x509.ExtKeyUsageServerAuth)
+			clientCertFile, clientKeyFile := ca.issue(tempDir, "nfsbroker", x509.ExtKeyUsageClientAuth)
+			caFile := filepath.Join(tempDir, "credhub-ca.crt")
+			Expect(ioutil.WriteFile(caFile, ca.pem, 0600)).To(Succeed())
+			serverCertificate, err := tls.LoadX509KeyPair(serverCertFile, serverKeyFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			credhubServer = ghttp.NewUnstartedServer()
+			credhubServer.HTTPTestServer.TLS = &tls.Config{
+				Certificates: []tls.Certificate{serverCertificate},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    ca.pool(),
+			}
+			credhubServer.HTTPTestServer.StartTLS()
+			credhubServer.RouteToHandler("GET", "/api/v1/data", ghttp.RespondWith(http.StatusOK,
+				`{"data":[{"type":"user","value":{"username":"credhub-admin","password":"credhub-password"}}]}`))
+
+			os.Setenv("USERNAME", "((/nfsbroker/admin.username))")
+			os.Setenv("PASSWORD", "((/nfsbroker/admin.password))")
+			args = []string{"-listenAddr", listenAddr, "-dataDir", tempDir, "-credhubURL", credhubServer.URL(),
+				"-credhubCACert", caFile, "-credhubClientCert", clientCertFile, "-credhubClientKey", clientKeyFile}
+		})
+
+		JustBeforeEach(func() {
+			process = ginkgomon.Invoke(ginkgomon.New(ginkgomon.Config{
+				Name:       "nfsbroker",
+				Command:    exec.Command(binaryPath, args...),
+				StartCheck: "started",
+			}))
+		})
+
+		AfterEach(func() {
+			ginkgomon.Kill(process)
+			credhubServer.Close()
+			os.RemoveAll(tempDir)
+		})
+
+		It("serves clients with the credentials in CredHub", func() {
+			req, err := http.NewRequest("GET", "http://"+listenAddr+"/v2/catalog", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.SetBasicAuth("credhub-admin", "credhub-password")
+			req.Header.Set("X-Broker-API-Version", "2.14")
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			Expect(credhubServer.ReceivedRequests()).To(HaveLen(2))
+			Expect(credhubServer.ReceivedRequests()[0].URL.Query().Get("name")).To(Equal("/nfsbroker/admin"))
+		})
+	})
+
+	Context("given share provisioner plugins", func() {
+		var (
+			plugin  *provisioner.Client
+			logPath string
+		)
+
+		BeforeEach(func() {
+			pluginPath, err := gexec.Build("code.cloudfoundry.org/nfsbroker/provisioner/fixtures/testprovisioner")
+			Expect(err).NotTo(HaveOccurred())
+
+			tempDir, err := ioutil.TempDir("", "plugins")
+			Expect(err).NotTo(HaveOccurred())
+			logPath = filepath.Join(tempDir, "requests.log")
+			os.Setenv("TEST_PROVISIONER_LOG", logPath)
+
+			plugin, err = provisioner.Start(lagertest.NewTestLogger("plugins"), pluginPath)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			plugin.Kill()
+			os.Unsetenv("TEST_PROVISIONER_LOG")
+			os.RemoveAll(filepath.Dir(logPath))
+		})
+
+		It("asks the plugins to provision and deprovision each instance's share", func() {
+			provisionSteps, deprovisionSteps := pluginSteps([]*provisioner.Client{plugin})
+			Expect(provisionSteps).To(HaveLen(1))
+			Expect(deprovisionSteps).To(HaveLen(1))
+
+			details := nfsbroker.ServiceInstance{PlanID: "Existing", OrganizationGUID: "org-guid", Share: "filer:/export", ShareServer: "filer", SharePath: "/export"}
+			Expect(provisionSteps[0](context.TODO(), "instance-id", details)).To(Succeed())
+			Expect(deprovisionSteps[0](context.TODO(), "instance-id", details)).To(Succeed())
+
+			requests, err := ioutil.ReadFile(logPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(requests)).To(ContainSubstring(`{"provision":{"instance_id":"instance-id","plan_id":"Existing","organization_guid":"org-guid","space_guid":"","share":{"server":"filer","path":"/export"}}}`))
+			Expect(string(requests)).To(ContainSubstring(`{"deprovision":{"instance_id":"instance-id","plan_id":"Existing","share":{"server":"filer","path":"/export"}}}`))
+		})
+
+		It("fails provisioning when a plugin fails", func() {
+			provisionSteps, _ := pluginSteps([]*provisioner.Client{plugin})
+
+			details := nfsbroker.ServiceInstance{Share: "broken-filer:/export", ShareServer: "broken-filer", SharePath: "/export"}
+			Expect(provisionSteps[0](context.TODO(), "instance-id", details)).To(MatchError(ContainSubstring("broken-filer is broken")))
+		})
 	})
 })
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+)
+
+// MigrateCommand copies the records of one store into another and exits, so that a broker can be moved from a state
+// file onto a database, or from one database onto another.  By default the stores are named by the broker's usual
+// flags: -dataDir or -dbDriver and the other database flags for the store to copy from, and -standbyDataDir or
+// -standbyDbDriver and the other standby flags for the store to copy to, so that -dbDriver mysql with
+// -standbyDbDriver postgres copies a broker's records from MySQL into Postgres before the standby store is promoted.
+// -from-dataDir instead copies the state file in the given directory, and -to-db copies into a database of the given
+// driver that -dbHostname and the other database flags describe, so that
+//
+//	nfsbroker migrate --from-dataDir /var/vcap/store/nfsbroker --to-db mysql -dbHostname ... -dbName ...
+//
+// moves a broker off its state file onto the database it is then started with.  Once copied, the stores' row counts
+// are compared and every record is read back from the target store, so that corrupt or lost records are found before
+// the broker is moved.  The migration can be run again, for instance after it was interrupted; records that have
+// already been copied are left alone.  The broker should be stopped while its records are migrated.
+const MigrateCommand = "migrate"
+
+var migrateMode bool
+
+// runMigration migrates the source store into the target store, logging a summary of what it copied.  It fails if
+// any record is stored differently in the target store, or if the migration could not be verified.
+func runMigration(logger lager.Logger) {
+	logger = logger.Session("migrate")
+	if *toDb != "" {
+		if *fromDataDir == "" {
+			logger.Fatal("missing-migration-source", errors.New("-to-db copies a state file into the database named by the database flags, so -from-dataDir must name the state file's directory"))
+		}
+		if *dbDriver != "" || *standbyDbDriver != "" || *standbyDataDir != "" {
+			logger.Fatal("conflicting-migration-flags", errors.New("-to-db cannot be used with -dbDriver, -standbyDbDriver or -standbyDataDir"))
+		}
+	} else if *standbyDbDriver == "" && *standbyDataDir == "" {
+		logger.Fatal("missing-migration-target", errors.New("migrate copies records into the database named by -to-db, or the standby store named by -standbyDataDir or -standbyDbDriver"))
+	}
+	loadCatalog(logger)
+
+	if *credhubURL != "" {
+		resolveCredHubReferences(logger, newCredHubClient(logger))
+	}
+	dbPassword = secretValue(logger, *dbPasswordFile, dbPassword)()
+	if err := nfsbroker.CheckBcryptCost(*bcryptCost); err != nil {
+		logger.Fatal("invalid-bcrypt-cost", err)
+	}
+	stateEncryption := newStateEncryption(logger)
+
+	// binding parameters are copied as they are stored, hashed or encrypted, so no parameter keys are needed
+	var from, to nfsbroker.Store
+	if *fromDataDir != "" {
+		fileName := filepath.Join(*fromDataDir, fmt.Sprintf("%s-services.json", *serviceName))
+		from = nfsbroker.NewStore(logger.Session("source-store"), "", "", "", "", "", "", "", fileName, *maxValueSize, *dbQueryTimeout, stateEncryption, nil, *bcryptCost)
+	} else {
+		fileName := filepath.Join(*dataDir, fmt.Sprintf("%s-services.json", *serviceName))
+		from = nfsbroker.NewStore(logger.Session("source-store"), *dbDriver, dbUsername, dbPassword, *dbHostname, *dbPort, *dbName, *dbCACert, fileName, *maxValueSize, *dbQueryTimeout, stateEncryption, nil, *bcryptCost)
+	}
+	if *toDb != "" {
+		to = nfsbroker.NewStore(logger.Session("target-store"), *toDb, dbUsername, dbPassword, *dbHostname, *dbPort, *dbName, *dbCACert, "", *maxValueSize, *dbQueryTimeout, stateEncryption, nil, *bcryptCost)
+	} else {
+		standbyFileName := filepath.Join(*standbyDataDir, fmt.Sprintf("%s-services.json", *serviceName))
+		to = nfsbroker.NewStore(logger.Session("target-store"), *standbyDbDriver, standbyDbUsername, standbyDbPassword, *standbyDbHostname, *standbyDbPort, *standbyDbName, *standbyDbCACert, standbyFileName, *maxValueSize, *dbQueryTimeout, stateEncryption, nil, *bcryptCost)
+	}
+
+	if err := from.Restore(logger); err != nil {
+		logger.Fatal("failed-to-read-source-store", err)
+	}
+	// a target state file is written by the migration if it does not exist yet
+	if err := to.Restore(logger); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Fatal("failed-to-read-target-store", err)
+	}
+
+	report, err := nfsbroker.MigrateStore(context.Background(), logger, from, to)
+	if err != nil {
+		logger.Fatal("failed-to-migrate-store", err, lager.Data{"report": report})
+	}
+	if conflicts := report.Conflicts(); conflicts > 0 {
+		logger.Fatal("conflicting-records", fmt.Errorf("%d records are stored differently in the target store, and were left as they are there", conflicts), lager.Data{"report": report})
+	}
+	if len(report.Problems) > 0 {
+		logger.Fatal("failed-to-verify-migration", fmt.Errorf("%d problems were found with the migrated records", len(report.Problems)), lager.Data{"report": report})
+	}
+}
@@ -0,0 +1,337 @@
+package nfsbroker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	AdminRemoveOrphanedBindingsPath = "/admin/orphaned_bindings/cleanup"
+	AdminPromoteStandbyStorePath    = "/admin/store/promote"
+	AdminInstancesPath              = "/admin/instances"
+	AdminInstanceLabelsPath         = "/admin/instances/labels"
+	AdminStatePath                  = "/admin/state"
+	AdminChangesPath                = "/admin/changes"
+	AdminSLOPath                    = "/admin/slo"
+	AdminNetworkRulesPath           = "/admin/network_rules"
+	AdminPlansPath                  = "/admin/plans"
+	AdminRetirePlanPath             = "/admin/plans/retire"
+	AdminReinstatePlanPath          = "/admin/plans/reinstate"
+	AdminShareReservationsPath      = "/admin/share_reservations"
+	AdminReserveSharesPath          = "/admin/share_reservations/reserve"
+	AdminReleaseSharesPath          = "/admin/share_reservations/release"
+)
+
+type removeOrphanedBindingsResponse struct {
+	RemovedBindings []string `json:"removed_bindings"`
+}
+
+type instancesResponse struct {
+	Instances []InstanceReport `json:"instances"`
+}
+
+// instanceLabelsRequest is the body of instance label requests.  Labels set to null are removed.
+type instanceLabelsRequest struct {
+	InstanceID string             `json:"instance_id"`
+	Labels     map[string]*string `json:"labels"`
+}
+
+type instanceLabelsResponse struct {
+	InstanceID string            `json:"instance_id"`
+	Labels     map[string]string `json:"labels"`
+}
+
+type shareReservationsResponse struct {
+	Reservations []ShareReservation `json:"reservations"`
+}
+
+// shareReservationRequest is the body of share reserve and release requests.  Releases need no organization.
+type shareReservationRequest struct {
+	Prefix           string `json:"prefix"`
+	OrganizationGUID string `json:"organization_guid"`
+}
+
+type networkRulesResponse struct {
+	Bindings []BindingNetworkRules `json:"bindings"`
+}
+
+type plansResponse struct {
+	Plans []PlanStatus `json:"plans"`
+}
+
+// planRetirementRequest is the body of plan retire and reinstate requests.  Plan is a plan ID or name.
+type planRetirementRequest struct {
+	Plan string `json:"plan"`
+	Hint string `json:"hint"`
+}
+
+// NewAdminHandler serves operator endpoints that sit alongside the service broker API.  It does no authentication
+// of its own.
+func NewAdminHandler(logger lager.Logger, broker *Broker) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(AdminRemoveOrphanedBindingsPath, func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(w, r, "POST") {
+			return
+		}
+
+		removed, err := broker.RemoveOrphanedBindings(r.Context())
+		if err != nil {
+			logger.Error("remove-orphaned-bindings-failed", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"description": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, removeOrphanedBindingsResponse{RemovedBindings: removed})
+	})
+	mux.HandleFunc(AdminPromoteStandbyStorePath, func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(w, r, "POST") {
+			return
+		}
+
+		err := broker.PromoteStandbyStore(r.Context())
+		switch {
+		case err == nil:
+			writeJSON(w, http.StatusOK, map[string]string{})
+		case errors.Is(err, ErrNoStandbyStore):
+			writeJSON(w, http.StatusNotFound, map[string]string{"description": err.Error()})
+		case errors.Is(err, ErrStandbyStoreMismatch):
+			writeJSON(w, http.StatusConflict, map[string]string{"description": err.Error()})
+		default:
+			logger.Error("promote-standby-store-failed", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"description": err.Error()})
+		}
+	})
+	mux.HandleFunc(AdminInstancesPath, func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(w, r, "GET") {
+			return
+		}
+
+		reports, err := broker.InstanceReports(r.Context())
+		if err != nil {
+			logger.Error("list-instances-failed", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"description": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, instancesResponse{Instances: reports})
+	})
+	mux.HandleFunc(AdminInstanceLabelsPath, func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(w, r, "POST") {
+			return
+		}
+
+		var request instanceLabelsRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.InstanceID == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"description": `the body must be a JSON object with an "instance_id" and "labels"`})
+			return
+		}
+
+		labels, err := broker.UpdateInstanceLabels(r.Context(), request.InstanceID, request.Labels)
+		switch {
+		case err == nil:
+			writeJSON(w, http.StatusOK, instanceLabelsResponse{InstanceID: request.InstanceID, Labels: labels})
+		case errors.Is(err, ErrInstanceNotFound):
+			writeJSON(w, http.StatusNotFound, map[string]string{"description": err.Error()})
+		case errors.Is(err, ErrInvalidLabels):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"description": err.Error()})
+		default:
+			logger.Error("update-instance-labels-failed", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"description": err.Error()})
+		}
+	})
+	mux.HandleFunc(AdminStatePath, func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(w, r, "GET") {
+			return
+		}
+		writeJSON(w, http.StatusOK, broker.DumpState(r.Context()))
+	})
+	mux.HandleFunc(AdminChangesPath, func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(w, r, "GET") {
+			return
+		}
+
+		since, limit, err := changesQuery(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"description": err.Error()})
+			return
+		}
+		page, err := broker.Changes(r.Context(), since, limit)
+		switch {
+		case err == nil:
+			writeJSON(w, http.StatusOK, page)
+		case errors.Is(err, ErrNoChangeFeed):
+			writeJSON(w, http.StatusNotFound, map[string]string{"description": err.Error()})
+		case errors.Is(err, ErrStoreUnavailable):
+			logger.Error("list-changes-failed", err)
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"description": err.Error()})
+		default:
+			logger.Error("list-changes-failed", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"description": err.Error()})
+		}
+	})
+	mux.HandleFunc(AdminSLOPath, func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(w, r, "GET") {
+			return
+		}
+
+		report, ok := broker.SLOReport()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"description": "the SLO probe is not enabled"})
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	})
+	mux.HandleFunc(AdminNetworkRulesPath, func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(w, r, "GET") {
+			return
+		}
+
+		reports, err := broker.NetworkRuleReports(r.Context(), r.URL.Query().Get("app_guid"))
+		switch {
+		case err == nil:
+			writeJSON(w, http.StatusOK, networkRulesResponse{Bindings: reports})
+		case errors.Is(err, ErrNetworkRulesDisabled):
+			writeJSON(w, http.StatusNotFound, map[string]string{"description": err.Error()})
+		default:
+			logger.Error("list-network-rules-failed", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"description": err.Error()})
+		}
+	})
+	mux.HandleFunc(AdminPlansPath, func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(w, r, "GET") {
+			return
+		}
+
+		statuses, err := broker.PlanStatuses(r.Context())
+		if err != nil {
+			logger.Error("list-plans-failed", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"description": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, plansResponse{Plans: statuses})
+	})
+	servePlanRetirement := func(retire bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !requireMethod(w, r, "POST") {
+				return
+			}
+
+			var request planRetirementRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Plan == "" {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"description": `the body must be a JSON object with a "plan" ID or name`})
+				return
+			}
+
+			var (
+				status PlanStatus
+				err    error
+			)
+			if retire {
+				status, err = broker.RetirePlan(r.Context(), request.Plan, request.Hint)
+			} else {
+				status, err = broker.ReinstatePlan(r.Context(), request.Plan)
+			}
+			switch {
+			case err == nil:
+				writeJSON(w, http.StatusOK, status)
+			case errors.Is(err, ErrPlanNotFound):
+				writeJSON(w, http.StatusNotFound, map[string]string{"description": err.Error()})
+			default:
+				logger.Error("update-plan-failed", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"description": err.Error()})
+			}
+		}
+	}
+	mux.HandleFunc(AdminRetirePlanPath, servePlanRetirement(true))
+	mux.HandleFunc(AdminReinstatePlanPath, servePlanRetirement(false))
+	mux.HandleFunc(AdminShareReservationsPath, func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(w, r, "GET") {
+			return
+		}
+
+		reservations, err := broker.ShareReservations(r.Context())
+		if err != nil {
+			logger.Error("list-share-reservations-failed", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"description": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, shareReservationsResponse{Reservations: reservations})
+	})
+	serveShareReservation := func(reserve bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !requireMethod(w, r, "POST") {
+				return
+			}
+
+			var request shareReservationRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Prefix == "" {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"description": `the body must be a JSON object with a "prefix"`})
+				return
+			}
+
+			var (
+				response interface{} = map[string]string{}
+				err      error
+			)
+			if reserve {
+				response, err = broker.ReserveShares(r.Context(), request.Prefix, request.OrganizationGUID)
+			} else {
+				err = broker.ReleaseShares(r.Context(), request.Prefix)
+			}
+			switch {
+			case err == nil:
+				writeJSON(w, http.StatusOK, response)
+			case errors.Is(err, ErrInvalidShareReservation):
+				writeJSON(w, http.StatusBadRequest, map[string]string{"description": err.Error()})
+			case errors.Is(err, ErrShareReservationNotFound):
+				writeJSON(w, http.StatusNotFound, map[string]string{"description": err.Error()})
+			case errors.Is(err, ErrShareReservationConflict):
+				writeJSON(w, http.StatusConflict, map[string]string{"description": err.Error()})
+			default:
+				logger.Error("update-share-reservations-failed", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"description": err.Error()})
+			}
+		}
+	}
+	mux.HandleFunc(AdminReserveSharesPath, serveShareReservation(true))
+	mux.HandleFunc(AdminReleaseSharesPath, serveShareReservation(false))
+	return mux
+}
+
+// changesQuery reads the since and limit query parameters of a changes request.  Both are optional.
+func changesQuery(r *http.Request) (int64, int, error) {
+	var (
+		since int64
+		limit int
+		err   error
+	)
+	query := r.URL.Query()
+	if value := query.Get("since"); value != "" {
+		if since, err = strconv.ParseInt(value, 10, 64); err != nil || since < 0 {
+			return 0, 0, errors.New("since must be a non-negative sequence number")
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			return 0, 0, errors.New("limit must be a positive number")
+		}
+	}
+	return since, limit, nil
+}
+
+func requireMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
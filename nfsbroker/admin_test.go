@@ -0,0 +1,234 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type changeFeedStore struct {
+	*nfsbrokerfakes.FakeStore
+	entries []nfsbroker.AuditEntry
+	err     error
+	since   int64
+	limit   int
+}
+
+func (s *changeFeedStore) ListChanges(ctx context.Context, since int64, limit int) ([]nfsbroker.AuditEntry, error) {
+	s.since, s.limit = since, limit
+	return s.entries, s.err
+}
+
+var _ = Describe("AdminHandler", func() {
+	var (
+		handler   http.Handler
+		fakeStore *nfsbrokerfakes.FakeStore
+		recorder  *httptest.ResponseRecorder
+		method    string
+		path      string
+	)
+
+	BeforeEach(func() {
+		logger := lagertest.NewTestLogger("test-admin")
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		broker := nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, fakeStore,
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		handler = nfsbroker.NewAdminHandler(logger, broker)
+		recorder = httptest.NewRecorder()
+		method = "POST"
+		path = nfsbroker.AdminRemoveOrphanedBindingsPath
+
+		fakeStore.ListBindingInstancesReturns(map[string]string{"binding-id": "instance-id"}, nil)
+		fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound)
+	})
+
+	JustBeforeEach(func() {
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	})
+
+	It("removes orphaned bindings and reports them", func() {
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(MatchJSON(`{"removed_bindings":["binding-id"]}`))
+		Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(1))
+	})
+
+	Context("when the cleanup fails", func() {
+		BeforeEach(func() {
+			fakeStore.ListBindingInstancesReturns(nil, errors.New("database is down"))
+		})
+
+		It("reports the failure", func() {
+			Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"description":"database is down"}`))
+		})
+	})
+
+	Context("when the method is not POST", func() {
+		BeforeEach(func() {
+			method = "GET"
+		})
+
+		It("rejects the request", func() {
+			Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+			Expect(fakeStore.ListBindingInstancesCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when promoting the standby store", func() {
+		BeforeEach(func() {
+			path = nfsbroker.AdminPromoteStandbyStorePath
+		})
+
+		It("reports that there is no standby store", func() {
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"description":"no standby store is configured"}`))
+		})
+	})
+
+	Context("when listing instances", func() {
+		BeforeEach(func() {
+			method = "GET"
+			path = nfsbroker.AdminInstancesPath
+			fakeStore.ListInstanceDetailsReturns(map[string]nfsbroker.ServiceInstance{
+				"instance-id": {ServiceID: "service-id", PlanID: "Existing", OrganizationGUID: "org-guid", SpaceGUID: "space-guid", Share: "server/export"},
+			}, nil)
+		})
+
+		It("lists the instances", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"instances":[{
+				"id":"instance-id",
+				"service_id":"service-id",
+				"plan_id":"Existing",
+				"organization_guid":"org-guid",
+				"space_guid":"space-guid",
+				"share":"server/export"
+			}]}`))
+		})
+
+		Context("when the method is not GET", func() {
+			BeforeEach(func() {
+				method = "POST"
+			})
+
+			It("rejects the request", func() {
+				Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+				Expect(fakeStore.ListInstanceDetailsCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("when dumping state", func() {
+		BeforeEach(func() {
+			method = "GET"
+			path = nfsbroker.AdminStatePath
+			fakeStore.CountInstancesReturns(3, nil)
+		})
+
+		It("returns the dump", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(ContainSubstring(`"instance_count":3`))
+		})
+	})
+
+	Context("when listing changes", func() {
+		var feed *changeFeedStore
+
+		BeforeEach(func() {
+			method = "GET"
+			path = nfsbroker.AdminChangesPath + "?since=41"
+			feed = &changeFeedStore{FakeStore: fakeStore, entries: []nfsbroker.AuditEntry{
+				{Sequence: 42, OccurredAt: "2017-01-01T00:00:00Z", Actor: "admin", Action: "create", RecordType: "service_instance", RecordID: "instance-id"},
+				{Sequence: 43, OccurredAt: "2017-01-01T00:01:00Z", Actor: "admin", Action: "create", RecordType: "service_binding", RecordID: "binding-id"},
+			}}
+			logger := lagertest.NewTestLogger("test-admin")
+			broker := nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, feed,
+				nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+			handler = nfsbroker.NewAdminHandler(logger, broker)
+		})
+
+		It("returns the changes after the cursor and the next cursor", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"changes":[
+				{"seq":42,"occurred_at":"2017-01-01T00:00:00Z","action":"create","record_type":"service_instance","record_id":"instance-id"},
+				{"seq":43,"occurred_at":"2017-01-01T00:01:00Z","action":"create","record_type":"service_binding","record_id":"binding-id"}
+			],"next":43}`))
+			Expect(feed.since).To(Equal(int64(41)))
+			Expect(feed.limit).To(Equal(nfsbroker.DefaultChangesLimit))
+		})
+
+		Context("when there are no newer changes", func() {
+			BeforeEach(func() {
+				feed.entries = nil
+			})
+
+			It("returns the same cursor", func() {
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				Expect(recorder.Body.String()).To(MatchJSON(`{"changes":[],"next":41}`))
+			})
+		})
+
+		Context("when the limit is too large", func() {
+			BeforeEach(func() {
+				path = nfsbroker.AdminChangesPath + "?limit=100000"
+			})
+
+			It("caps it", func() {
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				Expect(feed.since).To(Equal(int64(0)))
+				Expect(feed.limit).To(Equal(nfsbroker.MaxChangesLimit))
+			})
+		})
+
+		Context("when the cursor is not a number", func() {
+			BeforeEach(func() {
+				path = nfsbroker.AdminChangesPath + "?since=yesterday"
+			})
+
+			It("rejects the request", func() {
+				Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+			})
+		})
+
+		Context("when the store is unavailable", func() {
+			BeforeEach(func() {
+				feed.err = fmt.Errorf("%w: connection refused", nfsbroker.ErrStoreUnavailable)
+			})
+
+			It("reports the failure", func() {
+				Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+			})
+		})
+
+		Context("when the store does not record changes", func() {
+			BeforeEach(func() {
+				feed.err = nfsbroker.ErrNoChangeFeed
+			})
+
+			It("reports that there is no change feed", func() {
+				Expect(recorder.Code).To(Equal(http.StatusNotFound))
+				Expect(recorder.Body.String()).To(MatchJSON(`{"description":"store does not record changes"}`))
+			})
+		})
+	})
+
+	Context("when listing changes from a store without a change feed", func() {
+		BeforeEach(func() {
+			method = "GET"
+			path = nfsbroker.AdminChangesPath
+		})
+
+		It("reports that there is no change feed", func() {
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+})
@@ -0,0 +1,64 @@
+package nfsbroker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// ParseAllowedCIDRs reads a comma-separated list of networks in CIDR notation, such as 10.0.16.0/20.  Addresses
+// without a prefix length stand for themselves alone.
+func ParseAllowedCIDRs(value string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			ip := net.ParseIP(field)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid CIDR %q", field)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", field)
+		}
+		networks = append(networks, network)
+	}
+	if len(networks) == 0 {
+		return nil, fmt.Errorf("no CIDRs in %q", value)
+	}
+	return networks, nil
+}
+
+// AllowedNetworksHandler refuses requests whose connections come from outside networks with 403 Forbidden, before
+// they are authenticated.
+func AllowedNetworksHandler(logger lager.Logger, networks []*net.IPNet, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientAddress(r)
+		if ip := net.ParseIP(client); ip != nil {
+			for _, network := range networks {
+				if network.Contains(ip) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		logger.Info("client-not-allowed", lager.Data{"client": client, "path": r.URL.Path})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"description": "requests from this address are not allowed"})
+	})
+}
@@ -0,0 +1,65 @@
+package nfsbroker_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Allowed networks", func() {
+	Describe("ParseAllowedCIDRs", func() {
+		It("reads networks and single addresses", func() {
+			networks, err := nfsbroker.ParseAllowedCIDRs("10.0.16.0/20, 192.168.1.7,fd00::/8")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(networks).To(HaveLen(3))
+			Expect(networks[0].String()).To(Equal("10.0.16.0/20"))
+			Expect(networks[1].String()).To(Equal("192.168.1.7/32"))
+			Expect(networks[2].String()).To(Equal("fd00::/8"))
+		})
+
+		It("refuses malformed networks", func() {
+			_, err := nfsbroker.ParseAllowedCIDRs("10.0.16.0/20,10.0.300.0/24")
+			Expect(err).To(MatchError(`invalid CIDR "10.0.300.0/24"`))
+			_, err = nfsbroker.ParseAllowedCIDRs("cloud-controller")
+			Expect(err).To(MatchError(`invalid CIDR "cloud-controller"`))
+			_, err = nfsbroker.ParseAllowedCIDRs(" , ")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("AllowedNetworksHandler", func() {
+		var handler http.Handler
+
+		serve := func(remoteAddr string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/v2/catalog", nil)
+			req.RemoteAddr = remoteAddr
+			handler.ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		BeforeEach(func() {
+			networks, err := nfsbroker.ParseAllowedCIDRs("10.0.16.0/20,::1")
+			Expect(err).NotTo(HaveOccurred())
+			handler = nfsbroker.AllowedNetworksHandler(lagertest.NewTestLogger("test-allowed-networks"), networks, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+		})
+
+		It("serves requests from the allowed networks", func() {
+			Expect(serve("10.0.17.4:51234").Code).To(Equal(http.StatusOK))
+			Expect(serve("[::1]:51234").Code).To(Equal(http.StatusOK))
+		})
+
+		It("refuses requests from other addresses", func() {
+			refused := serve("10.0.32.1:51234")
+			Expect(refused.Code).To(Equal(http.StatusForbidden))
+			Expect(refused.Body.String()).To(MatchJSON(`{"description":"requests from this address are not allowed"}`))
+			Expect(serve("unknown").Code).To(Equal(http.StatusForbidden))
+		})
+	})
+})
@@ -0,0 +1,81 @@
+package nfsbroker
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const APIVersionHeader = "X-Broker-API-Version"
+
+// APIVersion is a service broker API version, such as 2.14.
+type APIVersion struct {
+	Major int
+	Minor int
+}
+
+// DefaultMinimumAPIVersion is the oldest API version the broker accepts unless configured otherwise.  It is the
+// first version with the fetch endpoints the broker serves.
+var DefaultMinimumAPIVersion = APIVersion{Major: 2, Minor: 14}
+
+// ParseAPIVersion reads a version in major.minor form.
+func ParseAPIVersion(version string) (APIVersion, error) {
+	parts := strings.Split(version, ".")
+	if len(parts) != 2 {
+		return APIVersion{}, fmt.Errorf("invalid broker API version %q: must be major.minor", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil || major < 0 {
+		return APIVersion{}, fmt.Errorf("invalid broker API version %q: must be major.minor", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil || minor < 0 {
+		return APIVersion{}, fmt.Errorf("invalid broker API version %q: must be major.minor", version)
+	}
+	return APIVersion{Major: major, Minor: minor}, nil
+}
+
+func (v APIVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// supports reports whether a platform speaking version v can talk to a broker that requires minimum.  Versions with
+// another major version are incompatible either way.
+func (v APIVersion) supports(minimum APIVersion) bool {
+	return v.Major == minimum.Major && v.Minor >= minimum.Minor
+}
+
+// SetMinimumAPIVersion configures the oldest API version the broker accepts in place of DefaultMinimumAPIVersion.
+func (b *Broker) SetMinimumAPIVersion(version APIVersion) {
+	b.minimumAPIVersion = version
+}
+
+// NewAPIVersionHandler rejects service broker API requests without an X-Broker-API-Version header, or with a version
+// the broker does not support, with 412 Precondition Failed, and passes every other request on to next.  Old platforms
+// would otherwise send requests the broker misreads without noticing.
+func NewAPIVersionHandler(broker *Broker, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/v2/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		minimum := broker.minimumAPIVersion
+		header := r.Header.Get(APIVersionHeader)
+		if header == "" {
+			writeJSON(w, http.StatusPreconditionFailed, map[string]string{
+				"description": fmt.Sprintf("%s header is required; this broker supports version %s and later", APIVersionHeader, minimum),
+			})
+			return
+		}
+		version, err := ParseAPIVersion(header)
+		if err != nil || !version.supports(minimum) {
+			writeJSON(w, http.StatusPreconditionFailed, map[string]string{
+				"description": fmt.Sprintf("broker API version %q is not supported; this broker supports version %s and later, up to %d.x", header, minimum, minimum.Major),
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
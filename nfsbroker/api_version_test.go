@@ -0,0 +1,126 @@
+package nfsbroker_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseAPIVersion", func() {
+	It("reads major.minor versions", func() {
+		Expect(nfsbroker.ParseAPIVersion("2.15")).To(Equal(nfsbroker.APIVersion{Major: 2, Minor: 15}))
+	})
+
+	It("rejects anything else", func() {
+		for _, version := range []string{"", "2", "2.x", "2.14.1", "-2.14"} {
+			_, err := nfsbroker.ParseAPIVersion(version)
+			Expect(err).To(MatchError(ContainSubstring("must be major.minor")), "version %q", version)
+		}
+	})
+})
+
+var _ = Describe("APIVersionHandler", func() {
+	var (
+		broker     *nfsbroker.Broker
+		handler    http.Handler
+		recorder   *httptest.ResponseRecorder
+		nextCalled bool
+		path       string
+		version    string
+	)
+
+	BeforeEach(func() {
+		logger := lagertest.NewTestLogger("test-api-version")
+		broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, &nfsbrokerfakes.FakeStore{},
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		nextCalled = false
+		handler = nfsbroker.NewAPIVersionHandler(broker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nextCalled = true
+		}))
+		recorder = httptest.NewRecorder()
+		path = "/v2/catalog"
+		version = "2.14"
+	})
+
+	JustBeforeEach(func() {
+		req := httptest.NewRequest("GET", path, nil)
+		if version != "" {
+			req.Header.Set(nfsbroker.APIVersionHeader, version)
+		}
+		handler.ServeHTTP(recorder, req)
+	})
+
+	It("passes on requests with a supported version", func() {
+		Expect(nextCalled).To(BeTrue())
+	})
+
+	Context("when the header is missing", func() {
+		BeforeEach(func() {
+			version = ""
+		})
+
+		It("rejects the request", func() {
+			Expect(recorder.Code).To(Equal(http.StatusPreconditionFailed))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"description":"X-Broker-API-Version header is required; this broker supports version 2.14 and later"}`))
+			Expect(nextCalled).To(BeFalse())
+		})
+
+		Context("and the request is not for the service broker API", func() {
+			BeforeEach(func() {
+				path = nfsbroker.AdminStatePath
+			})
+
+			It("passes it on", func() {
+				Expect(nextCalled).To(BeTrue())
+			})
+		})
+	})
+
+	Context("when the version is older than the minimum", func() {
+		BeforeEach(func() {
+			version = "2.13"
+		})
+
+		It("rejects the request", func() {
+			Expect(recorder.Code).To(Equal(http.StatusPreconditionFailed))
+			Expect(nextCalled).To(BeFalse())
+		})
+
+		Context("and the broker is configured to accept it", func() {
+			BeforeEach(func() {
+				broker.SetMinimumAPIVersion(nfsbroker.APIVersion{Major: 2, Minor: 10})
+			})
+
+			It("passes on the request", func() {
+				Expect(nextCalled).To(BeTrue())
+			})
+		})
+	})
+
+	Context("when the version has another major version", func() {
+		BeforeEach(func() {
+			version = "3.0"
+		})
+
+		It("rejects the request", func() {
+			Expect(recorder.Code).To(Equal(http.StatusPreconditionFailed))
+			Expect(recorder.Body.String()).To(ContainSubstring(`version \"3.0\" is not supported`))
+		})
+	})
+
+	Context("when the version cannot be parsed", func() {
+		BeforeEach(func() {
+			version = "latest"
+		})
+
+		It("rejects the request", func() {
+			Expect(recorder.Code).To(Equal(http.StatusPreconditionFailed))
+		})
+	})
+})
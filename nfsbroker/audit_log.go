@@ -0,0 +1,96 @@
+package nfsbroker
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+const (
+	AuditResultSucceeded  = "succeeded"
+	AuditResultFailed     = "failed"
+	AuditResultInProgress = "in progress"
+)
+
+// SetAuditLog records every provision, update, bind, unbind and deprovision request in logger, apart from the broker's
+// own log, with the instance's organization and space, who made the request and its result.  Asynchronous requests
+// are recorded as in progress, and again with their outcome once they finish.
+func (b *Broker) SetAuditLog(logger lager.Logger) {
+	b.auditLog = logger
+}
+
+// auditRecord is what the audit log records of a request.  Requests fill in the organization and space once they know
+// the instance, before any asynchronous operation they start reads it.
+type auditRecord struct {
+	data lager.Data
+}
+
+// auditRequest starts the audit record of a request for an operation on an instance, or on one of its bindings.
+func auditRequest(ctx context.Context, operation, instanceID, bindingID string) *auditRecord {
+	data := lager.Data{
+		"operation":           operation,
+		"instanceID":          instanceID,
+		"actor":               RequestActor(ctx),
+		"originatingIdentity": OriginatingIdentity(ctx),
+		"originatingUser":     OriginatingUser(ctx),
+	}
+	if bindingID != "" {
+		data["bindingID"] = bindingID
+	}
+	if id := RequestID(ctx); id != "" {
+		data["requestID"] = id
+	}
+	if isProbe(ctx) {
+		data["probe"] = true
+	}
+	return &auditRecord{data: data}
+}
+
+// instance records the organization and space of the request's instance.
+func (r *auditRecord) instance(details ServiceInstance) {
+	r.space(details.OrganizationGUID, details.SpaceGUID)
+}
+
+func (r *auditRecord) space(organizationGUID, spaceGUID string) {
+	r.data["organizationGUID"] = organizationGUID
+	r.data["spaceGUID"] = spaceGUID
+}
+
+// audit writes the record of a request that failed with err, or else succeeded or, if async, was started.
+func (b *Broker) audit(record *auditRecord, async bool, err error) {
+	switch {
+	case err != nil:
+		b.writeAudit(record.data, AuditResultFailed, err.Error())
+	case async:
+		b.writeAudit(record.data, AuditResultInProgress, "")
+	default:
+		b.writeAudit(record.data, AuditResultSucceeded, "")
+	}
+}
+
+// auditOutcome writes the outcome of an asynchronous operation once it has finished, as recorded when the request
+// that started it was.
+func (b *Broker) auditOutcome(record *auditRecord, outcome Operation) {
+	if outcome.State == brokerapi.Failed {
+		b.writeAudit(record.data, AuditResultFailed, outcome.Description)
+		return
+	}
+	b.writeAudit(record.data, AuditResultSucceeded, "")
+}
+
+// writeAudit also counts the operations that have finished and sends them to the webhook.
+func (b *Broker) writeAudit(data lager.Data, result, message string) {
+	if result != AuditResultInProgress {
+		b.countOperation(data["operation"].(string), result)
+		b.notifyWebhook(data, result, message)
+	}
+	if b.auditLog == nil {
+		return
+	}
+	outcome := lager.Data{"result": result}
+	if message != "" {
+		outcome["error"] = message
+	}
+	b.auditLog.Info(data["operation"].(string), data, outcome)
+}
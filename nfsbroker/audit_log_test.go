@@ -0,0 +1,111 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Audit log", func() {
+	var (
+		auditLog *lagertest.TestLogger
+		broker   *nfsbroker.Broker
+		ctx      context.Context
+	)
+
+	records := func() []lager.Data {
+		data := []lager.Data{}
+		for _, log := range auditLog.Logs() {
+			data = append(data, log.Data)
+		}
+		return data
+	}
+
+	provision := func(instanceID string) error {
+		_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+			ServiceID:        "service-id",
+			PlanID:           "Existing",
+			OrganizationGUID: "org-guid",
+			SpaceGUID:        "space-guid",
+			RawParameters:    json.RawMessage(`{"share":"server:/export"}`),
+		}, true)
+		return err
+	}
+
+	BeforeEach(func() {
+		identity := "cloudfoundry " + base64.StdEncoding.EncodeToString([]byte(`{"user_id":"user-guid"}`))
+		ctx = nfsbroker.WithRequestIdentity(context.Background(), "admin", identity)
+		auditLog = lagertest.NewTestLogger("audit")
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-audit-log"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize), nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		broker.SetAuditLog(auditLog)
+	})
+
+	It("records each request with its instance, space, caller and result", func() {
+		Expect(provision("instance-id")).To(Succeed())
+		_, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(broker.Unbind(ctx, "instance-id", "binding-id", brokerapi.UnbindDetails{})).To(Succeed())
+		_, err = broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(auditLog.LogMessages()).To(Equal([]string{"audit.provision", "audit.bind", "audit.unbind", "audit.deprovision"}))
+		Expect(records()[0]).To(Equal(lager.Data{
+			"operation":           "provision",
+			"instanceID":          "instance-id",
+			"organizationGUID":    "org-guid",
+			"spaceGUID":           "space-guid",
+			"actor":               "admin",
+			"originatingIdentity": "cloudfoundry eyJ1c2VyX2lkIjoidXNlci1ndWlkIn0=",
+			"originatingUser":     "user-guid",
+			"result":              nfsbroker.AuditResultSucceeded,
+		}))
+		for _, record := range records()[1:] {
+			Expect(record).To(HaveKeyWithValue("organizationGUID", "org-guid"))
+			Expect(record).To(HaveKeyWithValue("result", nfsbroker.AuditResultSucceeded))
+		}
+		Expect(records()[2]).To(HaveKeyWithValue("bindingID", "binding-id"))
+	})
+
+	It("records failed requests with their error", func() {
+		_, err := broker.Bind(ctx, "missing-instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})
+		Expect(err).To(HaveOccurred())
+
+		Expect(records()).To(HaveLen(1))
+		Expect(records()[0]).To(HaveKeyWithValue("result", nfsbroker.AuditResultFailed))
+		Expect(records()[0]).To(HaveKeyWithValue("error", err.Error()))
+		Expect(records()[0]).NotTo(HaveKey("organizationGUID"))
+	})
+
+	Context("when operations are asynchronous", func() {
+		var steps chan error
+
+		BeforeEach(func() {
+			steps = make(chan error)
+			broker.SetProvisionSteps(func(context.Context, string, nfsbroker.ServiceInstance) error {
+				return <-steps
+			})
+		})
+
+		It("records them as in progress, and then with their outcome", func() {
+			Expect(provision("instance-id")).To(Succeed())
+			Expect(records()).To(HaveLen(1))
+			Expect(records()[0]).To(HaveKeyWithValue("result", nfsbroker.AuditResultInProgress))
+
+			steps <- errors.New("share-not-created")
+			Eventually(auditLog.Logs).Should(HaveLen(2))
+			Expect(records()[1]).To(HaveKeyWithValue("result", nfsbroker.AuditResultFailed))
+			Expect(records()[1]).To(HaveKeyWithValue("error", "share-not-created"))
+			Expect(records()[1]).To(HaveKeyWithValue("originatingUser", "user-guid"))
+		})
+	})
+})
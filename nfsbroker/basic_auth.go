@@ -0,0 +1,40 @@
+package nfsbroker
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// BrokerCredential is a username and password that clients can authenticate to the broker with.
+type BrokerCredential struct {
+	Username string
+	Password string
+}
+
+// BasicAuth serves requests that carry any of the credentials returned by credentials with handler, so that operators
+// can add new credentials, register the broker with them and remove the old ones without a moment in which requests
+// fail.  credentials is called for each request, so that credentials read from files can change.  The username each
+// request authenticated with is logged, to show when old credentials are no longer used, and counted against its
+// rate limit when the request came through a RateLimiter.
+func BasicAuth(logger lager.Logger, credentials func() []BrokerCredential, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		authorized := false
+		for _, credential := range credentials() {
+			// every credential is compared, so that the time taken does not tell which one matched
+			if secureEqual(username, credential.Username) && secureEqual(password, credential.Password) {
+				authorized = true
+			}
+		}
+		if !ok || !authorized {
+			http.Error(w, "Not Authorized", http.StatusUnauthorized)
+			return
+		}
+		logger.Debug("authenticated", lager.Data{"username": username})
+		if !limitCredential(w, r, username) {
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
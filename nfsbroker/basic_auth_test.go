@@ -0,0 +1,59 @@
+package nfsbroker_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("BasicAuth", func() {
+	var (
+		logger      *lagertest.TestLogger
+		credentials []nfsbroker.BrokerCredential
+		handler     http.Handler
+	)
+
+	serve := func(username, password string) int {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/v2/catalog", nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-basic-auth")
+		credentials = []nfsbroker.BrokerCredential{
+			{Username: "admin", Password: "old-password"},
+			{Username: "admin-2", Password: "new-password"},
+		}
+		handler = nfsbroker.BasicAuth(logger, func() []nfsbroker.BrokerCredential { return credentials }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	})
+
+	It("serves requests with any of the credentials", func() {
+		Expect(serve("admin", "old-password")).To(Equal(http.StatusOK))
+		Expect(serve("admin-2", "new-password")).To(Equal(http.StatusOK))
+		Expect(logger).To(gbytes.Say(`"username":"admin-2"`))
+	})
+
+	It("refuses requests with other credentials or none", func() {
+		Expect(serve("admin", "new-password")).To(Equal(http.StatusUnauthorized))
+		Expect(serve("someone", "old-password")).To(Equal(http.StatusUnauthorized))
+		Expect(serve("", "")).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("checks the credentials current when each request arrives", func() {
+		credentials = []nfsbroker.BrokerCredential{{Username: "admin", Password: "rotated-password"}}
+		Expect(serve("admin", "old-password")).To(Equal(http.StatusUnauthorized))
+		Expect(serve("admin", "rotated-password")).To(Equal(http.StatusOK))
+	})
+})
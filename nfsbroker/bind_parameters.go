@@ -0,0 +1,133 @@
+package nfsbroker
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// IDRange is an inclusive range of user or group IDs.
+type IDRange struct {
+	Min int64
+	Max int64
+}
+
+// DefaultIDRange allows every valid user or group ID.
+var DefaultIDRange = IDRange{Min: 0, Max: math.MaxUint32 - 1}
+
+// ParseIDRange reads a range written as "min-max".
+func ParseIDRange(value string) (IDRange, error) {
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 {
+		return IDRange{}, fmt.Errorf("invalid ID range %q: expected min-max", value)
+	}
+	min, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil {
+		return IDRange{}, fmt.Errorf("invalid ID range %q: %w", value, err)
+	}
+	max, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+	if err != nil {
+		return IDRange{}, fmt.Errorf("invalid ID range %q: %w", value, err)
+	}
+	if min < DefaultIDRange.Min || max > DefaultIDRange.Max || min > max {
+		return IDRange{}, fmt.Errorf("invalid ID range %q: must be within %d-%d with min no greater than max", value, DefaultIDRange.Min, DefaultIDRange.Max)
+	}
+	return IDRange{Min: min, Max: max}, nil
+}
+
+func (r IDRange) contains(id int64) bool {
+	return id >= r.Min && id <= r.Max
+}
+
+// SetIDRanges limits the uid and gid bind parameters.  Both allow every valid ID by default.
+func (b *Broker) SetIDRanges(uids, gids IDRange) {
+	b.uidRange = uids
+	b.gidRange = gids
+}
+
+// validateBindParameters checks bind parameters before anything is stored, so that bad values are reported to the
+// user instead of failing when the app's container starts.  Unknown parameters are rejected, or only logged when
+// mounts are sloppy.
+func (b *Broker) validateBindParameters(logger lager.Logger, shareType ShareType, parameters map[string]interface{}) error {
+	problems := []string{}
+
+	for _, id := range []struct {
+		name    string
+		idRange IDRange
+	}{{"uid", b.uidRange}, {"gid", b.gidRange}} {
+		value, ok := parameters[id.name]
+		if !ok {
+			continue
+		}
+		if number, ok := parseID(value); !ok || !id.idRange.contains(number) {
+			problems = append(problems, fmt.Sprintf("%s must be a whole number from %d to %d", id.name, id.idRange.Min, id.idRange.Max))
+		}
+	}
+
+	if mount, ok := parameters["mount"].(string); ok && mount != "" {
+		if !path.IsAbs(mount) || path.Clean(mount) != strings.TrimSuffix(mount, "/") || mount == "/" {
+			problems = append(problems, "mount must be an absolute path to a directory other than /, without . or .. elements")
+		}
+	}
+
+	known := append(parameterNames(b.bindParameterSpecs()), "share")
+	known = append(known, shareType.bindCredentialNames()...)
+	known = append(known, b.config.mount.Allowed...)
+	unknown := []string{}
+	for name := range parameters {
+		if !inArray(known, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	if len(unknown) > 0 {
+		if b.sloppyMount(parameters) {
+			logger.Info("ignoring-unknown-bind-parameters", lager.Data{"parameters": unknown})
+		} else {
+			problems = append(problems, "unknown parameters: "+strings.Join(unknown, ", "))
+		}
+	}
+
+	if len(problems) > 0 {
+		err := fmt.Errorf("invalid bind parameters: %s", strings.Join(problems, "; "))
+		return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "invalid-bind-parameters")
+	}
+	return nil
+}
+
+// parseID reads a user or group ID given as a JSON number or a string.
+func parseID(value interface{}) (int64, bool) {
+	switch value := value.(type) {
+	case float64:
+		if value != math.Trunc(value) || value < 0 || value > math.MaxUint32 {
+			return 0, false
+		}
+		return int64(value), true
+	case string:
+		number, err := strconv.ParseInt(value, 10, 64)
+		return number, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// sloppyMount reports whether mounts made with the given bind parameters will be sloppy, which makes the driver
+// ignore options it does not know.
+func (b *Broker) sloppyMount(parameters map[string]interface{}) bool {
+	value, ok := b.config.mount.Forced["sloppy_mount"]
+	if !ok {
+		value = b.config.mount.Options["sloppy_mount"]
+		if requested, ok := parameters["sloppy_mount"]; ok && inArray(b.config.mount.Allowed, "sloppy_mount") {
+			value = b.config.mount.uniformKeyData("sloppy_mount", requested)
+		}
+	}
+	sloppy, err := strconv.ParseBool(value)
+	return err == nil && sloppy
+}
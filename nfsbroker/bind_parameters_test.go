@@ -0,0 +1,103 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"net/http"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("ParseIDRange", func() {
+	It("reads min-max", func() {
+		idRange, err := nfsbroker.ParseIDRange("1000-60000")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(idRange).To(Equal(nfsbroker.IDRange{Min: 1000, Max: 60000}))
+	})
+
+	It("rejects malformed ranges", func() {
+		for _, value := range []string{"1000", "a-b", "60000-1000", "-1-10", "0-4294967295"} {
+			_, err := nfsbroker.ParseIDRange(value)
+			Expect(err).To(HaveOccurred(), value)
+		}
+	})
+})
+
+var _ = Describe("Bind parameter validation", func() {
+	var (
+		broker    *nfsbroker.Broker
+		fakeStore *nfsbrokerfakes.FakeStore
+		logger    *lagertest.TestLogger
+		mounts    *nfsbroker.ConfigDetails
+	)
+
+	BeforeEach(func() {
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{PlanID: "Existing", Share: "server:/some-share"}, nil)
+		logger = lagertest.NewTestLogger("test-bind-parameters")
+		mounts = nfsbroker.NewNfsBrokerConfigDetails()
+		mounts.ReadConf("uid,gid", "")
+	})
+
+	JustBeforeEach(func() {
+		broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, fakeStore, nfsbroker.NewNfsBrokerConfig(mounts))
+		broker.SetIDRanges(nfsbroker.IDRange{Min: 1000, Max: 60000}, nfsbroker.DefaultIDRange)
+	})
+
+	bind := func(parameters map[string]interface{}) error {
+		_, err := broker.Bind(context.TODO(), "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid", Parameters: parameters})
+		return err
+	}
+
+	expectInvalid := func(err error, problem string) {
+		Expect(err).To(MatchError(ContainSubstring(problem)))
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusBadRequest))
+		Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(0))
+	}
+
+	It("accepts valid parameters", func() {
+		Expect(bind(map[string]interface{}{"uid": "1000", "gid": float64(0), "mount": "/var/data/", "readonly": true})).To(Succeed())
+	})
+
+	It("rejects uids and gids that are not whole numbers", func() {
+		expectInvalid(bind(map[string]interface{}{"uid": "root"}), "uid must be a whole number from 1000 to 60000")
+		expectInvalid(bind(map[string]interface{}{"gid": 1.5}), "gid must be a whole number from 0 to 4294967294")
+	})
+
+	It("rejects uids outside the configured range", func() {
+		expectInvalid(bind(map[string]interface{}{"uid": float64(0)}), "uid must be a whole number from 1000 to 60000")
+	})
+
+	It("rejects mount paths that are not absolute", func() {
+		expectInvalid(bind(map[string]interface{}{"mount": "data"}), "mount must be an absolute path")
+		expectInvalid(bind(map[string]interface{}{"mount": "/var/../etc"}), "mount must be an absolute path")
+		expectInvalid(bind(map[string]interface{}{"mount": "/"}), "mount must be an absolute path")
+	})
+
+	It("rejects unknown parameters", func() {
+		expectInvalid(bind(map[string]interface{}{"allow_root": true, "nfs_uid": "1"}), "unknown parameters: allow_root, nfs_uid")
+	})
+
+	It("reports every problem at once", func() {
+		err := bind(map[string]interface{}{"uid": "root", "mount": "data"})
+		Expect(err).To(MatchError("invalid bind parameters: uid must be a whole number from 1000 to 60000; mount must be an absolute path to a directory other than /, without . or .. elements"))
+	})
+
+	Context("when mounts are sloppy", func() {
+		BeforeEach(func() {
+			mounts.ReadConf("uid,gid", "sloppy_mount:true")
+		})
+
+		It("only logs unknown parameters", func() {
+			Expect(bind(map[string]interface{}{"allow_root": true})).To(Succeed())
+			Expect(logger).To(gbytes.Say(`ignoring-unknown-bind-parameters.*allow_root`))
+		})
+	})
+})
@@ -0,0 +1,156 @@
+package nfsbroker
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/nfsbroker/scheduler"
+)
+
+// BindingInstanceLookup finds the service instance a binding was made for, such as through the Cloud Controller, for
+// bindings stored before the broker recorded their instances.
+//
+//go:generate counterfeiter -o ../nfsbrokerfakes/fake_binding_instance_lookup.go . BindingInstanceLookup
+type BindingInstanceLookup interface {
+	BindingInstance(ctx context.Context, bindingID string) (string, error)
+}
+
+// SetBindingInstanceLookup lets orphaned binding cleanup find the instances of bindings stored without one.
+func (b *Broker) SetBindingInstanceLookup(lookup BindingInstanceLookup) {
+	b.bindingInstanceLookup = lookup
+}
+
+// RemoveOrphanedBindings deletes stored bindings whose service instance no longer exists, returning the IDs of the
+// bindings it removed.  The instances of bindings stored without an instance ID are looked up, when the broker has a
+// BindingInstanceLookup; otherwise, or when the lookup fails, they are left alone.
+func (b *Broker) RemoveOrphanedBindings(ctx context.Context) (_ []string, e error) {
+	logger := b.logger.Session("remove-orphaned-bindings")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	resolved, err := b.lookUpBindingInstances(ctx, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	bindingInstances, err := b.store.ListBindingInstances(ctx)
+	if err != nil {
+		logger.Error("failed-to-list-bindings", err)
+		return nil, err
+	}
+
+	bindingIDs := make([]string, 0, len(bindingInstances))
+	for bindingID := range bindingInstances {
+		bindingIDs = append(bindingIDs, bindingID)
+	}
+	sort.Strings(bindingIDs)
+
+	removed := []string{}
+	defer func() {
+		if len(removed) == 0 {
+			return
+		}
+		out := b.store.Save(logger)
+		if e == nil {
+			e = out
+		}
+	}()
+
+	instanceExists := map[string]bool{}
+	unknown := 0
+	for _, bindingID := range bindingIDs {
+		instanceID := bindingInstances[bindingID]
+		if instanceID == "" {
+			instanceID = resolved[bindingID]
+		}
+		if instanceID == "" {
+			unknown++
+			continue
+		}
+
+		exists, checked := instanceExists[instanceID]
+		if !checked {
+			_, err := b.store.RetrieveInstanceDetails(ctx, instanceID)
+			switch {
+			case err == nil:
+				exists = true
+			case errors.Is(err, ErrInstanceNotFound):
+				exists = false
+			default:
+				logger.Error("failed-to-retrieve-instance", err, lager.Data{"instanceID": instanceID})
+				return removed, err
+			}
+			instanceExists[instanceID] = exists
+		}
+		if exists {
+			continue
+		}
+
+		if err := b.store.DeleteBindingDetails(ctx, bindingID); err != nil {
+			logger.Error("failed-to-delete-orphaned-binding", err, lager.Data{"bindingID": bindingID, "instanceID": instanceID})
+			return removed, err
+		}
+		logger.Info("removed-orphaned-binding", lager.Data{"bindingID": bindingID, "instanceID": instanceID})
+		removed = append(removed, bindingID)
+	}
+
+	if unknown > 0 {
+		logger.Info("skipped-bindings-without-instance-id", lager.Data{"count": unknown})
+	}
+	return removed, nil
+}
+
+// lookUpBindingInstances looks up the instances of bindings stored without an instance ID.  The lookups are made
+// without holding the broker's lock, since they may be slow.
+func (b *Broker) lookUpBindingInstances(ctx context.Context, logger lager.Logger) (map[string]string, error) {
+	if b.bindingInstanceLookup == nil {
+		return nil, nil
+	}
+
+	b.mutex.Lock()
+	bindingInstances, err := b.store.ListBindingInstances(ctx)
+	b.mutex.Unlock()
+	if err != nil {
+		logger.Error("failed-to-list-bindings", err)
+		return nil, err
+	}
+
+	bindingIDs := []string{}
+	for bindingID, instanceID := range bindingInstances {
+		if instanceID == "" {
+			bindingIDs = append(bindingIDs, bindingID)
+		}
+	}
+	sort.Strings(bindingIDs)
+
+	resolved := map[string]string{}
+	for _, bindingID := range bindingIDs {
+		instanceID, err := b.bindingInstanceLookup.BindingInstance(ctx, bindingID)
+		if err != nil {
+			logger.Info("failed-to-look-up-binding-instance", lager.Data{"bindingID": bindingID, "error": err.Error()})
+			continue
+		}
+		resolved[bindingID] = instanceID
+	}
+	return resolved, nil
+}
+
+const OrphanedBindingCleanupJob = "orphaned-binding-cleanup"
+
+// OrphanedBindingCleanup returns a scheduler job that removes orphaned bindings every interval.
+func (b *Broker) OrphanedBindingCleanup(interval time.Duration) scheduler.Job {
+	return scheduler.Job{
+		Name:     OrphanedBindingCleanupJob,
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			_, err := b.RemoveOrphanedBindings(ctx)
+			return err
+		},
+	}
+}
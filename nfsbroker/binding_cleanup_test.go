@@ -0,0 +1,141 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Orphaned binding cleanup", func() {
+	var (
+		broker    *nfsbroker.Broker
+		logger    *lagertest.TestLogger
+		fakeStore *nfsbrokerfakes.FakeStore
+		fakeClock *fakeclock.FakeClock
+		removed   []string
+		err       error
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-broker")
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		broker = nfsbroker.New(
+			logger,
+			"service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{},
+			fakeClock,
+			fakeStore,
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()),
+		)
+
+		fakeStore.ListBindingInstancesReturns(map[string]string{
+			"binding-live":    "instance-live",
+			"binding-orphan":  "instance-gone",
+			"binding-orphan2": "instance-gone",
+			"binding-legacy":  "",
+		}, nil)
+		fakeStore.RetrieveInstanceDetailsStub = func(ctx context.Context, id string) (nfsbroker.ServiceInstance, error) {
+			if id == "instance-live" {
+				return nfsbroker.ServiceInstance{}, nil
+			}
+			return nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound
+		}
+	})
+
+	Describe("RemoveOrphanedBindings", func() {
+		JustBeforeEach(func() {
+			removed, err = broker.RemoveOrphanedBindings(context.TODO())
+		})
+
+		It("deletes bindings whose instance no longer exists", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal([]string{"binding-orphan", "binding-orphan2"}))
+			Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(2))
+			_, id := fakeStore.DeleteBindingDetailsArgsForCall(0)
+			Expect(id).To(Equal("binding-orphan"))
+		})
+
+		It("looks each instance up once", func() {
+			Expect(fakeStore.RetrieveInstanceDetailsCallCount()).To(Equal(2))
+		})
+
+		It("logs each removal and saves the store", func() {
+			Expect(logger.Buffer()).To(gbytes.Say("removed-orphaned-binding"))
+			Expect(fakeStore.SaveCallCount()).To(Equal(1))
+		})
+
+		Context("when an instance cannot be looked up", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, errors.New("database is down"))
+				fakeStore.RetrieveInstanceDetailsStub = nil
+			})
+
+			It("stops without deleting anything", func() {
+				Expect(err).To(MatchError("database is down"))
+				Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the instances of bindings stored without one can be looked up", func() {
+			var fakeLookup *nfsbrokerfakes.FakeBindingInstanceLookup
+
+			BeforeEach(func() {
+				fakeLookup = &nfsbrokerfakes.FakeBindingInstanceLookup{}
+				fakeLookup.BindingInstanceReturns("instance-gone", nil)
+				broker.SetBindingInstanceLookup(fakeLookup)
+			})
+
+			It("deletes them too when their instance no longer exists", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(removed).To(Equal([]string{"binding-legacy", "binding-orphan", "binding-orphan2"}))
+				Expect(fakeLookup.BindingInstanceCallCount()).To(Equal(1))
+				_, bindingID := fakeLookup.BindingInstanceArgsForCall(0)
+				Expect(bindingID).To(Equal("binding-legacy"))
+			})
+
+			Context("when the lookup fails", func() {
+				BeforeEach(func() {
+					fakeLookup.BindingInstanceReturns("", errors.New("cloud controller is down"))
+				})
+
+				It("leaves them alone", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(removed).To(Equal([]string{"binding-orphan", "binding-orphan2"}))
+					Expect(logger.Buffer()).To(gbytes.Say("failed-to-look-up-binding-instance"))
+				})
+			})
+		})
+
+		Context("when there are no orphans", func() {
+			BeforeEach(func() {
+				fakeStore.ListBindingInstancesReturns(map[string]string{"binding-live": "instance-live"}, nil)
+			})
+
+			It("does not touch the store", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(removed).To(BeEmpty())
+				Expect(fakeStore.SaveCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("OrphanedBindingCleanup", func() {
+		It("runs the cleanup as a scheduled job", func() {
+			job := broker.OrphanedBindingCleanup(time.Hour)
+			Expect(job.Name).To(Equal(nfsbroker.OrphanedBindingCleanupJob))
+			Expect(job.Interval).To(Equal(time.Hour))
+			Expect(job.Run(context.TODO())).To(Succeed())
+			Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(2))
+		})
+	})
+})
@@ -0,0 +1,214 @@
+package nfsbroker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// UnbindStep does cleanup work for a binding before it is deleted.
+type UnbindStep func(ctx context.Context, instanceID, bindingID string, details brokerapi.BindDetails) error
+
+// SetUnbindSteps configures steps that run before a binding is deleted.  If a step fails the binding is kept, so
+// that unbinding can be retried.  When there are steps, unbinding is asynchronous if the platform accepts incomplete
+// operations.
+func (b *Broker) SetUnbindSteps(steps ...UnbindStep) {
+	b.unbindSteps = steps
+}
+
+// UnbindSpec describes the outcome of an unbind request.
+type UnbindSpec struct {
+	IsAsync       bool
+	OperationData string
+}
+
+// AsyncUnbind unbinds like Unbind, but runs the unbind steps in the background when asyncAllowed is set.  The broker
+// API library only supports synchronous unbinds, so NewBindingOperationHandler calls this for requests that accept
+// incomplete operations.
+func (b *Broker) AsyncUnbind(ctx context.Context, instanceID, bindingID string, details brokerapi.UnbindDetails, asyncAllowed bool) (spec UnbindSpec, e error) {
+	logger := b.logger.Session("unbind", identityData(ctx))
+	logger.Info("start", lager.Data{"bindingID": bindingID, "asyncAllowed": asyncAllowed})
+	defer logger.Info("end")
+	record := auditRequest(ctx, UnbindOperation, instanceID, bindingID)
+	defer func() { b.audit(record, spec.IsAsync, e) }()
+	defer func() { e = b.deletionError(e) }()
+
+	if err := b.lockFor(ctx); err != nil {
+		return UnbindSpec{}, err
+	}
+	defer b.mutex.Unlock()
+	defer func() {
+		out := b.store.Save(logger)
+		if e == nil {
+			e = out
+		}
+	}()
+
+	instanceDetails, err := b.store.RetrieveInstanceDetails(ctx, instanceID)
+	if err != nil {
+		return UnbindSpec{}, err
+	}
+	record.instance(instanceDetails)
+	if err := b.checkConcurrency(ctx, instanceID, ProvisionOperation, DeprovisionOperation); err != nil {
+		return UnbindSpec{}, err
+	}
+	bindDetails, err := b.store.RetrieveBindingDetails(ctx, bindingID)
+	if err != nil {
+		return UnbindSpec{}, err
+	}
+	if err := b.checkBindingOwner(ctx, instanceID, bindingID); err != nil {
+		return UnbindSpec{}, err
+	}
+
+	unbinding, err := b.inProgress(ctx, bindingID, UnbindOperation)
+	if err != nil {
+		return UnbindSpec{}, err
+	}
+	if unbinding || (len(b.unbindSteps) > 0 && asyncAllowed) {
+		if !asyncAllowed {
+			return UnbindSpec{}, brokerapi.ErrAsyncRequired
+		}
+		if !unbinding {
+			err = b.store.SaveOperation(committed(ctx), bindingID, Operation{Type: UnbindOperation, State: brokerapi.InProgress})
+			if err != nil {
+				return UnbindSpec{}, err
+			}
+			b.runInBackground(func() { b.runAsyncUnbind(logger, instanceID, bindingID, bindDetails, record) })
+		}
+		return UnbindSpec{IsAsync: true, OperationData: UnbindOperation}, nil
+	}
+
+	if err := b.runUnbindSteps(ctx, logger, instanceID, bindingID, bindDetails); err != nil {
+		return UnbindSpec{}, err
+	}
+	if err := b.store.DeleteBindingDetails(committed(ctx), bindingID); err != nil {
+		return UnbindSpec{}, err
+	}
+	return UnbindSpec{}, nil
+}
+
+func (b *Broker) runUnbindSteps(ctx context.Context, logger lager.Logger, instanceID, bindingID string, details brokerapi.BindDetails) error {
+	if isProbe(ctx) {
+		return nil
+	}
+	for i, step := range b.unbindSteps {
+		if err := step(ctx, instanceID, bindingID, details); err != nil {
+			logger.Error("unbind-step-failed", err, lager.Data{"step": i})
+			return err
+		}
+	}
+	return nil
+}
+
+// runAsyncUnbind runs the unbind steps of a binding and deletes it if they succeed, recording the outcome as the
+// binding's operation.
+func (b *Broker) runAsyncUnbind(logger lager.Logger, instanceID, bindingID string, details brokerapi.BindDetails, record *auditRecord) {
+	logger = logger.Session("run-async-unbind")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	ctx := context.Background()
+	operation := Operation{Type: UnbindOperation, State: brokerapi.Succeeded}
+	stepErr := b.runUnbindSteps(ctx, logger, instanceID, bindingID, details)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	defer func() {
+		if err := b.store.Save(logger); err != nil {
+			logger.Error("failed-to-save-state", err)
+		}
+	}()
+
+	if stepErr == nil {
+		stepErr = b.store.DeleteBindingDetails(ctx, bindingID)
+	}
+	if stepErr != nil {
+		operation.State = brokerapi.Failed
+		operation.Description = stepErr.Error()
+	}
+	if err := b.store.SaveOperation(ctx, bindingID, operation); err != nil {
+		logger.Error("failed-to-save-operation", err)
+	}
+	b.auditOutcome(record, operation)
+}
+
+// LastBindingOperation reports the progress of an asynchronous unbind.  Bindings that have been deleted are reported
+// as missing, which platforms take to mean that the unbind succeeded.
+func (b *Broker) LastBindingOperation(ctx context.Context, instanceID, bindingID, operationData string) (_ brokerapi.LastOperation, e error) {
+	logger := b.logger.Session("last-binding-operation", identityData(ctx)).WithData(lager.Data{"instanceID": instanceID, "bindingID": bindingID})
+	logger.Info("start")
+	defer logger.Info("end")
+	defer func() { e = brokerError(e) }()
+
+	if operationData != UnbindOperation {
+		return brokerapi.LastOperation{}, errors.New("unrecognized operationData")
+	}
+
+	if err := b.lockFor(ctx); err != nil {
+		return brokerapi.LastOperation{}, err
+	}
+	defer b.mutex.Unlock()
+
+	if _, err := b.store.RetrieveBindingDetails(ctx, bindingID); err != nil {
+		return brokerapi.LastOperation{}, err
+	}
+	operation, err := b.store.RetrieveOperation(ctx, bindingID)
+	if err != nil {
+		return brokerapi.LastOperation{}, err
+	}
+	if operation.Type != UnbindOperation {
+		err := fmt.Errorf("service binding %s is not being unbound", bindingID)
+		return brokerapi.LastOperation{}, brokerapi.NewFailureResponse(err, http.StatusBadRequest, "no-unbind-operation")
+	}
+	return brokerapi.LastOperation{State: operation.State, Description: operation.Description}, nil
+}
+
+// NewBindingOperationHandler serves unbind requests that accept incomplete operations, and
+// GET /v2/service_instances/:instance_id/service_bindings/:binding_id/last_operation, which the broker API library
+// does not, and passes every other request on to next.  It does no authentication of its own.
+func NewBindingOperationHandler(logger lager.Logger, broker *Broker, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, ServiceInstancesPath), "/")
+		isBinding := strings.HasPrefix(r.URL.Path, ServiceInstancesPath) && len(parts) >= 3 &&
+			parts[0] != "" && parts[1] == "service_bindings" && parts[2] != ""
+
+		switch {
+		case isBinding && len(parts) == 3 && r.Method == "DELETE" && r.URL.Query().Get("accepts_incomplete") == "true":
+			details := brokerapi.UnbindDetails{PlanID: r.URL.Query().Get("plan_id"), ServiceID: r.URL.Query().Get("service_id")}
+			spec, err := broker.AsyncUnbind(r.Context(), parts[0], parts[2], details, true)
+			switch {
+			case err != nil:
+				writeBrokerError(w, logger, err)
+			case spec.IsAsync:
+				writeJSON(w, http.StatusAccepted, map[string]string{"operation": spec.OperationData})
+			default:
+				writeJSON(w, http.StatusOK, map[string]string{})
+			}
+		case isBinding && len(parts) == 4 && parts[3] == "last_operation" && r.Method == "GET":
+			operation, err := broker.LastBindingOperation(r.Context(), parts[0], parts[2], r.URL.Query().Get("operation"))
+			if err != nil {
+				writeBrokerError(w, logger, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, brokerapi.LastOperationResponse{State: operation.State, Description: operation.Description})
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// writeBrokerError responds to a failed request the way the broker API library does.
+func writeBrokerError(w http.ResponseWriter, logger lager.Logger, err error) {
+	var failure *brokerapi.FailureResponse
+	if errors.As(err, &failure) {
+		writeJSON(w, failure.ValidatedStatusCode(logger), failure.ErrorResponse())
+		return
+	}
+	logger.Error("request-failed", err)
+	writeJSON(w, http.StatusInternalServerError, map[string]string{"description": err.Error()})
+}
@@ -0,0 +1,153 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("BindingOperationHandler", func() {
+	var (
+		broker     *nfsbroker.Broker
+		handler    http.Handler
+		fakeStore  *nfsbrokerfakes.FakeStore
+		recorder   *httptest.ResponseRecorder
+		nextCalled bool
+		method     string
+		path       string
+		stepErr    error
+	)
+
+	BeforeEach(func() {
+		logger := lagertest.NewTestLogger("test-binding-operation-handler")
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, fakeStore,
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		stepErr = nil
+		broker.SetUnbindSteps(func(ctx context.Context, instanceID, bindingID string, details brokerapi.BindDetails) error {
+			return stepErr
+		})
+		nextCalled = false
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nextCalled = true
+		})
+		handler = nfsbroker.NewBindingOperationHandler(logger, broker, next)
+		recorder = httptest.NewRecorder()
+		method = "DELETE"
+		path = "/v2/service_instances/instance-id/service_bindings/binding-id?accepts_incomplete=true"
+	})
+
+	JustBeforeEach(func() {
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	})
+
+	It("unbinds asynchronously", func() {
+		Expect(recorder.Code).To(Equal(http.StatusAccepted))
+		Expect(recorder.Body.String()).To(MatchJSON(`{"operation":"unbind"}`))
+		Expect(nextCalled).To(BeFalse())
+		Eventually(fakeStore.DeleteBindingDetailsCallCount).Should(Equal(1))
+	})
+
+	It("records the operation's progress under the binding", func() {
+		Eventually(fakeStore.SaveOperationCallCount).Should(Equal(2))
+		_, id, operation := fakeStore.SaveOperationArgsForCall(0)
+		Expect(id).To(Equal("binding-id"))
+		Expect(operation).To(Equal(nfsbroker.Operation{Type: nfsbroker.UnbindOperation, State: brokerapi.InProgress}))
+		_, _, operation = fakeStore.SaveOperationArgsForCall(1)
+		Expect(operation.State).To(Equal(brokerapi.Succeeded))
+	})
+
+	Context("when a step fails", func() {
+		BeforeEach(func() {
+			stepErr = errors.New("mounts are still in use")
+		})
+
+		It("records the failure and keeps the binding", func() {
+			Eventually(fakeStore.SaveOperationCallCount).Should(Equal(2))
+			_, _, operation := fakeStore.SaveOperationArgsForCall(1)
+			Expect(operation).To(Equal(nfsbroker.Operation{Type: nfsbroker.UnbindOperation, State: brokerapi.Failed, Description: "mounts are still in use"}))
+			Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the binding does not exist", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveBindingDetailsReturns(brokerapi.BindDetails{}, nfsbroker.ErrBindingNotFound)
+		})
+
+		It("reports it as gone", func() {
+			Expect(recorder.Code).To(Equal(http.StatusGone))
+			Expect(fakeStore.SaveOperationCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the request does not accept incomplete operations", func() {
+		BeforeEach(func() {
+			path = "/v2/service_instances/instance-id/service_bindings/binding-id"
+		})
+
+		It("passes the request on", func() {
+			Expect(nextCalled).To(BeTrue())
+		})
+	})
+
+	Context("when polling the unbind", func() {
+		BeforeEach(func() {
+			method = "GET"
+			path = "/v2/service_instances/instance-id/service_bindings/binding-id/last_operation?operation=unbind"
+			fakeStore.RetrieveOperationReturns(nfsbroker.Operation{Type: nfsbroker.UnbindOperation, State: brokerapi.Failed, Description: "mounts are still in use"}, nil)
+		})
+
+		It("reports the recorded operation", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"state":"failed","description":"mounts are still in use"}`))
+			_, id := fakeStore.RetrieveOperationArgsForCall(0)
+			Expect(id).To(Equal("binding-id"))
+		})
+
+		Context("and the binding has been deleted", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveBindingDetailsReturns(brokerapi.BindDetails{}, nfsbroker.ErrBindingNotFound)
+			})
+
+			It("reports it as gone", func() {
+				Expect(recorder.Code).To(Equal(http.StatusGone))
+			})
+		})
+
+		Context("and no unbind was started", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveOperationReturns(nfsbroker.Operation{}, nil)
+			})
+
+			It("rejects the request", func() {
+				Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+			})
+		})
+	})
+
+	Context("when an unbind is already in progress", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveOperationReturns(nfsbroker.Operation{Type: nfsbroker.UnbindOperation, State: brokerapi.InProgress}, nil)
+		})
+
+		It("does not start another", func() {
+			Expect(recorder.Code).To(Equal(http.StatusAccepted))
+			Expect(fakeStore.SaveOperationCallCount()).To(Equal(0))
+		})
+
+		It("refuses synchronous unbinds", func() {
+			err := broker.Unbind(context.Background(), "instance-id", "binding-id", brokerapi.UnbindDetails{})
+			Expect(err).To(Equal(brokerapi.ErrAsyncRequired))
+		})
+	})
+})
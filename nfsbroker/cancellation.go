@@ -0,0 +1,34 @@
+package nfsbroker
+
+import "context"
+
+// lockFor takes the broker lock on behalf of a request.  It gives up once the request's context is done, so that
+// requests the platform has stopped waiting for do not queue up behind a slow one.
+func (b *Broker) lockFor(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		b.mutex.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			b.mutex.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+// committed detaches ctx from the request's cancellation once a request starts changing stored records.  A platform
+// that gives up on the request from then on cannot leave the change half made; the request finishes as if it were a
+// background job, and a retry finds the outcome.  Values carried by ctx, such as the request identity, are kept.
+func committed(ctx context.Context) context.Context {
+	return context.WithoutCancel(ctx)
+}
@@ -0,0 +1,137 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Request cancellation", func() {
+	var (
+		broker    *nfsbroker.Broker
+		fakeStore *nfsbrokerfakes.FakeStore
+		ctx       context.Context
+		cancel    context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		logger := lagertest.NewTestLogger("test-cancellation")
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, fakeStore,
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		ctx, cancel = context.WithCancel(context.Background())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	Context("when the client has gone away before the request starts", func() {
+		BeforeEach(func() {
+			cancel()
+		})
+
+		It("does not touch the store", func() {
+			_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(fakeStore.RetrieveInstanceDetailsCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the client goes away while the request waits for another", func() {
+		var release chan struct{}
+
+		BeforeEach(func() {
+			release = make(chan struct{})
+			fakeStore.RetrieveInstanceDetailsStub = func(context.Context, string) (nfsbroker.ServiceInstance, error) {
+				<-release
+				return nfsbroker.ServiceInstance{}, nil
+			}
+			go broker.GetInstance(context.Background(), "slow-instance-id")
+			Eventually(fakeStore.RetrieveInstanceDetailsCallCount).Should(Equal(1))
+		})
+
+		It("stops waiting", func() {
+			errs := make(chan error, 1)
+			go func() {
+				_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+				errs <- err
+			}()
+			cancel()
+			Eventually(errs).Should(Receive(MatchError(context.Canceled)))
+
+			close(release)
+			Eventually(func() error {
+				_, err := broker.GetInstance(context.Background(), "instance-id")
+				return err
+			}).Should(Succeed())
+		})
+	})
+
+	Context("when the client goes away after the broker starts storing an instance", func() {
+		BeforeEach(func() {
+			broker.SetProvisionSteps(func(context.Context, string, nfsbroker.ServiceInstance) error {
+				return nil
+			})
+			fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound)
+			fakeStore.CreateInstanceDetailsStub = func(context.Context, string, nfsbroker.ServiceInstance) error {
+				cancel()
+				return nil
+			}
+		})
+
+		It("finishes recording the provision", func() {
+			parameters, _ := json.Marshal(map[string]interface{}{"share": "server/export"})
+			_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{PlanID: "Existing", RawParameters: parameters}, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeStore.SaveOperationCallCount()).To(BeNumerically(">=", 1))
+			saveCtx, _, operation := fakeStore.SaveOperationArgsForCall(0)
+			Expect(operation.State).To(Equal(brokerapi.InProgress))
+			Expect(saveCtx.Err()).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("when the client goes away during a SQL query", func() {
+		var release chan struct{}
+
+		BeforeEach(func() {
+			release = make(chan struct{})
+		})
+
+		AfterEach(func() {
+			close(release)
+		})
+
+		It("does not report the store as unavailable", func() {
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			mock.ExpectQuery("SELECT").WillReturnError(errors.New("too late"))
+			fakeConnection := &nfsbrokerfakes.FakeSqlConnection{}
+			fakeConnection.QueryRowStub = func(query string, args ...interface{}) *sql.Row {
+				<-release
+				return db.QueryRow(query, args...)
+			}
+			sqlStore := nfsbroker.SqlStore{Database: fakeConnection, StoreType: "mysql", MaxValueSize: nfsbroker.DefaultMaxValueSize}
+
+			go func() {
+				defer GinkgoRecover()
+				Eventually(fakeConnection.QueryRowCallCount).Should(Equal(1))
+				cancel()
+			}()
+			_, err = sqlStore.RetrieveInstanceDetails(ctx, "instance-id")
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(errors.Is(err, nfsbroker.ErrStoreUnavailable)).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,163 @@
+package nfsbroker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pivotal-cf/brokerapi"
+	"gopkg.in/yaml.v2"
+)
+
+// CatalogService describes a service the broker offers, as loaded from a catalog file.
+type CatalogService struct {
+	ID          string                     `json:"id"`
+	Name        string                     `json:"name"`
+	Description string                     `json:"description"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Metadata    *brokerapi.ServiceMetadata `json:"metadata,omitempty"`
+	Plans       []Plan                     `json:"plans"`
+
+	// Driver is the volume driver named in the volume mounts of the service's bindings, in place of the share type's.
+	// A broker can offer a service for each of two drivers, such as the legacy and current NFS drivers, so that apps
+	// move between them by rebinding to an instance of the other service.
+	Driver string `json:"driver,omitempty"`
+
+	// ShareType is the name of the kind of share the service offers, such as "smb", in place of the broker's.
+	ShareType string `json:"share_type,omitempty"`
+}
+
+// ParseCatalog reads a catalog in the form of a service broker API catalog response, as YAML or JSON.  The catalog
+// must hold at least one service; services need distinct IDs and names, and plans distinct IDs across services.
+func ParseCatalog(data []byte) ([]CatalogService, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("invalid catalog: %w", err)
+	}
+	data, err := json.Marshal(jsonCompatible(document))
+	if err != nil {
+		return nil, fmt.Errorf("invalid catalog: %w", err)
+	}
+
+	var catalog struct {
+		Services []CatalogService `json:"services"`
+	}
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("invalid catalog: %w", err)
+	}
+	if len(catalog.Services) == 0 {
+		return nil, fmt.Errorf("invalid catalog: expected at least one service")
+	}
+
+	ids := map[string]bool{}
+	names := map[string]bool{}
+	planIDs := map[string]string{}
+	for _, service := range catalog.Services {
+		if service.ID == "" || service.Name == "" || service.Description == "" {
+			return nil, fmt.Errorf("invalid catalog: every service needs an id, a name and a description")
+		}
+		if ids[service.ID] || names[service.Name] {
+			return nil, fmt.Errorf("invalid catalog: service %q is defined more than once", service.Name)
+		}
+		ids[service.ID] = true
+		names[service.Name] = true
+		if service.ShareType != "" {
+			if _, err := LookupShareType(service.ShareType); err != nil {
+				return nil, fmt.Errorf("invalid catalog: service %q: %w", service.Name, err)
+			}
+		}
+
+		if err := validatePlans(service.Plans); err != nil {
+			return nil, fmt.Errorf("invalid catalog: service %q: %w", service.Name, err)
+		}
+		for _, plan := range stablePlans(service.Plans, service.ID) {
+			if plan.Description == "" {
+				return nil, fmt.Errorf("invalid catalog: plan %q needs a description", plan.Name)
+			}
+			if other, ok := planIDs[plan.ID]; ok {
+				return nil, fmt.Errorf("invalid catalog: plan %q of service %q has the same id as a plan of service %q", plan.Name, service.Name, other)
+			}
+			planIDs[plan.ID] = service.Name
+		}
+	}
+	return catalog.Services, nil
+}
+
+// jsonCompatible converts the maps decoded from YAML, which can have keys of any type, into maps with string keys.
+func jsonCompatible(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := map[string]interface{}{}
+		for k, v := range value {
+			converted[fmt.Sprint(k)] = jsonCompatible(v)
+		}
+		return converted
+	case []interface{}:
+		for i, v := range value {
+			value[i] = jsonCompatible(v)
+		}
+		return value
+	default:
+		return value
+	}
+}
+
+// SetCatalogService configures the broker to offer the service and plans of a catalog file in place of its own
+// service name, ID, description, tags and plans.
+func (b *Broker) SetCatalogService(service CatalogService) {
+	b.SetCatalogServices([]CatalogService{service})
+}
+
+// SetCatalogServices configures the broker to offer each of the services of a catalog file.  The first service
+// takes the place of the broker's own.
+func (b *Broker) SetCatalogServices(services []CatalogService) {
+	b.static = staticState{ServiceName: services[0].Name, ServiceId: services[0].ID}
+	b.plans = nil
+	for _, service := range services {
+		b.plans = append(b.plans, stablePlans(service.Plans, service.ID)...)
+	}
+	b.catalogServices = services
+}
+
+// catalogService looks up one of the services of the broker's catalog file by ID.
+func (b *Broker) catalogService(id string) (CatalogService, bool) {
+	for _, service := range b.catalogServices {
+		if service.ID == id {
+			return service, true
+		}
+	}
+	return CatalogService{}, false
+}
+
+// shareTypeFor returns the share type of the service's instances, with the service's driver.  Services that name no
+// share type, and instances of services the broker does not know, have the broker's.
+func (b *Broker) shareTypeFor(serviceID string) ShareType {
+	shareType := b.shareType
+	service, ok := b.catalogService(serviceID)
+	if !ok {
+		return shareType
+	}
+	if service.ShareType != "" && service.ShareType != shareType.Name {
+		if serviceShareType, err := LookupShareType(service.ShareType); err == nil {
+			shareType = serviceShareType
+		}
+	}
+	if service.Driver != "" {
+		shareType.Driver = service.Driver
+	}
+	return shareType
+}
+
+// checkPlanService refuses plans of one service for instances of another.  Brokers that offer a single service
+// accept any of their plans, whatever service ID they are given.
+func (b *Broker) checkPlanService(serviceID, planID string) error {
+	if len(b.catalogServices) < 2 {
+		return nil
+	}
+	plan, ok := b.plan(planID)
+	if !ok || plan.serviceID == serviceID {
+		return nil
+	}
+	err := fmt.Errorf("plan %q is not a plan of service %q", plan.Name, serviceID)
+	return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "plan-service-mismatch")
+}
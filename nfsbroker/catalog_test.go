@@ -0,0 +1,202 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Catalog", func() {
+	Describe("ParseCatalog", func() {
+		It("reads a YAML catalog", func() {
+			services, err := nfsbroker.ParseCatalog([]byte(`
+services:
+- id: service-id
+  name: nfs
+  description: NFS shares
+  tags: [nfs]
+  metadata:
+    displayName: NFS
+    longDescription: Existing NFS shares, mounted into app containers
+  plans:
+  - id: general-id
+    name: general
+    description: General purpose mounts
+    metadata:
+      displayName: General
+      bullets: [uid 1000]
+  - id: read-only-id
+    name: read-only
+    description: Read-only mounts
+    read_only: true
+    mount_options:
+      uid: 60000
+`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(services).To(HaveLen(1))
+			service := services[0]
+			Expect(service.ID).To(Equal("service-id"))
+			Expect(service.Name).To(Equal("nfs"))
+			Expect(service.Tags).To(Equal([]string{"nfs"}))
+			Expect(service.Metadata).To(Equal(&brokerapi.ServiceMetadata{
+				DisplayName:     "NFS",
+				LongDescription: "Existing NFS shares, mounted into app containers",
+			}))
+			Expect(service.Plans).To(HaveLen(2))
+			Expect(service.Plans[0].Metadata).To(Equal(&brokerapi.ServicePlanMetadata{DisplayName: "General", Bullets: []string{"uid 1000"}}))
+			Expect(service.Plans[1].ReadOnly).To(BeTrue())
+		})
+
+		It("reads a JSON catalog", func() {
+			services, err := nfsbroker.ParseCatalog([]byte(`{"services": [{"id": "service-id", "name": "nfs", "description": "NFS shares",
+				"plans": [{"id": "general-id", "name": "general", "description": "General purpose mounts"}]}]}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(services[0].Plans[0].Name).To(Equal("general"))
+		})
+
+		It("reads a service for each driver", func() {
+			services, err := nfsbroker.ParseCatalog([]byte(`
+services:
+- id: nfs-id
+  name: nfs
+  description: NFS shares
+  driver: nfsdriver
+  plans:
+  - name: general
+    description: General purpose mounts
+- id: nfs-legacy-id
+  name: nfs-legacy
+  description: NFS shares through the legacy driver
+  driver: nfsv3driver
+  plans:
+  - name: general
+    description: General purpose mounts
+`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(services).To(HaveLen(2))
+			Expect(services[0].Driver).To(Equal("nfsdriver"))
+			Expect(services[1].Driver).To(Equal("nfsv3driver"))
+		})
+
+		It("requires at least one service", func() {
+			_, err := nfsbroker.ParseCatalog([]byte(`services: []`))
+			Expect(err).To(MatchError(ContainSubstring("expected at least one service")))
+		})
+
+		It("requires distinct services and plan ids", func() {
+			_, err := nfsbroker.ParseCatalog([]byte(`{"services": [
+				{"id": "a-id", "name": "nfs", "description": "a", "plans": [{"id": "general-id", "name": "general", "description": "a"}]},
+				{"id": "b-id", "name": "nfs", "description": "b", "plans": [{"id": "other-id", "name": "general", "description": "b"}]}]}`))
+			Expect(err).To(MatchError(ContainSubstring(`service "nfs" is defined more than once`)))
+
+			_, err = nfsbroker.ParseCatalog([]byte(`{"services": [
+				{"id": "a-id", "name": "nfs", "description": "a", "plans": [{"id": "general-id", "name": "general", "description": "a"}]},
+				{"id": "b-id", "name": "nfs-legacy", "description": "b", "plans": [{"id": "general-id", "name": "general", "description": "b"}]}]}`))
+			Expect(err).To(MatchError(ContainSubstring(`plan "general" of service "nfs-legacy" has the same id as a plan of service "nfs"`)))
+		})
+
+		It("requires the service to be described", func() {
+			_, err := nfsbroker.ParseCatalog([]byte(`{"services": [{"id": "service-id", "name": "nfs", "plans": [{"id": "a", "name": "a", "description": "a"}]}]}`))
+			Expect(err).To(MatchError(ContainSubstring("needs an id, a name and a description")))
+		})
+
+		It("validates the plans", func() {
+			_, err := nfsbroker.ParseCatalog([]byte(`{"services": [{"id": "service-id", "name": "nfs", "description": "NFS shares", "plans": [{"id": "a", "name": "a"}]}]}`))
+			Expect(err).To(MatchError(ContainSubstring(`plan "a" needs a description`)))
+
+			_, err = nfsbroker.ParseCatalog([]byte(`{"services": [{"id": "service-id", "name": "nfs", "description": "NFS shares", "plans": []}]}`))
+			Expect(err).To(MatchError(ContainSubstring("at least one plan is required")))
+		})
+
+		It("rejects malformed files", func() {
+			_, err := nfsbroker.ParseCatalog([]byte("services: [\n"))
+			Expect(err).To(MatchError(ContainSubstring("invalid catalog")))
+		})
+	})
+
+	It("is served by brokers configured with it", func() {
+		broker := nfsbroker.New(lagertest.NewTestLogger("test-catalog"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, &nfsbrokerfakes.FakeStore{}, nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		broker.SetCatalogService(nfsbroker.CatalogService{
+			ID:          "catalog-service-id",
+			Name:        "catalog-nfs",
+			Description: "NFS shares",
+			Tags:        []string{"catalog"},
+			Plans:       []nfsbroker.Plan{{ID: "general-id", Name: "general", Description: "General purpose mounts"}},
+		})
+
+		services := broker.Services(context.TODO())
+		Expect(services).To(HaveLen(1))
+		Expect(services[0].ID).To(Equal("catalog-service-id"))
+		Expect(services[0].Name).To(Equal("catalog-nfs"))
+		Expect(services[0].Description).To(Equal("NFS shares"))
+		Expect(services[0].Tags).To(Equal([]string{"catalog"}))
+		Expect(services[0].Requires).To(ContainElement(nfsbroker.PermissionVolumeMount))
+		Expect(services[0].Plans).To(HaveLen(1))
+		Expect(services[0].Plans[0].Name).To(Equal("general"))
+	})
+
+	Context("with a service for each driver", func() {
+		var broker *nfsbroker.Broker
+
+		BeforeEach(func() {
+			broker = nfsbroker.New(lagertest.NewTestLogger("test-catalog"), "service-name", "service-id", "/fake-dir",
+				&os_fake.FakeOs{}, nil, nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize), nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+			broker.SetCatalogServices([]nfsbroker.CatalogService{
+				{ID: "nfs-id", Name: "nfs", Description: "NFS shares", Driver: "nfsdriver",
+					Plans: []nfsbroker.Plan{{ID: "nfs-general-id", Name: "general", Description: "General purpose mounts"}}},
+				{ID: "nfs-legacy-id", Name: "nfs-legacy", Description: "Legacy NFS shares",
+					Plans: []nfsbroker.Plan{{ID: "legacy-general-id", Name: "general", Description: "General purpose mounts"}}},
+			})
+		})
+
+		provision := func(instanceID, serviceID, planID string) error {
+			_, err := broker.Provision(context.TODO(), instanceID, brokerapi.ProvisionDetails{
+				ServiceID:     serviceID,
+				PlanID:        planID,
+				RawParameters: json.RawMessage(`{"share":"server:/export"}`),
+			}, false)
+			return err
+		}
+
+		It("serves each service with its own plans", func() {
+			services := broker.Services(context.TODO())
+			Expect(services).To(HaveLen(2))
+			Expect(services[0].ID).To(Equal("nfs-id"))
+			Expect(services[0].Plans).To(HaveLen(1))
+			Expect(services[0].Plans[0].ID).To(Equal("nfs-general-id"))
+			Expect(services[1].ID).To(Equal("nfs-legacy-id"))
+			Expect(services[1].Plans).To(HaveLen(1))
+			Expect(services[1].Plans[0].ID).To(Equal("legacy-general-id"))
+		})
+
+		It("names each service's driver in volume mounts", func() {
+			Expect(provision("modern", "nfs-id", "nfs-general-id")).To(Succeed())
+			Expect(provision("legacy", "nfs-legacy-id", "legacy-general-id")).To(Succeed())
+
+			binding, err := broker.Bind(context.TODO(), "modern", "modern-binding", brokerapi.BindDetails{AppGUID: "app-guid"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.VolumeMounts[0].Driver).To(Equal("nfsdriver"))
+
+			binding, err = broker.Bind(context.TODO(), "legacy", "legacy-binding", brokerapi.BindDetails{AppGUID: "app-guid"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.VolumeMounts[0].Driver).To(Equal("nfsv3driver"))
+		})
+
+		It("refuses plans of the other service", func() {
+			err := provision("instance-id", "nfs-id", "legacy-general-id")
+			Expect(err).To(MatchError(`plan "general" is not a plan of service "nfs-id"`))
+
+			Expect(provision("instance-id", "nfs-id", "nfs-general-id")).To(Succeed())
+			_, err = broker.Update(context.TODO(), "instance-id", brokerapi.UpdateDetails{ServiceID: "nfs-id", PlanID: "legacy-general-id"}, false)
+			Expect(err).To(MatchError(ContainSubstring("is not a plan of service")))
+		})
+	})
+})
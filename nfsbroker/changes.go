@@ -0,0 +1,79 @@
+package nfsbroker
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	DefaultChangesLimit = 100
+	MaxChangesLimit     = 1000
+)
+
+var ErrNoChangeFeed = errors.New("store does not record changes")
+
+// ChangeFeed is implemented by stores that number their mutations, so that other systems can follow them without
+// listing every record.  Only the SQL store with its audit trail enabled keeps such a log.
+type ChangeFeed interface {
+	// ListChanges returns up to limit mutations numbered after since, in order.
+	ListChanges(ctx context.Context, since int64, limit int) ([]AuditEntry, error)
+}
+
+// Change is a mutation of a stored record.  It identifies the record but does not include it; consumers fetch
+// records that were created or updated through the service broker API.
+type Change struct {
+	Sequence   int64  `json:"seq"`
+	OccurredAt string `json:"occurred_at"`
+	Action     string `json:"action"`
+	RecordType string `json:"record_type"`
+	RecordID   string `json:"record_id"`
+}
+
+// ChangesPage is one page of the change feed.  Next is the cursor to ask for the following page with; it is since
+// itself when there are no newer changes yet.
+type ChangesPage struct {
+	Changes []Change `json:"changes"`
+	Next    int64    `json:"next"`
+}
+
+// ListChanges delegates to the active store.  Each store numbers its own mutations, so consumers must start over
+// from zero after the standby store is promoted.
+func (s *SwitchableStore) ListChanges(ctx context.Context, since int64, limit int) ([]AuditEntry, error) {
+	feed, ok := s.current().(ChangeFeed)
+	if !ok {
+		return nil, ErrNoChangeFeed
+	}
+	return feed.ListChanges(ctx, since, limit)
+}
+
+// Changes returns the store mutations numbered after since.  Limits outside 1 to MaxChangesLimit are replaced by
+// DefaultChangesLimit and MaxChangesLimit respectively.
+func (b *Broker) Changes(ctx context.Context, since int64, limit int) (ChangesPage, error) {
+	feed, ok := b.store.(ChangeFeed)
+	if !ok {
+		return ChangesPage{}, ErrNoChangeFeed
+	}
+	if limit <= 0 {
+		limit = DefaultChangesLimit
+	}
+	if limit > MaxChangesLimit {
+		limit = MaxChangesLimit
+	}
+
+	entries, err := feed.ListChanges(ctx, since, limit)
+	if err != nil {
+		return ChangesPage{}, err
+	}
+	page := ChangesPage{Changes: []Change{}, Next: since}
+	for _, entry := range entries {
+		page.Changes = append(page.Changes, Change{
+			Sequence:   entry.Sequence,
+			OccurredAt: entry.OccurredAt,
+			Action:     entry.Action,
+			RecordType: entry.RecordType,
+			RecordID:   entry.RecordID,
+		})
+		page.Next = entry.Sequence
+	}
+	return page, nil
+}
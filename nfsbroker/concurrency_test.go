@@ -0,0 +1,139 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Concurrent operations", func() {
+	var (
+		broker     *nfsbroker.Broker
+		fakeStore  *nfsbrokerfakes.FakeStore
+		ctx        context.Context
+		operations map[string]nfsbroker.Operation
+	)
+
+	expectConcurrencyError := func(err error) {
+		failure, ok := err.(*brokerapi.FailureResponse)
+		Expect(ok).To(BeTrue(), "expected a failure response, got %v", err)
+		Expect(failure.ValidatedStatusCode(nil)).To(Equal(http.StatusUnprocessableEntity))
+		Expect(failure.ErrorResponse()).To(Equal(brokerapi.ErrorResponse{Description: err.Error(), Error: "ConcurrencyError"}))
+	}
+
+	BeforeEach(func() {
+		logger := lagertest.NewTestLogger("test-concurrency")
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, fakeStore,
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		ctx = context.Background()
+
+		operations = map[string]nfsbroker.Operation{}
+		fakeStore.RetrieveOperationStub = func(_ context.Context, id string) (nfsbroker.Operation, error) {
+			return operations[id], nil
+		}
+		fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{ServiceID: "service-id", PlanID: "Existing", Share: "server:/some-share"}, nil)
+		fakeStore.RetrieveBindingDetailsReturns(brokerapi.BindDetails{AppGUID: "app-guid"}, nil)
+		fakeStore.ListBindingInstancesReturns(map[string]string{"binding-id": "instance-id", "other-binding-id": "other-instance-id"}, nil)
+	})
+
+	Context("while an instance is being provisioned", func() {
+		BeforeEach(func() {
+			operations["instance-id"] = nfsbroker.Operation{Type: nfsbroker.ProvisionOperation, State: brokerapi.InProgress}
+		})
+
+		It("refuses to bind, update or deprovision it", func() {
+			_, err := broker.Bind(ctx, "instance-id", "new-binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})
+			expectConcurrencyError(err)
+
+			_, err = broker.Update(ctx, "instance-id", brokerapi.UpdateDetails{PlanID: "Existing"}, true)
+			expectConcurrencyError(err)
+
+			_, err = broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+			expectConcurrencyError(err)
+
+			Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(0))
+			Expect(fakeStore.UpdateInstanceDetailsCallCount()).To(Equal(0))
+			Expect(fakeStore.DeleteInstanceDetailsCallCount()).To(Equal(0))
+		})
+
+		It("answers retried provisions with the operation in progress", func() {
+			parameters, _ := json.Marshal(map[string]interface{}{"share": "server:/some-share"})
+			spec, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{ServiceID: "service-id", PlanID: "Existing", RawParameters: parameters}, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.IsAsync).To(BeTrue())
+		})
+	})
+
+	Context("while an instance is being deprovisioned", func() {
+		BeforeEach(func() {
+			operations["instance-id"] = nfsbroker.Operation{Type: nfsbroker.DeprovisionOperation, State: brokerapi.InProgress}
+		})
+
+		It("refuses to provision it again, bind, unbind or update it", func() {
+			parameters, _ := json.Marshal(map[string]interface{}{"share": "server:/some-share"})
+			_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{ServiceID: "service-id", PlanID: "Existing", RawParameters: parameters}, true)
+			expectConcurrencyError(err)
+
+			_, err = broker.Bind(ctx, "instance-id", "new-binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})
+			expectConcurrencyError(err)
+
+			err = broker.Unbind(ctx, "instance-id", "binding-id", brokerapi.UnbindDetails{})
+			expectConcurrencyError(err)
+
+			_, err = broker.Update(ctx, "instance-id", brokerapi.UpdateDetails{PlanID: "Existing"}, true)
+			expectConcurrencyError(err)
+		})
+
+		It("answers retried deprovisions with the operation in progress", func() {
+			spec, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.IsAsync).To(BeTrue())
+		})
+	})
+
+	Context("while one of an instance's bindings is being unbound", func() {
+		BeforeEach(func() {
+			operations["binding-id"] = nfsbroker.Operation{Type: nfsbroker.UnbindOperation, State: brokerapi.InProgress}
+		})
+
+		It("refuses to rebind the binding, or to update or deprovision the instance", func() {
+			_, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})
+			expectConcurrencyError(err)
+
+			_, err = broker.Update(ctx, "instance-id", brokerapi.UpdateDetails{PlanID: "Existing"}, true)
+			expectConcurrencyError(err)
+
+			_, err = broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+			expectConcurrencyError(err)
+		})
+
+		It("leaves other instances alone", func() {
+			_, err := broker.Deprovision(ctx, "other-instance-id", brokerapi.DeprovisionDetails{}, true)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("once the operation has finished", func() {
+		BeforeEach(func() {
+			operations["instance-id"] = nfsbroker.Operation{Type: nfsbroker.ProvisionOperation, State: brokerapi.Failed}
+			operations["binding-id"] = nfsbroker.Operation{Type: nfsbroker.UnbindOperation, State: brokerapi.Succeeded}
+		})
+
+		It("accepts other requests", func() {
+			_, err := broker.Update(ctx, "instance-id", brokerapi.UpdateDetails{PlanID: "Existing"}, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
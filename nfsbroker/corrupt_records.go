@@ -0,0 +1,51 @@
+package nfsbroker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// corruptRecordPrefixLength is how much of a corrupt record is hashed to identify it in logs.  Only the hash is
+// logged, since records can hold shares and bind parameters.
+const corruptRecordPrefixLength = 64
+
+var corruptRecords uint64
+
+// CorruptRecordCount returns the number of times a stored record has failed to unmarshal since the broker started.
+// A corrupt SQL record is counted each time it is read; a corrupt state file entry each time the file is restored.
+func CorruptRecordCount() uint64 {
+	return atomic.LoadUint64(&corruptRecords)
+}
+
+// unmarshalRecord unmarshals a stored record.  If the record is corrupt it logs enough to find the record, counts
+// it, and returns an error wrapping ErrCorruptRecord.
+func unmarshalRecord(logger lager.Logger, kind, id string, value []byte, record interface{}) error {
+	err := json.Unmarshal(value, record)
+	if err == nil {
+		return nil
+	}
+
+	atomic.AddUint64(&corruptRecords, 1)
+	if logger != nil {
+		logger.Error("failed-to-unmarshal-record", err, lager.Data{
+			"kind":        kind,
+			"id":          id,
+			"length":      len(value),
+			"prefix_hash": prefixHash(value),
+		})
+	}
+	return fmt.Errorf("%w: %s %s: %s", ErrCorruptRecord, kind, id, err)
+}
+
+func prefixHash(value []byte) string {
+	if len(value) > corruptRecordPrefixLength {
+		value = value[:corruptRecordPrefixLength]
+	}
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:8])
+}
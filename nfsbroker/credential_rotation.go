@@ -0,0 +1,51 @@
+package nfsbroker
+
+import (
+	"os"
+	"os/signal"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// CredentialRotator rotates the broker's database credentials whenever the broker receives one of its signals, so
+// that they can be changed without restarting the broker.  rotate re-reads the credentials and reconnects the stores
+// with them.
+type CredentialRotator struct {
+	logger  lager.Logger
+	rotate  func(logger lager.Logger) error
+	signals []os.Signal
+}
+
+func NewCredentialRotator(logger lager.Logger, rotate func(logger lager.Logger) error, signals ...os.Signal) *CredentialRotator {
+	return &CredentialRotator{logger: logger, rotate: rotate, signals: signals}
+}
+
+func (r *CredentialRotator) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	rotateSignals := make(chan os.Signal, 1)
+	signal.Notify(rotateSignals, r.signals...)
+	defer signal.Stop(rotateSignals)
+	close(ready)
+
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-rotateSignals:
+			r.Rotate()
+		}
+	}
+}
+
+// Rotate rotates the credentials, logging rather than returning failures: stores that could not be reconnected keep
+// their connections with the old credentials, and the rotation can be retried.
+func (r *CredentialRotator) Rotate() {
+	logger := r.logger.Session("rotate-credentials")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := r.rotate(logger); err != nil {
+		logger.Error("failed-to-rotate-credentials", err)
+		return
+	}
+	logger.Info("rotated-credentials")
+}
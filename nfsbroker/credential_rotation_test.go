@@ -0,0 +1,52 @@
+package nfsbroker_test
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tedsuo/ifrit"
+)
+
+var _ = Describe("CredentialRotator", func() {
+	var (
+		logger    *lagertest.TestLogger
+		rotations chan struct{}
+		rotateErr error
+		rotator   *nfsbroker.CredentialRotator
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-credential-rotator")
+		rotations = make(chan struct{}, 1)
+		rotateErr = nil
+		rotator = nfsbroker.NewCredentialRotator(logger, func(lager.Logger) error {
+			rotations <- struct{}{}
+			return rotateErr
+		}, syscall.SIGUSR2)
+	})
+
+	It("rotates the credentials when the broker receives its signal", func() {
+		process := ifrit.Invoke(rotator)
+		defer func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+		}()
+
+		Expect(syscall.Kill(os.Getpid(), syscall.SIGUSR2)).To(Succeed())
+		Eventually(rotations).Should(Receive())
+		Eventually(logger.LogMessages).Should(ContainElement("test-credential-rotator.rotate-credentials.rotated-credentials"))
+	})
+
+	It("logs failed rotations", func() {
+		rotateErr = errors.New("access denied")
+		rotator.Rotate()
+		Expect(rotations).To(Receive())
+		Expect(logger.LogMessages()).To(ContainElement("test-credential-rotator.rotate-credentials.failed-to-rotate-credentials"))
+	})
+})
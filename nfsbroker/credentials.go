@@ -0,0 +1,99 @@
+package nfsbroker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// CredentialsData is what plan credentials templates are executed with.  It describes the first volume mount of the
+// binding, so that apps that read connection details from VCAP_SERVICES can find the share during a migration to
+// volume services.
+type CredentialsData struct {
+	InstanceID string
+	BindingID  string
+
+	// Share is the instance's share as provisioned, such as "server:/export", and Server, Path and Version its parts.
+	Share   string
+	Server  string
+	Path    string
+	Version string
+
+	// URI is the source the volume driver mounts, such as "nfs://server:/export".
+	URI string
+	// Options are the binding's mount options, such as uid and gid, other than the source.
+	Options map[string]string
+
+	ContainerDir string
+	Mode         string
+}
+
+// parseCredentialsTemplates parses a plan's credentials templates and tries them on example data, so that templates
+// that refer to data that does not exist are refused when plans are loaded rather than on bind.
+func parseCredentialsTemplates(templates map[string]string) (map[string]*template.Template, error) {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parsed := map[string]*template.Template{}
+	example := CredentialsData{Options: map[string]string{}}
+	for _, name := range names {
+		tmpl, err := template.New(name).Option("missingkey=zero").Parse(templates[name])
+		if err != nil {
+			return nil, fmt.Errorf("credentials template %q: %w", name, err)
+		}
+		if err := tmpl.Execute(&strings.Builder{}, example); err != nil {
+			return nil, fmt.Errorf("credentials template %q: %w", name, err)
+		}
+		parsed[name] = tmpl
+	}
+	return parsed, nil
+}
+
+// bindingCredentials renders the credentials templates of the instance's plan for a binding with the given volume
+// mounts.  Bindings of plans without templates get empty credentials.
+func (b *Broker) bindingCredentials(instanceID, bindingID string, instanceDetails ServiceInstance, volumeMounts []brokerapi.VolumeMount) (interface{}, error) {
+	plan, ok := b.plan(instanceDetails.PlanID)
+	if !ok || len(plan.Credentials) == 0 || len(volumeMounts) == 0 {
+		return struct{}{}, nil // if nil, cloud controller chokes on response
+	}
+	templates, err := parseCredentialsTemplates(plan.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	details := withShareComponents(instanceDetails)
+	mount := volumeMounts[0]
+	data := CredentialsData{
+		InstanceID:   instanceID,
+		BindingID:    bindingID,
+		Share:        details.Share,
+		Server:       details.ShareServer,
+		Path:         details.SharePath,
+		Version:      details.ShareVersion,
+		URI:          fmt.Sprint(mount.Device.MountConfig["source"]),
+		Options:      map[string]string{},
+		ContainerDir: mount.ContainerDir,
+		Mode:         mount.Mode,
+	}
+	for name, value := range mount.Device.MountConfig {
+		if name != "source" {
+			data.Options[name] = fmt.Sprint(value)
+		}
+	}
+
+	credentials := map[string]string{}
+	for name, tmpl := range templates {
+		var rendered strings.Builder
+		if err := tmpl.Execute(&rendered, data); err != nil {
+			return nil, fmt.Errorf("failed to render credentials template %q of plan %q: %w", name, plan.Name, err)
+		}
+		credentials[name] = rendered.String()
+	}
+	return credentials, nil
+}
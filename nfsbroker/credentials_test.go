@@ -0,0 +1,72 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Credentials templates", func() {
+	var (
+		broker *nfsbroker.Broker
+		ctx    context.Context
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-credentials"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize),
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		broker.SetPlans([]nfsbroker.Plan{
+			{ID: "Existing", Name: "existing"},
+			{ID: "Legacy", Name: "legacy", MountOptions: map[string]string{"uid": "1000", "gid": "1000"}, Credentials: map[string]string{
+				"uri":     "{{.URI}}",
+				"host":    "{{.Server}}",
+				"path":    "{{.Path}}",
+				"options": "uid={{.Options.uid}},gid={{.Options.gid}}",
+			}},
+		})
+	})
+
+	provisionAndBind := func(planID string) brokerapi.Binding {
+		_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+			ServiceID:     "service-id",
+			PlanID:        planID,
+			RawParameters: json.RawMessage(`{"share": "server:/some-share"}`),
+		}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		binding, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})
+		Expect(err).NotTo(HaveOccurred())
+		return binding
+	}
+
+	It("renders the plan's templates into the bind response's credentials", func() {
+		binding := provisionAndBind("Legacy")
+		Expect(binding.Credentials).To(Equal(map[string]string{
+			"uri":     "nfs://server:/some-share",
+			"host":    "server",
+			"path":    "/some-share",
+			"options": "uid=1000,gid=1000",
+		}))
+		Expect(binding.VolumeMounts).To(HaveLen(1))
+	})
+
+	It("renders them for fetched bindings too", func() {
+		provisionAndBind("Legacy")
+		spec, err := broker.GetBinding(ctx, "instance-id", "binding-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.Credentials).To(HaveKeyWithValue("uri", "nfs://server:/some-share"))
+	})
+
+	It("gives empty credentials for plans without templates", func() {
+		binding := provisionAndBind("Existing")
+		Expect(binding.Credentials).To(Equal(struct{}{}))
+	})
+})
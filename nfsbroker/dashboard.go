@@ -0,0 +1,46 @@
+package nfsbroker
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DashboardURL is a template for the URL of each service instance's dashboard page.  The placeholders
+// {instance_id}, {plan_id}, {organization_guid} and {space_guid} are replaced with the instance's values.
+type DashboardURL string
+
+// ParseDashboardURL checks that a dashboard URL template is an absolute http or https URL.
+func ParseDashboardURL(template string) (DashboardURL, error) {
+	parsed, err := url.Parse(dashboardReplacer("", ServiceInstance{}).Replace(template))
+	if err != nil {
+		return "", fmt.Errorf("invalid dashboard URL: %w", err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("invalid dashboard URL %q: must be an absolute http or https URL", template)
+	}
+	return DashboardURL(template), nil
+}
+
+// SetDashboardURL configures the dashboard URL returned when instances are provisioned.  Instances provisioned
+// before it was set, or while it was not, have no dashboard.
+func (b *Broker) SetDashboardURL(template DashboardURL) {
+	b.dashboardURL = template
+}
+
+// instanceDashboardURL returns the dashboard URL of a new service instance.
+func (b *Broker) instanceDashboardURL(instanceID string, details ServiceInstance) string {
+	if b.dashboardURL == "" {
+		return ""
+	}
+	return dashboardReplacer(instanceID, details).Replace(string(b.dashboardURL))
+}
+
+func dashboardReplacer(instanceID string, details ServiceInstance) *strings.Replacer {
+	return strings.NewReplacer(
+		"{instance_id}", url.PathEscape(instanceID),
+		"{plan_id}", url.PathEscape(details.PlanID),
+		"{organization_guid}", url.PathEscape(details.OrganizationGUID),
+		"{space_guid}", url.PathEscape(details.SpaceGUID),
+	)
+}
@@ -0,0 +1,96 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("ParseDashboardURL", func() {
+	It("accepts absolute http and https URLs with placeholders", func() {
+		dashboard, err := nfsbroker.ParseDashboardURL("https://dashboard.example.com/orgs/{organization_guid}/instances/{instance_id}")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(dashboard)).To(Equal("https://dashboard.example.com/orgs/{organization_guid}/instances/{instance_id}"))
+	})
+
+	It("rejects relative URLs", func() {
+		_, err := nfsbroker.ParseDashboardURL("/instances/{instance_id}")
+		Expect(err).To(MatchError(ContainSubstring("must be an absolute http or https URL")))
+	})
+
+	It("rejects other schemes", func() {
+		_, err := nfsbroker.ParseDashboardURL("ftp://dashboard.example.com/{instance_id}")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Broker dashboard URLs", func() {
+	var (
+		broker    *nfsbroker.Broker
+		fakeStore *nfsbrokerfakes.FakeStore
+		ctx       context.Context
+		details   brokerapi.ProvisionDetails
+	)
+
+	BeforeEach(func() {
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound)
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-dashboard"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, fakeStore, nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		ctx = context.TODO()
+		details = brokerapi.ProvisionDetails{
+			PlanID:           "Existing",
+			OrganizationGUID: "org-guid",
+			SpaceGUID:        "space guid",
+			RawParameters:    json.RawMessage(`{"share":"filer:/export"}`),
+		}
+	})
+
+	It("returns no dashboard URL unless one is configured", func() {
+		spec, err := broker.Provision(ctx, "instance-id", details, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.DashboardURL).To(BeEmpty())
+	})
+
+	Context("when a dashboard URL is configured", func() {
+		BeforeEach(func() {
+			dashboard, err := nfsbroker.ParseDashboardURL("https://dashboard.example.com/{space_guid}/{instance_id}")
+			Expect(err).NotTo(HaveOccurred())
+			broker.SetDashboardURL(dashboard)
+		})
+
+		It("returns and stores the instance's dashboard URL", func() {
+			spec, err := broker.Provision(ctx, "instance-id", details, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.DashboardURL).To(Equal("https://dashboard.example.com/space%20guid/instance-id"))
+
+			Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(1))
+			_, _, stored := fakeStore.CreateInstanceDetailsArgsForCall(0)
+			Expect(stored.DashboardURL).To(Equal(spec.DashboardURL))
+		})
+
+		It("returns the stored dashboard URL when provisioning is repeated", func() {
+			fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{DashboardURL: "https://old.example.com/instance-id"}, nil)
+
+			spec, err := broker.Provision(ctx, "instance-id", details, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.DashboardURL).To(Equal("https://old.example.com/instance-id"))
+			Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+		})
+
+		It("includes the dashboard URL when the instance is fetched", func() {
+			fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{PlanID: "Existing", DashboardURL: "https://dashboard.example.com/instance-id"}, nil)
+
+			spec, err := broker.GetInstance(ctx, "instance-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.DashboardURL).To(Equal("https://dashboard.example.com/instance-id"))
+		})
+	})
+})
@@ -0,0 +1,71 @@
+package nfsbroker
+
+import (
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+)
+
+// DBStatsReporter reports the connection pool statistics of a SQL store every interval, so that an exhausted pool
+// shows up before requests start failing.  Each report is logged at debug level, and at info level when callers have
+// had to wait for a connection since the last report.
+type DBStatsReporter struct {
+	logger   lager.Logger
+	db       SqlConnection
+	metrics  Metrics
+	clock    clock.Clock
+	name     string
+	interval time.Duration
+}
+
+// DBStatsReporter returns a runner that reports the store's pool statistics under name.  metrics may be nil, to only
+// log them.
+func (s *SqlStore) DBStatsReporter(logger lager.Logger, metrics Metrics, clock clock.Clock, name string, interval time.Duration) *DBStatsReporter {
+	return &DBStatsReporter{
+		logger:   logger,
+		db:       s.Database,
+		metrics:  metrics,
+		clock:    clock,
+		name:     name,
+		interval: interval,
+	}
+}
+
+func (r *DBStatsReporter) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	logger := r.logger.Session("db-stats", lager.Data{"store": r.name})
+	close(ready)
+
+	ticker := r.clock.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	var waits int64
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-ticker.C():
+		}
+
+		stats := r.db.Stats()
+		data := lager.Data{
+			"maxOpen":      stats.MaxOpenConnections,
+			"open":         stats.OpenConnections,
+			"inUse":        stats.InUse,
+			"idle":         stats.Idle,
+			"waitCount":    stats.WaitCount,
+			"waitDuration": stats.WaitDuration.String(),
+		}
+		if stats.WaitCount > waits {
+			logger.Info("waited-for-connections", data)
+		} else {
+			logger.Debug("report", data)
+		}
+		waits = stats.WaitCount
+
+		if r.metrics != nil {
+			r.metrics.ObserveDBStats(r.name, stats)
+		}
+	}
+}
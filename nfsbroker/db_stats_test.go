@@ -0,0 +1,118 @@
+package nfsbroker_test
+
+import (
+	"database/sql"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/tedsuo/ifrit"
+)
+
+var _ = Describe("DBStatsReporter", func() {
+	var (
+		logger     *lagertest.TestLogger
+		connection *nfsbrokerfakes.FakeSqlConnection
+		registry   *prometheus.Registry
+		fakeClock  *fakeclock.FakeClock
+		process    ifrit.Process
+
+		statsMutex sync.Mutex
+		stats      sql.DBStats
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-db-stats")
+		connection = &nfsbrokerfakes.FakeSqlConnection{}
+		stats = sql.DBStats{}
+		// the reporter calls Stats from its own goroutine, so the stats it returns are changed under a lock
+		connection.StatsStub = func() sql.DBStats {
+			statsMutex.Lock()
+			defer statsMutex.Unlock()
+			return stats
+		}
+		registry = prometheus.NewRegistry()
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		store := nfsbroker.SqlStore{Database: connection}
+		process = ifrit.Invoke(store.DBStatsReporter(logger, nfsbroker.NewPrometheusMetrics(registry), fakeClock, "primary", time.Minute))
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+	})
+
+	gauge := func(name string, labels map[string]string) float64 {
+		families, err := registry.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() != name {
+				continue
+			}
+			for _, metric := range family.GetMetric() {
+				if matchLabels(metric, labels) {
+					return metric.GetGauge().GetValue()
+				}
+			}
+		}
+		return -1
+	}
+
+	report := func(next sql.DBStats) {
+		calls := connection.StatsCallCount()
+		statsMutex.Lock()
+		stats = next
+		statsMutex.Unlock()
+		fakeClock.WaitForWatcherAndIncrement(time.Minute)
+		Eventually(connection.StatsCallCount).Should(Equal(calls + 1))
+	}
+
+	It("sends the pool's statistics every interval", func() {
+		report(sql.DBStats{MaxOpenConnections: 10, OpenConnections: 4, InUse: 3, Idle: 1, WaitCount: 2, WaitDuration: 1500 * time.Millisecond})
+
+		Eventually(func() float64 {
+			return gauge("nfsbroker_db_connections", map[string]string{"store": "primary", "state": "in_use"})
+		}).Should(Equal(float64(3)))
+		Expect(gauge("nfsbroker_db_connections", map[string]string{"store": "primary", "state": "max_open"})).To(Equal(float64(10)))
+		Expect(gauge("nfsbroker_db_wait_count", map[string]string{"store": "primary"})).To(Equal(float64(2)))
+		Expect(gauge("nfsbroker_db_wait_duration_seconds", map[string]string{"store": "primary"})).To(Equal(1.5))
+	})
+
+	It("logs when callers have waited for connections since the last report", func() {
+		waited := func() int {
+			count := 0
+			for _, log := range logger.Logs() {
+				if log.Message == "test-db-stats.db-stats.waited-for-connections" && log.LogLevel == lager.INFO {
+					count++
+				}
+			}
+			return count
+		}
+
+		report(sql.DBStats{WaitCount: 2})
+		Eventually(waited).Should(Equal(1))
+
+		report(sql.DBStats{WaitCount: 2})
+		report(sql.DBStats{WaitCount: 5})
+		Eventually(waited).Should(Equal(2))
+	})
+})
+
+func matchLabels(metric *dto.Metric, labels map[string]string) bool {
+	for _, label := range metric.GetLabel() {
+		if value, ok := labels[label.GetName()]; ok && value != label.GetValue() {
+			return false
+		}
+	}
+	return true
+}
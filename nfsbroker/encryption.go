@@ -0,0 +1,44 @@
+package nfsbroker
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// MinEncryptionKeyLength keeps keys from being short enough to guess.
+const MinEncryptionKeyLength = 16
+
+// encryptionKey is an AES-256-GCM key, identified by a hash of it so that what it encrypted can name it.
+type encryptionKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// newEncryptionKeys derives AES keys with SHA-256 from keys, which are passphrases of at least
+// MinEncryptionKeyLength characters.  what names the keys in errors.
+func newEncryptionKeys(what string, keys []string) ([]encryptionKey, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no %s keys given", what)
+	}
+	encryptionKeys := []encryptionKey{}
+	for i, key := range keys {
+		if len(key) < MinEncryptionKeyLength {
+			return nil, fmt.Errorf("%s key %d is shorter than %d characters", what, i+1, MinEncryptionKeyLength)
+		}
+		sum := sha256.Sum256([]byte(key))
+		block, err := aes.NewCipher(sum[:])
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		id := sha256.Sum256(sum[:])
+		encryptionKeys = append(encryptionKeys, encryptionKey{id: hex.EncodeToString(id[:4]), aead: aead})
+	}
+	return encryptionKeys, nil
+}
@@ -0,0 +1,100 @@
+package nfsbroker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//go:generate counterfeiter -o ../nfsbrokerfakes/fake_entitlement_checker.go . EntitlementChecker
+type EntitlementChecker interface {
+	Entitled(ctx context.Context, orgGUID, server, path string) (bool, error)
+}
+
+// EntitlementCache remembers entitlement decisions for ttl, so that every bind does not wait on the entitlement
+// service.  Denials are cached as well as grants; failed checks are not cached.
+type EntitlementCache struct {
+	checker EntitlementChecker
+	clock   clock.Clock
+	ttl     time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]cachedEntitlement
+}
+
+type cachedEntitlement struct {
+	entitled bool
+	expires  time.Time
+}
+
+func NewEntitlementCache(checker EntitlementChecker, clock clock.Clock, ttl time.Duration) *EntitlementCache {
+	return &EntitlementCache{
+		checker: checker,
+		clock:   clock,
+		ttl:     ttl,
+		entries: map[string]cachedEntitlement{},
+	}
+}
+
+func (c *EntitlementCache) Entitled(ctx context.Context, orgGUID, server, path string) (bool, error) {
+	key := orgGUID + "\x00" + server + "\x00" + path
+
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	c.mutex.Unlock()
+	if ok && c.clock.Now().Before(entry.expires) {
+		return entry.entitled, nil
+	}
+
+	entitled, err := c.checker.Entitled(ctx, orgGUID, server, path)
+	if err != nil {
+		return false, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[key] = cachedEntitlement{entitled: entitled, expires: c.clock.Now().Add(c.ttl)}
+	return entitled, nil
+}
+
+// SetEntitlementChecker configures a check that organizations may use the shares they provision and bind.  When
+// failOpen is set, requests are allowed if the check itself fails; otherwise they are refused.
+func (b *Broker) SetEntitlementChecker(checker EntitlementChecker, failOpen bool) {
+	b.entitlementChecker = checker
+	b.entitlementFailOpen = failOpen
+}
+
+// checkEntitlement refuses shares that the instance's organization is not entitled to.
+func (b *Broker) checkEntitlement(ctx context.Context, logger lager.Logger, details ServiceInstance) error {
+	if b.entitlementChecker == nil || isProbe(ctx) {
+		return nil
+	}
+
+	server, path := details.ShareServer, details.SharePath
+	if server == "" {
+		path = details.Share
+	}
+	spanCtx, endSpan := startSpan(ctx, "check-entitlement", attribute.String("nfsbroker.share", details.Share))
+	entitled, err := b.entitlementChecker.Entitled(spanCtx, details.OrganizationGUID, server, path)
+	endSpan(err)
+	if err != nil {
+		if b.entitlementFailOpen {
+			logger.Error("entitlement-check-failed-allowing", err, lager.Data{"share": details.Share})
+			return nil
+		}
+		err = fmt.Errorf("failed to check entitlement to share %s: %w", details.Share, err)
+		return brokerapi.NewFailureResponse(err, http.StatusServiceUnavailable, "entitlement-check-failed")
+	}
+	if !entitled {
+		err := fmt.Errorf("organization %s is not entitled to share %s", details.OrganizationGUID, details.Share)
+		return brokerapi.NewFailureResponse(err, http.StatusForbidden, "share-not-entitled")
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("EntitlementCache", func() {
+	var (
+		cache       *nfsbroker.EntitlementCache
+		fakeChecker *nfsbrokerfakes.FakeEntitlementChecker
+		fakeClock   *fakeclock.FakeClock
+		ctx         context.Context
+	)
+
+	BeforeEach(func() {
+		fakeChecker = &nfsbrokerfakes.FakeEntitlementChecker{}
+		fakeChecker.EntitledReturns(false, nil)
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		cache = nfsbroker.NewEntitlementCache(fakeChecker, fakeClock, time.Minute)
+		ctx = context.TODO()
+	})
+
+	It("checks each share once while the decision is cached", func() {
+		for i := 0; i < 2; i++ {
+			entitled, err := cache.Entitled(ctx, "org-guid", "filer", "/export")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entitled).To(BeFalse())
+		}
+		Expect(fakeChecker.EntitledCallCount()).To(Equal(1))
+
+		_, err := cache.Entitled(ctx, "org-guid", "filer", "/other-export")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fakeChecker.EntitledCallCount()).To(Equal(2))
+	})
+
+	It("checks again once the decision expires", func() {
+		_, err := cache.Entitled(ctx, "org-guid", "filer", "/export")
+		Expect(err).NotTo(HaveOccurred())
+
+		fakeClock.Increment(time.Minute)
+		fakeChecker.EntitledReturns(true, nil)
+
+		entitled, err := cache.Entitled(ctx, "org-guid", "filer", "/export")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entitled).To(BeTrue())
+	})
+
+	It("does not cache failures", func() {
+		fakeChecker.EntitledReturns(false, errors.New("entitlement service is down"))
+		_, err := cache.Entitled(ctx, "org-guid", "filer", "/export")
+		Expect(err).To(MatchError("entitlement service is down"))
+
+		fakeChecker.EntitledReturns(true, nil)
+		entitled, err := cache.Entitled(ctx, "org-guid", "filer", "/export")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entitled).To(BeTrue())
+	})
+})
+
+var _ = Describe("Broker entitlements", func() {
+	var (
+		broker      *nfsbroker.Broker
+		fakeStore   *nfsbrokerfakes.FakeStore
+		fakeChecker *nfsbrokerfakes.FakeEntitlementChecker
+		ctx         context.Context
+		failOpen    bool
+	)
+
+	BeforeEach(func() {
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound)
+		fakeChecker = &nfsbrokerfakes.FakeEntitlementChecker{}
+		fakeChecker.EntitledReturns(true, nil)
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-entitlements"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, fakeStore, nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		ctx = context.TODO()
+		failOpen = false
+	})
+
+	JustBeforeEach(func() {
+		broker.SetEntitlementChecker(fakeChecker, failOpen)
+	})
+
+	provision := func() error {
+		_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+			PlanID:           "Existing",
+			OrganizationGUID: "org-guid",
+			RawParameters:    json.RawMessage(`{"share":"filer:/export"}`),
+		}, false)
+		return err
+	}
+
+	Context("when provisioning", func() {
+		It("checks the organization's entitlement to the share", func() {
+			Expect(provision()).To(Succeed())
+
+			_, orgGUID, server, path := fakeChecker.EntitledArgsForCall(0)
+			Expect(orgGUID).To(Equal("org-guid"))
+			Expect(server).To(Equal("filer"))
+			Expect(path).To(Equal("/export"))
+		})
+
+		It("refuses shares the organization is not entitled to", func() {
+			fakeChecker.EntitledReturns(false, nil)
+
+			err := provision()
+			Expect(err).To(MatchError("organization org-guid is not entitled to share filer:/export"))
+			Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusForbidden))
+			Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+		})
+
+		Context("when the entitlement check fails", func() {
+			BeforeEach(func() {
+				fakeChecker.EntitledReturns(false, errors.New("entitlement service is down"))
+			})
+
+			It("refuses the request", func() {
+				err := provision()
+				Expect(err).To(MatchError(ContainSubstring("entitlement service is down")))
+				Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusServiceUnavailable))
+				Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+			})
+
+			Context("and the broker fails open", func() {
+				BeforeEach(func() {
+					failOpen = true
+				})
+
+				It("allows the request", func() {
+					Expect(provision()).To(Succeed())
+					Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(1))
+				})
+			})
+		})
+	})
+
+	Context("when binding", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{OrganizationGUID: "org-guid", Share: "filer:/export"}, nil)
+		})
+
+		It("refuses shares the organization is no longer entitled to", func() {
+			fakeChecker.EntitledReturns(false, nil)
+
+			_, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})
+			Expect(err).To(MatchError("organization org-guid is not entitled to share filer:/export"))
+			Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(0))
+
+			_, _, server, path := fakeChecker.EntitledArgsForCall(0)
+			Expect(server).To(Equal("filer"))
+			Expect(path).To(Equal("/export"))
+		})
+	})
+})
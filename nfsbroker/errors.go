@@ -0,0 +1,81 @@
+package nfsbroker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// Stores and the broker wrap these errors, so callers should test for them with errors.Is.
+var (
+	ErrInstanceNotFound = errors.New("service instance not found")
+	ErrBindingNotFound  = errors.New("service binding not found")
+	ErrInstanceConflict = errors.New("service instance already exists with different details")
+	ErrBindingConflict  = errors.New("service binding already exists with different details")
+	ErrInstanceChanged  = errors.New("service instance has changed since the update was requested")
+	ErrStoreUnavailable = errors.New("store unavailable")
+	ErrCorruptRecord    = errors.New("stored record is corrupt")
+	ErrInvalidLabels    = errors.New("invalid labels")
+
+	// ErrOperationInProgress is returned for requests that would change a service instance or binding while an
+	// asynchronous operation on it is unfinished.  Platforms retry them once the operation is over.
+	ErrOperationInProgress = errors.New("another operation is in progress")
+)
+
+// storeUnavailable wraps errors from the database itself, as opposed to errors in the records it returned.  Queries
+// cancelled because the client went away are not the database's fault, so their errors are left alone.
+func storeUnavailable(err error) error {
+	if err == nil || err == sql.ErrNoRows || errors.Is(err, ErrStoreUnavailable) || errors.Is(err, context.Canceled) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+}
+
+// SetLegacyNotFound makes unbind and deprovision requests for unknown bindings and instances fail with 404 Not Found,
+// as earlier versions of the broker did, rather than the 410 Gone the service broker API asks for.
+func (b *Broker) SetLegacyNotFound(legacy bool) {
+	b.legacyNotFound = legacy
+}
+
+// deletionError maps the errors of unbind and deprovision requests like brokerError, keeping to SetLegacyNotFound.
+func (b *Broker) deletionError(err error) error {
+	if b.legacyNotFound {
+		switch {
+		case errors.Is(err, ErrInstanceNotFound):
+			return brokerapi.NewFailureResponse(err, http.StatusNotFound, "instance-missing")
+		case errors.Is(err, ErrBindingNotFound):
+			return brokerapi.NewFailureResponse(err, http.StatusNotFound, "binding-missing")
+		}
+	}
+	return brokerError(err)
+}
+
+// brokerError maps the package's errors to the errors brokerapi turns into service broker API responses.
+func brokerError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrInstanceNotFound):
+		return brokerapi.ErrInstanceDoesNotExist
+	case errors.Is(err, ErrBindingNotFound):
+		return brokerapi.ErrBindingDoesNotExist
+	case errors.Is(err, ErrInstanceConflict):
+		return brokerapi.ErrInstanceAlreadyExists
+	case errors.Is(err, ErrBindingConflict):
+		return brokerapi.ErrBindingAlreadyExists
+	case errors.Is(err, ErrInvalidLabels):
+		return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "invalid-labels")
+	case errors.Is(err, ErrInstanceChanged):
+		return brokerapi.NewFailureResponse(err, http.StatusConflict, "instance-changed")
+	case errors.Is(err, ErrOperationInProgress):
+		return brokerapi.NewFailureResponseBuilder(err, http.StatusUnprocessableEntity, "concurrent-operation").
+			WithErrorKey("ConcurrencyError").Build()
+	case errors.Is(err, ErrStoreUnavailable):
+		return brokerapi.NewFailureResponse(err, http.StatusServiceUnavailable, "store-unavailable")
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+package nfsbroker
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// FoundationHeader names the foundation a request is for.  Without it, requests are routed by their credentials.
+const FoundationHeader = "X-Broker-Foundation"
+
+// Foundation is a platform served by the broker alongside its default one.  Each foundation has its own credentials
+// and its own store: a file named after the foundation, or its own database.
+type Foundation struct {
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// DataDir holds the foundation's file store, in place of the broker's data directory.
+	DataDir string `json:"data_dir,omitempty"`
+
+	// DBName is the foundation's database, when the broker uses a SQL store.  It defaults to the broker's database
+	// name followed by an underscore and the foundation name, and must already exist.
+	DBName string `json:"db_name,omitempty"`
+}
+
+var foundationNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ParseFoundations reads a JSON array of foundations.  Names and credentials must be unique.
+func ParseFoundations(data []byte) ([]Foundation, error) {
+	var foundations []Foundation
+	if err := json.Unmarshal(data, &foundations); err != nil {
+		return nil, fmt.Errorf("invalid foundations: %w", err)
+	}
+
+	names := map[string]bool{}
+	usernames := map[string]bool{}
+	for _, foundation := range foundations {
+		if !foundationNamePattern.MatchString(foundation.Name) {
+			return nil, fmt.Errorf("invalid foundations: name %q must be lowercase letters, digits, - and _", foundation.Name)
+		}
+		if foundation.Username == "" || foundation.Password == "" {
+			return nil, fmt.Errorf("invalid foundations: foundation %q needs a username and a password", foundation.Name)
+		}
+		if names[foundation.Name] {
+			return nil, fmt.Errorf("invalid foundations: foundation %q is defined more than once", foundation.Name)
+		}
+		if usernames[foundation.Username] {
+			return nil, fmt.Errorf("invalid foundations: foundation %q reuses another foundation's username", foundation.Name)
+		}
+		names[foundation.Name] = true
+		usernames[foundation.Username] = true
+	}
+	return foundations, nil
+}
+
+// FoundationRoute is where requests for a foundation are served.  The handler must authenticate requests itself.
+type FoundationRoute struct {
+	Name     string
+	Username string
+	Password string
+	Handler  http.Handler
+}
+
+// NewFoundationRouter sends requests naming a foundation in FoundationHeader to that foundation, requests made with a
+// foundation's credentials to that foundation, and every other request to fallback.
+func NewFoundationRouter(routes []FoundationRoute, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name := r.Header.Get(FoundationHeader); name != "" {
+			for _, route := range routes {
+				if route.Name == name {
+					route.Handler.ServeHTTP(w, r)
+					return
+				}
+			}
+			writeJSON(w, http.StatusNotFound, map[string]string{"description": fmt.Sprintf("unknown foundation %q", name)})
+			return
+		}
+
+		if username, password, ok := r.BasicAuth(); ok {
+			for _, route := range routes {
+				if secureEqual(username, route.Username) && secureEqual(password, route.Password) {
+					route.Handler.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
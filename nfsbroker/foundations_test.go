@@ -0,0 +1,93 @@
+package nfsbroker_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseFoundations", func() {
+	It("reads the foundations", func() {
+		foundations, err := nfsbroker.ParseFoundations([]byte(`[
+			{"name": "east", "username": "east-user", "password": "east-password", "db_name": "nfsbroker_east"},
+			{"name": "west", "username": "west-user", "password": "west-password", "data_dir": "/var/vcap/store/west"}
+		]`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(foundations).To(Equal([]nfsbroker.Foundation{
+			{Name: "east", Username: "east-user", Password: "east-password", DBName: "nfsbroker_east"},
+			{Name: "west", Username: "west-user", Password: "west-password", DataDir: "/var/vcap/store/west"},
+		}))
+	})
+
+	It("requires names usable in file and database names", func() {
+		_, err := nfsbroker.ParseFoundations([]byte(`[{"name": "../east", "username": "u", "password": "p"}]`))
+		Expect(err).To(MatchError(ContainSubstring(`name "../east" must be lowercase letters`)))
+	})
+
+	It("requires credentials", func() {
+		_, err := nfsbroker.ParseFoundations([]byte(`[{"name": "east", "username": "u"}]`))
+		Expect(err).To(MatchError(ContainSubstring("needs a username and a password")))
+	})
+
+	It("rejects duplicate names and usernames", func() {
+		_, err := nfsbroker.ParseFoundations([]byte(`[{"name": "east", "username": "u", "password": "p"}, {"name": "east", "username": "v", "password": "p"}]`))
+		Expect(err).To(MatchError(ContainSubstring(`foundation "east" is defined more than once`)))
+
+		_, err = nfsbroker.ParseFoundations([]byte(`[{"name": "east", "username": "u", "password": "p"}, {"name": "west", "username": "u", "password": "q"}]`))
+		Expect(err).To(MatchError(ContainSubstring(`foundation "west" reuses another foundation's username`)))
+	})
+})
+
+var _ = Describe("FoundationRouter", func() {
+	var router http.Handler
+
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Served-By", name)
+		})
+	}
+
+	BeforeEach(func() {
+		router = nfsbroker.NewFoundationRouter([]nfsbroker.FoundationRoute{
+			{Name: "east", Username: "east-user", Password: "east-password", Handler: named("east")},
+			{Name: "west", Username: "west-user", Password: "west-password", Handler: named("west")},
+		}, named("default"))
+	})
+
+	serve := func(username, password, foundation string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v2/catalog", nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		if foundation != "" {
+			req.Header.Set(nfsbroker.FoundationHeader, foundation)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	It("routes requests by their credentials", func() {
+		Expect(serve("west-user", "west-password", "").Header().Get("Served-By")).To(Equal("west"))
+		Expect(serve("east-user", "east-password", "").Header().Get("Served-By")).To(Equal("east"))
+	})
+
+	It("routes requests with other credentials to the default foundation", func() {
+		Expect(serve("admin", "password", "").Header().Get("Served-By")).To(Equal("default"))
+		Expect(serve("west-user", "wrong-password", "").Header().Get("Served-By")).To(Equal("default"))
+		Expect(serve("", "", "").Header().Get("Served-By")).To(Equal("default"))
+	})
+
+	It("routes requests by the foundation header, leaving authentication to the foundation", func() {
+		Expect(serve("anyone", "anything", "east").Header().Get("Served-By")).To(Equal("east"))
+	})
+
+	It("rejects unknown foundations", func() {
+		recorder := serve("east-user", "east-password", "north")
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		Expect(recorder.Body.String()).To(ContainSubstring(`unknown foundation \"north\"`))
+	})
+})
@@ -0,0 +1,56 @@
+package nfsbroker
+
+import (
+	"context"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const HealthPath = "/health"
+
+// HealthChecker is implemented by stores that can check that they are usable: the SQL store queries its database,
+// and the file store checks that its state file can be read and written.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// CheckHealth checks that the broker's store is usable.  Stores with nothing to check are always healthy.
+func (b *Broker) CheckHealth(ctx context.Context) error {
+	checker, ok := b.store.(HealthChecker)
+	if !ok {
+		return nil
+	}
+	return checker.CheckHealth(ctx)
+}
+
+// NewHealthHandler answers requests with 200 OK while every checker is healthy, and otherwise 503 Service Unavailable
+// with the reason.  It is meant for load balancers, so it does not authenticate requests.
+func NewHealthHandler(logger lager.Logger, checkers ...HealthChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"description": "method not allowed"})
+			return
+		}
+		for _, checker := range checkers {
+			if err := checker.CheckHealth(r.Context()); err != nil {
+				logger.Error("unhealthy", err)
+				writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unhealthy", "description": err.Error()})
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
+	})
+}
+
+// HealthRoute serves HealthPath with health, apart from next, which serves everything else.
+func HealthRoute(health, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == HealthPath {
+			health.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
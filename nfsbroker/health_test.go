@@ -0,0 +1,116 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+type healthCheckerFunc func(ctx context.Context) error
+
+func (f healthCheckerFunc) CheckHealth(ctx context.Context) error {
+	return f(ctx)
+}
+
+var _ = Describe("Health", func() {
+	Describe("NewHealthHandler", func() {
+		var (
+			checkErr error
+			handler  http.Handler
+		)
+
+		BeforeEach(func() {
+			checkErr = nil
+			healthy := healthCheckerFunc(func(context.Context) error { return nil })
+			checked := healthCheckerFunc(func(context.Context) error { return checkErr })
+			handler = nfsbroker.HealthRoute(nfsbroker.NewHealthHandler(lagertest.NewTestLogger("test-health"), healthy, checked), http.NotFoundHandler())
+		})
+
+		get := func(path string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+			return recorder
+		}
+
+		It("answers 200 while every store is healthy", func() {
+			recorder := get("/health")
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"status":"healthy"}`))
+		})
+
+		It("answers 503 with the reason when a store is not", func() {
+			checkErr = errors.New("store unavailable: connection refused")
+			recorder := get("/health")
+			Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"status":"unhealthy","description":"store unavailable: connection refused"}`))
+		})
+
+		It("leaves other requests to the broker", func() {
+			Expect(get("/v2/catalog").Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("file stores", func() {
+		var tempDir string
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "nfsbroker-health")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tempDir)
+		})
+
+		newStore := func(fileName string) nfsbroker.HealthChecker {
+			store := nfsbroker.NewStore(lagertest.NewTestLogger("test-health"), "", "", "", "", "", "", "", fileName, nfsbroker.DefaultMaxValueSize, time.Minute, nil, nil, 0)
+			return store.(nfsbroker.HealthChecker)
+		}
+
+		It("are healthy when their state file can be written, whether or not it exists yet", func() {
+			fileName := filepath.Join(tempDir, "services.json")
+			Expect(newStore(fileName).CheckHealth(context.Background())).To(Succeed())
+			files, err := ioutil.ReadDir(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(BeEmpty())
+
+			Expect(ioutil.WriteFile(fileName, []byte("{}"), 0600)).To(Succeed())
+			Expect(newStore(fileName).CheckHealth(context.Background())).To(Succeed())
+		})
+
+		It("are unhealthy when their state file cannot be written", func() {
+			err := newStore(filepath.Join(tempDir, "missing-dir", "services.json")).CheckHealth(context.Background())
+			Expect(err).To(MatchError(ContainSubstring("state file is not usable")))
+		})
+	})
+
+	Describe("SQL stores", func() {
+		It("are healthy while the database answers queries", func() {
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			store := nfsbroker.SqlStore{Database: nfsbrokerfakes.FakeSQLMockConnection{db}, StoreType: "postgres"}
+
+			mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"one"}).AddRow(1))
+			Expect(store.CheckHealth(context.Background())).To(Succeed())
+
+			mock.ExpectQuery("SELECT 1").WillReturnError(errors.New("connection refused"))
+			err = store.CheckHealth(context.Background())
+			Expect(errors.Is(err, nfsbroker.ErrStoreUnavailable)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("connection refused")))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,80 @@
+package nfsbroker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// The bind parameters that IDResolver resolves into a uid and gid.
+const (
+	IDResolverUsername = "username"
+	IDResolverPassword = "password"
+)
+
+// ErrInvalidUserCredentials is returned by IDResolvers for users that do not exist or gave the wrong password.
+var ErrInvalidUserCredentials = errors.New("invalid username or password")
+
+//go:generate counterfeiter -o ../nfsbrokerfakes/fake_id_resolver.go . IDResolver
+type IDResolver interface {
+	Resolve(ctx context.Context, username, password string) (uid, gid string, err error)
+}
+
+// SetIDResolver lets bindings give a username and password in place of a uid and gid.  The broker resolves them
+// when the binding is made and stores only the resulting IDs.
+func (b *Broker) SetIDResolver(resolver IDResolver) {
+	b.idResolver = resolver
+}
+
+// resolveIDs replaces the username and password bind parameters with the uid and gid they resolve to.  Parameters
+// without a username are returned as they are, as are all parameters when there is no resolver.
+func (b *Broker) resolveIDs(ctx context.Context, logger lager.Logger, parameters map[string]interface{}) (map[string]interface{}, error) {
+	if b.idResolver == nil {
+		return parameters, nil
+	}
+	value, ok := parameters[IDResolverUsername]
+	if !ok {
+		return parameters, nil
+	}
+
+	username, _ := value.(string)
+	password, _ := parameters[IDResolverPassword].(string)
+	if username == "" || password == "" {
+		err := fmt.Errorf("%s and %s must both be non-empty strings", IDResolverUsername, IDResolverPassword)
+		return nil, brokerapi.NewFailureResponse(err, http.StatusBadRequest, "invalid-bind-parameters")
+	}
+	for _, name := range []string{"uid", "gid"} {
+		if _, ok := parameters[name]; ok {
+			err := fmt.Errorf("%s cannot be given with %s", name, IDResolverUsername)
+			return nil, brokerapi.NewFailureResponse(err, http.StatusBadRequest, "invalid-bind-parameters")
+		}
+	}
+
+	spanCtx, endSpan := startSpan(ctx, "resolve-ids")
+	uid, gid, err := b.idResolver.Resolve(spanCtx, username, password)
+	endSpan(err)
+	if errors.Is(err, ErrInvalidUserCredentials) {
+		logger.Info("invalid-user-credentials", lager.Data{"username": username})
+		return nil, brokerapi.NewFailureResponse(err, http.StatusBadRequest, "invalid-user-credentials")
+	}
+	if err != nil {
+		logger.Error("failed-to-resolve-ids", err, lager.Data{"username": username})
+		err = fmt.Errorf("failed to look up the uid and gid of %s: %w", username, err)
+		return nil, brokerapi.NewFailureResponse(err, http.StatusServiceUnavailable, "id-resolution-failed")
+	}
+	logger.Info("resolved-ids", lager.Data{"username": username, "uid": uid, "gid": gid})
+
+	resolved := map[string]interface{}{}
+	for name, value := range parameters {
+		resolved[name] = value
+	}
+	delete(resolved, IDResolverUsername)
+	delete(resolved, IDResolverPassword)
+	resolved["uid"] = uid
+	resolved["gid"] = gid
+	return resolved, nil
+}
@@ -0,0 +1,118 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("ID resolution", func() {
+	var (
+		broker   *nfsbroker.Broker
+		store    nfsbroker.Store
+		resolver *nfsbrokerfakes.FakeIDResolver
+		ctx      context.Context
+	)
+
+	bind := func(parameters map[string]interface{}) (brokerapi.Binding, error) {
+		return broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid", Parameters: parameters})
+	}
+
+	BeforeEach(func() {
+		configDetails := nfsbroker.NewNfsBrokerConfigDetails()
+		Expect(configDetails.ReadConf("uid,gid", "")).To(Succeed())
+		store = nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-id-resolver"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, store, nfsbroker.NewNfsBrokerConfig(configDetails))
+		resolver = &nfsbrokerfakes.FakeIDResolver{}
+		resolver.ResolveReturns("1001", "2001", nil)
+		broker.SetIDResolver(resolver)
+		ctx = context.Background()
+
+		_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+			ServiceID:     "service-id",
+			PlanID:        "Existing",
+			RawParameters: json.RawMessage(`{"share":"server:/export"}`),
+		}, false)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("mounts the share with the IDs of the user", func() {
+		binding, err := bind(map[string]interface{}{"username": "user1", "password": "secret"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(resolver.ResolveCallCount()).To(Equal(1))
+		_, username, password := resolver.ResolveArgsForCall(0)
+		Expect(username).To(Equal("user1"))
+		Expect(password).To(Equal("secret"))
+
+		mountConfig := binding.VolumeMounts[0].Device.MountConfig
+		Expect(mountConfig).To(HaveKeyWithValue("uid", "1001"))
+		Expect(mountConfig).To(HaveKeyWithValue("gid", "2001"))
+		Expect(mountConfig).NotTo(HaveKey("username"))
+		Expect(mountConfig).NotTo(HaveKey("password"))
+	})
+
+	It("stores the IDs and never the username or password", func() {
+		_, err := bind(map[string]interface{}{"username": "user1", "password": "secret"})
+		Expect(err).NotTo(HaveOccurred())
+
+		details, err := store.RetrieveBindingDetails(ctx, "binding-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(details.Parameters).To(HaveKeyWithValue("uid", "1001"))
+		Expect(details.Parameters).NotTo(HaveKey("username"))
+		Expect(details.Parameters).NotTo(HaveKey("password"))
+
+		spec, err := broker.GetBinding(ctx, "instance-id", "binding-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.VolumeMounts[0].Device.MountConfig).To(HaveKeyWithValue("uid", "1001"))
+	})
+
+	It("refuses wrong credentials", func() {
+		resolver.ResolveReturns("", "", nfsbroker.ErrInvalidUserCredentials)
+		_, err := bind(map[string]interface{}{"username": "user1", "password": "wrong"})
+		Expect(err).To(MatchError("invalid username or password"))
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusBadRequest))
+	})
+
+	It("reports a directory that cannot be reached as unavailable", func() {
+		resolver.ResolveReturns("", "", errors.New("connection refused"))
+		_, err := bind(map[string]interface{}{"username": "user1", "password": "secret"})
+		Expect(err).To(MatchError(ContainSubstring("failed to look up the uid and gid of user1")))
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("rejects usernames given with IDs or without a password", func() {
+		_, err := bind(map[string]interface{}{"username": "user1", "password": "secret", "uid": "1000"})
+		Expect(err).To(MatchError("uid cannot be given with username"))
+		_, err = bind(map[string]interface{}{"username": "user1"})
+		Expect(err).To(MatchError("username and password must both be non-empty strings"))
+		Expect(resolver.ResolveCallCount()).To(Equal(0))
+	})
+
+	It("still accepts IDs", func() {
+		binding, err := bind(map[string]interface{}{"uid": "1000", "gid": "1000"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(binding.VolumeMounts[0].Device.MountConfig).To(HaveKeyWithValue("uid", "1000"))
+		Expect(resolver.ResolveCallCount()).To(Equal(0))
+	})
+
+	It("documents the username and password", func() {
+		doc := broker.Parameters(ctx)
+		names := []string{}
+		for _, param := range doc.Bind {
+			names = append(names, param.Name)
+		}
+		Expect(names).To(ContainElement("username"))
+		Expect(names).To(ContainElement("password"))
+	})
+})
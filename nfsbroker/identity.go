@@ -0,0 +1,109 @@
+package nfsbroker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const OriginatingIdentityHeader = "X-Broker-API-Originating-Identity"
+
+type requestIdentityKey struct{}
+
+type requestIdentity struct {
+	actor               string
+	originatingIdentity string
+	originatingUser     string
+}
+
+func WithRequestIdentity(ctx context.Context, actor, originatingIdentity string) context.Context {
+	user, _ := ParseOriginatingIdentity(originatingIdentity)
+	return context.WithValue(ctx, requestIdentityKey{}, requestIdentity{
+		actor:               actor,
+		originatingIdentity: originatingIdentity,
+		originatingUser:     user,
+	})
+}
+
+// RequestActor returns the broker API user that made the request carried by ctx, if known.
+func RequestActor(ctx context.Context) string {
+	identity, _ := ctx.Value(requestIdentityKey{}).(requestIdentity)
+	return identity.actor
+}
+
+// OriginatingIdentity returns the platform user on whose behalf the request carried by ctx was made, if known.
+func OriginatingIdentity(ctx context.Context) string {
+	identity, _ := ctx.Value(requestIdentityKey{}).(requestIdentity)
+	return identity.originatingIdentity
+}
+
+// OriginatingUser returns the ID of the platform user on whose behalf the request carried by ctx was made, if the
+// request carried a well-formed originating identity.
+func OriginatingUser(ctx context.Context) string {
+	identity, _ := ctx.Value(requestIdentityKey{}).(requestIdentity)
+	return identity.originatingUser
+}
+
+// ParseOriginatingIdentity returns the user ID carried by an originating identity header, which is the platform name
+// followed by base64 encoded JSON properties.  Cloud Foundry identifies users by their user_id property, Kubernetes
+// by their uid.
+func ParseOriginatingIdentity(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return "", fmt.Errorf("originating identity must be a platform and its encoded properties")
+	}
+
+	encoded, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", fmt.Errorf("originating identity properties are not base64 encoded: %w", err)
+	}
+	var properties map[string]interface{}
+	if err := json.Unmarshal(encoded, &properties); err != nil {
+		return "", fmt.Errorf("originating identity properties are not a JSON object: %w", err)
+	}
+
+	key := "user_id"
+	if fields[0] == "kubernetes" {
+		key = "uid"
+	}
+	user, _ := properties[key].(string)
+	if user == "" {
+		return "", fmt.Errorf("originating identity of platform %q has no %s", fields[0], key)
+	}
+	return user, nil
+}
+
+// identityData is the ID and originating user of the request carried by ctx, for logging.
+func identityData(ctx context.Context) lager.Data {
+	data := lager.Data{}
+	if id := RequestID(ctx); id != "" {
+		data["requestID"] = id
+	}
+	if user := OriginatingUser(ctx); user != "" {
+		data["originatingUser"] = user
+	}
+	return data
+}
+
+// RequestIdentityHandler records the caller's basic auth username and originating identity header in the request
+// context so that the store can attribute mutations.  Requests whose originating identity cannot be parsed are still
+// served, without an originating user.
+func RequestIdentityHandler(logger lager.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor, _, _ := r.BasicAuth()
+		originatingIdentity := r.Header.Get(OriginatingIdentityHeader)
+		if _, err := ParseOriginatingIdentity(originatingIdentity); err != nil {
+			logger.Info("invalid-originating-identity", lager.Data{"actor": actor, "path": r.URL.Path, "error": err.Error()})
+		}
+		ctx := WithRequestIdentity(r.Context(), actor, originatingIdentity)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,101 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("RequestIdentityHandler", func() {
+	var (
+		logger                   *lagertest.TestLogger
+		request                  *http.Request
+		actor, originating, user string
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-identity")
+		request = httptest.NewRequest("PUT", "/v2/service_instances/some-id", nil)
+	})
+
+	JustBeforeEach(func() {
+		handler := nfsbroker.RequestIdentityHandler(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actor = nfsbroker.RequestActor(r.Context())
+			originating = nfsbroker.OriginatingIdentity(r.Context())
+			user = nfsbroker.OriginatingUser(r.Context())
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	})
+
+	Context("when the request carries credentials and an originating identity", func() {
+		BeforeEach(func() {
+			request.SetBasicAuth("admin", "secret")
+			request.Header.Set(nfsbroker.OriginatingIdentityHeader, "cloudfoundry eyJ1c2VyX2lkIjoiNjgzZWE3NDgifQ==")
+		})
+
+		It("should make them available from the request context", func() {
+			Expect(actor).To(Equal("admin"))
+			Expect(originating).To(Equal("cloudfoundry eyJ1c2VyX2lkIjoiNjgzZWE3NDgifQ=="))
+			Expect(user).To(Equal("683ea748"))
+		})
+	})
+
+	Context("when the originating identity is malformed", func() {
+		BeforeEach(func() {
+			request.Header.Set(nfsbroker.OriginatingIdentityHeader, "cloudfoundry not-base64")
+		})
+
+		It("should serve the request without an originating user", func() {
+			Expect(originating).To(Equal("cloudfoundry not-base64"))
+			Expect(user).To(BeEmpty())
+			Expect(logger.Buffer()).To(gbytes.Say("invalid-originating-identity"))
+		})
+	})
+
+	Context("when the request is anonymous", func() {
+		It("should leave the identity empty", func() {
+			Expect(actor).To(BeEmpty())
+			Expect(originating).To(BeEmpty())
+			Expect(user).To(BeEmpty())
+			Expect(logger.LogMessages()).To(BeEmpty())
+		})
+	})
+
+	It("should return an empty identity for contexts without one", func() {
+		Expect(nfsbroker.RequestActor(context.TODO())).To(BeEmpty())
+		Expect(nfsbroker.OriginatingIdentity(context.TODO())).To(BeEmpty())
+		Expect(nfsbroker.OriginatingUser(context.TODO())).To(BeEmpty())
+	})
+})
+
+var _ = Describe("ParseOriginatingIdentity", func() {
+	It("should return the user ID of Cloud Foundry identities", func() {
+		Expect(nfsbroker.ParseOriginatingIdentity("cloudfoundry eyJ1c2VyX2lkIjoiNjgzZWE3NDgifQ==")).To(Equal("683ea748"))
+	})
+
+	It("should return the uid of Kubernetes identities", func() {
+		Expect(nfsbroker.ParseOriginatingIdentity("kubernetes eyJ1c2VybmFtZSI6ImR1a2UiLCJ1aWQiOiJjMmRkZTI0MiJ9")).To(Equal("c2dde242"))
+	})
+
+	It("should accept a missing header", func() {
+		Expect(nfsbroker.ParseOriginatingIdentity("")).To(BeEmpty())
+	})
+
+	It("should reject malformed identities", func() {
+		for value, message := range map[string]string{
+			"cloudfoundry":              "must be a platform and its encoded properties",
+			"cloudfoundry %%%":          "not base64 encoded",
+			"cloudfoundry bm90LWpzb24=": "not a JSON object",
+			"cloudfoundry e30=":         "has no user_id",
+		} {
+			_, err := nfsbroker.ParseOriginatingIdentity(value)
+			Expect(err).To(MatchError(ContainSubstring(message)), value)
+		}
+	})
+})
@@ -0,0 +1,185 @@
+package nfsbroker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+const ServiceInstancesPath = "/v2/service_instances/"
+
+// ErrInstanceProvisioning is returned for instances whose asynchronous provision has not finished.
+var ErrInstanceProvisioning = errors.New("service instance is still being provisioned")
+
+// InstanceSpec is the body of a fetch service instance response.  Parameters only include values that are safe to
+// show to anyone who can see the instance.
+type InstanceSpec struct {
+	ServiceID    string                 `json:"service_id"`
+	PlanID       string                 `json:"plan_id"`
+	DashboardURL string                 `json:"dashboard_url,omitempty"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"`
+
+	MaintenanceInfo *MaintenanceInfo  `json:"maintenance_info,omitempty"`
+	Metadata        *InstanceMetadata `json:"metadata,omitempty"`
+}
+
+// GetInstance returns the stored details of a provisioned service instance.
+func (b *Broker) GetInstance(ctx context.Context, instanceID string) (InstanceSpec, error) {
+	logger := b.logger.Session("get-instance", identityData(ctx)).WithData(lager.Data{"instanceID": instanceID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := b.lockFor(ctx); err != nil {
+		return InstanceSpec{}, err
+	}
+	defer b.mutex.Unlock()
+
+	details, err := b.store.RetrieveInstanceDetails(ctx, instanceID)
+	if err != nil {
+		return InstanceSpec{}, err
+	}
+	operation, err := b.store.RetrieveOperation(ctx, instanceID)
+	if err != nil {
+		return InstanceSpec{}, err
+	}
+	if operation.Type == ProvisionOperation && operation.State == brokerapi.InProgress {
+		return InstanceSpec{}, ErrInstanceProvisioning
+	}
+
+	spec := InstanceSpec{
+		ServiceID:    details.ServiceID,
+		PlanID:       details.PlanID,
+		DashboardURL: details.DashboardURL,
+		Parameters:   map[string]interface{}{"share": details.Share},
+	}
+	if len(details.Shares) > 0 {
+		spec.Parameters["shares"] = details.Shares
+	}
+	if details.NFSVersion != "" {
+		spec.Parameters["version"] = details.NFSVersion
+	}
+	if details.MaintenanceVersion != "" {
+		spec.MaintenanceInfo = &MaintenanceInfo{Version: details.MaintenanceVersion}
+	}
+	if len(details.Labels) > 0 {
+		spec.Metadata = &InstanceMetadata{Labels: details.Labels}
+	}
+	return spec, nil
+}
+
+// BindingSpec is the body of a fetch service binding response.  Volume mounts are rebuilt from the stored binding,
+// so mount config options given as secret bind parameters are left out.
+type BindingSpec struct {
+	Credentials  interface{}             `json:"credentials"`
+	VolumeMounts []brokerapi.VolumeMount `json:"volume_mounts"`
+	Parameters   map[string]interface{}  `json:"parameters,omitempty"`
+	Metadata     *BindingMetadata        `json:"metadata,omitempty"`
+}
+
+// GetBinding returns the volume mounts and non-secret parameters of a service binding.
+func (b *Broker) GetBinding(ctx context.Context, instanceID, bindingID string) (BindingSpec, error) {
+	logger := b.logger.Session("get-binding", identityData(ctx)).WithData(lager.Data{"instanceID": instanceID, "bindingID": bindingID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := b.lockFor(ctx); err != nil {
+		return BindingSpec{}, err
+	}
+	defer b.mutex.Unlock()
+
+	instanceDetails, err := b.store.RetrieveInstanceDetails(ctx, instanceID)
+	if err != nil {
+		return BindingSpec{}, err
+	}
+	bindDetails, err := b.store.RetrieveBindingDetails(ctx, bindingID)
+	if err != nil {
+		return BindingSpec{}, err
+	}
+	if err := b.checkBindingOwner(ctx, instanceID, bindingID); err != nil {
+		return BindingSpec{}, err
+	}
+
+	// secrets are only stored, encrypted, by brokers with a binding parameter key, and are only used for the mounts
+	mountParameters := withoutRecordedBindParameters(bindDetails.Parameters)
+	parameters := withoutSecretBindParameters(bindDetails.Parameters)
+	mode, err := b.bindMode(instanceDetails, mountParameters)
+	if err != nil {
+		return BindingSpec{}, err
+	}
+	if recordedReadOnly(bindDetails.Parameters) {
+		mode = "r"
+	}
+	volumeMounts, err := b.volumeMounts(logger, instanceID, bindingID, instanceDetails, mode, mountParameters)
+	if err != nil {
+		return BindingSpec{}, err
+	}
+	credentials, err := b.bindingCredentials(instanceID, bindingID, instanceDetails, volumeMounts)
+	if err != nil {
+		return BindingSpec{}, err
+	}
+
+	return BindingSpec{
+		Credentials:  credentials,
+		VolumeMounts: volumeMounts,
+		Parameters:   parameters,
+		Metadata:     b.bindingMetadata(ctx, logger, instanceDetails),
+	}, nil
+}
+
+// checkBindingOwner treats bindings of other instances as not found.  Bindings stored before their instance was
+// recorded are taken to belong to any instance.
+func (b *Broker) checkBindingOwner(ctx context.Context, instanceID, bindingID string) error {
+	bindingInstances, err := b.store.ListBindingInstances(ctx)
+	if err != nil {
+		return err
+	}
+	if owner := bindingInstances[bindingID]; owner != "" && owner != instanceID {
+		return fmt.Errorf("%w: %s belongs to service instance %s", ErrBindingNotFound, bindingID, owner)
+	}
+	return nil
+}
+
+// NewInstanceHandler serves GET /v2/service_instances/:instance_id and
+// GET /v2/service_instances/:instance_id/service_bindings/:binding_id, which the broker API library does not, and
+// passes every other request on to next.  It does no authentication of its own.
+func NewInstanceHandler(logger lager.Logger, broker *Broker, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || !strings.HasPrefix(r.URL.Path, ServiceInstancesPath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var (
+			spec interface{}
+			err  error
+		)
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, ServiceInstancesPath), "/")
+		switch {
+		case isInstancePath(r.URL.Path):
+			spec, err = broker.GetInstance(r.Context(), parts[0])
+		case len(parts) == 3 && parts[0] != "" && parts[1] == "service_bindings" && parts[2] != "":
+			spec, err = broker.GetBinding(r.Context(), parts[0], parts[2])
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch {
+		case err == nil:
+			writeJSON(w, http.StatusOK, spec)
+		case errors.Is(err, ErrInstanceNotFound), errors.Is(err, ErrBindingNotFound), errors.Is(err, ErrInstanceProvisioning):
+			writeJSON(w, http.StatusNotFound, map[string]string{"description": err.Error()})
+		case errors.Is(err, ErrStoreUnavailable):
+			logger.Error("fetch-failed", err)
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"description": err.Error()})
+		default:
+			logger.Error("fetch-failed", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"description": err.Error()})
+		}
+	})
+}
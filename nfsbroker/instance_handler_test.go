@@ -0,0 +1,156 @@
+package nfsbroker_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("InstanceHandler", func() {
+	var (
+		handler    http.Handler
+		fakeStore  *nfsbrokerfakes.FakeStore
+		recorder   *httptest.ResponseRecorder
+		nextCalled bool
+		method     string
+		path       string
+	)
+
+	BeforeEach(func() {
+		logger := lagertest.NewTestLogger("test-instance-handler")
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		broker := nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, fakeStore,
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		nextCalled = false
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nextCalled = true
+		})
+		handler = nfsbroker.NewInstanceHandler(logger, broker, next)
+		recorder = httptest.NewRecorder()
+		method = "GET"
+		path = "/v2/service_instances/instance-id"
+
+		fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{ServiceID: "service-id", PlanID: "Existing", Share: "server/export"}, nil)
+	})
+
+	JustBeforeEach(func() {
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	})
+
+	It("returns the instance's service, plan and share", func() {
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(MatchJSON(`{"service_id":"service-id","plan_id":"Existing","parameters":{"share":"server/export"}}`))
+		Expect(nextCalled).To(BeFalse())
+		_, id := fakeStore.RetrieveInstanceDetailsArgsForCall(0)
+		Expect(id).To(Equal("instance-id"))
+	})
+
+	Context("when the instance does not exist", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound)
+		})
+
+		It("returns not found", func() {
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("when the instance is still being provisioned", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveOperationReturns(nfsbroker.Operation{Type: nfsbroker.ProvisionOperation, State: brokerapi.InProgress}, nil)
+		})
+
+		It("returns not found", func() {
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"description":"service instance is still being provisioned"}`))
+		})
+	})
+
+	Context("when the store fails", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, errors.New("badness"))
+		})
+
+		It("reports the failure", func() {
+			Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		})
+	})
+
+	Context("when the request is not a fetch", func() {
+		BeforeEach(func() {
+			method = "PUT"
+		})
+
+		It("passes it on", func() {
+			Expect(nextCalled).To(BeTrue())
+			Expect(fakeStore.RetrieveInstanceDetailsCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the request is for a binding", func() {
+		BeforeEach(func() {
+			path = "/v2/service_instances/instance-id/service_bindings/binding-id"
+			fakeStore.RetrieveBindingDetailsReturns(brokerapi.BindDetails{
+				AppGUID:    "app-guid",
+				Parameters: map[string]interface{}{nfsbroker.HashKey: "hash", "mount": "/data", "readonly": true},
+			}, nil)
+			fakeStore.ListBindingInstancesReturns(map[string]string{"binding-id": "instance-id"}, nil)
+		})
+
+		It("returns the binding's volume mounts and parameters", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(nextCalled).To(BeFalse())
+
+			var spec nfsbroker.BindingSpec
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &spec)).To(Succeed())
+			Expect(spec.Parameters).To(Equal(map[string]interface{}{"mount": "/data", "readonly": true}))
+			Expect(spec.VolumeMounts).To(HaveLen(1))
+			Expect(spec.VolumeMounts[0].ContainerDir).To(Equal("/data"))
+			Expect(spec.VolumeMounts[0].Driver).To(Equal("nfsv3driver"))
+			Expect(spec.VolumeMounts[0].Device.MountConfig).To(Equal(map[string]interface{}{"source": "nfs://server/export", "readonly": true}))
+			Expect(spec.VolumeMounts[0].Device.VolumeId).To(HavePrefix("instance-id-"))
+
+			_, id := fakeStore.RetrieveBindingDetailsArgsForCall(0)
+			Expect(id).To(Equal("binding-id"))
+		})
+
+		Context("when the binding does not exist", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveBindingDetailsReturns(brokerapi.BindDetails{}, nfsbroker.ErrBindingNotFound)
+			})
+
+			It("returns not found", func() {
+				Expect(recorder.Code).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("when the binding belongs to another instance", func() {
+			BeforeEach(func() {
+				fakeStore.ListBindingInstancesReturns(map[string]string{"binding-id": "other-instance-id"}, nil)
+			})
+
+			It("returns not found", func() {
+				Expect(recorder.Code).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+
+	Context("when the request is for something else under an instance", func() {
+		BeforeEach(func() {
+			path = "/v2/service_instances/instance-id/last_operation"
+		})
+
+		It("passes it on", func() {
+			Expect(nextCalled).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,29 @@
+package nfsbroker
+
+import (
+	"context"
+	"time"
+)
+
+// RetrieveJobNextRun and SaveJobNextRun let the scheduler persist job state in the broker's store.
+func (b *Broker) RetrieveJobNextRun(ctx context.Context, name string) (time.Time, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.store.RetrieveJobNextRun(ctx, name)
+}
+
+func (b *Broker) SaveJobNextRun(ctx context.Context, name string, next time.Time) (e error) {
+	logger := b.logger.Session("save-job-next-run")
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	defer func() {
+		out := b.store.Save(logger)
+		if e == nil {
+			e = out
+		}
+	}()
+
+	return b.store.SaveJobNextRun(ctx, name, next)
+}
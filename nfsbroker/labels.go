@@ -0,0 +1,124 @@
+package nfsbroker
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	maxLabels          = 50
+	maxLabelValueBytes = 255
+)
+
+// labelKeyPattern allows keys such as "cost-center" or "example.com/owner": letters, digits, '-', '_', '.' and
+// '/', starting and ending with a letter or digit.
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_./-]{0,61}[A-Za-z0-9])?$`)
+
+// InstanceMetadata is the metadata of a fetch service instance response.
+type InstanceMetadata struct {
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// provisionLabels reads the labels provision parameter, which must be an object of strings.
+func provisionLabels(parameters map[string]interface{}) (map[string]string, error) {
+	value, ok := parameters["labels"].(map[string]interface{})
+	if !ok || len(value) == 0 {
+		return nil, nil
+	}
+
+	labels := map[string]string{}
+	for key, value := range value {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: the value of %q must be a string", ErrInvalidLabels, key)
+		}
+		labels[key] = s
+	}
+	return labels, validateLabels(labels)
+}
+
+// mergeLabels applies changes to labels, removing those changed to nil.  The result is nil when no labels are left.
+func mergeLabels(labels map[string]string, changes map[string]*string) (map[string]string, error) {
+	merged := map[string]string{}
+	for key, value := range labels {
+		merged[key] = value
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(merged, key)
+		} else {
+			merged[key] = *value
+		}
+	}
+	if err := validateLabels(merged); err != nil {
+		return nil, err
+	}
+	if len(merged) == 0 {
+		return nil, nil
+	}
+	return merged, nil
+}
+
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("%w: an instance can have at most %d labels", ErrInvalidLabels, maxLabels)
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !labelKeyPattern.MatchString(key) {
+			return fmt.Errorf("%w: %q is not a valid label name", ErrInvalidLabels, key)
+		}
+		if len(labels[key]) > maxLabelValueBytes {
+			return fmt.Errorf("%w: the value of %q is longer than %d bytes", ErrInvalidLabels, key, maxLabelValueBytes)
+		}
+	}
+	return nil
+}
+
+// UpdateInstanceLabels applies changes to the labels of a service instance, removing those changed to nil, and
+// returns the labels the instance is left with.
+func (b *Broker) UpdateInstanceLabels(ctx context.Context, instanceID string, changes map[string]*string) (_ map[string]string, e error) {
+	logger := b.logger.Session("update-instance-labels", identityData(ctx)).WithData(lager.Data{"instanceID": instanceID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := b.lockFor(ctx); err != nil {
+		return nil, err
+	}
+	defer b.mutex.Unlock()
+	defer func() {
+		out := b.store.Save(logger)
+		if e == nil {
+			e = out
+		}
+	}()
+
+	instanceDetails, err := b.store.RetrieveInstanceDetails(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := mergeLabels(instanceDetails.Labels, changes)
+	if err != nil {
+		return nil, err
+	}
+	instanceDetails.Labels = labels
+
+	err = b.store.UpdateInstanceDetails(committed(ctx), instanceID, instanceDetails)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update instance details %s: %w", instanceID, err)
+	}
+	logger.Info("service-instance-labels-updated", lager.Data{"labels": labels})
+
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return labels, nil
+}
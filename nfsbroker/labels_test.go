@@ -0,0 +1,143 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Instance labels", func() {
+	var (
+		logger *lagertest.TestLogger
+		broker *nfsbroker.Broker
+		store  nfsbroker.Store
+		ctx    context.Context
+	)
+
+	provision := func(parameters string) error {
+		_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+			ServiceID:     "service-id",
+			PlanID:        "Existing",
+			RawParameters: json.RawMessage(parameters),
+		}, false)
+		return err
+	}
+
+	storedLabels := func() map[string]string {
+		details, err := store.RetrieveInstanceDetails(ctx, "instance-id")
+		Expect(err).NotTo(HaveOccurred())
+		return details.Labels
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-labels")
+		store = nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)
+		broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, store,
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		ctx = context.Background()
+	})
+
+	It("stores labels given when provisioning and returns them with the instance", func() {
+		Expect(provision(`{"share":"server:/some-share","labels":{"cost-center":"cc-1234","team":"storage"}}`)).To(Succeed())
+		Expect(storedLabels()).To(Equal(map[string]string{"cost-center": "cc-1234", "team": "storage"}))
+
+		spec, err := broker.GetInstance(ctx, "instance-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.Metadata).To(Equal(&nfsbroker.InstanceMetadata{Labels: map[string]string{"cost-center": "cc-1234", "team": "storage"}}))
+	})
+
+	It("leaves the metadata out for instances without labels", func() {
+		Expect(provision(`{"share":"server:/some-share"}`)).To(Succeed())
+		spec, err := broker.GetInstance(ctx, "instance-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.Metadata).To(BeNil())
+	})
+
+	It("rejects labels that are not strings", func() {
+		err := provision(`{"share":"server:/some-share","labels":{"cost-center":1234}}`)
+		Expect(err).To(MatchError(`invalid labels: the value of "cost-center" must be a string`))
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusBadRequest))
+	})
+
+	It("rejects invalid label names", func() {
+		err := provision(`{"share":"server:/some-share","labels":{"-cost center":"cc-1234"}}`)
+		Expect(err).To(MatchError(ContainSubstring(`"-cost center" is not a valid label name`)))
+	})
+
+	It("rejects labels that are not an object", func() {
+		Expect(provision(`{"share":"server:/some-share","labels":"cc-1234"}`)).To(Equal(brokerapi.ErrRawParamsInvalid))
+	})
+
+	Context("when updating", func() {
+		BeforeEach(func() {
+			Expect(provision(`{"share":"server:/some-share","labels":{"cost-center":"cc-1234","team":"storage"}}`)).To(Succeed())
+		})
+
+		It("merges the labels, removing those set to null", func() {
+			_, err := broker.Update(ctx, "instance-id", brokerapi.UpdateDetails{
+				RawParameters: json.RawMessage(`{"labels":{"cost-center":"cc-5678","team":null,"owner":"alice"}}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(storedLabels()).To(Equal(map[string]string{"cost-center": "cc-5678", "owner": "alice"}))
+		})
+
+		It("keeps the labels when none are given", func() {
+			_, err := broker.Update(ctx, "instance-id", brokerapi.UpdateDetails{RawParameters: json.RawMessage(`{}`)}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(storedLabels()).To(HaveLen(2))
+		})
+	})
+
+	Describe("the admin endpoint", func() {
+		var handler http.Handler
+
+		serve := func(method, path, body string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+			return recorder
+		}
+
+		BeforeEach(func() {
+			handler = nfsbroker.NewAdminHandler(logger, broker)
+			Expect(provision(`{"share":"server:/some-share","labels":{"team":"storage"}}`)).To(Succeed())
+		})
+
+		It("updates the labels of an instance", func() {
+			recorder := serve("POST", nfsbroker.AdminInstanceLabelsPath, `{"instance_id":"instance-id","labels":{"cost-center":"cc-1234"}}`)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body).To(MatchJSON(`{"instance_id":"instance-id","labels":{"cost-center":"cc-1234","team":"storage"}}`))
+
+			recorder = serve("GET", nfsbroker.AdminInstancesPath, "")
+			Expect(recorder.Body.String()).To(ContainSubstring(`"labels":{"cost-center":"cc-1234","team":"storage"}`))
+
+			recorder = serve("POST", nfsbroker.AdminInstanceLabelsPath, `{"instance_id":"instance-id","labels":{"cost-center":null,"team":null}}`)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body).To(MatchJSON(`{"instance_id":"instance-id","labels":{}}`))
+			Expect(storedLabels()).To(BeNil())
+		})
+
+		It("rejects invalid labels", func() {
+			recorder := serve("POST", nfsbroker.AdminInstanceLabelsPath, `{"instance_id":"instance-id","labels":{"":"x"}}`)
+			Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+			Expect(storedLabels()).To(Equal(map[string]string{"team": "storage"}))
+		})
+
+		It("answers requests for unknown instances with not found", func() {
+			recorder := serve("POST", nfsbroker.AdminInstanceLabelsPath, `{"instance_id":"missing","labels":{"team":"x"}}`)
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("rejects requests without an instance", func() {
+			Expect(serve("POST", nfsbroker.AdminInstanceLabelsPath, `{"labels":{}}`).Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
@@ -0,0 +1,121 @@
+package nfsbroker
+
+import (
+	"database/sql"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+)
+
+// LeaderLockName is the advisory lock held by the broker instance that runs background jobs.
+const LeaderLockName = "nfsbroker-leader"
+
+// AdvisoryLocker is implemented by variants whose databases provide advisory locks.
+type AdvisoryLocker interface {
+	// TryLockQuery returns a statement that takes the named lock without waiting and selects whether it was taken.
+	TryLockQuery(name string) (string, []interface{})
+
+	// UnlockQuery returns a statement that releases the named lock, or "" if ending the transaction releases it.
+	UnlockQuery(name string) (string, []interface{})
+}
+
+// LeaderLock elects one leader among broker instances sharing a database.  The lock is taken in a transaction that
+// stays open while the instance leads, so that it is held by a single connection and released if that connection
+// is lost.
+type LeaderLock struct {
+	logger   lager.Logger
+	db       SqlConnection
+	locker   AdvisoryLocker
+	clock    clock.Clock
+	name     string
+	interval time.Duration
+
+	leader int32
+}
+
+// LeaderLock returns a runner that competes for leadership every interval, or nil if the database has no advisory
+// locks.
+func (s *SqlStore) LeaderLock(logger lager.Logger, clock clock.Clock, name string, interval time.Duration) *LeaderLock {
+	if s.Locker == nil {
+		return nil
+	}
+	return &LeaderLock{
+		logger:   logger,
+		db:       s.Database,
+		locker:   s.Locker,
+		clock:    clock,
+		name:     name,
+		interval: interval,
+	}
+}
+
+func (l *LeaderLock) IsLeader() bool {
+	return atomic.LoadInt32(&l.leader) == 1
+}
+
+func (l *LeaderLock) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	logger := l.logger.Session("leader-lock", lager.Data{"lock": l.name})
+	close(ready)
+
+	ticker := l.clock.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	var tx *sql.Tx
+	for {
+		if tx == nil {
+			tx = l.tryAcquire(logger)
+		} else if err := tx.QueryRow("SELECT 1").Scan(new(int)); err != nil {
+			logger.Error("lost-leader-lock", err)
+			atomic.StoreInt32(&l.leader, 0)
+			tx.Rollback()
+			tx = nil
+		}
+
+		select {
+		case <-signals:
+			if tx != nil {
+				l.release(logger, tx)
+			}
+			return nil
+		case <-ticker.C():
+		}
+	}
+}
+
+func (l *LeaderLock) tryAcquire(logger lager.Logger) *sql.Tx {
+	tx, err := l.db.Begin()
+	if err != nil {
+		logger.Error("failed-to-begin-transaction", err)
+		return nil
+	}
+
+	query, args := l.locker.TryLockQuery(l.name)
+	var acquired sql.NullBool
+	if err := tx.QueryRow(query, args...).Scan(&acquired); err != nil {
+		logger.Error("failed-to-take-leader-lock", err)
+		tx.Rollback()
+		return nil
+	}
+	if !acquired.Bool {
+		tx.Rollback()
+		return nil
+	}
+
+	logger.Info("acquired-leader-lock")
+	atomic.StoreInt32(&l.leader, 1)
+	return tx
+}
+
+func (l *LeaderLock) release(logger lager.Logger, tx *sql.Tx) {
+	atomic.StoreInt32(&l.leader, 0)
+	if query, args := l.locker.UnlockQuery(l.name); query != "" {
+		if err := tx.QueryRow(query, args...).Scan(new(sql.NullBool)); err != nil {
+			logger.Error("failed-to-release-leader-lock", err)
+		}
+	}
+	tx.Rollback()
+	logger.Info("released-leader-lock")
+}
@@ -0,0 +1,99 @@
+package nfsbroker_test
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tedsuo/ifrit"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("LeaderLock", func() {
+	var (
+		mock       sqlmock.Sqlmock
+		fakeClock  *fakeclock.FakeClock
+		leaderLock *nfsbroker.LeaderLock
+		process    ifrit.Process
+	)
+
+	BeforeEach(func() {
+		db, sqlMock, err := sqlmock.New()
+		Expect(err).NotTo(HaveOccurred())
+		mock = sqlMock
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		locker := nfsbroker.NewMySqlVariant("username", "password", "host", "3306", "broker", "").(nfsbroker.AdvisoryLocker)
+		store := nfsbroker.SqlStore{Database: nfsbrokerfakes.FakeSQLMockConnection{db}, Locker: locker}
+		leaderLock = store.LeaderLock(lagertest.NewTestLogger("leader-lock"), fakeClock, "some-lock", time.Second)
+	})
+
+	JustBeforeEach(func() {
+		process = ifrit.Invoke(leaderLock)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	Context("when the lock is free", func() {
+		BeforeEach(func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT GET_LOCK\(\?, 0\)`).WithArgs("broker.some-lock").WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(1))
+			mock.ExpectQuery(`SELECT RELEASE_LOCK\(\?\)`).WithArgs("broker.some-lock").WillReturnRows(sqlmock.NewRows([]string{"released"}).AddRow(1))
+			mock.ExpectRollback()
+		})
+
+		It("becomes the leader and releases the lock when stopped", func() {
+			Eventually(leaderLock.IsLeader).Should(BeTrue())
+		})
+	})
+
+	Context("when another instance holds the lock", func() {
+		BeforeEach(func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT GET_LOCK`).WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(0))
+			mock.ExpectRollback()
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT GET_LOCK`).WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(1))
+			mock.ExpectQuery(`SELECT RELEASE_LOCK`).WillReturnRows(sqlmock.NewRows([]string{"released"}).AddRow(1))
+			mock.ExpectRollback()
+		})
+
+		It("tries again every interval", func() {
+			Consistently(leaderLock.IsLeader).Should(BeFalse())
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(leaderLock.IsLeader).Should(BeTrue())
+		})
+	})
+
+	Context("when the leader's connection is lost", func() {
+		BeforeEach(func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT GET_LOCK`).WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(1))
+			mock.ExpectQuery(`SELECT 1`).WillReturnError(errors.New("connection reset"))
+			mock.ExpectRollback()
+		})
+
+		It("gives up leadership", func() {
+			Eventually(leaderLock.IsLeader).Should(BeTrue())
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(leaderLock.IsLeader).Should(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("SqlStore.LeaderLock", func() {
+	It("returns nil when the database has no advisory locks", func() {
+		store := nfsbroker.SqlStore{}
+		Expect(store.LeaderLock(lagertest.NewTestLogger("leader-lock"), fakeclock.NewFakeClock(time.Now()), "some-lock", time.Second)).To(BeNil())
+	})
+})
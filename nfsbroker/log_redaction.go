@@ -0,0 +1,31 @@
+package nfsbroker
+
+// RedactedLogKeys are patterns of the keys of log data whose values the broker's logs scrub: passwords and other
+// secrets, credentials parsed from service bindings, and the parameters of provision, update and bind requests,
+// which users fill with share credentials.  Keys are matched at any depth, so the parameters inside logged request
+// details are scrubbed too.
+var RedactedLogKeys = []string{
+	`[Pp]wd`,
+	`[Pp]ass`,
+	`[Ss]ecret`,
+	`[Tt]oken`,
+	`[Kk]eytab`,
+	`[Pp]rivate_?[Kk]ey`,
+	`^[Aa]uthorization$`,
+	`^credentials$`,
+	`^parameters$`,
+	`^given_options$`,
+}
+
+// RedactedLogValues are patterns of the values the broker's logs scrub wherever they appear: lager's own patterns for
+// cloud keys, password hashes and private keys, and URLs and MySQL connection strings that carry a password.
+var RedactedLogValues = []string{
+	`AKIA[A-Z0-9]{16}`,
+	`KEY["']?\s*(?::|=>|=)\s*["']?[A-Z0-9/\+=]{40}["']?`,
+	`\$1\$[A-Z0-9./]{1,16}\$[A-Z0-9./]{22}`,
+	`\$5\$[A-Z0-9./]{1,16}\$[A-Z0-9./]{43}`,
+	`\$6\$[A-Z0-9./]{1,16}\$[A-Z0-9./]{86}`,
+	`-----BEGIN(.*)PRIVATE KEY-----`,
+	`[A-Za-z][A-Za-z0-9+.-]*://[^/\s:@]+:[^/\s@]+@`,
+	`[^/\s:@]+:[^/\s@]*@(tcp|unix)\(`,
+}
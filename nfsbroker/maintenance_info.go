@@ -0,0 +1,110 @@
+package nfsbroker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+const CatalogPath = "/v2/catalog"
+
+// MaintenanceInfo identifies the version of the broker's plans, so that platforms can tell which instances were
+// provisioned or last upgraded under an older version.
+type MaintenanceInfo struct {
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// SetMaintenanceInfo configures the maintenance info advertised for every plan.
+func (b *Broker) SetMaintenanceInfo(info MaintenanceInfo) {
+	b.maintenanceInfo = &info
+}
+
+type maintenanceInfoKey struct{}
+
+func withRequestedMaintenanceInfo(ctx context.Context, info MaintenanceInfo) context.Context {
+	return context.WithValue(ctx, maintenanceInfoKey{}, info)
+}
+
+// requestedMaintenanceInfo returns the maintenance info a provision or update request was made with, if any.
+func requestedMaintenanceInfo(ctx context.Context) (MaintenanceInfo, bool) {
+	info, ok := ctx.Value(maintenanceInfoKey{}).(MaintenanceInfo)
+	return info, ok
+}
+
+// NewMaintenanceInfoHandler adds maintenance info to the catalog and checks the maintenance info of provision and
+// update requests, which the broker API library does not know about, before passing requests on to next.
+func NewMaintenanceInfoHandler(broker *Broker, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == CatalogPath:
+			serveCatalogWithMaintenanceInfo(w, r, broker.maintenanceInfo, next)
+		case (r.Method == "PUT" || r.Method == "PATCH") && isInstancePath(r.URL.Path):
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"description": err.Error()})
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			var request struct {
+				MaintenanceInfo *MaintenanceInfo `json:"maintenance_info"`
+			}
+			if json.Unmarshal(body, &request) != nil || request.MaintenanceInfo == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if broker.maintenanceInfo == nil || request.MaintenanceInfo.Version != broker.maintenanceInfo.Version {
+				writeJSON(w, http.StatusUnprocessableEntity, map[string]string{
+					"error":       "MaintenanceInfoConflict",
+					"description": fmt.Sprintf("maintenance_info version %q does not match the plan's", request.MaintenanceInfo.Version),
+				})
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withRequestedMaintenanceInfo(r.Context(), *request.MaintenanceInfo)))
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func isInstancePath(path string) bool {
+	id := strings.TrimPrefix(path, ServiceInstancesPath)
+	return id != path && id != "" && !strings.Contains(id, "/")
+}
+
+func serveCatalogWithMaintenanceInfo(w http.ResponseWriter, r *http.Request, info *MaintenanceInfo, next http.Handler) {
+	if info == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	recorder := httptest.NewRecorder()
+	next.ServeHTTP(recorder, r)
+	var catalog map[string]interface{}
+	if recorder.Code != http.StatusOK || json.Unmarshal(recorder.Body.Bytes(), &catalog) != nil {
+		for key, values := range recorder.Header() {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(recorder.Code)
+		w.Write(recorder.Body.Bytes())
+		return
+	}
+
+	services, _ := catalog["services"].([]interface{})
+	for _, service := range services {
+		service, _ := service.(map[string]interface{})
+		plans, _ := service["plans"].([]interface{})
+		for _, plan := range plans {
+			if plan, ok := plan.(map[string]interface{}); ok {
+				plan["maintenance_info"] = info
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, catalog)
+}
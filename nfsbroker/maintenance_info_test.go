@@ -0,0 +1,131 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("MaintenanceInfoHandler", func() {
+	var (
+		broker    *nfsbroker.Broker
+		fakeStore *nfsbrokerfakes.FakeStore
+		handler   http.Handler
+		recorder  *httptest.ResponseRecorder
+		method    string
+		path      string
+		body      string
+	)
+
+	BeforeEach(func() {
+		logger := lagertest.NewTestLogger("test-maintenance-info")
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound)
+		broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, fakeStore,
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		broker.SetMaintenanceInfo(nfsbroker.MaintenanceInfo{Version: "2.0.0", Description: "mounts default to NFSv4"})
+
+		credentials := brokerapi.BrokerCredentials{Username: "user", Password: "password"}
+		handler = nfsbroker.NewMaintenanceInfoHandler(broker, brokerapi.New(broker, logger, credentials))
+		recorder = httptest.NewRecorder()
+		body = ""
+	})
+
+	JustBeforeEach(func() {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.SetBasicAuth("user", "password")
+		handler.ServeHTTP(recorder, request)
+	})
+
+	Context("when fetching the catalog", func() {
+		BeforeEach(func() {
+			method = "GET"
+			path = nfsbroker.CatalogPath
+		})
+
+		It("advertises the maintenance info for every plan", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(ContainSubstring(`"maintenance_info":{"version":"2.0.0","description":"mounts default to NFSv4"}`))
+		})
+	})
+
+	Context("when provisioning", func() {
+		BeforeEach(func() {
+			method = "PUT"
+			path = "/v2/service_instances/instance-id"
+		})
+
+		Context("with the current maintenance info", func() {
+			BeforeEach(func() {
+				body = `{"service_id":"service-id","plan_id":"Existing","parameters":{"share":"server/export"},"maintenance_info":{"version":"2.0.0"}}`
+			})
+
+			It("records the version with the instance", func() {
+				Expect(recorder.Code).To(Equal(http.StatusCreated))
+				_, _, details := fakeStore.CreateInstanceDetailsArgsForCall(0)
+				Expect(details.MaintenanceVersion).To(Equal("2.0.0"))
+			})
+		})
+
+		Context("with other maintenance info", func() {
+			BeforeEach(func() {
+				body = `{"service_id":"service-id","plan_id":"Existing","parameters":{"share":"server/export"},"maintenance_info":{"version":"1.0.0"}}`
+			})
+
+			It("rejects the request", func() {
+				Expect(recorder.Code).To(Equal(http.StatusUnprocessableEntity))
+				Expect(recorder.Body.String()).To(ContainSubstring(`"error":"MaintenanceInfoConflict"`))
+				Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("without maintenance info", func() {
+			BeforeEach(func() {
+				body = `{"service_id":"service-id","plan_id":"Existing","parameters":{"share":"server/export"}}`
+			})
+
+			It("provisions without recording a version", func() {
+				Expect(recorder.Code).To(Equal(http.StatusCreated))
+				_, _, details := fakeStore.CreateInstanceDetailsArgsForCall(0)
+				Expect(details.MaintenanceVersion).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("when updating to the current maintenance info", func() {
+		BeforeEach(func() {
+			method = "PATCH"
+			path = "/v2/service_instances/instance-id"
+			body = `{"service_id":"service-id","maintenance_info":{"version":"2.0.0"}}`
+			fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{ServiceID: "service-id", Share: "server/export", MaintenanceVersion: "1.0.0"}, nil)
+		})
+
+		It("upgrades the instance", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			_, _, details := fakeStore.UpdateInstanceDetailsArgsForCall(0)
+			Expect(details.MaintenanceVersion).To(Equal("2.0.0"))
+		})
+	})
+})
+
+var _ = Describe("GetInstance maintenance info", func() {
+	It("reports the version the instance was provisioned with", func() {
+		fakeStore := &nfsbrokerfakes.FakeStore{}
+		fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{ServiceID: "service-id", MaintenanceVersion: "1.0.0"}, nil)
+		broker := nfsbroker.New(lagertest.NewTestLogger("test"), "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, fakeStore,
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+
+		spec, err := broker.GetInstance(context.TODO(), "instance-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.MaintenanceInfo).To(Equal(&nfsbroker.MaintenanceInfo{Version: "1.0.0"}))
+	})
+})
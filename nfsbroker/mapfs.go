@@ -0,0 +1,75 @@
+package nfsbroker
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// ExperimentalOption is the bind parameter, and the mount config key, that asks the nfs driver to mount a share with
+// the kernel's NFS client and map file ownership to the binding's uid and gid with mapfs, rather than with fuse-nfs.
+const ExperimentalOption = "experimental"
+
+// legacyMountOptions are the fuse-nfs options that mapfs mounts do not understand.
+var legacyMountOptions = []string{
+	"allow_root", "allow_other", "default_permissions", "multithread", "fusenfs_uid", "fusenfs_gid", "nfs_uid",
+	"nfs_gid", "auto_cache",
+}
+
+// SetAllowExperimental lets bindings ask for mapfs mounts with the experimental bind parameter.  Bindings that do not
+// ask are mounted with fuse-nfs as before, so one broker can serve both.
+func (b *Broker) SetAllowExperimental(allow bool) {
+	b.allowExperimental = allow
+}
+
+// experimentalMount reports whether bind parameters ask for a mapfs mount.
+func experimentalMount(parameters map[string]interface{}) bool {
+	experimental, _ := parameters[ExperimentalOption].(bool)
+	return experimental
+}
+
+// checkExperimental refuses the experimental bind parameter unless the broker allows mapfs mounts and the share type
+// has them.
+func (b *Broker) checkExperimental(shareType ShareType, parameters map[string]interface{}) error {
+	if _, ok := parameters[ExperimentalOption]; !ok {
+		return nil
+	}
+	var err error
+	switch {
+	case !inArray(shareType.MountOptions, ExperimentalOption):
+		err = fmt.Errorf("%s shares have no experimental mounts", shareType.Name)
+	case !b.allowExperimental:
+		err = fmt.Errorf("experimental mounts are not enabled on this broker")
+	default:
+		return nil
+	}
+	return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "experimental-not-allowed")
+}
+
+// checkMapfsMountConfig checks that the mount config of a mapfs mount has a uid and gid to map files to, and no
+// fuse-nfs options, which the driver would otherwise ignore.
+func checkMapfsMountConfig(mountConfig map[string]interface{}) error {
+	problems := []string{}
+	for _, name := range []string{"uid", "gid"} {
+		if value, ok := mountConfig[name]; !ok || value == "" {
+			problems = append(problems, fmt.Sprintf("%s is required", name))
+		}
+	}
+	legacy := []string{}
+	for name := range mountConfig {
+		if inArray(legacyMountOptions, name) {
+			legacy = append(legacy, name)
+		}
+	}
+	sort.Strings(legacy)
+	if len(legacy) > 0 {
+		problems = append(problems, "options not supported: "+strings.Join(legacy, ", "))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid experimental mount: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Experimental mounts", func() {
+	var (
+		broker *nfsbroker.Broker
+		store  nfsbroker.Store
+		ctx    context.Context
+	)
+
+	bind := func(parameters map[string]interface{}) (brokerapi.Binding, error) {
+		return broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid", Parameters: parameters})
+	}
+
+	BeforeEach(func() {
+		configDetails := nfsbroker.NewNfsBrokerConfigDetails()
+		Expect(configDetails.ReadConf("uid,gid,allow_root,auto_cache", "")).To(Succeed())
+		store = nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-mapfs"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, store, nfsbroker.NewNfsBrokerConfig(configDetails))
+		broker.SetAllowExperimental(true)
+		ctx = context.Background()
+
+		_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+			ServiceID:     "service-id",
+			PlanID:        "Existing",
+			RawParameters: json.RawMessage(`{"share":"server:/export"}`),
+		}, false)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("asks the driver for a mapfs mount with the binding's uid and gid", func() {
+		binding, err := bind(map[string]interface{}{"experimental": true, "uid": "1000", "gid": "1000"})
+		Expect(err).NotTo(HaveOccurred())
+
+		mountConfig := binding.VolumeMounts[0].Device.MountConfig
+		Expect(mountConfig).To(HaveKeyWithValue("experimental", true))
+		Expect(mountConfig).To(HaveKeyWithValue("uid", "1000"))
+		Expect(mountConfig).To(HaveKeyWithValue("gid", "1000"))
+	})
+
+	It("keeps the mount experimental when the binding is fetched", func() {
+		_, err := bind(map[string]interface{}{"experimental": true, "uid": "1000", "gid": "1000"})
+		Expect(err).NotTo(HaveOccurred())
+
+		spec, err := broker.GetBinding(ctx, "instance-id", "binding-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.VolumeMounts[0].Device.MountConfig).To(HaveKeyWithValue("experimental", true))
+	})
+
+	It("mounts bindings that do not ask for mapfs with fuse-nfs", func() {
+		binding, err := bind(map[string]interface{}{"uid": "1000", "gid": "1000", "allow_root": true})
+		Expect(err).NotTo(HaveOccurred())
+
+		mountConfig := binding.VolumeMounts[0].Device.MountConfig
+		Expect(mountConfig).NotTo(HaveKey("experimental"))
+		Expect(mountConfig).To(HaveKey("allow_root"))
+	})
+
+	It("requires a uid and gid", func() {
+		_, err := bind(map[string]interface{}{"experimental": true, "uid": "1000"})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("gid is required"))
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(400))
+	})
+
+	It("refuses fuse-nfs options", func() {
+		_, err := bind(map[string]interface{}{"experimental": true, "uid": "1000", "gid": "1000", "auto_cache": true, "allow_root": true})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("options not supported: allow_root, auto_cache"))
+	})
+
+	It("refuses experimental values that are not booleans", func() {
+		_, err := bind(map[string]interface{}{"experimental": "yes", "uid": "1000", "gid": "1000"})
+		Expect(err).To(Equal(brokerapi.ErrRawParamsInvalid))
+	})
+
+	Context("when the broker does not allow experimental mounts", func() {
+		BeforeEach(func() {
+			broker.SetAllowExperimental(false)
+		})
+
+		It("refuses bindings that ask for them", func() {
+			_, err := bind(map[string]interface{}{"experimental": true, "uid": "1000", "gid": "1000"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("experimental mounts are not enabled"))
+			Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(400))
+		})
+	})
+
+	Context("when the share type has no experimental mounts", func() {
+		BeforeEach(func() {
+			broker.SetShareType(nfsbroker.CephFSShareType)
+		})
+
+		It("refuses bindings that ask for them", func() {
+			_, err := bind(map[string]interface{}{"experimental": true})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("cephfs shares have no experimental mounts"))
+		})
+	})
+})
@@ -0,0 +1,195 @@
+package nfsbroker
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics receives the broker's operational metrics.
+type Metrics interface {
+	// CountOperation counts a provision, update, bind, unbind or deprovision that has finished with outcome,
+	// AuditResultSucceeded or AuditResultFailed.  Asynchronous operations are counted once they finish.
+	CountOperation(operation, outcome string)
+	// ObserveRequest records how long the broker took to answer a request to an endpoint of the service broker API.
+	ObserveRequest(endpoint string, status int, duration time.Duration)
+	// ObserveStoreOperation records how long a call to a Store method took on a backend, as named by StoreBackend, and
+	// the error it failed with, if any.
+	ObserveStoreOperation(backend, operation string, duration time.Duration, err error)
+	// ObserveDBStats records the connection pool statistics of a SQL store, named for the role it plays, such as
+	// "primary" or "standby".
+	ObserveDBStats(store string, stats sql.DBStats)
+}
+
+// SetMetrics counts the broker's operations in metrics.
+func (b *Broker) SetMetrics(metrics Metrics) {
+	b.metrics = metrics
+}
+
+func (b *Broker) countOperation(operation, outcome string) {
+	if b.metrics != nil {
+		b.metrics.CountOperation(operation, outcome)
+	}
+}
+
+// MultiMetrics records metrics in each of several Metrics.
+type MultiMetrics []Metrics
+
+func (m MultiMetrics) CountOperation(operation, outcome string) {
+	for _, metrics := range m {
+		metrics.CountOperation(operation, outcome)
+	}
+}
+
+func (m MultiMetrics) ObserveRequest(endpoint string, status int, duration time.Duration) {
+	for _, metrics := range m {
+		metrics.ObserveRequest(endpoint, status, duration)
+	}
+}
+
+func (m MultiMetrics) ObserveStoreOperation(backend, operation string, duration time.Duration, err error) {
+	for _, metrics := range m {
+		metrics.ObserveStoreOperation(backend, operation, duration, err)
+	}
+}
+
+func (m MultiMetrics) ObserveDBStats(store string, stats sql.DBStats) {
+	for _, metrics := range m {
+		metrics.ObserveDBStats(store, stats)
+	}
+}
+
+// PrometheusMetrics registers the broker's metrics with a Prometheus registry, to be scraped from its handler.
+type PrometheusMetrics struct {
+	operations      *prometheus.CounterVec
+	requests        *prometheus.HistogramVec
+	storeOperations *prometheus.HistogramVec
+	storeErrors     *prometheus.CounterVec
+	dbConnections   *prometheus.GaugeVec
+	dbWaits         *prometheus.GaugeVec
+	dbWaitDuration  *prometheus.GaugeVec
+}
+
+func NewPrometheusMetrics(registerer prometheus.Registerer) *PrometheusMetrics {
+	metrics := &PrometheusMetrics{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nfsbroker",
+			Name:      "operations_total",
+			Help:      "Provisions, updates, binds, unbinds and deprovisions that have finished, by outcome.",
+		}, []string{"operation", "outcome"}),
+		requests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "nfsbroker",
+			Name:      "http_request_duration_seconds",
+			Help:      "How long the broker took to answer requests, by service broker API endpoint and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"endpoint", "status"}),
+		storeOperations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "nfsbroker",
+			Name:      "store_operation_duration_seconds",
+			Help:      "How long calls to the broker's store took, by backend and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"backend", "operation"}),
+		storeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nfsbroker",
+			Name:      "store_operation_errors_total",
+			Help:      "Calls to the broker's store that failed, by backend and method.",
+		}, []string{"backend", "operation"}),
+		dbConnections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nfsbroker",
+			Name:      "db_connections",
+			Help:      "Connections in the pools of SQL stores, by store and state: max_open, open, in_use or idle.",
+		}, []string{"store", "state"}),
+		dbWaits: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nfsbroker",
+			Name:      "db_wait_count",
+			Help:      "Times callers have waited for a connection from the pools of SQL stores since they were opened.",
+		}, []string{"store"}),
+		dbWaitDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nfsbroker",
+			Name:      "db_wait_duration_seconds",
+			Help:      "Time callers have spent waiting for a connection from the pools of SQL stores since they were opened.",
+		}, []string{"store"}),
+	}
+	registerer.MustRegister(metrics.operations, metrics.requests, metrics.storeOperations, metrics.storeErrors,
+		metrics.dbConnections, metrics.dbWaits, metrics.dbWaitDuration)
+	return metrics
+}
+
+func (m *PrometheusMetrics) CountOperation(operation, outcome string) {
+	m.operations.WithLabelValues(operation, outcome).Inc()
+}
+
+func (m *PrometheusMetrics) ObserveRequest(endpoint string, status int, duration time.Duration) {
+	m.requests.WithLabelValues(endpoint, strconv.Itoa(status)).Observe(duration.Seconds())
+}
+
+func (m *PrometheusMetrics) ObserveStoreOperation(backend, operation string, duration time.Duration, err error) {
+	m.storeOperations.WithLabelValues(backend, operation).Observe(duration.Seconds())
+	if err != nil {
+		m.storeErrors.WithLabelValues(backend, operation).Inc()
+	}
+}
+
+func (m *PrometheusMetrics) ObserveDBStats(store string, stats sql.DBStats) {
+	m.dbConnections.WithLabelValues(store, "max_open").Set(float64(stats.MaxOpenConnections))
+	m.dbConnections.WithLabelValues(store, "open").Set(float64(stats.OpenConnections))
+	m.dbConnections.WithLabelValues(store, "in_use").Set(float64(stats.InUse))
+	m.dbConnections.WithLabelValues(store, "idle").Set(float64(stats.Idle))
+	m.dbWaits.WithLabelValues(store).Set(float64(stats.WaitCount))
+	m.dbWaitDuration.WithLabelValues(store).Set(stats.WaitDuration.Seconds())
+}
+
+// MetricsHandler records how long next takes to answer each request in metrics, by the service broker API endpoint
+// requested.
+func MetricsHandler(metrics Metrics, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		metrics.ObserveRequest(BrokerAPIEndpoint(r.Method, r.URL.Path), recorder.status, time.Since(started))
+	})
+}
+
+// BrokerAPIEndpoint names the service broker API endpoint a request is for, such as "provision" or "bind".  Other
+// requests are all named "other", so that instance and binding IDs never become metric labels.
+func BrokerAPIEndpoint(method, path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) < 2 || segments[0] != "v2" {
+		return "other"
+	}
+	switch {
+	case len(segments) == 2 && segments[1] == "catalog":
+		return "catalog"
+	case segments[1] != "service_instances":
+		return "other"
+	case len(segments) == 3:
+		switch method {
+		case http.MethodPut:
+			return "provision"
+		case http.MethodPatch:
+			return "update"
+		case http.MethodDelete:
+			return "deprovision"
+		case http.MethodGet:
+			return "get_instance"
+		}
+	case len(segments) == 4 && segments[3] == "last_operation":
+		return "last_operation"
+	case len(segments) == 5 && segments[3] == "service_bindings":
+		switch method {
+		case http.MethodPut:
+			return "bind"
+		case http.MethodDelete:
+			return "unbind"
+		case http.MethodGet:
+			return "get_binding"
+		}
+	case len(segments) == 6 && segments[3] == "service_bindings" && segments[5] == "last_operation":
+		return "binding_last_operation"
+	}
+	return "other"
+}
@@ -0,0 +1,66 @@
+package nfsbroker
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"time"
+)
+
+// StatsdMetrics sends the broker's metrics to a StatsD server, such as a Datadog agent, over UDP.  Labels become
+// parts of the metric names: operations are counted as <prefix>.operations.<operation>.<outcome>, and requests and
+// store calls timed as <prefix>.requests.<endpoint>.<status> and <prefix>.store.<backend>.<operation>, with failed
+// store calls also counted as <prefix>.store.<backend>.<operation>.errors.  The pools of SQL stores are gauged as
+// <prefix>.db.<store>.<statistic>.  Metrics that cannot be sent are dropped.
+type StatsdMetrics struct {
+	conn   net.Conn
+	prefix string
+}
+
+// NewStatsdMetrics sends metrics to the StatsD server at address, a host:port, with names starting with prefix.
+func NewStatsdMetrics(address, prefix string) (*StatsdMetrics, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" {
+		prefix += "."
+	}
+	return &StatsdMetrics{conn: conn, prefix: prefix}, nil
+}
+
+func (m *StatsdMetrics) CountOperation(operation, outcome string) {
+	m.send(fmt.Sprintf("operations.%s.%s:1|c", operation, outcome))
+}
+
+func (m *StatsdMetrics) ObserveRequest(endpoint string, status int, duration time.Duration) {
+	m.send(fmt.Sprintf("requests.%s.%d:%s|ms", endpoint, status, statsdMilliseconds(duration)))
+}
+
+func (m *StatsdMetrics) ObserveStoreOperation(backend, operation string, duration time.Duration, err error) {
+	m.send(fmt.Sprintf("store.%s.%s:%s|ms", backend, operation, statsdMilliseconds(duration)))
+	if err != nil {
+		m.send(fmt.Sprintf("store.%s.%s.errors:1|c", backend, operation))
+	}
+}
+
+func (m *StatsdMetrics) ObserveDBStats(store string, stats sql.DBStats) {
+	m.send(fmt.Sprintf("db.%s.max_open:%d|g", store, stats.MaxOpenConnections))
+	m.send(fmt.Sprintf("db.%s.open:%d|g", store, stats.OpenConnections))
+	m.send(fmt.Sprintf("db.%s.in_use:%d|g", store, stats.InUse))
+	m.send(fmt.Sprintf("db.%s.idle:%d|g", store, stats.Idle))
+	m.send(fmt.Sprintf("db.%s.wait_count:%d|g", store, stats.WaitCount))
+	m.send(fmt.Sprintf("db.%s.wait_duration:%s|g", store, statsdMilliseconds(stats.WaitDuration)))
+}
+
+func (m *StatsdMetrics) Close() error {
+	return m.conn.Close()
+}
+
+func (m *StatsdMetrics) send(metric string) {
+	m.conn.Write([]byte(m.prefix + metric))
+}
+
+func statsdMilliseconds(duration time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(duration)/float64(time.Millisecond))
+}
@@ -0,0 +1,82 @@
+package nfsbroker_test
+
+import (
+	"database/sql"
+	"errors"
+	"net"
+	"time"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("StatsdMetrics", func() {
+	var (
+		server  net.PacketConn
+		metrics *nfsbroker.StatsdMetrics
+	)
+
+	received := func() string {
+		buffer := make([]byte, 512)
+		Expect(server.SetReadDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+		n, _, err := server.ReadFrom(buffer)
+		Expect(err).NotTo(HaveOccurred())
+		return string(buffer[:n])
+	}
+
+	BeforeEach(func() {
+		var err error
+		server, err = net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		metrics, err = nfsbroker.NewStatsdMetrics(server.LocalAddr().String(), "nfsbroker")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		metrics.Close()
+		server.Close()
+	})
+
+	It("counts operations by outcome", func() {
+		metrics.CountOperation("provision", nfsbroker.AuditResultFailed)
+		Expect(received()).To(Equal("nfsbroker.operations.provision.failed:1|c"))
+	})
+
+	It("times requests and store calls in milliseconds", func() {
+		metrics.ObserveRequest("bind", 201, 1500*time.Microsecond)
+		Expect(received()).To(Equal("nfsbroker.requests.bind.201:1.500|ms"))
+
+		metrics.ObserveStoreOperation("postgres", "CreateBindingDetails", 12*time.Millisecond, nil)
+		Expect(received()).To(Equal("nfsbroker.store.postgres.CreateBindingDetails:12.000|ms"))
+	})
+
+	It("counts store calls that fail", func() {
+		metrics.ObserveStoreOperation("mysql", "SaveOperation", time.Millisecond, errors.New("connection refused"))
+		Expect(received()).To(Equal("nfsbroker.store.mysql.SaveOperation:1.000|ms"))
+		Expect(received()).To(Equal("nfsbroker.store.mysql.SaveOperation.errors:1|c"))
+	})
+
+	It("gauges the connection pools of SQL stores", func() {
+		metrics.ObserveDBStats("standby", sql.DBStats{MaxOpenConnections: 10, OpenConnections: 4, InUse: 3, Idle: 1, WaitCount: 2, WaitDuration: time.Second})
+		Expect(received()).To(Equal("nfsbroker.db.standby.max_open:10|g"))
+		Expect(received()).To(Equal("nfsbroker.db.standby.open:4|g"))
+		Expect(received()).To(Equal("nfsbroker.db.standby.in_use:3|g"))
+		Expect(received()).To(Equal("nfsbroker.db.standby.idle:1|g"))
+		Expect(received()).To(Equal("nfsbroker.db.standby.wait_count:2|g"))
+		Expect(received()).To(Equal("nfsbroker.db.standby.wait_duration:1000.000|g"))
+	})
+
+	It("records metrics alongside Prometheus", func() {
+		registry := prometheus.NewRegistry()
+		both := nfsbroker.MultiMetrics{nfsbroker.NewPrometheusMetrics(registry), metrics}
+		both.CountOperation("bind", nfsbroker.AuditResultSucceeded)
+
+		Expect(received()).To(Equal("nfsbroker.operations.bind.succeeded:1|c"))
+		families, err := registry.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(families).To(HaveLen(1))
+		Expect(families[0].GetMetric()[0].GetCounter().GetValue()).To(Equal(1.0))
+	})
+})
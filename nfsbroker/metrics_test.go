@@ -0,0 +1,170 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Metrics", func() {
+	var (
+		registry *prometheus.Registry
+		metrics  *nfsbroker.PrometheusMetrics
+	)
+
+	BeforeEach(func() {
+		registry = prometheus.NewRegistry()
+		metrics = nfsbroker.NewPrometheusMetrics(registry)
+	})
+
+	sample := func(name string, labels prometheus.Labels) *dto.Metric {
+		families, err := registry.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() != name {
+				continue
+			}
+		metrics:
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if labels[label.GetName()] != label.GetValue() {
+						continue metrics
+					}
+				}
+				return metric
+			}
+		}
+		return &dto.Metric{}
+	}
+
+	histogramCount := func(name string, labels prometheus.Labels) uint64 {
+		return sample(name, labels).GetHistogram().GetSampleCount()
+	}
+
+	Describe("counting operations", func() {
+		var (
+			broker *nfsbroker.Broker
+			ctx    context.Context
+		)
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			broker = nfsbroker.New(lagertest.NewTestLogger("test-metrics"), "service-name", "service-id", "/fake-dir",
+				&os_fake.FakeOs{}, nil, nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize), nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+			broker.SetMetrics(metrics)
+		})
+
+		It("counts provisions, binds and deletes by outcome", func() {
+			_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+				ServiceID:     "service-id",
+				PlanID:        "Existing",
+				RawParameters: json.RawMessage(`{"share":"server:/export"}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = broker.Bind(ctx, "missing-instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})
+			Expect(err).To(HaveOccurred())
+			Expect(broker.Unbind(ctx, "instance-id", "binding-id", brokerapi.UnbindDetails{})).To(Succeed())
+			_, err = broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			operations := func(operation, outcome string) float64 {
+				return sample("nfsbroker_operations_total", prometheus.Labels{"operation": operation, "outcome": outcome}).GetCounter().GetValue()
+			}
+			Expect(operations("provision", nfsbroker.AuditResultSucceeded)).To(Equal(1.0))
+			Expect(operations("bind", nfsbroker.AuditResultSucceeded)).To(Equal(1.0))
+			Expect(operations("bind", nfsbroker.AuditResultFailed)).To(Equal(1.0))
+			Expect(operations("unbind", nfsbroker.AuditResultSucceeded)).To(Equal(1.0))
+			Expect(operations("deprovision", nfsbroker.AuditResultSucceeded)).To(Equal(1.0))
+		})
+	})
+
+	Describe("MetricsHandler", func() {
+		It("records request latency by endpoint and status", func() {
+			handler := nfsbroker.MetricsHandler(metrics, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodDelete {
+					w.WriteHeader(http.StatusGone)
+				}
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/v2/service_instances/instance-id", nil))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/v2/service_instances/instance-id", nil))
+
+			Expect(histogramCount("nfsbroker_http_request_duration_seconds", prometheus.Labels{"endpoint": "provision", "status": "200"})).To(Equal(uint64(1)))
+			Expect(histogramCount("nfsbroker_http_request_duration_seconds", prometheus.Labels{"endpoint": "deprovision", "status": "410"})).To(Equal(uint64(1)))
+		})
+	})
+
+	Describe("BrokerAPIEndpoint", func() {
+		It("names the endpoints of the service broker API", func() {
+			Expect(nfsbroker.BrokerAPIEndpoint("GET", "/v2/catalog")).To(Equal("catalog"))
+			Expect(nfsbroker.BrokerAPIEndpoint("PUT", "/v2/service_instances/instance-id")).To(Equal("provision"))
+			Expect(nfsbroker.BrokerAPIEndpoint("PATCH", "/v2/service_instances/instance-id")).To(Equal("update"))
+			Expect(nfsbroker.BrokerAPIEndpoint("DELETE", "/v2/service_instances/instance-id")).To(Equal("deprovision"))
+			Expect(nfsbroker.BrokerAPIEndpoint("GET", "/v2/service_instances/instance-id")).To(Equal("get_instance"))
+			Expect(nfsbroker.BrokerAPIEndpoint("GET", "/v2/service_instances/instance-id/last_operation")).To(Equal("last_operation"))
+			Expect(nfsbroker.BrokerAPIEndpoint("PUT", "/v2/service_instances/instance-id/service_bindings/binding-id")).To(Equal("bind"))
+			Expect(nfsbroker.BrokerAPIEndpoint("DELETE", "/v2/service_instances/instance-id/service_bindings/binding-id")).To(Equal("unbind"))
+			Expect(nfsbroker.BrokerAPIEndpoint("GET", "/v2/service_instances/instance-id/service_bindings/binding-id")).To(Equal("get_binding"))
+			Expect(nfsbroker.BrokerAPIEndpoint("GET", "/v2/service_instances/instance-id/service_bindings/binding-id/last_operation")).To(Equal("binding_last_operation"))
+		})
+
+		It("names everything else other", func() {
+			Expect(nfsbroker.BrokerAPIEndpoint("GET", "/admin/instances")).To(Equal("other"))
+			Expect(nfsbroker.BrokerAPIEndpoint("POST", "/v2/service_instances/instance-id")).To(Equal("other"))
+			Expect(nfsbroker.BrokerAPIEndpoint("GET", "/v2/service_instances/instance-id/unknown")).To(Equal("other"))
+		})
+	})
+
+	Describe("MetricsStore", func() {
+		It("records how long each store call takes, by backend", func() {
+			store := nfsbroker.NewMetricsStore(nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize), metrics)
+			_, err := store.RetrieveInstanceDetails(context.Background(), "missing-instance-id")
+			Expect(err).To(HaveOccurred())
+			Expect(store.CreateInstanceDetails(context.Background(), "instance-id", nfsbroker.ServiceInstance{})).To(Succeed())
+
+			Expect(histogramCount("nfsbroker_store_operation_duration_seconds", prometheus.Labels{"backend": "memory", "operation": "RetrieveInstanceDetails"})).To(Equal(uint64(1)))
+			Expect(histogramCount("nfsbroker_store_operation_duration_seconds", prometheus.Labels{"backend": "memory", "operation": "CreateInstanceDetails"})).To(Equal(uint64(1)))
+			Expect(sample("nfsbroker_store_operation_errors_total", prometheus.Labels{"backend": "memory", "operation": "RetrieveInstanceDetails"}).GetCounter().GetValue()).To(BeZero())
+		})
+
+		It("counts store calls that fail", func() {
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			store := nfsbroker.NewMetricsStore(&nfsbroker.SqlStore{Database: nfsbrokerfakes.FakeSQLMockConnection{db}, StoreType: "postgres"}, metrics)
+
+			mock.ExpectQuery("SELECT COUNT").WillReturnError(errors.New("connection refused"))
+			_, err = store.CountInstances(context.Background())
+			Expect(err).To(HaveOccurred())
+
+			labels := prometheus.Labels{"backend": "postgres", "operation": "CountInstances"}
+			Expect(histogramCount("nfsbroker_store_operation_duration_seconds", labels)).To(Equal(uint64(1)))
+			Expect(sample("nfsbroker_store_operation_errors_total", labels).GetCounter().GetValue()).To(Equal(float64(1)))
+		})
+
+		It("names the backend of a switchable store after its active store", func() {
+			switchable := nfsbroker.NewSwitchableStore(nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize), &nfsbroker.SqlStore{StoreType: "mysql"})
+			Expect(nfsbroker.StoreBackend(nfsbroker.NewMetricsStore(switchable, metrics))).To(Equal("memory"))
+		})
+
+		It("lets the broker promote the standby store it wraps", func() {
+			store := nfsbroker.NewMetricsStore(nfsbroker.NewSwitchableStore(nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize), nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)), metrics)
+			broker := nfsbroker.New(lagertest.NewTestLogger("test-metrics"), "service-name", "service-id", "/fake-dir",
+				&os_fake.FakeOs{}, nil, store, nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+			Expect(broker.PromoteStandbyStore(context.Background())).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,275 @@
+package nfsbroker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// MigrationCount counts the records of one kind that a migration copied, found already copied, and found stored
+// differently in the target store, which it leaves alone.
+type MigrationCount struct {
+	Copied    int      `json:"copied"`
+	Unchanged int      `json:"unchanged"`
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+func (c *MigrationCount) record(id string, copied, conflict bool) {
+	switch {
+	case conflict:
+		c.Conflicts = append(c.Conflicts, id)
+	case copied:
+		c.Copied++
+	default:
+		c.Unchanged++
+	}
+}
+
+// MigrationReport summarizes a migration by the kind of record.
+type MigrationReport struct {
+	Instances         MigrationCount `json:"instances"`
+	Bindings          MigrationCount `json:"bindings"`
+	Operations        MigrationCount `json:"operations"`
+	RetiredPlans      MigrationCount `json:"retired_plans"`
+	ShareReservations MigrationCount `json:"share_reservations"`
+
+	// Problems are what verifying the migration found wrong besides conflicts: row counts that differ between the
+	// stores, records whose JSON cannot be read, and records that read back differently from the target store.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Conflicts counts the records that differ between the stores.
+func (r MigrationReport) Conflicts() int {
+	return len(r.Instances.Conflicts) + len(r.Bindings.Conflicts) + len(r.Operations.Conflicts) +
+		len(r.RetiredPlans.Conflicts) + len(r.ShareReservations.Conflicts)
+}
+
+// MigrateStore copies the service instances and bindings of one store, along with their operations, retired plans
+// and share reservations, into another, such as from a file store into a SQL store or from MySQL into Postgres.
+// Records are copied as they are stored, so binding parameters stay hashed or encrypted with the keys they were
+// stored with.  A migration can be run again: records already in the target store are left as they are, and those
+// stored differently there are reported as conflicts rather than overwritten.  Once the records are copied the
+// migration is verified, and any problems are reported.  Neither store should be in use by a broker during a
+// migration.
+func MigrateStore(ctx context.Context, logger lager.Logger, from, to Store) (MigrationReport, error) {
+	logger = logger.Session("migrate-store")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	var report MigrationReport
+	instances, err := from.ListInstanceDetails(ctx, ListOptions{})
+	if err != nil {
+		return report, err
+	}
+	instanceIDs := make([]string, 0, len(instances))
+	for id := range instances {
+		instanceIDs = append(instanceIDs, id)
+	}
+	sort.Strings(instanceIDs)
+	for _, id := range instanceIDs {
+		existing, err := to.RetrieveInstanceDetails(ctx, id)
+		switch {
+		case errors.Is(err, ErrInstanceNotFound):
+			if err := to.CreateInstanceDetails(ctx, id, instances[id]); err != nil {
+				return report, err
+			}
+			report.Instances.record(id, true, false)
+		case err != nil:
+			return report, err
+		default:
+			report.Instances.record(id, false, !reflect.DeepEqual(existing, instances[id]))
+		}
+	}
+
+	bindings, err := from.ListBindingDetails(ctx, ListOptions{})
+	if err != nil {
+		return report, err
+	}
+	bindingInstances, err := from.ListBindingInstances(ctx)
+	if err != nil {
+		return report, err
+	}
+	bindingIDs := make([]string, 0, len(bindings))
+	for id := range bindings {
+		bindingIDs = append(bindingIDs, id)
+	}
+	sort.Strings(bindingIDs)
+	for _, id := range bindingIDs {
+		existing, err := to.RetrieveBindingDetails(ctx, id)
+		switch {
+		case errors.Is(err, ErrBindingNotFound):
+			if err := to.CreateBindingDetails(ctx, bindingInstances[id], id, bindings[id]); err != nil {
+				return report, err
+			}
+			report.Bindings.record(id, true, false)
+		case err != nil:
+			return report, err
+		default:
+			report.Bindings.record(id, false, !reflect.DeepEqual(existing, bindings[id]))
+		}
+	}
+
+	// operations are kept by instance ID, and by binding ID for unbinds
+	for _, id := range append(instanceIDs, bindingIDs...) {
+		operation, err := from.RetrieveOperation(ctx, id)
+		if err != nil {
+			return report, err
+		}
+		if operation == (Operation{}) {
+			continue
+		}
+		existing, err := to.RetrieveOperation(ctx, id)
+		if err != nil {
+			return report, err
+		}
+		if existing == (Operation{}) {
+			if err := to.SaveOperation(ctx, id, operation); err != nil {
+				return report, err
+			}
+		}
+		report.Operations.record(id, existing == (Operation{}), existing != (Operation{}) && existing != operation)
+	}
+
+	retiredPlans, err := from.ListRetiredPlans(ctx)
+	if err != nil {
+		return report, err
+	}
+	existingRetiredPlans, err := to.ListRetiredPlans(ctx)
+	if err != nil {
+		return report, err
+	}
+	for _, planID := range sortedKeys(retiredPlans) {
+		hint, ok := existingRetiredPlans[planID]
+		if !ok {
+			if err := to.RetirePlan(ctx, planID, retiredPlans[planID]); err != nil {
+				return report, err
+			}
+		}
+		report.RetiredPlans.record(planID, !ok, ok && hint != retiredPlans[planID])
+	}
+
+	reservations, err := from.ListShareReservations(ctx)
+	if err != nil {
+		return report, err
+	}
+	existingReservations, err := to.ListShareReservations(ctx)
+	if err != nil {
+		return report, err
+	}
+	for _, prefix := range sortedKeys(reservations) {
+		orgGUID, ok := existingReservations[prefix]
+		if !ok {
+			if err := to.ReserveShares(ctx, prefix, reservations[prefix]); err != nil {
+				return report, err
+			}
+		}
+		report.ShareReservations.record(prefix, !ok, ok && orgGUID != reservations[prefix])
+	}
+
+	if err := to.Save(logger); err != nil {
+		return report, err
+	}
+	if report.Problems, err = verifyMigration(ctx, from, to, report); err != nil {
+		return report, err
+	}
+	logger.Info("migrated", lager.Data{"report": report})
+	return report, nil
+}
+
+// verifyMigration checks that both stores hold as many instances and bindings as they have rows, so that none were
+// skipped for being corrupt, that they hold as many as each other, and that every record of the source store reads
+// back the same from the target store, besides those reported as conflicts.
+func verifyMigration(ctx context.Context, from, to Store, report MigrationReport) ([]string, error) {
+	var problems []string
+	compare := func(kind string, fromCount, toCount int, fromRecords, toRecords map[string]interface{}, conflicts []string) {
+		if len(fromRecords) != fromCount {
+			problems = append(problems, fmt.Sprintf("%d of the source store's %d %s could not be read, and were not copied", fromCount-len(fromRecords), fromCount, kind))
+		}
+		if len(toRecords) != toCount {
+			problems = append(problems, fmt.Sprintf("%d of the target store's %d %s could not be read", toCount-len(toRecords), toCount, kind))
+		}
+		if fromCount != toCount {
+			problems = append(problems, fmt.Sprintf("the target store has %d %s, but the source store has %d", toCount, kind, fromCount))
+		}
+
+		conflicting := map[string]bool{}
+		for _, id := range conflicts {
+			conflicting[id] = true
+		}
+		ids := make([]string, 0, len(fromRecords))
+		for id := range fromRecords {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			record, ok := toRecords[id]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s %s is missing from the target store", kind, id))
+			} else if !conflicting[id] && !reflect.DeepEqual(record, fromRecords[id]) {
+				problems = append(problems, fmt.Sprintf("%s %s reads back differently from the target store", kind, id))
+			}
+		}
+	}
+
+	fromCount, err := from.CountInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	toCount, err := to.CountInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fromInstances, err := from.ListInstanceDetails(ctx, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	toInstances, err := to.ListInstanceDetails(ctx, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	fromRecords, toRecords := map[string]interface{}{}, map[string]interface{}{}
+	for id, instance := range fromInstances {
+		fromRecords[id] = instance
+	}
+	for id, instance := range toInstances {
+		toRecords[id] = instance
+	}
+	compare("service instances", fromCount, toCount, fromRecords, toRecords, report.Instances.Conflicts)
+
+	if fromCount, err = from.CountBindings(ctx); err != nil {
+		return nil, err
+	}
+	if toCount, err = to.CountBindings(ctx); err != nil {
+		return nil, err
+	}
+	fromBindings, err := from.ListBindingDetails(ctx, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	toBindings, err := to.ListBindingDetails(ctx, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	fromRecords, toRecords = map[string]interface{}{}, map[string]interface{}{}
+	for id, binding := range fromBindings {
+		fromRecords[id] = binding
+	}
+	for id, binding := range toBindings {
+		toRecords[id] = binding
+	}
+	compare("service bindings", fromCount, toCount, fromRecords, toRecords, report.Bindings.Conflicts)
+	return problems, nil
+}
+
+func sortedKeys(records map[string]string) []string {
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,190 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("MigrateStore", func() {
+	var (
+		ctx      context.Context
+		logger   *lagertest.TestLogger
+		from, to nfsbroker.Store
+		instance nfsbroker.ServiceInstance
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger = lagertest.NewTestLogger("test-migration")
+		from = nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)
+		to = nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)
+
+		instance = nfsbroker.ServiceInstance{ServiceID: "service-id", PlanID: "Existing", Share: "server:/export", Labels: map[string]string{"team": "storage"}}
+		Expect(from.CreateInstanceDetails(ctx, "instance-id", instance)).To(Succeed())
+		Expect(from.CreateBindingDetails(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid", Parameters: map[string]interface{}{"uid": "1000"}})).To(Succeed())
+		Expect(from.SaveOperation(ctx, "instance-id", nfsbroker.Operation{Type: nfsbroker.ProvisionOperation, State: brokerapi.Succeeded})).To(Succeed())
+		Expect(from.RetirePlan(ctx, "old-plan-id", "use new-plan")).To(Succeed())
+		Expect(from.ReserveShares(ctx, "server:/finance", "org-guid")).To(Succeed())
+	})
+
+	It("copies every record, as it is stored", func() {
+		report, err := nfsbroker.MigrateStore(ctx, logger, from, to)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Instances).To(Equal(nfsbroker.MigrationCount{Copied: 1}))
+		Expect(report.Bindings).To(Equal(nfsbroker.MigrationCount{Copied: 1}))
+		Expect(report.Operations).To(Equal(nfsbroker.MigrationCount{Copied: 1}))
+		Expect(report.RetiredPlans).To(Equal(nfsbroker.MigrationCount{Copied: 1}))
+		Expect(report.ShareReservations).To(Equal(nfsbroker.MigrationCount{Copied: 1}))
+		Expect(report.Problems).To(BeEmpty())
+
+		stored, err := from.RetrieveInstanceDetails(ctx, "instance-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(to.RetrieveInstanceDetails(ctx, "instance-id")).To(Equal(stored))
+		Expect(to.ListBindingInstances(ctx)).To(Equal(map[string]string{"binding-id": "instance-id"}))
+		Expect(to.RetrieveOperation(ctx, "instance-id")).To(Equal(nfsbroker.Operation{Type: nfsbroker.ProvisionOperation, State: brokerapi.Succeeded}))
+		Expect(to.ListRetiredPlans(ctx)).To(Equal(map[string]string{"old-plan-id": "use new-plan"}))
+		Expect(to.ListShareReservations(ctx)).To(Equal(map[string]string{"server:/finance": "org-guid"}))
+
+		// the parameters hash is copied rather than taken again, so conflict checks still work
+		original, err := from.RetrieveBindingDetails(ctx, "binding-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(original.Parameters).To(HaveKey(nfsbroker.HashKey))
+		Expect(to.RetrieveBindingDetails(ctx, "binding-id")).To(Equal(original))
+	})
+
+	It("leaves records that were copied already alone when run again", func() {
+		_, err := nfsbroker.MigrateStore(ctx, logger, from, to)
+		Expect(err).NotTo(HaveOccurred())
+
+		report, err := nfsbroker.MigrateStore(ctx, logger, from, to)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Instances).To(Equal(nfsbroker.MigrationCount{Unchanged: 1}))
+		Expect(report.Bindings).To(Equal(nfsbroker.MigrationCount{Unchanged: 1}))
+		Expect(report.Conflicts()).To(Equal(0))
+		Expect(report.Problems).To(BeEmpty())
+	})
+
+	It("reports records stored differently in the target store, without overwriting them", func() {
+		different := instance
+		different.Share = "other-server:/export"
+		Expect(to.CreateInstanceDetails(ctx, "instance-id", different)).To(Succeed())
+		Expect(to.RetirePlan(ctx, "old-plan-id", "another hint")).To(Succeed())
+
+		report, err := nfsbroker.MigrateStore(ctx, logger, from, to)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Instances.Conflicts).To(Equal([]string{"instance-id"}))
+		Expect(report.RetiredPlans.Conflicts).To(Equal([]string{"old-plan-id"}))
+		Expect(report.Bindings).To(Equal(nfsbroker.MigrationCount{Copied: 1}))
+		Expect(report.Conflicts()).To(Equal(2))
+
+		stored, err := to.RetrieveInstanceDetails(ctx, "instance-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored.Share).To(Equal("other-server:/export"))
+		Expect(report.Problems).To(BeEmpty())
+	})
+
+	It("reports a target store holding records the source store does not", func() {
+		Expect(to.CreateInstanceDetails(ctx, "other-instance-id", instance)).To(Succeed())
+
+		report, err := nfsbroker.MigrateStore(ctx, logger, from, to)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Problems).To(Equal([]string{"the target store has 2 service instances, but the source store has 1"}))
+	})
+
+	Context("when the source store has records that cannot be read", func() {
+		var fakeStore *nfsbrokerfakes.FakeStore
+
+		BeforeEach(func() {
+			stored, err := from.RetrieveInstanceDetails(ctx, "instance-id")
+			Expect(err).NotTo(HaveOccurred())
+
+			// a SQL store skips rows whose JSON is corrupt when it lists them, but still counts them
+			fakeStore = &nfsbrokerfakes.FakeStore{}
+			fakeStore.ListInstanceDetailsReturns(map[string]nfsbroker.ServiceInstance{"instance-id": stored}, nil)
+			fakeStore.CountInstancesReturns(2, nil)
+			from = fakeStore
+		})
+
+		It("copies the rest, and reports those it could not copy", func() {
+			report, err := nfsbroker.MigrateStore(ctx, logger, from, to)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Instances).To(Equal(nfsbroker.MigrationCount{Copied: 1}))
+			Expect(report.Problems).To(Equal([]string{
+				"1 of the source store's 2 service instances could not be read, and were not copied",
+				"the target store has 1 service instances, but the source store has 2",
+			}))
+		})
+	})
+
+	Context("when the target store is a SQL store", func() {
+		var mock sqlmock.Sqlmock
+
+		BeforeEach(func() {
+			db, sqlMock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			mock = sqlMock
+			to = &nfsbroker.SqlStore{Database: nfsbrokerfakes.FakeSQLMockConnection{db},
+				StoreType: "mysql", MaxValueSize: nfsbroker.DefaultMaxValueSize}
+		})
+
+		It("inserts every record, and reads them back to verify the migration", func() {
+			instances, err := from.ListInstanceDetails(ctx, nfsbroker.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			instanceJSON, err := json.Marshal(instances["instance-id"])
+			Expect(err).NotTo(HaveOccurred())
+			bindings, err := from.ListBindingDetails(ctx, nfsbroker.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			bindingJSON, err := json.Marshal(bindings["binding-id"])
+			Expect(err).NotTo(HaveOccurred())
+			inserted := sqlmock.NewResult(1, 1)
+
+			mock.ExpectQuery("SELECT id, value FROM service_instances WHERE id = ?").WithArgs("instance-id").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "value"}))
+			mock.ExpectExec("INSERT INTO service_instances").WithArgs("instance-id", "service-id", "Existing", instanceJSON).
+				WillReturnResult(inserted)
+			mock.ExpectQuery("SELECT id, value FROM service_bindings WHERE id = ?").WithArgs("binding-id").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "value"}))
+			mock.ExpectExec("INSERT INTO service_bindings").WithArgs("binding-id", "instance-id", "", "", bindingJSON).
+				WillReturnResult(inserted)
+			mock.ExpectQuery("SELECT type, state, description FROM service_operations WHERE instance_id = ?").WithArgs("instance-id").
+				WillReturnRows(sqlmock.NewRows([]string{"type", "state", "description"}))
+			mock.ExpectQuery("SELECT instance_id FROM service_operations WHERE instance_id = ?").WithArgs("instance-id").
+				WillReturnRows(sqlmock.NewRows([]string{"instance_id"}))
+			mock.ExpectExec("INSERT INTO service_operations").WithArgs("instance-id", nfsbroker.ProvisionOperation, string(brokerapi.Succeeded), "").
+				WillReturnResult(inserted)
+			mock.ExpectQuery("SELECT plan_id, hint FROM retired_plans").WillReturnRows(sqlmock.NewRows([]string{"plan_id", "hint"}))
+			mock.ExpectQuery("SELECT plan_id FROM retired_plans WHERE plan_id = ?").WithArgs("old-plan-id").
+				WillReturnRows(sqlmock.NewRows([]string{"plan_id"}))
+			mock.ExpectExec("INSERT INTO retired_plans").WithArgs("old-plan-id", "use new-plan").WillReturnResult(inserted)
+			mock.ExpectQuery("SELECT prefix, organization_guid FROM share_reservations").
+				WillReturnRows(sqlmock.NewRows([]string{"prefix", "organization_guid"}))
+			mock.ExpectQuery("SELECT prefix FROM share_reservations WHERE prefix = ?").WithArgs("server:/finance").
+				WillReturnRows(sqlmock.NewRows([]string{"prefix"}))
+			mock.ExpectExec("INSERT INTO share_reservations").WithArgs("server:/finance", "org-guid").WillReturnResult(inserted)
+			mock.ExpectQuery(`SELECT COUNT\(\*\) FROM service_instances`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			mock.ExpectQuery("SELECT id, value FROM service_instances ORDER BY id").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "value"}).AddRow("instance-id", instanceJSON))
+			mock.ExpectQuery(`SELECT COUNT\(\*\) FROM service_bindings`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			mock.ExpectQuery("SELECT id, value FROM service_bindings ORDER BY id").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "value"}).AddRow("binding-id", bindingJSON))
+
+			report, err := nfsbroker.MigrateStore(ctx, logger, from, to)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Instances).To(Equal(nfsbroker.MigrationCount{Copied: 1}))
+			Expect(report.Bindings).To(Equal(nfsbroker.MigrationCount{Copied: 1}))
+			Expect(report.Operations).To(Equal(nfsbroker.MigrationCount{Copied: 1}))
+			Expect(report.RetiredPlans).To(Equal(nfsbroker.MigrationCount{Copied: 1}))
+			Expect(report.ShareReservations).To(Equal(nfsbroker.MigrationCount{Copied: 1}))
+			Expect(report.Problems).To(BeEmpty())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,61 @@
+package nfsbroker
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// EffectiveOptionsKey records, with each stored binding, the mount options the binding was given after the
+// operator's allowed and default options were applied.
+const EffectiveOptionsKey = "effectiveMountOptions"
+
+// checkShareOptions rejects shares whose query options the operator has not allowed, unless mounts are sloppy, so that
+// they are refused when the instance is provisioned instead of when it is bound.  Versions are checked against the
+// instance's plan instead.
+func (b *Broker) checkShareOptions(details ServiceInstance) error {
+	versionOption := b.shareTypeFor(details.ServiceID).VersionOption
+	disallowed := []string{}
+	for name, value := range details.ShareOptions {
+		if versionOption != "" && (name == "version" || name == "vers") {
+			continue
+		}
+		if value != "" && !inArray(b.config.mount.Allowed, name) {
+			disallowed = append(disallowed, name)
+		}
+	}
+	if len(disallowed) == 0 || b.sloppyMount(nil) {
+		return nil
+	}
+	sort.Strings(disallowed)
+	err := fmt.Errorf("share options not allowed: %s", strings.Join(disallowed, ", "))
+	return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "share-options-not-allowed")
+}
+
+// withEffectiveOptions returns bind parameters to store, recording the options of the binding's volume mount other
+// than secrets.
+func withEffectiveOptions(parameters map[string]interface{}, volumeMount brokerapi.VolumeMount) map[string]interface{} {
+	options := map[string]interface{}{}
+	for name, value := range volumeMount.Device.MountConfig {
+		if name != "source" && !inArray(SecretBindParameters, name) {
+			options[name] = value
+		}
+	}
+
+	recorded := map[string]interface{}{}
+	for name, value := range parameters {
+		recorded[name] = value
+	}
+	recorded[EffectiveOptionsKey] = options
+	return recorded
+}
+
+// recordedReadOnly reports whether a stored binding was mounted read-only, which its effective options record
+// whether it was asked for or came from the plan or the default mode.
+func recordedReadOnly(parameters map[string]interface{}) bool {
+	options, _ := parameters[EffectiveOptionsKey].(map[string]interface{})
+	return options["readonly"] == true
+}
@@ -0,0 +1,128 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Allowed and default mount options", func() {
+	var (
+		broker    *nfsbroker.Broker
+		fakeStore *nfsbrokerfakes.FakeStore
+		mounts    *nfsbroker.ConfigDetails
+		ctx       context.Context
+	)
+
+	BeforeEach(func() {
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		mounts = nfsbroker.NewNfsBrokerConfigDetails()
+		mounts.ReadConf("uid,gid", "uid:1000,gid:1000,nfs_version:4")
+		ctx = context.TODO()
+	})
+
+	JustBeforeEach(func() {
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-mount-options"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, fakeStore, nfsbroker.NewNfsBrokerConfig(mounts))
+	})
+
+	Context("when provisioning", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound)
+		})
+
+		provision := func(share string) error {
+			parameters, _ := json.Marshal(map[string]string{"share": share})
+			_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{PlanID: "Existing", RawParameters: parameters}, false)
+			return err
+		}
+
+		It("accepts share options that are allowed", func() {
+			Expect(provision("server:/export?uid=2000")).To(Succeed())
+		})
+
+		It("rejects share options that are not allowed", func() {
+			err := provision("server:/export?uid=2000&allow_root=true&nfs_version=3")
+			Expect(err).To(MatchError("share options not allowed: allow_root, nfs_version"))
+			Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusBadRequest))
+			Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+		})
+
+		Context("when mounts are sloppy", func() {
+			BeforeEach(func() {
+				mounts.ReadConf("uid,gid", "sloppy_mount:true")
+			})
+
+			It("accepts any share options", func() {
+				Expect(provision("server:/export?allow_root=true")).To(Succeed())
+			})
+		})
+	})
+
+	Context("when binding", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{PlanID: "Existing", Share: "server:/export"}, nil)
+		})
+
+		It("records the effective options with the binding", func() {
+			_, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid", Parameters: map[string]interface{}{"uid": "2000"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, _, _, stored := fakeStore.CreateBindingDetailsArgsForCall(0)
+			Expect(stored.Parameters).To(HaveKeyWithValue("uid", "2000"))
+			Expect(stored.Parameters).To(HaveKeyWithValue(nfsbroker.EffectiveOptionsKey, map[string]interface{}{
+				"uid":         "2000",
+				"gid":         "1000",
+				"nfs_version": "4",
+			}))
+		})
+
+		It("records the defaults for bindings made without parameters", func() {
+			_, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, _, _, stored := fakeStore.CreateBindingDetailsArgsForCall(0)
+			Expect(stored.Parameters).To(Equal(map[string]interface{}{
+				nfsbroker.EffectiveOptionsKey: map[string]interface{}{"uid": "1000", "gid": "1000", "nfs_version": "4"},
+			}))
+		})
+	})
+
+	Context("when bindings with effective options are stored", func() {
+		var store nfsbroker.Store
+
+		BeforeEach(func() {
+			store = nfsbroker.NewMemoryStore(0)
+		})
+
+		It("does not count them towards conflicts", func() {
+			requested := brokerapi.BindDetails{AppGUID: "app-guid", Parameters: map[string]interface{}{"uid": "2000"}}
+			stored := brokerapi.BindDetails{AppGUID: "app-guid", Parameters: map[string]interface{}{
+				"uid":                         "2000",
+				nfsbroker.EffectiveOptionsKey: map[string]interface{}{"uid": "2000"},
+			}}
+			Expect(store.CreateBindingDetails(ctx, "instance-id", "binding-id", stored)).To(Succeed())
+			Expect(store.IsBindingConflict(ctx, "binding-id", requested)).To(BeFalse())
+
+			retrieved, err := store.RetrieveBindingDetails(ctx, "binding-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retrieved.Parameters).To(HaveKeyWithValue(nfsbroker.EffectiveOptionsKey, map[string]interface{}{"uid": "2000"}))
+		})
+
+		It("does not conflict with retries of bindings made without parameters", func() {
+			stored := brokerapi.BindDetails{AppGUID: "app-guid", Parameters: map[string]interface{}{
+				nfsbroker.EffectiveOptionsKey: map[string]interface{}{"uid": "1000"},
+			}}
+			Expect(store.CreateBindingDetails(ctx, "instance-id", "binding-id", stored)).To(Succeed())
+			Expect(store.IsBindingConflict(ctx, "binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,170 @@
+package nfsbroker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+)
+
+//go:generate counterfeiter -o ../nfsbrokerfakes/fake_name_lookup.go . NameLookup
+type NameLookup interface {
+	OrgNameLookup
+	SpaceName(ctx context.Context, guid string) (string, error)
+}
+
+// NameCache remembers organization and space names for ttl, since operators' reports look up the same organizations
+// and spaces over and over.  Failed lookups are not cached.
+type NameCache struct {
+	lookup NameLookup
+	clock  clock.Clock
+	ttl    time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]cachedName
+	hits    int
+	misses  int
+}
+
+// NameCacheStats count the names a NameCache holds and how often lookups found them.
+type NameCacheStats struct {
+	Entries int `json:"entries"`
+	Hits    int `json:"hits"`
+	Misses  int `json:"misses"`
+}
+
+type cachedName struct {
+	name    string
+	expires time.Time
+}
+
+func NewNameCache(lookup NameLookup, clock clock.Clock, ttl time.Duration) *NameCache {
+	return &NameCache{
+		lookup:  lookup,
+		clock:   clock,
+		ttl:     ttl,
+		entries: map[string]cachedName{},
+	}
+}
+
+func (c *NameCache) OrganizationName(ctx context.Context, guid string) (string, error) {
+	return c.name(ctx, "organization/"+guid, guid, c.lookup.OrganizationName)
+}
+
+func (c *NameCache) SpaceName(ctx context.Context, guid string) (string, error) {
+	return c.name(ctx, "space/"+guid, guid, c.lookup.SpaceName)
+}
+
+func (c *NameCache) name(ctx context.Context, key, guid string, lookup func(context.Context, string) (string, error)) (string, error) {
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	if ok && c.clock.Now().Before(entry.expires) {
+		c.hits++
+		c.mutex.Unlock()
+		return entry.name, nil
+	}
+	c.misses++
+	c.mutex.Unlock()
+
+	name, err := lookup(ctx, guid)
+	if err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[key] = cachedName{name: name, expires: c.clock.Now().Add(c.ttl)}
+	return name, nil
+}
+
+func (c *NameCache) Stats() NameCacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return NameCacheStats{Entries: len(c.entries), Hits: c.hits, Misses: c.misses}
+}
+
+// InstanceReport describes a service instance for operators, with organization and space names filled in when the
+// broker can look them up.
+type InstanceReport struct {
+	ID               string `json:"id"`
+	ServiceID        string `json:"service_id"`
+	PlanID           string `json:"plan_id"`
+	OrganizationGUID string `json:"organization_guid"`
+	OrganizationName string `json:"organization_name,omitempty"`
+	SpaceGUID        string `json:"space_guid"`
+	SpaceName        string `json:"space_name,omitempty"`
+	Share            string `json:"share"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// SetNameLookup configures how organization and space names are found for logs and reports.
+func (b *Broker) SetNameLookup(lookup NameLookup) {
+	b.nameLookup = lookup
+}
+
+// InstanceReports lists every service instance in ID order.
+func (b *Broker) InstanceReports(ctx context.Context) ([]InstanceReport, error) {
+	b.mutex.Lock()
+	instances := map[string]ServiceInstance{}
+	err := ForEachInstance(ctx, b.store, ListOptions{}, 100, func(id string, details ServiceInstance) error {
+		instances[id] = details
+		return nil
+	})
+	b.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []InstanceReport{}
+	for id, details := range instances {
+		names := b.instanceNames(ctx, details)
+		reports = append(reports, InstanceReport{
+			ID:               id,
+			ServiceID:        details.ServiceID,
+			PlanID:           details.PlanID,
+			OrganizationGUID: details.OrganizationGUID,
+			OrganizationName: names.organization,
+			SpaceGUID:        details.SpaceGUID,
+			SpaceName:        names.space,
+			Share:            details.Share,
+			Labels:           details.Labels,
+		})
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID < reports[j].ID })
+	return reports, nil
+}
+
+type instanceNames struct {
+	organization string
+	space        string
+}
+
+// instanceNames looks up the names of an instance's organization and space.  Names that cannot be found are left
+// empty so that logging and reporting carry on without them.
+func (b *Broker) instanceNames(ctx context.Context, details ServiceInstance) instanceNames {
+	var names instanceNames
+	if b.nameLookup == nil || isProbe(ctx) {
+		return names
+	}
+
+	// lookups are best effort, so their failures are logged rather than recorded in the span
+	ctx, endSpan := startSpan(ctx, "look-up-names")
+	defer endSpan(nil)
+
+	var err error
+	if details.OrganizationGUID != "" {
+		if names.organization, err = b.nameLookup.OrganizationName(ctx, details.OrganizationGUID); err != nil {
+			b.logger.Info("failed-to-look-up-organization-name", lager.Data{"guid": details.OrganizationGUID, "error": err.Error()})
+		}
+	}
+	if details.SpaceGUID != "" {
+		if names.space, err = b.nameLookup.SpaceName(ctx, details.SpaceGUID); err != nil {
+			b.logger.Info("failed-to-look-up-space-name", lager.Data{"guid": details.SpaceGUID, "error": err.Error()})
+		}
+	}
+	return names
+}
@@ -0,0 +1,127 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NameCache", func() {
+	var (
+		cache      *nfsbroker.NameCache
+		fakeLookup *nfsbrokerfakes.FakeNameLookup
+		fakeClock  *fakeclock.FakeClock
+		ctx        context.Context
+	)
+
+	BeforeEach(func() {
+		fakeLookup = &nfsbrokerfakes.FakeNameLookup{}
+		fakeLookup.OrganizationNameReturns("my-org", nil)
+		fakeLookup.SpaceNameReturns("my-space", nil)
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		cache = nfsbroker.NewNameCache(fakeLookup, fakeClock, time.Minute)
+		ctx = context.TODO()
+	})
+
+	It("looks up each name once while it is cached", func() {
+		for i := 0; i < 2; i++ {
+			name, err := cache.OrganizationName(ctx, "org-guid")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(Equal("my-org"))
+
+			name, err = cache.SpaceName(ctx, "space-guid")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(Equal("my-space"))
+		}
+		Expect(fakeLookup.OrganizationNameCallCount()).To(Equal(1))
+		Expect(fakeLookup.SpaceNameCallCount()).To(Equal(1))
+		_, guid := fakeLookup.SpaceNameArgsForCall(0)
+		Expect(guid).To(Equal("space-guid"))
+	})
+
+	It("looks names up again once they expire", func() {
+		_, err := cache.OrganizationName(ctx, "org-guid")
+		Expect(err).NotTo(HaveOccurred())
+
+		fakeClock.Increment(time.Minute)
+		fakeLookup.OrganizationNameReturns("renamed-org", nil)
+
+		name, err := cache.OrganizationName(ctx, "org-guid")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(name).To(Equal("renamed-org"))
+		Expect(fakeLookup.OrganizationNameCallCount()).To(Equal(2))
+	})
+
+	It("does not cache failed lookups", func() {
+		fakeLookup.OrganizationNameReturns("", errors.New("cloud controller is down"))
+		_, err := cache.OrganizationName(ctx, "org-guid")
+		Expect(err).To(MatchError("cloud controller is down"))
+
+		fakeLookup.OrganizationNameReturns("my-org", nil)
+		name, err := cache.OrganizationName(ctx, "org-guid")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(name).To(Equal("my-org"))
+	})
+})
+
+var _ = Describe("InstanceReports", func() {
+	var (
+		broker     *nfsbroker.Broker
+		fakeStore  *nfsbrokerfakes.FakeStore
+		fakeLookup *nfsbrokerfakes.FakeNameLookup
+	)
+
+	BeforeEach(func() {
+		logger := lagertest.NewTestLogger("test-names")
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		fakeStore.ListInstanceDetailsReturns(map[string]nfsbroker.ServiceInstance{
+			"instance-2": {ServiceID: "service-id", PlanID: "Existing", OrganizationGUID: "org-guid", SpaceGUID: "space-guid", Share: "server/b"},
+			"instance-1": {ServiceID: "service-id", PlanID: "Existing", OrganizationGUID: "other-org-guid", SpaceGUID: "other-space-guid", Share: "server/a"},
+		}, nil)
+		fakeLookup = &nfsbrokerfakes.FakeNameLookup{}
+		fakeLookup.OrganizationNameStub = func(_ context.Context, guid string) (string, error) {
+			if guid == "org-guid" {
+				return "my-org", nil
+			}
+			return "", errors.New("not authorized")
+		}
+		fakeLookup.SpaceNameReturns("my-space", nil)
+
+		broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, fakeStore,
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+	})
+
+	It("lists instances in ID order with their organization and space names", func() {
+		broker.SetNameLookup(fakeLookup)
+
+		reports, err := broker.InstanceReports(context.TODO())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reports).To(Equal([]nfsbroker.InstanceReport{
+			{ID: "instance-1", ServiceID: "service-id", PlanID: "Existing", OrganizationGUID: "other-org-guid", SpaceGUID: "other-space-guid", SpaceName: "my-space", Share: "server/a"},
+			{ID: "instance-2", ServiceID: "service-id", PlanID: "Existing", OrganizationGUID: "org-guid", OrganizationName: "my-org", SpaceGUID: "space-guid", SpaceName: "my-space", Share: "server/b"},
+		}))
+	})
+
+	It("leaves names out without a lookup", func() {
+		reports, err := broker.InstanceReports(context.TODO())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reports).To(HaveLen(2))
+		Expect(reports[0].OrganizationName).To(BeEmpty())
+		Expect(reports[0].SpaceName).To(BeEmpty())
+	})
+
+	It("reports store failures", func() {
+		fakeStore.ListInstanceDetailsReturns(nil, errors.New("database is down"))
+
+		_, err := broker.InstanceReports(context.TODO())
+		Expect(err).To(MatchError("database is down"))
+	})
+})
@@ -0,0 +1,261 @@
+package nfsbroker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// NetworkRule is an egress rule an app needs to mount a share.  Rules have the format of Cloud Foundry application
+// security group rules, so that operators can apply them as they are.
+type NetworkRule struct {
+	Protocol    string `json:"protocol"`
+	Destination string `json:"destination"`
+	Ports       string `json:"ports"`
+	Description string `json:"description,omitempty"`
+}
+
+// BindingMetadata is the metadata of bind and fetch binding responses.
+type BindingMetadata struct {
+	NetworkRules []NetworkRule `json:"network_rules,omitempty"`
+}
+
+// HostResolver resolves share servers to their addresses.  *net.Resolver is one.
+//
+//go:generate counterfeiter -o ../nfsbrokerfakes/fake_host_resolver.go . HostResolver
+type HostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// EgressPolicyCreator lets an app reach the destinations of network rules, for instance through the Cloud Foundry
+// networking policy API.  Allowing egress that is already allowed must succeed.
+//
+//go:generate counterfeiter -o ../nfsbrokerfakes/fake_egress_policy_creator.go . EgressPolicyCreator
+type EgressPolicyCreator interface {
+	AllowEgress(ctx context.Context, appGUID string, rules []NetworkRule) error
+}
+
+type networkRules struct {
+	resolver HostResolver
+	ports    string
+	creator  EgressPolicyCreator
+}
+
+// SetNetworkRules makes the broker work out the egress rules apps need to reach the servers of the shares they
+// bind, on the given TCP ports.  Rules are returned in bind and fetch binding responses and by the admin API.  When
+// creator is not nil, binds also allow their apps the egress, and fail if it cannot be allowed; egress is left
+// allowed when apps are unbound, since other bindings of the app may still need it.
+func (b *Broker) SetNetworkRules(resolver HostResolver, ports []int, creator EgressPolicyCreator) {
+	formatted := []string{}
+	for _, port := range ports {
+		formatted = append(formatted, strconv.Itoa(port))
+	}
+	b.networkRules = &networkRules{resolver: resolver, ports: strings.Join(formatted, ","), creator: creator}
+}
+
+// ParseNetworkRulePorts reads a comma-separated list of TCP ports.
+func ParseNetworkRulePorts(value string) ([]int, error) {
+	ports := []int{}
+	for _, field := range strings.Split(value, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q", field)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// instanceNetworkRules returns the rules apps need to reach the servers of an instance's shares, one per address of
+// each server.  Shares without a server need none.
+func (b *Broker) instanceNetworkRules(ctx context.Context, details ServiceInstance) ([]NetworkRule, error) {
+	if b.networkRules == nil || isProbe(ctx) {
+		return nil, nil
+	}
+
+	rules := []NetworkRule{}
+	seen := map[string]bool{}
+	for _, share := range details.eachShare() {
+		server := share.details.ShareServer
+		if server == "" || seen[server] {
+			continue
+		}
+		seen[server] = true
+
+		addresses := []string{server}
+		if net.ParseIP(server) == nil {
+			var err error
+			if addresses, err = b.networkRules.resolver.LookupHost(ctx, server); err != nil {
+				return nil, fmt.Errorf("failed to resolve share server %s: %w", server, err)
+			}
+			sort.Strings(addresses)
+		}
+
+		for _, address := range addresses {
+			rules = append(rules, NetworkRule{
+				Protocol:    "tcp",
+				Destination: address,
+				Ports:       b.networkRules.ports,
+				Description: "share server " + server,
+			})
+		}
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	return rules, nil
+}
+
+// bindingMetadata returns the metadata of a binding of the instance.  Rules that cannot be worked out are left out,
+// since the binding itself is fine.
+func (b *Broker) bindingMetadata(ctx context.Context, logger lager.Logger, details ServiceInstance) *BindingMetadata {
+	rules, err := b.instanceNetworkRules(ctx, details)
+	if err != nil {
+		logger.Error("failed-to-compute-network-rules", err)
+		return nil
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	return &BindingMetadata{NetworkRules: rules}
+}
+
+// NewBindingMetadataHandler adds the network rules of new bindings to bind responses as binding metadata, which the
+// broker API library does not know about, and passes every request on to next.
+func NewBindingMetadataHandler(logger lager.Logger, broker *Broker, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, ServiceInstancesPath), "/")
+		isBindingPath := strings.HasPrefix(r.URL.Path, ServiceInstancesPath) && len(parts) == 3 && parts[0] != "" && parts[1] == "service_bindings" && parts[2] != ""
+		if broker.networkRules == nil || r.Method != "PUT" || !isBindingPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := httptest.NewRecorder()
+		next.ServeHTTP(recorder, r)
+		var binding map[string]interface{}
+		if (recorder.Code != http.StatusOK && recorder.Code != http.StatusCreated) || json.Unmarshal(recorder.Body.Bytes(), &binding) != nil {
+			for key, values := range recorder.Header() {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(recorder.Code)
+			w.Write(recorder.Body.Bytes())
+			return
+		}
+
+		if metadata := broker.instanceBindingMetadata(r.Context(), logger, parts[0]); metadata != nil {
+			binding["metadata"] = metadata
+		}
+		writeJSON(w, recorder.Code, binding)
+	})
+}
+
+// instanceBindingMetadata returns the metadata of bindings of an instance.
+func (b *Broker) instanceBindingMetadata(ctx context.Context, logger lager.Logger, instanceID string) *BindingMetadata {
+	if err := b.lockFor(ctx); err != nil {
+		return nil
+	}
+	defer b.mutex.Unlock()
+
+	details, err := b.store.RetrieveInstanceDetails(ctx, instanceID)
+	if err != nil {
+		logger.Error("failed-to-retrieve-instance-for-binding-metadata", err, lager.Data{"instanceID": instanceID})
+		return nil
+	}
+	return b.bindingMetadata(ctx, logger, details)
+}
+
+// allowEgress lets a newly bound app reach the server of the instance's share.
+func (b *Broker) allowEgress(ctx context.Context, logger lager.Logger, appGUID string, details ServiceInstance) error {
+	if b.networkRules == nil || b.networkRules.creator == nil {
+		return nil
+	}
+
+	rules, err := b.instanceNetworkRules(ctx, details)
+	if err == nil && len(rules) > 0 {
+		err = b.networkRules.creator.AllowEgress(ctx, appGUID, rules)
+	}
+	if err != nil {
+		logger.Error("failed-to-allow-egress", err, lager.Data{"appGUID": appGUID})
+		err = fmt.Errorf("failed to allow app %s to reach share server %s: %w", appGUID, details.ShareServer, err)
+		return brokerapi.NewFailureResponse(err, http.StatusServiceUnavailable, "egress-policy-failed")
+	}
+	return nil
+}
+
+// ErrNetworkRulesDisabled is returned when network rules are asked for but the broker does not work them out.
+var ErrNetworkRulesDisabled = errors.New("network rules are not enabled")
+
+// BindingNetworkRules lists the rules that the app of a binding needs to mount its share.
+type BindingNetworkRules struct {
+	BindingID    string        `json:"binding_id"`
+	InstanceID   string        `json:"instance_id"`
+	AppGUID      string        `json:"app_guid"`
+	NetworkRules []NetworkRule `json:"network_rules"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// NetworkRuleReports returns the network rules of every binding, or of the bindings of one app when appGUID is set,
+// in binding ID order.  Bindings whose rules cannot be worked out are reported with the error.
+func (b *Broker) NetworkRuleReports(ctx context.Context, appGUID string) ([]BindingNetworkRules, error) {
+	if b.networkRules == nil {
+		return nil, ErrNetworkRulesDisabled
+	}
+
+	if err := b.lockFor(ctx); err != nil {
+		return nil, err
+	}
+	defer b.mutex.Unlock()
+
+	bindings, err := b.store.ListBindingDetails(ctx, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	bindingInstances, err := b.store.ListBindingInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []BindingNetworkRules{}
+	rulesByInstance := map[string]BindingNetworkRules{}
+	for bindingID, details := range bindings {
+		if appGUID != "" && details.AppGUID != appGUID {
+			continue
+		}
+
+		instanceID := bindingInstances[bindingID]
+		instanceRules, ok := rulesByInstance[instanceID]
+		if !ok {
+			instanceRules = BindingNetworkRules{NetworkRules: []NetworkRule{}}
+			instanceDetails, err := b.store.RetrieveInstanceDetails(ctx, instanceID)
+			if err == nil {
+				var rules []NetworkRule
+				if rules, err = b.instanceNetworkRules(ctx, instanceDetails); rules != nil {
+					instanceRules.NetworkRules = rules
+				}
+			}
+			if err != nil {
+				instanceRules.Error = err.Error()
+			}
+			rulesByInstance[instanceID] = instanceRules
+		}
+
+		report := instanceRules
+		report.BindingID = bindingID
+		report.InstanceID = instanceID
+		report.AppGUID = details.AppGUID
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].BindingID < reports[j].BindingID })
+	return reports, nil
+}
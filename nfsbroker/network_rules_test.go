@@ -0,0 +1,228 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Network rules", func() {
+	var (
+		logger       *lagertest.TestLogger
+		broker       *nfsbroker.Broker
+		fakeStore    *nfsbrokerfakes.FakeStore
+		fakeResolver *nfsbrokerfakes.FakeHostResolver
+		fakeCreator  *nfsbrokerfakes.FakeEgressPolicyCreator
+		ctx          context.Context
+		bindDetails  brokerapi.BindDetails
+	)
+
+	expectedRules := []nfsbroker.NetworkRule{
+		{Protocol: "tcp", Destination: "10.0.0.1", Ports: "111,2049", Description: "share server nfs.example.com"},
+		{Protocol: "tcp", Destination: "10.0.0.2", Ports: "111,2049", Description: "share server nfs.example.com"},
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-network-rules")
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{ServiceID: "service-id", PlanID: "Existing", Share: "nfs.example.com:/export"}, nil)
+		fakeStore.RetrieveBindingDetailsReturns(brokerapi.BindDetails{}, nfsbroker.ErrBindingNotFound)
+		fakeResolver = &nfsbrokerfakes.FakeHostResolver{}
+		fakeResolver.LookupHostReturns([]string{"10.0.0.2", "10.0.0.1"}, nil)
+		fakeCreator = &nfsbrokerfakes.FakeEgressPolicyCreator{}
+
+		broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, fakeStore,
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		broker.SetNetworkRules(fakeResolver, []int{111, 2049}, nil)
+		ctx = context.Background()
+		bindDetails = brokerapi.BindDetails{AppGUID: "app-guid", ServiceID: "service-id", PlanID: "Existing"}
+	})
+
+	Describe("ParseNetworkRulePorts", func() {
+		It("reads comma-separated ports", func() {
+			Expect(nfsbroker.ParseNetworkRulePorts("111, 2049")).To(Equal([]int{111, 2049}))
+		})
+
+		It("rejects ports out of range", func() {
+			_, err := nfsbroker.ParseNetworkRulePorts("111,70000")
+			Expect(err).To(MatchError(`invalid port "70000"`))
+		})
+	})
+
+	Describe("bind responses", func() {
+		var (
+			handler  http.Handler
+			recorder *httptest.ResponseRecorder
+		)
+
+		BeforeEach(func() {
+			handler = nfsbroker.NewBindingMetadataHandler(logger, broker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, err := broker.Bind(r.Context(), "instance-id", "binding-id", bindDetails)
+				if err != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"credentials":{},"volume_mounts":[]}`))
+			}))
+			recorder = httptest.NewRecorder()
+		})
+
+		It("include the rules of the share server's addresses as metadata", func() {
+			handler.ServeHTTP(recorder, httptest.NewRequest("PUT", "/v2/service_instances/instance-id/service_bindings/binding-id", strings.NewReader("{}")))
+			Expect(recorder.Code).To(Equal(http.StatusCreated))
+
+			var response struct {
+				Credentials map[string]interface{}    `json:"credentials"`
+				Metadata    nfsbroker.BindingMetadata `json:"metadata"`
+			}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Credentials).NotTo(BeNil())
+			Expect(response.Metadata.NetworkRules).To(Equal(expectedRules))
+
+			_, host := fakeResolver.LookupHostArgsForCall(0)
+			Expect(host).To(Equal("nfs.example.com"))
+		})
+
+		It("leave other requests alone", func() {
+			handler.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/v2/service_instances/instance-id/service_bindings/binding-id", nil))
+			Expect(recorder.Body.String()).NotTo(ContainSubstring("metadata"))
+		})
+
+		Context("when the share server cannot be resolved", func() {
+			BeforeEach(func() {
+				fakeResolver.LookupHostReturns(nil, errors.New("no such host"))
+			})
+
+			It("still bind, without rules", func() {
+				handler.ServeHTTP(recorder, httptest.NewRequest("PUT", "/v2/service_instances/instance-id/service_bindings/binding-id", strings.NewReader("{}")))
+				Expect(recorder.Code).To(Equal(http.StatusCreated))
+				Expect(recorder.Body.String()).NotTo(ContainSubstring("metadata"))
+				Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("when the share server is an address", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{ServiceID: "service-id", Share: "192.168.1.5:/export"}, nil)
+			})
+
+			It("does not resolve it", func() {
+				handler.ServeHTTP(recorder, httptest.NewRequest("PUT", "/v2/service_instances/instance-id/service_bindings/binding-id", strings.NewReader("{}")))
+				Expect(recorder.Body.String()).To(ContainSubstring(`"destination":"192.168.1.5"`))
+				Expect(fakeResolver.LookupHostCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("fetched bindings", func() {
+		It("include the rules as metadata", func() {
+			fakeStore.RetrieveBindingDetailsReturns(bindDetails, nil)
+			spec, err := broker.GetBinding(ctx, "instance-id", "binding-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.Metadata).To(Equal(&nfsbroker.BindingMetadata{NetworkRules: expectedRules}))
+		})
+	})
+
+	Context("when binds create egress policies", func() {
+		BeforeEach(func() {
+			broker.SetNetworkRules(fakeResolver, []int{111, 2049}, fakeCreator)
+		})
+
+		It("allows the app to reach the share server before storing the binding", func() {
+			_, err := broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeCreator.AllowEgressCallCount()).To(Equal(1))
+			_, appGUID, rules := fakeCreator.AllowEgressArgsForCall(0)
+			Expect(appGUID).To(Equal("app-guid"))
+			Expect(rules).To(Equal(expectedRules))
+			Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(1))
+		})
+
+		Context("and the policy cannot be created", func() {
+			BeforeEach(func() {
+				fakeCreator.AllowEgressReturns(errors.New("policy server down"))
+			})
+
+			It("fails the bind", func() {
+				_, err := broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+				Expect(err).To(MatchError(ContainSubstring("policy server down")))
+				Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusServiceUnavailable))
+				Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("and the share server cannot be resolved", func() {
+			BeforeEach(func() {
+				fakeResolver.LookupHostReturns(nil, errors.New("no such host"))
+			})
+
+			It("fails the bind", func() {
+				_, err := broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+				Expect(err).To(MatchError(ContainSubstring("no such host")))
+				Expect(fakeCreator.AllowEgressCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("the admin endpoint", func() {
+		var recorder *httptest.ResponseRecorder
+
+		BeforeEach(func() {
+			fakeStore.ListBindingDetailsReturns(map[string]brokerapi.BindDetails{
+				"binding-2": {AppGUID: "other-app-guid"},
+				"binding-1": {AppGUID: "app-guid"},
+				"binding-3": {AppGUID: "app-guid"},
+			}, nil)
+			fakeStore.ListBindingInstancesReturns(map[string]string{"binding-1": "instance-id", "binding-2": "instance-id", "binding-3": "gone-instance-id"}, nil)
+			fakeStore.RetrieveInstanceDetailsStub = func(_ context.Context, id string) (nfsbroker.ServiceInstance, error) {
+				if id == "gone-instance-id" {
+					return nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound
+				}
+				return nfsbroker.ServiceInstance{Share: "nfs.example.com:/export"}, nil
+			}
+			recorder = httptest.NewRecorder()
+		})
+
+		It("lists the rules of an app's bindings", func() {
+			nfsbroker.NewAdminHandler(logger, broker).ServeHTTP(recorder, httptest.NewRequest("GET", nfsbroker.AdminNetworkRulesPath+"?app_guid=app-guid", nil))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			var response struct {
+				Bindings []nfsbroker.BindingNetworkRules `json:"bindings"`
+			}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Bindings).To(HaveLen(2))
+			Expect(response.Bindings[0]).To(Equal(nfsbroker.BindingNetworkRules{
+				BindingID: "binding-1", InstanceID: "instance-id", AppGUID: "app-guid", NetworkRules: expectedRules,
+			}))
+			Expect(response.Bindings[1].BindingID).To(Equal("binding-3"))
+			Expect(response.Bindings[1].Error).To(ContainSubstring("service instance not found"))
+			Expect(fakeResolver.LookupHostCallCount()).To(Equal(1))
+		})
+
+		Context("when network rules are not enabled", func() {
+			BeforeEach(func() {
+				broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, fakeStore,
+					nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+			})
+
+			It("is not found", func() {
+				nfsbroker.NewAdminHandler(logger, broker).ServeHTTP(recorder, httptest.NewRequest("GET", nfsbroker.AdminNetworkRulesPath, nil))
+				Expect(recorder.Code).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+})
@@ -0,0 +1,125 @@
+package nfsbroker
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// SupportedNFSVersions are the NFS protocol versions instances and bindings can ask for.
+var SupportedNFSVersions = []string{"3", "4", "4.1"}
+
+// parseNFSVersion reads a version given as a string, such as "4.1", or a JSON number, such as 3.  "4.0" is read as
+// "4".
+func parseNFSVersion(value interface{}) (string, error) {
+	var version string
+	switch value := value.(type) {
+	case string:
+		version = strings.TrimSpace(value)
+	case float64:
+		version = strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return "", invalidNFSVersion(fmt.Errorf("version must be a string or number, such as \"4.1\""))
+	}
+	version = strings.TrimSuffix(version, ".0")
+	if !inArray(SupportedNFSVersions, version) {
+		return "", invalidNFSVersion(fmt.Errorf("NFS version %q is not supported; expected one of %s", value, strings.Join(SupportedNFSVersions, ", ")))
+	}
+	return version, nil
+}
+
+func invalidNFSVersion(err error) error {
+	return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "invalid-nfs-version")
+}
+
+// validateNFSVersions checks the versions a plan allows.
+func validateNFSVersions(versions []string) error {
+	for _, version := range versions {
+		if !inArray(SupportedNFSVersions, version) {
+			return fmt.Errorf("NFS version %q is not supported; expected one of %s", version, strings.Join(SupportedNFSVersions, ", "))
+		}
+	}
+	return nil
+}
+
+// nfsVersion is the version the share is mounted with unless bindings ask for another: the version in the share's
+// options, or else the one the instance was provisioned with.  It is empty when neither names one, which leaves the
+// choice to the driver.
+func (s ServiceInstance) nfsVersion() string {
+	if s.ShareVersion != "" {
+		if version, err := parseNFSVersion(s.ShareVersion); err == nil {
+			return version
+		}
+		return s.ShareVersion
+	}
+	return s.NFSVersion
+}
+
+// provisionNFSVersion reads the version provision parameter, which must agree with any version in the share's
+// options.
+func (b *Broker) provisionNFSVersion(parameters map[string]interface{}, details ServiceInstance) (string, error) {
+	value, ok := parameters["version"]
+	if !ok || value == "" {
+		return "", nil
+	}
+	version, err := b.parseNFSVersion(details.ServiceID, value)
+	if err != nil {
+		return "", err
+	}
+	if shareVersion := details.nfsVersion(); shareVersion != "" && shareVersion != version {
+		err := fmt.Errorf("version %s conflicts with version %s in the share's options", version, shareVersion)
+		return "", invalidNFSVersion(err)
+	}
+	return version, nil
+}
+
+// bindNFSVersion returns the version a binding mounts the share with: the version bind parameter, if given, or else
+// the share's.
+func (b *Broker) bindNFSVersion(details ServiceInstance, parameters map[string]interface{}) (string, error) {
+	version := details.nfsVersion()
+	if value, ok := parameters["version"]; ok && value != "" {
+		var err error
+		if version, err = b.parseNFSVersion(details.ServiceID, value); err != nil {
+			return "", err
+		}
+	}
+	return version, b.checkNFSVersion(details.PlanID, version)
+}
+
+// parseNFSVersion refuses versions for the service's instances if its share type has none.
+func (b *Broker) parseNFSVersion(serviceID string, value interface{}) (string, error) {
+	if shareType := b.shareTypeFor(serviceID); shareType.VersionOption == "" {
+		return "", invalidNFSVersion(fmt.Errorf("%s shares have no protocol versions", shareType.Name))
+	}
+	return parseNFSVersion(value)
+}
+
+// checkNFSVersion refuses versions the plan does not allow.  Plans without a list of versions allow every supported
+// version, and shares without a version are left to the driver.
+func (b *Broker) checkNFSVersion(planID, version string) error {
+	if version == "" {
+		return nil
+	}
+	if _, err := parseNFSVersion(version); err != nil {
+		return err
+	}
+	plan, ok := b.plan(planID)
+	if !ok || len(plan.NFSVersions) == 0 || inArray(plan.NFSVersions, version) {
+		return nil
+	}
+	err := fmt.Errorf("plan %s does not offer NFS version %s; it offers %s", plan.Name, version, strings.Join(plan.NFSVersions, ", "))
+	return invalidNFSVersion(err)
+}
+
+// checkNFSVersions checks the version of each of an instance's shares against its plan.
+func (b *Broker) checkNFSVersions(details ServiceInstance) error {
+	for _, share := range details.eachShare() {
+		if err := b.checkNFSVersion(details.PlanID, share.details.nfsVersion()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
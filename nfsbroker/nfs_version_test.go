@@ -0,0 +1,126 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("NFS versions", func() {
+	var (
+		broker *nfsbroker.Broker
+		store  nfsbroker.Store
+		ctx    context.Context
+	)
+
+	provision := func(parameters string) error {
+		_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+			ServiceID:     "service-id",
+			PlanID:        "Existing",
+			RawParameters: json.RawMessage(parameters),
+		}, false)
+		return err
+	}
+
+	bind := func(parameters map[string]interface{}) (brokerapi.VolumeMount, error) {
+		binding, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid", Parameters: parameters})
+		if err != nil {
+			return brokerapi.VolumeMount{}, err
+		}
+		return binding.VolumeMounts[0], nil
+	}
+
+	BeforeEach(func() {
+		store = nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-nfs-versions"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, store, nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		ctx = context.Background()
+	})
+
+	It("mounts instances provisioned with a version with that version", func() {
+		Expect(provision(`{"share":"server:/export","version":"4.1"}`)).To(Succeed())
+		details, err := store.RetrieveInstanceDetails(ctx, "instance-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(details.NFSVersion).To(Equal("4.1"))
+
+		mount, err := bind(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mount.Device.MountConfig).To(HaveKeyWithValue("version", "4.1"))
+		Expect(mount.Device.MountConfig).To(HaveKeyWithValue("source", "nfs://server:/export"))
+	})
+
+	It("accepts versions given as numbers", func() {
+		Expect(provision(`{"share":"server:/export","version":3}`)).To(Succeed())
+		mount, err := bind(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mount.Device.MountConfig).To(HaveKeyWithValue("version", "3"))
+	})
+
+	It("leaves the version to the driver when none is given", func() {
+		Expect(provision(`{"share":"server:/export"}`)).To(Succeed())
+		mount, err := bind(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mount.Device.MountConfig).NotTo(HaveKey("version"))
+	})
+
+	It("uses the version in the share's options, though the operator has not allowed the option", func() {
+		Expect(provision(`{"share":"server:/export?vers=4"}`)).To(Succeed())
+		mount, err := bind(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mount.Device.MountConfig).To(HaveKeyWithValue("version", "4"))
+		Expect(mount.Device.MountConfig).NotTo(HaveKey("vers"))
+	})
+
+	It("lets bindings choose another version", func() {
+		Expect(provision(`{"share":"server:/export","version":"3"}`)).To(Succeed())
+		mount, err := bind(map[string]interface{}{"version": 4.1})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mount.Device.MountConfig).To(HaveKeyWithValue("version", "4.1"))
+	})
+
+	It("rejects unsupported versions", func() {
+		err := provision(`{"share":"server:/export","version":"2"}`)
+		Expect(err).To(MatchError(ContainSubstring(`NFS version "2" is not supported`)))
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusBadRequest))
+
+		Expect(provision(`{"share":"server:/export","version":true}`)).To(MatchError(brokerapi.ErrRawParamsInvalid))
+	})
+
+	It("rejects versions that conflict with the share's", func() {
+		err := provision(`{"share":"server:/export?version=3","version":"4.1"}`)
+		Expect(err).To(MatchError("version 4.1 conflicts with version 3 in the share's options"))
+	})
+
+	Context("when the plan offers only some versions", func() {
+		BeforeEach(func() {
+			broker.SetPlans([]nfsbroker.Plan{{ID: "Existing", Name: "existing", NFSVersions: []string{"4", "4.1"}}})
+		})
+
+		It("rejects instances and bindings of other versions", func() {
+			Expect(provision(`{"share":"server:/export","version":"3"}`)).To(MatchError(ContainSubstring("plan existing does not offer NFS version 3")))
+			Expect(provision(`{"share":"server:/export?version=3"}`)).To(MatchError(ContainSubstring("does not offer NFS version 3")))
+
+			Expect(provision(`{"share":"server:/export","version":"4"}`)).To(Succeed())
+			_, err := bind(map[string]interface{}{"version": "3"})
+			Expect(err).To(MatchError(ContainSubstring("does not offer NFS version 3")))
+		})
+
+		It("rejects plans with unsupported versions", func() {
+			_, err := nfsbroker.ParsePlans([]byte(`[{"name": "general", "nfs_versions": ["4.2"]}]`))
+			Expect(err).To(MatchError(ContainSubstring(`plan "general": NFS version "4.2" is not supported`)))
+		})
+	})
+
+	It("refuses versions for share types without them", func() {
+		broker.SetShareType(nfsbroker.CephFSShareType)
+		err := provision(`{"share":"mon:/path","version":"4"}`)
+		Expect(err).To(MatchError("cephfs shares have no protocol versions"))
+	})
+})
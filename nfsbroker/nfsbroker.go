@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"path"
 	"sync"
 
@@ -38,6 +39,29 @@ type ServiceInstance struct {
 	OrganizationGUID string `json:"organization_guid"`
 	SpaceGUID        string `json:"space_guid"`
 	Share            string
+
+	ShareServer  string            `json:"share_server,omitempty"`
+	SharePath    string            `json:"share_path,omitempty"`
+	ShareVersion string            `json:"share_version,omitempty"`
+	ShareOptions map[string]string `json:"share_options,omitempty"`
+
+	// MaintenanceVersion is the maintenance info version the instance was provisioned or last updated with.
+	MaintenanceVersion string `json:"maintenance_version,omitempty"`
+
+	// DashboardURL is the instance's dashboard page, fixed when the instance is provisioned.
+	DashboardURL string `json:"dashboard_url,omitempty"`
+
+	// Labels are free-form names and values that users and operators record on the instance, such as cost centers.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Shares names the instance's shares besides Share, which bindings can mount instead of or as well as it.
+	Shares map[string]string `json:"shares,omitempty"`
+
+	// Subdirectory is the subdirectory of the base export that the broker created for the instance's share.
+	Subdirectory string `json:"subdirectory,omitempty"`
+
+	// NFSVersion is the protocol version the instance was provisioned with, for shares whose options do not name one.
+	NFSVersion string `json:"nfs_version,omitempty"`
 }
 
 type lock interface {
@@ -54,6 +78,41 @@ type Broker struct {
 	static  staticState
 	store   Store
 	config  Config
+
+	shareType           ShareType
+	plans               []Plan
+	volumeMountDefaults VolumeMountDefaults
+	catalogServices     []CatalogService
+	quotas              *Quotas
+	uidRange            IDRange
+	gidRange            IDRange
+	defaultShareServers *DefaultShareServers
+	nameLookup          NameLookup
+	entitlementChecker  EntitlementChecker
+	entitlementFailOpen bool
+	maintenanceInfo     *MaintenanceInfo
+	minimumAPIVersion   APIVersion
+	dashboardURL        DashboardURL
+	provisionSteps      []ProvisionStep
+	deprovisionSteps    []DeprovisionStep
+	unbindSteps         []UnbindStep
+	sloProbe            *sloProbe
+	auditLog            lager.Logger
+	webhook             Webhook
+	metrics             Metrics
+	networkRules        *networkRules
+	legacyNotFound      bool
+	subdirectories      *Subdirectories
+	idResolver          IDResolver
+	allowExperimental   bool
+	shareChecker        ShareChecker
+	shareTemplate       *ShareTemplate
+	requireNonRootIDs   bool
+
+	bindingInstanceLookup BindingInstanceLookup
+
+	// operations counts the asynchronous operations still running, which Drain waits for.
+	operations *sync.WaitGroup
 }
 
 func New(
@@ -76,7 +135,17 @@ func New(
 			ServiceName: serviceName,
 			ServiceId:   serviceId,
 		},
-		config: *config,
+		config:    *config,
+		shareType: NFSShareType,
+		plans:     DefaultPlans,
+		uidRange:  DefaultIDRange,
+		gidRange:  DefaultIDRange,
+
+		volumeMountDefaults: DefaultVolumeMountDefaults,
+
+		minimumAPIVersion: DefaultMinimumAPIVersion,
+
+		operations: &sync.WaitGroup{},
 	}
 
 	theBroker.store.Restore(logger)
@@ -84,51 +153,174 @@ func New(
 	return &theBroker
 }
 
-func (b *Broker) Services(_ context.Context) []brokerapi.Service {
-	logger := b.logger.Session("services")
-	logger.Info("start")
-	defer logger.Info("end")
+// SetShareType configures the kind of filesystem the broker offers.  Brokers offer NFS shares by default.
+func (b *Broker) SetShareType(shareType ShareType) {
+	b.shareType = shareType
+}
 
-	return []brokerapi.Service{{
-		ID:            b.static.ServiceId,
-		Name:          b.static.ServiceName,
-		Description:   "Existing NFSv3 volumes (see: https://code.cloudfoundry.org/nfs-volume-release/)",
-		Bindable:      true,
-		PlanUpdatable: false,
-		Tags:          []string{"nfs"},
-		Requires:      []brokerapi.RequiredPermission{PermissionVolumeMount},
-
-		Plans: []brokerapi.ServicePlan{
-			{
-				Name:        "Existing",
-				ID:          "Existing",
-				Description: "A preexisting filesystem",
-			},
-		},
-	}}
+// SetDefaultShareServers configures the servers used for shares provisioned without one.
+func (b *Broker) SetDefaultShareServers(servers *DefaultShareServers) {
+	b.defaultShareServers = servers
 }
 
-func (b *Broker) Provision(context context.Context, instanceID string, details brokerapi.ProvisionDetails, asyncAllowed bool) (_ brokerapi.ProvisionedServiceSpec, e error) {
-	logger := b.logger.Session("provision").WithData(lager.Data{"instanceID": instanceID, "details": details})
+func (b *Broker) Services(ctx context.Context) []brokerapi.Service {
+	logger := b.logger.Session("services", identityData(ctx))
 	logger.Info("start")
 	defer logger.Info("end")
 
-	type Configuration struct {
-		Share string `json:"share"`
+	// the catalog can be reloaded
+	if err := b.lockFor(ctx); err != nil {
+		logger.Error("failed-to-lock", err)
+		return nil
 	}
-	var configuration Configuration
+	defer b.mutex.Unlock()
+	return b.services(ctx, logger)
+}
 
+func (b *Broker) services(ctx context.Context, logger lager.Logger) []brokerapi.Service {
+	retired, err := b.store.ListRetiredPlans(ctx)
+	if err != nil {
+		// the catalog is still worth serving; provisions of retired plans are refused all the same
+		logger.Error("failed-to-list-retired-plans", err)
+	}
+
+	if len(b.catalogServices) == 0 {
+		return []brokerapi.Service{{
+			ID:            b.static.ServiceId,
+			Name:          b.static.ServiceName,
+			Description:   b.shareType.Description,
+			Bindable:      true,
+			PlanUpdatable: true,
+			Tags:          b.shareType.Tags,
+			Requires:      []brokerapi.RequiredPermission{PermissionVolumeMount},
+
+			Plans: b.servicePlans(b.plans, retired),
+		}}
+	}
+
+	services := []brokerapi.Service{}
+	for _, catalogService := range b.catalogServices {
+		plans := []Plan{}
+		for _, plan := range b.plans {
+			if plan.serviceID == catalogService.ID {
+				plans = append(plans, plan)
+			}
+		}
+		services = append(services, brokerapi.Service{
+			ID:            catalogService.ID,
+			Name:          catalogService.Name,
+			Description:   catalogService.Description,
+			Bindable:      true,
+			PlanUpdatable: true,
+			Tags:          catalogService.Tags,
+			Requires:      []brokerapi.RequiredPermission{PermissionVolumeMount},
+			Metadata:      catalogService.Metadata,
+
+			Plans: b.servicePlans(plans, retired),
+		})
+	}
+	return services
+}
+
+// servicePlans describes plans for the catalog, marking those that are retired.
+func (b *Broker) servicePlans(plans []Plan, retired map[string]string) []brokerapi.ServicePlan {
+	servicePlans := []brokerapi.ServicePlan{}
+	for _, plan := range plans {
+		metadata := plan.Metadata
+		if hint, ok := retired[plan.ID]; ok {
+			metadata = retiredPlanMetadata(plan.Metadata, hint)
+		}
+		servicePlans = append(servicePlans, brokerapi.ServicePlan{
+			Name:        plan.Name,
+			ID:          plan.ID,
+			Description: plan.Description,
+			Free:        plan.Free,
+			Metadata:    metadata,
+			Schemas:     b.planSchemas(),
+		})
+	}
+	return servicePlans
+}
+
+func (b *Broker) Provision(ctx context.Context, instanceID string, details brokerapi.ProvisionDetails, asyncAllowed bool) (spec brokerapi.ProvisionedServiceSpec, e error) {
+	logger := b.logger.Session("provision", identityData(ctx)).WithData(lager.Data{"instanceID": instanceID, "details": details})
+	logger.Info("start")
+	defer logger.Info("end")
+	record := auditRequest(ctx, ProvisionOperation, instanceID, "")
+	record.space(provisionContext(details).OrganizationGUID, provisionContext(details).SpaceGUID)
+	defer func() { b.audit(record, spec.IsAsync, e) }()
+	defer func() { e = brokerError(e) }()
+
+	var parameters map[string]interface{}
 	var decoder *json.Decoder = json.NewDecoder(bytes.NewBuffer(details.RawParameters))
-	err := decoder.Decode(&configuration)
+	err := decoder.Decode(&parameters)
 	if err != nil {
 		return brokerapi.ProvisionedServiceSpec{}, brokerapi.ErrRawParamsInvalid
 	}
+	if err := checkParameters(b.provisionParameters(), parameters); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
 
-	if configuration.Share == "" {
-		return brokerapi.ProvisionedServiceSpec{}, errors.New("config requires a \"share\" key")
+	labels, err := provisionLabels(parameters)
+	if err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	shares, err := provisionShares(parameters)
+	if err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+
+	platform := provisionContext(details)
+	shareType := b.shareTypeFor(details.ServiceID)
+	requested, _ := parameters["share"].(string)
+	subdirectory := ""
+	if requested == "" && b.shareTemplate != nil && shareType.Name == b.shareType.Name {
+		if requested, err = b.templateShare(ctx, logger, instanceID, platform); err != nil {
+			return brokerapi.ProvisionedServiceSpec{}, err
+		}
+	} else if requested == "" && b.subdirectories != nil && shareType.Name == b.shareType.Name {
+		if requested, err = b.subdirectoryShare(instanceID); err != nil {
+			return brokerapi.ProvisionedServiceSpec{}, err
+		}
+		subdirectory = instanceID
+	}
+	share, err := b.completeShare(ctx, logger, shareType, requested, platform.OrganizationGUID)
+	if err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
 	}
 
-	b.mutex.Lock()
+	instanceDetails := ServiceInstance{
+		ServiceID:        details.ServiceID,
+		PlanID:           details.PlanID,
+		OrganizationGUID: platform.OrganizationGUID,
+		SpaceGUID:        platform.SpaceGUID,
+		Labels:           labels,
+		Subdirectory:     subdirectory,
+	}
+	if info, ok := requestedMaintenanceInfo(ctx); ok {
+		instanceDetails.MaintenanceVersion = info.Version
+	}
+	if err := instanceDetails.setShare(share); err != nil {
+		logger.Info("unparsed-share", lager.Data{"error": err.Error()})
+	}
+	if instanceDetails.NFSVersion, err = b.provisionNFSVersion(parameters, instanceDetails); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	if instanceDetails.Shares, err = b.completeShares(ctx, logger, shareType, shares, platform.OrganizationGUID); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	if err := b.checkShares(ctx, logger, instanceDetails); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	if err := b.checkReachable(ctx, logger, instanceDetails); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+
+	names := b.instanceNames(ctx, instanceDetails)
+
+	if err := b.lockFor(ctx); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
 	defer b.mutex.Unlock()
 	defer func() {
 		out := b.store.Save(logger)
@@ -137,33 +329,76 @@ func (b *Broker) Provision(context context.Context, instanceID string, details b
 		}
 	}()
 
-	instanceDetails := ServiceInstance{
-		details.ServiceID,
-		details.PlanID,
-		details.OrganizationGUID,
-		details.SpaceGUID,
-		configuration.Share}
+	if b.instanceConflicts(ctx, instanceDetails, instanceID) {
+		return brokerapi.ProvisionedServiceSpec{}, fmt.Errorf("%w: %s", ErrInstanceConflict, instanceID)
+	}
+
+	existing, err := b.store.RetrieveInstanceDetails(ctx, instanceID)
+	if err == nil {
+		return b.repeatedProvision(ctx, logger, instanceID, existing, asyncAllowed)
+	} else if !errors.Is(err, ErrInstanceNotFound) {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+
+	if err := b.checkPlan(ctx, details.PlanID); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	if err := b.checkPlanService(details.ServiceID, details.PlanID); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	if err := b.checkQuotas(ctx, instanceDetails.OrganizationGUID, instanceDetails.SpaceGUID); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	if err := b.checkShareReservations(ctx, instanceDetails); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+
+	async := len(b.provisionSteps) > 0 && !isProbe(ctx)
+	if async && !asyncAllowed {
+		return brokerapi.ProvisionedServiceSpec{}, brokerapi.ErrAsyncRequired
+	}
 
-	if b.instanceConflicts(instanceDetails, instanceID) {
-		return brokerapi.ProvisionedServiceSpec{}, brokerapi.ErrInstanceAlreadyExists
+	if err := b.createSubdirectory(ctx, logger, instanceDetails); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
 	}
 
-	err = b.store.CreateInstanceDetails(instanceID, instanceDetails)
+	instanceDetails.DashboardURL = b.instanceDashboardURL(instanceID, instanceDetails)
+	ctx = committed(ctx)
+	err = b.store.CreateInstanceDetails(ctx, instanceID, instanceDetails)
 	if err != nil {
-		return brokerapi.ProvisionedServiceSpec{}, fmt.Errorf("failed to store instance details %s", instanceID)
+		return brokerapi.ProvisionedServiceSpec{}, fmt.Errorf("failed to store instance details %s: %w", instanceID, err)
 	}
 
-	logger.Info("service-instance-created", lager.Data{"instanceDetails": instanceDetails})
+	logger.Info("service-instance-created", lager.Data{
+		"instanceDetails":   instanceDetails,
+		"organization_name": names.organization,
+		"space_name":        names.space,
+	})
 
-	return brokerapi.ProvisionedServiceSpec{IsAsync: false}, nil
+	if !async {
+		return brokerapi.ProvisionedServiceSpec{IsAsync: false, DashboardURL: instanceDetails.DashboardURL}, nil
+	}
+
+	err = b.store.SaveOperation(ctx, instanceID, Operation{Type: ProvisionOperation, State: brokerapi.InProgress})
+	if err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	b.runInBackground(func() { b.runProvisionSteps(logger, instanceID, instanceDetails, record) })
+
+	return brokerapi.ProvisionedServiceSpec{IsAsync: true, OperationData: ProvisionOperation, DashboardURL: instanceDetails.DashboardURL}, nil
 }
 
-func (b *Broker) Deprovision(context context.Context, instanceID string, details brokerapi.DeprovisionDetails, asyncAllowed bool) (_ brokerapi.DeprovisionServiceSpec, e error) {
-	logger := b.logger.Session("deprovision")
+func (b *Broker) Deprovision(ctx context.Context, instanceID string, details brokerapi.DeprovisionDetails, asyncAllowed bool) (spec brokerapi.DeprovisionServiceSpec, e error) {
+	logger := b.logger.Session("deprovision", identityData(ctx))
 	logger.Info("start")
 	defer logger.Info("end")
+	record := auditRequest(ctx, DeprovisionOperation, instanceID, "")
+	defer func() { b.audit(record, spec.IsAsync, e) }()
+	defer func() { e = b.deletionError(e) }()
 
-	b.mutex.Lock()
+	if err := b.lockFor(ctx); err != nil {
+		return brokerapi.DeprovisionServiceSpec{}, err
+	}
 	defer b.mutex.Unlock()
 	defer func() {
 		out := b.store.Save(logger)
@@ -172,25 +407,56 @@ func (b *Broker) Deprovision(context context.Context, instanceID string, details
 		}
 	}()
 
-	_, err := b.store.RetrieveInstanceDetails(instanceID)
+	instanceDetails, err := b.store.RetrieveInstanceDetails(ctx, instanceID)
+	if err != nil {
+		return brokerapi.DeprovisionServiceSpec{}, err
+	}
+	record.instance(instanceDetails)
+	if err := b.checkInstanceConcurrency(ctx, instanceID, ProvisionOperation); err != nil {
+		return brokerapi.DeprovisionServiceSpec{}, err
+	}
+
+	deprovisioning, err := b.inProgress(ctx, instanceID, DeprovisionOperation)
 	if err != nil {
-		return brokerapi.DeprovisionServiceSpec{}, brokerapi.ErrInstanceDoesNotExist
+		return brokerapi.DeprovisionServiceSpec{}, err
+	}
+	if deprovisioning || ((len(b.deprovisionSteps) > 0 || b.disposesSubdirectory(instanceDetails)) && asyncAllowed) {
+		if !asyncAllowed {
+			return brokerapi.DeprovisionServiceSpec{}, brokerapi.ErrAsyncRequired
+		}
+		if !deprovisioning {
+			err = b.store.SaveOperation(committed(ctx), instanceID, Operation{Type: DeprovisionOperation, State: brokerapi.InProgress})
+			if err != nil {
+				return brokerapi.DeprovisionServiceSpec{}, err
+			}
+			b.runInBackground(func() { b.runAsyncDeprovision(logger, instanceID, instanceDetails, record) })
+		}
+		return brokerapi.DeprovisionServiceSpec{IsAsync: true, OperationData: DeprovisionOperation}, nil
 	}
 
-	err = b.store.DeleteInstanceDetails(instanceID)
+	if err := b.runDeprovisionSteps(ctx, logger, instanceID, instanceDetails, func(string) {}); err != nil {
+		return brokerapi.DeprovisionServiceSpec{}, err
+	}
+
+	err = b.store.DeleteInstanceDetails(committed(ctx), instanceID)
 	if err != nil {
 		return brokerapi.DeprovisionServiceSpec{}, err
 	}
 
-	return brokerapi.DeprovisionServiceSpec{IsAsync: false, OperationData: "deprovision"}, nil
+	return brokerapi.DeprovisionServiceSpec{IsAsync: false, OperationData: DeprovisionOperation}, nil
 }
 
-func (b *Broker) Bind(context context.Context, instanceID string, bindingID string, bindDetails brokerapi.BindDetails) (_ brokerapi.Binding, e error) {
-	logger := b.logger.Session("bind")
+func (b *Broker) Bind(ctx context.Context, instanceID string, bindingID string, bindDetails brokerapi.BindDetails) (_ brokerapi.Binding, e error) {
+	logger := b.logger.Session("bind", identityData(ctx))
 	logger.Info("start", lager.Data{"bindingID": bindingID, "details": bindDetails})
 	defer logger.Info("end")
+	record := auditRequest(ctx, "bind", instanceID, bindingID)
+	defer func() { b.audit(record, false, e) }()
+	defer func() { e = brokerError(e) }()
 
-	b.mutex.Lock()
+	if err := b.lockFor(ctx); err != nil {
+		return brokerapi.Binding{}, err
+	}
 	defer b.mutex.Unlock()
 	defer func() {
 		out := b.store.Save(logger)
@@ -200,79 +466,161 @@ func (b *Broker) Bind(context context.Context, instanceID string, bindingID stri
 	}()
 
 	logger.Info("starting-nfsbroker-bind")
-	instanceDetails, err := b.store.RetrieveInstanceDetails(instanceID)
+	instanceDetails, err := b.store.RetrieveInstanceDetails(ctx, instanceID)
 	if err != nil {
-		return brokerapi.Binding{}, brokerapi.ErrInstanceDoesNotExist
+		return brokerapi.Binding{}, err
+	}
+	record.instance(instanceDetails)
+	if err := b.checkConcurrency(ctx, instanceID, ProvisionOperation, DeprovisionOperation); err != nil {
+		return brokerapi.Binding{}, err
+	}
+	if err := b.checkConcurrency(ctx, bindingID, UnbindOperation); err != nil {
+		return brokerapi.Binding{}, err
 	}
 
 	if bindDetails.AppGUID == "" {
 		return brokerapi.Binding{}, brokerapi.ErrAppGuidNotProvided
 	}
+	if err := checkParameters(b.bindParameterSpecs(), bindDetails.Parameters); err != nil {
+		return brokerapi.Binding{}, err
+	}
+	if err := b.checkLockedMountOptions(instanceDetails, bindDetails.Parameters); err != nil {
+		return brokerapi.Binding{}, err
+	}
+	shareType := b.shareTypeFor(instanceDetails.ServiceID)
+	if err := b.checkExperimental(shareType, bindDetails.Parameters); err != nil {
+		return brokerapi.Binding{}, err
+	}
+	if err := shareType.checkBindCredentials(bindDetails.Parameters); err != nil {
+		return brokerapi.Binding{}, err
+	}
+	shares, err := instanceDetails.boundShares(bindDetails.Parameters)
+	if err != nil {
+		return brokerapi.Binding{}, err
+	}
+	for _, share := range shares {
+		if err := b.checkEntitlement(ctx, logger, share.details); err != nil {
+			return brokerapi.Binding{}, err
+		}
+	}
 
-	mode, err := evaluateMode(bindDetails.Parameters)
+	if len(shareType.BindCredentials) == 0 {
+		// the username and password of share types with credentials are the share's, not a user's to resolve
+		if bindDetails.Parameters, err = b.resolveIDs(ctx, logger, bindDetails.Parameters); err != nil {
+			return brokerapi.Binding{}, err
+		}
+	}
+	if err := b.validateBindParameters(logger, shareType, bindDetails.Parameters); err != nil {
+		return brokerapi.Binding{}, err
+	}
+	mode, err := b.bindMode(instanceDetails, bindDetails.Parameters)
 	if err != nil {
 		return brokerapi.Binding{}, err
 	}
 
-	if b.bindingConflicts(bindingID, bindDetails) {
-		return brokerapi.Binding{}, brokerapi.ErrBindingAlreadyExists
+	if b.bindingConflicts(ctx, bindingID, bindDetails) {
+		return brokerapi.Binding{}, fmt.Errorf("%w: %s", ErrBindingConflict, bindingID)
 	}
 
 	logger.Info("retrieved-instance-details", lager.Data{"instanceDetails": instanceDetails})
 
-	err = b.store.CreateBindingDetails(bindingID, bindDetails)
+	volumeMounts, err := b.volumeMounts(logger, instanceID, bindingID, instanceDetails, mode, bindDetails.Parameters)
 	if err != nil {
 		return brokerapi.Binding{}, err
 	}
+	if err := b.checkNonRootIDs(shareType, volumeMounts); err != nil {
+		return brokerapi.Binding{}, err
+	}
+
+	credentials, err := b.bindingCredentials(instanceID, bindingID, instanceDetails, volumeMounts)
+	if err != nil {
+		return brokerapi.Binding{}, err
+	}
+
+	if err := b.allowEgress(ctx, logger, bindDetails.AppGUID, instanceDetails); err != nil {
+		return brokerapi.Binding{}, err
+	}
+
+	stored := bindDetails
+	stored.Parameters = withEffectiveOptions(bindDetails.Parameters, volumeMounts[0])
+	err = b.store.CreateBindingDetails(committed(ctx), instanceID, bindingID, stored)
+	if err != nil {
+		return brokerapi.Binding{}, err
+	}
+
+	return brokerapi.Binding{
+		Credentials:  credentials,
+		VolumeMounts: volumeMounts,
+	}, nil
+}
 
-	source := fmt.Sprintf("nfs://%s", instanceDetails.Share)
+// volumeMount builds the volume mount for a binding of the instance with the given bind parameters.
+func (b *Broker) volumeMount(logger lager.Logger, instanceID, bindingID string, instanceDetails ServiceInstance, mode string, parameters map[string]interface{}) (brokerapi.VolumeMount, error) {
+	shareType := b.shareTypeFor(instanceDetails.ServiceID)
+	source := shareType.Scheme + instanceDetails.Share
 
 	// TODO--brokerConfig is not re-entrant because it stores state in SetEntries--we should modify it to
 	// TODO--be stateless.  Until we do that, we will just make a local copy, but we should really
 	// TODO--refactor this to something more efficient.
 	tempConfig := b.config.Copy()
-	if err := tempConfig.SetEntries(logger, source, bindDetails.Parameters, []string{
-		"share", "mount", "kerberosPrincipal", "kerberosKeytab", "readonly",
-	}); err != nil {
+	if plan, ok := b.plan(instanceDetails.PlanID); ok {
+		tempConfig.mount.addDefaults(plan.MountOptions)
+		tempConfig.mount.lock(plan.LockedMountOptions)
+	}
+	version, err := b.bindNFSVersion(instanceDetails, parameters)
+	if err != nil {
+		return brokerapi.VolumeMount{}, err
+	}
+	ignored := append(parameterNames(bindParameters), "share", "vers", ExperimentalOption)
+	if err := tempConfig.SetEntries(logger, source, parameters, append(ignored, shareType.bindCredentialNames()...)); err != nil {
 		logger.Info("parameters-error-assign-entries", lager.Data{
 			"given_source":  source,
-			"given_options": bindDetails.Parameters,
+			"given_options": parameters,
 			"mount":         tempConfig.mount,
 			"sloppy_mount":  tempConfig.sloppyMount,
 		})
-		return brokerapi.Binding{}, err
+		return brokerapi.VolumeMount{}, err
 	}
 
 	mountConfig := tempConfig.MountConfig()
 	mountConfig["source"] = tempConfig.Share(source)
 	if mode == "r" {
 		mountConfig["readonly"] = true
-		mode = "rw"
+	}
+	if version != "" {
+		mountConfig[shareType.VersionOption] = version
+	}
+	for _, name := range shareType.bindCredentialNames() {
+		if value, ok := parameters[name].(string); ok && value != "" {
+			mountConfig[name] = value
+		}
+	}
+	if experimentalMount(parameters) {
+		mountConfig[ExperimentalOption] = true
+		if err := checkMapfsMountConfig(mountConfig); err != nil {
+			return brokerapi.VolumeMount{}, err
+		}
 	}
 
-	logger.Info("volume-service-binding", lager.Data{"Driver": "nfsv3driver", "mountConfig": mountConfig, "source": source})
+	logger.Info("volume-service-binding", lager.Data{"Driver": shareType.Driver, "mountConfig": mountConfig, "source": source})
 
 	s, err := b.hash(mountConfig)
 	if err != nil {
 		logger.Error("error-calculating-volume-id", err, lager.Data{"config": mountConfig, "bindingID": bindingID, "instanceID": instanceID})
-		return brokerapi.Binding{}, err
+		return brokerapi.VolumeMount{}, err
 	}
 	volumeId := fmt.Sprintf("%s-%s", instanceID, s)
 
-	ret := brokerapi.Binding{
-		Credentials: struct{}{}, // if nil, cloud controller chokes on response
-		VolumeMounts: []brokerapi.VolumeMount{{
-			ContainerDir: evaluateContainerPath(bindDetails.Parameters, instanceID),
-			Mode:         mode,
-			Driver:       "nfsv3driver",
-			DeviceType:   "shared",
-			Device: brokerapi.SharedDevice{
-				VolumeId:    volumeId,
-				MountConfig: mountConfig,
-			},
-		}},
-	}
-	return ret, nil
+	return brokerapi.VolumeMount{
+		ContainerDir: evaluateContainerPath(parameters, b.volumeMountDefaults.ContainerDir, instanceID),
+		Mode:         mode,
+		Driver:       shareType.Driver,
+		DeviceType:   "shared",
+		Device: brokerapi.SharedDevice{
+			VolumeId:    volumeId,
+			MountConfig: mountConfig,
+		},
+	}, nil
 }
 
 func (b *Broker) hash(mountConfig map[string]interface{}) (string, error) {
@@ -286,12 +634,33 @@ func (b *Broker) hash(mountConfig map[string]interface{}) (string, error) {
 	return fmt.Sprintf("%x", md5.Sum(bytes)), nil
 }
 
-func (b *Broker) Unbind(context context.Context, instanceID string, bindingID string, details brokerapi.UnbindDetails) (e error) {
-	logger := b.logger.Session("unbind")
+func (b *Broker) Unbind(ctx context.Context, instanceID string, bindingID string, details brokerapi.UnbindDetails) (e error) {
+	_, err := b.AsyncUnbind(ctx, instanceID, bindingID, details, false)
+	return err
+}
+
+func (b *Broker) Update(ctx context.Context, instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (_ brokerapi.UpdateServiceSpec, e error) {
+	logger := b.logger.Session("update", identityData(ctx)).WithData(lager.Data{"instanceID": instanceID, "details": details})
 	logger.Info("start")
 	defer logger.Info("end")
+	record := auditRequest(ctx, "update", instanceID, "")
+	defer func() { b.audit(record, false, e) }()
+	defer func() { e = brokerError(e) }()
+
+	var configuration struct {
+		Share  string             `json:"share"`
+		Shares map[string]*string `json:"shares"`
+		Labels map[string]*string `json:"labels"`
+	}
+	if len(details.RawParameters) > 0 {
+		if err := json.Unmarshal(details.RawParameters, &configuration); err != nil {
+			return brokerapi.UpdateServiceSpec{}, brokerapi.ErrRawParamsInvalid
+		}
+	}
 
-	b.mutex.Lock()
+	if err := b.lockFor(ctx); err != nil {
+		return brokerapi.UpdateServiceSpec{}, err
+	}
 	defer b.mutex.Unlock()
 	defer func() {
 		out := b.store.Save(logger)
@@ -300,55 +669,149 @@ func (b *Broker) Unbind(context context.Context, instanceID string, bindingID st
 		}
 	}()
 
-	if _, err := b.store.RetrieveInstanceDetails(instanceID); err != nil {
-		return brokerapi.ErrInstanceDoesNotExist
+	instanceDetails, err := b.store.RetrieveInstanceDetails(ctx, instanceID)
+	if err != nil {
+		return brokerapi.UpdateServiceSpec{}, err
+	}
+	record.instance(instanceDetails)
+	if err := b.checkInstanceConcurrency(ctx, instanceID, ProvisionOperation, DeprovisionOperation); err != nil {
+		return brokerapi.UpdateServiceSpec{}, err
 	}
 
-	if _, err := b.store.RetrieveBindingDetails(bindingID); err != nil {
-		return brokerapi.ErrBindingDoesNotExist
+	if details.ServiceID != "" && details.ServiceID != instanceDetails.ServiceID {
+		err := fmt.Errorf("service instance %s belongs to service %s, not %s", instanceID, instanceDetails.ServiceID, details.ServiceID)
+		return brokerapi.UpdateServiceSpec{}, brokerapi.NewFailureResponse(err, http.StatusBadRequest, "service-id-mismatch")
+	}
+	if previous := details.PreviousValues.PlanID; previous != "" && previous != instanceDetails.PlanID {
+		return brokerapi.UpdateServiceSpec{}, fmt.Errorf("%w: %s has plan %s, not %s", ErrInstanceChanged, instanceID, instanceDetails.PlanID, previous)
 	}
 
-	if err := b.store.DeleteBindingDetails(bindingID); err != nil {
-		return err
+	if details.PlanID != "" && details.PlanID != instanceDetails.PlanID {
+		if err := b.checkPlan(ctx, details.PlanID); err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+		if err := b.checkPlanService(instanceDetails.ServiceID, details.PlanID); err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+		instanceDetails.PlanID = details.PlanID
+		if err := b.checkNFSVersions(instanceDetails); err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+	}
+	if info, ok := requestedMaintenanceInfo(ctx); ok {
+		instanceDetails.MaintenanceVersion = info.Version
+	}
+	if configuration.Labels != nil {
+		if instanceDetails.Labels, err = mergeLabels(instanceDetails.Labels, configuration.Labels); err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+	}
+	if configuration.Share != "" {
+		share, err := b.completeShare(ctx, logger, b.shareTypeFor(instanceDetails.ServiceID), configuration.Share, instanceDetails.OrganizationGUID)
+		if err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+		if err := instanceDetails.setShare(share); err != nil {
+			logger.Info("unparsed-share", lager.Data{"error": err.Error()})
+		}
+	}
+	if configuration.Shares != nil {
+		shares, err := mergeShares(instanceDetails.Shares, configuration.Shares)
+		if err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+		if instanceDetails.Shares, err = b.completeShares(ctx, logger, b.shareTypeFor(instanceDetails.ServiceID), shares, instanceDetails.OrganizationGUID); err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+	}
+	if configuration.Share != "" || configuration.Shares != nil {
+		if err := b.checkShares(ctx, logger, instanceDetails); err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+		if err := b.checkShareReservations(ctx, instanceDetails); err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
 	}
-	return nil
-}
 
-func (b *Broker) Update(context context.Context, instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (brokerapi.UpdateServiceSpec, error) {
-	panic("not implemented")
+	err = b.store.UpdateInstanceDetails(committed(ctx), instanceID, instanceDetails)
+	if err != nil {
+		return brokerapi.UpdateServiceSpec{}, fmt.Errorf("failed to update instance details %s: %w", instanceID, err)
+	}
+
+	logger.Info("service-instance-updated", lager.Data{"instanceDetails": instanceDetails})
+
+	return brokerapi.UpdateServiceSpec{IsAsync: false}, nil
 }
 
-func (b *Broker) LastOperation(_ context.Context, instanceID string, operationData string) (brokerapi.LastOperation, error) {
-	logger := b.logger.Session("last-operation").WithData(lager.Data{"instanceID": instanceID})
+func (b *Broker) LastOperation(ctx context.Context, instanceID string, operationData string) (_ brokerapi.LastOperation, e error) {
+	logger := b.logger.Session("last-operation", identityData(ctx)).WithData(lager.Data{"instanceID": instanceID})
 	logger.Info("start")
 	defer logger.Info("end")
+	defer func() { e = brokerError(e) }()
 
-	b.mutex.Lock()
+	if err := b.lockFor(ctx); err != nil {
+		return brokerapi.LastOperation{}, err
+	}
 	defer b.mutex.Unlock()
 
 	switch operationData {
+	case ProvisionOperation:
+		return b.lastProvisionOperation(ctx, instanceID)
+	case DeprovisionOperation:
+		return b.lastDeprovisionOperation(ctx, instanceID)
 	default:
 		return brokerapi.LastOperation{}, errors.New("unrecognized operationData")
 	}
 }
 
-func (b *Broker) instanceConflicts(details ServiceInstance, instanceID string) bool {
-	return b.store.IsInstanceConflict(instanceID, ServiceInstance(details))
+// completeShare fills in the default server of shares given without one and validates the result.
+func (b *Broker) completeShare(ctx context.Context, logger lager.Logger, shareType ShareType, share, orgGUID string) (string, error) {
+	if !shareHasServer(share) {
+		server, err := b.defaultShareServer(ctx, orgGUID)
+		if err != nil {
+			logger.Error("failed-to-find-default-share-server", err)
+			return "", err
+		}
+		if server == "" {
+			err := fmt.Errorf("share %q does not name a server and organization %s has no default server", share, orgGUID)
+			return "", brokerapi.NewFailureResponse(err, http.StatusBadRequest, "default-share-server-missing")
+		}
+		share = server + share
+		logger.Info("using-default-share-server", lager.Data{"share": share})
+	}
+
+	if shareType.ValidateShare != nil {
+		if err := shareType.ValidateShare(share); err != nil {
+			return "", brokerapi.NewFailureResponse(err, http.StatusBadRequest, "invalid-share")
+		}
+	}
+	return share, nil
+}
+
+func (b *Broker) defaultShareServer(ctx context.Context, orgGUID string) (string, error) {
+	if b.defaultShareServers == nil {
+		return "", nil
+	}
+	return b.defaultShareServers.ServerFor(ctx, orgGUID)
+}
+
+func (b *Broker) instanceConflicts(ctx context.Context, details ServiceInstance, instanceID string) bool {
+	return b.store.IsInstanceConflict(ctx, instanceID, ServiceInstance(details))
 }
 
-func (b *Broker) bindingConflicts(bindingID string, details brokerapi.BindDetails) bool {
-	return b.store.IsBindingConflict(bindingID, details)
+func (b *Broker) bindingConflicts(ctx context.Context, bindingID string, details brokerapi.BindDetails) bool {
+	return b.store.IsBindingConflict(ctx, bindingID, details)
 }
 
-func evaluateContainerPath(parameters map[string]interface{}, volId string) string {
+func evaluateContainerPath(parameters map[string]interface{}, containerDir, volId string) string {
 	if containerPath, ok := parameters["mount"]; ok && containerPath != "" {
 		return containerPath.(string)
 	}
 
-	return path.Join(DefaultContainerPath, volId)
+	return path.Join(containerDir, volId)
 }
 
-func evaluateMode(parameters map[string]interface{}) (string, error) {
+func evaluateMode(parameters map[string]interface{}, defaultMode string) (string, error) {
 	if ro, ok := parameters["readonly"]; ok {
 		switch ro := ro.(type) {
 		case bool:
@@ -357,7 +820,7 @@ func evaluateMode(parameters map[string]interface{}) (string, error) {
 			return "", brokerapi.ErrRawParamsInvalid
 		}
 	}
-	return "rw", nil
+	return defaultMode, nil
 }
 
 func readOnlyToMode(ro bool) string {
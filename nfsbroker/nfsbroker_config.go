@@ -119,6 +119,25 @@ func (m *ConfigDetails) readConfDefault(flagString string) {
 	}
 }
 
+// addDefaults sets default values for options, forcing those that are not allowed to be set.
+func (m *ConfigDetails) addDefaults(options map[string]string) {
+	for k, v := range options {
+		if inArray(m.Allowed, k) {
+			m.Options[k] = v
+		} else {
+			m.Forced[k] = v
+		}
+	}
+}
+
+// lock forces options to the given values, whether or not they are allowed to be set.
+func (m *ConfigDetails) lock(options map[string]string) {
+	for k, v := range options {
+		delete(m.Options, k)
+		m.Forced[k] = v
+	}
+}
+
 func (m *ConfigDetails) ReadConf(allowedFlag string, defaultFlag string) error {
 	if len(allowedFlag) > 0 {
 		m.Allowed = strings.Split(allowedFlag, ",")
@@ -3,6 +3,8 @@ package nfsbroker_test
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"net/http"
 
 	"code.cloudfoundry.org/lager/lagertest"
 	"github.com/pivotal-cf/brokerapi"
@@ -56,7 +58,7 @@ var _ = Describe("Broker", func() {
 				Expect(result.Name).To(Equal("service-name"))
 				Expect(result.Description).To(Equal("Existing NFSv3 volumes (see: https://code.cloudfoundry.org/nfs-volume-release/)"))
 				Expect(result.Bindable).To(Equal(true))
-				Expect(result.PlanUpdatable).To(Equal(false))
+				Expect(result.PlanUpdatable).To(Equal(true))
 				Expect(result.Tags).To(ContainElement("nfs"))
 				Expect(result.Requires).To(ContainElement(brokerapi.RequiredPermission("volume_mount")))
 
@@ -64,6 +66,24 @@ var _ = Describe("Broker", func() {
 				Expect(result.Plans[0].ID).To(Equal("Existing"))
 				Expect(result.Plans[0].Description).To(Equal("A preexisting filesystem"))
 			})
+
+			It("describes the provision and bind parameters in the plan schemas", func() {
+				schemas := broker.Services(ctx)[0].Plans[0].Schemas
+				Expect(schemas).NotTo(BeNil())
+
+				create := schemas.Instance.Create.Parameters
+				Expect(create["type"]).To(Equal("object"))
+				Expect(create["required"]).To(Equal([]string{"share"}))
+				Expect(create["properties"]).To(HaveKeyWithValue("share", HaveKeyWithValue("type", "string")))
+				Expect(schemas.Instance.Update.Parameters).NotTo(HaveKey("required"))
+
+				bind := schemas.Binding.Create.Parameters["properties"]
+				Expect(bind).To(HaveKeyWithValue("mount", HaveKeyWithValue("type", "string")))
+				Expect(bind).To(HaveKeyWithValue("readonly", HaveKeyWithValue("default", false)))
+				Expect(bind).To(HaveKeyWithValue("uid", HaveKeyWithValue("type", []string{"string", "number", "boolean"})))
+				Expect(bind).To(HaveKeyWithValue("gid", HaveKey("description")))
+				Expect(bind).To(HaveKeyWithValue("sloppy_mount", HaveKeyWithValue("default", "true")))
+			})
 		})
 
 		Context(".Provision", func() {
@@ -84,7 +104,7 @@ var _ = Describe("Broker", func() {
 				_ = json.NewEncoder(buf).Encode(configuration)
 				provisionDetails = brokerapi.ProvisionDetails{PlanID: "Existing", RawParameters: json.RawMessage(buf.Bytes())}
 				asyncAllowed = false
-				fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, errors.New("not found"))
+				fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound)
 			})
 
 			JustBeforeEach(func() {
@@ -103,6 +123,24 @@ var _ = Describe("Broker", func() {
 				Expect(fakeStore.SaveCallCount()).Should(BeNumerically(">", 0))
 			})
 
+			It("should store the parsed share alongside the share string", func() {
+				_, _, details := fakeStore.CreateInstanceDetailsArgsForCall(0)
+				Expect(details.Share).To(Equal("server:/some-share"))
+				Expect(details.ShareServer).To(Equal("server"))
+				Expect(details.SharePath).To(Equal("/some-share"))
+			})
+
+			Context("when the request carries an originating identity", func() {
+				BeforeEach(func() {
+					ctx = nfsbroker.WithRequestIdentity(ctx, "admin", "cloudfoundry eyJ1c2VyX2lkIjoiNjgzZWE3NDgifQ==")
+				})
+
+				It("should log the originating user", func() {
+					Expect(logger.(*lagertest.TestLogger).LogMessages()).To(ContainElement("test-broker.provision.start"))
+					Expect(string(logger.(*lagertest.TestLogger).Buffer().Contents())).To(ContainSubstring(`"originatingUser":"683ea748"`))
+				})
+			})
+
 			Context("create-service was given invalid JSON", func() {
 				BeforeEach(func() {
 					badJson := []byte("{this is not json")
@@ -123,17 +161,228 @@ var _ = Describe("Broker", func() {
 				})
 
 				It("errors", func() {
-					Expect(err).To(Equal(errors.New("config requires a \"share\" key")))
+					Expect(err).To(MatchError("config requires a \"share\" key"))
+					Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusBadRequest))
+				})
+			})
+			Context("when the plan is not offered", func() {
+				BeforeEach(func() {
+					provisionDetails.PlanID = "Unknown"
+				})
+
+				It("errors without storing the instance", func() {
+					Expect(err).To(MatchError(ContainSubstring(`plan "Unknown" is not offered`)))
+					Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the plan limits its instances", func() {
+				BeforeEach(func() {
+					broker.SetPlans([]nfsbroker.Plan{{ID: "Existing", Name: "existing", MaxInstances: 2}})
+					fakeStore.ListInstanceDetailsReturns(map[string]nfsbroker.ServiceInstance{"instance-1": {PlanID: "Existing"}}, nil)
+				})
+
+				It("provisions while there is room", func() {
+					Expect(err).NotTo(HaveOccurred())
+					_, opts := fakeStore.ListInstanceDetailsArgsForCall(0)
+					Expect(opts.PlanID).To(Equal("Existing"))
+				})
+
+				Context("and the limit has been reached", func() {
+					BeforeEach(func() {
+						fakeStore.ListInstanceDetailsReturns(map[string]nfsbroker.ServiceInstance{
+							"instance-1": {PlanID: "Existing"},
+							"instance-2": {PlanID: "Existing"},
+						}, nil)
+					})
+
+					It("errors without storing the instance", func() {
+						Expect(err).To(MatchError(`plan "existing" is limited to 2 instances`))
+						Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+					})
+				})
+			})
+
+			Context("create-service was given a share that is not a string", func() {
+				BeforeEach(func() {
+					provisionDetails = brokerapi.ProvisionDetails{PlanID: "Existing", RawParameters: json.RawMessage(`{"share":42}`)}
+				})
+
+				It("errors", func() {
+					Expect(err).To(Equal(brokerapi.ErrRawParamsInvalid))
+				})
+			})
+
+			Context("when the share does not name a server", func() {
+				BeforeEach(func() {
+					configuration := map[string]interface{}{"share": "/export/vol1"}
+					buf := &bytes.Buffer{}
+					_ = json.NewEncoder(buf).Encode(configuration)
+					provisionDetails = brokerapi.ProvisionDetails{PlanID: "Existing", OrganizationGUID: "org-guid", RawParameters: json.RawMessage(buf.Bytes())}
+				})
+
+				Context("and the organization has a default server", func() {
+					BeforeEach(func() {
+						broker.SetDefaultShareServers(nfsbroker.NewDefaultShareServers(map[string]string{"org-guid": "filer.example.com"}, nil))
+					})
+
+					It("stores the share on the default server", func() {
+						Expect(err).NotTo(HaveOccurred())
+						_, _, details := fakeStore.CreateInstanceDetailsArgsForCall(0)
+						Expect(details.Share).To(Equal("filer.example.com/export/vol1"))
+					})
+				})
+
+				Context("and the organization has no default server", func() {
+					It("rejects the request", func() {
+						Expect(err).To(MatchError(ContainSubstring("does not name a server")))
+						Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+					})
+				})
+			})
+
+			Context("when there are provisioning steps", func() {
+				var stepErr error
+
+				BeforeEach(func() {
+					stepErr = nil
+					broker.SetProvisionSteps(func(ctx context.Context, id string, details nfsbroker.ServiceInstance) error {
+						return stepErr
+					})
+				})
+
+				Context("and the client does not accept incomplete operations", func() {
+					It("requires an asynchronous request", func() {
+						Expect(err).To(Equal(brokerapi.ErrAsyncRequired))
+						Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+					})
+				})
+
+				Context("and the client accepts incomplete operations", func() {
+					BeforeEach(func() {
+						asyncAllowed = true
+					})
+
+					It("provisions asynchronously", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(spec.IsAsync).To(BeTrue())
+						Expect(spec.OperationData).To(Equal(nfsbroker.ProvisionOperation))
+						Eventually(fakeStore.SaveOperationCallCount).Should(Equal(2))
+					})
+
+					It("records the operation's progress", func() {
+						Eventually(fakeStore.SaveOperationCallCount).Should(Equal(2))
+						_, id, operation := fakeStore.SaveOperationArgsForCall(0)
+						Expect(id).To(Equal(instanceID))
+						Expect(operation.State).To(Equal(brokerapi.InProgress))
+						_, _, operation = fakeStore.SaveOperationArgsForCall(1)
+						Expect(operation.State).To(Equal(brokerapi.Succeeded))
+					})
+
+					Context("when a step fails", func() {
+						BeforeEach(func() {
+							stepErr = errors.New("export does not exist")
+						})
+
+						It("records the failure", func() {
+							Eventually(fakeStore.SaveOperationCallCount).Should(Equal(2))
+							_, _, operation := fakeStore.SaveOperationArgsForCall(1)
+							Expect(operation).To(Equal(nfsbroker.Operation{Type: nfsbroker.ProvisionOperation, State: brokerapi.Failed, Description: "export does not exist"}))
+						})
+					})
+				})
+			})
+
+			Context("when the share is not valid for the share type", func() {
+				BeforeEach(func() {
+					configuration := map[string]interface{}{"share": "server-without-a-path"}
+					buf := &bytes.Buffer{}
+					_ = json.NewEncoder(buf).Encode(configuration)
+					provisionDetails = brokerapi.ProvisionDetails{PlanID: "Existing", RawParameters: json.RawMessage(buf.Bytes())}
+				})
+
+				It("rejects the request", func() {
+					Expect(err).To(MatchError(ContainSubstring("has no export path")))
+					Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
 				})
 			})
 
 			Context("when the service instance already exists with the same details", func() {
 				BeforeEach(func() {
 					fakeStore.IsInstanceConflictReturns(false)
+					fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{PlanID: "Existing", Share: "server:/some-share"}, nil)
 				})
 
 				It("should not error", func() {
 					Expect(err).NotTo(HaveOccurred())
+					Expect(spec.IsAsync).To(BeFalse())
+				})
+
+				It("does not store the instance again", func() {
+					Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+				})
+
+				Context("and it is still being provisioned asynchronously", func() {
+					BeforeEach(func() {
+						fakeStore.RetrieveOperationReturns(nfsbroker.Operation{Type: nfsbroker.ProvisionOperation, State: brokerapi.InProgress}, nil)
+						broker.SetProvisionSteps(func(ctx context.Context, id string, details nfsbroker.ServiceInstance) error {
+							return nil
+						})
+					})
+
+					Context("and the client accepts incomplete operations", func() {
+						BeforeEach(func() {
+							asyncAllowed = true
+						})
+
+						It("reports the operation in progress without rerunning the steps", func() {
+							Expect(err).NotTo(HaveOccurred())
+							Expect(spec).To(Equal(brokerapi.ProvisionedServiceSpec{IsAsync: true, OperationData: nfsbroker.ProvisionOperation}))
+							Consistently(fakeStore.SaveOperationCallCount).Should(Equal(0))
+						})
+					})
+
+					Context("and the client does not accept incomplete operations", func() {
+						It("requires an asynchronous request", func() {
+							Expect(err).To(Equal(brokerapi.ErrAsyncRequired))
+						})
+					})
+				})
+
+				Context("and it was provisioned synchronously before provisioning steps were configured", func() {
+					BeforeEach(func() {
+						broker.SetProvisionSteps(func(ctx context.Context, id string, details nfsbroker.ServiceInstance) error {
+							return nil
+						})
+					})
+
+					It("still reports the instance as provisioned", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(spec.IsAsync).To(BeFalse())
+					})
+				})
+
+				Context("and it was provisioned asynchronously", func() {
+					BeforeEach(func() {
+						fakeStore.RetrieveOperationReturns(nfsbroker.Operation{Type: nfsbroker.ProvisionOperation, State: brokerapi.Succeeded}, nil)
+					})
+
+					It("reports the instance as provisioned", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(spec.IsAsync).To(BeFalse())
+					})
+				})
+
+				Context("and its asynchronous provision failed", func() {
+					BeforeEach(func() {
+						asyncAllowed = true
+						fakeStore.RetrieveOperationReturns(nfsbroker.Operation{Type: nfsbroker.ProvisionOperation, State: brokerapi.Failed}, nil)
+					})
+
+					It("reports the operation so that the platform sees the failure", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(spec.IsAsync).To(BeTrue())
+					})
 				})
 			})
 
@@ -175,7 +424,8 @@ var _ = Describe("Broker", func() {
 				asyncAllowed     bool
 				provisionDetails brokerapi.ProvisionDetails
 
-				err error
+				spec brokerapi.DeprovisionServiceSpec
+				err  error
 			)
 
 			BeforeEach(func() {
@@ -186,13 +436,13 @@ var _ = Describe("Broker", func() {
 			})
 
 			JustBeforeEach(func() {
-				_, err = broker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{}, asyncAllowed)
+				spec, err = broker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{}, asyncAllowed)
 			})
 
 			Context("when the instance does not exist", func() {
 				BeforeEach(func() {
 					instanceID = "does-not-exist"
-					fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, brokerapi.ErrInstanceDoesNotExist)
+					fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound)
 				})
 
 				It("should fail", func() {
@@ -234,6 +484,98 @@ var _ = Describe("Broker", func() {
 						Expect(err).To(HaveOccurred())
 					})
 				})
+
+				Context("when there are deprovision steps", func() {
+					var stepInstanceIDs []string
+
+					BeforeEach(func() {
+						stepInstanceIDs = nil
+						broker.SetDeprovisionSteps(func(ctx context.Context, id string, details nfsbroker.ServiceInstance) error {
+							stepInstanceIDs = append(stepInstanceIDs, id)
+							return nil
+						})
+					})
+
+					It("runs them before deleting the instance", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(stepInstanceIDs).To(Equal([]string{"some-instance-id"}))
+						Expect(fakeStore.DeleteInstanceDetailsCallCount()).To(Equal(1))
+					})
+
+					Context("when a step fails", func() {
+						BeforeEach(func() {
+							broker.SetDeprovisionSteps(func(ctx context.Context, id string, details nfsbroker.ServiceInstance) error {
+								return errors.New("filer is unreachable")
+							})
+						})
+
+						It("keeps the instance", func() {
+							Expect(err).To(MatchError("filer is unreachable"))
+							Expect(fakeStore.DeleteInstanceDetailsCallCount()).To(Equal(0))
+						})
+					})
+
+					Context("and the client accepts incomplete operations", func() {
+						BeforeEach(func() {
+							asyncAllowed = true
+						})
+
+						It("deprovisions asynchronously", func() {
+							Expect(err).NotTo(HaveOccurred())
+							Expect(spec.IsAsync).To(BeTrue())
+							Expect(spec.OperationData).To(Equal(nfsbroker.DeprovisionOperation))
+							Eventually(fakeStore.DeleteInstanceDetailsCallCount).Should(Equal(1))
+						})
+
+						It("records the operation's progress", func() {
+							Eventually(fakeStore.SaveOperationCallCount).Should(Equal(2))
+							_, id, operation := fakeStore.SaveOperationArgsForCall(0)
+							Expect(id).To(Equal(instanceID))
+							Expect(operation).To(Equal(nfsbroker.Operation{Type: nfsbroker.DeprovisionOperation, State: brokerapi.InProgress}))
+							_, _, operation = fakeStore.SaveOperationArgsForCall(1)
+							Expect(operation.State).To(Equal(brokerapi.Succeeded))
+						})
+
+						Context("when a step fails", func() {
+							BeforeEach(func() {
+								broker.SetDeprovisionSteps(func(ctx context.Context, id string, details nfsbroker.ServiceInstance) error {
+									return errors.New("filer is unreachable")
+								})
+							})
+
+							It("records the failure and keeps the instance", func() {
+								Eventually(fakeStore.SaveOperationCallCount).Should(Equal(2))
+								_, _, operation := fakeStore.SaveOperationArgsForCall(1)
+								Expect(operation).To(Equal(nfsbroker.Operation{Type: nfsbroker.DeprovisionOperation, State: brokerapi.Failed, Description: "filer is unreachable"}))
+								Expect(fakeStore.DeleteInstanceDetailsCallCount()).To(Equal(0))
+							})
+						})
+					})
+
+					Context("when a deprovision is already in progress", func() {
+						BeforeEach(func() {
+							asyncAllowed = true
+							fakeStore.RetrieveOperationReturns(nfsbroker.Operation{Type: nfsbroker.DeprovisionOperation, State: brokerapi.InProgress}, nil)
+						})
+
+						It("does not run the steps again", func() {
+							Expect(err).NotTo(HaveOccurred())
+							Expect(spec.IsAsync).To(BeTrue())
+							Consistently(func() []string { return stepInstanceIDs }).Should(BeEmpty())
+							Expect(fakeStore.SaveOperationCallCount()).To(Equal(0))
+						})
+
+						Context("and the client does not accept incomplete operations", func() {
+							BeforeEach(func() {
+								asyncAllowed = false
+							})
+
+							It("requires an asynchronous request", func() {
+								Expect(err).To(Equal(brokerapi.ErrAsyncRequired))
+							})
+						})
+					})
+				})
 			})
 
 			Context("when the save fails", func() {
@@ -247,10 +589,181 @@ var _ = Describe("Broker", func() {
 			})
 		})
 
+		Context(".Update", func() {
+			var (
+				instanceID    string
+				updateDetails brokerapi.UpdateDetails
+				spec          brokerapi.UpdateServiceSpec
+				err           error
+			)
+
+			BeforeEach(func() {
+				instanceID = "some-instance-id"
+				updateDetails = brokerapi.UpdateDetails{
+					ServiceID:     "service-id",
+					PlanID:        "Existing",
+					RawParameters: json.RawMessage(`{"share":"server:/other-share"}`),
+				}
+				fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{
+					ServiceID:        "service-id",
+					PlanID:           "Existing",
+					OrganizationGUID: "org-guid",
+					Share:            "server:/some-share",
+				}, nil)
+			})
+
+			JustBeforeEach(func() {
+				spec, err = broker.Update(ctx, instanceID, updateDetails, false)
+			})
+
+			It("stores the new share", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spec.IsAsync).To(BeFalse())
+
+				Expect(fakeStore.UpdateInstanceDetailsCallCount()).To(Equal(1))
+				_, id, details := fakeStore.UpdateInstanceDetailsArgsForCall(0)
+				Expect(id).To(Equal(instanceID))
+				Expect(details.Share).To(Equal("server:/other-share"))
+				Expect(details.ShareServer).To(Equal("server"))
+				Expect(details.SharePath).To(Equal("/other-share"))
+				Expect(details.OrganizationGUID).To(Equal("org-guid"))
+				Expect(fakeStore.SaveCallCount()).To(Equal(1))
+			})
+
+			Context("when only the plan changes", func() {
+				BeforeEach(func() {
+					broker.SetPlans(append(nfsbroker.DefaultPlans, nfsbroker.Plan{ID: "Other", Name: "other"}))
+					updateDetails.PlanID = "Other"
+					updateDetails.RawParameters = nil
+				})
+
+				It("keeps the share", func() {
+					Expect(err).NotTo(HaveOccurred())
+					_, _, details := fakeStore.UpdateInstanceDetailsArgsForCall(0)
+					Expect(details.PlanID).To(Equal("Other"))
+					Expect(details.Share).To(Equal("server:/some-share"))
+				})
+			})
+
+			Context("when the new plan is not offered", func() {
+				BeforeEach(func() {
+					updateDetails.PlanID = "Unknown"
+				})
+
+				It("rejects the update", func() {
+					Expect(err).To(MatchError(ContainSubstring(`plan "Unknown" is not offered`)))
+					Expect(fakeStore.UpdateInstanceDetailsCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the instance does not exist", func() {
+				BeforeEach(func() {
+					fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound)
+				})
+
+				It("should fail", func() {
+					Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+					Expect(fakeStore.UpdateInstanceDetailsCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the parameters are not valid JSON", func() {
+				BeforeEach(func() {
+					updateDetails.RawParameters = json.RawMessage(`{"share":`)
+				})
+
+				It("should fail", func() {
+					Expect(err).To(Equal(brokerapi.ErrRawParamsInvalid))
+				})
+			})
+
+			Context("when the instance belongs to another service", func() {
+				BeforeEach(func() {
+					updateDetails.ServiceID = "other-service-id"
+				})
+
+				It("rejects the update", func() {
+					Expect(err).To(MatchError(ContainSubstring("belongs to service service-id")))
+					Expect(fakeStore.UpdateInstanceDetailsCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the instance's plan has changed since the update was requested", func() {
+				BeforeEach(func() {
+					updateDetails.PreviousValues = brokerapi.PreviousValues{PlanID: "Other"}
+				})
+
+				It("reports a conflict", func() {
+					Expect(err).To(MatchError(ContainSubstring("has changed since the update was requested")))
+					Expect(fakeStore.UpdateInstanceDetailsCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the share is not valid for the share type", func() {
+				BeforeEach(func() {
+					updateDetails.RawParameters = json.RawMessage(`{"share":"server-without-a-path"}`)
+				})
+
+				It("rejects the update", func() {
+					Expect(err).To(MatchError(ContainSubstring("has no export path")))
+					Expect(fakeStore.UpdateInstanceDetailsCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the update cannot be stored", func() {
+				BeforeEach(func() {
+					fakeStore.UpdateInstanceDetailsReturns(errors.New("badness"))
+				})
+
+				It("should error", func() {
+					Expect(err).To(MatchError(ContainSubstring("badness")))
+				})
+			})
+		})
+
 		Context(".LastOperation", func() {
-			It("errors", func() {
+			It("errors when the instance does not exist", func() {
+				fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound)
 				_, err := broker.LastOperation(ctx, "non-existant", "provision")
-				Expect(err).To(HaveOccurred())
+				Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+			})
+
+			It("reports the recorded operation", func() {
+				fakeStore.RetrieveOperationReturns(nfsbroker.Operation{Type: "provision", State: brokerapi.Failed, Description: "no export"}, nil)
+				op, err := broker.LastOperation(ctx, "some-instance-id", "provision")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(op).To(Equal(brokerapi.LastOperation{State: brokerapi.Failed, Description: "no export"}))
+			})
+
+			It("reports instances provisioned synchronously as succeeded", func() {
+				op, err := broker.LastOperation(ctx, "some-instance-id", "provision")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(op.State).To(Equal(brokerapi.Succeeded))
+			})
+
+			It("errors on unknown operations", func() {
+				_, err := broker.LastOperation(ctx, "some-instance-id", "resize")
+				Expect(err).To(MatchError("unrecognized operationData"))
+			})
+
+			Context("for deprovisions", func() {
+				It("reports the recorded operation", func() {
+					fakeStore.RetrieveOperationReturns(nfsbroker.Operation{Type: "deprovision", State: brokerapi.InProgress}, nil)
+					op, err := broker.LastOperation(ctx, "some-instance-id", "deprovision")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(op.State).To(Equal(brokerapi.InProgress))
+				})
+
+				It("reports deleted instances as gone", func() {
+					fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound)
+					_, err := broker.LastOperation(ctx, "some-instance-id", "deprovision")
+					Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+				})
+
+				It("errors when no deprovision was started", func() {
+					_, err := broker.LastOperation(ctx, "some-instance-id", "deprovision")
+					Expect(err).To(MatchError(ContainSubstring("is not being deprovisioned")))
+				})
 			})
 		})
 
@@ -281,6 +794,77 @@ var _ = Describe("Broker", func() {
 				}
 			})
 
+			Context("when the instance's plan has mount option defaults", func() {
+				BeforeEach(func() {
+					broker.SetPlans([]nfsbroker.Plan{{
+						ID:           "high-uid",
+						Name:         "high-uid",
+						MountOptions: map[string]string{"uid": "60000", "nfs_uid": "70000"},
+						ReadOnly:     true,
+					}})
+					fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{PlanID: "high-uid", Share: "server:/some-share"}, nil)
+					delete(bindDetails.Parameters, "uid")
+				})
+
+				It("applies them to the mount config", func() {
+					binding, err := broker.Bind(ctx, instanceID, "binding-id", bindDetails)
+					Expect(err).NotTo(HaveOccurred())
+
+					mc := binding.VolumeMounts[0].Device.MountConfig
+					Expect(mc["uid"]).To(Equal("60000"))
+					Expect(mc["nfs_uid"]).To(Equal("70000"))
+					Expect(mc["readonly"]).To(Equal(true))
+				})
+
+				It("lets bind parameters override allowed options", func() {
+					bindDetails.Parameters["uid"] = "1000"
+					binding, err := broker.Bind(ctx, instanceID, "binding-id", bindDetails)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(binding.VolumeMounts[0].Device.MountConfig["uid"]).To(Equal("1000"))
+				})
+
+				It("keeps the plan's bindings read-only", func() {
+					bindDetails.Parameters["readonly"] = false
+					binding, err := broker.Bind(ctx, instanceID, "binding-id", bindDetails)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(binding.VolumeMounts[0].Device.MountConfig["readonly"]).To(Equal(true))
+				})
+			})
+
+			Context("when the instance's plan locks mount options", func() {
+				BeforeEach(func() {
+					broker.SetPlans([]nfsbroker.Plan{{
+						ID:                 "locked-down",
+						Name:               "locked-down",
+						LockedMountOptions: map[string]string{"uid": "60000", "nosuid": "true"},
+					}})
+					fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{PlanID: "locked-down", Share: "server:/some-share"}, nil)
+					delete(bindDetails.Parameters, "uid")
+				})
+
+				It("applies them to the mount config", func() {
+					binding, err := broker.Bind(ctx, instanceID, "binding-id", bindDetails)
+					Expect(err).NotTo(HaveOccurred())
+
+					mc := binding.VolumeMounts[0].Device.MountConfig
+					Expect(mc["uid"]).To(Equal("60000"))
+					Expect(mc["nosuid"]).To(Equal("true"))
+				})
+
+				It("refuses bind parameters that change them, even when allowed", func() {
+					bindDetails.Parameters["uid"] = "1000"
+					_, err := broker.Bind(ctx, instanceID, "binding-id", bindDetails)
+					Expect(err).To(MatchError(`plan "locked-down" does not let bindings change mount options: uid`))
+					Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(400))
+				})
+
+				It("accepts bind parameters that agree with them", func() {
+					bindDetails.Parameters["uid"] = 60000.0
+					_, err := broker.Bind(ctx, instanceID, "binding-id", bindDetails)
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+
 			It("passes `share` from create-service into `mountConfig.ip` on the bind response", func() {
 				binding, err := broker.Bind(ctx, instanceID, "binding-id", bindDetails)
 				Expect(err).NotTo(HaveOccurred())
@@ -324,14 +908,14 @@ var _ = Describe("Broker", func() {
 				Expect(binding.VolumeMounts[0].Mode).To(Equal("rw"))
 			})
 
-			//It("sets mode to `r` when readonly is true", func() {
-			//	bindDetails.Parameters["readonly"] = true
-			//	binding, err := broker.Bind(ctx, "some-instance-id", "binding-id", bindDetails)
-			//	Expect(err).NotTo(HaveOccurred())
-			//
-			//	Expect(binding.VolumeMounts[0].Mode).To(Equal("r"))
-			//	Expect(binding.VolumeMounts[0].Device.MountConfig["readonly"]).To(Equal(true))
-			//})
+			It("sets mode to `r` when readonly is true", func() {
+				bindDetails.Parameters["readonly"] = true
+				binding, err := broker.Bind(ctx, "some-instance-id", "binding-id", bindDetails)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(binding.VolumeMounts[0].Mode).To(Equal("r"))
+				Expect(binding.VolumeMounts[0].Device.MountConfig["readonly"]).To(Equal(true))
+			})
 
 			It("should write state", func() {
 				previousSaveCallCount := fakeStore.SaveCallCount()
@@ -346,6 +930,12 @@ var _ = Describe("Broker", func() {
 				Expect(err).To(Equal(brokerapi.ErrRawParamsInvalid))
 			})
 
+			It("errors if the mount path is not a string", func() {
+				bindDetails.Parameters["mount"] = 42.0
+				_, err := broker.Bind(ctx, "some-instance-id", "binding-id", bindDetails)
+				Expect(err).To(Equal(brokerapi.ErrRawParamsInvalid))
+			})
+
 			It("fills in the driver name", func() {
 				binding, err := broker.Bind(ctx, "some-instance-id", "binding-id", bindDetails)
 				Expect(err).NotTo(HaveOccurred())
@@ -353,6 +943,25 @@ var _ = Describe("Broker", func() {
 				Expect(binding.VolumeMounts[0].Driver).To(Equal("nfsv3driver"))
 			})
 
+			Context("when the broker offers another share type", func() {
+				BeforeEach(func() {
+					broker.SetShareType(nfsbroker.CephFSShareType)
+					fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{ServiceID: instanceID, Share: "mon1,mon2:/volumes/vol1"}, nil)
+				})
+
+				It("uses that type's driver and source scheme", func() {
+					binding, err := broker.Bind(ctx, "some-instance-id", "binding-id", bindDetails)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(binding.VolumeMounts[0].Driver).To(Equal("cephdriver"))
+					Expect(binding.VolumeMounts[0].Device.MountConfig["source"]).To(Equal("cephfs://mon1,mon2:/volumes/vol1"))
+				})
+
+				It("advertises the type in the catalog", func() {
+					Expect(broker.Services(ctx)[0].Tags).To(Equal([]string{"cephfs"}))
+				})
+			})
+
 			It("fills in the volume id", func() {
 				binding, err := broker.Bind(ctx, "some-instance-id", "binding-id", bindDetails)
 				Expect(err).NotTo(HaveOccurred())
@@ -435,11 +1044,18 @@ var _ = Describe("Broker", func() {
 			})
 
 			It("errors when the service instance does not exist", func() {
-				fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, errors.New("Awesome!"))
+				fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound)
 				_, err := broker.Bind(ctx, "nonexistent-instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "guid"})
 				Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
 			})
 
+			It("reports the service as unavailable when the store cannot be reached", func() {
+				fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, fmt.Errorf("%w: connection refused", nfsbroker.ErrStoreUnavailable))
+				_, err := broker.Bind(ctx, "some-instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "guid"})
+				Expect(err).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
+				Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusServiceUnavailable))
+			})
+
 			It("errors when the app guid is not provided", func() {
 				_, err := broker.Bind(ctx, "some-instance-id", "binding-id", brokerapi.BindDetails{})
 				Expect(err).To(Equal(brokerapi.ErrAppGuidNotProvided))
@@ -568,13 +1184,13 @@ var _ = Describe("Broker", func() {
 			})
 
 			It("fails when trying to unbind a instance that has not been provisioned", func() {
-				fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, errors.New("Shazaam!"))
+				fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound)
 				err := broker.Unbind(ctx, "some-other-instance-id", "binding-id", brokerapi.UnbindDetails{})
 				Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
 			})
 
 			It("fails when trying to unbind a binding that has not been bound", func() {
-				fakeStore.RetrieveBindingDetailsReturns(brokerapi.BindDetails{}, errors.New("Hooray!"))
+				fakeStore.RetrieveBindingDetailsReturns(brokerapi.BindDetails{}, nfsbroker.ErrBindingNotFound)
 				err := broker.Unbind(ctx, "some-instance-id", "some-other-binding-id", brokerapi.UnbindDetails{})
 				Expect(err).To(Equal(brokerapi.ErrBindingDoesNotExist))
 			})
@@ -0,0 +1,244 @@
+package nfsbroker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// Operation data returned for asynchronous operations.
+const (
+	ProvisionOperation   = "provision"
+	DeprovisionOperation = "deprovision"
+	UnbindOperation      = "unbind"
+)
+
+// Operation records the progress of an asynchronous operation on a service instance, or on a binding for unbinds.
+// Only asynchronous operations are recorded, so a provision operation also records how the instance was originally
+// provisioned.
+type Operation struct {
+	Type        string                       `json:"type"`
+	State       brokerapi.LastOperationState `json:"state"`
+	Description string                       `json:"description,omitempty"`
+}
+
+// ProvisionStep does work to make a newly stored service instance usable, such as validating or creating its share.
+type ProvisionStep func(ctx context.Context, instanceID string, details ServiceInstance) error
+
+// SetProvisionSteps configures steps that run after an instance is stored.  When there are steps, provisioning is
+// asynchronous and requires accepts_incomplete.
+func (b *Broker) SetProvisionSteps(steps ...ProvisionStep) {
+	b.provisionSteps = steps
+}
+
+// DeprovisionStep undoes the work of provision steps, such as removing an instance's share.
+type DeprovisionStep func(ctx context.Context, instanceID string, details ServiceInstance) error
+
+// SetDeprovisionSteps configures steps that run before an instance is deleted.  If a step fails the instance is kept,
+// so that deprovisioning can be retried.  When there are steps, deprovisioning is asynchronous if the platform accepts
+// incomplete operations, so that slow steps do not run into its request timeout.
+func (b *Broker) SetDeprovisionSteps(steps ...DeprovisionStep) {
+	b.deprovisionSteps = steps
+}
+
+// runDeprovisionSteps runs the deprovision steps of an instance and then deletes or archives its subdirectory,
+// reporting the progress of slow work to progress.
+func (b *Broker) runDeprovisionSteps(ctx context.Context, logger lager.Logger, instanceID string, details ServiceInstance, progress func(string)) error {
+	if isProbe(ctx) {
+		return nil
+	}
+	for i, step := range b.deprovisionSteps {
+		if err := step(ctx, instanceID, details); err != nil {
+			logger.Error("deprovision-step-failed", err, lager.Data{"step": i})
+			return err
+		}
+	}
+	return b.disposeSubdirectory(ctx, logger, instanceID, details, progress)
+}
+
+// runAsyncDeprovision runs the deprovision steps of an instance and deletes it if they succeed.  The outcome is
+// recorded as the instance's operation; once the instance is gone, polling reports it as such.
+func (b *Broker) runAsyncDeprovision(logger lager.Logger, instanceID string, details ServiceInstance, record *auditRecord) {
+	logger = logger.Session("run-async-deprovision")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	ctx := context.Background()
+	operation := Operation{Type: DeprovisionOperation, State: brokerapi.Succeeded}
+	stepErr := b.runDeprovisionSteps(ctx, logger, instanceID, details, func(description string) {
+		b.reportDeprovisionProgress(logger, instanceID, description)
+	})
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	defer func() {
+		if err := b.store.Save(logger); err != nil {
+			logger.Error("failed-to-save-state", err)
+		}
+	}()
+
+	if stepErr == nil {
+		stepErr = b.store.DeleteInstanceDetails(ctx, instanceID)
+	}
+	if stepErr != nil {
+		operation.State = brokerapi.Failed
+		operation.Description = stepErr.Error()
+	}
+	if err := b.store.SaveOperation(ctx, instanceID, operation); err != nil {
+		logger.Error("failed-to-save-operation", err)
+	}
+	b.auditOutcome(record, operation)
+}
+
+// reportDeprovisionProgress describes what an asynchronous deprovision is doing in its operation, so that platforms
+// polling it can show its progress.
+func (b *Broker) reportDeprovisionProgress(logger lager.Logger, instanceID, description string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ctx := context.Background()
+	operation := Operation{Type: DeprovisionOperation, State: brokerapi.InProgress, Description: description}
+	if err := b.store.SaveOperation(ctx, instanceID, operation); err != nil {
+		logger.Error("failed-to-save-operation", err)
+		return
+	}
+	if err := b.store.Save(logger); err != nil {
+		logger.Error("failed-to-save-state", err)
+	}
+}
+
+// inProgress reports whether the operation recorded under id is an unfinished operation of the given type.
+func (b *Broker) inProgress(ctx context.Context, id, operationType string) (bool, error) {
+	operation, err := b.store.RetrieveOperation(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	return operation.Type == operationType && operation.State == brokerapi.InProgress, nil
+}
+
+// checkConcurrency returns ErrOperationInProgress while an asynchronous operation of one of the given types is
+// unfinished for the instance or binding recorded under id.  Callers leave out the type of the operation they start,
+// since platforms retry requests to learn whether their operation is still running.
+func (b *Broker) checkConcurrency(ctx context.Context, id string, operationTypes ...string) error {
+	operation, err := b.store.RetrieveOperation(ctx, id)
+	if err != nil {
+		return err
+	}
+	if operation.State != brokerapi.InProgress {
+		return nil
+	}
+	for _, operationType := range operationTypes {
+		if operation.Type == operationType {
+			return fmt.Errorf("%w: %s of %s", ErrOperationInProgress, operationType, id)
+		}
+	}
+	return nil
+}
+
+// checkInstanceConcurrency is checkConcurrency for an instance's own operations, and for unbinds of its bindings.
+func (b *Broker) checkInstanceConcurrency(ctx context.Context, instanceID string, operationTypes ...string) error {
+	if err := b.checkConcurrency(ctx, instanceID, operationTypes...); err != nil {
+		return err
+	}
+	bindingInstances, err := b.store.ListBindingInstances(ctx)
+	if err != nil {
+		return err
+	}
+	for bindingID, owner := range bindingInstances {
+		if owner != instanceID {
+			continue
+		}
+		if err := b.checkConcurrency(ctx, bindingID, UnbindOperation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Broker) runProvisionSteps(logger lager.Logger, instanceID string, details ServiceInstance, record *auditRecord) {
+	logger = logger.Session("run-provision-steps")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	ctx := context.Background()
+	operation := Operation{Type: ProvisionOperation, State: brokerapi.Succeeded}
+	for i, step := range b.provisionSteps {
+		if err := step(ctx, instanceID, details); err != nil {
+			logger.Error("provision-step-failed", err, lager.Data{"step": i})
+			operation.State = brokerapi.Failed
+			operation.Description = err.Error()
+			break
+		}
+	}
+
+	b.auditOutcome(record, operation)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err := b.store.SaveOperation(ctx, instanceID, operation); err != nil {
+		logger.Error("failed-to-save-operation", err)
+		return
+	}
+	if err := b.store.Save(logger); err != nil {
+		logger.Error("failed-to-save-state", err)
+	}
+}
+
+// repeatedProvision answers a retried provision of an instance that already exists with the same details.  Retries
+// never rerun provisioning steps: while an asynchronous provision is unfinished, or if it failed, retries are answered
+// asynchronously so that the platform polls for its outcome; otherwise the instance is reported as provisioned.
+// Instances that are being deprovisioned are reported as busy.
+func (b *Broker) repeatedProvision(ctx context.Context, logger lager.Logger, instanceID string, existing ServiceInstance, asyncAllowed bool) (brokerapi.ProvisionedServiceSpec, error) {
+	operation, err := b.store.RetrieveOperation(ctx, instanceID)
+	if err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	logger.Info("service-instance-already-provisioned", lager.Data{"operation": operation})
+	if operation.Type == DeprovisionOperation && operation.State == brokerapi.InProgress {
+		return brokerapi.ProvisionedServiceSpec{}, fmt.Errorf("%w: %s of %s", ErrOperationInProgress, DeprovisionOperation, instanceID)
+	}
+
+	if operation.Type != ProvisionOperation || operation.State == brokerapi.Succeeded {
+		return brokerapi.ProvisionedServiceSpec{IsAsync: false, DashboardURL: existing.DashboardURL}, nil
+	}
+	if !asyncAllowed {
+		return brokerapi.ProvisionedServiceSpec{}, brokerapi.ErrAsyncRequired
+	}
+	return brokerapi.ProvisionedServiceSpec{IsAsync: true, OperationData: ProvisionOperation, DashboardURL: existing.DashboardURL}, nil
+}
+
+func (b *Broker) lastProvisionOperation(ctx context.Context, instanceID string) (brokerapi.LastOperation, error) {
+	operation, err := b.store.RetrieveOperation(ctx, instanceID)
+	if err != nil {
+		return brokerapi.LastOperation{}, err
+	}
+	if operation.Type == ProvisionOperation {
+		return brokerapi.LastOperation{State: operation.State, Description: operation.Description}, nil
+	}
+
+	// instances provisioned synchronously have no recorded operation
+	if _, err := b.store.RetrieveInstanceDetails(ctx, instanceID); err != nil {
+		return brokerapi.LastOperation{}, err
+	}
+	return brokerapi.LastOperation{State: brokerapi.Succeeded}, nil
+}
+
+// lastDeprovisionOperation reports the progress of an asynchronous deprovision.  Instances that have been deleted
+// are reported as missing, which platforms take to mean that the deprovision succeeded.
+func (b *Broker) lastDeprovisionOperation(ctx context.Context, instanceID string) (brokerapi.LastOperation, error) {
+	if _, err := b.store.RetrieveInstanceDetails(ctx, instanceID); err != nil {
+		return brokerapi.LastOperation{}, err
+	}
+	operation, err := b.store.RetrieveOperation(ctx, instanceID)
+	if err != nil {
+		return brokerapi.LastOperation{}, err
+	}
+	if operation.Type != DeprovisionOperation {
+		err := fmt.Errorf("service instance %s is not being deprovisioned", instanceID)
+		return brokerapi.LastOperation{}, brokerapi.NewFailureResponse(err, http.StatusBadRequest, "no-deprovision-operation")
+	}
+	return brokerapi.LastOperation{State: operation.State, Description: operation.Description}, nil
+}
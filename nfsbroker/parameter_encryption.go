@@ -0,0 +1,77 @@
+package nfsbroker
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// EncryptedParamsKey holds, in place of HashKey, the parameters of bindings stored with a ParameterEncryption.
+const EncryptedParamsKey = "encryptedParams"
+
+// ParameterEncryption encrypts binding parameters with AES-256-GCM when bindings are stored, in place of hashing them
+// with bcrypt, so that their secrets can be read back to serve fetch binding requests and to check for conflicts
+// while still never being stored in cleartext.  The first key encrypts parameters; the others only decrypt them, so
+// that keys can be rotated.  Bindings keep the key they were stored with until they are deleted.
+type ParameterEncryption struct {
+	keys []encryptionKey
+}
+
+// NewParameterEncryption returns an encryption with the given keys, which are passphrases of at least
+// MinEncryptionKeyLength characters.
+func NewParameterEncryption(keys []string) (*ParameterEncryption, error) {
+	encryptionKeys, err := newEncryptionKeys("binding parameter", keys)
+	if err != nil {
+		return nil, err
+	}
+	return &ParameterEncryption{keys: encryptionKeys}, nil
+}
+
+// encrypt returns the parameters of a binding encrypted as the ID of the key, a colon and the base64 of the nonce and
+// ciphertext.  The binding ID is authenticated with them, so that one binding's parameters cannot be given to another.
+func (e *ParameterEncryption) encrypt(bindingID string, parameters map[string]interface{}) (string, error) {
+	plaintext, err := json.Marshal(parameters)
+	if err != nil {
+		return "", err
+	}
+	key := e.keys[0]
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := key.aead.Seal(nonce, nonce, plaintext, []byte(bindingID))
+	return key.id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt returns the parameters encrypted for a binding.
+func (e *ParameterEncryption) decrypt(bindingID, encrypted string) (map[string]interface{}, error) {
+	parts := strings.SplitN(encrypted, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("the parameters of binding %s are not encrypted parameters", bindingID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("the encrypted parameters of binding %s are malformed: %w", bindingID, err)
+	}
+	for _, key := range e.keys {
+		if key.id != parts[0] {
+			continue
+		}
+		if len(sealed) < key.aead.NonceSize() {
+			return nil, fmt.Errorf("the encrypted parameters of binding %s are truncated", bindingID)
+		}
+		plaintext, err := key.aead.Open(nil, sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():], []byte(bindingID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt the parameters of binding %s with key %s: %w", bindingID, key.id, err)
+		}
+		var parameters map[string]interface{}
+		if err := json.Unmarshal(plaintext, &parameters); err != nil {
+			return nil, err
+		}
+		return parameters, nil
+	}
+	return nil, fmt.Errorf("the parameters of binding %s are encrypted with key %s, which is not one of the binding parameter keys", bindingID, parts[0])
+}
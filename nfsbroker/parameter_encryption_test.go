@@ -0,0 +1,145 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Binding parameter encryption", func() {
+	var (
+		logger   *lagertest.TestLogger
+		ctx      context.Context
+		dir      string
+		fileName string
+		store    nfsbroker.Store
+		details  brokerapi.BindDetails
+	)
+
+	newStore := func(keys ...string) nfsbroker.Store {
+		var encryption *nfsbroker.ParameterEncryption
+		if len(keys) > 0 {
+			var err error
+			encryption, err = nfsbroker.NewParameterEncryption(keys)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		s := nfsbroker.NewStore(logger, "", "", "", "", "", "", "", fileName, nfsbroker.DefaultMaxValueSize, 0, nil, encryption, 0)
+		Expect(s.Restore(logger)).To(Succeed())
+		return s
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-parameter-encryption")
+		ctx = context.TODO()
+		var err error
+		dir, err = ioutil.TempDir("", "parameter-encryption")
+		Expect(err).NotTo(HaveOccurred())
+		fileName = filepath.Join(dir, "state.json")
+		Expect(ioutil.WriteFile(fileName, []byte(`{}`), 0600)).To(Succeed())
+
+		store = newStore("first-key-0123456789")
+		details = brokerapi.BindDetails{
+			AppGUID:   "app-guid",
+			ServiceID: "service-id",
+			PlanID:    "plan-id",
+			Parameters: map[string]interface{}{
+				"username":                    "someone",
+				"password":                    "s3cret",
+				nfsbroker.EffectiveOptionsKey: map[string]interface{}{"uid": "1000"},
+			},
+		}
+		Expect(store.CreateBindingDetails(ctx, "instance-id", "binding-id", details)).To(Succeed())
+		Expect(store.Save(logger)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("stores the parameters encrypted rather than hashed", func() {
+		data, err := ioutil.ReadFile(fileName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).NotTo(ContainSubstring("s3cret"))
+		Expect(string(data)).To(ContainSubstring(nfsbroker.EncryptedParamsKey))
+		Expect(string(data)).NotTo(ContainSubstring(nfsbroker.HashKey))
+	})
+
+	It("returns stored bindings with their secrets", func() {
+		stored, err := newStore("first-key-0123456789").RetrieveBindingDetails(ctx, "binding-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored.Parameters).To(Equal(map[string]interface{}{
+			"username":                    "someone",
+			"password":                    "s3cret",
+			nfsbroker.EffectiveOptionsKey: map[string]interface{}{"uid": "1000"},
+		}))
+
+		bindings, err := store.ListBindingDetails(ctx, nfsbroker.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bindings["binding-id"].Parameters).To(HaveKeyWithValue("password", "s3cret"))
+	})
+
+	It("checks conflicts against the decrypted parameters", func() {
+		requested := details
+		requested.Parameters = map[string]interface{}{"username": "someone", "password": "s3cret"}
+		Expect(store.IsBindingConflict(ctx, "binding-id", requested)).To(BeFalse())
+
+		requested.Parameters = map[string]interface{}{"username": "someone", "password": "other"}
+		Expect(store.IsBindingConflict(ctx, "binding-id", requested)).To(BeTrue())
+	})
+
+	It("decrypts parameters encrypted with older keys", func() {
+		stored, err := newStore("second-key-0123456789", "first-key-0123456789").RetrieveBindingDetails(ctx, "binding-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored.Parameters).To(HaveKeyWithValue("password", "s3cret"))
+	})
+
+	It("fails to return bindings encrypted with other keys", func() {
+		_, err := newStore("other-key-0123456789").RetrieveBindingDetails(ctx, "binding-id")
+		Expect(err).To(MatchError(ContainSubstring("which is not one of the binding parameter keys")))
+	})
+
+	It("treats bindings as conflicting when their parameters cannot be decrypted", func() {
+		requested := details
+		requested.Parameters = map[string]interface{}{"username": "someone", "password": "s3cret"}
+		Expect(newStore().IsBindingConflict(ctx, "binding-id", requested)).To(BeTrue())
+	})
+
+	It("still reads and checks bindings stored with hashed parameters", func() {
+		hashing := newStore()
+		Expect(hashing.CreateBindingDetails(ctx, "instance-id", "hashed-binding-id", details)).To(Succeed())
+		Expect(hashing.Save(logger)).To(Succeed())
+
+		encrypting := newStore("first-key-0123456789")
+		stored, err := encrypting.RetrieveBindingDetails(ctx, "hashed-binding-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored.Parameters).To(HaveKey(nfsbroker.HashKey))
+		Expect(stored.Parameters).NotTo(HaveKey("password"))
+
+		requested := details
+		requested.Parameters = map[string]interface{}{"username": "someone", "password": "s3cret"}
+		Expect(encrypting.IsBindingConflict(ctx, "hashed-binding-id", requested)).To(BeFalse())
+	})
+
+	It("binds encrypted parameters to their binding", func() {
+		data, err := ioutil.ReadFile(fileName)
+		Expect(err).NotTo(HaveOccurred())
+		var state map[string]interface{}
+		Expect(json.Unmarshal(data, &state)).To(Succeed())
+		bindings := state["BindingMap"].(map[string]interface{})
+		bindings["other-binding-id"] = bindings["binding-id"]
+		data, err = json.Marshal(state)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(fileName, data, 0600)).To(Succeed())
+
+		_, err = newStore("first-key-0123456789").RetrieveBindingDetails(ctx, "other-binding-id")
+		Expect(err).To(MatchError(ContainSubstring("failed to decrypt the parameters of binding other-binding-id")))
+	})
+})
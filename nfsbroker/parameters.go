@@ -0,0 +1,311 @@
+package nfsbroker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+const ParametersPath = "/parameters"
+
+// parameterSpec describes a parameter the broker accepts.  Provision and bind requests are checked against the same
+// specs that the parameters documentation is generated from.
+type parameterSpec struct {
+	name         string
+	kind         string
+	description  string
+	required     bool
+	defaultValue interface{}
+}
+
+var provisionParameters = []parameterSpec{
+	{
+		name:        "share",
+		kind:        "string",
+		description: "The share to offer, without a server if the broker has a default share server for the organization",
+		required:    true,
+	},
+	{
+		name:        "shares",
+		kind:        "object",
+		description: "Further shares to offer, as an object of share names and shares; bindings choose which to mount with share_name, and updates remove shares set to null",
+	},
+	{
+		name:        "version",
+		kind:        "scalar",
+		description: "The NFS version to mount the share with: 3, 4 or 4.1, if the plan offers it; a version in the share's options must agree",
+	},
+	{
+		name:        "labels",
+		kind:        "object",
+		description: "Labels to record on the instance, such as a cost center, as an object of strings; updates remove labels set to null",
+	},
+}
+
+var bindParameters = []parameterSpec{
+	{
+		name:        "mount",
+		kind:        "string",
+		description: "The path in the app container to mount the share at",
+	},
+	{
+		name:        "share_name",
+		kind:        "string",
+		description: "The share to mount: \"default\" for the instance's share, the name of one of its further shares, or \"all\" to mount every share",
+	},
+	{
+		name:         "readonly",
+		kind:         "boolean",
+		description:  "Whether to mount the share read-only",
+		defaultValue: false,
+	},
+	{
+		name:        "version",
+		kind:        "scalar",
+		description: "The NFS version to mount the share with, in place of the instance's: 3, 4 or 4.1, if the plan offers it",
+	},
+	{
+		name:        Username,
+		kind:        "string",
+		description: "Accepted for compatibility and not passed to the driver",
+	},
+	{
+		name:        Secret,
+		kind:        "string",
+		description: "Accepted for compatibility and not passed to the driver; never stored",
+	},
+}
+
+// provisionParameters returns the specs of the provision parameters.  The share is optional when the broker fills in
+// a share template or creates subdirectories for instances without one.
+func (b *Broker) provisionParameters() []parameterSpec {
+	var otherwise string
+	switch {
+	case b.shareTemplate != nil:
+		otherwise = "; when none is given, " + b.shareTemplate.String() + " filled in for the instance"
+	case b.subdirectories != nil:
+		otherwise = "; when none is given, a new directory of " + b.subdirectories.Base + " named after the instance"
+	default:
+		return provisionParameters
+	}
+	specs := append([]parameterSpec{}, provisionParameters...)
+	for i, spec := range specs {
+		if spec.name == "share" {
+			specs[i].required = false
+			specs[i].description += otherwise
+		}
+	}
+	return specs
+}
+
+// bindParameterSpecs returns the specs of the bind parameters, including experimental when the broker allows mapfs
+// mounts and a username and password when it resolves them into IDs.
+func (b *Broker) bindParameterSpecs() []parameterSpec {
+	specs := bindParameters
+	if b.allowExperimental {
+		specs = append(append([]parameterSpec{}, specs...), parameterSpec{
+			name:        ExperimentalOption,
+			kind:        "boolean",
+			description: "Whether to mount the share with the kernel's NFS client and map file ownership to uid and gid with mapfs, rather than with fuse-nfs; needs uid and gid",
+		})
+	}
+	if b.idResolver == nil {
+		return specs
+	}
+	return append(append([]parameterSpec{}, specs...),
+		parameterSpec{
+			name:        IDResolverUsername,
+			kind:        "string",
+			description: "A user to mount the share as, in place of uid and gid, which are looked up when the app is bound",
+		},
+		parameterSpec{
+			name:        IDResolverPassword,
+			kind:        "string",
+			description: "The password of username; never stored",
+		},
+	)
+}
+
+func parameterNames(specs []parameterSpec) []string {
+	names := []string{}
+	for _, spec := range specs {
+		names = append(names, spec.name)
+	}
+	return names
+}
+
+// checkParameters rejects parameters that are missing or empty though required, or that have the wrong JSON type.
+func checkParameters(specs []parameterSpec, parameters map[string]interface{}) error {
+	for _, spec := range specs {
+		value, ok := parameters[spec.name]
+		if !ok || value == "" {
+			if spec.required {
+				err := fmt.Errorf("config requires a %q key", spec.name)
+				return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "missing-parameter")
+			}
+			continue
+		}
+
+		switch value.(type) {
+		case string:
+			ok = spec.kind == "string" || spec.kind == "scalar"
+		case float64:
+			ok = spec.kind == "scalar"
+		case bool:
+			ok = spec.kind == "boolean"
+		case map[string]interface{}:
+			ok = spec.kind == "object"
+		default:
+			ok = false
+		}
+		if !ok {
+			return brokerapi.ErrRawParamsInvalid
+		}
+	}
+	return nil
+}
+
+// ParameterDoc documents a provision or bind parameter.  Type is a JSON Schema type, or "scalar" for mount options,
+// which may be strings, numbers or booleans.
+type ParameterDoc struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Description string      `json:"description"`
+	Required    bool        `json:"required"`
+	Default     interface{} `json:"default,omitempty"`
+	Plans       []string    `json:"plans"`
+}
+
+// ParametersDoc documents every parameter accepted by provision and bind requests.
+type ParametersDoc struct {
+	Provision []ParameterDoc `json:"provision"`
+	Bind      []ParameterDoc `json:"bind"`
+}
+
+// Parameters documents the parameters the broker accepts, including the mount options operators have allowed.
+func (b *Broker) Parameters(ctx context.Context) ParametersDoc {
+	logger := b.logger.Session("parameters", identityData(ctx))
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	plans := []string{}
+	for _, service := range b.services(ctx, logger) {
+		for _, plan := range service.Plans {
+			plans = append(plans, plan.ID)
+		}
+	}
+	return b.parameterDocs(plans)
+}
+
+func (b *Broker) parameterDocs(plans []string) ParametersDoc {
+	doc := ParametersDoc{Provision: []ParameterDoc{}, Bind: []ParameterDoc{}}
+	for _, spec := range b.provisionParameters() {
+		doc.Provision = append(doc.Provision, spec.doc(plans))
+	}
+	documented := map[string]bool{}
+	for _, spec := range b.bindParameterSpecs() {
+		param := spec.doc(plans)
+		switch spec.name {
+		case "mount":
+			param.Description += ", by default " + path.Join(b.volumeMountDefaults.ContainerDir, "<instance_id>")
+		case "readonly":
+			param.Default = b.volumeMountDefaults.Mode == "r"
+		}
+		doc.Bind = append(doc.Bind, param)
+		documented[spec.name] = true
+	}
+	for _, option := range b.config.mount.Allowed {
+		if documented[option] {
+			continue
+		}
+		documented[option] = true
+
+		option := ParameterDoc{
+			Name:        option,
+			Type:        "scalar",
+			Description: fmt.Sprintf("Mount option for %s; a string, number or boolean", b.shareType.Driver),
+			Plans:       plans,
+		}
+		if value, ok := b.config.mount.Options[option.Name]; ok {
+			option.Default = value
+		}
+		doc.Bind = append(doc.Bind, option)
+	}
+	return doc
+}
+
+// parametersSchema is a JSON Schema for an object of the given parameters, as advertised in plan schemas.
+func parametersSchema(params []ParameterDoc) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, param := range params {
+		property := map[string]interface{}{
+			"type":        param.Type,
+			"description": param.Description,
+		}
+		if param.Type == "scalar" {
+			property["type"] = []string{"string", "number", "boolean"}
+		}
+		if param.Default != nil {
+			property["default"] = param.Default
+		}
+		properties[param.Name] = property
+		if param.Required {
+			required = append(required, param.Name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"$schema":    "http://json-schema.org/draft-04/schema#",
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// planSchemas describes the provision, update and bind parameters of every plan.  Updates take the provision
+// parameters, none of them required.
+func (b *Broker) planSchemas() *brokerapi.ServiceSchemas {
+	doc := b.parameterDocs(nil)
+	update := []ParameterDoc{}
+	for _, param := range doc.Provision {
+		param.Required = false
+		update = append(update, param)
+	}
+	return &brokerapi.ServiceSchemas{
+		Instance: brokerapi.ServiceInstanceSchema{
+			Create: brokerapi.Schema{Parameters: parametersSchema(doc.Provision)},
+			Update: brokerapi.Schema{Parameters: parametersSchema(update)},
+		},
+		Binding: brokerapi.ServiceBindingSchema{
+			Create: brokerapi.Schema{Parameters: parametersSchema(doc.Bind)},
+		},
+	}
+}
+
+func (spec parameterSpec) doc(plans []string) ParameterDoc {
+	return ParameterDoc{
+		Name:        spec.name,
+		Type:        spec.kind,
+		Description: spec.description,
+		Required:    spec.required,
+		Default:     spec.defaultValue,
+		Plans:       plans,
+	}
+}
+
+// NewParametersHandler serves the broker's parameters documentation.  It does no authentication of its own.
+func NewParametersHandler(broker *Broker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(w, r, "GET") {
+			return
+		}
+		writeJSON(w, http.StatusOK, broker.Parameters(r.Context()))
+	})
+}
@@ -0,0 +1,67 @@
+package nfsbroker_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParametersHandler", func() {
+	var (
+		handler  http.Handler
+		recorder *httptest.ResponseRecorder
+		method   string
+	)
+
+	BeforeEach(func() {
+		configDetails := nfsbroker.NewNfsBrokerConfigDetails()
+		Expect(configDetails.ReadConf("uid,gid", "uid:1000,nfs_uid:2000")).To(Succeed())
+		broker := nfsbroker.New(lagertest.NewTestLogger("test-parameters"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, &nfsbrokerfakes.FakeStore{}, nfsbroker.NewNfsBrokerConfig(configDetails))
+		handler = nfsbroker.NewParametersHandler(broker)
+		recorder = httptest.NewRecorder()
+		method = "GET"
+	})
+
+	JustBeforeEach(func() {
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, nfsbroker.ParametersPath, nil))
+	})
+
+	It("documents the provision and bind parameters, including the allowed mount options", func() {
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(MatchJSON(`{
+			"provision": [
+				{"name": "share", "type": "string", "description": "The share to offer, without a server if the broker has a default share server for the organization", "required": true, "plans": ["Existing"]},
+				{"name": "shares", "type": "object", "description": "Further shares to offer, as an object of share names and shares; bindings choose which to mount with share_name, and updates remove shares set to null", "required": false, "plans": ["Existing"]},
+				{"name": "version", "type": "scalar", "description": "The NFS version to mount the share with: 3, 4 or 4.1, if the plan offers it; a version in the share's options must agree", "required": false, "plans": ["Existing"]},
+				{"name": "labels", "type": "object", "description": "Labels to record on the instance, such as a cost center, as an object of strings; updates remove labels set to null", "required": false, "plans": ["Existing"]}
+			],
+			"bind": [
+				{"name": "mount", "type": "string", "description": "The path in the app container to mount the share at, by default /var/vcap/data/<instance_id>", "required": false, "plans": ["Existing"]},
+				{"name": "share_name", "type": "string", "description": "The share to mount: \"default\" for the instance's share, the name of one of its further shares, or \"all\" to mount every share", "required": false, "plans": ["Existing"]},
+				{"name": "readonly", "type": "boolean", "description": "Whether to mount the share read-only", "required": false, "default": false, "plans": ["Existing"]},
+				{"name": "version", "type": "scalar", "description": "The NFS version to mount the share with, in place of the instance's: 3, 4 or 4.1, if the plan offers it", "required": false, "plans": ["Existing"]},
+				{"name": "kerberosPrincipal", "type": "string", "description": "Accepted for compatibility and not passed to the driver", "required": false, "plans": ["Existing"]},
+				{"name": "kerberosKeytab", "type": "string", "description": "Accepted for compatibility and not passed to the driver; never stored", "required": false, "plans": ["Existing"]},
+				{"name": "uid", "type": "scalar", "description": "Mount option for nfsv3driver; a string, number or boolean", "required": false, "default": "1000", "plans": ["Existing"]},
+				{"name": "gid", "type": "scalar", "description": "Mount option for nfsv3driver; a string, number or boolean", "required": false, "plans": ["Existing"]}
+			]
+		}`))
+	})
+
+	Context("when the method is not GET", func() {
+		BeforeEach(func() {
+			method = "POST"
+		})
+
+		It("rejects the request", func() {
+			Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+})
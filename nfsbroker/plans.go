@@ -0,0 +1,270 @@
+package nfsbroker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// Plan is a service plan offered by the broker.  Plans differ in the mount options bindings get by default and in
+// how many instances can be provisioned.  Plans without an ID are given one derived from the service ID and the plan
+// name, so that it stays the same across restarts.
+type Plan struct {
+	ID          string                         `json:"id"`
+	Name        string                         `json:"name"`
+	Description string                         `json:"description"`
+	Free        *bool                          `json:"free,omitempty"`
+	Metadata    *brokerapi.ServicePlanMetadata `json:"metadata,omitempty"`
+
+	// MountOptions are mount option defaults for bindings of the plan's instances, on top of the broker's defaults.
+	// Options that bind parameters are not allowed to set are forced.
+	MountOptions MountOptions `json:"mount_options,omitempty"`
+
+	// LockedMountOptions are mount options every binding of the plan's instances gets, such as nosuid and noexec for
+	// a locked-down plan.  Bindings that ask for other values are refused.
+	LockedMountOptions MountOptions `json:"locked_mount_options,omitempty"`
+
+	// ReadOnly mounts every binding of the plan's instances read-only, whatever the readonly bind parameter says.
+	ReadOnly bool `json:"read_only,omitempty"`
+
+	// MaxInstances limits how many instances of the plan can be provisioned.  Zero means no limit.  Quotas can
+	// override it.
+	MaxInstances int `json:"max_instances,omitempty"`
+
+	// Credentials are text/template templates of the credentials that bind responses carry alongside their volume
+	// mounts, for legacy apps that read mount details from VCAP_SERVICES.  They are executed with a CredentialsData.
+	Credentials map[string]string `json:"credentials,omitempty"`
+
+	// DataOnDeprovision overrides what happens to the subdirectories of the plan's instances when they are
+	// deprovisioned: DataKeep, DataDelete or DataArchive.
+	DataOnDeprovision string `json:"data_on_deprovision,omitempty"`
+
+	// NFSVersions are the NFS versions instances and bindings of the plan can ask for.  Plans without any allow
+	// every supported version.
+	NFSVersions []string `json:"nfs_versions,omitempty"`
+
+	// serviceID is the service the plan belongs to.
+	serviceID string
+}
+
+// MountOptions maps mount option names to their values.  Values can be given as JSON strings, numbers or booleans.
+type MountOptions map[string]string
+
+func (o *MountOptions) UnmarshalJSON(data []byte) error {
+	var values map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return err
+	}
+
+	options := MountOptions{}
+	for name, value := range values {
+		switch value.(type) {
+		case string, json.Number, bool:
+			options[name] = fmt.Sprint(value)
+		default:
+			return fmt.Errorf("mount option %q must be a string, number or boolean", name)
+		}
+	}
+	*o = options
+	return nil
+}
+
+// DefaultPlans are offered unless the broker is configured with others.
+var DefaultPlans = []Plan{
+	{
+		ID:          "Existing",
+		Name:        "Existing",
+		Description: "A preexisting filesystem",
+	},
+}
+
+// ParsePlans reads a JSON array of plans.
+func ParsePlans(data []byte) ([]Plan, error) {
+	var plans []Plan
+	if err := json.Unmarshal(data, &plans); err != nil {
+		return nil, fmt.Errorf("invalid plans: %w", err)
+	}
+	if err := validatePlans(plans); err != nil {
+		return nil, fmt.Errorf("invalid plans: %w", err)
+	}
+	return plans, nil
+}
+
+func validatePlans(plans []Plan) error {
+	if len(plans) == 0 {
+		return fmt.Errorf("at least one plan is required")
+	}
+
+	ids := map[string]bool{}
+	names := map[string]bool{}
+	for _, plan := range plans {
+		if plan.Name == "" {
+			return fmt.Errorf("every plan needs a name")
+		}
+		if (plan.ID != "" && ids[plan.ID]) || names[plan.Name] {
+			return fmt.Errorf("plan %q is defined more than once", plan.Name)
+		}
+		if plan.MaxInstances < 0 {
+			return fmt.Errorf("plan %q has a negative max_instances", plan.Name)
+		}
+		if err := validateNFSVersions(plan.NFSVersions); err != nil {
+			return fmt.Errorf("plan %q: %w", plan.Name, err)
+		}
+		if err := validateDataOnDeprovision(plan.DataOnDeprovision); err != nil {
+			return fmt.Errorf("plan %q: %w", plan.Name, err)
+		}
+		if err := validateLockedMountOptions(plan); err != nil {
+			return fmt.Errorf("plan %q: %w", plan.Name, err)
+		}
+		if _, err := parseCredentialsTemplates(plan.Credentials); err != nil {
+			return fmt.Errorf("plan %q: %w", plan.Name, err)
+		}
+		ids[plan.ID] = true
+		names[plan.Name] = true
+	}
+	return nil
+}
+
+// SetPlans configures the plans the broker offers in place of DefaultPlans.
+func (b *Broker) SetPlans(plans []Plan) {
+	b.plans = stablePlans(plans, b.static.ServiceId)
+}
+
+// planIDNamespace is the UUID namespace of generated plan IDs.
+var planIDNamespace = [16]byte{0x6f, 0x1c, 0x2d, 0x8e, 0x54, 0x3b, 0x4a, 0x7e, 0x9b, 0x0d, 0x2e, 0x61, 0xc4, 0x35, 0xa9, 0x17}
+
+// stablePlans gives plans without an ID one derived from the service ID and the plan name, and sorts the plans by
+// name.  The cloud controller treats reordered or regenerated plans as catalog changes, so neither may depend on
+// the order plans were configured or discovered in.
+func stablePlans(plans []Plan, serviceID string) []Plan {
+	stable := make([]Plan, len(plans))
+	copy(stable, plans)
+	for i := range stable {
+		stable[i].serviceID = serviceID
+		if stable[i].ID == "" {
+			stable[i].ID = nameBasedUUID(planIDNamespace, serviceID+"/"+stable[i].Name)
+		}
+	}
+	sort.SliceStable(stable, func(i, j int) bool {
+		if stable[i].Name != stable[j].Name {
+			return stable[i].Name < stable[j].Name
+		}
+		return stable[i].ID < stable[j].ID
+	})
+	return stable
+}
+
+// nameBasedUUID returns the version 5 UUID of name in namespace, as described in RFC 4122.
+func nameBasedUUID(namespace [16]byte, name string) string {
+	hash := sha1.New()
+	hash.Write(namespace[:])
+	hash.Write([]byte(name))
+	sum := hash.Sum(nil)
+
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// plan looks up one of the broker's plans by ID.
+func (b *Broker) plan(id string) (Plan, bool) {
+	for _, plan := range b.plans {
+		if plan.ID == id {
+			return plan, true
+		}
+	}
+	return Plan{}, false
+}
+
+// checkPlan rejects plans the broker does not offer, retired plans, and plans that have no room for another instance.
+func (b *Broker) checkPlan(ctx context.Context, planID string) error {
+	if planID == ProbePlanID && isProbe(ctx) {
+		return nil
+	}
+	plan, ok := b.plan(planID)
+	if !ok {
+		err := fmt.Errorf("plan %q is not offered by this broker", planID)
+		return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "unknown-plan")
+	}
+	if err := b.checkPlanRetirement(ctx, plan); err != nil {
+		return err
+	}
+	limit := b.quotas.planLimit(plan)
+	if limit == 0 {
+		return nil
+	}
+
+	count := 0
+	err := ForEachInstance(ctx, b.store, ListOptions{PlanID: plan.ID}, 100, func(string, ServiceInstance) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if count >= limit {
+		err := fmt.Errorf("plan %q is limited to %d instances", plan.Name, limit)
+		return brokerapi.NewFailureResponse(err, http.StatusForbidden, "plan-quota-exceeded")
+	}
+	return nil
+}
+
+// bindMode returns the mode of a binding of the instance with the given bind parameters.
+func (b *Broker) bindMode(instanceDetails ServiceInstance, parameters map[string]interface{}) (string, error) {
+	mode, err := evaluateMode(parameters, b.volumeMountDefaults.Mode)
+	if err != nil {
+		return "", err
+	}
+	if plan, ok := b.plan(instanceDetails.PlanID); ok && plan.ReadOnly {
+		return "r", nil
+	}
+	return mode, nil
+}
+
+// validateLockedMountOptions checks that a plan does not both lock an option and give it a default, and leaves
+// read-only bindings to read_only, which also sets the volume mount's mode.
+func validateLockedMountOptions(plan Plan) error {
+	names := []string{}
+	for name := range plan.LockedMountOptions {
+		if name == "readonly" {
+			return fmt.Errorf("locked mount option readonly is set with read_only")
+		}
+		if _, ok := plan.MountOptions[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		return fmt.Errorf("mount options are both locked and defaulted: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// checkLockedMountOptions refuses bind parameters that set a mount option the instance's plan locks to another value.
+func (b *Broker) checkLockedMountOptions(instanceDetails ServiceInstance, parameters map[string]interface{}) error {
+	plan, ok := b.plan(instanceDetails.PlanID)
+	if !ok {
+		return nil
+	}
+	names := []string{}
+	for name, locked := range plan.LockedMountOptions {
+		if value, ok := parameters[name]; ok && b.config.mount.uniformKeyData(name, value) != locked {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		err := fmt.Errorf("plan %q does not let bindings change mount options: %s", plan.Name, strings.Join(names, ", "))
+		return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "locked-mount-options")
+	}
+	return nil
+}
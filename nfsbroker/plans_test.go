@@ -0,0 +1,133 @@
+package nfsbroker_test
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParsePlans", func() {
+	It("reads the plans", func() {
+		plans, err := nfsbroker.ParsePlans([]byte(`[
+			{"id": "general-id", "name": "general", "description": "General purpose"},
+			{"id": "high-uid-id", "name": "high-uid", "mount_options": {"uid": 60000, "allow_other": true}, "max_instances": 10},
+			{"id": "read-only-id", "name": "read-only", "read_only": true}
+		]`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plans).To(Equal([]nfsbroker.Plan{
+			{ID: "general-id", Name: "general", Description: "General purpose"},
+			{ID: "high-uid-id", Name: "high-uid", MountOptions: map[string]string{"uid": "60000", "allow_other": "true"}, MaxInstances: 10},
+			{ID: "read-only-id", Name: "read-only", ReadOnly: true},
+		}))
+	})
+
+	It("requires at least one plan", func() {
+		_, err := nfsbroker.ParsePlans([]byte(`[]`))
+		Expect(err).To(MatchError(ContainSubstring("at least one plan")))
+	})
+
+	It("requires names", func() {
+		_, err := nfsbroker.ParsePlans([]byte(`[{"id": "general-id"}]`))
+		Expect(err).To(MatchError(ContainSubstring("needs a name")))
+	})
+
+	It("leaves ids to be generated", func() {
+		plans, err := nfsbroker.ParsePlans([]byte(`[{"name": "general"}]`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plans[0].ID).To(BeEmpty())
+	})
+
+	It("rejects duplicate plans", func() {
+		_, err := nfsbroker.ParsePlans([]byte(`[{"id": "a", "name": "general"}, {"id": "b", "name": "general"}]`))
+		Expect(err).To(MatchError(ContainSubstring(`plan "general" is defined more than once`)))
+	})
+
+	It("rejects mount options that are not scalars", func() {
+		_, err := nfsbroker.ParsePlans([]byte(`[{"id": "a", "name": "general", "mount_options": {"uid": [1000]}}]`))
+		Expect(err).To(MatchError(ContainSubstring(`mount option "uid" must be a string, number or boolean`)))
+	})
+
+	It("rejects credentials templates that do not parse or refer to unknown fields", func() {
+		_, err := nfsbroker.ParsePlans([]byte(`[{"id": "a", "name": "general", "credentials": {"uri": "{{.URI"}}]`))
+		Expect(err).To(MatchError(ContainSubstring(`plan "general": credentials template "uri"`)))
+
+		_, err = nfsbroker.ParsePlans([]byte(`[{"id": "a", "name": "general", "credentials": {"uri": "{{.Hostname}}"}}]`))
+		Expect(err).To(MatchError(ContainSubstring(`credentials template "uri"`)))
+	})
+
+	It("rejects unknown data_on_deprovision values", func() {
+		_, err := nfsbroker.ParsePlans([]byte(`[{"id": "a", "name": "general", "data_on_deprovision": "shred"}]`))
+		Expect(err).To(MatchError(ContainSubstring(`plan "general": data on deprovision must be`)))
+	})
+
+	It("reads locked mount options", func() {
+		plans, err := nfsbroker.ParsePlans([]byte(`[{"name": "locked-down", "locked_mount_options": {"nosuid": true, "noexec": true}}]`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plans[0].LockedMountOptions).To(Equal(nfsbroker.MountOptions{"nosuid": "true", "noexec": "true"}))
+	})
+
+	It("rejects options that are both locked and defaulted", func() {
+		_, err := nfsbroker.ParsePlans([]byte(`[{"name": "general", "mount_options": {"uid": 1000}, "locked_mount_options": {"uid": 2000}}]`))
+		Expect(err).To(MatchError(ContainSubstring(`plan "general": mount options are both locked and defaulted: uid`)))
+	})
+
+	It("leaves read-only bindings to read_only", func() {
+		_, err := nfsbroker.ParsePlans([]byte(`[{"name": "general", "locked_mount_options": {"readonly": true}}]`))
+		Expect(err).To(MatchError(ContainSubstring("set with read_only")))
+	})
+
+	It("rejects invalid JSON", func() {
+		_, err := nfsbroker.ParsePlans([]byte(`{`))
+		Expect(err).To(MatchError(ContainSubstring("invalid plans")))
+	})
+})
+
+var _ = Describe("Broker plans", func() {
+	newBroker := func(serviceID string) *nfsbroker.Broker {
+		return nfsbroker.New(lagertest.NewTestLogger("test-plans"), "service-name", serviceID, "/fake-dir",
+			&os_fake.FakeOs{}, nil, &nfsbrokerfakes.FakeStore{}, nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+	}
+
+	planIDs := func(broker *nfsbroker.Broker) []string {
+		ids := []string{}
+		for _, plan := range broker.Services(context.TODO())[0].Plans {
+			ids = append(ids, plan.ID)
+		}
+		return ids
+	}
+
+	It("offers plans in name order, whatever order they were configured in", func() {
+		broker := newBroker("service-id")
+		broker.SetPlans([]nfsbroker.Plan{
+			{ID: "c-id", Name: "read-only"},
+			{ID: "a-id", Name: "general"},
+			{ID: "b-id", Name: "high-uid"},
+		})
+		Expect(planIDs(broker)).To(Equal([]string{"a-id", "b-id", "c-id"}))
+	})
+
+	It("derives missing plan ids from the service id and plan name", func() {
+		broker := newBroker("service-id")
+		broker.SetPlans([]nfsbroker.Plan{{Name: "general"}, {ID: "explicit-id", Name: "read-only"}})
+		Expect(planIDs(broker)).To(Equal([]string{"0fc0c1c2-58c5-57e5-8db9-4790d4c4ed28", "explicit-id"}))
+
+		again := newBroker("service-id")
+		again.SetPlans([]nfsbroker.Plan{{ID: "explicit-id", Name: "read-only"}, {Name: "general"}})
+		Expect(planIDs(again)).To(Equal(planIDs(broker)))
+
+		other := newBroker("other-service-id")
+		other.SetPlans([]nfsbroker.Plan{{Name: "general"}})
+		Expect(planIDs(other)).To(Equal([]string{"772e4078-fe67-5f41-bbfe-ff88f2617936"}))
+	})
+
+	It("uses the catalog's service id for plans from a catalog file", func() {
+		broker := newBroker("service-id")
+		broker.SetCatalogService(nfsbroker.CatalogService{ID: "other-service-id", Name: "nfs", Plans: []nfsbroker.Plan{{Name: "general"}}})
+		Expect(planIDs(broker)).To(Equal([]string{"772e4078-fe67-5f41-bbfe-ff88f2617936"}))
+	})
+})
@@ -0,0 +1,154 @@
+package nfsbroker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// Quotas caps how many instances each organization and each space can have, how many the broker has in all, and how
+// many each plan can have.  Organizations and Spaces override the defaults for particular GUIDs, and Plans, keyed by
+// plan ID or name, override the max_instances of the plans themselves.  A limit of zero means no limit, and requests
+// that do not say which organization or space they are for are not limited by it.
+type Quotas struct {
+	DefaultOrganizationLimit int            `json:"default_organization_limit"`
+	DefaultSpaceLimit        int            `json:"default_space_limit"`
+	Organizations            map[string]int `json:"organizations,omitempty"`
+	Spaces                   map[string]int `json:"spaces,omitempty"`
+	MaxInstances             int            `json:"max_instances,omitempty"`
+	Plans                    map[string]int `json:"plans,omitempty"`
+}
+
+// ParseQuotas reads quotas from a JSON object.
+func ParseQuotas(data []byte) (Quotas, error) {
+	var quotas Quotas
+	if err := json.Unmarshal(data, &quotas); err != nil {
+		return Quotas{}, fmt.Errorf("invalid quotas: %w", err)
+	}
+	if quotas.DefaultOrganizationLimit < 0 || quotas.DefaultSpaceLimit < 0 || quotas.MaxInstances < 0 {
+		return Quotas{}, fmt.Errorf("invalid quotas: limits cannot be negative")
+	}
+	for guid, limit := range quotas.Organizations {
+		if limit < 0 {
+			return Quotas{}, fmt.Errorf("invalid quotas: organization %s has a negative limit", guid)
+		}
+	}
+	for guid, limit := range quotas.Spaces {
+		if limit < 0 {
+			return Quotas{}, fmt.Errorf("invalid quotas: space %s has a negative limit", guid)
+		}
+	}
+	for plan, limit := range quotas.Plans {
+		if limit < 0 {
+			return Quotas{}, fmt.Errorf("invalid quotas: plan %s has a negative limit", plan)
+		}
+	}
+	return quotas, nil
+}
+
+func (q Quotas) organizationLimit(guid string) int {
+	if guid == "" {
+		return 0
+	}
+	if limit, ok := q.Organizations[guid]; ok {
+		return limit
+	}
+	return q.DefaultOrganizationLimit
+}
+
+func (q Quotas) spaceLimit(guid string) int {
+	if guid == "" {
+		return 0
+	}
+	if limit, ok := q.Spaces[guid]; ok {
+		return limit
+	}
+	return q.DefaultSpaceLimit
+}
+
+// planLimit returns the limit of the plan: its entry in Plans by ID, then by name, falling back to its own.
+func (q *Quotas) planLimit(plan Plan) int {
+	if q != nil {
+		if limit, ok := q.Plans[plan.ID]; ok {
+			return limit
+		}
+		if limit, ok := q.Plans[plan.Name]; ok {
+			return limit
+		}
+	}
+	return plan.MaxInstances
+}
+
+// SetQuotas configures per-organization, per-space, per-plan and broker-wide instance limits, which are not enforced
+// by default.
+func (b *Broker) SetQuotas(quotas Quotas) {
+	b.quotas = &quotas
+}
+
+// checkQuotas rejects new instances when the broker, or their organization or space, has no room for another.
+func (b *Broker) checkQuotas(ctx context.Context, orgGUID, spaceGUID string) error {
+	if b.quotas == nil || isProbe(ctx) {
+		return nil
+	}
+	orgLimit := b.quotas.organizationLimit(orgGUID)
+	spaceLimit := b.quotas.spaceLimit(spaceGUID)
+	if orgLimit == 0 && spaceLimit == 0 && b.quotas.MaxInstances == 0 {
+		return nil
+	}
+
+	count, orgCount, spaceCount := 0, 0, 0
+	err := ForEachInstance(ctx, b.store, ListOptions{}, 100, func(_ string, details ServiceInstance) error {
+		count++
+		if details.OrganizationGUID == orgGUID {
+			orgCount++
+		}
+		if details.SpaceGUID == spaceGUID {
+			spaceCount++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if b.quotas.MaxInstances > 0 && count >= b.quotas.MaxInstances {
+		err := fmt.Errorf("the broker is limited to %d instances of this service", b.quotas.MaxInstances)
+		return brokerapi.NewFailureResponse(err, http.StatusForbidden, "service-quota-exceeded")
+	}
+	if orgLimit > 0 && orgCount >= orgLimit {
+		err := fmt.Errorf("organization %s is limited to %d instances of this service", orgGUID, orgLimit)
+		return brokerapi.NewFailureResponse(err, http.StatusForbidden, "organization-quota-exceeded")
+	}
+	if spaceLimit > 0 && spaceCount >= spaceLimit {
+		err := fmt.Errorf("space %s is limited to %d instances of this service", spaceGUID, spaceLimit)
+		return brokerapi.NewFailureResponse(err, http.StatusForbidden, "space-quota-exceeded")
+	}
+	return nil
+}
+
+// platformContext is the part of the OSB context object the broker uses.  Platforms send the organization and space
+// in the context; the top-level fields are deprecated but still honored when the context does not have them.
+type platformContext struct {
+	OrganizationGUID string `json:"organization_guid"`
+	SpaceGUID        string `json:"space_guid"`
+	OrganizationName string `json:"organization_name"`
+	SpaceName        string `json:"space_name"`
+}
+
+func provisionContext(details brokerapi.ProvisionDetails) platformContext {
+	var context platformContext
+	if len(details.RawContext) > 0 {
+		// a malformed context is no worse than a missing one
+		_ = json.Unmarshal(details.RawContext, &context)
+	}
+	if context.OrganizationGUID == "" {
+		context.OrganizationGUID = details.OrganizationGUID
+	}
+	if context.SpaceGUID == "" {
+		context.SpaceGUID = details.SpaceGUID
+	}
+	return context
+}
@@ -0,0 +1,174 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("ParseQuotas", func() {
+	It("reads default and per-GUID limits", func() {
+		quotas, err := nfsbroker.ParseQuotas([]byte(`{
+			"default_organization_limit": 20,
+			"default_space_limit": 5,
+			"organizations": {"big-org-guid": 100},
+			"spaces": {"big-space-guid": 0},
+			"max_instances": 500,
+			"plans": {"existing": 50}
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quotas).To(Equal(nfsbroker.Quotas{
+			DefaultOrganizationLimit: 20,
+			DefaultSpaceLimit:        5,
+			Organizations:            map[string]int{"big-org-guid": 100},
+			Spaces:                   map[string]int{"big-space-guid": 0},
+			MaxInstances:             500,
+			Plans:                    map[string]int{"existing": 50},
+		}))
+	})
+
+	It("rejects negative limits", func() {
+		_, err := nfsbroker.ParseQuotas([]byte(`{"organizations": {"org-guid": -1}}`))
+		Expect(err).To(MatchError(ContainSubstring("organization org-guid has a negative limit")))
+	})
+
+	It("rejects negative plan limits", func() {
+		_, err := nfsbroker.ParseQuotas([]byte(`{"plans": {"existing": -1}}`))
+		Expect(err).To(MatchError(ContainSubstring("plan existing has a negative limit")))
+	})
+
+	It("rejects invalid JSON", func() {
+		_, err := nfsbroker.ParseQuotas([]byte(`[`))
+		Expect(err).To(MatchError(ContainSubstring("invalid quotas")))
+	})
+})
+
+var _ = Describe("Broker quotas", func() {
+	var (
+		broker    *nfsbroker.Broker
+		fakeStore *nfsbrokerfakes.FakeStore
+		ctx       context.Context
+		details   brokerapi.ProvisionDetails
+	)
+
+	BeforeEach(func() {
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound)
+		fakeStore.ListInstanceDetailsReturns(map[string]nfsbroker.ServiceInstance{
+			"instance-1": {OrganizationGUID: "org-guid", SpaceGUID: "space-guid"},
+			"instance-2": {OrganizationGUID: "org-guid", SpaceGUID: "other-space-guid"},
+			"instance-3": {OrganizationGUID: "other-org-guid", SpaceGUID: "third-space-guid"},
+		}, nil)
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-quotas"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, fakeStore, nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		ctx = context.TODO()
+		details = brokerapi.ProvisionDetails{
+			PlanID:        "Existing",
+			RawContext:    json.RawMessage(`{"platform":"cloudfoundry","organization_guid":"org-guid","space_guid":"space-guid"}`),
+			RawParameters: json.RawMessage(`{"share":"filer:/export"}`),
+		}
+	})
+
+	provision := func() error {
+		_, err := broker.Provision(ctx, "instance-id", details, false)
+		return err
+	}
+
+	It("does not limit instances by default", func() {
+		Expect(provision()).To(Succeed())
+		Expect(fakeStore.ListInstanceDetailsCallCount()).To(Equal(0))
+	})
+
+	It("stores the organization and space from the context", func() {
+		Expect(provision()).To(Succeed())
+		_, _, stored := fakeStore.CreateInstanceDetailsArgsForCall(0)
+		Expect(stored.OrganizationGUID).To(Equal("org-guid"))
+		Expect(stored.SpaceGUID).To(Equal("space-guid"))
+	})
+
+	It("allows instances within the limits", func() {
+		broker.SetQuotas(nfsbroker.Quotas{DefaultOrganizationLimit: 3, DefaultSpaceLimit: 2})
+		Expect(provision()).To(Succeed())
+	})
+
+	It("rejects instances beyond the organization's limit", func() {
+		broker.SetQuotas(nfsbroker.Quotas{DefaultOrganizationLimit: 2})
+
+		err := provision()
+		Expect(err).To(MatchError("organization org-guid is limited to 2 instances of this service"))
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusForbidden))
+		Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+	})
+
+	It("rejects instances beyond the space's limit", func() {
+		broker.SetQuotas(nfsbroker.Quotas{DefaultSpaceLimit: 1})
+
+		err := provision()
+		Expect(err).To(MatchError("space space-guid is limited to 1 instances of this service"))
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusForbidden))
+	})
+
+	It("applies per-organization overrides", func() {
+		broker.SetQuotas(nfsbroker.Quotas{DefaultOrganizationLimit: 1, Organizations: map[string]int{"org-guid": 0}})
+		Expect(provision()).To(Succeed())
+	})
+
+	It("falls back to the deprecated top-level organization and space", func() {
+		details.RawContext = nil
+		details.OrganizationGUID = "org-guid"
+		details.SpaceGUID = "space-guid"
+		broker.SetQuotas(nfsbroker.Quotas{DefaultSpaceLimit: 1})
+
+		Expect(provision()).To(MatchError(ContainSubstring("space space-guid is limited")))
+	})
+
+	It("rejects instances beyond the broker's limit", func() {
+		broker.SetQuotas(nfsbroker.Quotas{MaxInstances: 3, DefaultOrganizationLimit: 10})
+
+		err := provision()
+		Expect(err).To(MatchError("the broker is limited to 3 instances of this service"))
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusForbidden))
+		Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+	})
+
+	Context("with plan limits", func() {
+		BeforeEach(func() {
+			fakeStore.ListInstanceDetailsReturns(map[string]nfsbroker.ServiceInstance{
+				"instance-1": {PlanID: "Existing"},
+				"instance-2": {PlanID: "Existing"},
+			}, nil)
+			broker.SetPlans([]nfsbroker.Plan{{ID: "Existing", Name: "existing", MaxInstances: 2}})
+		})
+
+		It("overrides the plan's own limit by name", func() {
+			broker.SetQuotas(nfsbroker.Quotas{Plans: map[string]int{"existing": 3}})
+			Expect(provision()).To(Succeed())
+		})
+
+		It("overrides the plan's own limit by ID", func() {
+			broker.SetQuotas(nfsbroker.Quotas{Plans: map[string]int{"Existing": 1, "existing": 3}})
+			Expect(provision()).To(MatchError(`plan "existing" is limited to 1 instances`))
+		})
+
+		It("keeps the plan's own limit for other plans", func() {
+			broker.SetQuotas(nfsbroker.Quotas{Plans: map[string]int{"other": 3}})
+			Expect(provision()).To(MatchError(`plan "existing" is limited to 2 instances`))
+		})
+	})
+
+	It("fails when instances cannot be counted", func() {
+		fakeStore.ListInstanceDetailsReturns(nil, errors.New("database is down"))
+		broker.SetQuotas(nfsbroker.Quotas{DefaultOrganizationLimit: 10})
+
+		Expect(provision()).To(MatchError(ContainSubstring("database is down")))
+	})
+})
@@ -0,0 +1,268 @@
+package nfsbroker
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+)
+
+// MaxAuthLockout caps how long repeated authentication failures lock a client out.
+const MaxAuthLockout = time.Hour
+
+// rateLimitIdleTimeout is how long a client or credential goes without requests before the limiter forgets it.
+const rateLimitIdleTimeout = 10 * time.Minute
+
+// RateLimits are the limits a RateLimiter enforces.  Zero values disable a limit.
+type RateLimits struct {
+	// ClientRate is how many requests per second each client IP address may make on average, and ClientBurst how
+	// many it may make at once.
+	ClientRate  float64
+	ClientBurst int
+
+	// CredentialRate is how many requests per second may be made with each basic auth username on average, with
+	// bursts of ClientBurst, so that clients spread over many addresses are limited too.  Only requests that
+	// authenticate with the username are counted, so that others cannot use up a client's limit by sending its
+	// username with the wrong password.
+	CredentialRate float64
+
+	// AuthFailures is how many failed authentications in a row lock a client IP address out.  The first lockout
+	// lasts AuthLockout, and each one after it twice as long as the last, up to MaxAuthLockout, until the client
+	// authenticates.
+	AuthFailures int
+	AuthLockout  time.Duration
+}
+
+// RateLimiter refuses requests over its limits, and requests from clients that keep failing to authenticate, with
+// 429 Too Many Requests.  Clients are told apart by the address their connection comes from.
+type RateLimiter struct {
+	limits RateLimits
+	clock  clock.Clock
+	logger lager.Logger
+
+	mutex       sync.Mutex
+	clients     map[string]*rateLimitClient
+	credentials map[string]*tokenBucket
+	swept       time.Time
+}
+
+type rateLimitClient struct {
+	bucket      tokenBucket
+	failures    int
+	lockouts    int
+	lockedUntil time.Time
+	seen        time.Time
+}
+
+// tokenBucket holds up to a burst of tokens, refilled at a rate per second, one of which each request takes.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter returns a limiter that enforces limits.  A burst below 1 is taken as 1.
+func NewRateLimiter(logger lager.Logger, clock clock.Clock, limits RateLimits) *RateLimiter {
+	if limits.ClientBurst < 1 {
+		limits.ClientBurst = 1
+	}
+	return &RateLimiter{
+		limits:      limits,
+		clock:       clock,
+		logger:      logger,
+		clients:     map[string]*rateLimitClient{},
+		credentials: map[string]*tokenBucket{},
+		swept:       clock.Now(),
+	}
+}
+
+// Wrap serves requests within the limits with handler.  Responses of 401 Unauthorized count as failed
+// authentications, and others as successful ones.  Requests are limited by client here, and by credential once
+// BasicAuth has authenticated them.
+func (l *RateLimiter) Wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientAddress(r)
+		if wait, reason := l.admitClient(client); wait > 0 {
+			l.refuse(w, wait, reason, lager.Data{"client": client})
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), rateLimiterKey{}, l)))
+		l.recordAuthentication(client, recorder.status != http.StatusUnauthorized)
+	})
+}
+
+type rateLimiterKey struct{}
+
+// limitCredential takes a token from the bucket of the username a request has authenticated with, if the request
+// came through a RateLimiter.  It answers the request with 429 Too Many Requests and returns false when the
+// credential is over its limit.
+func limitCredential(w http.ResponseWriter, r *http.Request, username string) bool {
+	l, ok := r.Context().Value(rateLimiterKey{}).(*RateLimiter)
+	if !ok {
+		return true
+	}
+	if wait, reason := l.admitCredential(username); wait > 0 {
+		l.refuse(w, wait, reason, lager.Data{"client": clientAddress(r), "username": username})
+		return false
+	}
+	return true
+}
+
+func (l *RateLimiter) refuse(w http.ResponseWriter, wait time.Duration, reason string, data lager.Data) {
+	data["reason"] = reason
+	l.logger.Info("request-limited", data)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]string{"description": reason})
+}
+
+// admitClient takes a token for the request from its client's bucket, and returns how long to wait, and why, when it
+// is refused.
+func (l *RateLimiter) admitClient(client string) (time.Duration, string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.clock.Now()
+	l.sweep(now)
+	state := l.client(client, now)
+	if now.Before(state.lockedUntil) {
+		return state.lockedUntil.Sub(now), "too many failed authentications; try again later"
+	}
+	if l.limits.ClientRate > 0 {
+		if wait := state.bucket.take(now, l.limits.ClientRate, l.limits.ClientBurst); wait > 0 {
+			return wait, "too many requests from this client; try again later"
+		}
+	}
+	return 0, ""
+}
+
+// admitCredential takes a token for an authenticated request from its credential's bucket, like admitClient.
+func (l *RateLimiter) admitCredential(username string) (time.Duration, string) {
+	if l.limits.CredentialRate <= 0 {
+		return 0, ""
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.clock.Now()
+	bucket, ok := l.credentials[username]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(l.limits.ClientBurst), updated: now}
+		l.credentials[username] = bucket
+	}
+	if wait := bucket.take(now, l.limits.CredentialRate, l.limits.ClientBurst); wait > 0 {
+		return wait, "too many requests with these credentials; try again later"
+	}
+	return 0, ""
+}
+
+// recordAuthentication counts a client's failed authentications, locking it out when there are too many in a row.
+func (l *RateLimiter) recordAuthentication(client string, succeeded bool) {
+	if l.limits.AuthFailures < 1 {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.clock.Now()
+	state := l.client(client, now)
+	if succeeded {
+		state.failures = 0
+		state.lockouts = 0
+		return
+	}
+	state.failures++
+	if state.failures < l.limits.AuthFailures {
+		return
+	}
+
+	lockout := l.limits.AuthLockout
+	for i := 0; i < state.lockouts && lockout < MaxAuthLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > MaxAuthLockout {
+		lockout = MaxAuthLockout
+	}
+	state.failures = 0
+	state.lockouts++
+	state.lockedUntil = now.Add(lockout)
+	l.logger.Info("client-locked-out", lager.Data{"client": client, "lockout": lockout.String()})
+}
+
+func (l *RateLimiter) client(address string, now time.Time) *rateLimitClient {
+	state, ok := l.clients[address]
+	if !ok {
+		state = &rateLimitClient{bucket: tokenBucket{tokens: float64(l.limits.ClientBurst), updated: now}}
+		l.clients[address] = state
+	}
+	state.seen = now
+	return state
+}
+
+// sweep forgets clients and credentials that have been idle for a while, so that the limiter's memory does not grow
+// with every address it has seen.  Clients are kept for MaxAuthLockout after their last lockout ends, so that waiting
+// one out does not reset how long the next one lasts.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < rateLimitIdleTimeout {
+		return
+	}
+	l.swept = now
+	for address, state := range l.clients {
+		if now.Sub(state.seen) > rateLimitIdleTimeout && now.After(state.lockedUntil.Add(MaxAuthLockout)) {
+			delete(l.clients, address)
+		}
+	}
+	for username, bucket := range l.credentials {
+		if now.Sub(bucket.updated) > rateLimitIdleTimeout {
+			delete(l.credentials, username)
+		}
+	}
+}
+
+// take refills the bucket for the time since it was last used and takes a token, or returns how long it will be
+// until there is one.
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) time.Duration {
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// clientAddress returns the IP address a request's connection comes from.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusRecorder records the status of the response written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streamed responses through.
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,135 @@
+package nfsbroker_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RateLimiter", func() {
+	var (
+		fakeClock *fakeclock.FakeClock
+		limits    nfsbroker.RateLimits
+		handler   http.Handler
+	)
+
+	serve := func(client, username, password string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/v2/catalog", nil)
+		req.RemoteAddr = client + ":12345"
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Unix(1000, 0))
+		limits = nfsbroker.RateLimits{ClientBurst: 2}
+	})
+
+	JustBeforeEach(func() {
+		limiter := nfsbroker.NewRateLimiter(lagertest.NewTestLogger("test-rate-limiter"), fakeClock, limits)
+		credentials := func() []nfsbroker.BrokerCredential {
+			return []nfsbroker.BrokerCredential{{Username: "admin", Password: "password"}, {Username: "other", Password: "password"}}
+		}
+		handler = limiter.Wrap(nfsbroker.BasicAuth(lagertest.NewTestLogger("test-basic-auth"), credentials, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})))
+	})
+
+	Context("with a client rate", func() {
+		BeforeEach(func() {
+			limits.ClientRate = 1
+		})
+
+		It("refuses requests over the burst until tokens are refilled", func() {
+			Expect(serve("10.0.0.1", "admin", "password").Code).To(Equal(http.StatusOK))
+			Expect(serve("10.0.0.1", "admin", "password").Code).To(Equal(http.StatusOK))
+			refused := serve("10.0.0.1", "admin", "password")
+			Expect(refused.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(refused.Header().Get("Retry-After")).To(Equal("1"))
+			Expect(refused.Body.String()).To(ContainSubstring("too many requests from this client"))
+
+			Expect(serve("10.0.0.2", "admin", "password").Code).To(Equal(http.StatusOK))
+
+			fakeClock.Increment(time.Second)
+			Expect(serve("10.0.0.1", "admin", "password").Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("with a credential rate", func() {
+		BeforeEach(func() {
+			limits.CredentialRate = 1
+		})
+
+		It("limits requests with each username from any client", func() {
+			Expect(serve("10.0.0.1", "admin", "password").Code).To(Equal(http.StatusOK))
+			Expect(serve("10.0.0.2", "admin", "password").Code).To(Equal(http.StatusOK))
+			Expect(serve("10.0.0.3", "admin", "password").Code).To(Equal(http.StatusTooManyRequests))
+			Expect(serve("10.0.0.3", "other", "password").Code).To(Equal(http.StatusOK))
+		})
+
+		It("does not count requests that fail to authenticate against the username", func() {
+			for i := 0; i < 5; i++ {
+				Expect(serve("10.0.0.9", "admin", "wrong").Code).To(Equal(http.StatusUnauthorized))
+			}
+			Expect(serve("10.0.0.1", "admin", "password").Code).To(Equal(http.StatusOK))
+			Expect(serve("10.0.0.1", "admin", "password").Code).To(Equal(http.StatusOK))
+			refused := serve("10.0.0.1", "admin", "password")
+			Expect(refused.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(refused.Body.String()).To(ContainSubstring("too many requests with these credentials"))
+		})
+	})
+
+	Context("with an authentication failure limit", func() {
+		BeforeEach(func() {
+			limits.AuthFailures = 3
+			limits.AuthLockout = time.Minute
+		})
+
+		It("locks clients out after failures in a row, for twice as long each time", func() {
+			for i := 0; i < 3; i++ {
+				Expect(serve("10.0.0.1", "admin", "wrong").Code).To(Equal(http.StatusUnauthorized))
+			}
+			locked := serve("10.0.0.1", "admin", "password")
+			Expect(locked.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(locked.Header().Get("Retry-After")).To(Equal("60"))
+			Expect(serve("10.0.0.2", "admin", "password").Code).To(Equal(http.StatusOK))
+
+			fakeClock.Increment(time.Minute)
+			for i := 0; i < 3; i++ {
+				Expect(serve("10.0.0.1", "admin", "wrong").Code).To(Equal(http.StatusUnauthorized))
+			}
+			Expect(serve("10.0.0.1", "admin", "password").Header().Get("Retry-After")).To(Equal("120"))
+		})
+
+		It("forgets failures once the client authenticates", func() {
+			Expect(serve("10.0.0.1", "admin", "wrong").Code).To(Equal(http.StatusUnauthorized))
+			Expect(serve("10.0.0.1", "admin", "wrong").Code).To(Equal(http.StatusUnauthorized))
+			Expect(serve("10.0.0.1", "admin", "password").Code).To(Equal(http.StatusOK))
+			Expect(serve("10.0.0.1", "admin", "wrong").Code).To(Equal(http.StatusUnauthorized))
+			Expect(serve("10.0.0.1", "admin", "password").Code).To(Equal(http.StatusOK))
+		})
+
+		It("locks clients out for no longer than the maximum", func() {
+			for lockout := 0; lockout < 8; lockout++ {
+				for i := 0; i < 3; i++ {
+					serve("10.0.0.1", "admin", "wrong")
+				}
+				fakeClock.Increment(nfsbroker.MaxAuthLockout)
+			}
+			for i := 0; i < 3; i++ {
+				serve("10.0.0.1", "admin", "wrong")
+			}
+			Expect(serve("10.0.0.1", "admin", "password").Header().Get("Retry-After")).To(Equal("3600"))
+		})
+	})
+})
@@ -0,0 +1,43 @@
+package nfsbroker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//go:generate counterfeiter -o ../nfsbrokerfakes/fake_share_checker.go . ShareChecker
+type ShareChecker interface {
+	CheckShare(ctx context.Context, server, path string) error
+}
+
+// SetShareChecker makes provision check that the servers of new instances answer and export their shares, so that
+// typos are reported to the user rather than found when apps first mount the share.
+func (b *Broker) SetShareChecker(checker ShareChecker) {
+	b.shareChecker = checker
+}
+
+// checkReachable checks each of the instance's shares with the share checker, if there is one.
+func (b *Broker) checkReachable(ctx context.Context, logger lager.Logger, details ServiceInstance) error {
+	if b.shareChecker == nil || isProbe(ctx) || b.shareTypeFor(details.ServiceID).Name != NFSShareType.Name {
+		return nil
+	}
+	for _, share := range details.eachShare() {
+		if share.details.ShareServer == "" {
+			continue
+		}
+		spanCtx, endSpan := startSpan(ctx, "check-share", attribute.String("nfsbroker.share", share.details.Share))
+		err := b.shareChecker.CheckShare(spanCtx, share.details.ShareServer, share.details.SharePath)
+		endSpan(err)
+		if err != nil {
+			logger.Info("share-unreachable", lager.Data{"share": share.details.Share, "error": err.Error()})
+			err = fmt.Errorf("share %s cannot be mounted: %w", share.details.Share, err)
+			return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "share-unreachable")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Share reachability", func() {
+	var (
+		broker  *nfsbroker.Broker
+		store   nfsbroker.Store
+		checker *nfsbrokerfakes.FakeShareChecker
+		ctx     context.Context
+	)
+
+	provision := func(parameters string) error {
+		_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+			ServiceID:     "service-id",
+			PlanID:        "Existing",
+			RawParameters: json.RawMessage(parameters),
+		}, false)
+		return err
+	}
+
+	BeforeEach(func() {
+		store = nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-reachability"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, store, nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		checker = &nfsbrokerfakes.FakeShareChecker{}
+		broker.SetShareChecker(checker)
+		ctx = context.Background()
+	})
+
+	It("checks each share's server and path", func() {
+		Expect(provision(`{"share":"server:/export","shares":{"logs":"other-server:/logs"}}`)).To(Succeed())
+
+		Expect(checker.CheckShareCallCount()).To(Equal(2))
+		_, server, path := checker.CheckShareArgsForCall(0)
+		Expect([]string{server, path}).To(Equal([]string{"server", "/export"}))
+		_, server, path = checker.CheckShareArgsForCall(1)
+		Expect([]string{server, path}).To(Equal([]string{"other-server", "/logs"}))
+	})
+
+	It("refuses shares that fail the check without storing the instance", func() {
+		checker.CheckShareReturns(errors.New("server serevr cannot be found: no such host"))
+
+		err := provision(`{"share":"serevr:/export"}`)
+		Expect(err).To(MatchError("share serevr:/export cannot be mounted: server serevr cannot be found: no such host"))
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusBadRequest))
+
+		_, err = store.RetrieveInstanceDetails(ctx, "instance-id")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("does not check shares of invalid requests", func() {
+		Expect(provision(`{"share":"server:/export/"}`)).NotTo(Succeed())
+		Expect(checker.CheckShareCallCount()).To(Equal(0))
+	})
+})
@@ -0,0 +1,74 @@
+package nfsbroker
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ReloadCatalogServices replaces the services of the broker's catalog file with those of the file as it has since
+// been changed, and describes what changed.  Descriptions, tags, metadata and plans can change, and plans can be
+// added, but every service must keep its ID, driver and share type, and every plan must be kept, so that existing
+// instances and bindings stay valid.  Broker requests wait while the catalog is replaced.
+func (b *Broker) ReloadCatalogServices(services []CatalogService) ([]string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.catalogServices) == 0 {
+		return nil, fmt.Errorf("the broker was not started with a catalog file")
+	}
+	if len(services) != len(b.catalogServices) {
+		return nil, fmt.Errorf("a reloaded catalog must offer the same %d services", len(b.catalogServices))
+	}
+
+	reloaded := map[string]CatalogService{}
+	for _, service := range services {
+		reloaded[service.ID] = service
+	}
+	var changes []string
+	for _, current := range b.catalogServices {
+		service, ok := reloaded[current.ID]
+		if !ok {
+			return nil, fmt.Errorf("service %q is missing from the reloaded catalog", current.ID)
+		}
+		if service.Driver != current.Driver || service.ShareType != current.ShareType {
+			return nil, fmt.Errorf("service %q cannot change its driver or share type without a restart", current.ID)
+		}
+
+		currentPlans := map[string]Plan{}
+		for _, plan := range stablePlans(current.Plans, current.ID) {
+			currentPlans[plan.ID] = plan
+		}
+		for _, plan := range stablePlans(service.Plans, service.ID) {
+			currentPlan, ok := currentPlans[plan.ID]
+			if !ok {
+				changes = append(changes, fmt.Sprintf("added plan %q to service %q", plan.Name, current.ID))
+			} else if !reflect.DeepEqual(plan, currentPlan) {
+				changes = append(changes, fmt.Sprintf("changed plan %q of service %q", plan.Name, current.ID))
+			}
+			delete(currentPlans, plan.ID)
+		}
+		for _, plan := range currentPlans {
+			return nil, fmt.Errorf("plan %q of service %q is missing from the reloaded catalog; retire it instead", plan.Name, current.ID)
+		}
+
+		service.Plans, current.Plans = nil, nil
+		if !reflect.DeepEqual(service, current) {
+			changes = append(changes, fmt.Sprintf("changed service %q", current.ID))
+		}
+	}
+
+	b.SetCatalogServices(services)
+	return changes, nil
+}
+
+// ReloadMountOptions replaces the mount options operators allow bindings to set and give defaults for, and reports
+// whether they changed.  Broker requests wait while the options are replaced.
+func (b *Broker) ReloadMountOptions(details *ConfigDetails) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	config := NewNfsBrokerConfig(details)
+	changed := !reflect.DeepEqual(config.mount, b.config.mount)
+	b.config = *config
+	return changed
+}
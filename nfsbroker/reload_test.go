@@ -0,0 +1,97 @@
+package nfsbroker_test
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Reloading settings", func() {
+	var broker *nfsbroker.Broker
+
+	newBroker := func(store nfsbroker.Store) *nfsbroker.Broker {
+		mounts := nfsbroker.NewNfsBrokerConfigDetails()
+		mounts.ReadConf("uid,gid", "")
+		return nfsbroker.New(lagertest.NewTestLogger("test-reload"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, store, nfsbroker.NewNfsBrokerConfig(mounts))
+	}
+
+	catalog := func(description string, plans ...nfsbroker.Plan) []nfsbroker.CatalogService {
+		return []nfsbroker.CatalogService{{ID: "nfs-id", Name: "nfs", Description: description, Driver: "nfsdriver", Plans: plans}}
+	}
+	general := nfsbroker.Plan{ID: "general-id", Name: "general", Description: "General purpose mounts"}
+
+	Describe("ReloadCatalogServices", func() {
+		BeforeEach(func() {
+			broker = newBroker(nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize))
+			broker.SetCatalogServices(catalog("NFS shares", general))
+		})
+
+		It("serves the reloaded catalog, and describes what changed", func() {
+			archive := nfsbroker.Plan{ID: "archive-id", Name: "archive", Description: "Archives"}
+			changes, err := broker.ReloadCatalogServices(catalog("Reloaded NFS shares", general, archive))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes).To(Equal([]string{`added plan "archive" to service "nfs-id"`, `changed service "nfs-id"`}))
+
+			services := broker.Services(context.TODO())
+			Expect(services[0].Description).To(Equal("Reloaded NFS shares"))
+			Expect(services[0].Plans).To(HaveLen(2))
+		})
+
+		It("reports no changes when the catalog is the same", func() {
+			Expect(broker.ReloadCatalogServices(catalog("NFS shares", general))).To(BeEmpty())
+		})
+
+		It("refuses catalogs that drop plans or services, or change drivers", func() {
+			_, err := broker.ReloadCatalogServices(catalog("NFS shares"))
+			Expect(err).To(MatchError(ContainSubstring(`plan "general" of service "nfs-id" is missing`)))
+
+			_, err = broker.ReloadCatalogServices([]nfsbroker.CatalogService{{ID: "other-id", Name: "nfs", Description: "NFS shares", Plans: []nfsbroker.Plan{general}}})
+			Expect(err).To(MatchError(ContainSubstring(`service "nfs-id" is missing`)))
+
+			changed := catalog("NFS shares", general)
+			changed[0].Driver = "smbdriver"
+			_, err = broker.ReloadCatalogServices(changed)
+			Expect(err).To(MatchError(ContainSubstring("cannot change its driver")))
+
+			Expect(broker.Services(context.TODO())[0].Plans).To(HaveLen(1))
+		})
+
+		It("refuses a catalog for a broker started without one", func() {
+			_, err := newBroker(nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)).ReloadCatalogServices(catalog("NFS shares", general))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("ReloadMountOptions", func() {
+		var fakeStore *nfsbrokerfakes.FakeStore
+
+		BeforeEach(func() {
+			fakeStore = &nfsbrokerfakes.FakeStore{}
+			fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{PlanID: "Existing", Share: "server:/some-share"}, nil)
+			broker = newBroker(fakeStore)
+		})
+
+		bind := func(parameters map[string]interface{}) error {
+			_, err := broker.Bind(context.TODO(), "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid", Parameters: parameters})
+			return err
+		}
+
+		It("allows the reloaded options, and reports whether they changed", func() {
+			Expect(bind(map[string]interface{}{"allow_root": true})).NotTo(Succeed())
+
+			mounts := nfsbroker.NewNfsBrokerConfigDetails()
+			mounts.ReadConf("uid,gid,allow_root", "")
+			Expect(broker.ReloadMountOptions(mounts)).To(BeTrue())
+			Expect(bind(map[string]interface{}{"allow_root": true})).To(Succeed())
+
+			Expect(broker.ReloadMountOptions(mounts)).To(BeFalse())
+		})
+	})
+})
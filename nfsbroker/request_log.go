@@ -0,0 +1,87 @@
+package nfsbroker
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// RequestIDHeader carries the ID of each request back in its response.
+const RequestIDHeader = "X-Request-Id"
+
+// requestIDHeaders are the headers an incoming request ID is taken from, in order of preference: the Cloud Controller
+// sends its own request's ID as X-Broker-API-Request-Identity, and the gorouter adds X-Vcap-Request-Id.
+var requestIDHeaders = []string{"X-Broker-API-Request-Identity", "X-Vcap-Request-Id", RequestIDHeader}
+
+// maxRequestIDLength bounds the request IDs taken from requests, which end up in every log line of the request.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request carried by ctx, if it has one.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestLogHandler logs each request's method, path, status and duration once it has been answered, under a request
+// ID taken from the request or else generated.  The ID is carried in the request context, so that the broker's logs
+// of the request include it, and returned in the X-Request-Id response header.
+func RequestLogHandler(logger lager.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		id := incomingRequestID(r)
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(WithRequestID(r.Context(), id)))
+
+		logger.Info("request", lager.Data{
+			"requestID": id,
+			"method":    r.Method,
+			"path":      r.URL.Path,
+			"status":    recorder.status,
+			"duration":  time.Since(started).String(),
+		})
+	})
+}
+
+// incomingRequestID returns the request ID a request carries, if it is short and printable.
+func incomingRequestID(r *http.Request) string {
+	for _, header := range requestIDHeaders {
+		id := r.Header.Get(header)
+		if id == "" || len(id) > maxRequestIDLength {
+			continue
+		}
+		printable := true
+		for _, c := range id {
+			if c < ' ' || c > '~' {
+				printable = false
+			}
+		}
+		if printable {
+			return id
+		}
+	}
+	return ""
+}
+
+// newRequestID returns a random version 4 UUID.
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
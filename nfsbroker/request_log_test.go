@@ -0,0 +1,88 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RequestLogHandler", func() {
+	var (
+		logger    *lagertest.TestLogger
+		handler   http.Handler
+		requestID string
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-request-log")
+		requestID = ""
+		handler = nfsbroker.RequestLogHandler(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID = nfsbroker.RequestID(r.Context())
+			w.WriteHeader(http.StatusCreated)
+		}))
+	})
+
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/v2/service_instances/instance-id?accepts_incomplete=true", nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	It("logs each request with its method, path, status and duration", func() {
+		recorder := serve(http.Header{})
+
+		Expect(logger.LogMessages()).To(Equal([]string{"test-request-log.request"}))
+		data := logger.Logs()[0].Data
+		Expect(data).To(HaveKeyWithValue("method", "PUT"))
+		Expect(data).To(HaveKeyWithValue("path", "/v2/service_instances/instance-id"))
+		Expect(data).To(HaveKeyWithValue("status", float64(http.StatusCreated)))
+		Expect(data).To(HaveKey("duration"))
+
+		Expect(requestID).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+		Expect(data).To(HaveKeyWithValue("requestID", requestID))
+		Expect(recorder.Header().Get(nfsbroker.RequestIDHeader)).To(Equal(requestID))
+	})
+
+	It("takes the request ID from the Cloud Controller's request", func() {
+		serve(http.Header{
+			"X-Broker-Api-Request-Identity": {"cc-request-id"},
+			"X-Vcap-Request-Id":             {"router-request-id"},
+		})
+		Expect(requestID).To(Equal("cc-request-id"))
+
+		serve(http.Header{"X-Vcap-Request-Id": {"router-request-id"}})
+		Expect(requestID).To(Equal("router-request-id"))
+	})
+
+	It("generates request IDs in place of unprintable or overlong ones", func() {
+		serve(http.Header{"X-Request-Id": {"forged\nentry"}})
+		Expect(requestID).NotTo(ContainSubstring("forged"))
+
+		serve(http.Header{"X-Request-Id": {strings.Repeat("x", 200)}})
+		Expect(requestID).To(HaveLen(36))
+	})
+
+	It("attaches the request ID to the broker's logs of the request", func() {
+		brokerLogger := lagertest.NewTestLogger("test-broker")
+		broker := nfsbroker.New(brokerLogger, "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize), nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		startup := len(brokerLogger.Logs())
+		broker.Services(nfsbroker.WithRequestID(context.Background(), "cc-request-id"))
+
+		Expect(len(brokerLogger.Logs())).To(BeNumerically(">", startup))
+		for _, log := range brokerLogger.Logs()[startup:] {
+			Expect(log.Data).To(HaveKeyWithValue("requestID", "cc-request-id"), log.Message)
+		}
+	})
+})
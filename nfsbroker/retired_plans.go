@@ -0,0 +1,144 @@
+package nfsbroker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// ErrPlanNotFound is returned for plans the broker does not offer.
+var ErrPlanNotFound = errors.New("plan not found")
+
+// defaultRetiredPlanHint is given to those who try to provision a plan that was retired without a hint.
+const defaultRetiredPlanHint = "choose another plan"
+
+// PlanStatus says whether a plan takes new instances.  Retired plans keep serving the instances they have, which
+// can still be bound, unbound, updated and deprovisioned, but new instances are refused with the hint.
+type PlanStatus struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Retired bool   `json:"retired"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// PlanStatuses reports whether each of the broker's plans is retired.
+func (b *Broker) PlanStatuses(ctx context.Context) ([]PlanStatus, error) {
+	retired, err := b.retiredPlans(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []PlanStatus{}
+	for _, plan := range b.plans {
+		hint, ok := retired[plan.ID]
+		statuses = append(statuses, PlanStatus{ID: plan.ID, Name: plan.Name, Retired: ok, Hint: hint})
+	}
+	return statuses, nil
+}
+
+// RetirePlan stops the plan with the given ID or name from taking new instances, and persists that in the store so
+// that it survives restarts.  Retiring a retired plan replaces its hint.
+func (b *Broker) RetirePlan(ctx context.Context, idOrName, hint string) (PlanStatus, error) {
+	logger := b.logger.Session("retire-plan", identityData(ctx)).WithData(lager.Data{"plan": idOrName, "hint": hint})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	return b.setPlanRetirement(ctx, logger, idOrName, func(ctx context.Context, plan Plan) error {
+		return b.store.RetirePlan(ctx, plan.ID, hint)
+	})
+}
+
+// ReinstatePlan lets a retired plan take new instances again.
+func (b *Broker) ReinstatePlan(ctx context.Context, idOrName string) (PlanStatus, error) {
+	logger := b.logger.Session("reinstate-plan", identityData(ctx)).WithData(lager.Data{"plan": idOrName})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	return b.setPlanRetirement(ctx, logger, idOrName, func(ctx context.Context, plan Plan) error {
+		return b.store.ReinstatePlan(ctx, plan.ID)
+	})
+}
+
+func (b *Broker) setPlanRetirement(ctx context.Context, logger lager.Logger, idOrName string, update func(context.Context, Plan) error) (PlanStatus, error) {
+	plan, ok := b.plan(idOrName)
+	if !ok {
+		plan, ok = b.planNamed(idOrName)
+	}
+	if !ok {
+		return PlanStatus{}, fmt.Errorf("%w: %s", ErrPlanNotFound, idOrName)
+	}
+
+	if err := b.lockFor(ctx); err != nil {
+		return PlanStatus{}, err
+	}
+	defer b.mutex.Unlock()
+
+	ctx = committed(ctx)
+	if err := update(ctx, plan); err != nil {
+		logger.Error("failed-to-update-plan", err)
+		return PlanStatus{}, err
+	}
+	if err := b.store.Save(logger); err != nil {
+		return PlanStatus{}, err
+	}
+
+	retired, err := b.store.ListRetiredPlans(ctx)
+	if err != nil {
+		return PlanStatus{}, err
+	}
+	hint, isRetired := retired[plan.ID]
+	return PlanStatus{ID: plan.ID, Name: plan.Name, Retired: isRetired, Hint: hint}, nil
+}
+
+// retiredPlans maps the IDs of retired plans to their hints.
+func (b *Broker) retiredPlans(ctx context.Context) (map[string]string, error) {
+	if err := b.lockFor(ctx); err != nil {
+		return nil, err
+	}
+	defer b.mutex.Unlock()
+
+	return b.store.ListRetiredPlans(ctx)
+}
+
+func (b *Broker) planNamed(name string) (Plan, bool) {
+	for _, plan := range b.plans {
+		if plan.Name == name {
+			return plan, true
+		}
+	}
+	return Plan{}, false
+}
+
+// checkPlanRetirement refuses new instances of retired plans.
+func (b *Broker) checkPlanRetirement(ctx context.Context, plan Plan) error {
+	retired, err := b.store.ListRetiredPlans(ctx)
+	if err != nil {
+		return err
+	}
+	hint, ok := retired[plan.ID]
+	if !ok {
+		return nil
+	}
+	if hint == "" {
+		hint = defaultRetiredPlanHint
+	}
+	err = fmt.Errorf("plan %q is retired and takes no new instances: %s", plan.Name, hint)
+	return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "plan-retired")
+}
+
+// retiredPlanMetadata adds a bullet about the plan's retirement to its catalog metadata.
+func retiredPlanMetadata(metadata *brokerapi.ServicePlanMetadata, hint string) *brokerapi.ServicePlanMetadata {
+	retired := brokerapi.ServicePlanMetadata{}
+	if metadata != nil {
+		retired = *metadata
+	}
+	if hint == "" {
+		hint = defaultRetiredPlanHint
+	}
+	retired.Bullets = append(append([]string{}, retired.Bullets...), "Retired: no new instances; "+hint)
+	return &retired
+}
@@ -0,0 +1,159 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Retired plans", func() {
+	var (
+		logger *lagertest.TestLogger
+		broker *nfsbroker.Broker
+		store  nfsbroker.Store
+		ctx    context.Context
+	)
+
+	provision := func(instanceID, planID string) error {
+		_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+			ServiceID:     "service-id",
+			PlanID:        planID,
+			RawParameters: json.RawMessage(`{"share":"server:/some-share"}`),
+		}, false)
+		return err
+	}
+
+	planMetadata := func(planID string) *brokerapi.ServicePlanMetadata {
+		for _, plan := range broker.Services(ctx)[0].Plans {
+			if plan.ID == planID {
+				return plan.Metadata
+			}
+		}
+		Fail("plan " + planID + " is not in the catalog")
+		return nil
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-retired-plans")
+		store = nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)
+		broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, store,
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		broker.SetPlans([]nfsbroker.Plan{
+			{ID: "old-plan-id", Name: "old", Description: "The old plan", Metadata: &brokerapi.ServicePlanMetadata{Bullets: []string{"NFSv3"}}},
+			{ID: "new-plan-id", Name: "new", Description: "The new plan"},
+		})
+		ctx = context.Background()
+
+		Expect(provision("existing-instance-id", "old-plan-id")).To(Succeed())
+	})
+
+	Context("when a plan is retired", func() {
+		BeforeEach(func() {
+			status, err := broker.RetirePlan(ctx, "old", "move to the new plan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status).To(Equal(nfsbroker.PlanStatus{ID: "old-plan-id", Name: "old", Retired: true, Hint: "move to the new plan"}))
+		})
+
+		It("persists the retirement in the store", func() {
+			Expect(store.ListRetiredPlans(ctx)).To(Equal(map[string]string{"old-plan-id": "move to the new plan"}))
+		})
+
+		It("refuses new instances of the plan with the hint", func() {
+			err := provision("instance-id", "old-plan-id")
+			Expect(err).To(MatchError(`plan "old" is retired and takes no new instances: move to the new plan`))
+			Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusBadRequest))
+
+			Expect(provision("instance-id", "new-plan-id")).To(Succeed())
+		})
+
+		It("refuses to move instances onto the plan", func() {
+			Expect(provision("instance-id", "new-plan-id")).To(Succeed())
+			_, err := broker.Update(ctx, "instance-id", brokerapi.UpdateDetails{PlanID: "old-plan-id"}, false)
+			Expect(err).To(MatchError(ContainSubstring("is retired")))
+		})
+
+		It("keeps serving the plan's instances", func() {
+			_, err := broker.Bind(ctx, "existing-instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid", PlanID: "old-plan-id"})
+			Expect(err).NotTo(HaveOccurred())
+			err = broker.Unbind(ctx, "existing-instance-id", "binding-id", brokerapi.UnbindDetails{PlanID: "old-plan-id"})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = broker.Update(ctx, "existing-instance-id", brokerapi.UpdateDetails{PlanID: "new-plan-id"}, false)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = broker.Deprovision(ctx, "existing-instance-id", brokerapi.DeprovisionDetails{PlanID: "new-plan-id"}, false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("marks the plan as retired in the catalog", func() {
+			Expect(planMetadata("old-plan-id").Bullets).To(Equal([]string{"NFSv3", "Retired: no new instances; move to the new plan"}))
+			Expect(planMetadata("new-plan-id")).To(BeNil())
+		})
+
+		Context("and reinstated", func() {
+			BeforeEach(func() {
+				status, err := broker.ReinstatePlan(ctx, "old-plan-id")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(status.Retired).To(BeFalse())
+			})
+
+			It("takes new instances again", func() {
+				Expect(provision("instance-id", "old-plan-id")).To(Succeed())
+				Expect(planMetadata("old-plan-id").Bullets).To(Equal([]string{"NFSv3"}))
+			})
+		})
+	})
+
+	It("does not retire plans the broker does not offer", func() {
+		_, err := broker.RetirePlan(ctx, "missing", "")
+		Expect(err).To(MatchError(nfsbroker.ErrPlanNotFound))
+	})
+
+	Describe("the admin endpoints", func() {
+		var handler http.Handler
+
+		serve := func(method, path, body string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+			return recorder
+		}
+
+		BeforeEach(func() {
+			handler = nfsbroker.NewAdminHandler(logger, broker)
+		})
+
+		It("retire, list and reinstate plans", func() {
+			recorder := serve("POST", nfsbroker.AdminRetirePlanPath, `{"plan":"old-plan-id"}`)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body).To(MatchJSON(`{"id":"old-plan-id","name":"old","retired":true}`))
+
+			recorder = serve("GET", nfsbroker.AdminPlansPath, "")
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body).To(MatchJSON(`{"plans":[
+				{"id":"new-plan-id","name":"new","retired":false},
+				{"id":"old-plan-id","name":"old","retired":true}
+			]}`))
+			Expect(provision("instance-id", "old-plan-id")).To(MatchError(ContainSubstring("choose another plan")))
+
+			recorder = serve("POST", nfsbroker.AdminReinstatePlanPath, `{"plan":"old"}`)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body).To(MatchJSON(`{"id":"old-plan-id","name":"old","retired":false}`))
+		})
+
+		It("reject requests without a plan", func() {
+			Expect(serve("POST", nfsbroker.AdminRetirePlanPath, `{"hint":"none"}`).Code).To(Equal(http.StatusBadRequest))
+			Expect(serve("POST", nfsbroker.AdminReinstatePlanPath, `not json`).Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("answer requests for unknown plans with not found", func() {
+			Expect(serve("POST", nfsbroker.AdminRetirePlanPath, `{"plan":"missing"}`).Code).To(Equal(http.StatusNotFound))
+		})
+	})
+})
@@ -0,0 +1,43 @@
+package nfsbroker
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// SetRequireNonRootIDs refuses bindings that would mount shares as root: with a uid or gid of 0, or without a uid or
+// gid, which drivers take to mean root.  Share types whose mounts take no uid and gid are not affected.
+func (b *Broker) SetRequireNonRootIDs(require bool) {
+	b.requireNonRootIDs = require
+}
+
+// checkNonRootIDs checks the uid and gid a binding's shares are mounted with, after defaults, forced options and
+// resolved user names have been applied.
+func (b *Broker) checkNonRootIDs(shareType ShareType, mounts []brokerapi.VolumeMount) error {
+	if !b.requireNonRootIDs || !inArray(shareType.MountOptions, "uid") {
+		return nil
+	}
+
+	problems := []string{}
+	for _, name := range []string{"uid", "gid"} {
+		for _, mount := range mounts {
+			value, ok := mount.Device.MountConfig[name]
+			if !ok || value == "" {
+				problems = append(problems, fmt.Sprintf("%s is required", name))
+				break
+			}
+			if id, ok := parseID(value); !ok || id == 0 {
+				problems = append(problems, fmt.Sprintf("%s must be an ID other than 0", name))
+				break
+			}
+		}
+	}
+	if len(problems) > 0 {
+		err := fmt.Errorf("shares cannot be mounted as root on this broker: %s", strings.Join(problems, "; "))
+		return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "root-not-allowed")
+	}
+	return nil
+}
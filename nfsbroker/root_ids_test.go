@@ -0,0 +1,88 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Non-root IDs", func() {
+	var (
+		broker        *nfsbroker.Broker
+		configDetails *nfsbroker.ConfigDetails
+		ctx           context.Context
+	)
+
+	newBroker := func() {
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-root-ids"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize), nfsbroker.NewNfsBrokerConfig(configDetails))
+		broker.SetRequireNonRootIDs(true)
+
+		_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+			ServiceID:     "service-id",
+			PlanID:        "Existing",
+			RawParameters: json.RawMessage(`{"share":"server:/export"}`),
+		}, false)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	bind := func(parameters map[string]interface{}) error {
+		_, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid", Parameters: parameters})
+		return err
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		configDetails = nfsbroker.NewNfsBrokerConfigDetails()
+		Expect(configDetails.ReadConf("uid,gid", "")).To(Succeed())
+		newBroker()
+	})
+
+	It("binds with IDs other than 0", func() {
+		Expect(bind(map[string]interface{}{"uid": "1000", "gid": 1000.0})).To(Succeed())
+	})
+
+	It("refuses uid or gid 0", func() {
+		err := bind(map[string]interface{}{"uid": "0", "gid": "1000"})
+		Expect(err).To(MatchError("shares cannot be mounted as root on this broker: uid must be an ID other than 0"))
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(400))
+
+		Expect(bind(map[string]interface{}{"uid": "1000", "gid": 0.0})).To(MatchError(ContainSubstring("gid must be an ID other than 0")))
+	})
+
+	It("refuses bindings without a uid and gid", func() {
+		Expect(bind(map[string]interface{}{"gid": "1000"})).To(MatchError(ContainSubstring("uid is required")))
+	})
+
+	Context("when the IDs come from default mount options", func() {
+		BeforeEach(func() {
+			Expect(configDetails.ReadConf("uid,gid", "uid:2000,gid:0")).To(Succeed())
+			newBroker()
+		})
+
+		It("checks them too", func() {
+			Expect(bind(map[string]interface{}{})).To(MatchError(ContainSubstring("gid must be an ID other than 0")))
+			Expect(bind(map[string]interface{}{"gid": "2000"})).To(Succeed())
+		})
+	})
+
+	Context("when the policy is off", func() {
+		It("binds as root", func() {
+			broker.SetRequireNonRootIDs(false)
+			Expect(bind(map[string]interface{}{"uid": "0", "gid": "0"})).To(Succeed())
+		})
+	})
+
+	Context("when the share type takes no uid and gid", func() {
+		It("binds without them", func() {
+			broker.SetShareType(nfsbroker.CephFSShareType)
+			Expect(bind(map[string]interface{}{})).To(Succeed())
+		})
+	})
+})
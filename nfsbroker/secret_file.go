@@ -0,0 +1,76 @@
+package nfsbroker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// SecretFile is a secret, such as a password, read from a file rather than given in the environment, where it can be
+// seen by other processes and is written into deployment manifests.  The file is read again whenever it changes, so
+// that secrets mounted from Kubernetes secrets or rendered by BOSH can be rotated without restarting the broker.
+type SecretFile struct {
+	path   string
+	logger lager.Logger
+
+	mutex   sync.Mutex
+	value   string
+	modTime time.Time
+	size    int64
+}
+
+// NewSecretFile reads the secret in path, which must not be empty.
+func NewSecretFile(logger lager.Logger, path string) (*SecretFile, error) {
+	s := &SecretFile{path: path, logger: logger.Session("secret-file", lager.Data{"path": path})}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.read(info); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Value returns the secret, reading the file again if it has changed since it was last read.  If the file cannot be
+// read, or has been emptied, the last secret read is kept.
+func (s *SecretFile) Value() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		s.logger.Error("failed-to-stat-secret-file", err)
+		return s.value
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.value
+	}
+	if err := s.read(info); err != nil {
+		s.logger.Error("failed-to-read-secret-file", err)
+		return s.value
+	}
+	s.logger.Info("read-changed-secret")
+	return s.value
+}
+
+func (s *SecretFile) read(info os.FileInfo) error {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	// files written by editors and templates usually end with a newline that is not part of the secret
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return fmt.Errorf("secret file %s is empty", s.path)
+	}
+	s.value = value
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return nil
+}
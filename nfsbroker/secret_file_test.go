@@ -0,0 +1,74 @@
+package nfsbroker_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SecretFile", func() {
+	var (
+		logger *lagertest.TestLogger
+		dir    string
+		path   string
+	)
+
+	write := func(contents string, modTime time.Time) {
+		Expect(ioutil.WriteFile(path, []byte(contents), 0600)).To(Succeed())
+		Expect(os.Chtimes(path, modTime, modTime)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-secret-file")
+		var err error
+		dir, err = ioutil.TempDir("", "secret-file")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "password")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("reads the secret without its trailing newline", func() {
+		write("s3cret\n", time.Now())
+		secret, err := nfsbroker.NewSecretFile(logger, path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.Value()).To(Equal("s3cret"))
+	})
+
+	It("reads the secret again when the file changes", func() {
+		write("old-secret", time.Now().Add(-time.Minute))
+		secret, err := nfsbroker.NewSecretFile(logger, path)
+		Expect(err).NotTo(HaveOccurred())
+
+		write("new-secret", time.Now())
+		Expect(secret.Value()).To(Equal("new-secret"))
+	})
+
+	It("keeps the last secret when the file is removed or emptied", func() {
+		write("s3cret", time.Now().Add(-time.Minute))
+		secret, err := nfsbroker.NewSecretFile(logger, path)
+		Expect(err).NotTo(HaveOccurred())
+
+		write("", time.Now())
+		Expect(secret.Value()).To(Equal("s3cret"))
+		Expect(os.Remove(path)).To(Succeed())
+		Expect(secret.Value()).To(Equal("s3cret"))
+	})
+
+	It("refuses missing and empty files", func() {
+		_, err := nfsbroker.NewSecretFile(logger, path)
+		Expect(err).To(HaveOccurred())
+
+		write("\n", time.Now())
+		_, err = nfsbroker.NewSecretFile(logger, path)
+		Expect(err).To(MatchError(ContainSubstring("is empty")))
+	})
+})
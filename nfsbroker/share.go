@@ -0,0 +1,74 @@
+package nfsbroker
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ShareComponents are the parts of a share string such as "server:/export/path?version=4.1".
+type ShareComponents struct {
+	Server  string
+	Path    string
+	Version string
+	Options map[string]string
+}
+
+// ParseShare splits a share into its server, export path and query options.  The server may be separated from the
+// path by "/" or ":/", and an "nfs://" prefix, or the "//" that SMB shares start with, is ignored.
+func ParseShare(share string) (ShareComponents, error) {
+	rest := strings.TrimPrefix(strings.TrimPrefix(share, "nfs://"), "//")
+
+	var components ShareComponents
+	if parts := strings.SplitN(rest, "?", 2); len(parts) == 2 {
+		query, err := url.ParseQuery(parts[1])
+		if err != nil {
+			return ShareComponents{}, fmt.Errorf("invalid options in share %q: %w", share, err)
+		}
+		components.Options = map[string]string{}
+		for key, values := range query {
+			components.Options[key] = values[0]
+		}
+		rest = parts[0]
+	}
+
+	if i := strings.Index(rest, ":/"); i >= 0 && !strings.Contains(rest[:i], "/") {
+		components.Server, components.Path = rest[:i], rest[i+1:]
+	} else if i := strings.Index(rest, "/"); i >= 0 {
+		components.Server, components.Path = rest[:i], rest[i:]
+	} else {
+		return ShareComponents{}, fmt.Errorf("share %q has no export path", share)
+	}
+	if components.Server == "" {
+		return ShareComponents{}, fmt.Errorf("share %q has no server", share)
+	}
+
+	components.Version = components.Options["version"]
+	if components.Version == "" {
+		components.Version = components.Options["vers"]
+	}
+	return components, nil
+}
+
+// setShare records the share string along with its parsed components.  Shares that cannot be parsed are kept as
+// given so that records created before the components were tracked still round trip.
+func (s *ServiceInstance) setShare(share string) error {
+	s.Share = share
+	components, err := ParseShare(share)
+	if err != nil {
+		return err
+	}
+	s.ShareServer = components.Server
+	s.SharePath = components.Path
+	s.ShareVersion = components.Version
+	s.ShareOptions = components.Options
+	return nil
+}
+
+// withShareComponents fills in the share components of records stored before they were tracked.
+func withShareComponents(s ServiceInstance) ServiceInstance {
+	if s.ShareServer == "" && s.Share != "" {
+		s.setShare(s.Share)
+	}
+	return s
+}
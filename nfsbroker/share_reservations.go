@@ -0,0 +1,211 @@
+package nfsbroker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var (
+	ErrInvalidShareReservation  = errors.New("invalid share reservation")
+	ErrShareReservationNotFound = errors.New("share reservation not found")
+	ErrShareReservationConflict = errors.New("share prefix overlaps a reservation for another organization")
+)
+
+// maxReservationPrefix is the width of the share_reservations.prefix column.
+const maxReservationPrefix = 255
+
+// ShareReservation reserves a share path, and every path beneath it, for one organization: only that organization
+// can provision or update instances onto shares under it.  Reservations without a server apply on every server.
+// Instances provisioned before a reservation was made are left alone.
+type ShareReservation struct {
+	Prefix           string `json:"prefix"`
+	Server           string `json:"server,omitempty"`
+	Path             string `json:"path"`
+	OrganizationGUID string `json:"organization_guid"`
+}
+
+// parseSharePrefix reads a reserved prefix such as "/exports/teamA/*" or "filer:/exports/teamA".  The trailing "/*"
+// is optional, since reservations always cover the paths beneath them.
+func parseSharePrefix(prefix string) (ShareReservation, error) {
+	server, sharePath := "", prefix
+	if i := strings.Index(prefix, ":/"); i >= 0 && !strings.Contains(prefix[:i], "/") {
+		server, sharePath = prefix[:i], prefix[i+1:]
+	}
+	sharePath = strings.TrimSuffix(sharePath, "/*")
+	if !path.IsAbs(sharePath) || strings.Contains(sharePath, "*") {
+		return ShareReservation{}, fmt.Errorf("%w: share prefix %q is not an absolute path, optionally preceded by a server and followed by /*", ErrInvalidShareReservation, prefix)
+	}
+	sharePath = path.Clean(sharePath)
+
+	reservation := ShareReservation{Server: server, Path: sharePath}
+	reservation.Prefix = reservation.key()
+	if len(reservation.Prefix) > maxReservationPrefix {
+		return ShareReservation{}, fmt.Errorf("%w: share prefix %q is longer than %d characters", ErrInvalidShareReservation, prefix, maxReservationPrefix)
+	}
+	return reservation, nil
+}
+
+// key is the normalized prefix reservations are stored under.
+func (r ShareReservation) key() string {
+	if r.Server == "" {
+		return r.Path
+	}
+	return r.Server + ":" + r.Path
+}
+
+// covers reports whether the share on server at sharePath falls under the reservation.
+func (r ShareReservation) covers(server, sharePath string) bool {
+	if r.Server != "" && r.Server != server {
+		return false
+	}
+	sharePath = path.Clean(sharePath)
+	return sharePath == r.Path || r.Path == "/" || strings.HasPrefix(sharePath, r.Path+"/")
+}
+
+// overlaps reports whether either reservation covers the other's prefix.
+func (r ShareReservation) overlaps(other ShareReservation) bool {
+	if r.Server != "" && other.Server != "" && r.Server != other.Server {
+		return false
+	}
+	return (ShareReservation{Path: r.Path}).covers("", other.Path) || (ShareReservation{Path: other.Path}).covers("", r.Path)
+}
+
+// ShareReservations lists the reservations in prefix order.
+func (b *Broker) ShareReservations(ctx context.Context) ([]ShareReservation, error) {
+	if err := b.lockFor(ctx); err != nil {
+		return nil, err
+	}
+	defer b.mutex.Unlock()
+
+	return b.shareReservations(ctx)
+}
+
+func (b *Broker) shareReservations(ctx context.Context) ([]ShareReservation, error) {
+	stored, err := b.store.ListShareReservations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reservations := []ShareReservation{}
+	for prefix, orgGUID := range stored {
+		reservation, err := parseSharePrefix(prefix)
+		if err != nil {
+			b.logger.Error("invalid-share-reservation", err, lager.Data{"prefix": prefix})
+			continue
+		}
+		reservation.OrganizationGUID = orgGUID
+		reservations = append(reservations, reservation)
+	}
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].Prefix < reservations[j].Prefix })
+	return reservations, nil
+}
+
+// ReserveShares reserves a share prefix for an organization.  Reserving a prefix again moves it to the new
+// organization, but prefixes that overlap another organization's reservation are refused.
+func (b *Broker) ReserveShares(ctx context.Context, prefix, orgGUID string) (_ ShareReservation, e error) {
+	logger := b.logger.Session("reserve-shares", identityData(ctx)).WithData(lager.Data{"prefix": prefix, "organizationGUID": orgGUID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if orgGUID == "" {
+		return ShareReservation{}, fmt.Errorf("%w: no organization given", ErrInvalidShareReservation)
+	}
+	reservation, err := parseSharePrefix(prefix)
+	if err != nil {
+		return ShareReservation{}, err
+	}
+	reservation.OrganizationGUID = orgGUID
+
+	if err := b.lockFor(ctx); err != nil {
+		return ShareReservation{}, err
+	}
+	defer b.mutex.Unlock()
+
+	existing, err := b.shareReservations(ctx)
+	if err != nil {
+		return ShareReservation{}, err
+	}
+	for _, other := range existing {
+		if other.Prefix != reservation.Prefix && other.OrganizationGUID != orgGUID && other.overlaps(reservation) {
+			return ShareReservation{}, fmt.Errorf("%w: %s is reserved for organization %s", ErrShareReservationConflict, other.Prefix, other.OrganizationGUID)
+		}
+	}
+
+	defer func() {
+		out := b.store.Save(logger)
+		if e == nil {
+			e = out
+		}
+	}()
+	if err := b.store.ReserveShares(committed(ctx), reservation.Prefix, orgGUID); err != nil {
+		return ShareReservation{}, err
+	}
+	return reservation, nil
+}
+
+// ReleaseShares removes the reservation of a share prefix.
+func (b *Broker) ReleaseShares(ctx context.Context, prefix string) (e error) {
+	logger := b.logger.Session("release-shares", identityData(ctx)).WithData(lager.Data{"prefix": prefix})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	reservation, err := parseSharePrefix(prefix)
+	if err != nil {
+		return err
+	}
+
+	if err := b.lockFor(ctx); err != nil {
+		return err
+	}
+	defer b.mutex.Unlock()
+
+	existing, err := b.store.ListShareReservations(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := existing[reservation.Prefix]; !ok {
+		return fmt.Errorf("%w: %s", ErrShareReservationNotFound, reservation.Prefix)
+	}
+
+	defer func() {
+		out := b.store.Save(logger)
+		if e == nil {
+			e = out
+		}
+	}()
+	return b.store.ReleaseShares(committed(ctx), reservation.Prefix)
+}
+
+// checkShareReservations refuses instances with shares reserved for an organization other than the instance's.
+// Shares that could not be parsed into a server and path are not checked.
+func (b *Broker) checkShareReservations(ctx context.Context, details ServiceInstance) error {
+	if isProbe(ctx) {
+		return nil
+	}
+
+	reservations, err := b.shareReservations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, share := range details.eachShare() {
+		share := share.details
+		if share.SharePath == "" {
+			continue
+		}
+		for _, reservation := range reservations {
+			if reservation.covers(share.ShareServer, share.SharePath) && reservation.OrganizationGUID != details.OrganizationGUID {
+				err := fmt.Errorf("share %s is under %s, which is reserved for another organization", share.Share, reservation.Prefix)
+				return brokerapi.NewFailureResponse(err, http.StatusForbidden, "share-reserved")
+			}
+		}
+	}
+	return nil
+}
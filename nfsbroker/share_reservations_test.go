@@ -0,0 +1,175 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Share reservations", func() {
+	var (
+		logger *lagertest.TestLogger
+		broker *nfsbroker.Broker
+		store  nfsbroker.Store
+		ctx    context.Context
+	)
+
+	provision := func(instanceID, orgGUID, share string) error {
+		_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+			ServiceID:        "service-id",
+			PlanID:           "Existing",
+			OrganizationGUID: orgGUID,
+			RawParameters:    json.RawMessage(`{"share":"` + share + `"}`),
+		}, false)
+		return err
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-share-reservations")
+		store = nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)
+		broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, store,
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		ctx = context.Background()
+	})
+
+	Context("when a prefix is reserved for an organization", func() {
+		BeforeEach(func() {
+			reservation, err := broker.ReserveShares(ctx, "/exports/teamA/*", "org-a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reservation).To(Equal(nfsbroker.ShareReservation{Prefix: "/exports/teamA", Path: "/exports/teamA", OrganizationGUID: "org-a"}))
+		})
+
+		It("persists the reservation in the store", func() {
+			Expect(store.ListShareReservations(ctx)).To(Equal(map[string]string{"/exports/teamA": "org-a"}))
+		})
+
+		It("refuses shares under the prefix to other organizations", func() {
+			err := provision("instance-id", "org-b", "server:/exports/teamA/data")
+			Expect(err).To(MatchError("share server:/exports/teamA/data is under /exports/teamA, which is reserved for another organization"))
+			Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusForbidden))
+
+			Expect(provision("instance-id", "org-b", "other-server:/exports/teamA")).To(MatchError(ContainSubstring("reserved")))
+			Expect(provision("instance-id", "org-b", "server:/exports/teamB/../teamA/data")).To(MatchError(ContainSubstring("not clean")))
+		})
+
+		It("allows the organization it is reserved for", func() {
+			Expect(provision("instance-id", "org-a", "server:/exports/teamA/data")).To(Succeed())
+		})
+
+		It("allows shares outside the prefix", func() {
+			Expect(provision("instance-id", "org-b", "server:/exports/teamAB")).To(Succeed())
+			Expect(provision("other-instance-id", "org-b", "server:/exports")).To(Succeed())
+		})
+
+		It("refuses to move other organizations' instances onto the prefix", func() {
+			Expect(provision("instance-id", "org-b", "server:/exports/teamB")).To(Succeed())
+			_, err := broker.Update(ctx, "instance-id", brokerapi.UpdateDetails{
+				PlanID:        "Existing",
+				RawParameters: json.RawMessage(`{"share":"server:/exports/teamA"}`),
+			}, false)
+			Expect(err).To(MatchError(ContainSubstring("reserved")))
+		})
+
+		It("refuses overlapping reservations for other organizations", func() {
+			_, err := broker.ReserveShares(ctx, "/exports/teamA/data", "org-b")
+			Expect(err).To(MatchError(nfsbroker.ErrShareReservationConflict))
+			_, err = broker.ReserveShares(ctx, "filer:/exports", "org-b")
+			Expect(err).To(MatchError(nfsbroker.ErrShareReservationConflict))
+
+			_, err = broker.ReserveShares(ctx, "/exports/teamA/data", "org-a")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("moves the reservation when the prefix is reserved again", func() {
+			_, err := broker.ReserveShares(ctx, "/exports/teamA", "org-b")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(provision("instance-id", "org-b", "server:/exports/teamA/data")).To(Succeed())
+		})
+
+		Context("and released", func() {
+			BeforeEach(func() {
+				Expect(broker.ReleaseShares(ctx, "/exports/teamA/*")).To(Succeed())
+			})
+
+			It("allows every organization again", func() {
+				Expect(provision("instance-id", "org-b", "server:/exports/teamA/data")).To(Succeed())
+			})
+
+			It("cannot be released again", func() {
+				Expect(broker.ReleaseShares(ctx, "/exports/teamA")).To(MatchError(nfsbroker.ErrShareReservationNotFound))
+			})
+		})
+	})
+
+	It("only applies reservations with a server to shares on that server", func() {
+		_, err := broker.ReserveShares(ctx, "filer:/exports/teamA", "org-a")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(provision("instance-id", "org-b", "filer:/exports/teamA/data")).To(MatchError(ContainSubstring("reserved")))
+		Expect(provision("instance-id", "org-b", "other-filer:/exports/teamA/data")).To(Succeed())
+	})
+
+	It("refuses invalid reservations", func() {
+		_, err := broker.ReserveShares(ctx, "exports/teamA", "org-a")
+		Expect(err).To(MatchError(nfsbroker.ErrInvalidShareReservation))
+		_, err = broker.ReserveShares(ctx, "/exports/*/data", "org-a")
+		Expect(err).To(MatchError(nfsbroker.ErrInvalidShareReservation))
+		_, err = broker.ReserveShares(ctx, "/exports/teamA", "")
+		Expect(err).To(MatchError(nfsbroker.ErrInvalidShareReservation))
+	})
+
+	Describe("the admin endpoints", func() {
+		var handler http.Handler
+
+		serve := func(method, path, body string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+			return recorder
+		}
+
+		BeforeEach(func() {
+			handler = nfsbroker.NewAdminHandler(logger, broker)
+		})
+
+		It("reserve, list and release prefixes", func() {
+			recorder := serve("POST", nfsbroker.AdminReserveSharesPath, `{"prefix":"filer:/exports/teamA/*","organization_guid":"org-a"}`)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body).To(MatchJSON(`{"prefix":"filer:/exports/teamA","server":"filer","path":"/exports/teamA","organization_guid":"org-a"}`))
+
+			recorder = serve("GET", nfsbroker.AdminShareReservationsPath, "")
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body).To(MatchJSON(`{"reservations":[
+				{"prefix":"filer:/exports/teamA","server":"filer","path":"/exports/teamA","organization_guid":"org-a"}
+			]}`))
+
+			recorder = serve("POST", nfsbroker.AdminReleaseSharesPath, `{"prefix":"filer:/exports/teamA"}`)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(store.ListShareReservations(ctx)).To(BeEmpty())
+		})
+
+		It("reject invalid requests", func() {
+			Expect(serve("POST", nfsbroker.AdminReserveSharesPath, `{"organization_guid":"org-a"}`).Code).To(Equal(http.StatusBadRequest))
+			Expect(serve("POST", nfsbroker.AdminReserveSharesPath, `{"prefix":"/exports"}`).Code).To(Equal(http.StatusBadRequest))
+			Expect(serve("POST", nfsbroker.AdminReleaseSharesPath, `not json`).Code).To(Equal(http.StatusBadRequest))
+			Expect(serve("GET", nfsbroker.AdminReserveSharesPath, "").Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+
+		It("answer conflicting reservations with conflict", func() {
+			Expect(serve("POST", nfsbroker.AdminReserveSharesPath, `{"prefix":"/exports","organization_guid":"org-a"}`).Code).To(Equal(http.StatusOK))
+			Expect(serve("POST", nfsbroker.AdminReserveSharesPath, `{"prefix":"/exports/teamB","organization_guid":"org-b"}`).Code).To(Equal(http.StatusConflict))
+		})
+
+		It("answer releases of unreserved prefixes with not found", func() {
+			Expect(serve("POST", nfsbroker.AdminReleaseSharesPath, `{"prefix":"/exports"}`).Code).To(Equal(http.StatusNotFound))
+		})
+	})
+})
@@ -0,0 +1,61 @@
+package nfsbroker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//go:generate counterfeiter -o ../nfsbrokerfakes/fake_org_name_lookup.go . OrgNameLookup
+type OrgNameLookup interface {
+	OrganizationName(ctx context.Context, guid string) (string, error)
+}
+
+// DefaultShareServers picks the NFS server for shares provisioned without one, keyed by organization GUID or, when an
+// OrgNameLookup is available, by organization name.
+type DefaultShareServers struct {
+	servers map[string]string
+	lookup  OrgNameLookup
+}
+
+func NewDefaultShareServers(servers map[string]string, lookup OrgNameLookup) *DefaultShareServers {
+	return &DefaultShareServers{
+		servers: servers,
+		lookup:  lookup,
+	}
+}
+
+// ParseDefaultShareServers reads a JSON object mapping organization GUIDs or names to server hostnames.
+func ParseDefaultShareServers(data []byte) (map[string]string, error) {
+	servers := map[string]string{}
+	if err := json.Unmarshal(data, &servers); err != nil {
+		return nil, fmt.Errorf("invalid default share server mapping: %w", err)
+	}
+	for org, server := range servers {
+		if server == "" || strings.ContainsAny(server, "/?") {
+			return nil, fmt.Errorf("invalid default share server %q for organization %q", server, org)
+		}
+	}
+	return servers, nil
+}
+
+// ServerFor returns the default server for the organization, or "" if there is none.
+func (d *DefaultShareServers) ServerFor(ctx context.Context, orgGUID string) (string, error) {
+	if server, ok := d.servers[orgGUID]; ok {
+		return server, nil
+	}
+	if d.lookup == nil {
+		return "", nil
+	}
+
+	orgName, err := d.lookup.OrganizationName(ctx, orgGUID)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up name of organization %s: %w", orgGUID, err)
+	}
+	return d.servers[orgName], nil
+}
+
+func shareHasServer(share string) bool {
+	return !strings.HasPrefix(share, "/") || strings.HasPrefix(share, "//")
+}
@@ -0,0 +1,97 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"errors"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DefaultShareServers", func() {
+	var (
+		servers    *nfsbroker.DefaultShareServers
+		fakeLookup *nfsbrokerfakes.FakeOrgNameLookup
+		mapping    map[string]string
+		server     string
+		err        error
+	)
+
+	BeforeEach(func() {
+		fakeLookup = &nfsbrokerfakes.FakeOrgNameLookup{}
+		mapping = map[string]string{
+			"org-guid": "filer-1.example.com",
+			"my-org":   "filer-2.example.com",
+		}
+		servers = nfsbroker.NewDefaultShareServers(mapping, fakeLookup)
+	})
+
+	Context("when the organization GUID is mapped", func() {
+		BeforeEach(func() {
+			server, err = servers.ServerFor(context.TODO(), "org-guid")
+		})
+
+		It("returns its server without a lookup", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server).To(Equal("filer-1.example.com"))
+			Expect(fakeLookup.OrganizationNameCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the organization name is mapped", func() {
+		BeforeEach(func() {
+			fakeLookup.OrganizationNameReturns("my-org", nil)
+			server, err = servers.ServerFor(context.TODO(), "other-guid")
+		})
+
+		It("looks up the name and returns its server", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server).To(Equal("filer-2.example.com"))
+			_, guid := fakeLookup.OrganizationNameArgsForCall(0)
+			Expect(guid).To(Equal("other-guid"))
+		})
+	})
+
+	Context("when the name lookup fails", func() {
+		BeforeEach(func() {
+			fakeLookup.OrganizationNameReturns("", errors.New("cc is down"))
+			server, err = servers.ServerFor(context.TODO(), "other-guid")
+		})
+
+		It("returns the error", func() {
+			Expect(err).To(MatchError(ContainSubstring("cc is down")))
+		})
+	})
+
+	Context("when there is no name lookup", func() {
+		BeforeEach(func() {
+			servers = nfsbroker.NewDefaultShareServers(mapping, nil)
+			server, err = servers.ServerFor(context.TODO(), "other-guid")
+		})
+
+		It("finds no server", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server).To(BeEmpty())
+		})
+	})
+
+	Describe("ParseDefaultShareServers", func() {
+		It("parses a JSON mapping", func() {
+			parsed, err := nfsbroker.ParseDefaultShareServers([]byte(`{"org-guid":"filer.example.com"}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed).To(Equal(map[string]string{"org-guid": "filer.example.com"}))
+		})
+
+		It("rejects servers that include a path", func() {
+			_, err := nfsbroker.ParseDefaultShareServers([]byte(`{"org-guid":"filer.example.com/export"}`))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects invalid JSON", func() {
+			_, err := nfsbroker.ParseDefaultShareServers([]byte(`not json`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
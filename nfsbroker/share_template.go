@@ -0,0 +1,134 @@
+package nfsbroker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// The placeholders a share template may use.
+const (
+	TemplateOrg        = "{org}"
+	TemplateSpace      = "{space}"
+	TemplateOrgGUID    = "{org_guid}"
+	TemplateSpaceGUID  = "{space_guid}"
+	TemplateInstanceID = "{instance_id}"
+)
+
+var templatePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// unsafePathCharacters are replaced with hyphens when names fill a share template, so that names with spaces or
+// slashes still give one directory.
+var unsafePathCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// ShareTemplate is the share, such as "filer:/exports/{org}/{space}", of instances provisioned without one.  Its
+// placeholders are filled in with the names or GUIDs of the instance's organization and space, or with its ID, so that
+// each tenant gets a directory of its own.
+type ShareTemplate struct {
+	template string
+}
+
+// NewShareTemplate checks that template only uses known placeholders, and only in its export path.
+func NewShareTemplate(template string) (*ShareTemplate, error) {
+	known := []string{TemplateOrg, TemplateSpace, TemplateOrgGUID, TemplateSpaceGUID, TemplateInstanceID}
+	for _, placeholder := range templatePlaceholder.FindAllString(template, -1) {
+		if !inArray(known, placeholder) {
+			return nil, fmt.Errorf("share template %q has unknown placeholder %s; expected one of %s", template, placeholder, strings.Join(known, ", "))
+		}
+	}
+	if strings.ContainsAny(templatePlaceholder.ReplaceAllString(template, ""), "{}") {
+		return nil, fmt.Errorf("share template %q has an unclosed placeholder", template)
+	}
+
+	components, err := ParseShare(template)
+	if err != nil {
+		return nil, fmt.Errorf("share template %q: %w", template, err)
+	}
+	if templatePlaceholder.MatchString(components.Server) {
+		return nil, fmt.Errorf("share template %q has a placeholder in its server", template)
+	}
+	if !path.IsAbs(components.Path) {
+		return nil, fmt.Errorf("share template %q does not have an absolute path", template)
+	}
+	return &ShareTemplate{template: template}, nil
+}
+
+func (t *ShareTemplate) String() string {
+	return t.template
+}
+
+// SetShareTemplate makes the share provision parameter optional, provisioning instances without one the share
+// template filled in for them.
+func (b *Broker) SetShareTemplate(template *ShareTemplate) {
+	b.shareTemplate = template
+}
+
+// templateShare fills in the share template for a new instance.  Organization and space names are taken from the
+// provision request's context, or else looked up.
+func (b *Broker) templateShare(ctx context.Context, logger lager.Logger, instanceID string, platform platformContext) (string, error) {
+	values := map[string]string{}
+	missing := []string{}
+	for _, placeholder := range templatePlaceholder.FindAllString(b.shareTemplate.template, -1) {
+		if _, ok := values[placeholder]; ok {
+			continue
+		}
+		value, err := b.templateValue(ctx, placeholder, instanceID, platform)
+		if err != nil {
+			return "", err
+		}
+		if value == "" {
+			missing = append(missing, placeholder)
+		}
+		values[placeholder] = value
+	}
+	if len(missing) > 0 {
+		err := fmt.Errorf("the share template %s needs %s, which the broker could not find; give a share instead", b.shareTemplate, strings.Join(missing, ", "))
+		return "", brokerapi.NewFailureResponse(err, http.StatusBadRequest, "incomplete-share-template")
+	}
+
+	share := templatePlaceholder.ReplaceAllStringFunc(b.shareTemplate.template, func(placeholder string) string {
+		return values[placeholder]
+	})
+	logger.Info("filled-in-share-template", lager.Data{"template": b.shareTemplate.template, "share": share})
+	return share, nil
+}
+
+// templateValue returns what placeholder is filled in with, made safe to use as a directory name.  It returns "" for
+// values that cannot be found.
+func (b *Broker) templateValue(ctx context.Context, placeholder, instanceID string, platform platformContext) (string, error) {
+	var value string
+	switch placeholder {
+	case TemplateOrgGUID:
+		value = platform.OrganizationGUID
+	case TemplateSpaceGUID:
+		value = platform.SpaceGUID
+	case TemplateInstanceID:
+		value = instanceID
+	case TemplateOrg:
+		value = platform.OrganizationName
+		if value == "" && platform.OrganizationGUID != "" {
+			value = b.instanceNames(ctx, ServiceInstance{OrganizationGUID: platform.OrganizationGUID}).organization
+		}
+	case TemplateSpace:
+		value = platform.SpaceName
+		if value == "" && platform.SpaceGUID != "" {
+			value = b.instanceNames(ctx, ServiceInstance{SpaceGUID: platform.SpaceGUID}).space
+		}
+	}
+	if value == "" {
+		return "", nil
+	}
+
+	safe := unsafePathCharacters.ReplaceAllString(value, "-")
+	if strings.Trim(safe, ".") == "" {
+		err := fmt.Errorf("%q cannot name a directory for the share template %s; give a share instead", value, b.shareTemplate)
+		return "", brokerapi.NewFailureResponse(err, http.StatusBadRequest, "invalid-share-template-value")
+	}
+	return safe, nil
+}
@@ -0,0 +1,134 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Share templates", func() {
+	var (
+		broker     *nfsbroker.Broker
+		store      nfsbroker.Store
+		fakeLookup *nfsbrokerfakes.FakeNameLookup
+		ctx        context.Context
+		rawContext string
+	)
+
+	provision := func(parameters string) error {
+		_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+			ServiceID:     "service-id",
+			PlanID:        "Existing",
+			RawParameters: json.RawMessage(parameters),
+			RawContext:    json.RawMessage(rawContext),
+		}, false)
+		return err
+	}
+
+	useTemplate := func(template string) {
+		shareTemplate, err := nfsbroker.NewShareTemplate(template)
+		Expect(err).NotTo(HaveOccurred())
+		broker.SetShareTemplate(shareTemplate)
+	}
+
+	share := func() string {
+		details, err := store.RetrieveInstanceDetails(ctx, "instance-id")
+		Expect(err).NotTo(HaveOccurred())
+		return details.Share
+	}
+
+	BeforeEach(func() {
+		store = nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-share-template"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, store, nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		fakeLookup = &nfsbrokerfakes.FakeNameLookup{}
+		fakeLookup.OrganizationNameReturns("looked-up-org", nil)
+		fakeLookup.SpaceNameReturns("looked-up-space", nil)
+		ctx = context.Background()
+		rawContext = `{"platform":"cloudfoundry","organization_guid":"org-guid","space_guid":"space-guid","organization_name":"my-org","space_name":"my-space"}`
+	})
+
+	It("fills in GUIDs and the instance ID", func() {
+		useTemplate("filer:/exports/{org_guid}/{space_guid}/{instance_id}")
+		Expect(provision(`{}`)).To(Succeed())
+		Expect(share()).To(Equal("filer:/exports/org-guid/space-guid/instance-id"))
+	})
+
+	It("fills in names from the context", func() {
+		useTemplate("filer:/exports/{org}/{space}")
+		Expect(provision(`{}`)).To(Succeed())
+		Expect(share()).To(Equal("filer:/exports/my-org/my-space"))
+	})
+
+	It("looks up names the context does not have", func() {
+		rawContext = `{"organization_guid":"org-guid","space_guid":"space-guid"}`
+		broker.SetNameLookup(fakeLookup)
+		useTemplate("filer:/exports/{org}/{space}")
+		Expect(provision(`{}`)).To(Succeed())
+		Expect(share()).To(Equal("filer:/exports/looked-up-org/looked-up-space"))
+	})
+
+	It("makes names safe for directories", func() {
+		rawContext = `{"organization_guid":"org-guid","space_guid":"space-guid","organization_name":"Team A/B","space_name":"dev"}`
+		useTemplate("filer:/exports/{org}/{space}")
+		Expect(provision(`{}`)).To(Succeed())
+		Expect(share()).To(Equal("filer:/exports/Team-A-B/dev"))
+	})
+
+	It("refuses names that cannot name a directory", func() {
+		rawContext = `{"organization_guid":"org-guid","space_guid":"space-guid","organization_name":"..","space_name":"dev"}`
+		useTemplate("filer:/exports/{org}/{space}")
+		err := provision(`{}`)
+		Expect(err).To(MatchError(ContainSubstring(`".." cannot name a directory`)))
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(400))
+	})
+
+	It("refuses to provision when names cannot be found", func() {
+		rawContext = `{"organization_guid":"org-guid","space_guid":"space-guid"}`
+		useTemplate("filer:/exports/{org}/{space}")
+		err := provision(`{}`)
+		Expect(err).To(MatchError(ContainSubstring("needs {org}, {space}, which the broker could not find")))
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(400))
+	})
+
+	It("uses the share given instead", func() {
+		useTemplate("filer:/exports/{org}/{space}")
+		Expect(provision(`{"share":"other:/export"}`)).To(Succeed())
+		Expect(share()).To(Equal("other:/export"))
+	})
+
+	It("makes the share parameter optional", func() {
+		useTemplate("filer:/exports/{org}/{space}")
+		schema := broker.Services(ctx)[0].Plans[0].Schemas.Instance.Create.Parameters
+		Expect(schema).NotTo(HaveKey("required"))
+	})
+
+	Describe("NewShareTemplate", func() {
+		It("refuses unknown placeholders", func() {
+			_, err := nfsbroker.NewShareTemplate("filer:/exports/{organization}")
+			Expect(err).To(MatchError(ContainSubstring("unknown placeholder {organization}; expected one of {org}, {space}, {org_guid}, {space_guid}, {instance_id}")))
+		})
+
+		It("refuses unclosed placeholders", func() {
+			_, err := nfsbroker.NewShareTemplate("filer:/exports/{org")
+			Expect(err).To(MatchError(ContainSubstring("unclosed placeholder")))
+		})
+
+		It("refuses placeholders in the server", func() {
+			_, err := nfsbroker.NewShareTemplate("{org}:/exports")
+			Expect(err).To(MatchError(ContainSubstring("placeholder in its server")))
+		})
+
+		It("refuses templates without an absolute path", func() {
+			_, err := nfsbroker.NewShareTemplate("filer")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,46 @@
+package nfsbroker_test
+
+import (
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseShare", func() {
+	It("parses server/path shares", func() {
+		components, err := nfsbroker.ParseShare("filer.example.com/export/vol1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(components).To(Equal(nfsbroker.ShareComponents{Server: "filer.example.com", Path: "/export/vol1"}))
+	})
+
+	It("parses server:/path shares", func() {
+		components, err := nfsbroker.ParseShare("filer.example.com:/export/vol1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(components.Server).To(Equal("filer.example.com"))
+		Expect(components.Path).To(Equal("/export/vol1"))
+	})
+
+	It("ignores an nfs:// prefix", func() {
+		components, err := nfsbroker.ParseShare("nfs://filer.example.com/export/vol1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(components.Server).To(Equal("filer.example.com"))
+	})
+
+	It("parses options and the version", func() {
+		components, err := nfsbroker.ParseShare("filer.example.com/export/vol1?version=4.1&uid=1000")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(components.Path).To(Equal("/export/vol1"))
+		Expect(components.Version).To(Equal("4.1"))
+		Expect(components.Options).To(Equal(map[string]string{"version": "4.1", "uid": "1000"}))
+	})
+
+	It("rejects shares without a path", func() {
+		_, err := nfsbroker.ParseShare("filer.example.com")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects shares without a server", func() {
+		_, err := nfsbroker.ParseShare("/export/vol1")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,284 @@
+package nfsbroker
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// ShareType describes a kind of existing filesystem the broker can offer as a volume service.  The broker is an NFS
+// broker by default; other share types reuse the same store, bind parameters and mount config handling.
+type ShareType struct {
+	Name        string
+	Description string
+	Tags        []string
+
+	// Driver is the volume driver named in binding volume mounts.
+	Driver string
+
+	// Scheme prefixes the share in the mount source passed to the driver.
+	Scheme string
+
+	// ValidateShare rejects shares that the driver would not be able to mount.  Shares are validated after any
+	// default server has been filled in.
+	ValidateShare func(share string) error
+
+	// MountOptions are the mount config keys the driver understands besides "source".
+	MountOptions []string
+
+	// VersionOption is the mount config key the driver takes the protocol version in.  Share types without one
+	// refuse version parameters.
+	VersionOption string
+
+	// BindCredentials are bind parameters that the driver authenticates to the share's server with, such as an SMB
+	// username and password.  They are passed to the driver under the same names whatever mount options are
+	// allowed.
+	BindCredentials []BindCredential
+}
+
+// BindCredential describes a bind parameter that a share type's driver authenticates with.  Credentials named in
+// SecretBindParameters are never stored.
+type BindCredential struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// bindCredentialNames returns the names of the share type's bind credentials.
+func (t ShareType) bindCredentialNames() []string {
+	names := []string{}
+	for _, credential := range t.BindCredentials {
+		names = append(names, credential.Name)
+	}
+	return names
+}
+
+// checkBindCredentials refuses bindings without the share type's required credentials, and credentials that are not
+// strings.
+func (t ShareType) checkBindCredentials(parameters map[string]interface{}) error {
+	problems := []string{}
+	for _, credential := range t.BindCredentials {
+		value, ok := parameters[credential.Name]
+		if !ok {
+			if credential.Required {
+				problems = append(problems, fmt.Sprintf("%s is required", credential.Name))
+			}
+			continue
+		}
+		if s, ok := value.(string); !ok || (s == "" && credential.Required) {
+			problems = append(problems, fmt.Sprintf("%s must be a non-empty string", credential.Name))
+		}
+	}
+	if len(problems) > 0 {
+		err := fmt.Errorf("invalid %s credentials: %s", t.Name, strings.Join(problems, "; "))
+		return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "invalid-bind-credentials")
+	}
+	return nil
+}
+
+var NFSShareType = ShareType{
+	Name:          "nfs",
+	Description:   "Existing NFSv3 volumes (see: https://code.cloudfoundry.org/nfs-volume-release/)",
+	Tags:          []string{"nfs"},
+	Driver:        "nfsv3driver",
+	Scheme:        "nfs://",
+	ValidateShare: validateNFSShare,
+	MountOptions: []string{
+		"uid", "gid", "allow_root", "allow_other", "default_permissions", "multithread", "fusenfs_uid", "fusenfs_gid",
+		"nfs_uid", "nfs_gid", "auto_cache", "sloppy_mount", "fsname", "username", "password", "readonly", "version",
+		"experimental",
+	},
+	VersionOption: "version",
+}
+
+var CephFSShareType = ShareType{
+	Name:          "cephfs",
+	Description:   "Existing CephFS paths (see: https://github.com/cloudfoundry/cephfs-bosh-release/)",
+	Tags:          []string{"cephfs"},
+	Driver:        "cephdriver",
+	Scheme:        "cephfs://",
+	ValidateShare: validateCephFSShare,
+	MountOptions:  []string{"keyring", "ip", "readonly"},
+}
+
+var SMBShareType = ShareType{
+	Name:          "smb",
+	Description:   "Existing SMB shares (see: https://github.com/cloudfoundry/smb-volume-release/)",
+	Tags:          []string{"smb"},
+	Driver:        "smbdriver",
+	ValidateShare: validateSMBShare,
+	MountOptions: []string{
+		"username", "password", "domain", "uid", "gid", "file_mode", "dir_mode", "readonly", "ro", "mfsymlinks",
+		"noserverino", "forceuid", "forcegid", "nodfs", "sec",
+	},
+	BindCredentials: []BindCredential{
+		{Name: "username", Description: "The user to mount the share as", Required: true},
+		{Name: "password", Description: "The password of username; never stored", Required: true},
+		{Name: "domain", Description: "The Windows domain of username"},
+	},
+}
+
+var shareTypes = map[string]ShareType{}
+
+func init() {
+	RegisterShareType(NFSShareType)
+	RegisterShareType(CephFSShareType)
+	RegisterShareType(SMBShareType)
+}
+
+// RegisterShareType makes a share type available to LookupShareType, replacing any registered under the same name.
+func RegisterShareType(shareType ShareType) {
+	shareTypes[shareType.Name] = shareType
+}
+
+func LookupShareType(name string) (ShareType, error) {
+	shareType, ok := shareTypes[name]
+	if !ok {
+		names := []string{}
+		for n := range shareTypes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return ShareType{}, fmt.Errorf("unknown share type %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return shareType, nil
+}
+
+// nfsShareForm is how NFS shares are written, for error messages.
+const nfsShareForm = "server:/path or nfs://server/path, with any options after ?, such as server:/export?version=4.1"
+
+// validateNFSShare accepts shares of the form "server:/path", "server/path" or "nfs://server/path", with options in a
+// query string, where server is a host name or IP address and path is a clean absolute path.  Mount options given as
+// they would be to mount, such as "server:/export -o vers=3" or "server:/export,ro", are refused rather than left
+// for the driver to fail on when the app starts.
+func validateNFSShare(share string) error {
+	if strings.IndexFunc(share, unicode.IsSpace) >= 0 {
+		if strings.Contains(share, " -o ") {
+			return fmt.Errorf("share %q has mount options after -o; give them as bind parameters or share options, as in %s", share, nfsShareForm)
+		}
+		return fmt.Errorf("share %q contains whitespace; expected %s", share, nfsShareForm)
+	}
+	if i := strings.Index(share, "://"); i >= 0 && !strings.HasPrefix(share, "nfs://") {
+		return fmt.Errorf("share %q has scheme %q; expected %s", share, share[:i], nfsShareForm)
+	}
+	if strings.HasPrefix(share, "//") {
+		return fmt.Errorf("share %q is written as an SMB share; expected %s", share, nfsShareForm)
+	}
+
+	components, err := ParseShare(share)
+	if err != nil {
+		return fmt.Errorf("%w; expected %s", err, nfsShareForm)
+	}
+	if err := validateShareServer(components.Server); err != nil {
+		return fmt.Errorf("share %q: %w", share, err)
+	}
+	if err := validateSharePath(components.Path); err != nil {
+		return fmt.Errorf("share %q: %w", share, err)
+	}
+	return nil
+}
+
+// validateShareServer accepts IPv4 addresses, IPv6 addresses in brackets and host names.
+func validateShareServer(server string) error {
+	if strings.HasPrefix(server, "[") && strings.HasSuffix(server, "]") {
+		if ip := net.ParseIP(server[1 : len(server)-1]); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("server %q is not a valid IPv6 address", server)
+		}
+		return nil
+	}
+	if strings.Contains(server, ":") {
+		if net.ParseIP(server) != nil {
+			return fmt.Errorf("server %q is an IPv6 address, which must be written in brackets, as in [%s]:/export", server, server)
+		}
+		return fmt.Errorf("server %q has a port, which NFS shares cannot give; give the server without one", server)
+	}
+	if net.ParseIP(server) != nil {
+		return nil
+	}
+	if strings.Trim(server, "0123456789.") == "" {
+		return fmt.Errorf("server %q is not a valid IP address", server)
+	}
+	if len(server) > 253 {
+		return fmt.Errorf("server %q is longer than 253 characters", server)
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(server, "."), ".") {
+		if !validHostLabel(label) {
+			return fmt.Errorf("server %q is not a valid host name: labels must be 1 to 63 letters, digits or hyphens, and cannot start or end with a hyphen", server)
+		}
+	}
+	return nil
+}
+
+func validHostLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, c := range label {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// validateSharePath accepts absolute paths without empty, . or .. elements or a trailing slash, other than "/".
+func validateSharePath(sharePath string) error {
+	if strings.ContainsRune(sharePath, ',') {
+		return fmt.Errorf("path %q contains a comma; give mount options as bind parameters or share options, as in %s", sharePath, nfsShareForm)
+	}
+	if strings.IndexFunc(sharePath, unicode.IsControl) >= 0 {
+		return fmt.Errorf("path %q contains control characters", sharePath)
+	}
+	if sharePath != "/" && strings.HasSuffix(sharePath, "/") {
+		return fmt.Errorf("path %q has a trailing slash; give it as %s", sharePath, strings.TrimRight(sharePath, "/"))
+	}
+	if path.Clean(sharePath) != sharePath {
+		return fmt.Errorf("path %q is not clean; give it as %s", sharePath, path.Clean(sharePath))
+	}
+	return nil
+}
+
+// validateSMBShare accepts shares of the form "//server/share", optionally followed by a path within the share, where
+// server is a host name or IP address.  Share names and paths may contain spaces.
+func validateSMBShare(share string) error {
+	if strings.HasPrefix(share, `\\`) {
+		return fmt.Errorf("share %q must be written with forward slashes, as in %s", share, strings.Replace(share, `\`, "/", -1))
+	}
+	if !strings.HasPrefix(share, "//") {
+		return fmt.Errorf("share %q must be of the form //server/share", share)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(share, "//"), "/", 2)
+	if len(parts) != 2 || strings.Trim(parts[1], "/") == "" {
+		return fmt.Errorf("share %q has no share name; expected //server/share", share)
+	}
+	if err := validateShareServer(parts[0]); err != nil {
+		return fmt.Errorf("share %q: %w", share, err)
+	}
+	if strings.HasSuffix(parts[1], "/") {
+		return fmt.Errorf("share %q has a trailing slash; give it as //%s/%s", share, parts[0], strings.TrimRight(parts[1], "/"))
+	}
+	if strings.IndexFunc(parts[1], unicode.IsControl) >= 0 || strings.Contains(parts[1], "//") {
+		return fmt.Errorf("share %q has an invalid share name or path", share)
+	}
+	return nil
+}
+
+// validateCephFSShare accepts shares of the form "mon1[:port][,mon2[:port]...]:/path".
+func validateCephFSShare(share string) error {
+	i := strings.Index(share, ":/")
+	if i < 0 {
+		return fmt.Errorf("share %q must be of the form monitors:/path", share)
+	}
+	for _, monitor := range strings.Split(share[:i], ",") {
+		if monitor == "" || strings.ContainsAny(monitor, " /") {
+			return fmt.Errorf("share %q has an invalid monitor address %q", share, monitor)
+		}
+	}
+	return nil
+}
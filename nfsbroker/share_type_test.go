@@ -0,0 +1,127 @@
+package nfsbroker_test
+
+import (
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ShareType", func() {
+	It("looks up the built in share types", func() {
+		shareType, err := nfsbroker.LookupShareType("nfs")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(shareType.Driver).To(Equal("nfsv3driver"))
+
+		shareType, err = nfsbroker.LookupShareType("cephfs")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(shareType.Driver).To(Equal("cephdriver"))
+
+		shareType, err = nfsbroker.LookupShareType("smb")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(shareType.Driver).To(Equal("smbdriver"))
+	})
+
+	It("rejects unknown share types", func() {
+		_, err := nfsbroker.LookupShareType("afs")
+		Expect(err).To(MatchError(ContainSubstring("expected one of cephfs, nfs, smb")))
+	})
+
+	It("looks up registered share types", func() {
+		nfsbroker.RegisterShareType(nfsbroker.ShareType{Name: "glusterfs", Driver: "glusterdriver"})
+		shareType, err := nfsbroker.LookupShareType("glusterfs")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(shareType.Driver).To(Equal("glusterdriver"))
+	})
+
+	Describe("NFS share validation", func() {
+		validate := nfsbroker.NFSShareType.ValidateShare
+
+		It("accepts servers and paths in each form", func() {
+			for _, share := range []string{
+				"server:/export",
+				"server/export",
+				"nfs://server/export",
+				"nfs://server:/export",
+				"nfs.example.com:/exports/data",
+				"nfs.example.com.:/exports/data",
+				"192.168.1.5:/export",
+				"[fd00::5]:/export",
+				"server:/",
+				"server:/export?version=4.1",
+			} {
+				Expect(validate(share)).To(Succeed(), share)
+			}
+		})
+
+		It("rejects shares without a server or path", func() {
+			Expect(validate("server-without-a-path")).To(MatchError(ContainSubstring("has no export path; expected server:/path")))
+			Expect(validate(":/export")).To(MatchError(ContainSubstring("has no server")))
+		})
+
+		It("rejects other schemes", func() {
+			Expect(validate("smb://server/export")).To(MatchError(ContainSubstring(`has scheme "smb"`)))
+		})
+
+		It("rejects invalid servers", func() {
+			Expect(validate("my_server:/export")).To(MatchError(ContainSubstring("not a valid host name")))
+			Expect(validate("-server:/export")).To(MatchError(ContainSubstring("not a valid host name")))
+			Expect(validate("server..example.com:/export")).To(MatchError(ContainSubstring("not a valid host name")))
+			Expect(validate("192.168.1.500:/export")).To(MatchError(ContainSubstring("not a valid IP address")))
+			Expect(validate("server:2049:/export")).To(MatchError(ContainSubstring("has a port")))
+			Expect(validate("[192.168.1.5]:/export")).To(MatchError(ContainSubstring("not a valid IPv6 address")))
+		})
+
+		It("rejects trailing slashes and paths that are not clean", func() {
+			Expect(validate("server:/export/")).To(MatchError(ContainSubstring("has a trailing slash; give it as /export")))
+			Expect(validate("server:/exports//data")).To(MatchError(ContainSubstring("give it as /exports/data")))
+			Expect(validate("server:/exports/./data")).To(MatchError(ContainSubstring("not clean")))
+		})
+
+		It("rejects embedded mount options", func() {
+			Expect(validate("server:/export -o vers=3")).To(MatchError(ContainSubstring("mount options after -o")))
+			Expect(validate("server:/export,ro")).To(MatchError(ContainSubstring("contains a comma")))
+			Expect(validate("server:/my export")).To(MatchError(ContainSubstring("contains whitespace")))
+		})
+	})
+
+	Describe("SMB share validation", func() {
+		validate := nfsbroker.SMBShareType.ValidateShare
+
+		It("accepts servers, share names and paths", func() {
+			Expect(validate("//server/share")).To(Succeed())
+			Expect(validate("//filer.example.com/Team Share/reports")).To(Succeed())
+			Expect(validate("//192.168.1.5/share")).To(Succeed())
+		})
+
+		It("rejects shares without a server or share name", func() {
+			Expect(validate("server/share")).To(MatchError(ContainSubstring("must be of the form //server/share")))
+			Expect(validate("//server")).To(MatchError(ContainSubstring("has no share name")))
+			Expect(validate("//server/")).To(MatchError(ContainSubstring("has no share name")))
+			Expect(validate("//bad_server/share")).To(MatchError(ContainSubstring("not a valid host name")))
+		})
+
+		It("asks for forward slashes", func() {
+			Expect(validate(`\\server\share`)).To(MatchError(ContainSubstring("as in //server/share")))
+		})
+
+		It("rejects trailing slashes", func() {
+			Expect(validate("//server/share/")).To(MatchError(ContainSubstring("give it as //server/share")))
+		})
+	})
+
+	Describe("CephFS share validation", func() {
+		validate := nfsbroker.CephFSShareType.ValidateShare
+
+		It("accepts monitors and a path", func() {
+			Expect(validate("mon1:6789,mon2:6789:/volumes/vol1")).To(Succeed())
+		})
+
+		It("rejects shares without a path", func() {
+			Expect(validate("mon1,mon2")).To(MatchError(ContainSubstring("monitors:/path")))
+		})
+
+		It("rejects empty monitor addresses", func() {
+			Expect(validate("mon1,,mon2:/volumes/vol1")).To(MatchError(ContainSubstring("invalid monitor address")))
+		})
+	})
+})
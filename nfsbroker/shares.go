@@ -0,0 +1,192 @@
+package nfsbroker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+const (
+	// DefaultShareName names an instance's main share, the one given by the share parameter, when bindings select
+	// the share to mount.
+	DefaultShareName = "default"
+	// AllSharesName selects every share of an instance.
+	AllSharesName = "all"
+
+	maxNamedShares = 16
+)
+
+// shareNamePattern allows names such as "data" or "config-v2", which also make up the container directories of
+// bindings that mount every share.
+var shareNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9_-]{0,61}[a-z0-9])?$`)
+
+// provisionShares reads the shares provision parameter, which must be an object of strings naming the instance's
+// shares besides its main one.
+func provisionShares(parameters map[string]interface{}) (map[string]string, error) {
+	value, ok := parameters["shares"].(map[string]interface{})
+	if !ok || len(value) == 0 {
+		return nil, nil
+	}
+
+	shares := map[string]string{}
+	for name, value := range value {
+		share, ok := value.(string)
+		if !ok || share == "" {
+			return nil, invalidShares(fmt.Errorf("share %q must be a non-empty string", name))
+		}
+		shares[name] = share
+	}
+	return shares, validateShareNames(shares)
+}
+
+// mergeShares applies changes to named shares, removing those changed to nil.  The result is nil when no named
+// shares are left.
+func mergeShares(shares map[string]string, changes map[string]*string) (map[string]string, error) {
+	merged := map[string]string{}
+	for name, share := range shares {
+		merged[name] = share
+	}
+	for name, share := range changes {
+		if share == nil {
+			delete(merged, name)
+		} else {
+			merged[name] = *share
+		}
+	}
+	if err := validateShareNames(merged); err != nil {
+		return nil, err
+	}
+	if len(merged) == 0 {
+		return nil, nil
+	}
+	return merged, nil
+}
+
+func validateShareNames(shares map[string]string) error {
+	if len(shares) > maxNamedShares {
+		return invalidShares(fmt.Errorf("an instance can have at most %d shares besides its main one", maxNamedShares))
+	}
+	for _, name := range sortedShareNames(shares) {
+		if !shareNamePattern.MatchString(name) || name == DefaultShareName || name == AllSharesName {
+			return invalidShares(fmt.Errorf("%q is not a valid share name", name))
+		}
+		if shares[name] == "" {
+			return invalidShares(fmt.Errorf("share %q must be a non-empty string", name))
+		}
+	}
+	return nil
+}
+
+func invalidShares(err error) error {
+	return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "invalid-shares")
+}
+
+func sortedShareNames(shares map[string]string) []string {
+	names := make([]string, 0, len(shares))
+	for name := range shares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completeShares fills in the default server of named shares given without one and validates them.
+func (b *Broker) completeShares(ctx context.Context, logger lager.Logger, shareType ShareType, shares map[string]string, orgGUID string) (map[string]string, error) {
+	if len(shares) == 0 {
+		return nil, nil
+	}
+	completed := map[string]string{}
+	for _, name := range sortedShareNames(shares) {
+		share, err := b.completeShare(ctx, logger, shareType, shares[name], orgGUID)
+		if err != nil {
+			return nil, err
+		}
+		completed[name] = share
+	}
+	return completed, nil
+}
+
+// namedShare is one share of an instance, with the instance's details as if it were the instance's only share.
+type namedShare struct {
+	name    string
+	details ServiceInstance
+}
+
+// eachShare returns the instance's main share followed by its named shares in name order.
+func (s ServiceInstance) eachShare() []namedShare {
+	shares := []namedShare{{name: DefaultShareName, details: withShareComponents(s)}}
+	for _, name := range sortedShareNames(s.Shares) {
+		details := s
+		details.Shares = nil
+		if err := details.setShare(s.Shares[name]); err != nil {
+			details.ShareServer, details.SharePath, details.ShareVersion, details.ShareOptions = "", "", "", nil
+		}
+		shares = append(shares, namedShare{name: name, details: details})
+	}
+	return shares
+}
+
+// boundShares returns the shares a binding with the given parameters mounts: the main share unless the share_name
+// parameter names another share, or every share if it is "all".
+func (s ServiceInstance) boundShares(parameters map[string]interface{}) ([]namedShare, error) {
+	name, _ := parameters["share_name"].(string)
+	shares := s.eachShare()
+	switch name {
+	case "", DefaultShareName:
+		return shares[:1], nil
+	case AllSharesName:
+		return shares, nil
+	}
+	for _, share := range shares {
+		if share.name == name {
+			return []namedShare{share}, nil
+		}
+	}
+	err := fmt.Errorf("the service instance has no share named %q", name)
+	return nil, brokerapi.NewFailureResponse(err, http.StatusBadRequest, "unknown-share")
+}
+
+// checkShares checks the options and NFS version of each of an instance's shares, and that its organization is
+// entitled to them.
+func (b *Broker) checkShares(ctx context.Context, logger lager.Logger, details ServiceInstance) error {
+	for _, share := range details.eachShare() {
+		if err := b.checkShareOptions(share.details); err != nil {
+			return err
+		}
+		if err := b.checkNFSVersion(details.PlanID, share.details.nfsVersion()); err != nil {
+			return err
+		}
+		if err := b.checkEntitlement(ctx, logger, share.details); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// volumeMounts builds the volume mounts of a binding: one for each share it mounts.  When a binding mounts every
+// share, the named shares are mounted next to the main one, in its container directory followed by "-" and the
+// share's name.
+func (b *Broker) volumeMounts(logger lager.Logger, instanceID, bindingID string, instanceDetails ServiceInstance, mode string, parameters map[string]interface{}) ([]brokerapi.VolumeMount, error) {
+	shares, err := instanceDetails.boundShares(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts := []brokerapi.VolumeMount{}
+	for i, share := range shares {
+		mount, err := b.volumeMount(logger, instanceID, bindingID, share.details, mode, parameters)
+		if err != nil {
+			return nil, brokerapi.NewFailureResponse(err, http.StatusBadRequest, "invalid-mount-options")
+		}
+		if len(shares) > 1 && i > 0 {
+			mount.ContainerDir += "-" + share.name
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts, nil
+}
@@ -0,0 +1,135 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Multiple shares", func() {
+	var (
+		broker *nfsbroker.Broker
+		store  nfsbroker.Store
+		ctx    context.Context
+	)
+
+	provision := func(parameters string) error {
+		_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+			ServiceID:        "service-id",
+			PlanID:           "Existing",
+			OrganizationGUID: "org-guid",
+			RawParameters:    json.RawMessage(parameters),
+		}, false)
+		return err
+	}
+
+	bind := func(parameters map[string]interface{}) ([]brokerapi.VolumeMount, error) {
+		binding, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid", Parameters: parameters})
+		return binding.VolumeMounts, err
+	}
+
+	sources := func(mounts []brokerapi.VolumeMount) []interface{} {
+		sources := []interface{}{}
+		for _, mount := range mounts {
+			sources = append(sources, mount.Device.MountConfig["source"])
+		}
+		return sources
+	}
+
+	BeforeEach(func() {
+		store = nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-shares"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, store, nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		ctx = context.Background()
+	})
+
+	Context("when an instance is provisioned with further shares", func() {
+		BeforeEach(func() {
+			Expect(provision(`{"share":"filer:/exports/data","shares":{"config":"filer:/exports/config","logs":"other-filer:/logs"}}`)).To(Succeed())
+		})
+
+		It("stores them on the instance", func() {
+			details, err := store.RetrieveInstanceDetails(ctx, "instance-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(details.Share).To(Equal("filer:/exports/data"))
+			Expect(details.Shares).To(Equal(map[string]string{"config": "filer:/exports/config", "logs": "other-filer:/logs"}))
+
+			spec, err := broker.GetInstance(ctx, "instance-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.Parameters).To(HaveKeyWithValue("shares", details.Shares))
+		})
+
+		It("mounts the main share by default", func() {
+			mounts, err := bind(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sources(mounts)).To(Equal([]interface{}{"nfs://filer:/exports/data"}))
+		})
+
+		It("mounts the share a binding names", func() {
+			mounts, err := bind(map[string]interface{}{"share_name": "config", "mount": "/config"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sources(mounts)).To(Equal([]interface{}{"nfs://filer:/exports/config"}))
+			Expect(mounts[0].ContainerDir).To(Equal("/config"))
+		})
+
+		It("mounts every share next to each other", func() {
+			mounts, err := bind(map[string]interface{}{"share_name": "all", "mount": "/data", "readonly": true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sources(mounts)).To(Equal([]interface{}{"nfs://filer:/exports/data", "nfs://filer:/exports/config", "nfs://other-filer:/logs"}))
+			Expect(mounts[0].ContainerDir).To(Equal("/data"))
+			Expect(mounts[1].ContainerDir).To(Equal("/data-config"))
+			Expect(mounts[2].ContainerDir).To(Equal("/data-logs"))
+			Expect(mounts[0].Device.VolumeId).NotTo(Equal(mounts[1].Device.VolumeId))
+			for _, mount := range mounts {
+				Expect(mount.Mode).To(Equal("r"))
+			}
+
+			spec, err := broker.GetBinding(ctx, "instance-id", "binding-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.VolumeMounts).To(Equal(mounts))
+		})
+
+		It("refuses to mount shares the instance does not have", func() {
+			_, err := bind(map[string]interface{}{"share_name": "missing"})
+			Expect(err).To(MatchError(`the service instance has no share named "missing"`))
+			Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusBadRequest))
+		})
+
+		It("lets updates add, change and remove shares", func() {
+			_, err := broker.Update(ctx, "instance-id", brokerapi.UpdateDetails{
+				RawParameters: json.RawMessage(`{"shares":{"config":"filer:/exports/config-v2","logs":null,"scratch":"filer:/scratch"}}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			details, err := store.RetrieveInstanceDetails(ctx, "instance-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(details.Shares).To(Equal(map[string]string{"config": "filer:/exports/config-v2", "scratch": "filer:/scratch"}))
+		})
+
+		It("checks every share against reservations", func() {
+			_, err := broker.ReserveShares(ctx, "/scratch", "other-org-guid")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = broker.Update(ctx, "instance-id", brokerapi.UpdateDetails{
+				RawParameters: json.RawMessage(`{"shares":{"scratch":"filer:/scratch"}}`),
+			}, false)
+			Expect(err).To(MatchError(ContainSubstring("reserved for another organization")))
+		})
+	})
+
+	It("refuses invalid share names", func() {
+		err := provision(`{"share":"filer:/exports/data","shares":{"all":"filer:/exports/config"}}`)
+		Expect(err).To(MatchError(`"all" is not a valid share name`))
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusBadRequest))
+
+		Expect(provision(`{"share":"filer:/exports/data","shares":{"Config":"filer:/exports/config"}}`)).To(MatchError(ContainSubstring("not a valid share name")))
+		Expect(provision(`{"share":"filer:/exports/data","shares":{"config":7}}`)).To(MatchError(`share "config" must be a non-empty string`))
+	})
+})
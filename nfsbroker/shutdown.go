@@ -0,0 +1,140 @@
+package nfsbroker
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// DefaultDrainTimeout bounds how long a broker that is shutting down waits for in-flight requests and asynchronous
+// operations, which is less than the time BOSH gives jobs to stop.
+const DefaultDrainTimeout = 30 * time.Second
+
+func (b *Broker) runInBackground(operation func()) {
+	b.operations.Add(1)
+	go func() {
+		defer b.operations.Done()
+		operation()
+	}()
+}
+
+// Drain waits for the broker's asynchronous operations to finish, then saves its store.  The store is saved even when
+// ctx is done first, in which case the unfinished operations are left in progress and Drain returns ctx's error.
+func (b *Broker) Drain(ctx context.Context) error {
+	logger := b.logger.Session("drain")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	finished := make(chan struct{})
+	go func() {
+		b.operations.Wait()
+		close(finished)
+	}()
+	var drainErr error
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		drainErr = ctx.Err()
+		logger.Error("gave-up-waiting-for-operations", drainErr)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err := b.store.Save(logger); err != nil {
+		return err
+	}
+	return drainErr
+}
+
+// APIServer serves the broker API until it is signalled, then shuts down gracefully: it stops accepting connections,
+// waits for in-flight requests and then for the brokers' asynchronous operations to finish, saves the brokers' stores,
+// and finally closes its closers, such as database connection pools.  The drain timeout bounds the wait for requests
+// and operations together; stores are saved and closed even once it runs out.
+type APIServer struct {
+	logger       lager.Logger
+	address      string
+	handler      http.Handler
+	tlsConfig    *tls.Config
+	drainTimeout time.Duration
+	brokers      []*Broker
+	closers      []io.Closer
+}
+
+// NewAPIServer serves handler on address, over TLS when tlsConfig is given.
+func NewAPIServer(logger lager.Logger, address string, handler http.Handler, tlsConfig *tls.Config, drainTimeout time.Duration, brokers []*Broker, closers ...io.Closer) *APIServer {
+	return &APIServer{
+		logger:       logger,
+		address:      address,
+		handler:      handler,
+		tlsConfig:    tlsConfig,
+		drainTimeout: drainTimeout,
+		brokers:      brokers,
+		closers:      closers,
+	}
+}
+
+func (s *APIServer) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+
+	server := &http.Server{Handler: s.handler}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	close(ready)
+
+	select {
+	case err := <-served:
+		s.close()
+		return err
+	case <-signals:
+		return s.shutdown(server)
+	}
+}
+
+func (s *APIServer) shutdown(server *http.Server) error {
+	logger := s.logger.Session("shutdown", lager.Data{"drainTimeout": s.drainTimeout.String()})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.drainTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("gave-up-waiting-for-requests", err)
+		server.Close()
+	}
+
+	var drainErr error
+	for _, broker := range s.brokers {
+		if err := broker.Drain(ctx); err != nil && drainErr == nil {
+			drainErr = err
+		}
+	}
+	if drainErr != nil {
+		logger.Error("failed-to-drain", drainErr)
+	}
+
+	s.close()
+	return nil
+}
+
+func (s *APIServer) close() {
+	for _, closer := range s.closers {
+		if err := closer.Close(); err != nil {
+			s.logger.Error("failed-to-close", err)
+		}
+	}
+}
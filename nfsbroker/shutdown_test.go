@@ -0,0 +1,153 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/tedsuo/ifrit"
+)
+
+type fakeCloser struct {
+	closed chan struct{}
+}
+
+func (c *fakeCloser) Close() error {
+	close(c.closed)
+	return nil
+}
+
+var _ = Describe("Shutdown", func() {
+	var (
+		broker    *nfsbroker.Broker
+		fakeStore *nfsbrokerfakes.FakeStore
+		released  chan struct{}
+		release   func()
+	)
+
+	BeforeEach(func() {
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		fakeStore.RetrieveInstanceDetailsReturns(nfsbroker.ServiceInstance{Share: "server:/some-share"}, nil)
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-shutdown"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, fakeStore, nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+
+		// each spec's deprovision waits on its own channel, since it may outlive the spec
+		spec := make(chan struct{})
+		var once sync.Once
+		released = spec
+		release = func() { once.Do(func() { close(spec) }) }
+		broker.SetDeprovisionSteps(func(context.Context, string, nfsbroker.ServiceInstance) error {
+			<-spec
+			return nil
+		})
+		_, err := broker.Deprovision(context.TODO(), "instance-id", brokerapi.DeprovisionDetails{}, true)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		release()
+		Expect(broker.Drain(context.Background())).To(Succeed())
+	})
+
+	Describe("Drain", func() {
+		It("waits for asynchronous operations to finish, then saves the store", func() {
+			saves := fakeStore.SaveCallCount()
+			drained := make(chan error, 1)
+			go func() {
+				drained <- broker.Drain(context.Background())
+			}()
+			Consistently(drained).ShouldNot(Receive())
+
+			release()
+			Eventually(drained).Should(Receive(BeNil()))
+			Expect(fakeStore.DeleteInstanceDetailsCallCount()).To(Equal(1))
+			Expect(fakeStore.SaveCallCount()).To(BeNumerically(">=", saves+2))
+		})
+
+		It("saves the store even when it gives up waiting", func() {
+			saves := fakeStore.SaveCallCount()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			Expect(broker.Drain(ctx)).To(MatchError(context.DeadlineExceeded))
+			Expect(fakeStore.SaveCallCount()).To(Equal(saves + 1))
+			Expect(fakeStore.DeleteInstanceDetailsCallCount()).To(Equal(0))
+		})
+	})
+
+	Describe("APIServer", func() {
+		var (
+			address      string
+			drainTimeout time.Duration
+			requests     chan struct{}
+			closer       *fakeCloser
+			process      ifrit.Process
+		)
+
+		BeforeEach(func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			address = listener.Addr().String()
+			listener.Close()
+
+			drainTimeout = time.Minute
+			requests = make(chan struct{}, 1)
+			closer = &fakeCloser{closed: make(chan struct{})}
+		})
+
+		JustBeforeEach(func() {
+			requests, released := requests, released
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests <- struct{}{}
+				<-released
+			})
+			server := nfsbroker.NewAPIServer(lagertest.NewTestLogger("test-api-server"), address, handler, nil, drainTimeout, []*nfsbroker.Broker{broker}, closer)
+			process = ifrit.Invoke(server)
+
+			go func() {
+				if response, err := http.Get("http://" + address); err == nil {
+					response.Body.Close()
+				}
+			}()
+			Eventually(requests).Should(Receive())
+		})
+
+		It("stops accepting connections, finishes in-flight requests and operations, then closes its closers", func() {
+			process.Signal(os.Interrupt)
+			Eventually(func() error {
+				_, err := net.Dial("tcp", address)
+				return err
+			}).Should(HaveOccurred())
+			Consistently(process.Wait()).ShouldNot(Receive())
+			Expect(closer.closed).NotTo(BeClosed())
+
+			release()
+			Eventually(process.Wait(), 5*time.Second).Should(Receive(BeNil()))
+			Expect(closer.closed).To(BeClosed())
+			Expect(fakeStore.DeleteInstanceDetailsCallCount()).To(Equal(1))
+		})
+
+		Context("when the drain timeout runs out", func() {
+			BeforeEach(func() {
+				drainTimeout = 100 * time.Millisecond
+			})
+
+			It("gives up on requests and operations, and closes its closers all the same", func() {
+				process.Signal(os.Interrupt)
+				Eventually(process.Wait(), 5*time.Second).Should(Receive(BeNil()))
+				Expect(closer.closed).To(BeClosed())
+				Expect(fakeStore.DeleteInstanceDetailsCallCount()).To(Equal(0))
+			})
+		})
+	})
+})
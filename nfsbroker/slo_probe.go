@@ -0,0 +1,247 @@
+package nfsbroker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/nfsbroker/scheduler"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+const (
+	SLOProbeJob = "slo-probe"
+
+	// ProbePlanID is the plan of the instances the SLO probe provisions.  It is not offered in the catalog, and only
+	// the probe itself can provision it.
+	ProbePlanID = "nfsbroker-slo-probe"
+
+	// ProbeGUID is the organization, space and app GUID of the probe's instances and bindings.
+	ProbeGUID = "slo-probe"
+
+	// sloProbeWindow is the number of recent probes the SLO report covers.
+	sloProbeWindow = 100
+)
+
+// probeShare is the share of probe instances.  Nothing ever mounts it.
+const probeShare = "slo-probe.invalid:/probe"
+
+type probeKey struct{}
+
+// isProbe reports whether a request was made by the SLO probe.  Probe requests go through the same store and
+// validation as any other, but never reach share provisioners, the entitlement service or the cloud controller.
+func isProbe(ctx context.Context) bool {
+	probe, _ := ctx.Value(probeKey{}).(bool)
+	return probe
+}
+
+// ProbeResult is the outcome of one provision, bind, unbind and deprovision cycle.
+type ProbeResult struct {
+	Time            time.Time          `json:"time"`
+	LatencySeconds  float64            `json:"latency_seconds"`
+	StepSeconds     map[string]float64 `json:"step_seconds"`
+	WithinObjective bool               `json:"within_objective"`
+	Error           string             `json:"error,omitempty"`
+}
+
+// SLOReport summarizes the most recent probes.  Latency percentiles only count probes that succeeded, while
+// WithinObjective is the fraction of all probes that succeeded within the objective.
+type SLOReport struct {
+	ObjectiveSeconds float64      `json:"objective_seconds"`
+	Probes           int          `json:"probes"`
+	Failures         int          `json:"failures"`
+	WithinObjective  float64      `json:"within_objective"`
+	LatencyP50       float64      `json:"latency_p50_seconds"`
+	LatencyP95       float64      `json:"latency_p95_seconds"`
+	LatencyP99       float64      `json:"latency_p99_seconds"`
+	Last             *ProbeResult `json:"last,omitempty"`
+}
+
+type sloProbe struct {
+	objective time.Duration
+
+	mutex   sync.Mutex
+	results []ProbeResult
+}
+
+// SetSLOObjective enables the SLO probe, which measures how long a provision, bind, unbind and deprovision cycle
+// takes and reports how often it finishes within objective.
+func (b *Broker) SetSLOObjective(objective time.Duration) {
+	b.sloProbe = &sloProbe{objective: objective}
+}
+
+// SLOProbe returns a scheduler job that runs the SLO probe every interval.
+func (b *Broker) SLOProbe(interval time.Duration) scheduler.Job {
+	return scheduler.Job{
+		Name:     SLOProbeJob,
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			result := b.RunProbe(ctx)
+			if result.Error != "" {
+				return fmt.Errorf("slo probe failed: %s", result.Error)
+			}
+			return nil
+		},
+	}
+}
+
+// RunProbe provisions, binds, unbinds and deprovisions an instance of the probe plan, and records how long each step
+// took.  If a step fails the probe still tries to remove what it created.
+func (b *Broker) RunProbe(ctx context.Context) ProbeResult {
+	logger := b.logger.Session("slo-probe")
+	ctx = context.WithValue(WithRequestIdentity(ctx, ProbeGUID, ""), probeKey{}, true)
+
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	instanceID := "slo-probe-" + hex.EncodeToString(suffix)
+	bindingID := instanceID + "-binding"
+	parameters, _ := json.Marshal(map[string]interface{}{"share": probeShare})
+
+	start := time.Now()
+	result := ProbeResult{Time: start, StepSeconds: map[string]float64{}}
+	step := func(name string, run func() error) error {
+		stepStart := time.Now()
+		err := run()
+		result.StepSeconds[name] = time.Since(stepStart).Seconds()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	}
+
+	provisioned, bound := false, false
+	err := step("provision", func() error {
+		_, err := b.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+			ServiceID:        b.static.ServiceId,
+			PlanID:           ProbePlanID,
+			OrganizationGUID: ProbeGUID,
+			SpaceGUID:        ProbeGUID,
+			RawParameters:    parameters,
+		}, false)
+		provisioned = err == nil
+		return err
+	})
+	if err == nil {
+		err = step("bind", func() error {
+			_, err := b.Bind(ctx, instanceID, bindingID, brokerapi.BindDetails{
+				AppGUID:   ProbeGUID,
+				PlanID:    ProbePlanID,
+				ServiceID: b.static.ServiceId,
+			})
+			bound = err == nil
+			return err
+		})
+	}
+	if err == nil {
+		err = step("unbind", func() error {
+			bound = false
+			return b.Unbind(ctx, instanceID, bindingID, brokerapi.UnbindDetails{PlanID: ProbePlanID, ServiceID: b.static.ServiceId})
+		})
+	}
+	if err == nil {
+		err = step("deprovision", func() error {
+			provisioned = false
+			_, err := b.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{PlanID: ProbePlanID, ServiceID: b.static.ServiceId}, false)
+			return err
+		})
+	}
+	result.LatencySeconds = time.Since(start).Seconds()
+
+	if err != nil {
+		result.Error = err.Error()
+		logger.Error("probe-failed", err, lager.Data{"instanceID": instanceID})
+		b.cleanUpProbe(ctx, logger, instanceID, bindingID, provisioned, bound)
+	}
+
+	if b.sloProbe != nil {
+		result.WithinObjective = err == nil && time.Since(start) <= b.sloProbe.objective
+		b.sloProbe.record(result)
+	}
+	logger.Info("probe-completed", lager.Data{
+		"latency_seconds":  result.LatencySeconds,
+		"step_seconds":     result.StepSeconds,
+		"within_objective": result.WithinObjective,
+	})
+	return result
+}
+
+func (b *Broker) cleanUpProbe(ctx context.Context, logger lager.Logger, instanceID, bindingID string, provisioned, bound bool) {
+	if bound {
+		if err := b.Unbind(ctx, instanceID, bindingID, brokerapi.UnbindDetails{}); err != nil {
+			logger.Error("failed-to-unbind-probe-binding", err, lager.Data{"bindingID": bindingID})
+		}
+	}
+	if provisioned {
+		if _, err := b.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{}, false); err != nil {
+			logger.Error("failed-to-deprovision-probe-instance", err, lager.Data{"instanceID": instanceID})
+		}
+	}
+}
+
+func (p *sloProbe) record(result ProbeResult) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.results = append(p.results, result)
+	if len(p.results) > sloProbeWindow {
+		p.results = p.results[len(p.results)-sloProbeWindow:]
+	}
+}
+
+// SLOReport summarizes the recent probes.  It returns false when the probe is not enabled.
+func (b *Broker) SLOReport() (SLOReport, bool) {
+	if b.sloProbe == nil {
+		return SLOReport{}, false
+	}
+	return b.sloProbe.report(), true
+}
+
+func (p *sloProbe) report() SLOReport {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	report := SLOReport{ObjectiveSeconds: p.objective.Seconds(), Probes: len(p.results)}
+	if len(p.results) == 0 {
+		return report
+	}
+
+	latencies := []float64{}
+	within := 0
+	for _, result := range p.results {
+		if result.Error != "" {
+			report.Failures++
+			continue
+		}
+		latencies = append(latencies, result.LatencySeconds)
+		if result.WithinObjective {
+			within++
+		}
+	}
+	report.WithinObjective = float64(within) / float64(len(p.results))
+	sort.Float64s(latencies)
+	report.LatencyP50 = percentile(latencies, 0.50)
+	report.LatencyP95 = percentile(latencies, 0.95)
+	report.LatencyP99 = percentile(latencies, 0.99)
+	last := p.results[len(p.results)-1]
+	report.Last = &last
+	return report
+}
+
+// percentile returns the nearest-rank percentile of sorted values, or zero when there are none.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
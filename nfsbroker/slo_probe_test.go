@@ -0,0 +1,148 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/goshims/ioutilshim/ioutil_fake"
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("SLO probe", func() {
+	var (
+		logger        *lagertest.TestLogger
+		store         nfsbroker.Store
+		broker        *nfsbroker.Broker
+		stepsRun      int
+		fakeChecker   *nfsbrokerfakes.FakeEntitlementChecker
+		probeResult   nfsbroker.ProbeResult
+		probeProvider func() nfsbroker.ProbeResult
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-slo-probe")
+		store = nfsbroker.NewFileStore("/tmp/whatever", &ioutil_fake.FakeIoutil{}, nfsbroker.DefaultMaxValueSize)
+		broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, store,
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		broker.SetSLOObjective(time.Minute)
+
+		stepsRun = 0
+		broker.SetProvisionSteps(func(context.Context, string, nfsbroker.ServiceInstance) error {
+			stepsRun++
+			return nil
+		})
+		broker.SetDeprovisionSteps(func(context.Context, string, nfsbroker.ServiceInstance) error {
+			stepsRun++
+			return nil
+		})
+		fakeChecker = &nfsbrokerfakes.FakeEntitlementChecker{}
+		fakeChecker.EntitledReturns(true, nil)
+		broker.SetEntitlementChecker(fakeChecker, false)
+
+		probeProvider = func() nfsbroker.ProbeResult {
+			return broker.RunProbe(context.Background())
+		}
+	})
+
+	JustBeforeEach(func() {
+		probeResult = probeProvider()
+	})
+
+	It("runs a full cycle and leaves nothing behind", func() {
+		Expect(probeResult.Error).To(BeEmpty())
+		Expect(probeResult.StepSeconds).To(HaveKey("provision"))
+		Expect(probeResult.StepSeconds).To(HaveKey("deprovision"))
+		Expect(probeResult.WithinObjective).To(BeTrue())
+
+		Expect(store.CountInstances(context.Background())).To(Equal(0))
+		Expect(store.CountBindings(context.Background())).To(Equal(0))
+	})
+
+	It("does not reach share provisioners or the entitlement service", func() {
+		Expect(stepsRun).To(Equal(0))
+		Expect(fakeChecker.EntitledCallCount()).To(Equal(0))
+	})
+
+	It("reports the probe", func() {
+		report, ok := broker.SLOReport()
+		Expect(ok).To(BeTrue())
+		Expect(report.Probes).To(Equal(1))
+		Expect(report.Failures).To(Equal(0))
+		Expect(report.WithinObjective).To(Equal(1.0))
+		Expect(report.ObjectiveSeconds).To(Equal(60.0))
+		Expect(report.Last).NotTo(BeNil())
+	})
+
+	It("is served from the admin endpoint", func() {
+		recorder := httptest.NewRecorder()
+		nfsbroker.NewAdminHandler(logger, broker).ServeHTTP(recorder, httptest.NewRequest("GET", nfsbroker.AdminSLOPath, nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		var report map[string]interface{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &report)).To(Succeed())
+		Expect(report).To(HaveKeyWithValue("probes", 1.0))
+		Expect(report).To(HaveKey("latency_p95_seconds"))
+	})
+
+	Context("when a step fails", func() {
+		BeforeEach(func() {
+			fakeStore := &nfsbrokerfakes.FakeStore{}
+			fakeStore.RetrieveInstanceDetailsStub = func(context.Context, string) (nfsbroker.ServiceInstance, error) {
+				if fakeStore.CreateInstanceDetailsCallCount() == 0 {
+					return nfsbroker.ServiceInstance{}, nfsbroker.ErrInstanceNotFound
+				}
+				_, _, details := fakeStore.CreateInstanceDetailsArgsForCall(0)
+				return details, nil
+			}
+			fakeStore.CreateBindingDetailsReturns(errors.New("disk full"))
+			broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, fakeStore,
+				nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+			broker.SetSLOObjective(time.Minute)
+			probeProvider = func() nfsbroker.ProbeResult {
+				result := broker.RunProbe(context.Background())
+				Expect(fakeStore.DeleteInstanceDetailsCallCount()).To(Equal(1))
+				return result
+			}
+		})
+
+		It("records the failure and removes the instance", func() {
+			Expect(probeResult.Error).To(ContainSubstring("bind: "))
+			Expect(probeResult.WithinObjective).To(BeFalse())
+
+			report, _ := broker.SLOReport()
+			Expect(report.Failures).To(Equal(1))
+			Expect(report.WithinObjective).To(Equal(0.0))
+		})
+	})
+
+	Context("when a platform asks for the probe plan", func() {
+		It("rejects the request", func() {
+			parameters, _ := json.Marshal(map[string]interface{}{"share": "server:/export"})
+			_, err := broker.Provision(context.Background(), "instance-id", brokerapi.ProvisionDetails{PlanID: nfsbroker.ProbePlanID, RawParameters: parameters}, false)
+			Expect(err).To(MatchError(ContainSubstring("is not offered by this broker")))
+		})
+	})
+
+	Context("when the probe is not enabled", func() {
+		It("has no report", func() {
+			broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, store,
+				nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+			_, ok := broker.SLOReport()
+			Expect(ok).To(BeFalse())
+
+			recorder := httptest.NewRecorder()
+			nfsbroker.NewAdminHandler(logger, broker).ServeHTTP(recorder, httptest.NewRequest("GET", nfsbroker.AdminSLOPath, nil))
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+})
@@ -0,0 +1,102 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("SMB service", func() {
+	var (
+		broker *nfsbroker.Broker
+		store  nfsbroker.Store
+		ctx    context.Context
+	)
+
+	provision := func(instanceID, serviceID, planID, parameters string) error {
+		_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+			ServiceID:     serviceID,
+			PlanID:        planID,
+			RawParameters: json.RawMessage(parameters),
+		}, false)
+		return err
+	}
+
+	bind := func(parameters map[string]interface{}) (brokerapi.Binding, error) {
+		return broker.Bind(ctx, "smb-instance", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid", Parameters: parameters})
+	}
+
+	BeforeEach(func() {
+		store = nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-smb"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, store, nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		broker.SetCatalogServices([]nfsbroker.CatalogService{
+			{ID: "nfs-id", Name: "nfs", Description: "NFS shares",
+				Plans: []nfsbroker.Plan{{ID: "nfs-plan", Name: "Existing", Description: "Existing shares"}}},
+			{ID: "smb-id", Name: "smb", Description: "SMB shares", ShareType: "smb",
+				Plans: []nfsbroker.Plan{{ID: "smb-plan", Name: "Existing", Description: "Existing shares"}}},
+		})
+		ctx = context.Background()
+
+		Expect(provision("smb-instance", "smb-id", "smb-plan", `{"share":"//filer/share"}`)).To(Succeed())
+	})
+
+	It("validates shares as SMB shares", func() {
+		Expect(provision("other", "smb-id", "smb-plan", `{"share":"filer:/export"}`)).To(MatchError(ContainSubstring("must be of the form //server/share")))
+		Expect(provision("other", "nfs-id", "nfs-plan", `{"share":"//filer/share"}`)).To(MatchError(ContainSubstring("is written as an SMB share")))
+	})
+
+	It("mounts the share with the smb driver and the binding's credentials", func() {
+		binding, err := bind(map[string]interface{}{"username": "user", "password": "secret", "domain": "CORP"})
+		Expect(err).NotTo(HaveOccurred())
+
+		mount := binding.VolumeMounts[0]
+		Expect(mount.Driver).To(Equal("smbdriver"))
+		Expect(mount.Device.MountConfig).To(Equal(map[string]interface{}{
+			"source":   "//filer/share",
+			"username": "user",
+			"password": "secret",
+			"domain":   "CORP",
+		}))
+		Expect(nfsbroker.ValidateVolumeMount(mount)).To(BeEmpty())
+	})
+
+	It("never stores the password", func() {
+		_, err := bind(map[string]interface{}{"username": "user", "password": "secret"})
+		Expect(err).NotTo(HaveOccurred())
+
+		details, err := store.RetrieveBindingDetails(ctx, "binding-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(details.Parameters).To(HaveKeyWithValue("username", "user"))
+		Expect(details.Parameters).NotTo(HaveKey("password"))
+		Expect(details.Parameters[nfsbroker.EffectiveOptionsKey]).NotTo(HaveKey("password"))
+	})
+
+	It("requires a username and password", func() {
+		_, err := bind(map[string]interface{}{"username": "user"})
+		Expect(err).To(MatchError("invalid smb credentials: password is required"))
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusBadRequest))
+	})
+
+	It("does not resolve the credentials into IDs", func() {
+		resolver := &nfsbrokerfakes.FakeIDResolver{}
+		broker.SetIDResolver(resolver)
+
+		_, err := bind(map[string]interface{}{"username": "user", "password": "secret"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resolver.ResolveCallCount()).To(Equal(0))
+	})
+
+	It("refuses NFS versions", func() {
+		_, err := bind(map[string]interface{}{"username": "user", "password": "secret", "version": "3"})
+		Expect(err).To(MatchError(ContainSubstring("smb shares have no protocol versions")))
+	})
+})
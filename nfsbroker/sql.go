@@ -1,12 +1,16 @@
 package nfsbroker
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
+	"sync"
 	"time"
 
+	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/goshims/sqlshim"
 	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/nfsbroker/backoff"
 )
 
 //go:generate counterfeiter -o ../nfsbrokerfakes/fake_sql_variant.go . SqlVariant
@@ -22,70 +26,157 @@ type SqlConnection interface {
 	sqlshim.SqlDB
 }
 
+// sqlContextDB is implemented by connections that can cancel in-flight statements when their context is done.
+type sqlContextDB interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 type sqlConnection struct {
+	clock       clock.Clock
+	retryPolicy backoff.Policy
+
+	// mutex guards the pool and variant, which reconnect replaces
+	mutex sync.RWMutex
 	sqlDB sqlshim.SqlDB
 	leaf  SqlVariant
 }
 
 func NewSqlConnection(variant SqlVariant) SqlConnection {
+	return NewSqlConnectionWithRetry(variant, clock.NewClock(), backoff.DefaultPolicy)
+}
+
+// NewSqlConnectionWithRetry returns a connection that retries failed connection attempts according to retryPolicy.
+func NewSqlConnectionWithRetry(variant SqlVariant, clock clock.Clock, retryPolicy backoff.Policy) SqlConnection {
 	if variant == nil {
 		panic("variant cannot be nil")
 	}
 	return &sqlConnection{
-		leaf: variant,
+		leaf:        variant,
+		clock:       clock,
+		retryPolicy: retryPolicy,
 	}
 }
 
 func (c *sqlConnection) flavorify(query string) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	return c.leaf.Flavorify(query)
 }
 
-func (c *sqlConnection) Connect(logger lager.Logger) error {
-	sqlDB, err := c.leaf.Connect(logger)
-	if err != nil {
-		return err
-	}
+// db returns the connection's current pool.
+func (c *sqlConnection) db() sqlshim.SqlDB {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.sqlDB
+}
 
-	c.sqlDB = sqlDB
+func (c *sqlConnection) Connect(logger lager.Logger) error {
+	logger = logger.Session("connect")
+	return backoff.Retry(context.Background(), logger, c.clock, c.retryPolicy, func(context.Context) error {
+		c.mutex.Lock()
+		sqlDB, err := c.leaf.Connect(logger)
+		if err != nil {
+			c.mutex.Unlock()
+			return err
+		}
+		c.sqlDB = sqlDB
+		c.mutex.Unlock()
 
-	err = c.Ping()
-	return err
+		if err = c.Ping(); err != nil {
+			sqlDB.Close()
+			return err
+		}
+		return nil
+	})
 }
 
 func (c *sqlConnection) Ping() error {
-	return c.sqlDB.Ping()
+	return c.db().Ping()
 }
 func (c *sqlConnection) Close() error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	defer c.leaf.Close()
 	return c.sqlDB.Close()
 }
+
+// reconnect opens a pool to the database with variant, which differs from the connection's only in its credentials,
+// and replaces the connection's pool with it once it answers.  Statements and transactions already under way finish
+// on the old pool, which is closed once they have.  If the new pool cannot be opened, the old one stays in use.
+func (c *sqlConnection) reconnect(logger lager.Logger, variant SqlVariant) error {
+	logger = logger.Session("reconnect")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	sqlDB, err := variant.Connect(logger)
+	if err != nil {
+		variant.Close()
+		return err
+	}
+	if err := sqlDB.Ping(); err != nil {
+		sqlDB.Close()
+		variant.Close()
+		return err
+	}
+
+	c.mutex.Lock()
+	oldDB, oldLeaf := c.sqlDB, c.leaf
+	c.sqlDB, c.leaf = sqlDB, variant
+	c.mutex.Unlock()
+
+	defer oldLeaf.Close()
+	if err := oldDB.Close(); err != nil {
+		logger.Error("failed-to-close-old-pool", err)
+	}
+	return nil
+}
 func (c *sqlConnection) SetMaxIdleConns(n int) {
-	c.sqlDB.SetMaxIdleConns(n)
+	c.db().SetMaxIdleConns(n)
 }
 func (c *sqlConnection) SetMaxOpenConns(n int) {
-	c.sqlDB.SetMaxOpenConns(n)
+	c.db().SetMaxOpenConns(n)
 }
 func (c *sqlConnection) SetConnMaxLifetime(d time.Duration) {
-	c.sqlDB.SetConnMaxLifetime(d)
+	c.db().SetConnMaxLifetime(d)
 }
 func (c *sqlConnection) Stats() sql.DBStats {
-	return c.sqlDB.Stats()
+	return c.db().Stats()
 }
 func (c *sqlConnection) Prepare(query string) (*sql.Stmt, error) {
-	return c.sqlDB.Prepare(c.flavorify(query))
+	return c.db().Prepare(c.flavorify(query))
 }
 func (c *sqlConnection) Exec(query string, args ...interface{}) (sql.Result, error) {
-	return c.sqlDB.Exec(c.flavorify(query), args...)
+	return c.db().Exec(c.flavorify(query), args...)
 }
 func (c *sqlConnection) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	return c.sqlDB.Query(c.flavorify(query), args...)
+	return c.db().Query(c.flavorify(query), args...)
 }
 func (c *sqlConnection) QueryRow(query string, args ...interface{}) *sql.Row {
-	return c.sqlDB.QueryRow(c.flavorify(query), args...)
+	return c.db().QueryRow(c.flavorify(query), args...)
+}
+func (c *sqlConnection) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if db, ok := c.db().(sqlContextDB); ok {
+		return db.ExecContext(ctx, c.flavorify(query), args...)
+	}
+	return c.Exec(query, args...)
+}
+func (c *sqlConnection) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if db, ok := c.db().(sqlContextDB); ok {
+		return db.QueryContext(ctx, c.flavorify(query), args...)
+	}
+	return c.Query(query, args...)
+}
+func (c *sqlConnection) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if db, ok := c.db().(sqlContextDB); ok {
+		return db.QueryRowContext(ctx, c.flavorify(query), args...)
+	}
+	return c.QueryRow(query, args...)
 }
 func (c *sqlConnection) Begin() (*sql.Tx, error) {
-	return c.sqlDB.Begin()
+	return c.db().Begin()
 }
 func (c *sqlConnection) Driver() driver.Driver {
-	return c.sqlDB.Driver()
+	return c.db().Driver()
 }
@@ -75,6 +75,20 @@ func (c *mysqlVariant) Flavorify(query string) string {
 	return query
 }
 
+// MySQL schemas are databases.
+func (c *mysqlVariant) CurrentSchema() string {
+	return "DATABASE()"
+}
+
 func (c *mysqlVariant) Close() error {
 	return nil
 }
+
+// MySQL lock names are global to the server, so they are qualified with the database name.
+func (c *mysqlVariant) TryLockQuery(name string) (string, []interface{}) {
+	return "SELECT GET_LOCK(?, 0)", []interface{}{c.dbName + "." + name}
+}
+
+func (c *mysqlVariant) UnlockQuery(name string) (string, []interface{}) {
+	return "SELECT RELEASE_LOCK(?)", []interface{}{c.dbName + "." + name}
+}
@@ -2,6 +2,7 @@ package nfsbroker
 
 import (
 	"fmt"
+	"hash/fnv"
 	"strings"
 
 	"code.cloudfoundry.org/goshims/ioutilshim"
@@ -83,9 +84,26 @@ func (c *postgresVariant) Flavorify(query string) string {
 	return strings.Join(strParts, "")
 }
 
+// Postgres tables are created in the first schema on the search path.
+func (c *postgresVariant) CurrentSchema() string {
+	return "current_schema()"
+}
+
 func (c *postgresVariant) Close() error {
 	if c.caCert != "" {
 		return c.os.Remove(c.caCert)
 	}
 	return nil
 }
+
+// Postgres advisory locks are keyed by integer, so the name is hashed.  Transaction level locks are released when
+// the transaction ends.
+func (c *postgresVariant) TryLockQuery(name string) (string, []interface{}) {
+	key := fnv.New64a()
+	key.Write([]byte(name))
+	return "SELECT pg_try_advisory_xact_lock($1)", []interface{}{int64(key.Sum64())}
+}
+
+func (c *postgresVariant) UnlockQuery(name string) (string, []interface{}) {
+	return "", nil
+}
@@ -1,8 +1,10 @@
 package nfsbroker_test
 
 import (
+	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/backoff"
 	"code.cloudfoundry.org/nfsbroker/nfsbroker"
 
 	"code.cloudfoundry.org/goshims/sqlshim/sql_fake"
@@ -87,6 +89,7 @@ var _ = Describe("SqlConnection", func() {
 
 		Context("when it cannot connect to a valid database", func() {
 			BeforeEach(func() {
+				database = nfsbroker.NewSqlConnectionWithRetry(toDatabase, clock.NewClock(), backoff.Policy{InitialDelay: time.Millisecond, Multiplier: 2, MaxAttempts: 3})
 				toDatabase.ConnectReturns(nil, errors.New("something wrong"))
 			})
 
@@ -94,6 +97,12 @@ var _ = Describe("SqlConnection", func() {
 				err = database.Connect(logger)
 				Expect(err).To(HaveOccurred())
 			})
+
+			It("retries before giving up", func() {
+				calls := toDatabase.ConnectCallCount()
+				database.Connect(logger)
+				Expect(toDatabase.ConnectCallCount() - calls).To(Equal(3))
+			})
 		})
 
 		Context("when it is give invalid database", func() {
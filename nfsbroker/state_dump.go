@@ -0,0 +1,144 @@
+package nfsbroker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// StateDumpLockTimeout bounds how long a state dump waits for the broker lock, so that a broker stuck holding it
+// can still be diagnosed.
+const StateDumpLockTimeout = 5 * time.Second
+
+// StateDump is a snapshot of the broker for diagnosing live incidents.  It holds record IDs and counts but no
+// shares or bind parameters.
+type StateDump struct {
+	Time       time.Time `json:"time"`
+	Goroutines int       `json:"goroutines"`
+
+	StoreError       string            `json:"store_error,omitempty"`
+	InstanceCount    int               `json:"instance_count"`
+	BindingCount     int               `json:"binding_count"`
+	InstanceIDs      []string          `json:"instance_ids"`
+	BindingInstances map[string]string `json:"binding_instances"`
+
+	NameCache      *NameCacheStats `json:"name_cache,omitempty"`
+	CorruptRecords uint64          `json:"corrupt_records"`
+
+	// GoroutineStacks is only filled in for dumps written to a file.
+	GoroutineStacks string `json:"goroutine_stacks,omitempty"`
+}
+
+// DumpState takes a snapshot of the broker.  If the store cannot be read, or the broker lock is not released within
+// StateDumpLockTimeout, the dump describes the problem in StoreError instead of the store's contents.
+func (b *Broker) DumpState(ctx context.Context) StateDump {
+	dump := StateDump{
+		Time:       time.Now(),
+		Goroutines: runtime.NumGoroutine(),
+
+		CorruptRecords: CorruptRecordCount(),
+	}
+	if cache, ok := b.nameLookup.(*NameCache); ok {
+		stats := cache.Stats()
+		dump.NameCache = &stats
+	}
+
+	lockCtx, cancel := context.WithTimeout(ctx, StateDumpLockTimeout)
+	defer cancel()
+	if err := b.lockFor(lockCtx); err != nil {
+		dump.StoreError = "timed out waiting for the broker lock"
+		return dump
+	}
+	defer b.mutex.Unlock()
+
+	if err := b.dumpStore(ctx, &dump); err != nil {
+		dump.StoreError = err.Error()
+	}
+	return dump
+}
+
+func (b *Broker) dumpStore(ctx context.Context, dump *StateDump) error {
+	var err error
+	if dump.InstanceCount, err = b.store.CountInstances(ctx); err != nil {
+		return err
+	}
+	if dump.BindingCount, err = b.store.CountBindings(ctx); err != nil {
+		return err
+	}
+
+	dump.InstanceIDs = []string{}
+	err = ForEachInstance(ctx, b.store, ListOptions{}, 100, func(id string, _ ServiceInstance) error {
+		dump.InstanceIDs = append(dump.InstanceIDs, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(dump.InstanceIDs)
+
+	dump.BindingInstances, err = b.store.ListBindingInstances(ctx)
+	return err
+}
+
+// StateDumper logs a state dump, and optionally writes it to a file, whenever the process receives one of its
+// signals.
+type StateDumper struct {
+	logger  lager.Logger
+	broker  *Broker
+	path    string
+	signals []os.Signal
+}
+
+func NewStateDumper(logger lager.Logger, broker *Broker, path string, signals ...os.Signal) *StateDumper {
+	return &StateDumper{logger: logger, broker: broker, path: path, signals: signals}
+}
+
+func (d *StateDumper) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	dumpSignals := make(chan os.Signal, 1)
+	signal.Notify(dumpSignals, d.signals...)
+	defer signal.Stop(dumpSignals)
+	close(ready)
+
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-dumpSignals:
+			d.Dump()
+		}
+	}
+}
+
+// Dump logs a state dump and writes it to the dumper's file, if it has one.
+func (d *StateDumper) Dump() {
+	logger := d.logger.Session("state-dump")
+	dump := d.broker.DumpState(context.Background())
+	logger.Info("dump", lager.Data{"state": dump})
+
+	if d.path == "" {
+		return
+	}
+	stacks := &bytes.Buffer{}
+	pprof.Lookup("goroutine").WriteTo(stacks, 2)
+	dump.GoroutineStacks = stacks.String()
+
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		logger.Error("failed-to-marshal-state-dump", err)
+		return
+	}
+	if err := ioutil.WriteFile(d.path, data, 0600); err != nil {
+		logger.Error("failed-to-write-state-dump", err, lager.Data{"path": d.path})
+		return
+	}
+	logger.Info("wrote-state-dump", lager.Data{"path": d.path})
+}
@@ -0,0 +1,105 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("StateDump", func() {
+	var (
+		logger    *lagertest.TestLogger
+		broker    *nfsbroker.Broker
+		fakeStore *nfsbrokerfakes.FakeStore
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-state-dump")
+		fakeStore = &nfsbrokerfakes.FakeStore{}
+		fakeStore.CountInstancesReturns(2, nil)
+		fakeStore.CountBindingsReturns(1, nil)
+		fakeStore.ListInstanceDetailsReturns(map[string]nfsbroker.ServiceInstance{
+			"instance-2": {Share: "server/secret-export"},
+			"instance-1": {Share: "server/export"},
+		}, nil)
+		fakeStore.ListBindingInstancesReturns(map[string]string{"binding-id": "instance-1"}, nil)
+
+		broker = nfsbroker.New(logger, "service-name", "service-id", "/fake-dir", &os_fake.FakeOs{}, nil, fakeStore,
+			nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+	})
+
+	It("records counts and IDs without shares", func() {
+		dump := broker.DumpState(context.TODO())
+		Expect(dump.StoreError).To(BeEmpty())
+		Expect(dump.InstanceCount).To(Equal(2))
+		Expect(dump.BindingCount).To(Equal(1))
+		Expect(dump.InstanceIDs).To(Equal([]string{"instance-1", "instance-2"}))
+		Expect(dump.BindingInstances).To(Equal(map[string]string{"binding-id": "instance-1"}))
+		Expect(dump.Goroutines).To(BeNumerically(">", 0))
+		Expect(dump.NameCache).To(BeNil())
+
+		data, err := json.Marshal(dump)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).NotTo(ContainSubstring("export"))
+	})
+
+	It("includes name cache statistics", func() {
+		fakeLookup := &nfsbrokerfakes.FakeNameLookup{}
+		cache := nfsbroker.NewNameCache(fakeLookup, fakeclock.NewFakeClock(time.Now()), time.Minute)
+		broker.SetNameLookup(cache)
+		cache.SpaceName(context.TODO(), "space-guid")
+		cache.SpaceName(context.TODO(), "space-guid")
+
+		dump := broker.DumpState(context.TODO())
+		Expect(dump.NameCache).To(Equal(&nfsbroker.NameCacheStats{Entries: 1, Hits: 1, Misses: 1}))
+	})
+
+	It("reports store failures", func() {
+		fakeStore.CountBindingsReturns(0, errors.New("database is down"))
+
+		dump := broker.DumpState(context.TODO())
+		Expect(dump.StoreError).To(Equal("database is down"))
+		Expect(dump.InstanceCount).To(Equal(2))
+	})
+
+	Describe("StateDumper", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "state-dump")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("logs the dump and writes it with goroutine stacks to a file", func() {
+			path := filepath.Join(dir, "dump.json")
+			nfsbroker.NewStateDumper(logger, broker, path).Dump()
+			Expect(logger.Buffer()).To(gbytes.Say("state-dump.dump"))
+
+			data, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			var dump nfsbroker.StateDump
+			Expect(json.Unmarshal(data, &dump)).To(Succeed())
+			Expect(dump.InstanceCount).To(Equal(2))
+			Expect(dump.GoroutineStacks).To(ContainSubstring("goroutine"))
+		})
+	})
+})
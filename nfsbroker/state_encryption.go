@@ -0,0 +1,70 @@
+package nfsbroker
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// encryptedStatePrefix starts the header line of encrypted state files, which is followed by the ID of the key the
+// file is encrypted with.  State files without it are plaintext JSON.
+const encryptedStatePrefix = "nfsbroker-encrypted-state v1 "
+
+// StateEncryption encrypts the file store's state file with AES-256-GCM.  The first key encrypts the state; the
+// others only decrypt it, so that keys can be rotated by adding a new key first, restarting the broker, which
+// rewrites the state file with it, and then removing the old key.
+type StateEncryption struct {
+	keys []encryptionKey
+}
+
+// NewStateEncryption returns an encryption with the given keys, which are passphrases of at least
+// MinEncryptionKeyLength characters.  AES keys are derived from them with SHA-256.
+func NewStateEncryption(keys []string) (*StateEncryption, error) {
+	encryptionKeys, err := newEncryptionKeys("state encryption", keys)
+	if err != nil {
+		return nil, err
+	}
+	return &StateEncryption{keys: encryptionKeys}, nil
+}
+
+// encrypt returns the encrypted state file holding plaintext.
+func (e *StateEncryption) encrypt(plaintext []byte) ([]byte, error) {
+	key := e.keys[0]
+	header := []byte(encryptedStatePrefix + key.id + "\n")
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	// the header is authenticated, so that the key ID cannot be changed
+	return key.aead.Seal(append(header, nonce...), nonce, plaintext, header), nil
+}
+
+// decrypt returns the plaintext of an encrypted state file, and whether it was encrypted with the first key.
+func (e *StateEncryption) decrypt(data []byte) ([]byte, bool, error) {
+	newline := bytes.IndexByte(data, '\n')
+	if !isEncryptedState(data) || newline < 0 {
+		return nil, false, errors.New("the state file is not encrypted")
+	}
+	header, sealed := data[:newline+1], data[newline+1:]
+	id := string(header[len(encryptedStatePrefix):newline])
+	for i, key := range e.keys {
+		if key.id != id {
+			continue
+		}
+		if len(sealed) < key.aead.NonceSize() {
+			return nil, false, errors.New("the encrypted state file is truncated")
+		}
+		plaintext, err := key.aead.Open(nil, sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():], header)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to decrypt the state file with key %s: %w", id, err)
+		}
+		return plaintext, i == 0, nil
+	}
+	return nil, false, fmt.Errorf("the state file is encrypted with key %s, which is not one of the state encryption keys", id)
+}
+
+func isEncryptedState(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedStatePrefix))
+}
@@ -0,0 +1,110 @@
+package nfsbroker_test
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/goshims/ioutilshim/ioutil_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encrypted state files", func() {
+	var (
+		logger     *lagertest.TestLogger
+		ctx        context.Context
+		fakeIoutil *ioutil_fake.FakeIoutil
+	)
+
+	encryption := func(keys ...string) *nfsbroker.StateEncryption {
+		e, err := nfsbroker.NewStateEncryption(keys)
+		Expect(err).NotTo(HaveOccurred())
+		return e
+	}
+
+	// saved returns the state file written by a store with an instance, encrypted with the given keys.
+	saved := func(keys ...string) []byte {
+		var store nfsbroker.Store
+		if len(keys) == 0 {
+			store = nfsbroker.NewFileStore("/tmp/whatever", fakeIoutil, nfsbroker.DefaultMaxValueSize)
+		} else {
+			store = nfsbroker.NewEncryptedFileStore("/tmp/whatever", fakeIoutil, nfsbroker.DefaultMaxValueSize, encryption(keys...))
+		}
+		Expect(store.CreateInstanceDetails(ctx, "instance-id", nfsbroker.ServiceInstance{Share: "server:/secret-share"})).To(Succeed())
+		Expect(store.Save(logger)).To(Succeed())
+		_, data, _ := fakeIoutil.WriteFileArgsForCall(fakeIoutil.WriteFileCallCount() - 1)
+		return data
+	}
+
+	restore := func(data []byte, keys ...string) (nfsbroker.Store, error) {
+		store := nfsbroker.NewEncryptedFileStore("/tmp/whatever", fakeIoutil, nfsbroker.DefaultMaxValueSize, encryption(keys...))
+		fakeIoutil.ReadFileReturns(data, nil)
+		return store, store.Restore(logger)
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-state-encryption")
+		ctx = context.TODO()
+		fakeIoutil = &ioutil_fake.FakeIoutil{}
+	})
+
+	It("does not write the state in plaintext, and restores it", func() {
+		data := saved("first-key-0123456789")
+		Expect(string(data)).NotTo(ContainSubstring("secret-share"))
+
+		store, err := restore(data, "first-key-0123456789")
+		Expect(err).NotTo(HaveOccurred())
+		instance, err := store.RetrieveInstanceDetails(ctx, "instance-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instance.Share).To(Equal("server:/secret-share"))
+		Expect(fakeIoutil.WriteFileCallCount()).To(Equal(1))
+	})
+
+	It("encrypts plaintext state files when they are restored", func() {
+		store, err := restore(saved(), "first-key-0123456789")
+		Expect(err).NotTo(HaveOccurred())
+		instance, err := store.RetrieveInstanceDetails(ctx, "instance-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instance.Share).To(Equal("server:/secret-share"))
+
+		Expect(fakeIoutil.WriteFileCallCount()).To(Equal(2))
+		_, data, _ := fakeIoutil.WriteFileArgsForCall(1)
+		Expect(string(data)).NotTo(ContainSubstring("secret-share"))
+	})
+
+	It("rewrites state files encrypted with older keys with the first key", func() {
+		data := saved("old-key-0123456789")
+
+		_, err := restore(data, "new-key-0123456789", "old-key-0123456789")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fakeIoutil.WriteFileCallCount()).To(Equal(2))
+		_, rewritten, _ := fakeIoutil.WriteFileArgsForCall(1)
+
+		_, err = restore(rewritten, "new-key-0123456789")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("fails to restore state files encrypted with other keys", func() {
+		_, err := restore(saved("some-key-0123456789"), "other-key-0123456789")
+		Expect(err).To(MatchError(ContainSubstring("which is not one of the state encryption keys")))
+	})
+
+	It("fails to restore state files that have been tampered with", func() {
+		data := saved("some-key-0123456789")
+		data[len(data)-1] ^= 1
+		_, err := restore(data, "some-key-0123456789")
+		Expect(err).To(MatchError(ContainSubstring("failed to decrypt the state file")))
+	})
+
+	It("fails to restore encrypted state files without keys", func() {
+		store := nfsbroker.NewFileStore("/tmp/whatever", fakeIoutil, nfsbroker.DefaultMaxValueSize)
+		fakeIoutil.ReadFileReturns(saved("some-key-0123456789"), nil)
+		Expect(store.Restore(logger)).To(MatchError(ContainSubstring("no state encryption keys were given")))
+	})
+
+	It("refuses short keys", func() {
+		_, err := nfsbroker.NewStateEncryption([]string{"short"})
+		Expect(err).To(MatchError(ContainSubstring("shorter than 16 characters")))
+	})
+})
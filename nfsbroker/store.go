@@ -1,72 +1,271 @@
 package nfsbroker
 
 import (
+	"bytes"
 	"code.cloudfoundry.org/goshims/ioutilshim"
 	"code.cloudfoundry.org/lager"
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/pivotal-cf/brokerapi"
 	"golang.org/x/crypto/bcrypt"
 	"reflect"
+	"sort"
+	"time"
 )
 
 //go:generate counterfeiter -o ../nfsbrokerfakes/fake_store.go . Store
 type Store interface {
-	RetrieveInstanceDetails(id string) (ServiceInstance, error)
-	RetrieveBindingDetails(id string) (brokerapi.BindDetails, error)
+	RetrieveInstanceDetails(ctx context.Context, id string) (ServiceInstance, error)
+	RetrieveBindingDetails(ctx context.Context, id string) (brokerapi.BindDetails, error)
 
-	CreateInstanceDetails(id string, details ServiceInstance) error
-	CreateBindingDetails(id string, details brokerapi.BindDetails) error
+	CreateInstanceDetails(ctx context.Context, id string, details ServiceInstance) error
+	CreateBindingDetails(ctx context.Context, instanceID, id string, details brokerapi.BindDetails) error
 
-	DeleteInstanceDetails(id string) error
-	DeleteBindingDetails(id string) error
+	// UpdateInstanceDetails replaces the details of an existing service instance.
+	UpdateInstanceDetails(ctx context.Context, id string, details ServiceInstance) error
 
-	IsInstanceConflict(id string, details ServiceInstance) bool
-	IsBindingConflict(id string, details brokerapi.BindDetails) bool
+	DeleteInstanceDetails(ctx context.Context, id string) error
+	DeleteBindingDetails(ctx context.Context, id string) error
+
+	ListInstanceDetails(ctx context.Context, opts ListOptions) (map[string]ServiceInstance, error)
+	ListBindingDetails(ctx context.Context, opts ListOptions) (map[string]brokerapi.BindDetails, error)
+
+	// ListBindingInstances maps each stored binding ID to the ID of the instance it was bound to.  Bindings created
+	// before the broker recorded instance IDs map to "".
+	ListBindingInstances(ctx context.Context) (map[string]string, error)
+
+	// CountInstances and CountBindings count stored records without fetching them.
+	CountInstances(ctx context.Context) (int, error)
+	CountBindings(ctx context.Context) (int, error)
+
+	// RetrieveJobNextRun returns the zero time for jobs that have never been scheduled.
+	RetrieveJobNextRun(ctx context.Context, name string) (time.Time, error)
+	SaveJobNextRun(ctx context.Context, name string, next time.Time) error
+
+	// RetrieveOperation returns the zero Operation for instances with no recorded operation.
+	RetrieveOperation(ctx context.Context, instanceID string) (Operation, error)
+	SaveOperation(ctx context.Context, instanceID string, operation Operation) error
+
+	// ListRetiredPlans maps the IDs of retired plans to the hints given to those who try to provision them.
+	ListRetiredPlans(ctx context.Context) (map[string]string, error)
+	// RetirePlan retires a plan, or replaces the hint of a plan that is retired already.
+	RetirePlan(ctx context.Context, planID, hint string) error
+	ReinstatePlan(ctx context.Context, planID string) error
+
+	// ListShareReservations maps reserved share prefixes to the organizations they are reserved for.
+	ListShareReservations(ctx context.Context) (map[string]string, error)
+	ReserveShares(ctx context.Context, prefix, orgGUID string) error
+	ReleaseShares(ctx context.Context, prefix string) error
+
+	IsInstanceConflict(ctx context.Context, id string, details ServiceInstance) bool
+	IsBindingConflict(ctx context.Context, id string, details brokerapi.BindDetails) bool
 
 	Restore(logger lager.Logger) error
 	Save(logger lager.Logger) error
 	Cleanup() error
 }
 
-func NewStore(logger lager.Logger, dbDriver, dbUsername, dbPassword, dbHostname, dbPort, dbName, dbCACert, fileName string) Store {
+// ListOptions filters and pages the results of the list methods.  The zero value lists everything.
+type ListOptions struct {
+	ServiceID string
+	PlanID    string
+
+	// After and Limit page through records in ID order: pass the largest ID of one page as After to fetch the next.
+	After string
+	Limit int
+}
+
+func (o ListOptions) matches(id, serviceID, planID string) bool {
+	if o.ServiceID != "" && serviceID != o.ServiceID {
+		return false
+	}
+	if o.PlanID != "" && planID != o.PlanID {
+		return false
+	}
+	return o.After == "" || id > o.After
+}
+
+// pageIDs sorts the IDs of matching records and trims them to the page limit.
+func (o ListOptions) pageIDs(ids []string) []string {
+	sort.Strings(ids)
+	if o.Limit > 0 && len(ids) > o.Limit {
+		ids = ids[:o.Limit]
+	}
+	return ids
+}
+
+// ForEachInstance calls fn for every instance matching opts, fetching pageSize instances at a time.
+func ForEachInstance(ctx context.Context, s Store, opts ListOptions, pageSize int, fn func(id string, details ServiceInstance) error) error {
+	opts.Limit = pageSize
+	for {
+		page, err := s.ListInstanceDetails(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		ids := make([]string, 0, len(page))
+		for id := range page {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if err := fn(id, page[id]); err != nil {
+				return err
+			}
+		}
+
+		if len(ids) == 0 || len(ids) < pageSize {
+			return nil
+		}
+		opts.After = ids[len(ids)-1]
+	}
+}
+
+func NewStore(logger lager.Logger, dbDriver, dbUsername, dbPassword, dbHostname, dbPort, dbName, dbCACert, fileName string, maxValueSize int, queryTimeout time.Duration, stateEncryption *StateEncryption, parameterEncryption *ParameterEncryption, bcryptCost int) Store {
 	if dbDriver != "" {
-		store, err := NewSqlStore(logger, dbDriver, dbUsername, dbPassword, dbHostname, dbPort, dbName, dbCACert)
+		store, err := NewSqlStore(logger, dbDriver, dbUsername, dbPassword, dbHostname, dbPort, dbName, dbCACert, maxValueSize, queryTimeout)
 		if err != nil {
 			logger.Fatal("failed-creating-sql-store", err)
 		}
+		store.(*SqlStore).ParameterEncryption = parameterEncryption
+		store.(*SqlStore).BcryptCost = bcryptCost
 		return store
 	} else {
-		return NewFileStore(fileName, &ioutilshim.IoutilShim{})
+		store := newFileStore(fileName, &ioutilshim.IoutilShim{}, maxValueSize)
+		store.open = openFile
+		store.encryption = stateEncryption
+		store.parameterEncryption = parameterEncryption
+		store.bcryptCost = bcryptCost
+		return store
+	}
+}
+
+// DefaultMaxValueSize matches the width of the value columns created by earlier versions of the broker.
+const DefaultMaxValueSize = 4096
+
+// checkValueSize rejects serialized records that would not fit in the store.  A maxValueSize of 0 disables the check.
+func checkValueSize(kind, id string, value []byte, maxValueSize int) error {
+	if maxValueSize > 0 && len(value) > maxValueSize {
+		return fmt.Errorf("%s %s is too large to store: serialized size is %d bytes, maximum is %d bytes", kind, id, len(value), maxValueSize)
 	}
+	return nil
 }
 
 // Utility methods for storing bindings with secrets stripped out
 const HashKey = "paramsHash"
 
-func redactBindingDetails(details brokerapi.BindDetails) (brokerapi.BindDetails, error) {
+// SecretBindParameters are the bind parameters that are never stored in cleartext.  Bindings are stored with a hash
+// of all of their parameters, for conflict checks, or with all of them encrypted, and the values of the rest.
+var SecretBindParameters = []string{Secret, "password"}
+
+// The bcrypt costs binding parameters can be hashed with.  Each increment doubles the time hashing takes: the default
+// cost takes around 100ms, and the maximum several seconds.
+const (
+	MinBcryptCost = bcrypt.MinCost
+	MaxBcryptCost = 16
+)
+
+// CheckBcryptCost checks that cost is between MinBcryptCost and MaxBcryptCost.
+func CheckBcryptCost(cost int) error {
+	if cost < MinBcryptCost || cost > MaxBcryptCost {
+		return fmt.Errorf("bcrypt cost must be between %d and %d, got %d", MinBcryptCost, MaxBcryptCost, cost)
+	}
+	return nil
+}
+
+// redactBindingDetails returns a binding as it is stored: its secret parameters are left out, and all of its
+// requested parameters are hashed with bcrypt at bcryptCost, or bcrypt.DefaultCost when it is 0, or, with encryption,
+// encrypted.
+func redactBindingDetails(id string, details brokerapi.BindDetails, encryption *ParameterEncryption, bcryptCost int) (brokerapi.BindDetails, error) {
 	if details.Parameters == nil {
 		return details, nil
 	}
-	if len(details.Parameters) == 1 {
-		if _, ok := details.Parameters[HashKey]; ok {
-			return details, nil
+	if _, ok := details.Parameters[HashKey]; ok {
+		return details, nil
+	}
+	if _, ok := details.Parameters[EncryptedParamsKey]; ok {
+		return details, nil
+	}
+
+	// the effective options are recorded by the broker, not requested, so they are not part of the hash
+	requested := withoutRecordedBindParameters(details.Parameters)
+	var protected string
+	if encryption != nil {
+		encrypted, err := encryption.encrypt(id, requested)
+		if err != nil {
+			return brokerapi.BindDetails{}, err
+		}
+		protected = encrypted
+	} else {
+		s, err := json.Marshal(requested)
+		if err != nil {
+			return brokerapi.BindDetails{}, err
+		}
+		if bcryptCost == 0 {
+			bcryptCost = bcrypt.DefaultCost
 		}
+		s, err = bcrypt.GenerateFromPassword(s, bcryptCost)
+		if err != nil {
+			return brokerapi.BindDetails{}, err
+		}
+		protected = string(s)
+	}
+	effectiveOptions, recorded := details.Parameters[EffectiveOptionsKey]
+	details.Parameters = withoutSecretBindParameters(requested)
+	if encryption != nil {
+		details.Parameters[EncryptedParamsKey] = protected
+	} else {
+		details.Parameters[HashKey] = protected
 	}
+	if recorded {
+		details.Parameters[EffectiveOptionsKey] = effectiveOptions
+	}
+	return details, nil
+}
 
-	s, err := json.Marshal(details.Parameters)
-	if err != nil {
-		return brokerapi.BindDetails{}, err
+// revealBindingDetails returns a stored binding with the parameters it was stored with, secrets included, when they
+// were encrypted and encryption has their key.  Other bindings are returned as they are.
+func revealBindingDetails(id string, details brokerapi.BindDetails, encryption *ParameterEncryption) (brokerapi.BindDetails, error) {
+	encrypted, ok := details.Parameters[EncryptedParamsKey].(string)
+	if !ok || encryption == nil {
+		return details, nil
 	}
-	s, err = bcrypt.GenerateFromPassword(s, bcrypt.DefaultCost)
+	parameters, err := encryption.decrypt(id, encrypted)
 	if err != nil {
 		return brokerapi.BindDetails{}, err
 	}
-	details.Parameters = map[string]interface{}{HashKey: string(s)}
+	if effectiveOptions, recorded := details.Parameters[EffectiveOptionsKey]; recorded {
+		parameters[EffectiveOptionsKey] = effectiveOptions
+	}
+	details.Parameters = parameters
 	return details, nil
 }
 
-func isBindingConflict(s Store, id string, details brokerapi.BindDetails) bool {
-	if existing, err := s.RetrieveBindingDetails(id); err == nil {
+// withoutSecretBindParameters copies parameters, leaving out secrets and what the broker records with stored
+// bindings.
+func withoutSecretBindParameters(parameters map[string]interface{}) map[string]interface{} {
+	redacted := withoutRecordedBindParameters(parameters)
+	for _, key := range SecretBindParameters {
+		delete(redacted, key)
+	}
+	return redacted
+}
+
+// withoutRecordedBindParameters copies parameters, leaving out what the broker records with stored bindings.
+func withoutRecordedBindParameters(parameters map[string]interface{}) map[string]interface{} {
+	requested := map[string]interface{}{}
+	for key, value := range parameters {
+		requested[key] = value
+	}
+	delete(requested, HashKey)
+	delete(requested, EncryptedParamsKey)
+	delete(requested, EffectiveOptionsKey)
+	return requested
+}
+
+func isBindingConflict(ctx context.Context, s Store, id string, details brokerapi.BindDetails) bool {
+	if existing, err := s.RetrieveBindingDetails(ctx, id); err == nil {
 		if existing.AppGUID != details.AppGUID {
 			return true
 		}
@@ -82,16 +281,27 @@ func isBindingConflict(s Store, id string, details brokerapi.BindDetails) bool {
 		if (details.Parameters == nil) && (existing.Parameters == nil) {
 			return false
 		}
-		if (details.Parameters == nil) || (existing.Parameters == nil) {
+		if existing.Parameters == nil {
 			return true
 		}
+		if details.Parameters == nil {
+			// bindings made without parameters are stored with an empty set, to record their effective options
+			details.Parameters = map[string]interface{}{}
+		}
 
 		s, err := json.Marshal(details.Parameters)
 		if err != nil {
 			return true
 		}
-		h, _ := existing.Parameters[HashKey]
-		if bcrypt.CompareHashAndPassword([]byte(h.(string)), s) != nil {
+		if h, ok := existing.Parameters[HashKey].(string); ok {
+			return bcrypt.CompareHashAndPassword([]byte(h), s) != nil
+		}
+		if _, ok := existing.Parameters[EncryptedParamsKey]; ok {
+			// the parameters could not be decrypted, so they cannot be compared
+			return true
+		}
+		stored, err := json.Marshal(withoutRecordedBindParameters(existing.Parameters))
+		if err != nil || !bytes.Equal(stored, s) {
 			return true
 		}
 	}
@@ -1,40 +1,112 @@
 package nfsbroker
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
-
+	"path/filepath"
 	"reflect"
+	"time"
 
 	"code.cloudfoundry.org/goshims/ioutilshim"
 	"code.cloudfoundry.org/lager"
 	"github.com/pivotal-cf/brokerapi"
 )
 
+// restoreProgressInterval is how many instances or bindings are restored between progress messages.
+const restoreProgressInterval = 10000
+
 type fileStore struct {
 	fileName     string
 	ioutil       ioutilshim.Ioutil
+	maxValueSize int
 	dynamicState *DynamicState
+
+	// encryption, when set, encrypts the state file.
+	encryption *StateEncryption
+
+	// parameterEncryption, when set, encrypts binding parameters in place of hashing them.
+	parameterEncryption *ParameterEncryption
+
+	// bcryptCost is the cost binding parameters are hashed with, or 0 for bcrypt.DefaultCost.
+	bcryptCost int
+
+	// open reads the state file on restore.  It defaults to reading the whole file through ioutil; NewStore opens
+	// the file instead so that large state files are decoded as they are read.
+	open func(fileName string) (io.ReadCloser, error)
 }
 
 type DynamicState struct {
-	InstanceMap map[string]ServiceInstance
-	BindingMap  map[string]brokerapi.BindDetails
+	InstanceMap        map[string]ServiceInstance
+	BindingMap         map[string]brokerapi.BindDetails
+	BindingInstanceMap map[string]string
+	JobNextRunMap      map[string]time.Time
+	OperationMap       map[string]Operation
+	RetiredPlanMap     map[string]string
+	ReservationMap     map[string]string
+
+	// CorruptInstanceMap and CorruptBindingMap keep the entries that failed to unmarshal when the state file was
+	// restored, so that saving the state does not lose them before they can be repaired.
+	CorruptInstanceMap map[string]json.RawMessage `json:",omitempty"`
+	CorruptBindingMap  map[string]json.RawMessage `json:",omitempty"`
 }
 
 func NewFileStore(
 	fileName string,
 	ioutil ioutilshim.Ioutil,
+	maxValueSize int,
 ) Store {
-	return &fileStore{
-		fileName: fileName,
-		ioutil:   ioutil,
+	return newFileStore(fileName, ioutil, maxValueSize)
+}
+
+func newFileStore(fileName string, ioutil ioutilshim.Ioutil, maxValueSize int) *fileStore {
+	s := &fileStore{
+		fileName:     fileName,
+		ioutil:       ioutil,
+		maxValueSize: maxValueSize,
 		dynamicState: &DynamicState{
-			InstanceMap: make(map[string]ServiceInstance),
-			BindingMap:  make(map[string]brokerapi.BindDetails),
+			InstanceMap:        make(map[string]ServiceInstance),
+			BindingMap:         make(map[string]brokerapi.BindDetails),
+			BindingInstanceMap: make(map[string]string),
+			JobNextRunMap:      make(map[string]time.Time),
+			OperationMap:       make(map[string]Operation),
+			RetiredPlanMap:     make(map[string]string),
+			ReservationMap:     make(map[string]string),
 		},
 	}
+	s.open = s.readFile
+	return s
+}
+
+func (s *fileStore) readFile(fileName string) (io.ReadCloser, error) {
+	data, err := s.ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func openFile(fileName string) (io.ReadCloser, error) {
+	return os.Open(fileName)
+}
+
+// NewEncryptedFileStore returns a file store whose state file is encrypted.  Plaintext state files, and those encrypted
+// with keys other than the first, are rewritten with the first key when they are restored.
+func NewEncryptedFileStore(fileName string, ioutil ioutilshim.Ioutil, maxValueSize int, encryption *StateEncryption) Store {
+	s := newFileStore(fileName, ioutil, maxValueSize)
+	s.encryption = encryption
+	return s
+}
+
+// NewMemoryStore returns a store that keeps its state in memory only, for development and tests.
+func NewMemoryStore(maxValueSize int) Store {
+	return NewFileStore("", nil, maxValueSize)
 }
 
 func (s *fileStore) Restore(logger lager.Logger) error {
@@ -42,20 +114,203 @@ func (s *fileStore) Restore(logger lager.Logger) error {
 	logger.Info("start")
 	defer logger.Info("end")
 
-	serviceData, err := s.ioutil.ReadFile(s.fileName)
+	if s.fileName == "" {
+		return nil
+	}
+
+	file, err := s.open(s.fileName)
 	if err != nil {
 		logger.Error("failed-to-read-state-file", err, lager.Data{"fileName": s.fileName})
 		return err
 	}
+	defer file.Close()
 
-	err = json.Unmarshal(serviceData, s.dynamicState)
+	reader, rewrite, err := s.stateReader(file)
+	if err != nil {
+		logger.Error("failed-to-decrypt-state-file", err, lager.Data{"fileName": s.fileName})
+		return err
+	}
+	state, err := decodeState(logger, reader)
 	if err != nil {
 		logger.Error("failed-to-unmarshall-state from state-file", err, lager.Data{"fileName": s.fileName})
 		return err
 	}
+	*s.dynamicState = state
+
+	if s.dynamicState.BindingInstanceMap == nil {
+		s.dynamicState.BindingInstanceMap = make(map[string]string)
+	}
+	if s.dynamicState.JobNextRunMap == nil {
+		s.dynamicState.JobNextRunMap = make(map[string]time.Time)
+	}
+	if s.dynamicState.OperationMap == nil {
+		s.dynamicState.OperationMap = make(map[string]Operation)
+	}
+	if s.dynamicState.RetiredPlanMap == nil {
+		s.dynamicState.RetiredPlanMap = make(map[string]string)
+	}
+	if s.dynamicState.ReservationMap == nil {
+		s.dynamicState.ReservationMap = make(map[string]string)
+	}
 	logger.Info("state-restored", lager.Data{"fileName": s.fileName})
 
-	return err
+	if rewrite {
+		logger.Info("rewriting-state-file-with-current-key", lager.Data{"fileName": s.fileName})
+		return s.Save(logger)
+	}
+	return nil
+}
+
+// stateReader returns a reader of the plaintext state in a state file, decrypting it if it is encrypted, and whether
+// the state file should be rewritten to encrypt it with the first key.
+func (s *fileStore) stateReader(file io.Reader) (io.Reader, bool, error) {
+	buffered := bufio.NewReader(file)
+	prefix, _ := buffered.Peek(len(encryptedStatePrefix))
+	if !isEncryptedState(prefix) {
+		return buffered, s.encryption != nil, nil
+	}
+	if s.encryption == nil {
+		return nil, false, errors.New("the state file is encrypted, but no state encryption keys were given")
+	}
+
+	// encrypted state files are authenticated as a whole, so they are decrypted before they are decoded
+	data, err := ioutil.ReadAll(buffered)
+	if err != nil {
+		return nil, false, err
+	}
+	plaintext, current, err := s.encryption.decrypt(data)
+	if err != nil {
+		return nil, false, err
+	}
+	return bytes.NewReader(plaintext), !current, nil
+}
+
+// decodeState reads a state file as it is decoded, so that only one instance or binding is held in its serialized
+// form at a time.  Instances and bindings are unmarshaled one by one, setting aside those that are corrupt so that
+// the rest can still be served.  The other maps are small and are decoded whole.
+func decodeState(logger lager.Logger, r io.Reader) (DynamicState, error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	if err := expectDelim(decoder, '{'); err != nil {
+		return DynamicState{}, err
+	}
+
+	state := DynamicState{
+		InstanceMap:        make(map[string]ServiceInstance),
+		BindingMap:         make(map[string]brokerapi.BindDetails),
+		CorruptInstanceMap: make(map[string]json.RawMessage),
+		CorruptBindingMap:  make(map[string]json.RawMessage),
+	}
+	rest := map[string]json.RawMessage{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return DynamicState{}, err
+		}
+		key, _ := token.(string)
+
+		switch key {
+		case "InstanceMap":
+			err = decodeEntries(logger, decoder, "service instance", func(id string, value json.RawMessage) {
+				var instance ServiceInstance
+				if err := unmarshalRecord(logger, "service instance", id, value, &instance); err != nil {
+					state.CorruptInstanceMap[id] = value
+					return
+				}
+				state.InstanceMap[id] = instance
+			})
+		case "BindingMap":
+			err = decodeEntries(logger, decoder, "service binding", func(id string, value json.RawMessage) {
+				var binding brokerapi.BindDetails
+				if err := unmarshalRecord(logger, "service binding", id, value, &binding); err != nil {
+					state.CorruptBindingMap[id] = value
+					return
+				}
+				state.BindingMap[id] = binding
+			})
+		default:
+			var value json.RawMessage
+			err = decoder.Decode(&value)
+			rest[key] = value
+		}
+		if err != nil {
+			return DynamicState{}, err
+		}
+	}
+	if err := expectDelim(decoder, '}'); err != nil {
+		return DynamicState{}, err
+	}
+
+	remaining, err := json.Marshal(rest)
+	if err != nil {
+		return DynamicState{}, err
+	}
+	var other DynamicState
+	if err := json.Unmarshal(remaining, &other); err != nil {
+		return DynamicState{}, err
+	}
+	state.BindingInstanceMap = other.BindingInstanceMap
+	state.JobNextRunMap = other.JobNextRunMap
+	state.OperationMap = other.OperationMap
+	state.RetiredPlanMap = other.RetiredPlanMap
+	state.ReservationMap = other.ReservationMap
+	for id, value := range other.CorruptInstanceMap {
+		state.CorruptInstanceMap[id] = value
+	}
+	for id, value := range other.CorruptBindingMap {
+		state.CorruptBindingMap[id] = value
+	}
+	return state, nil
+}
+
+// decodeEntries passes each entry of the JSON object, or null, that the decoder is at to restore, logging progress
+// every restoreProgressInterval entries.
+func decodeEntries(logger lager.Logger, decoder *json.Decoder, kind string, restore func(id string, value json.RawMessage)) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("expected an object of %ss in the state file, found %v", kind, token)
+	}
+
+	count := 0
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		id, _ := token.(string)
+
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		restore(id, value)
+
+		count++
+		if count%restoreProgressInterval == 0 {
+			logger.Info("restoring-entries", lager.Data{"kind": kind, "count": count, "offset": decoder.InputOffset()})
+		}
+	}
+	if err := expectDelim(decoder, '}'); err != nil {
+		return err
+	}
+	logger.Info("entries-restored", lager.Data{"kind": kind, "count": count})
+	return nil
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v in the state file, found %v", delim, token)
+	}
+	return nil
 }
 
 func (s *fileStore) Save(logger lager.Logger) error {
@@ -63,11 +318,21 @@ func (s *fileStore) Save(logger lager.Logger) error {
 	logger.Info("start")
 	defer logger.Info("end")
 
+	if s.fileName == "" {
+		return nil
+	}
+
 	stateData, err := json.Marshal(s.dynamicState)
 	if err != nil {
 		logger.Error("failed-to-marshall-state", err)
 		return err
 	}
+	if s.encryption != nil {
+		if stateData, err = s.encryption.encrypt(stateData); err != nil {
+			logger.Error("failed-to-encrypt-state", err)
+			return err
+		}
+	}
 
 	err = s.ioutil.WriteFile(s.fileName, stateData, os.ModePerm)
 	if err != nil {
@@ -83,54 +348,205 @@ func (s *fileStore) Cleanup() error {
 	return nil
 }
 
-func (s *fileStore) RetrieveInstanceDetails(id string) (ServiceInstance, error) {
+// CheckHealth checks that the state file can be read and written or, before it is first saved, created.
+func (s *fileStore) CheckHealth(ctx context.Context) error {
+	if s.fileName == "" {
+		return nil
+	}
+	file, err := os.OpenFile(s.fileName, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		if file, err = ioutil.TempFile(filepath.Dir(s.fileName), ".health-"); err == nil {
+			defer os.Remove(file.Name())
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("state file is not usable: %w", err)
+	}
+	return file.Close()
+}
+
+func (s *fileStore) RetrieveInstanceDetails(ctx context.Context, id string) (ServiceInstance, error) {
+	if _, corrupt := s.dynamicState.CorruptInstanceMap[id]; corrupt {
+		return ServiceInstance{}, fmt.Errorf("%w: service instance %s", ErrCorruptRecord, id)
+	}
 	requestedServiceInstance, found := s.dynamicState.InstanceMap[id]
 	if !found {
-		return ServiceInstance{}, errors.New(id + " Not Found.")
+		return ServiceInstance{}, fmt.Errorf("%w: %s", ErrInstanceNotFound, id)
 	}
-	return requestedServiceInstance, nil
+	return withShareComponents(requestedServiceInstance), nil
 }
 
-func (s *fileStore) RetrieveBindingDetails(id string) (brokerapi.BindDetails, error) {
+func (s *fileStore) RetrieveBindingDetails(ctx context.Context, id string) (brokerapi.BindDetails, error) {
+	if _, corrupt := s.dynamicState.CorruptBindingMap[id]; corrupt {
+		return brokerapi.BindDetails{}, fmt.Errorf("%w: service binding %s", ErrCorruptRecord, id)
+	}
 	requestedBindingInstance, found := s.dynamicState.BindingMap[id]
 	if !found {
-		return brokerapi.BindDetails{}, errors.New(id + " Not Found.")
+		return brokerapi.BindDetails{}, fmt.Errorf("%w: %s", ErrBindingNotFound, id)
 	}
-	return requestedBindingInstance, nil
+	return revealBindingDetails(id, requestedBindingInstance, s.parameterEncryption)
 }
-func (s *fileStore) CreateInstanceDetails(id string, details ServiceInstance) error {
+func (s *fileStore) CreateInstanceDetails(ctx context.Context, id string, details ServiceInstance) error {
+	jsonData, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	if err := checkValueSize("service instance", id, jsonData, s.maxValueSize); err != nil {
+		return err
+	}
 	s.dynamicState.InstanceMap[id] = details
 	return nil
 }
-func (s *fileStore) CreateBindingDetails(id string, details brokerapi.BindDetails) error {
-	storeDetails, err := redactBindingDetails(details)
+func (s *fileStore) CreateBindingDetails(ctx context.Context, instanceID, id string, details brokerapi.BindDetails) error {
+	storeDetails, err := redactBindingDetails(id, details, s.parameterEncryption, s.bcryptCost)
+	if err != nil {
+		return err
+	}
+	jsonData, err := json.Marshal(storeDetails)
 	if err != nil {
 		return err
 	}
+	if err := checkValueSize("service binding", id, jsonData, s.maxValueSize); err != nil {
+		return err
+	}
 	s.dynamicState.BindingMap[id] = storeDetails
+	s.dynamicState.BindingInstanceMap[id] = instanceID
 	return nil
 }
-func (s *fileStore) DeleteInstanceDetails(id string) error {
+func (s *fileStore) UpdateInstanceDetails(ctx context.Context, id string, details ServiceInstance) error {
+	if _, found := s.dynamicState.InstanceMap[id]; !found {
+		return fmt.Errorf("%w: %s", ErrInstanceNotFound, id)
+	}
+	return s.CreateInstanceDetails(ctx, id, details)
+}
+func (s *fileStore) DeleteInstanceDetails(ctx context.Context, id string) error {
 	_, found := s.dynamicState.InstanceMap[id]
 	if !found {
-		return errors.New(id + " Not Found.")
+		return fmt.Errorf("%w: %s", ErrInstanceNotFound, id)
 	}
 
 	delete(s.dynamicState.InstanceMap, id)
 	return nil
 }
-func (s *fileStore) DeleteBindingDetails(id string) error {
+func (s *fileStore) DeleteBindingDetails(ctx context.Context, id string) error {
 	_, found := s.dynamicState.BindingMap[id]
 	if !found {
-		return errors.New(id + " Not Found.")
+		return fmt.Errorf("%w: %s", ErrBindingNotFound, id)
 	}
 
 	delete(s.dynamicState.BindingMap, id)
+	delete(s.dynamicState.BindingInstanceMap, id)
+	return nil
+}
+
+func (s *fileStore) ListInstanceDetails(ctx context.Context, opts ListOptions) (map[string]ServiceInstance, error) {
+	ids := []string{}
+	for id, details := range s.dynamicState.InstanceMap {
+		if opts.matches(id, details.ServiceID, details.PlanID) {
+			ids = append(ids, id)
+		}
+	}
+
+	instances := map[string]ServiceInstance{}
+	for _, id := range opts.pageIDs(ids) {
+		instances[id] = withShareComponents(s.dynamicState.InstanceMap[id])
+	}
+	return instances, nil
+}
+
+func (s *fileStore) ListBindingDetails(ctx context.Context, opts ListOptions) (map[string]brokerapi.BindDetails, error) {
+	ids := []string{}
+	for id, details := range s.dynamicState.BindingMap {
+		if opts.matches(id, details.ServiceID, details.PlanID) {
+			ids = append(ids, id)
+		}
+	}
+
+	bindings := map[string]brokerapi.BindDetails{}
+	for _, id := range opts.pageIDs(ids) {
+		details, err := revealBindingDetails(id, s.dynamicState.BindingMap[id], s.parameterEncryption)
+		if err != nil {
+			return nil, err
+		}
+		bindings[id] = details
+	}
+	return bindings, nil
+}
+
+func (s *fileStore) ListBindingInstances(ctx context.Context) (map[string]string, error) {
+	bindingInstances := make(map[string]string, len(s.dynamicState.BindingMap))
+	for id := range s.dynamicState.BindingMap {
+		bindingInstances[id] = s.dynamicState.BindingInstanceMap[id]
+	}
+	return bindingInstances, nil
+}
+
+func (s *fileStore) CountInstances(ctx context.Context) (int, error) {
+	return len(s.dynamicState.InstanceMap), nil
+}
+
+func (s *fileStore) CountBindings(ctx context.Context) (int, error) {
+	return len(s.dynamicState.BindingMap), nil
+}
+
+func (s *fileStore) RetrieveJobNextRun(ctx context.Context, name string) (time.Time, error) {
+	return s.dynamicState.JobNextRunMap[name], nil
+}
+
+func (s *fileStore) SaveJobNextRun(ctx context.Context, name string, next time.Time) error {
+	s.dynamicState.JobNextRunMap[name] = next
+	return nil
+}
+
+func (s *fileStore) RetrieveOperation(ctx context.Context, instanceID string) (Operation, error) {
+	return s.dynamicState.OperationMap[instanceID], nil
+}
+
+func (s *fileStore) SaveOperation(ctx context.Context, instanceID string, operation Operation) error {
+	s.dynamicState.OperationMap[instanceID] = operation
+	return nil
+}
+
+func (s *fileStore) ListRetiredPlans(ctx context.Context) (map[string]string, error) {
+	retiredPlans := map[string]string{}
+	for planID, hint := range s.dynamicState.RetiredPlanMap {
+		retiredPlans[planID] = hint
+	}
+	return retiredPlans, nil
+}
+
+func (s *fileStore) RetirePlan(ctx context.Context, planID, hint string) error {
+	s.dynamicState.RetiredPlanMap[planID] = hint
+	return nil
+}
+
+func (s *fileStore) ReinstatePlan(ctx context.Context, planID string) error {
+	delete(s.dynamicState.RetiredPlanMap, planID)
+	return nil
+}
+
+func (s *fileStore) ListShareReservations(ctx context.Context) (map[string]string, error) {
+	reservations := map[string]string{}
+	for prefix, orgGUID := range s.dynamicState.ReservationMap {
+		reservations[prefix] = orgGUID
+	}
+	return reservations, nil
+}
+
+func (s *fileStore) ReserveShares(ctx context.Context, prefix, orgGUID string) error {
+	s.dynamicState.ReservationMap[prefix] = orgGUID
+	return nil
+}
+
+func (s *fileStore) ReleaseShares(ctx context.Context, prefix string) error {
+	delete(s.dynamicState.ReservationMap, prefix)
 	return nil
 }
 
-func (s *fileStore) IsInstanceConflict(id string, details ServiceInstance) bool {
-	if existing, err := s.RetrieveInstanceDetails(id); err == nil {
+func (s *fileStore) IsInstanceConflict(ctx context.Context, id string, details ServiceInstance) bool {
+	if existing, err := s.RetrieveInstanceDetails(ctx, id); err == nil {
+		// the dashboard URL is chosen by the broker, not requested
+		existing.DashboardURL = details.DashboardURL
 		if !reflect.DeepEqual(details, existing) {
 			return true
 		}
@@ -138,6 +554,6 @@ func (s *fileStore) IsInstanceConflict(id string, details ServiceInstance) bool
 	return false
 }
 
-func (s *fileStore) IsBindingConflict(id string, details brokerapi.BindDetails) bool {
-	return isBindingConflict(s, id, details)
+func (s *fileStore) IsBindingConflict(ctx context.Context, id string, details brokerapi.BindDetails) bool {
+	return isBindingConflict(ctx, s, id, details)
 }
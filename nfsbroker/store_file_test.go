@@ -1,13 +1,21 @@
 package nfsbroker_test
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"code.cloudfoundry.org/goshims/ioutilshim/ioutil_fake"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagertest"
 	"code.cloudfoundry.org/nfsbroker/nfsbroker"
 	"github.com/pivotal-cf/brokerapi"
+	"golang.org/x/crypto/bcrypt"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -18,13 +26,15 @@ var _ = Describe("FileStore", func() {
 		store      nfsbroker.Store
 		fakeIoutil *ioutil_fake.FakeIoutil
 		logger     lager.Logger
+		ctx        context.Context
 		state      nfsbroker.DynamicState
 	)
 
 	BeforeEach(func() {
 		logger = lagertest.NewTestLogger("test-broker")
+		ctx = context.TODO()
 		fakeIoutil = &ioutil_fake.FakeIoutil{}
-		store = nfsbroker.NewFileStore("/tmp/whatever", fakeIoutil)
+		store = nfsbroker.NewFileStore("/tmp/whatever", fakeIoutil, nfsbroker.DefaultMaxValueSize)
 		state = nfsbroker.DynamicState{
 			InstanceMap: map[string]nfsbroker.ServiceInstance{
 				"service-name": {
@@ -52,6 +62,97 @@ var _ = Describe("FileStore", func() {
 			})
 		})
 
+		Context("when the file was written before bindings recorded their instance", func() {
+			BeforeEach(func() {
+				fakeIoutil.ReadFileReturns([]byte(`{"InstanceMap":{},"BindingMap":{"binding-id":{"app_guid":"app"}}}`), nil)
+				err = store.Restore(logger)
+			})
+
+			It("lists the bindings without an instance", func() {
+				Expect(err).ToNot(HaveOccurred())
+				bindingInstances, err := store.ListBindingInstances(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(bindingInstances).To(Equal(map[string]string{"binding-id": ""}))
+			})
+		})
+
+		Context("when an entry in the file is corrupt", func() {
+			var corruptBefore uint64
+
+			BeforeEach(func() {
+				corruptBefore = nfsbroker.CorruptRecordCount()
+				fakeIoutil.ReadFileReturns([]byte(`{"InstanceMap":{"good-id":{"Share":"server:/share"},"bad-id":"junk"},"BindingMap":{}}`), nil)
+				err = store.Restore(logger)
+			})
+
+			It("restores the other entries and reports the corrupt one", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(nfsbroker.CorruptRecordCount()).To(Equal(corruptBefore + 1))
+
+				instances, err := store.ListInstanceDetails(ctx, nfsbroker.ListOptions{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(instances).To(HaveLen(1))
+				Expect(instances).To(HaveKey("good-id"))
+
+				_, err = store.RetrieveInstanceDetails(ctx, "bad-id")
+				Expect(errors.Is(err, nfsbroker.ErrCorruptRecord)).To(BeTrue())
+			})
+
+			It("keeps the corrupt entry when saving", func() {
+				Expect(store.Save(logger)).To(Succeed())
+				_, data, _ := fakeIoutil.WriteFileArgsForCall(0)
+				Expect(string(data)).To(ContainSubstring(`"CorruptInstanceMap":{"bad-id":"junk"}`))
+			})
+		})
+
+		Context("when the file is large", func() {
+			var testLogger *lagertest.TestLogger
+
+			BeforeEach(func() {
+				var data strings.Builder
+				data.WriteString(`{"OperationMap":{"instance-0":{"Type":"provision","State":"succeeded"}},"InstanceMap":{`)
+				for i := 0; i < 10001; i++ {
+					if i > 0 {
+						data.WriteString(",")
+					}
+					fmt.Fprintf(&data, `"instance-%d":{"Share":"server:/share-%d"}`, i, i)
+				}
+				data.WriteString(`},"BindingMap":null}`)
+				fakeIoutil.ReadFileReturns([]byte(data.String()), nil)
+
+				testLogger = lagertest.NewTestLogger("test-restore")
+				err = store.Restore(testLogger)
+			})
+
+			It("restores every entry and logs its progress", func() {
+				Expect(err).ToNot(HaveOccurred())
+				instances, err := store.ListInstanceDetails(ctx, nfsbroker.ListOptions{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(instances).To(HaveLen(10001))
+				Expect(instances["instance-10000"].Share).To(Equal("server:/share-10000"))
+				Expect(store.RetrieveOperation(ctx, "instance-0")).To(Equal(nfsbroker.Operation{Type: "provision", State: "succeeded"}))
+
+				Expect(testLogger.LogMessages()).To(ContainElement("test-restore.restore-state.restoring-entries"))
+			})
+		})
+
+		Context("when the file is truncated", func() {
+			BeforeEach(func() {
+				Expect(store.CreateInstanceDetails(ctx, "existing-id", nfsbroker.ServiceInstance{Share: "server:/share"})).To(Succeed())
+				fakeIoutil.ReadFileReturns([]byte(`{"InstanceMap":{"good-id":{"Share":"server:/share"},"other-id":{"Sha`), nil)
+				err = store.Restore(logger)
+			})
+
+			It("returns an error and keeps the state it had", func() {
+				Expect(err).To(HaveOccurred())
+				existing, err := store.RetrieveInstanceDetails(ctx, "existing-id")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(existing.Share).To(Equal("server:/share"))
+				_, err = store.RetrieveInstanceDetails(ctx, "good-id")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
 		Context("when the file system is failing", func() {
 			BeforeEach(func() {
 				fakeIoutil.ReadFileReturns(nil, errors.New("badness"))
@@ -128,7 +229,7 @@ var _ = Describe("FileStore", func() {
 			inInstanceDetails  nfsbroker.ServiceInstance
 		)
 		JustBeforeEach(func() {
-			outInstanceDetails, err = store.RetrieveInstanceDetails(instanceID)
+			outInstanceDetails, err = store.RetrieveInstanceDetails(ctx, instanceID)
 		})
 
 		Context("when details not found", func() {
@@ -137,6 +238,19 @@ var _ = Describe("FileStore", func() {
 			})
 
 			It("then will error", func() {
+				Expect(errors.Is(err, nfsbroker.ErrInstanceNotFound)).To(BeTrue())
+			})
+		})
+
+		Context("when details are too large to store", func() {
+			BeforeEach(func() {
+				instanceID = "tooBig"
+				inInstanceDetails = nfsbroker.ServiceInstance{ServiceID: "sample-service", Share: "server:/" + strings.Repeat("a", nfsbroker.DefaultMaxValueSize)}
+			})
+
+			It("then will refuse to create them", func() {
+				createErr := store.CreateInstanceDetails(ctx, instanceID, inInstanceDetails)
+				Expect(createErr).To(MatchError(ContainSubstring("too large to store")))
 				Expect(err).To(HaveOccurred())
 			})
 		})
@@ -145,27 +259,47 @@ var _ = Describe("FileStore", func() {
 			BeforeEach(func() {
 				instanceID = "somethingGood"
 				inInstanceDetails = nfsbroker.ServiceInstance{ServiceID: "sample-service"}
-				store.CreateInstanceDetails(instanceID, inInstanceDetails)
+				store.CreateInstanceDetails(ctx, instanceID, inInstanceDetails)
 			})
 			It("then will find instance details", func() {
 				Expect(outInstanceDetails).To(Equal(inInstanceDetails))
 			})
 
+			It("lists the instance", func() {
+				instances, err := store.ListInstanceDetails(ctx, nfsbroker.ListOptions{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(instances).To(Equal(map[string]nfsbroker.ServiceInstance{instanceID: inInstanceDetails}))
+			})
+
 			It("reports conflicts correctly", func() {
-				Expect(store.IsInstanceConflict(instanceID, inInstanceDetails)).To(BeFalse())
+				Expect(store.IsInstanceConflict(ctx, instanceID, inInstanceDetails)).To(BeFalse())
 				otherInstance := nfsbroker.ServiceInstance{ServiceID: "sample-service", PlanID: "foo"}
-				Expect(store.IsInstanceConflict(instanceID, otherInstance)).To(BeTrue())
+				Expect(store.IsInstanceConflict(ctx, instanceID, otherInstance)).To(BeTrue())
+			})
+
+			It("updates the instance details", func() {
+				updated := nfsbroker.ServiceInstance{ServiceID: "sample-service", PlanID: "other-plan"}
+				Expect(store.UpdateInstanceDetails(ctx, instanceID, updated)).To(Succeed())
+
+				details, err := store.RetrieveInstanceDetails(ctx, instanceID)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(details).To(Equal(updated))
+			})
+
+			It("refuses to update missing instances", func() {
+				err := store.UpdateInstanceDetails(ctx, "garbage", inInstanceDetails)
+				Expect(errors.Is(err, nfsbroker.ErrInstanceNotFound)).To(BeTrue())
 			})
 
 			Context("when deleting", func() {
 				JustBeforeEach(func() {
-					err = store.DeleteInstanceDetails(instanceID)
+					err = store.DeleteInstanceDetails(ctx, instanceID)
 				})
 				It("then should not error", func() {
 					Expect(err).ToNot(HaveOccurred())
 				})
 				It("then should not be able to delete again", func() {
-					err = store.DeleteInstanceDetails(instanceID)
+					err = store.DeleteInstanceDetails(ctx, instanceID)
 					Expect(err).To(HaveOccurred())
 				})
 			})
@@ -179,7 +313,7 @@ var _ = Describe("FileStore", func() {
 				inBindingDetails  brokerapi.BindDetails
 			)
 			JustBeforeEach(func() {
-				outBindingDetails, err = store.RetrieveBindingDetails(bindingID)
+				outBindingDetails, err = store.RetrieveBindingDetails(ctx, bindingID)
 			})
 
 			Context("when details not found", func() {
@@ -195,32 +329,51 @@ var _ = Describe("FileStore", func() {
 			Context("when details found", func() {
 				BeforeEach(func() {
 					bindingID = "somethingGood"
-					inBindingDetails = brokerapi.BindDetails{ServiceID: "sample-service", Parameters: map[string]interface{}{"ping": "pong"}}
-					store.CreateBindingDetails(bindingID, inBindingDetails)
+					inBindingDetails = brokerapi.BindDetails{ServiceID: "sample-service", Parameters: map[string]interface{}{"ping": "pong", nfsbroker.Secret: "keytab"}}
+					store.CreateBindingDetails(ctx, "instance-id", bindingID, inBindingDetails)
 				})
 				It("then will find binding details", func() {
 					Expect(outBindingDetails.ServiceID).To(Equal(inBindingDetails.ServiceID))
 				})
 
 				It("reports conflicts correctly", func() {
-					Expect(store.IsBindingConflict(bindingID, inBindingDetails)).To(BeFalse())
+					Expect(store.IsBindingConflict(ctx, bindingID, inBindingDetails)).To(BeFalse())
 					otherBindingDetails := brokerapi.BindDetails{ServiceID: "sample-service", Parameters: map[string]interface{}{"foo": "foo"}}
-					Expect(store.IsBindingConflict(bindingID, otherBindingDetails)).To(BeTrue())
+					Expect(store.IsBindingConflict(ctx, bindingID, otherBindingDetails)).To(BeTrue())
 					otherBindingDetails = brokerapi.BindDetails{ServiceID: "sample-service"}
-					Expect(store.IsBindingConflict(bindingID, otherBindingDetails)).To(BeTrue())
+					Expect(store.IsBindingConflict(ctx, bindingID, otherBindingDetails)).To(BeTrue())
 					otherBindingDetails = brokerapi.BindDetails{ServiceID: "sample-service", Parameters: map[string]interface{}{}}
-					Expect(store.IsBindingConflict(bindingID, otherBindingDetails)).To(BeTrue())
+					Expect(store.IsBindingConflict(ctx, bindingID, otherBindingDetails)).To(BeTrue())
+				})
+
+				It("lists the binding with its parameters redacted", func() {
+					bindings, err := store.ListBindingDetails(ctx, nfsbroker.ListOptions{})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(bindings).To(HaveKey(bindingID))
+					Expect(bindings[bindingID].ServiceID).To(Equal(inBindingDetails.ServiceID))
+					Expect(bindings[bindingID].Parameters).To(HaveKey(nfsbroker.HashKey))
+					Expect(bindings[bindingID].Parameters).To(HaveKeyWithValue("ping", "pong"))
+					Expect(bindings[bindingID].Parameters).NotTo(HaveKey(nfsbroker.Secret))
+				})
+
+				It("records the instance the binding belongs to", func() {
+					bindingInstances, err := store.ListBindingInstances(ctx)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(bindingInstances).To(Equal(map[string]string{bindingID: "instance-id"}))
 				})
 
 				Context("when deleting", func() {
 					JustBeforeEach(func() {
-						err = store.DeleteBindingDetails(bindingID)
+						err = store.DeleteBindingDetails(ctx, bindingID)
 					})
 					It("then should not error", func() {
 						Expect(err).ToNot(HaveOccurred())
+						bindingInstances, err := store.ListBindingInstances(ctx)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(bindingInstances).To(BeEmpty())
 					})
 					It("then should not be able to delete again", func() {
-						err = store.DeleteBindingDetails(bindingID)
+						err = store.DeleteBindingDetails(ctx, bindingID)
 						Expect(err).To(HaveOccurred())
 					})
 				})
@@ -228,4 +381,147 @@ var _ = Describe("FileStore", func() {
 			})
 		})
 	})
+
+	Describe("job next run times", func() {
+		It("returns the zero time for a job that has never been scheduled", func() {
+			nextRun, err := store.RetrieveJobNextRun(ctx, "some-job")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nextRun.IsZero()).To(BeTrue())
+		})
+
+		It("returns the saved time", func() {
+			next := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+			Expect(store.SaveJobNextRun(ctx, "some-job", next)).To(Succeed())
+			nextRun, err := store.RetrieveJobNextRun(ctx, "some-job")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nextRun).To(Equal(next))
+		})
+	})
+
+	Describe("operations", func() {
+		It("returns the saved operation", func() {
+			operation := nfsbroker.Operation{Type: nfsbroker.ProvisionOperation, State: brokerapi.InProgress}
+			Expect(store.SaveOperation(ctx, "instance-1", operation)).To(Succeed())
+			Expect(store.RetrieveOperation(ctx, "instance-1")).To(Equal(operation))
+		})
+	})
+
+	Describe("retired plans", func() {
+		It("lists retired plans until they are reinstated", func() {
+			Expect(store.ListRetiredPlans(ctx)).To(BeEmpty())
+			Expect(store.RetirePlan(ctx, "plan-1", "use plan-2")).To(Succeed())
+			Expect(store.ListRetiredPlans(ctx)).To(Equal(map[string]string{"plan-1": "use plan-2"}))
+			Expect(store.ReinstatePlan(ctx, "plan-1")).To(Succeed())
+			Expect(store.ListRetiredPlans(ctx)).To(BeEmpty())
+		})
+
+		It("survives a save and restore", func() {
+			Expect(store.RetirePlan(ctx, "plan-1", "use plan-2")).To(Succeed())
+			Expect(store.Save(logger)).To(Succeed())
+			_, data, _ := fakeIoutil.WriteFileArgsForCall(0)
+
+			restored := nfsbroker.NewFileStore("/tmp/whatever", fakeIoutil, nfsbroker.DefaultMaxValueSize)
+			fakeIoutil.ReadFileReturns(data, nil)
+			Expect(restored.Restore(logger)).To(Succeed())
+			Expect(restored.ListRetiredPlans(ctx)).To(Equal(map[string]string{"plan-1": "use plan-2"}))
+		})
+	})
+
+	Describe("share reservations", func() {
+		It("lists reservations until they are released", func() {
+			Expect(store.ListShareReservations(ctx)).To(BeEmpty())
+			Expect(store.ReserveShares(ctx, "/exports/teamA", "org-1")).To(Succeed())
+			Expect(store.ReserveShares(ctx, "/exports/teamA", "org-2")).To(Succeed())
+			Expect(store.ListShareReservations(ctx)).To(Equal(map[string]string{"/exports/teamA": "org-2"}))
+			Expect(store.ReleaseShares(ctx, "/exports/teamA")).To(Succeed())
+			Expect(store.ListShareReservations(ctx)).To(BeEmpty())
+		})
+
+		It("survives a save and restore", func() {
+			Expect(store.ReserveShares(ctx, "/exports/teamA", "org-1")).To(Succeed())
+			Expect(store.Save(logger)).To(Succeed())
+			_, data, _ := fakeIoutil.WriteFileArgsForCall(0)
+
+			restored := nfsbroker.NewFileStore("/tmp/whatever", fakeIoutil, nfsbroker.DefaultMaxValueSize)
+			fakeIoutil.ReadFileReturns(data, nil)
+			Expect(restored.Restore(logger)).To(Succeed())
+			Expect(restored.ListShareReservations(ctx)).To(Equal(map[string]string{"/exports/teamA": "org-1"}))
+		})
+	})
+
+	Describe("listing with options", func() {
+		BeforeEach(func() {
+			for _, id := range []string{"instance-c", "instance-a", "instance-d", "instance-b"} {
+				planID := "plan-1"
+				if id == "instance-d" {
+					planID = "plan-2"
+				}
+				Expect(store.CreateInstanceDetails(ctx, id, nfsbroker.ServiceInstance{ServiceID: "service-1", PlanID: planID})).To(Succeed())
+			}
+		})
+
+		It("filters by plan", func() {
+			instances, err := store.ListInstanceDetails(ctx, nfsbroker.ListOptions{PlanID: "plan-2"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(1))
+			Expect(instances).To(HaveKey("instance-d"))
+		})
+
+		It("pages in ID order", func() {
+			instances, err := store.ListInstanceDetails(ctx, nfsbroker.ListOptions{After: "instance-a", Limit: 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(2))
+			Expect(instances).To(HaveKey("instance-b"))
+			Expect(instances).To(HaveKey("instance-c"))
+		})
+
+		It("counts instances", func() {
+			count, err := store.CountInstances(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(4))
+		})
+
+		It("visits every matching instance a page at a time", func() {
+			var visited []string
+			err := nfsbroker.ForEachInstance(ctx, store, nfsbroker.ListOptions{PlanID: "plan-1"}, 2, func(id string, details nfsbroker.ServiceInstance) error {
+				visited = append(visited, id)
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(visited).To(Equal([]string{"instance-a", "instance-b", "instance-c"}))
+		})
+	})
+
+	Describe("bcrypt cost", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "bcrypt-cost")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("hashes binding parameters with the given cost", func() {
+			store = nfsbroker.NewStore(logger, "", "", "", "", "", "", "", filepath.Join(dir, "state.json"), nfsbroker.DefaultMaxValueSize, 0, nil, nil, nfsbroker.MinBcryptCost)
+			details := brokerapi.BindDetails{AppGUID: "app-guid", Parameters: map[string]interface{}{"password": "s3cret"}}
+			Expect(store.CreateBindingDetails(ctx, "instance-id", "binding-id", details)).To(Succeed())
+
+			stored, err := store.RetrieveBindingDetails(ctx, "binding-id")
+			Expect(err).NotTo(HaveOccurred())
+			cost, err := bcrypt.Cost([]byte(stored.Parameters[nfsbroker.HashKey].(string)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cost).To(Equal(nfsbroker.MinBcryptCost))
+			Expect(store.IsBindingConflict(ctx, "binding-id", details)).To(BeFalse())
+		})
+
+		It("refuses costs out of bounds", func() {
+			Expect(nfsbroker.CheckBcryptCost(bcrypt.DefaultCost)).To(Succeed())
+			Expect(nfsbroker.CheckBcryptCost(nfsbroker.MinBcryptCost - 1)).To(MatchError(ContainSubstring("bcrypt cost must be between")))
+			Expect(nfsbroker.CheckBcryptCost(nfsbroker.MaxBcryptCost + 1)).To(HaveOccurred())
+		})
+	})
 })
@@ -0,0 +1,270 @@
+package nfsbroker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// MetricsStore records how long each call to the store it wraps takes, and whether it fails, by the kind of backend
+// that answered it.
+type MetricsStore struct {
+	store   Store
+	metrics Metrics
+}
+
+func NewMetricsStore(store Store, metrics Metrics) *MetricsStore {
+	return &MetricsStore{store: store, metrics: metrics}
+}
+
+// Unwrap returns the wrapped store.
+func (s *MetricsStore) Unwrap() Store {
+	return s.store
+}
+
+// unwrapStore returns the store that store records metrics of, or store itself.
+func unwrapStore(store Store) Store {
+	if wrapped, ok := store.(*MetricsStore); ok {
+		return wrapped.Unwrap()
+	}
+	return store
+}
+
+// StoreBackend names the kind of backend a store keeps its state in: the SQL driver, such as "mysql" or "postgres",
+// "file" or "memory".  A switchable store is named after its active store.
+func StoreBackend(store Store) string {
+	switch store := store.(type) {
+	case *SqlStore:
+		return store.StoreType
+	case *fileStore:
+		if store.fileName == "" {
+			return "memory"
+		}
+		return "file"
+	case *SwitchableStore:
+		store.mutex.RLock()
+		defer store.mutex.RUnlock()
+		return StoreBackend(store.active)
+	case *MetricsStore:
+		return StoreBackend(store.store)
+	}
+	return "other"
+}
+
+// observe records a store call that started at started and returned err.  Lookups of instances and bindings that do
+// not exist are not failures of the store.
+func (s *MetricsStore) observe(operation string, started time.Time, err error) {
+	if errors.Is(err, ErrInstanceNotFound) || errors.Is(err, ErrBindingNotFound) {
+		err = nil
+	}
+	s.metrics.ObserveStoreOperation(StoreBackend(s.store), operation, time.Since(started), err)
+}
+
+func (s *MetricsStore) RetrieveInstanceDetails(ctx context.Context, id string) (ServiceInstance, error) {
+	started := time.Now()
+	result, err := s.store.RetrieveInstanceDetails(ctx, id)
+	s.observe("RetrieveInstanceDetails", started, err)
+	return result, err
+}
+
+func (s *MetricsStore) RetrieveBindingDetails(ctx context.Context, id string) (brokerapi.BindDetails, error) {
+	started := time.Now()
+	result, err := s.store.RetrieveBindingDetails(ctx, id)
+	s.observe("RetrieveBindingDetails", started, err)
+	return result, err
+}
+
+func (s *MetricsStore) CreateInstanceDetails(ctx context.Context, id string, details ServiceInstance) error {
+	started := time.Now()
+	err := s.store.CreateInstanceDetails(ctx, id, details)
+	s.observe("CreateInstanceDetails", started, err)
+	return err
+}
+
+func (s *MetricsStore) CreateBindingDetails(ctx context.Context, instanceID, id string, details brokerapi.BindDetails) error {
+	started := time.Now()
+	err := s.store.CreateBindingDetails(ctx, instanceID, id, details)
+	s.observe("CreateBindingDetails", started, err)
+	return err
+}
+
+func (s *MetricsStore) UpdateInstanceDetails(ctx context.Context, id string, details ServiceInstance) error {
+	started := time.Now()
+	err := s.store.UpdateInstanceDetails(ctx, id, details)
+	s.observe("UpdateInstanceDetails", started, err)
+	return err
+}
+
+func (s *MetricsStore) DeleteInstanceDetails(ctx context.Context, id string) error {
+	started := time.Now()
+	err := s.store.DeleteInstanceDetails(ctx, id)
+	s.observe("DeleteInstanceDetails", started, err)
+	return err
+}
+
+func (s *MetricsStore) DeleteBindingDetails(ctx context.Context, id string) error {
+	started := time.Now()
+	err := s.store.DeleteBindingDetails(ctx, id)
+	s.observe("DeleteBindingDetails", started, err)
+	return err
+}
+
+func (s *MetricsStore) ListInstanceDetails(ctx context.Context, opts ListOptions) (map[string]ServiceInstance, error) {
+	started := time.Now()
+	result, err := s.store.ListInstanceDetails(ctx, opts)
+	s.observe("ListInstanceDetails", started, err)
+	return result, err
+}
+
+func (s *MetricsStore) ListBindingDetails(ctx context.Context, opts ListOptions) (map[string]brokerapi.BindDetails, error) {
+	started := time.Now()
+	result, err := s.store.ListBindingDetails(ctx, opts)
+	s.observe("ListBindingDetails", started, err)
+	return result, err
+}
+
+func (s *MetricsStore) ListBindingInstances(ctx context.Context) (map[string]string, error) {
+	started := time.Now()
+	result, err := s.store.ListBindingInstances(ctx)
+	s.observe("ListBindingInstances", started, err)
+	return result, err
+}
+
+func (s *MetricsStore) CountInstances(ctx context.Context) (int, error) {
+	started := time.Now()
+	result, err := s.store.CountInstances(ctx)
+	s.observe("CountInstances", started, err)
+	return result, err
+}
+
+func (s *MetricsStore) CountBindings(ctx context.Context) (int, error) {
+	started := time.Now()
+	result, err := s.store.CountBindings(ctx)
+	s.observe("CountBindings", started, err)
+	return result, err
+}
+
+func (s *MetricsStore) RetrieveJobNextRun(ctx context.Context, name string) (time.Time, error) {
+	started := time.Now()
+	result, err := s.store.RetrieveJobNextRun(ctx, name)
+	s.observe("RetrieveJobNextRun", started, err)
+	return result, err
+}
+
+func (s *MetricsStore) SaveJobNextRun(ctx context.Context, name string, next time.Time) error {
+	started := time.Now()
+	err := s.store.SaveJobNextRun(ctx, name, next)
+	s.observe("SaveJobNextRun", started, err)
+	return err
+}
+
+func (s *MetricsStore) RetrieveOperation(ctx context.Context, instanceID string) (Operation, error) {
+	started := time.Now()
+	result, err := s.store.RetrieveOperation(ctx, instanceID)
+	s.observe("RetrieveOperation", started, err)
+	return result, err
+}
+
+func (s *MetricsStore) SaveOperation(ctx context.Context, instanceID string, operation Operation) error {
+	started := time.Now()
+	err := s.store.SaveOperation(ctx, instanceID, operation)
+	s.observe("SaveOperation", started, err)
+	return err
+}
+
+func (s *MetricsStore) ListRetiredPlans(ctx context.Context) (map[string]string, error) {
+	started := time.Now()
+	result, err := s.store.ListRetiredPlans(ctx)
+	s.observe("ListRetiredPlans", started, err)
+	return result, err
+}
+
+func (s *MetricsStore) RetirePlan(ctx context.Context, planID, hint string) error {
+	started := time.Now()
+	err := s.store.RetirePlan(ctx, planID, hint)
+	s.observe("RetirePlan", started, err)
+	return err
+}
+
+func (s *MetricsStore) ReinstatePlan(ctx context.Context, planID string) error {
+	started := time.Now()
+	err := s.store.ReinstatePlan(ctx, planID)
+	s.observe("ReinstatePlan", started, err)
+	return err
+}
+
+func (s *MetricsStore) ListShareReservations(ctx context.Context) (map[string]string, error) {
+	started := time.Now()
+	result, err := s.store.ListShareReservations(ctx)
+	s.observe("ListShareReservations", started, err)
+	return result, err
+}
+
+func (s *MetricsStore) ReserveShares(ctx context.Context, prefix, orgGUID string) error {
+	started := time.Now()
+	err := s.store.ReserveShares(ctx, prefix, orgGUID)
+	s.observe("ReserveShares", started, err)
+	return err
+}
+
+func (s *MetricsStore) ReleaseShares(ctx context.Context, prefix string) error {
+	started := time.Now()
+	err := s.store.ReleaseShares(ctx, prefix)
+	s.observe("ReleaseShares", started, err)
+	return err
+}
+
+func (s *MetricsStore) IsInstanceConflict(ctx context.Context, id string, details ServiceInstance) bool {
+	started := time.Now()
+	conflict := s.store.IsInstanceConflict(ctx, id, details)
+	s.observe("IsInstanceConflict", started, nil)
+	return conflict
+}
+
+func (s *MetricsStore) IsBindingConflict(ctx context.Context, id string, details brokerapi.BindDetails) bool {
+	started := time.Now()
+	conflict := s.store.IsBindingConflict(ctx, id, details)
+	s.observe("IsBindingConflict", started, nil)
+	return conflict
+}
+
+func (s *MetricsStore) Restore(logger lager.Logger) error {
+	started := time.Now()
+	err := s.store.Restore(logger)
+	s.observe("Restore", started, err)
+	return err
+}
+
+func (s *MetricsStore) Save(logger lager.Logger) error {
+	started := time.Now()
+	err := s.store.Save(logger)
+	s.observe("Save", started, err)
+	return err
+}
+
+func (s *MetricsStore) Cleanup() error {
+	return s.store.Cleanup()
+}
+
+// CheckHealth checks the wrapped store, if it can be checked.
+func (s *MetricsStore) CheckHealth(ctx context.Context) error {
+	if checker, ok := s.store.(HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// ListChanges delegates to the wrapped store, if it records changes.
+func (s *MetricsStore) ListChanges(ctx context.Context, since int64, limit int) ([]AuditEntry, error) {
+	feed, ok := s.store.(ChangeFeed)
+	if !ok {
+		return nil, ErrNoChangeFeed
+	}
+	started := time.Now()
+	changes, err := feed.ListChanges(ctx, since, limit)
+	s.observe("ListChanges", started, err)
+	return changes, err
+}
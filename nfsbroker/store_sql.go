@@ -1,7 +1,11 @@
 package nfsbroker
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	//"encoding/json"
 
@@ -10,47 +14,129 @@ import (
 	"code.cloudfoundry.org/lager"
 	"encoding/json"
 	"github.com/pivotal-cf/brokerapi"
+	"go.opentelemetry.io/otel/attribute"
 	"reflect"
 )
 
+// MaxSqlValueSize keeps the rows of service_bindings, the widest table, within MySQL's 65,535 byte row size limit
+// for 4-byte character sets.  Besides the value column and its two length bytes, a row holds four VARCHAR(255) columns
+// of 1,022 bytes each and a byte of null flags.
+const MaxSqlValueSize = (mysqlMaxRowSize - 1 - 4*(255*mysqlMaxCharBytes+2) - 2) / mysqlMaxCharBytes
+
+// mysqlMaxRowSize is the most bytes MySQL allows the columns of a row to take, other than TEXT and BLOB columns.
+const mysqlMaxRowSize = 65535
+
+// mysqlMaxCharBytes is the width of the widest character of utf8mb4.
+const mysqlMaxCharBytes = 4
+
+// maxOperationDescription is the width of the service_operations.description column.
+const maxOperationDescription = 1024
+
+// maxRetiredPlanHint is the width of the retired_plans.hint column.
+const maxRetiredPlanHint = 1024
+
+// DefaultSlowQueryThreshold is how long a statement runs before SQL stores log it as slow.
+const DefaultSlowQueryThreshold = 500 * time.Millisecond
+
 type SqlStore struct {
-	StoreType string
-	Database  SqlConnection
+	StoreType    string
+	Database     SqlConnection
+	MaxValueSize int
+	QueryTimeout time.Duration
+	AuditTrail   bool
+	Locker       AdvisoryLocker
+
+	// SlowQueryThreshold, when positive, is how long a statement runs before it is logged as slow.
+	SlowQueryThreshold time.Duration
+
+	// ParameterEncryption, when set, encrypts binding parameters in place of hashing them.
+	ParameterEncryption *ParameterEncryption
+
+	// BcryptCost is the cost binding parameters are hashed with, or 0 for bcrypt.DefaultCost.
+	BcryptCost int
+
+	// Logger records corrupt records and slow queries.  It may be nil.
+	Logger lager.Logger
 }
 
-func NewSqlStore(logger lager.Logger, dbDriver, username, password, host, port, dbName, caCert string) (Store, error) {
+func NewSqlStore(logger lager.Logger, dbDriver, username, password, host, port, dbName, caCert string, maxValueSize int, queryTimeout time.Duration) (Store, error) {
+	toDatabase, err := NewSqlVariant(dbDriver, username, password, host, port, dbName, caCert)
+	if err != nil {
+		logger.Error("db-driver-unrecognized", err)
+		return nil, err
+	}
+	store, err := NewSqlStoreWithVariant(logger, toDatabase, maxValueSize, queryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	store.(*SqlStore).StoreType = dbDriver
+	return store, nil
+}
 
-	var err error
-	var toDatabase SqlVariant
+// NewSqlVariant returns the variant of the database driver dbDriver, which is mysql or postgres.
+func NewSqlVariant(dbDriver, username, password, host, port, dbName, caCert string) (SqlVariant, error) {
 	switch dbDriver {
 	case "mysql":
-		toDatabase = NewMySqlVariant(username, password, host, port, dbName, caCert)
+		return NewMySqlVariant(username, password, host, port, dbName, caCert), nil
 	case "postgres":
-		toDatabase = NewPostgresVariant(username, password, host, port, dbName, caCert)
+		return NewPostgresVariant(username, password, host, port, dbName, caCert), nil
 	default:
-		err = fmt.Errorf("Unrecognized Driver: %s", dbDriver)
-		logger.Error("db-driver-unrecognized", err)
-		return nil, err
+		return nil, fmt.Errorf("Unrecognized Driver: %s", dbDriver)
 	}
-	return NewSqlStoreWithVariant(logger, toDatabase)
 }
 
-func NewSqlStoreWithVariant(logger lager.Logger, toDatabase SqlVariant) (Store, error) {
+// Reconnect moves the store onto a new pool of connections to its database made with variant, which differs from the
+// store's only in its credentials, so that they can be rotated while the broker runs.  Requests under way finish on
+// the old pool.
+func (s *SqlStore) Reconnect(logger lager.Logger, variant SqlVariant) error {
+	connection, ok := s.Database.(*sqlConnection)
+	if !ok {
+		return errors.New("the store's database connection cannot be reconnected")
+	}
+	return connection.reconnect(logger, variant)
+}
+
+func NewSqlStoreWithVariant(logger lager.Logger, toDatabase SqlVariant, maxValueSize int, queryTimeout time.Duration) (Store, error) {
+	if maxValueSize < 1 || maxValueSize > MaxSqlValueSize {
+		err := fmt.Errorf("maxValueSize must be between 1 and %d, got %d", MaxSqlValueSize, maxValueSize)
+		logger.Error("sql-invalid-max-value-size", err)
+		return nil, err
+	}
+
 	database := NewSqlConnection(toDatabase)
 
-	err := initialize(logger, database)
+	var schema string
+	if variant, ok := toDatabase.(SchemaVariant); ok {
+		schema = variant.CurrentSchema()
+	}
+	err := initialize(logger, database, maxValueSize, schema)
 
 	if err != nil {
 		logger.Error("sql-failed-to-initialize-database", err)
 		return nil, err
 	}
 
+	locker, _ := toDatabase.(AdvisoryLocker)
 	return &SqlStore{
-		Database: database,
+		Database:     database,
+		MaxValueSize: maxValueSize,
+		QueryTimeout: queryTimeout,
+		AuditTrail:   true,
+		Locker:       locker,
+		Logger:       logger.Session("sql-store"),
+
+		SlowQueryThreshold: DefaultSlowQueryThreshold,
 	}, nil
 }
 
-func initialize(logger lager.Logger, db SqlConnection) error {
+// SchemaVariant is implemented by variants that can name the schema the store's tables are in, so that tables of the
+// same name in other databases on the server are not mistaken for the store's own.
+type SchemaVariant interface {
+	// CurrentSchema returns an expression for the schema of the connection's tables.
+	CurrentSchema() string
+}
+
+func initialize(logger lager.Logger, db SqlConnection, maxValueSize int, schema string) error {
 	logger = logger.Session("initialize-database")
 	logger.Info("start")
 	defer logger.Info("end")
@@ -63,24 +149,201 @@ func initialize(logger lager.Logger, db SqlConnection) error {
 	}
 
 	// TODO: uniquify table names?
-	_, err = db.Exec(`
+	_, err = db.Exec(fmt.Sprintf(`
 			CREATE TABLE IF NOT EXISTS service_instances(
 				id VARCHAR(255) PRIMARY KEY,
-				value VARCHAR(4096)
+				service_id VARCHAR(255),
+				plan_id VARCHAR(255),
+				value VARCHAR(%d)
 			)
-		`)
+		`, maxValueSize))
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(`
+	_, err = db.Exec(fmt.Sprintf(`
 			CREATE TABLE IF NOT EXISTS service_bindings(
 				id VARCHAR(255) PRIMARY KEY,
-				value VARCHAR(4096)
+				instance_id VARCHAR(255),
+				service_id VARCHAR(255),
+				plan_id VARCHAR(255),
+				value VARCHAR(%d)
+			)
+		`, maxValueSize))
+	if err != nil {
+		return err
+	}
+	for _, column := range []struct{ table, name string }{
+		{"service_bindings", "instance_id"},
+		{"service_instances", "service_id"},
+		{"service_instances", "plan_id"},
+		{"service_bindings", "service_id"},
+		{"service_bindings", "plan_id"},
+	} {
+		if err = addColumn(logger, db, schema, column.table, column.name); err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{"service_instances", "service_bindings"} {
+		if err = backfillFilterColumns(logger, db, table); err != nil {
+			return err
+		}
+	}
+	if err = createAuditTable(db); err != nil {
+		return err
+	}
+	if err = addColumn(logger, db, schema, "broker_audit", "originating_user"); err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+			CREATE TABLE IF NOT EXISTS scheduled_jobs(
+				name VARCHAR(255) PRIMARY KEY,
+				next_run VARCHAR(64)
 			)
 		`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+			CREATE TABLE IF NOT EXISTS service_operations(
+				instance_id VARCHAR(255) PRIMARY KEY,
+				type VARCHAR(32),
+				state VARCHAR(32),
+				description VARCHAR(1024)
+			)
+		`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+			CREATE TABLE IF NOT EXISTS retired_plans(
+				plan_id VARCHAR(255) PRIMARY KEY,
+				hint VARCHAR(1024)
+			)
+		`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+			CREATE TABLE IF NOT EXISTS share_reservations(
+				prefix VARCHAR(255) PRIMARY KEY,
+				organization_guid VARCHAR(255)
+			)
+		`)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"service_instances", "service_bindings"} {
+		if err = validateValueColumn(logger, db, schema, table, maxValueSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateValueColumn makes sure a table created by an earlier run is wide enough for maxValueSize, since MySQL
+// silently truncates oversized values when not running in strict mode.
+func validateValueColumn(logger lager.Logger, db SqlConnection, schema, table string, maxValueSize int) error {
+	row := db.QueryRow("SELECT MIN(character_maximum_length) FROM information_schema.columns WHERE table_name = ? AND column_name = 'value'"+inSchema(schema), table)
+	if row == nil {
+		return nil
+	}
+
+	var columnSize sql.NullInt64
+	if err := row.Scan(&columnSize); err != nil {
+		logger.Info("unable-to-determine-value-column-size", lager.Data{"table": table, "error": err.Error()})
+		return nil
+	}
+
+	if columnSize.Valid && columnSize.Int64 < int64(maxValueSize) {
+		err := fmt.Errorf("%s.value column holds %d characters but maxValueSize is %d: widen the column or lower maxValueSize", table, columnSize.Int64, maxValueSize)
+		logger.Error("value-column-too-small", err)
+		return err
+	}
+	return nil
+}
+
+// addColumn adds a VARCHAR(255) column to tables created by earlier versions of the broker.
+func addColumn(logger lager.Logger, db SqlConnection, schema, table, column string) error {
+	row := db.QueryRow("SELECT COUNT(*) FROM information_schema.columns WHERE table_name = ? AND column_name = ?"+inSchema(schema), table, column)
+	if row == nil {
+		return nil
+	}
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		logger.Error("failed-to-inspect-table", err, lager.Data{"table": table})
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	logger.Info("adding-column", lager.Data{"table": table, "column": column})
+	_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s VARCHAR(255)", table, column))
 	return err
 }
 
+// inSchema restricts a query of information_schema.columns to the given schema, when there is one.
+func inSchema(schema string) string {
+	if schema == "" {
+		return ""
+	}
+	return " AND table_schema = " + schema
+}
+
+// backfillFilterColumns copies the service and plan IDs out of the values of records written before those columns
+// existed, so that filtered listings include them.
+func backfillFilterColumns(logger lager.Logger, db SqlConnection, table string) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT id, value FROM %s WHERE service_id IS NULL", table))
+	if err != nil {
+		logger.Error("failed-to-find-records-to-backfill", err, lager.Data{"table": table})
+		return err
+	}
+	if rows == nil {
+		return nil
+	}
+
+	type filterColumns struct {
+		id                string
+		ServiceID, PlanID string
+	}
+	var records []filterColumns
+	for rows.Next() {
+		var id string
+		var value []byte
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		var record struct {
+			ServiceID string `json:"service_id"`
+			PlanID    string `json:"plan_id"`
+		}
+		if err := json.Unmarshal(value, &record); err != nil {
+			logger.Info("skipping-unreadable-record", lager.Data{"table": table, "id": id, "error": err.Error()})
+			continue
+		}
+		records = append(records, filterColumns{id, record.ServiceID, record.PlanID})
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		_, err := db.Exec(fmt.Sprintf("UPDATE %s SET service_id = ?, plan_id = ? WHERE id = ?", table), record.ServiceID, record.PlanID, record.id)
+		if err != nil {
+			return err
+		}
+	}
+	if len(records) > 0 {
+		logger.Info("backfilled-filter-columns", lager.Data{"table": table, "count": len(records)})
+	}
+	return nil
+}
+
 func (s *SqlStore) Restore(logger lager.Logger) error {
 	return nil
 }
@@ -93,80 +356,493 @@ func (s *SqlStore) Cleanup() error {
 	return nil
 }
 
-func (s *SqlStore) CreateInstanceDetails(id string, details ServiceInstance) error {
+// Close closes the store's connection pool once the broker has finished with it.
+func (s *SqlStore) Close() error {
+	return s.Database.Close()
+}
+
+func (s *SqlStore) CreateInstanceDetails(ctx context.Context, id string, details ServiceInstance) error {
 	jsonData, err := json.Marshal(details)
 	if err != nil {
 		return err
 	}
-	_, err = s.Database.Exec("INSERT INTO service_instances (id, value) VALUES (?, ?)", id, jsonData)
+	if err := checkValueSize("service instance", id, jsonData, s.MaxValueSize); err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, "INSERT INTO service_instances (id, service_id, plan_id, value) VALUES (?, ?, ?, ?)", id, details.ServiceID, details.PlanID, jsonData)
 	if err != nil {
 		return err
 	}
-	return nil
+	return s.audit(ctx, AuditActionCreate, AuditRecordInstance, id)
 }
 
-func (s *SqlStore) RetrieveInstanceDetails(id string) (ServiceInstance, error) {
+func (s *SqlStore) RetrieveInstanceDetails(ctx context.Context, id string) (ServiceInstance, error) {
 	var serviceID string
 	var value []byte
 	var serviceInstance ServiceInstance
-	if err := s.Database.QueryRow("SELECT id, value FROM service_instances WHERE id = ?", id).Scan(&serviceID, &value); err == nil {
-		err = json.Unmarshal(value, &serviceInstance)
+	if err := s.queryRow(ctx, "SELECT id, value FROM service_instances WHERE id = ?", []interface{}{id}, &serviceID, &value); err == nil {
+		err = unmarshalRecord(s.Logger, "service instance", id, value, &serviceInstance)
 		if err != nil {
 			return ServiceInstance{}, err
 		}
-		return serviceInstance, nil
+		return withShareComponents(serviceInstance), nil
 	} else if err == sql.ErrNoRows {
-		return ServiceInstance{}, brokerapi.ErrInstanceDoesNotExist
+		return ServiceInstance{}, fmt.Errorf("%w: %s", ErrInstanceNotFound, id)
 	} else {
 		return ServiceInstance{}, err
 	}
 }
 
-func (s *SqlStore) RetrieveBindingDetails(id string) (brokerapi.BindDetails, error) {
+func (s *SqlStore) RetrieveBindingDetails(ctx context.Context, id string) (brokerapi.BindDetails, error) {
 	var bindingID string
 	var value []byte
 	bindDetails := brokerapi.BindDetails{}
-	if err := s.Database.QueryRow("SELECT id, value FROM service_bindings WHERE id = ?", id).Scan(&bindingID, &value); err == nil {
-		err = json.Unmarshal(value, &bindDetails)
+	if err := s.queryRow(ctx, "SELECT id, value FROM service_bindings WHERE id = ?", []interface{}{id}, &bindingID, &value); err == nil {
+		err = unmarshalRecord(s.Logger, "service binding", id, value, &bindDetails)
 		if err != nil {
 			return brokerapi.BindDetails{}, err
 		}
-		return bindDetails, nil
+		return revealBindingDetails(id, bindDetails, s.ParameterEncryption)
 	} else if err == sql.ErrNoRows {
-		return brokerapi.BindDetails{}, brokerapi.ErrInstanceDoesNotExist
+		return brokerapi.BindDetails{}, fmt.Errorf("%w: %s", ErrBindingNotFound, id)
 	} else {
 		return brokerapi.BindDetails{}, err
 	}
 }
 
-func (s *SqlStore) CreateBindingDetails(id string, details brokerapi.BindDetails) error {
-	storeDetails, err := redactBindingDetails(details)
+func (s *SqlStore) CreateBindingDetails(ctx context.Context, instanceID, id string, details brokerapi.BindDetails) error {
+	storeDetails, err := redactBindingDetails(id, details, s.ParameterEncryption, s.BcryptCost)
+	if err != nil {
+		return err
+	}
 
 	jsonData, err := json.Marshal(storeDetails)
 	if err != nil {
 		return err
 	}
-	_, err = s.Database.Exec("INSERT INTO service_bindings (id, value) VALUES (?, ?)", id, jsonData)
+	if err := checkValueSize("service binding", id, jsonData, s.MaxValueSize); err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, "INSERT INTO service_bindings (id, instance_id, service_id, plan_id, value) VALUES (?, ?, ?, ?, ?)", id, instanceID, details.ServiceID, details.PlanID, jsonData)
 	if err != nil {
 		return err
 	}
-	return nil
+	return s.audit(ctx, AuditActionCreate, AuditRecordBinding, id)
 }
 
-func (s *SqlStore) DeleteInstanceDetails(id string) error {
-	_, err := s.Database.Exec("DELETE FROM service_instances WHERE id = ?", id)
+func (s *SqlStore) UpdateInstanceDetails(ctx context.Context, id string, details ServiceInstance) error {
+	jsonData, err := json.Marshal(details)
 	if err != nil {
 		return err
 	}
-	return nil
+	if err := checkValueSize("service instance", id, jsonData, s.MaxValueSize); err != nil {
+		return err
+	}
+
+	// MySQL reports no affected rows for updates that change nothing, so look the instance up rather than relying
+	// on the update's result.
+	var existingID string
+	if err := s.queryRow(ctx, "SELECT id FROM service_instances WHERE id = ?", []interface{}{id}, &existingID); err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", ErrInstanceNotFound, id)
+	} else if err != nil {
+		return err
+	}
+
+	_, err = s.exec(ctx, "UPDATE service_instances SET service_id = ?, plan_id = ?, value = ? WHERE id = ?", details.ServiceID, details.PlanID, jsonData, id)
+	if err != nil {
+		return err
+	}
+	return s.audit(ctx, AuditActionUpdate, AuditRecordInstance, id)
 }
 
-func (s *SqlStore) DeleteBindingDetails(id string) error {
-	_, err := s.Database.Exec("DELETE FROM service_bindings WHERE id = ?", id)
+// DeleteInstanceDetails and DeleteBindingDetails report records that were not there as not found, as the file store
+// does.
+func (s *SqlStore) DeleteInstanceDetails(ctx context.Context, id string) error {
+	result, err := s.exec(ctx, "DELETE FROM service_instances WHERE id = ?", id)
 	if err != nil {
 		return err
 	}
-	return nil
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("%w: %s", ErrInstanceNotFound, id)
+	}
+	return s.audit(ctx, AuditActionDelete, AuditRecordInstance, id)
+}
+
+func (s *SqlStore) DeleteBindingDetails(ctx context.Context, id string) error {
+	result, err := s.exec(ctx, "DELETE FROM service_bindings WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("%w: %s", ErrBindingNotFound, id)
+	}
+	return s.audit(ctx, AuditActionDelete, AuditRecordBinding, id)
+}
+
+// ListInstanceDetails skips corrupt instances, which are logged and counted, so that one bad record does not stop
+// the rest from being listed.
+func (s *SqlStore) ListInstanceDetails(ctx context.Context, opts ListOptions) (map[string]ServiceInstance, error) {
+	instances := map[string]ServiceInstance{}
+	err := s.listRecords(ctx, "service_instances", opts, func(id string, value []byte) bool {
+		var serviceInstance ServiceInstance
+		if err := unmarshalRecord(s.Logger, "service instance", id, value, &serviceInstance); err != nil {
+			return false
+		}
+		instances[id] = withShareComponents(serviceInstance)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+// ListBindingDetails skips corrupt bindings, which are logged and counted.
+func (s *SqlStore) ListBindingDetails(ctx context.Context, opts ListOptions) (map[string]brokerapi.BindDetails, error) {
+	bindings := map[string]brokerapi.BindDetails{}
+	var revealErr error
+	err := s.listRecords(ctx, "service_bindings", opts, func(id string, value []byte) bool {
+		var bindDetails brokerapi.BindDetails
+		if err := unmarshalRecord(s.Logger, "service binding", id, value, &bindDetails); err != nil {
+			return false
+		}
+		revealed, err := revealBindingDetails(id, bindDetails, s.ParameterEncryption)
+		if err != nil {
+			revealErr = err
+			return false
+		}
+		bindings[id] = revealed
+		return true
+	})
+	if revealErr != nil {
+		return nil, revealErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
+
+// listRecords calls decode for each record of table matching opts.  decode reports whether it kept the record.
+// Records it skips do not count towards opts.Limit, so that corrupt records do not cut a page short.
+func (s *SqlStore) listRecords(ctx context.Context, table string, opts ListOptions, decode func(id string, value []byte) bool) error {
+	for {
+		var (
+			scanned, kept int
+			lastID        string
+		)
+		query, args := listQuery(table, opts)
+		err := s.query(ctx, query, args, func(rows *sql.Rows) error {
+			var id string
+			var value []byte
+			if err := rows.Scan(&id, &value); err != nil {
+				return err
+			}
+			scanned++
+			lastID = id
+			if decode(id, value) {
+				kept++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if opts.Limit == 0 || scanned < opts.Limit || kept == scanned {
+			return nil
+		}
+		opts.After = lastID
+		opts.Limit = scanned - kept
+	}
+}
+
+func listQuery(table string, opts ListOptions) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if opts.ServiceID != "" {
+		conditions = append(conditions, "service_id = ?")
+		args = append(args, opts.ServiceID)
+	}
+	if opts.PlanID != "" {
+		conditions = append(conditions, "plan_id = ?")
+		args = append(args, opts.PlanID)
+	}
+	if opts.After != "" {
+		conditions = append(conditions, "id > ?")
+		args = append(args, opts.After)
+	}
+
+	query := fmt.Sprintf("SELECT id, value FROM %s", table)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id"
+	if opts.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", opts.Limit)
+	}
+	return query, args
+}
+
+func (s *SqlStore) ListBindingInstances(ctx context.Context) (map[string]string, error) {
+	bindingInstances := map[string]string{}
+	err := s.query(ctx, "SELECT id, instance_id FROM service_bindings", nil, func(rows *sql.Rows) error {
+		var id string
+		var instanceID sql.NullString
+		if err := rows.Scan(&id, &instanceID); err != nil {
+			return err
+		}
+		bindingInstances[id] = instanceID.String
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bindingInstances, nil
+}
+
+// CheckHealth checks that the database answers queries.
+func (s *SqlStore) CheckHealth(ctx context.Context) error {
+	var one int
+	return s.queryRow(ctx, "SELECT 1", nil, &one)
+}
+
+func (s *SqlStore) CountInstances(ctx context.Context) (int, error) {
+	return s.count(ctx, "service_instances")
+}
+
+func (s *SqlStore) CountBindings(ctx context.Context) (int, error) {
+	return s.count(ctx, "service_bindings")
+}
+
+func (s *SqlStore) count(ctx context.Context, table string) (int, error) {
+	var count int
+	if err := s.queryRow(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", table), nil, &count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *SqlStore) RetrieveJobNextRun(ctx context.Context, name string) (time.Time, error) {
+	var nextRun string
+	err := s.queryRow(ctx, "SELECT next_run FROM scheduled_jobs WHERE name = ?", []interface{}{name}, &nextRun)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, nextRun)
+}
+
+func (s *SqlStore) SaveJobNextRun(ctx context.Context, name string, next time.Time) error {
+	var existing string
+	err := s.queryRow(ctx, "SELECT name FROM scheduled_jobs WHERE name = ?", []interface{}{name}, &existing)
+	nextRun := next.UTC().Format(time.RFC3339Nano)
+	switch err {
+	case nil:
+		_, err = s.exec(ctx, "UPDATE scheduled_jobs SET next_run = ? WHERE name = ?", nextRun, name)
+	case sql.ErrNoRows:
+		_, err = s.exec(ctx, "INSERT INTO scheduled_jobs (name, next_run) VALUES (?, ?)", name, nextRun)
+	}
+	return err
+}
+
+func (s *SqlStore) RetrieveOperation(ctx context.Context, instanceID string) (Operation, error) {
+	var operation Operation
+	err := s.queryRow(ctx, "SELECT type, state, description FROM service_operations WHERE instance_id = ?", []interface{}{instanceID}, &operation.Type, &operation.State, &operation.Description)
+	if err == sql.ErrNoRows {
+		return Operation{}, nil
+	}
+	return operation, err
+}
+
+func (s *SqlStore) SaveOperation(ctx context.Context, instanceID string, operation Operation) error {
+	if len(operation.Description) > maxOperationDescription {
+		operation.Description = operation.Description[:maxOperationDescription]
+	}
+
+	var existing string
+	err := s.queryRow(ctx, "SELECT instance_id FROM service_operations WHERE instance_id = ?", []interface{}{instanceID}, &existing)
+	switch err {
+	case nil:
+		_, err = s.exec(ctx, "UPDATE service_operations SET type = ?, state = ?, description = ? WHERE instance_id = ?", operation.Type, string(operation.State), operation.Description, instanceID)
+	case sql.ErrNoRows:
+		_, err = s.exec(ctx, "INSERT INTO service_operations (instance_id, type, state, description) VALUES (?, ?, ?, ?)", instanceID, operation.Type, string(operation.State), operation.Description)
+	}
+	return err
+}
+
+func (s *SqlStore) ListRetiredPlans(ctx context.Context) (map[string]string, error) {
+	retiredPlans := map[string]string{}
+	err := s.query(ctx, "SELECT plan_id, hint FROM retired_plans", nil, func(rows *sql.Rows) error {
+		var planID string
+		var hint sql.NullString
+		if err := rows.Scan(&planID, &hint); err != nil {
+			return err
+		}
+		retiredPlans[planID] = hint.String
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return retiredPlans, nil
+}
+
+func (s *SqlStore) RetirePlan(ctx context.Context, planID, hint string) error {
+	if len(hint) > maxRetiredPlanHint {
+		hint = hint[:maxRetiredPlanHint]
+	}
+
+	var existing string
+	err := s.queryRow(ctx, "SELECT plan_id FROM retired_plans WHERE plan_id = ?", []interface{}{planID}, &existing)
+	switch err {
+	case nil:
+		_, err = s.exec(ctx, "UPDATE retired_plans SET hint = ? WHERE plan_id = ?", hint, planID)
+	case sql.ErrNoRows:
+		_, err = s.exec(ctx, "INSERT INTO retired_plans (plan_id, hint) VALUES (?, ?)", planID, hint)
+	}
+	return err
+}
+
+func (s *SqlStore) ReinstatePlan(ctx context.Context, planID string) error {
+	_, err := s.exec(ctx, "DELETE FROM retired_plans WHERE plan_id = ?", planID)
+	return err
+}
+
+func (s *SqlStore) ListShareReservations(ctx context.Context) (map[string]string, error) {
+	reservations := map[string]string{}
+	err := s.query(ctx, "SELECT prefix, organization_guid FROM share_reservations", nil, func(rows *sql.Rows) error {
+		var prefix string
+		var orgGUID sql.NullString
+		if err := rows.Scan(&prefix, &orgGUID); err != nil {
+			return err
+		}
+		reservations[prefix] = orgGUID.String
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+func (s *SqlStore) ReserveShares(ctx context.Context, prefix, orgGUID string) error {
+	var existing string
+	err := s.queryRow(ctx, "SELECT prefix FROM share_reservations WHERE prefix = ?", []interface{}{prefix}, &existing)
+	switch err {
+	case nil:
+		_, err = s.exec(ctx, "UPDATE share_reservations SET organization_guid = ? WHERE prefix = ?", orgGUID, prefix)
+	case sql.ErrNoRows:
+		_, err = s.exec(ctx, "INSERT INTO share_reservations (prefix, organization_guid) VALUES (?, ?)", prefix, orgGUID)
+	}
+	return err
+}
+
+func (s *SqlStore) ReleaseShares(ctx context.Context, prefix string) error {
+	_, err := s.exec(ctx, "DELETE FROM share_reservations WHERE prefix = ?", prefix)
+	return err
+}
+
+// withDeadline runs op under the store's query timeout and abandons it once the context is done, so that a hung
+// database cannot block the caller even when the driver does not support cancellation.  It is traced as a span of the
+// statement query, and logged if it is slow.
+func (s *SqlStore) withDeadline(ctx context.Context, query string, op func(ctx context.Context) error) (err error) {
+	ctx, endSpan := startSpan(ctx, "sql", attribute.String("db.statement", query))
+	defer func() { endSpan(err) }()
+	defer s.logSlowQuery(ctx, query, time.Now())
+
+	if s.QueryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.QueryTimeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- op(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return storeUnavailable(ctx.Err())
+	}
+}
+
+// logSlowQuery logs query, which started at started, if it ran for longer than the slow query threshold.  The
+// statement is logged without its arguments, which may hold share details.
+func (s *SqlStore) logSlowQuery(ctx context.Context, query string, started time.Time) {
+	duration := time.Since(started)
+	if s.Logger == nil || s.SlowQueryThreshold <= 0 || duration < s.SlowQueryThreshold {
+		return
+	}
+	data := lager.Data{"statement": query, "duration": duration.String(), "threshold": s.SlowQueryThreshold.String()}
+	if id := RequestID(ctx); id != "" {
+		data["requestID"] = id
+	}
+	s.Logger.Info("slow-query", data)
+}
+
+func (s *SqlStore) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	results := make(chan sql.Result, 1)
+	err := s.withDeadline(ctx, query, func(ctx context.Context) error {
+		var (
+			result sql.Result
+			err    error
+		)
+		if db, ok := s.Database.(sqlContextDB); ok {
+			result, err = db.ExecContext(ctx, query, args...)
+		} else {
+			result, err = s.Database.Exec(query, args...)
+		}
+		results <- result
+		return storeUnavailable(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return <-results, nil
+}
+
+func (s *SqlStore) queryRow(ctx context.Context, query string, args []interface{}, dest ...interface{}) error {
+	return s.withDeadline(ctx, query, func(ctx context.Context) error {
+		var row *sql.Row
+		if db, ok := s.Database.(sqlContextDB); ok {
+			row = db.QueryRowContext(ctx, query, args...)
+		} else {
+			row = s.Database.QueryRow(query, args...)
+		}
+		return storeUnavailable(row.Scan(dest...))
+	})
+}
+
+// query calls scan for each row of the result.  When query gives up on a slow database it returns before the scan
+// finishes, so callers should discard anything scan collected when query returns an error.
+func (s *SqlStore) query(ctx context.Context, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	return s.withDeadline(ctx, query, func(ctx context.Context) error {
+		var (
+			rows *sql.Rows
+			err  error
+		)
+		if db, ok := s.Database.(sqlContextDB); ok {
+			rows, err = db.QueryContext(ctx, query, args...)
+		} else {
+			rows, err = s.Database.Query(query, args...)
+		}
+		if err != nil {
+			return storeUnavailable(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				return err
+			}
+		}
+		return storeUnavailable(rows.Err())
+	})
 }
 
 func (s *SqlStore) keyValueInTable(logger lager.Logger, key, value, table string) (error, bool) {
@@ -190,8 +866,10 @@ func (s *SqlStore) keyValueInTable(logger lager.Logger, key, value, table string
 	return err, true
 }
 
-func (s *SqlStore) IsInstanceConflict(id string, details ServiceInstance) bool {
-	if existing, err := s.RetrieveInstanceDetails(id); err == nil {
+func (s *SqlStore) IsInstanceConflict(ctx context.Context, id string, details ServiceInstance) bool {
+	if existing, err := s.RetrieveInstanceDetails(ctx, id); err == nil {
+		// the dashboard URL is chosen by the broker, not requested
+		existing.DashboardURL = details.DashboardURL
 		if !reflect.DeepEqual(details, existing) {
 			return true
 		}
@@ -199,6 +877,6 @@ func (s *SqlStore) IsInstanceConflict(id string, details ServiceInstance) bool {
 	return false
 }
 
-func (s *SqlStore) IsBindingConflict(id string, details brokerapi.BindDetails) bool {
-	return isBindingConflict(s, id, details)
+func (s *SqlStore) IsBindingConflict(ctx context.Context, id string, details brokerapi.BindDetails) bool {
+	return isBindingConflict(ctx, s, id, details)
 }
@@ -0,0 +1,148 @@
+package nfsbroker
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+
+	AuditRecordInstance = "service_instance"
+	AuditRecordBinding  = "service_binding"
+)
+
+// AuditEntry is one row of the broker_audit table. Each entry carries the hash of the entry before it, so deleting
+// or editing a row breaks the chain for every row that follows.  OriginatingUser is parsed from OriginatingIdentity,
+// which the hash covers, so it is left out of the hash and checked against the identity instead.
+type AuditEntry struct {
+	Sequence            int64
+	OccurredAt          string
+	Actor               string
+	OriginatingIdentity string
+	OriginatingUser     string
+	Action              string
+	RecordType          string
+	RecordID            string
+	PrevHash            string
+	EntryHash           string
+}
+
+func (e AuditEntry) computeHash() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%s|%s|%s|%s|%s|%s|%s",
+		e.Sequence, e.OccurredAt, e.Actor, e.OriginatingIdentity, e.Action, e.RecordType, e.RecordID, e.PrevHash)))
+	return hex.EncodeToString(sum[:])
+}
+
+func createAuditTable(db SqlConnection) error {
+	_, err := db.Exec(`
+			CREATE TABLE IF NOT EXISTS broker_audit(
+				seq BIGINT PRIMARY KEY,
+				occurred_at VARCHAR(64),
+				actor VARCHAR(255),
+				originating_identity VARCHAR(1024),
+				originating_user VARCHAR(255),
+				action VARCHAR(32),
+				record_type VARCHAR(32),
+				record_id VARCHAR(255),
+				prev_hash VARCHAR(64),
+				entry_hash VARCHAR(64)
+			)
+		`)
+	return err
+}
+
+// audit appends an entry describing a mutation to the broker_audit table. The store only ever inserts into the
+// table, so operators may restrict the broker's database user to INSERT and SELECT on it.
+func (s *SqlStore) audit(ctx context.Context, action, recordType, recordID string) error {
+	if !s.AuditTrail {
+		return nil
+	}
+
+	var prevSeq int64
+	var prevHash string
+	err := s.queryRow(ctx, "SELECT seq, entry_hash FROM broker_audit ORDER BY seq DESC LIMIT 1", nil, &prevSeq, &prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	entry := AuditEntry{
+		Sequence:            prevSeq + 1,
+		OccurredAt:          time.Now().UTC().Format(time.RFC3339Nano),
+		Actor:               RequestActor(ctx),
+		OriginatingIdentity: OriginatingIdentity(ctx),
+		OriginatingUser:     OriginatingUser(ctx),
+		Action:              action,
+		RecordType:          recordType,
+		RecordID:            recordID,
+		PrevHash:            prevHash,
+	}
+	entry.EntryHash = entry.computeHash()
+
+	_, err = s.exec(ctx,
+		"INSERT INTO broker_audit (seq, occurred_at, actor, originating_identity, originating_user, action, record_type, record_id, prev_hash, entry_hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.Sequence, entry.OccurredAt, entry.Actor, entry.OriginatingIdentity, entry.OriginatingUser, entry.Action, entry.RecordType, entry.RecordID, entry.PrevHash, entry.EntryHash)
+	return err
+}
+
+// VerifyAuditTrail walks the broker_audit table in order and returns the number of entries checked, or an error
+// identifying the first entry whose hash chain does not hold.
+func (s *SqlStore) VerifyAuditTrail(ctx context.Context) (int, error) {
+	var prev AuditEntry
+	count := 0
+	err := s.query(ctx, "SELECT seq, occurred_at, actor, originating_identity, originating_user, action, record_type, record_id, prev_hash, entry_hash FROM broker_audit ORDER BY seq", nil, func(rows *sql.Rows) error {
+		var entry AuditEntry
+		var user sql.NullString
+		if err := rows.Scan(&entry.Sequence, &entry.OccurredAt, &entry.Actor, &entry.OriginatingIdentity, &user, &entry.Action, &entry.RecordType, &entry.RecordID, &entry.PrevHash, &entry.EntryHash); err != nil {
+			return err
+		}
+		entry.OriginatingUser = user.String
+		if entry.Sequence != prev.Sequence+1 || entry.PrevHash != prev.EntryHash {
+			return fmt.Errorf("audit trail broken at entry %d: does not follow entry %d", entry.Sequence, prev.Sequence)
+		}
+		if entry.computeHash() != entry.EntryHash {
+			return fmt.Errorf("audit trail broken at entry %d: entry hash does not match its contents", entry.Sequence)
+		}
+		// Entries written before the originating_user column existed have none.
+		if parsed, _ := ParseOriginatingIdentity(entry.OriginatingIdentity); user.Valid && entry.OriginatingUser != parsed {
+			return fmt.Errorf("audit trail broken at entry %d: originating user does not match its originating identity", entry.Sequence)
+		}
+		prev = entry
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ListChanges returns up to limit entries of the broker_audit table that come after the entry numbered since, in
+// order.
+func (s *SqlStore) ListChanges(ctx context.Context, since int64, limit int) ([]AuditEntry, error) {
+	if !s.AuditTrail {
+		return nil, ErrNoChangeFeed
+	}
+
+	entries := []AuditEntry{}
+	err := s.query(ctx, "SELECT seq, occurred_at, actor, originating_identity, originating_user, action, record_type, record_id, prev_hash, entry_hash FROM broker_audit WHERE seq > ? ORDER BY seq LIMIT ?", []interface{}{since, limit}, func(rows *sql.Rows) error {
+		var entry AuditEntry
+		var user sql.NullString
+		if err := rows.Scan(&entry.Sequence, &entry.OccurredAt, &entry.Actor, &entry.OriginatingIdentity, &user, &entry.Action, &entry.RecordType, &entry.RecordID, &entry.PrevHash, &entry.EntryHash); err != nil {
+			return err
+		}
+		entry.OriginatingUser = user.String
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
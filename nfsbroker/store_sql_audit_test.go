@@ -0,0 +1,203 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("SqlStore audit trail", func() {
+	var (
+		ctx      context.Context
+		db       *sql.DB
+		mock     sqlmock.Sqlmock
+		sqlStore nfsbroker.SqlStore
+		err      error
+	)
+
+	auditColumns := []string{"seq", "occurred_at", "actor", "originating_identity", "originating_user", "action", "record_type", "record_id", "prev_hash", "entry_hash"}
+
+	entryHash := func(seq int64, occurredAt, actor, identity, action, recordType, recordID, prevHash string) string {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%s|%s|%s|%s|%s|%s|%s", seq, occurredAt, actor, identity, action, recordType, recordID, prevHash)))
+		return hex.EncodeToString(sum[:])
+	}
+
+	BeforeEach(func() {
+		ctx = nfsbroker.WithRequestIdentity(context.TODO(), "broker-admin", "cloudfoundry eyJ1c2VyX2lkIjoiNjgzZWE3NDgifQ==")
+		db, mock, err = sqlmock.New()
+		Expect(err).NotTo(HaveOccurred())
+		sqlStore = nfsbroker.SqlStore{Database: nfsbrokerfakes.FakeSQLMockConnection{db},
+			StoreType: "mysql", MaxValueSize: nfsbroker.DefaultMaxValueSize, AuditTrail: true}
+	})
+
+	Context("when deleting an instance", func() {
+		BeforeEach(func() {
+			mock.ExpectExec("DELETE FROM service_instances WHERE id = ?").WithArgs("instance-1").WillReturnResult(sqlmock.NewResult(1, 1))
+		})
+
+		Context("and the audit table is empty", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT seq, entry_hash FROM broker_audit").WillReturnRows(sqlmock.NewRows([]string{"seq", "entry_hash"}))
+				mock.ExpectExec("INSERT INTO broker_audit").
+					WithArgs(1, sqlmock.AnyArg(), "broker-admin", "cloudfoundry eyJ1c2VyX2lkIjoiNjgzZWE3NDgifQ==", "683ea748", "delete", "service_instance", "instance-1", "", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			})
+
+			It("should record the first entry of the chain", func() {
+				err = sqlStore.DeleteInstanceDetails(ctx, "instance-1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+			})
+		})
+
+		Context("and the audit table has earlier entries", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT seq, entry_hash FROM broker_audit").WillReturnRows(sqlmock.NewRows([]string{"seq", "entry_hash"}).AddRow(41, "previous-hash"))
+				mock.ExpectExec("INSERT INTO broker_audit").
+					WithArgs(42, sqlmock.AnyArg(), "broker-admin", sqlmock.AnyArg(), sqlmock.AnyArg(), "delete", "service_instance", "instance-1", "previous-hash", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			})
+
+			It("should chain the new entry onto the latest one", func() {
+				err = sqlStore.DeleteInstanceDetails(ctx, "instance-1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+			})
+		})
+
+		Context("and the audit entry cannot be written", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT seq, entry_hash FROM broker_audit").WillReturnError(fmt.Errorf("connection lost"))
+			})
+
+			It("should fail the operation", func() {
+				err = sqlStore.DeleteInstanceDetails(ctx, "instance-1")
+				Expect(err).To(MatchError(ContainSubstring("connection lost")))
+				Expect(errors.Is(err, nfsbroker.ErrStoreUnavailable)).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("VerifyAuditTrail", func() {
+		var (
+			rows  *sqlmock.Rows
+			count int
+		)
+
+		BeforeEach(func() {
+			first := entryHash(1, "2017-01-01T00:00:00Z", "admin", "", "create", "service_instance", "instance-1", "")
+			second := entryHash(2, "2017-01-01T00:01:00Z", "admin", "", "delete", "service_instance", "instance-1", first)
+			rows = sqlmock.NewRows(auditColumns).
+				AddRow(1, "2017-01-01T00:00:00Z", "admin", "", nil, "create", "service_instance", "instance-1", "", first).
+				AddRow(2, "2017-01-01T00:01:00Z", "admin", "", nil, "delete", "service_instance", "instance-1", first, second)
+		})
+
+		JustBeforeEach(func() {
+			mock.ExpectQuery("SELECT (.+) FROM broker_audit ORDER BY seq").WillReturnRows(rows)
+			count, err = sqlStore.VerifyAuditTrail(ctx)
+		})
+
+		It("should accept an intact chain", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(2))
+		})
+
+		Context("when an entry has been altered", func() {
+			BeforeEach(func() {
+				first := entryHash(1, "2017-01-01T00:00:00Z", "admin", "", "create", "service_instance", "instance-1", "")
+				rows = sqlmock.NewRows(auditColumns).
+					AddRow(1, "2017-01-01T00:00:00Z", "someone-else", "", nil, "create", "service_instance", "instance-1", "", first)
+			})
+
+			It("should report the broken entry", func() {
+				Expect(err).To(MatchError(ContainSubstring("audit trail broken at entry 1")))
+			})
+		})
+
+		Context("when entries record originating users", func() {
+			BeforeEach(func() {
+				identity := "cloudfoundry eyJ1c2VyX2lkIjoiNjgzZWE3NDgifQ=="
+				first := entryHash(1, "2017-01-01T00:00:00Z", "admin", identity, "create", "service_instance", "instance-1", "")
+				rows = sqlmock.NewRows(auditColumns).
+					AddRow(1, "2017-01-01T00:00:00Z", "admin", identity, "683ea748", "create", "service_instance", "instance-1", "", first)
+			})
+
+			It("should accept users that match the identity", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(1))
+			})
+		})
+
+		Context("when an originating user has been altered", func() {
+			BeforeEach(func() {
+				identity := "cloudfoundry eyJ1c2VyX2lkIjoiNjgzZWE3NDgifQ=="
+				first := entryHash(1, "2017-01-01T00:00:00Z", "admin", identity, "create", "service_instance", "instance-1", "")
+				rows = sqlmock.NewRows(auditColumns).
+					AddRow(1, "2017-01-01T00:00:00Z", "admin", identity, "someone-else", "create", "service_instance", "instance-1", "", first)
+			})
+
+			It("should report the broken entry", func() {
+				Expect(err).To(MatchError(ContainSubstring("originating user does not match")))
+			})
+		})
+
+		Context("when an entry has been removed", func() {
+			BeforeEach(func() {
+				first := entryHash(1, "2017-01-01T00:00:00Z", "admin", "", "create", "service_instance", "instance-1", "")
+				third := entryHash(3, "2017-01-01T00:02:00Z", "admin", "", "create", "service_instance", "instance-2", "missing")
+				rows = sqlmock.NewRows(auditColumns).
+					AddRow(1, "2017-01-01T00:00:00Z", "admin", "", nil, "create", "service_instance", "instance-1", "", first).
+					AddRow(3, "2017-01-01T00:02:00Z", "admin", "", nil, "create", "service_instance", "instance-2", "missing", third)
+			})
+
+			It("should report where the chain breaks", func() {
+				Expect(err).To(MatchError(ContainSubstring("audit trail broken at entry 3")))
+			})
+		})
+	})
+
+	Describe("ListChanges", func() {
+		var entries []nfsbroker.AuditEntry
+
+		JustBeforeEach(func() {
+			entries, err = sqlStore.ListChanges(ctx, 41, 2)
+		})
+
+		Context("when there are newer entries", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT (.+) FROM broker_audit WHERE seq > \\? ORDER BY seq LIMIT \\?").WithArgs(41, 2).
+					WillReturnRows(sqlmock.NewRows(auditColumns).
+						AddRow(42, "2017-01-01T00:00:00Z", "admin", "cloudfoundry eyJ1c2VyX2lkIjoiNjgzZWE3NDgifQ==", "683ea748", "create", "service_instance", "instance-1", "a", "b").
+						AddRow(43, "2017-01-01T00:01:00Z", "admin", "", nil, "delete", "service_instance", "instance-1", "b", "c"))
+			})
+
+			It("should return them in order", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(entries).To(HaveLen(2))
+				Expect(entries[0].Sequence).To(Equal(int64(42)))
+				Expect(entries[0].OriginatingUser).To(Equal("683ea748"))
+				Expect(entries[1].Action).To(Equal("delete"))
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+			})
+		})
+
+		Context("when the audit trail is disabled", func() {
+			BeforeEach(func() {
+				sqlStore.AuditTrail = false
+			})
+
+			It("should report that changes are not recorded", func() {
+				Expect(err).To(MatchError(nfsbroker.ErrNoChangeFeed))
+			})
+		})
+	})
+})
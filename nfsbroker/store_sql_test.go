@@ -1,20 +1,30 @@
 package nfsbroker_test
 
 import (
+	"context"
+	"time"
+
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagertest"
 	"code.cloudfoundry.org/nfsbroker/nfsbroker"
 	"github.com/pivotal-cf/brokerapi"
 
+	"code.cloudfoundry.org/goshims/ioutilshim/ioutil_fake"
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
 	"code.cloudfoundry.org/goshims/sqlshim/sql_fake"
 	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
+	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -38,6 +48,7 @@ var _ = Describe("SqlStore", func() {
 	var (
 		store                                                            nfsbroker.Store
 		logger                                                           lager.Logger
+		ctx                                                              context.Context
 		state                                                            nfsbroker.DynamicState
 		fakeSqlDb                                                        = &sql_fake.FakeSqlDB{}
 		fakeVariant                                                      = &nfsbrokerfakes.FakeSqlVariant{}
@@ -54,11 +65,12 @@ var _ = Describe("SqlStore", func() {
 
 	BeforeEach(func() {
 		logger = lagertest.NewTestLogger("test-broker")
+		ctx = context.TODO()
 		fakeVariant.ConnectReturns(fakeSqlDb, nil)
 		fakeVariant.FlavorifyStub = func(query string) string {
 			return query
 		}
-		store, err = nfsbroker.NewSqlStoreWithVariant(logger, fakeVariant)
+		store, err = nfsbroker.NewSqlStoreWithVariant(logger, fakeVariant, nfsbroker.DefaultMaxValueSize, time.Minute)
 		Expect(err).ToNot(HaveOccurred())
 		state = nfsbroker.DynamicState{
 			InstanceMap: map[string]nfsbroker.ServiceInstance{
@@ -70,7 +82,7 @@ var _ = Describe("SqlStore", func() {
 		}
 		db, mock, err = sqlmock.New()
 		sqlStore = nfsbroker.SqlStore{Database: nfsbrokerfakes.FakeSQLMockConnection{db},
-			StoreType: "mysql"}
+			StoreType: "mysql", MaxValueSize: nfsbroker.DefaultMaxValueSize}
 	})
 
 	It("should open a db connection", func() {
@@ -81,6 +93,184 @@ var _ = Describe("SqlStore", func() {
 		Expect(fakeSqlDb.ExecCallCount()).To(BeNumerically(">=", 2))
 		Expect(fakeSqlDb.ExecArgsForCall(0)).To(ContainSubstring("CREATE TABLE IF NOT EXISTS service_instances"))
 		Expect(fakeSqlDb.ExecArgsForCall(1)).To(ContainSubstring("CREATE TABLE IF NOT EXISTS service_bindings"))
+		Expect(fakeSqlDb.ExecArgsForCall(2)).To(ContainSubstring("CREATE TABLE IF NOT EXISTS broker_audit"))
+	})
+
+	It("should size the value columns to the maximum value size", func() {
+		query, _ := fakeSqlDb.ExecArgsForCall(0)
+		Expect(query).To(ContainSubstring("value VARCHAR(4096)"))
+	})
+
+	Context("when the tables were created by an earlier version", func() {
+		It("should add the new columns and backfill them", func() {
+			oldDb, oldMock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			oldMock.MatchExpectationsInOrder(false)
+			variant := &nfsbrokerfakes.FakeSqlVariant{}
+			variant.ConnectReturns(oldDb, nil)
+			variant.FlavorifyStub = func(query string) string { return query }
+
+			oldMock.ExpectExec("CREATE TABLE IF NOT EXISTS service_instances").WillReturnResult(sqlmock.NewResult(0, 0))
+			oldMock.ExpectExec("CREATE TABLE IF NOT EXISTS service_bindings").WillReturnResult(sqlmock.NewResult(0, 0))
+			oldMock.ExpectExec("CREATE TABLE IF NOT EXISTS broker_audit").WillReturnResult(sqlmock.NewResult(0, 0))
+			oldMock.ExpectExec("CREATE TABLE IF NOT EXISTS scheduled_jobs").WillReturnResult(sqlmock.NewResult(0, 0))
+			oldMock.ExpectExec("CREATE TABLE IF NOT EXISTS service_operations").WillReturnResult(sqlmock.NewResult(0, 0))
+			oldMock.ExpectExec("CREATE TABLE IF NOT EXISTS retired_plans").WillReturnResult(sqlmock.NewResult(0, 0))
+			oldMock.ExpectExec("CREATE TABLE IF NOT EXISTS share_reservations").WillReturnResult(sqlmock.NewResult(0, 0))
+			for _, column := range [][]driver.Value{
+				{"service_bindings", "instance_id"},
+				{"service_instances", "service_id"},
+				{"service_instances", "plan_id"},
+				{"service_bindings", "service_id"},
+				{"service_bindings", "plan_id"},
+				{"broker_audit", "originating_user"},
+			} {
+				oldMock.ExpectQuery(`SELECT COUNT\(\*\) FROM information_schema.columns`).WithArgs(column...).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				oldMock.ExpectExec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", column[0], column[1])).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			oldMock.ExpectQuery("SELECT id, value FROM service_instances WHERE service_id IS NULL").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "value"}).AddRow("instance_1", []byte(`{"service_id":"service_123","plan_id":"plan_123"}`)))
+			oldMock.ExpectExec("UPDATE service_instances SET service_id = .+, plan_id = .+ WHERE id = .+").
+				WithArgs("service_123", "plan_123", "instance_1").WillReturnResult(sqlmock.NewResult(0, 1))
+			oldMock.ExpectQuery("SELECT id, value FROM service_bindings WHERE service_id IS NULL").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "value"}))
+			oldMock.ExpectQuery("SELECT MIN.character_maximum_length.").WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(4096))
+			oldMock.ExpectQuery("SELECT MIN.character_maximum_length.").WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(4096))
+
+			_, err = nfsbroker.NewSqlStoreWithVariant(logger, variant, nfsbroker.DefaultMaxValueSize, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(oldMock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
+	Context("when other databases on the server have the same tables", func() {
+		var (
+			schemaDb *sql_fake.FakeSqlDB
+			fakeSql  *sql_fake.FakeSql
+		)
+
+		BeforeEach(func() {
+			schemaDb = &sql_fake.FakeSqlDB{}
+			fakeSql = &sql_fake.FakeSql{}
+			fakeSql.OpenReturns(schemaDb, nil)
+		})
+
+		schemaQueries := func() []string {
+			queries := []string{}
+			for i := 0; i < schemaDb.QueryRowCallCount(); i++ {
+				query, _ := schemaDb.QueryRowArgsForCall(i)
+				if strings.Contains(query, "information_schema.columns") {
+					queries = append(queries, query)
+				}
+			}
+			return queries
+		}
+
+		It("should only inspect the columns of its own MySQL database", func() {
+			variant := nfsbroker.NewMySqlVariantWithSqlObject("username", "password", "host", "port", "dbName", "", fakeSql)
+			_, err := nfsbroker.NewSqlStoreWithVariant(logger, variant, nfsbroker.DefaultMaxValueSize, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			queries := schemaQueries()
+			Expect(queries).To(HaveLen(8))
+			for _, query := range queries {
+				Expect(query).To(HaveSuffix(" AND table_schema = DATABASE()"))
+			}
+		})
+
+		It("should only inspect the columns of its own Postgres schema", func() {
+			variant := nfsbroker.NewPostgresVariantWithShims("username", "password", "host", "port", "dbName", "", fakeSql, &ioutil_fake.FakeIoutil{}, &os_fake.FakeOs{})
+			_, err := nfsbroker.NewSqlStoreWithVariant(logger, variant, nfsbroker.DefaultMaxValueSize, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			queries := schemaQueries()
+			Expect(queries).To(HaveLen(8))
+			for _, query := range queries {
+				Expect(query).To(HaveSuffix(" AND table_schema = current_schema()"))
+			}
+		})
+	})
+
+	Context("when the maximum value size is out of range", func() {
+		It("should fail to create the store", func() {
+			_, err := nfsbroker.NewSqlStoreWithVariant(logger, fakeVariant, nfsbroker.MaxSqlValueSize+1, time.Minute)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should create tables whose rows fit MySQL's row size limit at the largest size allowed", func() {
+			widestDb := &sql_fake.FakeSqlDB{}
+			variant := &nfsbrokerfakes.FakeSqlVariant{}
+			variant.ConnectReturns(widestDb, nil)
+			variant.FlavorifyStub = func(query string) string { return query }
+			_, err := nfsbroker.NewSqlStoreWithVariant(logger, variant, nfsbroker.MaxSqlValueSize, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+
+			// with utf8mb4, a VARCHAR(n) column takes 4n bytes, and one length byte or two when that is over 255
+			varchar := regexp.MustCompile(`VARCHAR\((\d+)\)`)
+			tables := 0
+			for i := 0; i < widestDb.ExecCallCount(); i++ {
+				query, _ := widestDb.ExecArgsForCall(i)
+				if !strings.Contains(query, "CREATE TABLE") {
+					continue
+				}
+				tables++
+				columns := varchar.FindAllStringSubmatch(query, -1)
+				rowSize := (len(columns) + 7) / 8
+				for _, column := range columns {
+					width, err := strconv.Atoi(column[1])
+					Expect(err).NotTo(HaveOccurred())
+					rowSize += 4 * width
+					if 4*width > 255 {
+						rowSize += 2
+					} else {
+						rowSize++
+					}
+				}
+				Expect(rowSize).To(BeNumerically("<=", 65535), query)
+			}
+			Expect(tables).To(BeNumerically(">=", 7))
+		})
+	})
+
+	Describe("Reconnect", func() {
+		var (
+			newSqlDb   *sql_fake.FakeSqlDB
+			newVariant *nfsbrokerfakes.FakeSqlVariant
+		)
+
+		BeforeEach(func() {
+			newSqlDb = &sql_fake.FakeSqlDB{}
+			newVariant = &nfsbrokerfakes.FakeSqlVariant{}
+			newVariant.ConnectReturns(newSqlDb, nil)
+			newVariant.FlavorifyStub = func(query string) string { return query }
+		})
+
+		It("moves the store onto a pool made with the new variant and closes the old one", func() {
+			oldCloses, oldExecs := fakeSqlDb.CloseCallCount(), fakeSqlDb.ExecCallCount()
+			Expect(store.(*nfsbroker.SqlStore).Reconnect(logger, newVariant)).To(Succeed())
+			Expect(newSqlDb.PingCallCount()).To(Equal(1))
+			Expect(fakeSqlDb.CloseCallCount()).To(Equal(oldCloses + 1))
+
+			store.(*nfsbroker.SqlStore).Database.Exec("DELETE FROM service_instances")
+			Expect(newSqlDb.ExecCallCount()).To(Equal(1))
+			Expect(fakeSqlDb.ExecCallCount()).To(Equal(oldExecs))
+		})
+
+		Context("when the database refuses the new credentials", func() {
+			BeforeEach(func() {
+				newSqlDb.PingReturns(errors.New("access denied"))
+			})
+
+			It("keeps using the old pool", func() {
+				oldCloses, oldExecs := fakeSqlDb.CloseCallCount(), fakeSqlDb.ExecCallCount()
+				Expect(store.(*nfsbroker.SqlStore).Reconnect(logger, newVariant)).To(MatchError("access denied"))
+				Expect(newSqlDb.CloseCallCount()).To(Equal(1))
+				Expect(newVariant.CloseCallCount()).To(Equal(1))
+				Expect(fakeSqlDb.CloseCallCount()).To(Equal(oldCloses))
+
+				store.(*nfsbroker.SqlStore).Database.Exec("DELETE FROM service_instances")
+				Expect(fakeSqlDb.ExecCallCount()).To(Equal(oldExecs + 1))
+			})
+		})
 	})
 
 	Describe("Restore", func() {
@@ -134,7 +324,7 @@ var _ = Describe("SqlStore", func() {
 			})
 			JustBeforeEach(func() {
 
-				serviceInstance, err = sqlStore.RetrieveInstanceDetails(serviceID)
+				serviceInstance, err = sqlStore.RetrieveInstanceDetails(ctx, serviceID)
 			})
 			It("should return the instance", func() {
 				Expect(err).To(BeNil())
@@ -146,15 +336,28 @@ var _ = Describe("SqlStore", func() {
 				Expect(serviceInstance.Share).To(Equal(share))
 			})
 		})
+		Context("When the instance is corrupt", func() {
+			BeforeEach(func() {
+				rows := sqlmock.NewRows([]string{"id", "value"}).AddRow(serviceID, []byte(`"junk"`))
+				mock.ExpectQuery("SELECT id, value FROM service_instances WHERE id = ?").WithArgs(serviceID).WillReturnRows(rows)
+			})
+			JustBeforeEach(func() {
+				serviceInstance, err = sqlStore.RetrieveInstanceDetails(ctx, serviceID)
+			})
+			It("should return an error identifying the record", func() {
+				Expect(errors.Is(err, nfsbroker.ErrCorruptRecord)).To(BeTrue())
+				Expect(err).To(MatchError(ContainSubstring("service instance " + serviceID)))
+			})
+		})
 		Context("When the instance does not exist", func() {
 			BeforeEach(func() {
-				mock.ExpectQuery("SELECT id, value FROM service_instances WHERE id = ?").WithArgs(serviceID)
+				mock.ExpectQuery("SELECT id, value FROM service_instances WHERE id = ?").WithArgs(serviceID).WillReturnRows(sqlmock.NewRows([]string{"id", "value"}))
 			})
 			JustBeforeEach(func() {
-				serviceInstance, err = sqlStore.RetrieveInstanceDetails(serviceID)
+				serviceInstance, err = sqlStore.RetrieveInstanceDetails(ctx, serviceID)
 			})
 			It("should return an error", func() {
-				Expect(err).To(HaveOccurred())
+				Expect(errors.Is(err, nfsbroker.ErrInstanceNotFound)).To(BeTrue())
 				Expect(reflect.DeepEqual(serviceInstance, nfsbroker.ServiceInstance{})).To(BeTrue())
 			})
 		})
@@ -181,7 +384,7 @@ var _ = Describe("SqlStore", func() {
 			})
 			JustBeforeEach(func() {
 
-				bindDetails, err = sqlStore.RetrieveBindingDetails(bindingID)
+				bindDetails, err = sqlStore.RetrieveBindingDetails(ctx, bindingID)
 			})
 			It("should return the binding details", func() {
 				Expect(err).To(BeNil())
@@ -196,13 +399,13 @@ var _ = Describe("SqlStore", func() {
 		})
 		Context("When the binding does not exist", func() {
 			BeforeEach(func() {
-				mock.ExpectQuery("SELECT id, value FROM service_bindings WHERE id = ?").WithArgs(bindingID)
+				mock.ExpectQuery("SELECT id, value FROM service_bindings WHERE id = ?").WithArgs(bindingID).WillReturnRows(sqlmock.NewRows([]string{"id", "value"}))
 			})
 			JustBeforeEach(func() {
-				bindDetails, err = sqlStore.RetrieveBindingDetails(bindingID)
+				bindDetails, err = sqlStore.RetrieveBindingDetails(ctx, bindingID)
 			})
 			It("should return an error", func() {
-				Expect(err).To(HaveOccurred())
+				Expect(errors.Is(err, nfsbroker.ErrBindingNotFound)).To(BeTrue())
 				Expect(reflect.DeepEqual(bindDetails, brokerapi.BindDetails{})).To(BeTrue())
 			})
 		})
@@ -221,15 +424,26 @@ var _ = Describe("SqlStore", func() {
 			Expect(err).NotTo(HaveOccurred())
 
 			result := sqlmock.NewResult(1, 1)
-			mock.ExpectExec("INSERT INTO service_instances").WithArgs(serviceID, jsonValue).WillReturnResult(result)
+			mock.ExpectExec("INSERT INTO service_instances").WithArgs(serviceID, serviceID, planID, jsonValue).WillReturnResult(result)
 		})
 		JustBeforeEach(func() {
-			err = sqlStore.CreateInstanceDetails(serviceID, serviceInstance)
+			err = sqlStore.CreateInstanceDetails(ctx, serviceID, serviceInstance)
 		})
 		It("should not error and call INSERT INTO on the db", func() {
 			Expect(err).To(BeNil())
 			Expect(mock.ExpectationsWereMet()).Should(Succeed())
 		})
+
+		Context("when the serialized instance exceeds the maximum value size", func() {
+			BeforeEach(func() {
+				sqlStore.MaxValueSize = 10
+			})
+
+			It("should error without writing to the db", func() {
+				Expect(err).To(MatchError(ContainSubstring("too large to store")))
+				Expect(mock.ExpectationsWereMet()).NotTo(Succeed())
+			})
+		})
 	})
 
 	Describe("CreateBindingDetails", func() {
@@ -243,7 +457,7 @@ var _ = Describe("SqlStore", func() {
 			bindDetails = brokerapi.BindDetails{AppGUID: appGUID, PlanID: planID, ServiceID: serviceID, BindResource: &bindResource, Parameters: parameters}
 		})
 		JustBeforeEach(func() {
-			err = sqlStore.CreateBindingDetails(bindingID, bindDetails)
+			err = sqlStore.CreateBindingDetails(ctx, "instance_123", bindingID, bindDetails)
 		})
 
 		Context("when there are no parameters in the binding", func() {
@@ -252,7 +466,7 @@ var _ = Describe("SqlStore", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				result := sqlmock.NewResult(1, 1)
-				mock.ExpectExec("INSERT INTO service_bindings").WithArgs(bindingID, jsonValue).WillReturnResult(result)
+				mock.ExpectExec("INSERT INTO service_bindings").WithArgs(bindingID, "instance_123", serviceID, planID, jsonValue).WillReturnResult(result)
 			})
 			It("should not error and call INSERT INTO on the db", func() {
 				Expect(err).To(BeNil())
@@ -264,7 +478,7 @@ var _ = Describe("SqlStore", func() {
 			BeforeEach(func() {
 				bindDetails = brokerapi.BindDetails{AppGUID: appGUID, PlanID: planID, ServiceID: serviceID, BindResource: &bindResource, Parameters: map[string]interface{}{"secret": "don't tell"}}
 				result := sqlmock.NewResult(1, 1)
-				mock.ExpectExec("INSERT INTO service_bindings").WithArgs(bindingID, &redactedStuff{}).WillReturnResult(result)
+				mock.ExpectExec("INSERT INTO service_bindings").WithArgs(bindingID, "instance_123", serviceID, planID, &redactedStuff{}).WillReturnResult(result)
 			})
 			It("should redact parameters before saving records to the db", func() {
 				Expect(err).To(BeNil())
@@ -273,6 +487,332 @@ var _ = Describe("SqlStore", func() {
 		})
 	})
 
+	Describe("ListInstanceDetails", func() {
+		var (
+			rows      *sqlmock.Rows
+			instances map[string]nfsbroker.ServiceInstance
+		)
+
+		BeforeEach(func() {
+			rows = sqlmock.NewRows([]string{"id", "value"}).
+				AddRow("instance_1", []byte(`{"service_id":"service_123","Share":"server/share_1"}`)).
+				AddRow("instance_2", []byte(`{"service_id":"service_123","Share":"server/share_2"}`))
+		})
+		JustBeforeEach(func() {
+			mock.ExpectQuery("SELECT id, value FROM service_instances").WillReturnRows(rows)
+			instances, err = sqlStore.ListInstanceDetails(ctx, nfsbroker.ListOptions{})
+		})
+		It("should return every instance", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(2))
+			Expect(instances["instance_2"].Share).To(Equal("server/share_2"))
+			Expect(instances["instance_2"].SharePath).To(Equal("/share_2"))
+		})
+
+		Context("when filtering and paging", func() {
+			JustBeforeEach(func() {
+				mock.ExpectQuery(`SELECT id, value FROM service_instances WHERE service_id = \? AND plan_id = \? AND id > \? ORDER BY id LIMIT 2`).
+					WithArgs("service_123", "plan_123", "instance_1").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "value"}))
+				instances, err = sqlStore.ListInstanceDetails(ctx, nfsbroker.ListOptions{ServiceID: "service_123", PlanID: "plan_123", After: "instance_1", Limit: 2})
+			})
+			It("should push the filter and page into the query", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(instances).To(BeEmpty())
+			})
+		})
+
+		Context("when a row cannot be unmarshalled", func() {
+			var corruptBefore uint64
+
+			BeforeEach(func() {
+				corruptBefore = nfsbroker.CorruptRecordCount()
+				rows = sqlmock.NewRows([]string{"id", "value"}).
+					AddRow("instance_1", []byte(`{`)).
+					AddRow("instance_2", []byte(`{"service_id":"service_123","Share":"server/share_2"}`))
+			})
+			It("should skip it and count it", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(instances).To(HaveLen(1))
+				Expect(instances).To(HaveKey("instance_2"))
+				Expect(nfsbroker.CorruptRecordCount()).To(Equal(corruptBefore + 1))
+			})
+		})
+
+		Context("when a page has a row that cannot be unmarshalled", func() {
+			JustBeforeEach(func() {
+				mock.ExpectQuery(`SELECT id, value FROM service_instances ORDER BY id LIMIT 2`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "value"}).
+						AddRow("instance_1", []byte(`{`)).
+						AddRow("instance_2", []byte(`{"Share":"server/share_2"}`)))
+				mock.ExpectQuery(`SELECT id, value FROM service_instances WHERE id > \? ORDER BY id LIMIT 1`).
+					WithArgs("instance_2").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "value"}).
+						AddRow("instance_3", []byte(`{"Share":"server/share_3"}`)))
+				instances, err = sqlStore.ListInstanceDetails(ctx, nfsbroker.ListOptions{Limit: 2})
+			})
+			It("should fill the page from the following rows", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(instances).To(HaveLen(2))
+				Expect(instances).To(HaveKey("instance_2"))
+				Expect(instances).To(HaveKey("instance_3"))
+			})
+		})
+	})
+
+	Describe("ListBindingDetails", func() {
+		var bindings map[string]brokerapi.BindDetails
+
+		BeforeEach(func() {
+			rows := sqlmock.NewRows([]string{"id", "value"}).
+				AddRow("binding_1", []byte(`{"app_guid":"app_1","plan_id":"plan_123"}`))
+			mock.ExpectQuery("SELECT id, value FROM service_bindings").WillReturnRows(rows)
+		})
+		JustBeforeEach(func() {
+			bindings, err = sqlStore.ListBindingDetails(ctx, nfsbroker.ListOptions{})
+		})
+		It("should return every binding", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bindings).To(HaveKey("binding_1"))
+			Expect(bindings["binding_1"].AppGUID).To(Equal("app_1"))
+		})
+	})
+
+	Describe("ListBindingInstances", func() {
+		var bindingInstances map[string]string
+
+		BeforeEach(func() {
+			rows := sqlmock.NewRows([]string{"id", "instance_id"}).
+				AddRow("binding_1", "instance_1").
+				AddRow("binding_2", nil)
+			mock.ExpectQuery("SELECT id, instance_id FROM service_bindings").WillReturnRows(rows)
+		})
+		JustBeforeEach(func() {
+			bindingInstances, err = sqlStore.ListBindingInstances(ctx)
+		})
+		It("should map bindings to their instances", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bindingInstances).To(Equal(map[string]string{"binding_1": "instance_1", "binding_2": ""}))
+		})
+	})
+
+	Describe("CountInstances and CountBindings", func() {
+		It("should count the rows in the database", func() {
+			mock.ExpectQuery(`SELECT COUNT\(\*\) FROM service_instances`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+			mock.ExpectQuery(`SELECT COUNT\(\*\) FROM service_bindings`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+
+			instances, err := sqlStore.CountInstances(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(Equal(3))
+
+			bindings, err := sqlStore.CountBindings(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bindings).To(Equal(5))
+		})
+	})
+
+	Describe("RetrieveJobNextRun", func() {
+		var nextRun time.Time
+
+		JustBeforeEach(func() {
+			nextRun, err = sqlStore.RetrieveJobNextRun(ctx, "some-job")
+		})
+
+		Context("when the job has been scheduled", func() {
+			BeforeEach(func() {
+				rows := sqlmock.NewRows([]string{"next_run"}).AddRow("2017-06-01T12:00:00Z")
+				mock.ExpectQuery("SELECT next_run FROM scheduled_jobs WHERE name = ?").WithArgs("some-job").WillReturnRows(rows)
+			})
+			It("should return the stored time", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(nextRun).To(Equal(time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)))
+			})
+		})
+
+		Context("when the job has never been scheduled", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT next_run FROM scheduled_jobs WHERE name = ?").WithArgs("some-job").WillReturnRows(sqlmock.NewRows([]string{"next_run"}))
+			})
+			It("should return the zero time", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(nextRun.IsZero()).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("SaveJobNextRun", func() {
+		var nextRun time.Time
+
+		BeforeEach(func() {
+			nextRun = time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+		})
+
+		JustBeforeEach(func() {
+			err = sqlStore.SaveJobNextRun(ctx, "some-job", nextRun)
+		})
+
+		Context("when the job has no row yet", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT name FROM scheduled_jobs WHERE name = ?").WithArgs("some-job").WillReturnRows(sqlmock.NewRows([]string{"name"}))
+				mock.ExpectExec("INSERT INTO scheduled_jobs").WithArgs("some-job", "2017-06-01T12:00:00Z").WillReturnResult(sqlmock.NewResult(1, 1))
+			})
+			It("should insert one", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+			})
+		})
+
+		Context("when the job already has a row", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT name FROM scheduled_jobs WHERE name = ?").WithArgs("some-job").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("some-job"))
+				mock.ExpectExec("UPDATE scheduled_jobs SET next_run = .+ WHERE name = .+").WithArgs("2017-06-01T12:00:00Z", "some-job").WillReturnResult(sqlmock.NewResult(0, 1))
+			})
+			It("should update it", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+			})
+		})
+	})
+
+	Describe("RetrieveOperation", func() {
+		It("should return the stored operation", func() {
+			rows := sqlmock.NewRows([]string{"type", "state", "description"}).AddRow("provision", "failed", "export missing")
+			mock.ExpectQuery("SELECT type, state, description FROM service_operations WHERE instance_id = ?").WithArgs("instance_1").WillReturnRows(rows)
+
+			operation, err := sqlStore.RetrieveOperation(ctx, "instance_1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(operation).To(Equal(nfsbroker.Operation{Type: "provision", State: brokerapi.Failed, Description: "export missing"}))
+		})
+
+		It("should return the zero operation when none is recorded", func() {
+			mock.ExpectQuery("SELECT type, state, description FROM service_operations").WillReturnRows(sqlmock.NewRows([]string{"type", "state", "description"}))
+
+			operation, err := sqlStore.RetrieveOperation(ctx, "instance_1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(operation).To(Equal(nfsbroker.Operation{}))
+		})
+	})
+
+	Describe("retired plans", func() {
+		It("should list them", func() {
+			rows := sqlmock.NewRows([]string{"plan_id", "hint"}).AddRow("plan_1", "use plan_2").AddRow("plan_3", nil)
+			mock.ExpectQuery("SELECT plan_id, hint FROM retired_plans").WillReturnRows(rows)
+
+			Expect(sqlStore.ListRetiredPlans(ctx)).To(Equal(map[string]string{"plan_1": "use plan_2", "plan_3": ""}))
+		})
+
+		It("should insert a newly retired plan", func() {
+			mock.ExpectQuery("SELECT plan_id FROM retired_plans WHERE plan_id = ?").WithArgs("plan_1").WillReturnRows(sqlmock.NewRows([]string{"plan_id"}))
+			mock.ExpectExec("INSERT INTO retired_plans").WithArgs("plan_1", "use plan_2").WillReturnResult(sqlmock.NewResult(1, 1))
+
+			Expect(sqlStore.RetirePlan(ctx, "plan_1", "use plan_2")).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("should update the hint of a retired plan", func() {
+			mock.ExpectQuery("SELECT plan_id FROM retired_plans WHERE plan_id = ?").WithArgs("plan_1").WillReturnRows(sqlmock.NewRows([]string{"plan_id"}).AddRow("plan_1"))
+			mock.ExpectExec("UPDATE retired_plans SET hint = .+ WHERE plan_id = .+").WithArgs("use plan_3", "plan_1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+			Expect(sqlStore.RetirePlan(ctx, "plan_1", "use plan_3")).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("should delete a reinstated plan", func() {
+			mock.ExpectExec("DELETE FROM retired_plans WHERE plan_id = ?").WithArgs("plan_1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+			Expect(sqlStore.ReinstatePlan(ctx, "plan_1")).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
+	Describe("share reservations", func() {
+		It("should list them", func() {
+			rows := sqlmock.NewRows([]string{"prefix", "organization_guid"}).AddRow("/exports/team_a", "org_1")
+			mock.ExpectQuery("SELECT prefix, organization_guid FROM share_reservations").WillReturnRows(rows)
+
+			Expect(sqlStore.ListShareReservations(ctx)).To(Equal(map[string]string{"/exports/team_a": "org_1"}))
+		})
+
+		It("should insert a new reservation", func() {
+			mock.ExpectQuery("SELECT prefix FROM share_reservations WHERE prefix = ?").WithArgs("/exports/team_a").WillReturnRows(sqlmock.NewRows([]string{"prefix"}))
+			mock.ExpectExec("INSERT INTO share_reservations").WithArgs("/exports/team_a", "org_1").WillReturnResult(sqlmock.NewResult(1, 1))
+
+			Expect(sqlStore.ReserveShares(ctx, "/exports/team_a", "org_1")).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("should move an existing reservation", func() {
+			mock.ExpectQuery("SELECT prefix FROM share_reservations WHERE prefix = ?").WithArgs("/exports/team_a").WillReturnRows(sqlmock.NewRows([]string{"prefix"}).AddRow("/exports/team_a"))
+			mock.ExpectExec("UPDATE share_reservations SET organization_guid = .+ WHERE prefix = .+").WithArgs("org_2", "/exports/team_a").WillReturnResult(sqlmock.NewResult(0, 1))
+
+			Expect(sqlStore.ReserveShares(ctx, "/exports/team_a", "org_2")).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("should delete a released reservation", func() {
+			mock.ExpectExec("DELETE FROM share_reservations WHERE prefix = ?").WithArgs("/exports/team_a").WillReturnResult(sqlmock.NewResult(0, 1))
+
+			Expect(sqlStore.ReleaseShares(ctx, "/exports/team_a")).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
+	Describe("SaveOperation", func() {
+		It("should insert the first operation for an instance", func() {
+			mock.ExpectQuery("SELECT instance_id FROM service_operations WHERE instance_id = ?").WithArgs("instance_1").WillReturnRows(sqlmock.NewRows([]string{"instance_id"}))
+			mock.ExpectExec("INSERT INTO service_operations").WithArgs("instance_1", "provision", "in progress", "").WillReturnResult(sqlmock.NewResult(1, 1))
+
+			Expect(sqlStore.SaveOperation(ctx, "instance_1", nfsbroker.Operation{Type: "provision", State: brokerapi.InProgress})).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("should update an existing operation", func() {
+			mock.ExpectQuery("SELECT instance_id FROM service_operations WHERE instance_id = ?").WithArgs("instance_1").WillReturnRows(sqlmock.NewRows([]string{"instance_id"}).AddRow("instance_1"))
+			mock.ExpectExec("UPDATE service_operations SET type = .+, state = .+, description = .+ WHERE instance_id = .+").WithArgs("provision", "succeeded", "", "instance_1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+			Expect(sqlStore.SaveOperation(ctx, "instance_1", nfsbroker.Operation{Type: "provision", State: brokerapi.Succeeded})).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
+	Describe("UpdateInstanceDetails", func() {
+		BeforeEach(func() {
+			Expect(err).NotTo(HaveOccurred())
+			serviceID = "service_123"
+			planID = "plan_456"
+			serviceInstance = nfsbroker.ServiceInstance{ServiceID: serviceID, PlanID: planID, Share: "server/other_share"}
+		})
+		JustBeforeEach(func() {
+			err = sqlStore.UpdateInstanceDetails(ctx, "instance_123", serviceInstance)
+		})
+
+		Context("when the instance exists", func() {
+			BeforeEach(func() {
+				jsonValue, err := json.Marshal(serviceInstance)
+				Expect(err).NotTo(HaveOccurred())
+
+				mock.ExpectQuery("SELECT id FROM service_instances WHERE id = ?").WithArgs("instance_123").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("instance_123"))
+				mock.ExpectExec("UPDATE service_instances SET").WithArgs(serviceID, planID, jsonValue, "instance_123").WillReturnResult(sqlmock.NewResult(0, 1))
+			})
+
+			It("should not error and call UPDATE on the db", func() {
+				Expect(err).To(BeNil())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			})
+		})
+
+		Context("when the instance does not exist", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT id FROM service_instances WHERE id = ?").WithArgs("instance_123").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			})
+
+			It("returns not found without updating", func() {
+				Expect(errors.Is(err, nfsbroker.ErrInstanceNotFound)).To(BeTrue())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			})
+		})
+	})
+
 	Describe("DeleteInstanceDetails", func() {
 		BeforeEach(func() {
 			Expect(err).NotTo(HaveOccurred())
@@ -281,13 +821,74 @@ var _ = Describe("SqlStore", func() {
 			mock.ExpectExec("DELETE FROM service_instances WHERE id = ?").WithArgs(serviceID).WillReturnResult(result)
 		})
 		JustBeforeEach(func() {
-			err = sqlStore.DeleteInstanceDetails(serviceID)
+			err = sqlStore.DeleteInstanceDetails(ctx, serviceID)
 		})
 		It("should not error and call DELETE FROM on the db", func() {
 			Expect(err).To(BeNil())
 			Expect(mock.ExpectationsWereMet()).Should(Succeed())
 		})
+
+		It("should report an instance that was not there as not found", func() {
+			mock.ExpectExec("DELETE FROM service_instances WHERE id = ?").WithArgs("missing_instance").WillReturnResult(sqlmock.NewResult(0, 0))
+			err := sqlStore.DeleteInstanceDetails(ctx, "missing_instance")
+			Expect(errors.Is(err, nfsbroker.ErrInstanceNotFound)).To(BeTrue())
+		})
 	})
+
+	Describe("query deadlines", func() {
+		BeforeEach(func() {
+			serviceID = "slow_service"
+			sqlStore.QueryTimeout = 10 * time.Millisecond
+			mock.ExpectExec("DELETE FROM service_instances WHERE id = ?").WithArgs(serviceID).WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(1, 1))
+		})
+
+		It("should give up on queries that exceed the query timeout", func() {
+			// the abandoned query keeps running, so give it a store of its own
+			slowStore := sqlStore
+			err = slowStore.DeleteInstanceDetails(ctx, serviceID)
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+			Expect(errors.Is(err, nfsbroker.ErrStoreUnavailable)).To(BeTrue())
+		})
+
+		It("should give up when the caller's context is cancelled", func() {
+			slowStore := sqlStore
+			slowStore.QueryTimeout = 0
+			cancelledCtx, cancel := context.WithCancel(ctx)
+			cancel()
+			err = slowStore.DeleteInstanceDetails(cancelledCtx, serviceID)
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		})
+	})
+
+	Describe("slow queries", func() {
+		var testLogger *lagertest.TestLogger
+
+		BeforeEach(func() {
+			testLogger = lagertest.NewTestLogger("test-sql-store")
+			sqlStore.Logger = testLogger
+			sqlStore.SlowQueryThreshold = 20 * time.Millisecond
+		})
+
+		It("should log statements that take longer than the threshold, without their arguments", func() {
+			mock.ExpectExec("DELETE FROM service_instances WHERE id = ?").WithArgs("slow_instance").WillDelayFor(50 * time.Millisecond).WillReturnResult(sqlmock.NewResult(1, 1))
+			Expect(sqlStore.DeleteInstanceDetails(nfsbroker.WithRequestID(ctx, "request-id"), "slow_instance")).To(Succeed())
+
+			Expect(testLogger.LogMessages()).To(Equal([]string{"test-sql-store.slow-query"}))
+			data := testLogger.Logs()[0].Data
+			Expect(data).To(HaveKeyWithValue("statement", "DELETE FROM service_instances WHERE id = ?"))
+			Expect(data).To(HaveKeyWithValue("threshold", "20ms"))
+			Expect(data).To(HaveKeyWithValue("requestID", "request-id"))
+			Expect(data).To(HaveKey("duration"))
+			Expect(testLogger.Buffer()).NotTo(gbytes.Say("slow_instance"))
+		})
+
+		It("should not log statements that finish in time", func() {
+			mock.ExpectExec("DELETE FROM service_instances WHERE id = ?").WithArgs("fast_instance").WillReturnResult(sqlmock.NewResult(1, 1))
+			Expect(sqlStore.DeleteInstanceDetails(ctx, "fast_instance")).To(Succeed())
+			Expect(testLogger.Logs()).To(BeEmpty())
+		})
+	})
+
 	Describe("DeleteBindingDetails", func() {
 		BeforeEach(func() {
 			Expect(err).NotTo(HaveOccurred())
@@ -296,11 +897,17 @@ var _ = Describe("SqlStore", func() {
 			mock.ExpectExec("DELETE FROM service_bindings WHERE id = ?").WithArgs(bindingID).WillReturnResult(result)
 		})
 		JustBeforeEach(func() {
-			err = sqlStore.DeleteBindingDetails(bindingID)
+			err = sqlStore.DeleteBindingDetails(ctx, bindingID)
 		})
 		It("should not error and call DELETE FROM on the db", func() {
 			Expect(err).To(BeNil())
 			Expect(mock.ExpectationsWereMet()).Should(Succeed())
 		})
+
+		It("should report a binding that was not there as not found", func() {
+			mock.ExpectExec("DELETE FROM service_bindings WHERE id = ?").WithArgs("missing_binding").WillReturnResult(sqlmock.NewResult(0, 0))
+			err := sqlStore.DeleteBindingDetails(ctx, "missing_binding")
+			Expect(errors.Is(err, nfsbroker.ErrBindingNotFound)).To(BeTrue())
+		})
 	})
 })
@@ -0,0 +1,232 @@
+package nfsbroker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var (
+	ErrNoStandbyStore       = errors.New("no standby store is configured")
+	ErrStandbyStoreMismatch = errors.New("standby store does not match the active store")
+)
+
+// SwitchableStore delegates to an active store that can be exchanged for a standby store while the broker runs,
+// for instance to move onto a database that records have been migrated to.
+type SwitchableStore struct {
+	mutex   sync.RWMutex
+	active  Store
+	standby Store
+}
+
+func NewSwitchableStore(active, standby Store) *SwitchableStore {
+	return &SwitchableStore{active: active, standby: standby}
+}
+
+// Promote makes the standby store active once it is verified to hold the same instances and bindings as the
+// active store.  The previously active store becomes the standby, so promoting again rolls the switch back.
+func (s *SwitchableStore) Promote(ctx context.Context, logger lager.Logger) error {
+	logger = logger.Session("promote-standby-store")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.standby == nil {
+		return ErrNoStandbyStore
+	}
+	if err := s.standby.Restore(logger); err != nil {
+		return fmt.Errorf("failed to restore standby store: %w", err)
+	}
+	if err := verifySameRecords(ctx, s.active, s.standby); err != nil {
+		logger.Error("standby-store-verification-failed", err)
+		return err
+	}
+
+	s.active, s.standby = s.standby, s.active
+	if err := s.active.Save(logger); err != nil {
+		logger.Error("failed-to-save-promoted-store", err)
+		s.active, s.standby = s.standby, s.active
+		return err
+	}
+	logger.Info("promoted-standby-store")
+	return nil
+}
+
+// PromoteStandbyStore switches the broker onto its standby store.  Broker requests wait while the stores are
+// compared.
+func (b *Broker) PromoteStandbyStore(ctx context.Context) error {
+	store, ok := unwrapStore(b.store).(*SwitchableStore)
+	if !ok {
+		return ErrNoStandbyStore
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return store.Promote(ctx, b.logger)
+}
+
+func verifySameRecords(ctx context.Context, active, standby Store) error {
+	activeInstances, err := active.ListInstanceDetails(ctx, ListOptions{})
+	if err != nil {
+		return err
+	}
+	standbyInstances, err := standby.ListInstanceDetails(ctx, ListOptions{})
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(activeInstances, standbyInstances) {
+		return fmt.Errorf("%w: service instances differ (standby has %d, active has %d)", ErrStandbyStoreMismatch, len(standbyInstances), len(activeInstances))
+	}
+
+	activeBindings, err := active.ListBindingDetails(ctx, ListOptions{})
+	if err != nil {
+		return err
+	}
+	standbyBindings, err := standby.ListBindingDetails(ctx, ListOptions{})
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(activeBindings, standbyBindings) {
+		return fmt.Errorf("%w: service bindings differ (standby has %d, active has %d)", ErrStandbyStoreMismatch, len(standbyBindings), len(activeBindings))
+	}
+	return nil
+}
+
+func (s *SwitchableStore) current() Store {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.active
+}
+
+func (s *SwitchableStore) RetrieveInstanceDetails(ctx context.Context, id string) (ServiceInstance, error) {
+	return s.current().RetrieveInstanceDetails(ctx, id)
+}
+
+func (s *SwitchableStore) RetrieveBindingDetails(ctx context.Context, id string) (brokerapi.BindDetails, error) {
+	return s.current().RetrieveBindingDetails(ctx, id)
+}
+
+func (s *SwitchableStore) CreateInstanceDetails(ctx context.Context, id string, details ServiceInstance) error {
+	return s.current().CreateInstanceDetails(ctx, id, details)
+}
+
+func (s *SwitchableStore) CreateBindingDetails(ctx context.Context, instanceID, id string, details brokerapi.BindDetails) error {
+	return s.current().CreateBindingDetails(ctx, instanceID, id, details)
+}
+
+func (s *SwitchableStore) UpdateInstanceDetails(ctx context.Context, id string, details ServiceInstance) error {
+	return s.current().UpdateInstanceDetails(ctx, id, details)
+}
+
+func (s *SwitchableStore) DeleteInstanceDetails(ctx context.Context, id string) error {
+	return s.current().DeleteInstanceDetails(ctx, id)
+}
+
+func (s *SwitchableStore) DeleteBindingDetails(ctx context.Context, id string) error {
+	return s.current().DeleteBindingDetails(ctx, id)
+}
+
+func (s *SwitchableStore) ListInstanceDetails(ctx context.Context, opts ListOptions) (map[string]ServiceInstance, error) {
+	return s.current().ListInstanceDetails(ctx, opts)
+}
+
+func (s *SwitchableStore) ListBindingDetails(ctx context.Context, opts ListOptions) (map[string]brokerapi.BindDetails, error) {
+	return s.current().ListBindingDetails(ctx, opts)
+}
+
+func (s *SwitchableStore) ListBindingInstances(ctx context.Context) (map[string]string, error) {
+	return s.current().ListBindingInstances(ctx)
+}
+
+func (s *SwitchableStore) CountInstances(ctx context.Context) (int, error) {
+	return s.current().CountInstances(ctx)
+}
+
+func (s *SwitchableStore) CountBindings(ctx context.Context) (int, error) {
+	return s.current().CountBindings(ctx)
+}
+
+func (s *SwitchableStore) RetrieveJobNextRun(ctx context.Context, name string) (time.Time, error) {
+	return s.current().RetrieveJobNextRun(ctx, name)
+}
+
+func (s *SwitchableStore) SaveJobNextRun(ctx context.Context, name string, next time.Time) error {
+	return s.current().SaveJobNextRun(ctx, name, next)
+}
+
+func (s *SwitchableStore) RetrieveOperation(ctx context.Context, instanceID string) (Operation, error) {
+	return s.current().RetrieveOperation(ctx, instanceID)
+}
+
+func (s *SwitchableStore) SaveOperation(ctx context.Context, instanceID string, operation Operation) error {
+	return s.current().SaveOperation(ctx, instanceID, operation)
+}
+
+func (s *SwitchableStore) ListRetiredPlans(ctx context.Context) (map[string]string, error) {
+	return s.current().ListRetiredPlans(ctx)
+}
+
+func (s *SwitchableStore) RetirePlan(ctx context.Context, planID, hint string) error {
+	return s.current().RetirePlan(ctx, planID, hint)
+}
+
+func (s *SwitchableStore) ReinstatePlan(ctx context.Context, planID string) error {
+	return s.current().ReinstatePlan(ctx, planID)
+}
+
+func (s *SwitchableStore) ListShareReservations(ctx context.Context) (map[string]string, error) {
+	return s.current().ListShareReservations(ctx)
+}
+
+func (s *SwitchableStore) ReserveShares(ctx context.Context, prefix, orgGUID string) error {
+	return s.current().ReserveShares(ctx, prefix, orgGUID)
+}
+
+func (s *SwitchableStore) ReleaseShares(ctx context.Context, prefix string) error {
+	return s.current().ReleaseShares(ctx, prefix)
+}
+
+func (s *SwitchableStore) IsInstanceConflict(ctx context.Context, id string, details ServiceInstance) bool {
+	return s.current().IsInstanceConflict(ctx, id, details)
+}
+
+func (s *SwitchableStore) IsBindingConflict(ctx context.Context, id string, details brokerapi.BindDetails) bool {
+	return s.current().IsBindingConflict(ctx, id, details)
+}
+
+func (s *SwitchableStore) Restore(logger lager.Logger) error {
+	return s.current().Restore(logger)
+}
+
+func (s *SwitchableStore) Save(logger lager.Logger) error {
+	return s.current().Save(logger)
+}
+
+// CheckHealth checks the active store.
+func (s *SwitchableStore) CheckHealth(ctx context.Context) error {
+	if checker, ok := s.current().(HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
+}
+
+func (s *SwitchableStore) Cleanup() error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	err := s.active.Cleanup()
+	if s.standby != nil {
+		if standbyErr := s.standby.Cleanup(); err == nil {
+			err = standbyErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"errors"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SwitchableStore", func() {
+	var (
+		ctx          context.Context
+		logger       *lagertest.TestLogger
+		activeStore  *nfsbrokerfakes.FakeStore
+		standbyStore *nfsbrokerfakes.FakeStore
+		store        *nfsbroker.SwitchableStore
+		instances    map[string]nfsbroker.ServiceInstance
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		logger = lagertest.NewTestLogger("test-switchable-store")
+		instances = map[string]nfsbroker.ServiceInstance{"instance-1": {Share: "server:/export"}}
+		activeStore = &nfsbrokerfakes.FakeStore{}
+		activeStore.ListInstanceDetailsReturns(instances, nil)
+		standbyStore = &nfsbrokerfakes.FakeStore{}
+		standbyStore.ListInstanceDetailsReturns(instances, nil)
+		store = nfsbroker.NewSwitchableStore(activeStore, standbyStore)
+	})
+
+	It("delegates to the active store", func() {
+		store.RetrieveInstanceDetails(ctx, "instance-1")
+		Expect(activeStore.RetrieveInstanceDetailsCallCount()).To(Equal(1))
+		Expect(standbyStore.RetrieveInstanceDetailsCallCount()).To(Equal(0))
+	})
+
+	Describe("Promote", func() {
+		It("switches to the standby store once it holds the same records", func() {
+			Expect(store.Promote(ctx, logger)).To(Succeed())
+			Expect(standbyStore.RestoreCallCount()).To(Equal(1))
+			Expect(standbyStore.SaveCallCount()).To(Equal(1))
+
+			store.RetrieveInstanceDetails(ctx, "instance-1")
+			Expect(standbyStore.RetrieveInstanceDetailsCallCount()).To(Equal(1))
+			Expect(activeStore.RetrieveInstanceDetailsCallCount()).To(Equal(0))
+		})
+
+		It("switches back when promoted again", func() {
+			Expect(store.Promote(ctx, logger)).To(Succeed())
+			Expect(store.Promote(ctx, logger)).To(Succeed())
+
+			store.RetrieveInstanceDetails(ctx, "instance-1")
+			Expect(activeStore.RetrieveInstanceDetailsCallCount()).To(Equal(1))
+		})
+
+		Context("when the standby store is missing records", func() {
+			BeforeEach(func() {
+				standbyStore.ListInstanceDetailsReturns(map[string]nfsbroker.ServiceInstance{}, nil)
+			})
+
+			It("keeps the active store", func() {
+				err := store.Promote(ctx, logger)
+				Expect(errors.Is(err, nfsbroker.ErrStandbyStoreMismatch)).To(BeTrue())
+
+				store.RetrieveInstanceDetails(ctx, "instance-1")
+				Expect(activeStore.RetrieveInstanceDetailsCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("when the standby store cannot be saved", func() {
+			BeforeEach(func() {
+				standbyStore.SaveReturns(errors.New("disk full"))
+			})
+
+			It("rolls back to the active store", func() {
+				Expect(store.Promote(ctx, logger)).To(MatchError("disk full"))
+
+				store.RetrieveInstanceDetails(ctx, "instance-1")
+				Expect(activeStore.RetrieveInstanceDetailsCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("when there is no standby store", func() {
+			BeforeEach(func() {
+				store = nfsbroker.NewSwitchableStore(activeStore, nil)
+			})
+
+			It("fails", func() {
+				Expect(store.Promote(ctx, logger)).To(Equal(nfsbroker.ErrNoStandbyStore))
+			})
+		})
+	})
+})
@@ -0,0 +1,163 @@
+package nfsbroker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+const (
+	// subdirectoryPerm lets apps write to their subdirectories whatever uid they mount them with.
+	subdirectoryPerm = 0777
+	// archivePerm keeps archived subdirectories from apps that mount the base export.
+	archivePerm = 0700
+
+	// ArchiveDir is the directory of the base export that archived subdirectories are moved to.  Instance IDs
+	// cannot start with a dot, so it cannot be any instance's subdirectory.
+	ArchiveDir = ".archive"
+)
+
+// What happens to the data in an instance's subdirectory when the instance is deprovisioned.
+const (
+	// DataKeep leaves the subdirectory as it is.
+	DataKeep = "keep"
+	// DataDelete removes the subdirectory and everything in it.
+	DataDelete = "delete"
+	// DataArchive moves the subdirectory into ArchiveDir, named after the instance and the time it was deprovisioned.
+	DataArchive = "archive"
+)
+
+// validateDataOnDeprovision checks that data is DataKeep, DataDelete, DataArchive or empty.
+func validateDataOnDeprovision(data string) error {
+	switch data {
+	case "", DataKeep, DataDelete, DataArchive:
+		return nil
+	}
+	return fmt.Errorf("data on deprovision must be %q, %q or %q, not %q", DataKeep, DataDelete, DataArchive, data)
+}
+
+// Subdirectories provisions instances without a share each a new subdirectory of a base export, named after the
+// instance, so that users need not know of an existing share.  The broker creates and removes the subdirectories
+// through a mount of the base export on its own VM.
+type Subdirectories struct {
+	// Base is the export the subdirectories are created in, such as "server:/export".
+	Base string
+	// MountPath is where Base is mounted on the broker's VM.
+	MountPath string
+	// DataOnDeprovision is what happens to an instance's subdirectory when the instance is deprovisioned: DataKeep,
+	// DataDelete or DataArchive.  Plans can override it.
+	DataOnDeprovision string
+}
+
+// NewSubdirectories checks that base is a share with a server, mountPath is an absolute path and dataOnDeprovision
+// is DataKeep, DataDelete or DataArchive.  Empty dataOnDeprovision keeps subdirectories.
+func NewSubdirectories(base, mountPath, dataOnDeprovision string) (*Subdirectories, error) {
+	components, err := ParseShare(base)
+	if err != nil {
+		return nil, fmt.Errorf("base export %q: %w", base, err)
+	}
+	if !path.IsAbs(components.Path) {
+		return nil, fmt.Errorf("base export %q does not have an absolute path", base)
+	}
+	if !path.IsAbs(mountPath) || path.Clean(mountPath) == "/" {
+		return nil, fmt.Errorf("mount path %q of the base export is not an absolute path below /", mountPath)
+	}
+	if err := validateDataOnDeprovision(dataOnDeprovision); err != nil {
+		return nil, err
+	}
+	if dataOnDeprovision == "" {
+		dataOnDeprovision = DataKeep
+	}
+	return &Subdirectories{
+		Base:              strings.TrimSuffix(base, "/"),
+		MountPath:         path.Clean(mountPath),
+		DataOnDeprovision: dataOnDeprovision,
+	}, nil
+}
+
+// SetSubdirectories makes the share provision parameter optional, provisioning instances without one a subdirectory
+// of a base export.
+func (b *Broker) SetSubdirectories(subdirectories *Subdirectories) {
+	b.subdirectories = subdirectories
+}
+
+// subdirectoryShare returns the share of a new subdirectory for the instance.
+func (b *Broker) subdirectoryShare(instanceID string) (string, error) {
+	if instanceID == "" || strings.HasPrefix(instanceID, ".") || strings.ContainsAny(instanceID, "/\\:,?") {
+		err := fmt.Errorf("service instance ID %q cannot name a subdirectory; give a share instead", instanceID)
+		return "", brokerapi.NewFailureResponse(err, http.StatusBadRequest, "invalid-subdirectory")
+	}
+	return b.subdirectories.Base + "/" + instanceID, nil
+}
+
+// createSubdirectory creates the subdirectory of a new instance.  Subdirectories that exist already are kept, so
+// that provision requests can be retried.
+func (b *Broker) createSubdirectory(ctx context.Context, logger lager.Logger, details ServiceInstance) error {
+	if details.Subdirectory == "" || isProbe(ctx) {
+		return nil
+	}
+
+	localPath := path.Join(b.subdirectories.MountPath, details.Subdirectory)
+	if err := b.os.MkdirAll(localPath, subdirectoryPerm); err != nil {
+		logger.Error("failed-to-create-subdirectory", err, lager.Data{"path": localPath})
+		return fmt.Errorf("failed to create the share's directory: %w", err)
+	}
+	logger.Info("created-subdirectory", lager.Data{"path": localPath, "share": details.Share})
+	return nil
+}
+
+// dataOnDeprovision returns what happens to the instance's data when it is deprovisioned: the instance plan's
+// choice, or else the broker's.  The data of instances that the broker did not create a subdirectory for is kept.
+func (b *Broker) dataOnDeprovision(details ServiceInstance) string {
+	if details.Subdirectory == "" || b.subdirectories == nil {
+		return DataKeep
+	}
+	if plan, ok := b.plan(details.PlanID); ok && plan.DataOnDeprovision != "" {
+		return plan.DataOnDeprovision
+	}
+	return b.subdirectories.DataOnDeprovision
+}
+
+// disposesSubdirectory reports whether deprovisioning the instance deletes or archives its subdirectory, which can
+// take long enough for deprovisioning to be asynchronous.
+func (b *Broker) disposesSubdirectory(details ServiceInstance) bool {
+	return b.dataOnDeprovision(details) != DataKeep
+}
+
+// disposeSubdirectory deletes or archives the subdirectory of a deprovisioned instance, as dataOnDeprovision says,
+// reporting what it is doing to progress.
+func (b *Broker) disposeSubdirectory(ctx context.Context, logger lager.Logger, instanceID string, details ServiceInstance, progress func(string)) error {
+	if !b.disposesSubdirectory(details) || isProbe(ctx) {
+		return nil
+	}
+
+	localPath := path.Join(b.subdirectories.MountPath, details.Subdirectory)
+	switch b.dataOnDeprovision(details) {
+	case DataDelete:
+		progress(fmt.Sprintf("deleting the data in %s", details.Share))
+		if err := b.os.RemoveAll(localPath); err != nil {
+			logger.Error("failed-to-remove-subdirectory", err, lager.Data{"path": localPath})
+			return fmt.Errorf("failed to remove the share's directory: %w", err)
+		}
+		logger.Info("removed-subdirectory", lager.Data{"path": localPath})
+	case DataArchive:
+		archivePath := path.Join(b.subdirectories.MountPath, ArchiveDir)
+		archived := path.Join(archivePath, instanceID+"-"+b.clock.Now().UTC().Format("20060102T150405Z"))
+		progress(fmt.Sprintf("archiving the data in %s to %s", details.Share, path.Join(ArchiveDir, path.Base(archived))))
+		if err := b.os.MkdirAll(archivePath, archivePerm); err != nil {
+			logger.Error("failed-to-create-archive", err, lager.Data{"path": archivePath})
+			return fmt.Errorf("failed to create the archive directory: %w", err)
+		}
+		if err := b.os.Rename(localPath, archived); err != nil {
+			logger.Error("failed-to-archive-subdirectory", err, lager.Data{"path": localPath, "archive": archived})
+			return fmt.Errorf("failed to archive the share's directory: %w", err)
+		}
+		logger.Info("archived-subdirectory", lager.Data{"path": localPath, "archive": archived})
+	}
+	return nil
+}
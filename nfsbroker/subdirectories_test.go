@@ -0,0 +1,207 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Subdirectories", func() {
+	var (
+		broker *nfsbroker.Broker
+		store  nfsbroker.Store
+		fakeOs *os_fake.FakeOs
+		ctx    context.Context
+	)
+
+	provision := func(instanceID, parameters string) error {
+		_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+			ServiceID:     "service-id",
+			PlanID:        "Existing",
+			RawParameters: json.RawMessage(parameters),
+		}, false)
+		return err
+	}
+
+	newBroker := func(dataOnDeprovision string) {
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-subdirectories"), "service-name", "service-id", "/fake-dir",
+			fakeOs, fakeclock.NewFakeClock(time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)), store, nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		subdirectories, err := nfsbroker.NewSubdirectories("filer:/export/", "/var/vcap/data/export", dataOnDeprovision)
+		Expect(err).NotTo(HaveOccurred())
+		broker.SetSubdirectories(subdirectories)
+	}
+
+	BeforeEach(func() {
+		store = nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)
+		fakeOs = &os_fake.FakeOs{}
+		ctx = context.Background()
+		newBroker(nfsbroker.DataDelete)
+	})
+
+	Context("when an instance is provisioned without a share", func() {
+		BeforeEach(func() {
+			Expect(provision("instance-id", `{}`)).To(Succeed())
+		})
+
+		It("creates a directory for it in the base export", func() {
+			Expect(fakeOs.MkdirAllCallCount()).To(Equal(1))
+			localPath, perm := fakeOs.MkdirAllArgsForCall(0)
+			Expect(localPath).To(Equal("/var/vcap/data/export/instance-id"))
+			Expect(perm).To(Equal(os.FileMode(0777)))
+
+			details, err := store.RetrieveInstanceDetails(ctx, "instance-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(details.Share).To(Equal("filer:/export/instance-id"))
+			Expect(details.Subdirectory).To(Equal("instance-id"))
+		})
+
+		It("removes the directory when the instance is deprovisioned", func() {
+			_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeOs.RemoveAllCallCount()).To(Equal(1))
+			Expect(fakeOs.RemoveAllArgsForCall(0)).To(Equal("/var/vcap/data/export/instance-id"))
+		})
+
+		It("deprovisions asynchronously when the platform allows", func() {
+			spec, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.IsAsync).To(BeTrue())
+			Eventually(fakeOs.RemoveAllCallCount).Should(Equal(1))
+		})
+
+		It("keeps the instance when the directory cannot be removed", func() {
+			fakeOs.RemoveAllReturns(errors.New("permission denied"))
+			_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, false)
+			Expect(err).To(MatchError(ContainSubstring("failed to remove the share's directory")))
+
+			_, err = store.RetrieveInstanceDetails(ctx, "instance-id")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("reports what it is doing while deprovisioning asynchronously", func() {
+			removing := make(chan struct{})
+			fakeOs.RemoveAllStub = func(string) error {
+				<-removing
+				return nil
+			}
+			_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func() string {
+				operation, _ := broker.LastOperation(ctx, "instance-id", nfsbroker.DeprovisionOperation)
+				return operation.Description
+			}).Should(Equal("deleting the data in filer:/export/instance-id"))
+			close(removing)
+
+			// the deprovision removes the instance from the store in the background, under the broker's lock
+			Eventually(func() error {
+				_, err := broker.LastOperation(ctx, "instance-id", nfsbroker.DeprovisionOperation)
+				return err
+			}).Should(Equal(brokerapi.ErrInstanceDoesNotExist))
+		})
+
+		Context("and directories are to be archived", func() {
+			BeforeEach(func() {
+				newBroker(nfsbroker.DataArchive)
+			})
+
+			It("moves the directory into the archive", func() {
+				_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeOs.RemoveAllCallCount()).To(Equal(0))
+
+				Expect(fakeOs.MkdirAllCallCount()).To(Equal(2))
+				archivePath, perm := fakeOs.MkdirAllArgsForCall(1)
+				Expect(archivePath).To(Equal("/var/vcap/data/export/.archive"))
+				Expect(perm).To(Equal(os.FileMode(0700)))
+
+				Expect(fakeOs.RenameCallCount()).To(Equal(1))
+				from, to := fakeOs.RenameArgsForCall(0)
+				Expect(from).To(Equal("/var/vcap/data/export/instance-id"))
+				Expect(to).To(Equal("/var/vcap/data/export/.archive/instance-id-20261016T093000Z"))
+			})
+
+			It("keeps the instance when the directory cannot be archived", func() {
+				fakeOs.RenameReturns(errors.New("cross-device link"))
+				_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, false)
+				Expect(err).To(MatchError(ContainSubstring("failed to archive the share's directory")))
+
+				_, err = store.RetrieveInstanceDetails(ctx, "instance-id")
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("and the instance's plan keeps its data", func() {
+			BeforeEach(func() {
+				broker.SetPlans([]nfsbroker.Plan{{ID: "Existing", Name: "existing", DataOnDeprovision: nfsbroker.DataKeep}})
+			})
+
+			It("keeps the directory whatever the broker's default", func() {
+				spec, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spec.IsAsync).To(BeFalse())
+				Expect(fakeOs.RemoveAllCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("and directories are not to be removed", func() {
+			BeforeEach(func() {
+				newBroker(nfsbroker.DataKeep)
+			})
+
+			It("keeps the directory", func() {
+				_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeOs.RemoveAllCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	It("uses the share of instances provisioned with one", func() {
+		Expect(provision("instance-id", `{"share":"server:/some-share"}`)).To(Succeed())
+		Expect(fakeOs.MkdirAllCallCount()).To(Equal(0))
+
+		_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fakeOs.RemoveAllCallCount()).To(Equal(0))
+	})
+
+	It("refuses instances whose directory cannot be created", func() {
+		fakeOs.MkdirAllReturns(errors.New("read-only file system"))
+		Expect(provision("instance-id", `{}`)).To(MatchError(ContainSubstring("failed to create the share's directory")))
+
+		_, err := store.RetrieveInstanceDetails(ctx, "instance-id")
+		Expect(errors.Is(err, nfsbroker.ErrInstanceNotFound)).To(BeTrue())
+	})
+
+	It("refuses instance IDs that cannot name a directory", func() {
+		Expect(provision("..", `{}`)).To(MatchError(ContainSubstring("cannot name a subdirectory")))
+		Expect(provision(".archive", `{}`)).To(MatchError(ContainSubstring("cannot name a subdirectory")))
+		Expect(fakeOs.MkdirAllCallCount()).To(Equal(0))
+	})
+
+	It("documents the share as optional", func() {
+		share := broker.Parameters(ctx).Provision[0]
+		Expect(share.Required).To(BeFalse())
+		Expect(share.Description).To(HaveSuffix("a new directory of filer:/export named after the instance"))
+	})
+
+	It("rejects bases without a server and relative mount paths", func() {
+		_, err := nfsbroker.NewSubdirectories("/export", "/mnt/export", "")
+		Expect(err).To(HaveOccurred())
+		_, err = nfsbroker.NewSubdirectories("filer:/export", "mnt/export", "")
+		Expect(err).To(MatchError(ContainSubstring("is not an absolute path")))
+		_, err = nfsbroker.NewSubdirectories("filer:/export", "/mnt/export", "shred")
+		Expect(err).To(MatchError(ContainSubstring(`data on deprovision must be "keep", "delete" or "archive", not "shred"`)))
+	})
+})
@@ -0,0 +1,55 @@
+package nfsbroker
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const TracerName = "code.cloudfoundry.org/nfsbroker"
+
+// startSpan starts a span within the trace of the request carried by ctx, and returns the span's context along with a
+// function that ends the span, marking it failed with err if err is not nil.  Spans are recorded by the global tracer
+// provider, which records nothing unless the broker is configured to export traces.
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, func(err error)) {
+	ctx, span := otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// TracingHandler serves each request within a span named after the service broker API endpoint it is for, continuing
+// the trace of the W3C trace context headers the request carries, such as the Cloud Controller's.
+func TracingHandler(next http.Handler) http.Handler {
+	propagator := propagation.TraceContext{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		endpoint := BrokerAPIEndpoint(r.Method, r.URL.Path)
+		ctx, span := otel.Tracer(TracerName).Start(ctx, "broker-api "+endpoint,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.Path),
+				attribute.String("nfsbroker.endpoint", endpoint),
+			),
+		)
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.status_code", recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package nfsbroker_test
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Tracing", func() {
+	var (
+		recorder *tracetest.SpanRecorder
+		previous trace.TracerProvider
+	)
+
+	BeforeEach(func() {
+		recorder = tracetest.NewSpanRecorder()
+		previous = otel.GetTracerProvider()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	})
+
+	AfterEach(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	attributes := func(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		values := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes() {
+			values[kv.Key] = kv.Value
+		}
+		return values
+	}
+
+	Describe("TracingHandler", func() {
+		var (
+			handler http.Handler
+			status  int
+			mock    sqlmock.Sqlmock
+		)
+
+		BeforeEach(func() {
+			status = http.StatusCreated
+			var (
+				db  *sql.DB
+				err error
+			)
+			db, mock, err = sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			store := nfsbroker.SqlStore{Database: nfsbrokerfakes.FakeSQLMockConnection{db}, StoreType: "postgres"}
+			handler = nfsbroker.TracingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				store.CheckHealth(r.Context())
+				w.WriteHeader(status)
+			}))
+		})
+
+		serve := func(header http.Header) {
+			req := httptest.NewRequest("PUT", "/v2/service_instances/instance-id", nil)
+			for name, values := range header {
+				req.Header[name] = values
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+
+		It("serves each request in a span continuing the trace of the request's trace context", func() {
+			mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"one"}).AddRow(1))
+			serve(http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}})
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(2))
+			statement, request := spans[0], spans[1]
+
+			Expect(request.Name()).To(Equal("broker-api provision"))
+			Expect(request.SpanKind()).To(Equal(trace.SpanKindServer))
+			Expect(request.SpanContext().TraceID().String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+			Expect(request.Parent().SpanID().String()).To(Equal("00f067aa0ba902b7"))
+			Expect(attributes(request)).To(HaveKeyWithValue(attribute.Key("http.status_code"), attribute.IntValue(http.StatusCreated)))
+			Expect(request.Status().Code).To(Equal(codes.Unset))
+
+			Expect(statement.Name()).To(Equal("sql"))
+			Expect(statement.Parent().SpanID()).To(Equal(request.SpanContext().SpanID()))
+			Expect(attributes(statement)).To(HaveKeyWithValue(attribute.Key("db.statement"), attribute.StringValue("SELECT 1")))
+		})
+
+		It("marks failed requests and statements as errors", func() {
+			status = http.StatusInternalServerError
+			mock.ExpectQuery("SELECT 1").WillReturnError(errors.New("connection refused"))
+			serve(http.Header{})
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(2))
+			Expect(spans[0].Status().Code).To(Equal(codes.Error))
+			Expect(spans[0].Status().Description).To(ContainSubstring("connection refused"))
+			Expect(spans[1].Status().Code).To(Equal(codes.Error))
+			Expect(spans[1].Parent().IsValid()).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,88 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Unknown instances and bindings", func() {
+	var (
+		broker *nfsbroker.Broker
+		store  nfsbroker.Store
+		ctx    context.Context
+	)
+
+	statusCode := func(err error) int {
+		Expect(err).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
+		return err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)
+	}
+
+	BeforeEach(func() {
+		store = nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-unknown-ids"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, store, nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		ctx = context.Background()
+
+		for _, instanceID := range []string{"instance-id", "other-instance-id"} {
+			_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        "Existing",
+				RawParameters: json.RawMessage(`{"share":"server:/some-share"}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err := broker.Bind(ctx, "other-instance-id", "other-binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("answers deprovisions of unknown instances with 410 Gone", func() {
+		_, err := broker.Deprovision(ctx, "missing-instance-id", brokerapi.DeprovisionDetails{}, false)
+		Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+		Expect(statusCode(err)).To(Equal(http.StatusGone))
+	})
+
+	It("answers unbinds of unknown bindings with 410 Gone", func() {
+		err := broker.Unbind(ctx, "instance-id", "missing-binding-id", brokerapi.UnbindDetails{})
+		Expect(err).To(Equal(brokerapi.ErrBindingDoesNotExist))
+		Expect(statusCode(err)).To(Equal(http.StatusGone))
+	})
+
+	It("treats bindings of other instances as unknown", func() {
+		err := broker.Unbind(ctx, "instance-id", "other-binding-id", brokerapi.UnbindDetails{})
+		Expect(err).To(Equal(brokerapi.ErrBindingDoesNotExist))
+
+		_, err = store.RetrieveBindingDetails(ctx, "other-binding-id")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("answers a repeated deprovision with 410 Gone", func() {
+		_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, false)
+		Expect(statusCode(err)).To(Equal(http.StatusGone))
+	})
+
+	Context("when the broker keeps the legacy behavior", func() {
+		BeforeEach(func() {
+			broker.SetLegacyNotFound(true)
+		})
+
+		It("answers with 404 Not Found", func() {
+			_, err := broker.Deprovision(ctx, "missing-instance-id", brokerapi.DeprovisionDetails{}, false)
+			Expect(statusCode(err)).To(Equal(http.StatusNotFound))
+
+			err = broker.Unbind(ctx, "instance-id", "missing-binding-id", brokerapi.UnbindDetails{})
+			Expect(statusCode(err)).To(Equal(http.StatusNotFound))
+
+			err = broker.Unbind(ctx, "missing-instance-id", "other-binding-id", brokerapi.UnbindDetails{})
+			Expect(statusCode(err)).To(Equal(http.StatusNotFound))
+		})
+	})
+})
@@ -0,0 +1,93 @@
+package nfsbroker
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// VolumeMountDefaults are the parts of binding volume mounts that bind parameters may leave out: the directory
+// under which shares are mounted at <container_dir>/<instance_id>, and the mode of bindings that do not say whether
+// they are read-only.
+type VolumeMountDefaults struct {
+	ContainerDir string
+	Mode         string
+}
+
+// DefaultVolumeMountDefaults mount shares read-write under DefaultContainerPath.
+var DefaultVolumeMountDefaults = VolumeMountDefaults{ContainerDir: DefaultContainerPath, Mode: "rw"}
+
+// NewVolumeMountDefaults checks that containerDir is an absolute path and mode is "r" or "rw".
+func NewVolumeMountDefaults(containerDir, mode string) (VolumeMountDefaults, error) {
+	if !path.IsAbs(containerDir) || path.Clean(containerDir) == "/" {
+		return VolumeMountDefaults{}, fmt.Errorf("container directory %q is not an absolute path to a directory other than /", containerDir)
+	}
+	if mode != "r" && mode != "rw" {
+		return VolumeMountDefaults{}, fmt.Errorf("mode %q is neither \"r\" nor \"rw\"", mode)
+	}
+	return VolumeMountDefaults{ContainerDir: path.Clean(containerDir), Mode: mode}, nil
+}
+
+// SetVolumeMountDefaults configures the container directory and mode of bindings that do not choose their own, in
+// place of DefaultVolumeMountDefaults.
+func (b *Broker) SetVolumeMountDefaults(defaults VolumeMountDefaults) {
+	b.volumeMountDefaults = defaults
+}
+
+// ValidateVolumeMount checks a binding volume mount against what the registered share type for its driver accepts,
+// and describes each problem found.  It returns nothing for mounts the driver should be able to use.
+func ValidateVolumeMount(mount brokerapi.VolumeMount) []string {
+	problems := []string{}
+
+	var shareType ShareType
+	for _, t := range shareTypes {
+		if t.Driver == mount.Driver {
+			shareType = t
+			break
+		}
+	}
+	if shareType.Driver == "" {
+		problems = append(problems, fmt.Sprintf("driver %q is not the driver of any known share type", mount.Driver))
+	}
+
+	if !path.IsAbs(mount.ContainerDir) {
+		problems = append(problems, fmt.Sprintf("container_dir %q is not an absolute path", mount.ContainerDir))
+	}
+	if mount.Mode != "r" && mount.Mode != "rw" {
+		problems = append(problems, fmt.Sprintf("mode %q is neither \"r\" nor \"rw\"", mount.Mode))
+	}
+	if mount.DeviceType != "shared" {
+		problems = append(problems, fmt.Sprintf("device_type %q is not \"shared\"", mount.DeviceType))
+	}
+	if mount.Device.VolumeId == "" {
+		problems = append(problems, "device has no volume_id")
+	}
+
+	source, ok := mount.Device.MountConfig["source"].(string)
+	if !ok || source == "" {
+		problems = append(problems, "mount_config has no source")
+	} else if shareType.Scheme != "" && !strings.HasPrefix(source, shareType.Scheme) {
+		problems = append(problems, fmt.Sprintf("mount_config source %q does not start with %q", source, shareType.Scheme))
+	}
+
+	if shareType.Driver != "" {
+		known := map[string]bool{"source": true}
+		for _, option := range shareType.MountOptions {
+			known[option] = true
+		}
+		unknown := []string{}
+		for key := range mount.Device.MountConfig {
+			if !known[key] {
+				unknown = append(unknown, key)
+			}
+		}
+		sort.Strings(unknown)
+		for _, key := range unknown {
+			problems = append(problems, fmt.Sprintf("mount_config option %q is not understood by %s", key, mount.Driver))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,185 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("ValidateVolumeMount", func() {
+	var mount brokerapi.VolumeMount
+
+	BeforeEach(func() {
+		mount = brokerapi.VolumeMount{
+			Driver:       "nfsv3driver",
+			ContainerDir: "/var/vcap/data/instance-id",
+			Mode:         "rw",
+			DeviceType:   "shared",
+			Device: brokerapi.SharedDevice{
+				VolumeId:    "instance-id-hash",
+				MountConfig: map[string]interface{}{"source": "nfs://server/export", "uid": "1000", "readonly": true},
+			},
+		}
+	})
+
+	It("accepts mounts the driver understands", func() {
+		Expect(nfsbroker.ValidateVolumeMount(mount)).To(BeEmpty())
+	})
+
+	It("accepts mounts for other share types", func() {
+		mount.Driver = "cephdriver"
+		mount.Device.MountConfig = map[string]interface{}{"source": "cephfs://mon:/path", "keyring": "secret"}
+		Expect(nfsbroker.ValidateVolumeMount(mount)).To(BeEmpty())
+	})
+
+	It("reports unknown drivers", func() {
+		mount.Driver = "afsdriver"
+		Expect(nfsbroker.ValidateVolumeMount(mount)).To(ConsistOf(`driver "afsdriver" is not the driver of any known share type`))
+	})
+
+	It("reports malformed mounts", func() {
+		mount.ContainerDir = "relative/dir"
+		mount.Mode = "w"
+		mount.DeviceType = "local"
+		mount.Device.VolumeId = ""
+		Expect(nfsbroker.ValidateVolumeMount(mount)).To(ConsistOf(
+			`container_dir "relative/dir" is not an absolute path`,
+			`mode "w" is neither "r" nor "rw"`,
+			`device_type "local" is not "shared"`,
+			"device has no volume_id",
+		))
+	})
+
+	It("reports sources the driver cannot mount", func() {
+		mount.Device.MountConfig["source"] = "cephfs://mon:/path"
+		Expect(nfsbroker.ValidateVolumeMount(mount)).To(ConsistOf(`mount_config source "cephfs://mon:/path" does not start with "nfs://"`))
+
+		delete(mount.Device.MountConfig, "source")
+		Expect(nfsbroker.ValidateVolumeMount(mount)).To(ConsistOf("mount_config has no source"))
+	})
+
+	It("reports options the driver does not understand", func() {
+		mount.Device.MountConfig["mount"] = "/data"
+		mount.Device.MountConfig["kerberosPrincipal"] = "user"
+		Expect(nfsbroker.ValidateVolumeMount(mount)).To(Equal([]string{
+			`mount_config option "kerberosPrincipal" is not understood by nfsv3driver`,
+			`mount_config option "mount" is not understood by nfsv3driver`,
+		}))
+	})
+})
+
+var _ = Describe("Volume mount defaults", func() {
+	var (
+		broker *nfsbroker.Broker
+		ctx    context.Context
+	)
+
+	bind := func(parameters map[string]interface{}) brokerapi.VolumeMount {
+		binding, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid", Parameters: parameters})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(binding.VolumeMounts).To(HaveLen(1))
+		return binding.VolumeMounts[0]
+	}
+
+	BeforeEach(func() {
+		store := nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize)
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-volume-mount-defaults"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, store, nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		ctx = context.Background()
+
+		_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+			PlanID:        "Existing",
+			RawParameters: json.RawMessage(`{"share":"server:/some-share"}`),
+		}, false)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("mounts shares read-write under /var/vcap/data by default", func() {
+		mount := bind(nil)
+		Expect(mount.ContainerDir).To(Equal("/var/vcap/data/instance-id"))
+		Expect(mount.Device.MountConfig).NotTo(HaveKey("readonly"))
+		Expect(mount.Mode).To(Equal("rw"))
+	})
+
+	It("mounts bindings with mode r when readonly is set, and records it on the binding", func() {
+		mount := bind(map[string]interface{}{"readonly": true})
+		Expect(mount.Mode).To(Equal("r"))
+		Expect(mount.Device.MountConfig).To(HaveKeyWithValue("readonly", true))
+
+		spec, err := broker.GetBinding(ctx, "instance-id", "binding-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.Parameters).To(HaveKeyWithValue("readonly", true))
+		Expect(spec.VolumeMounts[0].Mode).To(Equal("r"))
+	})
+
+	It("treats an identical bind on a read-only plan as a retry", func() {
+		broker.SetPlans([]nfsbroker.Plan{{ID: "read-only-id", Name: "read-only", ReadOnly: true}})
+		_, err := broker.Provision(ctx, "read-only-instance-id", brokerapi.ProvisionDetails{
+			PlanID:        "read-only-id",
+			RawParameters: json.RawMessage(`{"share":"server:/some-share"}`),
+		}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		details := brokerapi.BindDetails{AppGUID: "app-guid", PlanID: "read-only-id", Parameters: map[string]interface{}{"mount": "/data"}}
+		binding, err := broker.Bind(ctx, "read-only-instance-id", "binding-id", details)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(binding.VolumeMounts[0].Mode).To(Equal("r"))
+
+		binding, err = broker.Bind(ctx, "read-only-instance-id", "binding-id", details)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(binding.VolumeMounts[0].Mode).To(Equal("r"))
+	})
+
+	Context("when they are configured", func() {
+		BeforeEach(func() {
+			defaults, err := nfsbroker.NewVolumeMountDefaults("/mnt/shares/", "r")
+			Expect(err).NotTo(HaveOccurred())
+			broker.SetVolumeMountDefaults(defaults)
+		})
+
+		It("apply to bindings that do not choose their own", func() {
+			mount := bind(nil)
+			Expect(mount.ContainerDir).To(Equal("/mnt/shares/instance-id"))
+			Expect(mount.Mode).To(Equal("r"))
+			Expect(mount.Device.MountConfig).To(HaveKeyWithValue("readonly", true))
+
+			broker.SetVolumeMountDefaults(nfsbroker.DefaultVolumeMountDefaults)
+			spec, err := broker.GetBinding(ctx, "instance-id", "binding-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.VolumeMounts[0].Mode).To(Equal("r"))
+		})
+
+		It("treat an identical bind as a retry", func() {
+			Expect(bind(nil).Mode).To(Equal("r"))
+			Expect(bind(nil).Mode).To(Equal("r"))
+		})
+
+		It("give way to bind parameters", func() {
+			mount := bind(map[string]interface{}{"mount": "/data", "readonly": false})
+			Expect(mount.ContainerDir).To(Equal("/data"))
+			Expect(mount.Device.MountConfig).NotTo(HaveKey("readonly"))
+		})
+
+		It("are documented", func() {
+			doc := broker.Parameters(ctx)
+			Expect(doc.Bind[0].Description).To(HaveSuffix("by default /mnt/shares/<instance_id>"))
+			Expect(doc.Bind[2].Name).To(Equal("readonly"))
+			Expect(doc.Bind[2].Default).To(Equal(true))
+		})
+	})
+
+	It("rejects relative container directories and unknown modes", func() {
+		_, err := nfsbroker.NewVolumeMountDefaults("mnt", "rw")
+		Expect(err).To(MatchError(ContainSubstring(`container directory "mnt" is not an absolute path`)))
+		_, err = nfsbroker.NewVolumeMountDefaults("/", "rw")
+		Expect(err).To(HaveOccurred())
+		_, err = nfsbroker.NewVolumeMountDefaults("/mnt", "ro")
+		Expect(err).To(MatchError(`mode "ro" is neither "r" nor "rw"`))
+	})
+})
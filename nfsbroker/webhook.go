@@ -0,0 +1,40 @@
+package nfsbroker
+
+import "code.cloudfoundry.org/lager"
+
+//go:generate counterfeiter -o ../nfsbrokerfakes/fake_webhook.go . Webhook
+type Webhook interface {
+	// Send delivers an event, or queues it for delivery, without waiting for the endpoint.
+	Send(event interface{})
+}
+
+// OperationEvent is what a webhook is sent when a provision, update, bind, unbind or deprovision has finished.
+type OperationEvent struct {
+	Operation        string `json:"operation"`
+	InstanceID       string `json:"instance_id"`
+	BindingID        string `json:"binding_id,omitempty"`
+	OrganizationGUID string `json:"organization_guid,omitempty"`
+	SpaceGUID        string `json:"space_guid,omitempty"`
+	Result           string `json:"result"`
+	Error            string `json:"error,omitempty"`
+}
+
+// SetWebhook sends webhook an OperationEvent for every operation once it has finished.  The broker's own SLO probes
+// are not sent.
+func (b *Broker) SetWebhook(webhook Webhook) {
+	b.webhook = webhook
+}
+
+// notifyWebhook sends the webhook, if there is one, the event of a finished operation as recorded for the audit log.
+func (b *Broker) notifyWebhook(data lager.Data, result, message string) {
+	if b.webhook == nil || data["probe"] == true {
+		return
+	}
+	event := OperationEvent{Result: result, Error: message}
+	event.Operation, _ = data["operation"].(string)
+	event.InstanceID, _ = data["instanceID"].(string)
+	event.BindingID, _ = data["bindingID"].(string)
+	event.OrganizationGUID, _ = data["organizationGUID"].(string)
+	event.SpaceGUID, _ = data["spaceGUID"].(string)
+	b.webhook.Send(event)
+}
@@ -0,0 +1,97 @@
+package nfsbroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/nfsbrokerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("Webhook", func() {
+	var (
+		webhook *nfsbrokerfakes.FakeWebhook
+		broker  *nfsbroker.Broker
+		ctx     context.Context
+	)
+
+	provision := func(instanceID string) error {
+		_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+			ServiceID:        "service-id",
+			PlanID:           "Existing",
+			OrganizationGUID: "org-guid",
+			SpaceGUID:        "space-guid",
+			RawParameters:    json.RawMessage(`{"share":"server:/export"}`),
+		}, true)
+		return err
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		webhook = &nfsbrokerfakes.FakeWebhook{}
+		broker = nfsbroker.New(lagertest.NewTestLogger("test-webhook"), "service-name", "service-id", "/fake-dir",
+			&os_fake.FakeOs{}, nil, nfsbroker.NewMemoryStore(nfsbroker.DefaultMaxValueSize), nfsbroker.NewNfsBrokerConfig(nfsbroker.NewNfsBrokerConfigDetails()))
+		broker.SetWebhook(webhook)
+	})
+
+	It("sends an event for each finished operation", func() {
+		Expect(provision("instance-id")).To(Succeed())
+		_, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(webhook.SendCallCount()).To(Equal(2))
+		Expect(webhook.SendArgsForCall(0)).To(Equal(nfsbroker.OperationEvent{
+			Operation:        "provision",
+			InstanceID:       "instance-id",
+			OrganizationGUID: "org-guid",
+			SpaceGUID:        "space-guid",
+			Result:           nfsbroker.AuditResultSucceeded,
+		}))
+		Expect(webhook.SendArgsForCall(1)).To(Equal(nfsbroker.OperationEvent{
+			Operation:        "bind",
+			InstanceID:       "instance-id",
+			BindingID:        "binding-id",
+			OrganizationGUID: "org-guid",
+			SpaceGUID:        "space-guid",
+			Result:           nfsbroker.AuditResultSucceeded,
+		}))
+	})
+
+	It("sends failed operations with their error", func() {
+		_, err := broker.Bind(ctx, "missing-instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})
+		Expect(err).To(HaveOccurred())
+
+		Expect(webhook.SendCallCount()).To(Equal(1))
+		event := webhook.SendArgsForCall(0).(nfsbroker.OperationEvent)
+		Expect(event.Result).To(Equal(nfsbroker.AuditResultFailed))
+		Expect(event.Error).To(Equal(err.Error()))
+	})
+
+	Context("when operations are asynchronous", func() {
+		var steps chan error
+
+		BeforeEach(func() {
+			steps = make(chan error)
+			broker.SetProvisionSteps(func(context.Context, string, nfsbroker.ServiceInstance) error {
+				return <-steps
+			})
+		})
+
+		It("sends them once they have finished", func() {
+			Expect(provision("instance-id")).To(Succeed())
+			Expect(webhook.SendCallCount()).To(BeZero())
+
+			steps <- errors.New("share-not-created")
+			Eventually(webhook.SendCallCount).Should(Equal(1))
+			event := webhook.SendArgsForCall(0).(nfsbroker.OperationEvent)
+			Expect(event.Result).To(Equal(nfsbroker.AuditResultFailed))
+			Expect(event.Error).To(Equal("share-not-created"))
+		})
+	})
+})
@@ -0,0 +1,58 @@
+// This file was generated by counterfeiter
+package nfsbrokerfakes
+
+import (
+	"context"
+	"sync"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+)
+
+type FakeBindingInstanceLookup struct {
+	BindingInstanceStub        func(ctx context.Context, bindingID string) (string, error)
+	bindingInstanceMutex       sync.RWMutex
+	bindingInstanceArgsForCall []struct {
+		ctx       context.Context
+		bindingID string
+	}
+	bindingInstanceReturns struct {
+		result1 string
+		result2 error
+	}
+}
+
+func (fake *FakeBindingInstanceLookup) BindingInstance(ctx context.Context, bindingID string) (string, error) {
+	fake.bindingInstanceMutex.Lock()
+	fake.bindingInstanceArgsForCall = append(fake.bindingInstanceArgsForCall, struct {
+		ctx       context.Context
+		bindingID string
+	}{ctx, bindingID})
+	fake.bindingInstanceMutex.Unlock()
+	if fake.BindingInstanceStub != nil {
+		return fake.BindingInstanceStub(ctx, bindingID)
+	} else {
+		return fake.bindingInstanceReturns.result1, fake.bindingInstanceReturns.result2
+	}
+}
+
+func (fake *FakeBindingInstanceLookup) BindingInstanceCallCount() int {
+	fake.bindingInstanceMutex.RLock()
+	defer fake.bindingInstanceMutex.RUnlock()
+	return len(fake.bindingInstanceArgsForCall)
+}
+
+func (fake *FakeBindingInstanceLookup) BindingInstanceArgsForCall(i int) (context.Context, string) {
+	fake.bindingInstanceMutex.RLock()
+	defer fake.bindingInstanceMutex.RUnlock()
+	return fake.bindingInstanceArgsForCall[i].ctx, fake.bindingInstanceArgsForCall[i].bindingID
+}
+
+func (fake *FakeBindingInstanceLookup) BindingInstanceReturns(result1 string, result2 error) {
+	fake.BindingInstanceStub = nil
+	fake.bindingInstanceReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+var _ nfsbroker.BindingInstanceLookup = new(FakeBindingInstanceLookup)
@@ -0,0 +1,58 @@
+// This file was generated by counterfeiter
+package nfsbrokerfakes
+
+import (
+	"context"
+	"sync"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+)
+
+type FakeEgressPolicyCreator struct {
+	AllowEgressStub        func(ctx context.Context, appGUID string, rules []nfsbroker.NetworkRule) error
+	allowEgressMutex       sync.RWMutex
+	allowEgressArgsForCall []struct {
+		ctx     context.Context
+		appGUID string
+		rules   []nfsbroker.NetworkRule
+	}
+	allowEgressReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeEgressPolicyCreator) AllowEgress(ctx context.Context, appGUID string, rules []nfsbroker.NetworkRule) error {
+	fake.allowEgressMutex.Lock()
+	fake.allowEgressArgsForCall = append(fake.allowEgressArgsForCall, struct {
+		ctx     context.Context
+		appGUID string
+		rules   []nfsbroker.NetworkRule
+	}{ctx, appGUID, rules})
+	fake.allowEgressMutex.Unlock()
+	if fake.AllowEgressStub != nil {
+		return fake.AllowEgressStub(ctx, appGUID, rules)
+	} else {
+		return fake.allowEgressReturns.result1
+	}
+}
+
+func (fake *FakeEgressPolicyCreator) AllowEgressCallCount() int {
+	fake.allowEgressMutex.RLock()
+	defer fake.allowEgressMutex.RUnlock()
+	return len(fake.allowEgressArgsForCall)
+}
+
+func (fake *FakeEgressPolicyCreator) AllowEgressArgsForCall(i int) (context.Context, string, []nfsbroker.NetworkRule) {
+	fake.allowEgressMutex.RLock()
+	defer fake.allowEgressMutex.RUnlock()
+	return fake.allowEgressArgsForCall[i].ctx, fake.allowEgressArgsForCall[i].appGUID, fake.allowEgressArgsForCall[i].rules
+}
+
+func (fake *FakeEgressPolicyCreator) AllowEgressReturns(result1 error) {
+	fake.AllowEgressStub = nil
+	fake.allowEgressReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ nfsbroker.EgressPolicyCreator = new(FakeEgressPolicyCreator)
@@ -0,0 +1,62 @@
+// This file was generated by counterfeiter
+package nfsbrokerfakes
+
+import (
+	"context"
+	"sync"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+)
+
+type FakeEntitlementChecker struct {
+	EntitledStub        func(ctx context.Context, orgGUID string, server string, path string) (bool, error)
+	entitledMutex       sync.RWMutex
+	entitledArgsForCall []struct {
+		ctx     context.Context
+		orgGUID string
+		server  string
+		path    string
+	}
+	entitledReturns struct {
+		result1 bool
+		result2 error
+	}
+}
+
+func (fake *FakeEntitlementChecker) Entitled(ctx context.Context, orgGUID string, server string, path string) (bool, error) {
+	fake.entitledMutex.Lock()
+	fake.entitledArgsForCall = append(fake.entitledArgsForCall, struct {
+		ctx     context.Context
+		orgGUID string
+		server  string
+		path    string
+	}{ctx, orgGUID, server, path})
+	fake.entitledMutex.Unlock()
+	if fake.EntitledStub != nil {
+		return fake.EntitledStub(ctx, orgGUID, server, path)
+	} else {
+		return fake.entitledReturns.result1, fake.entitledReturns.result2
+	}
+}
+
+func (fake *FakeEntitlementChecker) EntitledCallCount() int {
+	fake.entitledMutex.RLock()
+	defer fake.entitledMutex.RUnlock()
+	return len(fake.entitledArgsForCall)
+}
+
+func (fake *FakeEntitlementChecker) EntitledArgsForCall(i int) (context.Context, string, string, string) {
+	fake.entitledMutex.RLock()
+	defer fake.entitledMutex.RUnlock()
+	return fake.entitledArgsForCall[i].ctx, fake.entitledArgsForCall[i].orgGUID, fake.entitledArgsForCall[i].server, fake.entitledArgsForCall[i].path
+}
+
+func (fake *FakeEntitlementChecker) EntitledReturns(result1 bool, result2 error) {
+	fake.EntitledStub = nil
+	fake.entitledReturns = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+var _ nfsbroker.EntitlementChecker = new(FakeEntitlementChecker)
@@ -0,0 +1,58 @@
+// This file was generated by counterfeiter
+package nfsbrokerfakes
+
+import (
+	"context"
+	"sync"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+)
+
+type FakeHostResolver struct {
+	LookupHostStub        func(ctx context.Context, host string) ([]string, error)
+	lookupHostMutex       sync.RWMutex
+	lookupHostArgsForCall []struct {
+		ctx  context.Context
+		host string
+	}
+	lookupHostReturns struct {
+		result1 []string
+		result2 error
+	}
+}
+
+func (fake *FakeHostResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	fake.lookupHostMutex.Lock()
+	fake.lookupHostArgsForCall = append(fake.lookupHostArgsForCall, struct {
+		ctx  context.Context
+		host string
+	}{ctx, host})
+	fake.lookupHostMutex.Unlock()
+	if fake.LookupHostStub != nil {
+		return fake.LookupHostStub(ctx, host)
+	} else {
+		return fake.lookupHostReturns.result1, fake.lookupHostReturns.result2
+	}
+}
+
+func (fake *FakeHostResolver) LookupHostCallCount() int {
+	fake.lookupHostMutex.RLock()
+	defer fake.lookupHostMutex.RUnlock()
+	return len(fake.lookupHostArgsForCall)
+}
+
+func (fake *FakeHostResolver) LookupHostArgsForCall(i int) (context.Context, string) {
+	fake.lookupHostMutex.RLock()
+	defer fake.lookupHostMutex.RUnlock()
+	return fake.lookupHostArgsForCall[i].ctx, fake.lookupHostArgsForCall[i].host
+}
+
+func (fake *FakeHostResolver) LookupHostReturns(result1 []string, result2 error) {
+	fake.LookupHostStub = nil
+	fake.lookupHostReturns = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
+var _ nfsbroker.HostResolver = new(FakeHostResolver)
@@ -0,0 +1,62 @@
+// This file was generated by counterfeiter
+package nfsbrokerfakes
+
+import (
+	"context"
+	"sync"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+)
+
+type FakeIDResolver struct {
+	ResolveStub        func(ctx context.Context, username string, password string) (string, string, error)
+	resolveMutex       sync.RWMutex
+	resolveArgsForCall []struct {
+		ctx      context.Context
+		username string
+		password string
+	}
+	resolveReturns struct {
+		result1 string
+		result2 string
+		result3 error
+	}
+}
+
+func (fake *FakeIDResolver) Resolve(ctx context.Context, username string, password string) (string, string, error) {
+	fake.resolveMutex.Lock()
+	fake.resolveArgsForCall = append(fake.resolveArgsForCall, struct {
+		ctx      context.Context
+		username string
+		password string
+	}{ctx, username, password})
+	fake.resolveMutex.Unlock()
+	if fake.ResolveStub != nil {
+		return fake.ResolveStub(ctx, username, password)
+	} else {
+		return fake.resolveReturns.result1, fake.resolveReturns.result2, fake.resolveReturns.result3
+	}
+}
+
+func (fake *FakeIDResolver) ResolveCallCount() int {
+	fake.resolveMutex.RLock()
+	defer fake.resolveMutex.RUnlock()
+	return len(fake.resolveArgsForCall)
+}
+
+func (fake *FakeIDResolver) ResolveArgsForCall(i int) (context.Context, string, string) {
+	fake.resolveMutex.RLock()
+	defer fake.resolveMutex.RUnlock()
+	return fake.resolveArgsForCall[i].ctx, fake.resolveArgsForCall[i].username, fake.resolveArgsForCall[i].password
+}
+
+func (fake *FakeIDResolver) ResolveReturns(result1 string, result2 string, result3 error) {
+	fake.ResolveStub = nil
+	fake.resolveReturns = struct {
+		result1 string
+		result2 string
+		result3 error
+	}{result1, result2, result3}
+}
+
+var _ nfsbroker.IDResolver = new(FakeIDResolver)
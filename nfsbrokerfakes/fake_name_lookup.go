@@ -0,0 +1,102 @@
+// This file was generated by counterfeiter
+package nfsbrokerfakes
+
+import (
+	"context"
+	"sync"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+)
+
+type FakeNameLookup struct {
+	OrganizationNameStub        func(ctx context.Context, guid string) (string, error)
+	organizationNameMutex       sync.RWMutex
+	organizationNameArgsForCall []struct {
+		ctx  context.Context
+		guid string
+	}
+	organizationNameReturns struct {
+		result1 string
+		result2 error
+	}
+	SpaceNameStub        func(ctx context.Context, guid string) (string, error)
+	spaceNameMutex       sync.RWMutex
+	spaceNameArgsForCall []struct {
+		ctx  context.Context
+		guid string
+	}
+	spaceNameReturns struct {
+		result1 string
+		result2 error
+	}
+}
+
+func (fake *FakeNameLookup) OrganizationName(ctx context.Context, guid string) (string, error) {
+	fake.organizationNameMutex.Lock()
+	fake.organizationNameArgsForCall = append(fake.organizationNameArgsForCall, struct {
+		ctx  context.Context
+		guid string
+	}{ctx, guid})
+	fake.organizationNameMutex.Unlock()
+	if fake.OrganizationNameStub != nil {
+		return fake.OrganizationNameStub(ctx, guid)
+	} else {
+		return fake.organizationNameReturns.result1, fake.organizationNameReturns.result2
+	}
+}
+
+func (fake *FakeNameLookup) OrganizationNameCallCount() int {
+	fake.organizationNameMutex.RLock()
+	defer fake.organizationNameMutex.RUnlock()
+	return len(fake.organizationNameArgsForCall)
+}
+
+func (fake *FakeNameLookup) OrganizationNameArgsForCall(i int) (context.Context, string) {
+	fake.organizationNameMutex.RLock()
+	defer fake.organizationNameMutex.RUnlock()
+	return fake.organizationNameArgsForCall[i].ctx, fake.organizationNameArgsForCall[i].guid
+}
+
+func (fake *FakeNameLookup) OrganizationNameReturns(result1 string, result2 error) {
+	fake.OrganizationNameStub = nil
+	fake.organizationNameReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeNameLookup) SpaceName(ctx context.Context, guid string) (string, error) {
+	fake.spaceNameMutex.Lock()
+	fake.spaceNameArgsForCall = append(fake.spaceNameArgsForCall, struct {
+		ctx  context.Context
+		guid string
+	}{ctx, guid})
+	fake.spaceNameMutex.Unlock()
+	if fake.SpaceNameStub != nil {
+		return fake.SpaceNameStub(ctx, guid)
+	} else {
+		return fake.spaceNameReturns.result1, fake.spaceNameReturns.result2
+	}
+}
+
+func (fake *FakeNameLookup) SpaceNameCallCount() int {
+	fake.spaceNameMutex.RLock()
+	defer fake.spaceNameMutex.RUnlock()
+	return len(fake.spaceNameArgsForCall)
+}
+
+func (fake *FakeNameLookup) SpaceNameArgsForCall(i int) (context.Context, string) {
+	fake.spaceNameMutex.RLock()
+	defer fake.spaceNameMutex.RUnlock()
+	return fake.spaceNameArgsForCall[i].ctx, fake.spaceNameArgsForCall[i].guid
+}
+
+func (fake *FakeNameLookup) SpaceNameReturns(result1 string, result2 error) {
+	fake.SpaceNameStub = nil
+	fake.spaceNameReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+var _ nfsbroker.NameLookup = new(FakeNameLookup)
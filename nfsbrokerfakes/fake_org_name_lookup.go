@@ -0,0 +1,58 @@
+// This file was generated by counterfeiter
+package nfsbrokerfakes
+
+import (
+	"context"
+	"sync"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+)
+
+type FakeOrgNameLookup struct {
+	OrganizationNameStub        func(ctx context.Context, guid string) (string, error)
+	organizationNameMutex       sync.RWMutex
+	organizationNameArgsForCall []struct {
+		ctx  context.Context
+		guid string
+	}
+	organizationNameReturns struct {
+		result1 string
+		result2 error
+	}
+}
+
+func (fake *FakeOrgNameLookup) OrganizationName(ctx context.Context, guid string) (string, error) {
+	fake.organizationNameMutex.Lock()
+	fake.organizationNameArgsForCall = append(fake.organizationNameArgsForCall, struct {
+		ctx  context.Context
+		guid string
+	}{ctx, guid})
+	fake.organizationNameMutex.Unlock()
+	if fake.OrganizationNameStub != nil {
+		return fake.OrganizationNameStub(ctx, guid)
+	} else {
+		return fake.organizationNameReturns.result1, fake.organizationNameReturns.result2
+	}
+}
+
+func (fake *FakeOrgNameLookup) OrganizationNameCallCount() int {
+	fake.organizationNameMutex.RLock()
+	defer fake.organizationNameMutex.RUnlock()
+	return len(fake.organizationNameArgsForCall)
+}
+
+func (fake *FakeOrgNameLookup) OrganizationNameArgsForCall(i int) (context.Context, string) {
+	fake.organizationNameMutex.RLock()
+	defer fake.organizationNameMutex.RUnlock()
+	return fake.organizationNameArgsForCall[i].ctx, fake.organizationNameArgsForCall[i].guid
+}
+
+func (fake *FakeOrgNameLookup) OrganizationNameReturns(result1 string, result2 error) {
+	fake.OrganizationNameStub = nil
+	fake.organizationNameReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+var _ nfsbroker.OrgNameLookup = new(FakeOrgNameLookup)
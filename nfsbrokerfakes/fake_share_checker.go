@@ -0,0 +1,58 @@
+// This file was generated by counterfeiter
+package nfsbrokerfakes
+
+import (
+	"context"
+	"sync"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+)
+
+type FakeShareChecker struct {
+	CheckShareStub        func(ctx context.Context, server string, path string) error
+	checkShareMutex       sync.RWMutex
+	checkShareArgsForCall []struct {
+		ctx    context.Context
+		server string
+		path   string
+	}
+	checkShareReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeShareChecker) CheckShare(ctx context.Context, server string, path string) error {
+	fake.checkShareMutex.Lock()
+	fake.checkShareArgsForCall = append(fake.checkShareArgsForCall, struct {
+		ctx    context.Context
+		server string
+		path   string
+	}{ctx, server, path})
+	fake.checkShareMutex.Unlock()
+	if fake.CheckShareStub != nil {
+		return fake.CheckShareStub(ctx, server, path)
+	} else {
+		return fake.checkShareReturns.result1
+	}
+}
+
+func (fake *FakeShareChecker) CheckShareCallCount() int {
+	fake.checkShareMutex.RLock()
+	defer fake.checkShareMutex.RUnlock()
+	return len(fake.checkShareArgsForCall)
+}
+
+func (fake *FakeShareChecker) CheckShareArgsForCall(i int) (context.Context, string, string) {
+	fake.checkShareMutex.RLock()
+	defer fake.checkShareMutex.RUnlock()
+	return fake.checkShareArgsForCall[i].ctx, fake.checkShareArgsForCall[i].server, fake.checkShareArgsForCall[i].path
+}
+
+func (fake *FakeShareChecker) CheckShareReturns(result1 error) {
+	fake.CheckShareStub = nil
+	fake.checkShareReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ nfsbroker.ShareChecker = new(FakeShareChecker)
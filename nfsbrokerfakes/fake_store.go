@@ -2,7 +2,9 @@
 package nfsbrokerfakes
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/nfsbroker/nfsbroker"
@@ -10,70 +12,232 @@ import (
 )
 
 type FakeStore struct {
-	RetrieveInstanceDetailsStub        func(id string) (nfsbroker.ServiceInstance, error)
+	RetrieveInstanceDetailsStub        func(ctx context.Context, id string) (nfsbroker.ServiceInstance, error)
 	retrieveInstanceDetailsMutex       sync.RWMutex
 	retrieveInstanceDetailsArgsForCall []struct {
-		id string
+		ctx context.Context
+		id  string
 	}
 	retrieveInstanceDetailsReturns struct {
 		result1 nfsbroker.ServiceInstance
 		result2 error
 	}
-	RetrieveBindingDetailsStub        func(id string) (brokerapi.BindDetails, error)
+	RetrieveBindingDetailsStub        func(ctx context.Context, id string) (brokerapi.BindDetails, error)
 	retrieveBindingDetailsMutex       sync.RWMutex
 	retrieveBindingDetailsArgsForCall []struct {
-		id string
+		ctx context.Context
+		id  string
 	}
 	retrieveBindingDetailsReturns struct {
 		result1 brokerapi.BindDetails
 		result2 error
 	}
-	CreateInstanceDetailsStub        func(id string, details nfsbroker.ServiceInstance) error
+	CreateInstanceDetailsStub        func(ctx context.Context, id string, details nfsbroker.ServiceInstance) error
 	createInstanceDetailsMutex       sync.RWMutex
 	createInstanceDetailsArgsForCall []struct {
+		ctx     context.Context
 		id      string
 		details nfsbroker.ServiceInstance
 	}
 	createInstanceDetailsReturns struct {
 		result1 error
 	}
-	CreateBindingDetailsStub        func(id string, details brokerapi.BindDetails) error
+	CreateBindingDetailsStub        func(ctx context.Context, instanceID string, id string, details brokerapi.BindDetails) error
 	createBindingDetailsMutex       sync.RWMutex
 	createBindingDetailsArgsForCall []struct {
-		id      string
-		details brokerapi.BindDetails
+		ctx        context.Context
+		instanceID string
+		id         string
+		details    brokerapi.BindDetails
 	}
 	createBindingDetailsReturns struct {
 		result1 error
 	}
-	DeleteInstanceDetailsStub        func(id string) error
+	UpdateInstanceDetailsStub        func(ctx context.Context, id string, details nfsbroker.ServiceInstance) error
+	updateInstanceDetailsMutex       sync.RWMutex
+	updateInstanceDetailsArgsForCall []struct {
+		ctx     context.Context
+		id      string
+		details nfsbroker.ServiceInstance
+	}
+	updateInstanceDetailsReturns struct {
+		result1 error
+	}
+	DeleteInstanceDetailsStub        func(ctx context.Context, id string) error
 	deleteInstanceDetailsMutex       sync.RWMutex
 	deleteInstanceDetailsArgsForCall []struct {
-		id string
+		ctx context.Context
+		id  string
 	}
 	deleteInstanceDetailsReturns struct {
 		result1 error
 	}
-	DeleteBindingDetailsStub        func(id string) error
+	DeleteBindingDetailsStub        func(ctx context.Context, id string) error
 	deleteBindingDetailsMutex       sync.RWMutex
 	deleteBindingDetailsArgsForCall []struct {
-		id string
+		ctx context.Context
+		id  string
 	}
 	deleteBindingDetailsReturns struct {
 		result1 error
 	}
-	IsInstanceConflictStub        func(id string, details nfsbroker.ServiceInstance) bool
+	ListInstanceDetailsStub        func(ctx context.Context, opts nfsbroker.ListOptions) (map[string]nfsbroker.ServiceInstance, error)
+	listInstanceDetailsMutex       sync.RWMutex
+	listInstanceDetailsArgsForCall []struct {
+		ctx  context.Context
+		opts nfsbroker.ListOptions
+	}
+	listInstanceDetailsReturns struct {
+		result1 map[string]nfsbroker.ServiceInstance
+		result2 error
+	}
+	ListBindingDetailsStub        func(ctx context.Context, opts nfsbroker.ListOptions) (map[string]brokerapi.BindDetails, error)
+	listBindingDetailsMutex       sync.RWMutex
+	listBindingDetailsArgsForCall []struct {
+		ctx  context.Context
+		opts nfsbroker.ListOptions
+	}
+	listBindingDetailsReturns struct {
+		result1 map[string]brokerapi.BindDetails
+		result2 error
+	}
+	ListBindingInstancesStub        func(ctx context.Context) (map[string]string, error)
+	listBindingInstancesMutex       sync.RWMutex
+	listBindingInstancesArgsForCall []struct {
+		ctx context.Context
+	}
+	listBindingInstancesReturns struct {
+		result1 map[string]string
+		result2 error
+	}
+	CountInstancesStub        func(ctx context.Context) (int, error)
+	countInstancesMutex       sync.RWMutex
+	countInstancesArgsForCall []struct {
+		ctx context.Context
+	}
+	countInstancesReturns struct {
+		result1 int
+		result2 error
+	}
+	CountBindingsStub        func(ctx context.Context) (int, error)
+	countBindingsMutex       sync.RWMutex
+	countBindingsArgsForCall []struct {
+		ctx context.Context
+	}
+	countBindingsReturns struct {
+		result1 int
+		result2 error
+	}
+	RetrieveJobNextRunStub        func(ctx context.Context, name string) (time.Time, error)
+	retrieveJobNextRunMutex       sync.RWMutex
+	retrieveJobNextRunArgsForCall []struct {
+		ctx  context.Context
+		name string
+	}
+	retrieveJobNextRunReturns struct {
+		result1 time.Time
+		result2 error
+	}
+	SaveJobNextRunStub        func(ctx context.Context, name string, next time.Time) error
+	saveJobNextRunMutex       sync.RWMutex
+	saveJobNextRunArgsForCall []struct {
+		ctx  context.Context
+		name string
+		next time.Time
+	}
+	saveJobNextRunReturns struct {
+		result1 error
+	}
+	RetrieveOperationStub        func(ctx context.Context, instanceID string) (nfsbroker.Operation, error)
+	retrieveOperationMutex       sync.RWMutex
+	retrieveOperationArgsForCall []struct {
+		ctx        context.Context
+		instanceID string
+	}
+	retrieveOperationReturns struct {
+		result1 nfsbroker.Operation
+		result2 error
+	}
+	SaveOperationStub        func(ctx context.Context, instanceID string, operation nfsbroker.Operation) error
+	saveOperationMutex       sync.RWMutex
+	saveOperationArgsForCall []struct {
+		ctx        context.Context
+		instanceID string
+		operation  nfsbroker.Operation
+	}
+	saveOperationReturns struct {
+		result1 error
+	}
+	ListRetiredPlansStub        func(ctx context.Context) (map[string]string, error)
+	listRetiredPlansMutex       sync.RWMutex
+	listRetiredPlansArgsForCall []struct {
+		ctx context.Context
+	}
+	listRetiredPlansReturns struct {
+		result1 map[string]string
+		result2 error
+	}
+	RetirePlanStub        func(ctx context.Context, planID string, hint string) error
+	retirePlanMutex       sync.RWMutex
+	retirePlanArgsForCall []struct {
+		ctx    context.Context
+		planID string
+		hint   string
+	}
+	retirePlanReturns struct {
+		result1 error
+	}
+	ReinstatePlanStub        func(ctx context.Context, planID string) error
+	reinstatePlanMutex       sync.RWMutex
+	reinstatePlanArgsForCall []struct {
+		ctx    context.Context
+		planID string
+	}
+	reinstatePlanReturns struct {
+		result1 error
+	}
+	ListShareReservationsStub        func(ctx context.Context) (map[string]string, error)
+	listShareReservationsMutex       sync.RWMutex
+	listShareReservationsArgsForCall []struct {
+		ctx context.Context
+	}
+	listShareReservationsReturns struct {
+		result1 map[string]string
+		result2 error
+	}
+	ReserveSharesStub        func(ctx context.Context, prefix string, orgGUID string) error
+	reserveSharesMutex       sync.RWMutex
+	reserveSharesArgsForCall []struct {
+		ctx     context.Context
+		prefix  string
+		orgGUID string
+	}
+	reserveSharesReturns struct {
+		result1 error
+	}
+	ReleaseSharesStub        func(ctx context.Context, prefix string) error
+	releaseSharesMutex       sync.RWMutex
+	releaseSharesArgsForCall []struct {
+		ctx    context.Context
+		prefix string
+	}
+	releaseSharesReturns struct {
+		result1 error
+	}
+	IsInstanceConflictStub        func(ctx context.Context, id string, details nfsbroker.ServiceInstance) bool
 	isInstanceConflictMutex       sync.RWMutex
 	isInstanceConflictArgsForCall []struct {
+		ctx     context.Context
 		id      string
 		details nfsbroker.ServiceInstance
 	}
 	isInstanceConflictReturns struct {
 		result1 bool
 	}
-	IsBindingConflictStub        func(id string, details brokerapi.BindDetails) bool
+	IsBindingConflictStub        func(ctx context.Context, id string, details brokerapi.BindDetails) bool
 	isBindingConflictMutex       sync.RWMutex
 	isBindingConflictArgsForCall []struct {
+		ctx     context.Context
 		id      string
 		details brokerapi.BindDetails
 	}
@@ -99,19 +263,20 @@ type FakeStore struct {
 	CleanupStub        func() error
 	cleanupMutex       sync.RWMutex
 	cleanupArgsForCall []struct{}
-	cleanupReturns struct {
+	cleanupReturns     struct {
 		result1 error
 	}
 }
 
-func (fake *FakeStore) RetrieveInstanceDetails(id string) (nfsbroker.ServiceInstance, error) {
+func (fake *FakeStore) RetrieveInstanceDetails(ctx context.Context, id string) (nfsbroker.ServiceInstance, error) {
 	fake.retrieveInstanceDetailsMutex.Lock()
 	fake.retrieveInstanceDetailsArgsForCall = append(fake.retrieveInstanceDetailsArgsForCall, struct {
-		id string
-	}{id})
+		ctx context.Context
+		id  string
+	}{ctx, id})
 	fake.retrieveInstanceDetailsMutex.Unlock()
 	if fake.RetrieveInstanceDetailsStub != nil {
-		return fake.RetrieveInstanceDetailsStub(id)
+		return fake.RetrieveInstanceDetailsStub(ctx, id)
 	} else {
 		return fake.retrieveInstanceDetailsReturns.result1, fake.retrieveInstanceDetailsReturns.result2
 	}
@@ -123,10 +288,10 @@ func (fake *FakeStore) RetrieveInstanceDetailsCallCount() int {
 	return len(fake.retrieveInstanceDetailsArgsForCall)
 }
 
-func (fake *FakeStore) RetrieveInstanceDetailsArgsForCall(i int) string {
+func (fake *FakeStore) RetrieveInstanceDetailsArgsForCall(i int) (context.Context, string) {
 	fake.retrieveInstanceDetailsMutex.RLock()
 	defer fake.retrieveInstanceDetailsMutex.RUnlock()
-	return fake.retrieveInstanceDetailsArgsForCall[i].id
+	return fake.retrieveInstanceDetailsArgsForCall[i].ctx, fake.retrieveInstanceDetailsArgsForCall[i].id
 }
 
 func (fake *FakeStore) RetrieveInstanceDetailsReturns(result1 nfsbroker.ServiceInstance, result2 error) {
@@ -137,14 +302,15 @@ func (fake *FakeStore) RetrieveInstanceDetailsReturns(result1 nfsbroker.ServiceI
 	}{result1, result2}
 }
 
-func (fake *FakeStore) RetrieveBindingDetails(id string) (brokerapi.BindDetails, error) {
+func (fake *FakeStore) RetrieveBindingDetails(ctx context.Context, id string) (brokerapi.BindDetails, error) {
 	fake.retrieveBindingDetailsMutex.Lock()
 	fake.retrieveBindingDetailsArgsForCall = append(fake.retrieveBindingDetailsArgsForCall, struct {
-		id string
-	}{id})
+		ctx context.Context
+		id  string
+	}{ctx, id})
 	fake.retrieveBindingDetailsMutex.Unlock()
 	if fake.RetrieveBindingDetailsStub != nil {
-		return fake.RetrieveBindingDetailsStub(id)
+		return fake.RetrieveBindingDetailsStub(ctx, id)
 	} else {
 		return fake.retrieveBindingDetailsReturns.result1, fake.retrieveBindingDetailsReturns.result2
 	}
@@ -156,10 +322,10 @@ func (fake *FakeStore) RetrieveBindingDetailsCallCount() int {
 	return len(fake.retrieveBindingDetailsArgsForCall)
 }
 
-func (fake *FakeStore) RetrieveBindingDetailsArgsForCall(i int) string {
+func (fake *FakeStore) RetrieveBindingDetailsArgsForCall(i int) (context.Context, string) {
 	fake.retrieveBindingDetailsMutex.RLock()
 	defer fake.retrieveBindingDetailsMutex.RUnlock()
-	return fake.retrieveBindingDetailsArgsForCall[i].id
+	return fake.retrieveBindingDetailsArgsForCall[i].ctx, fake.retrieveBindingDetailsArgsForCall[i].id
 }
 
 func (fake *FakeStore) RetrieveBindingDetailsReturns(result1 brokerapi.BindDetails, result2 error) {
@@ -170,15 +336,16 @@ func (fake *FakeStore) RetrieveBindingDetailsReturns(result1 brokerapi.BindDetai
 	}{result1, result2}
 }
 
-func (fake *FakeStore) CreateInstanceDetails(id string, details nfsbroker.ServiceInstance) error {
+func (fake *FakeStore) CreateInstanceDetails(ctx context.Context, id string, details nfsbroker.ServiceInstance) error {
 	fake.createInstanceDetailsMutex.Lock()
 	fake.createInstanceDetailsArgsForCall = append(fake.createInstanceDetailsArgsForCall, struct {
+		ctx     context.Context
 		id      string
 		details nfsbroker.ServiceInstance
-	}{id, details})
+	}{ctx, id, details})
 	fake.createInstanceDetailsMutex.Unlock()
 	if fake.CreateInstanceDetailsStub != nil {
-		return fake.CreateInstanceDetailsStub(id, details)
+		return fake.CreateInstanceDetailsStub(ctx, id, details)
 	} else {
 		return fake.createInstanceDetailsReturns.result1
 	}
@@ -190,10 +357,10 @@ func (fake *FakeStore) CreateInstanceDetailsCallCount() int {
 	return len(fake.createInstanceDetailsArgsForCall)
 }
 
-func (fake *FakeStore) CreateInstanceDetailsArgsForCall(i int) (string, nfsbroker.ServiceInstance) {
+func (fake *FakeStore) CreateInstanceDetailsArgsForCall(i int) (context.Context, string, nfsbroker.ServiceInstance) {
 	fake.createInstanceDetailsMutex.RLock()
 	defer fake.createInstanceDetailsMutex.RUnlock()
-	return fake.createInstanceDetailsArgsForCall[i].id, fake.createInstanceDetailsArgsForCall[i].details
+	return fake.createInstanceDetailsArgsForCall[i].ctx, fake.createInstanceDetailsArgsForCall[i].id, fake.createInstanceDetailsArgsForCall[i].details
 }
 
 func (fake *FakeStore) CreateInstanceDetailsReturns(result1 error) {
@@ -203,15 +370,17 @@ func (fake *FakeStore) CreateInstanceDetailsReturns(result1 error) {
 	}{result1}
 }
 
-func (fake *FakeStore) CreateBindingDetails(id string, details brokerapi.BindDetails) error {
+func (fake *FakeStore) CreateBindingDetails(ctx context.Context, instanceID string, id string, details brokerapi.BindDetails) error {
 	fake.createBindingDetailsMutex.Lock()
 	fake.createBindingDetailsArgsForCall = append(fake.createBindingDetailsArgsForCall, struct {
-		id      string
-		details brokerapi.BindDetails
-	}{id, details})
+		ctx        context.Context
+		instanceID string
+		id         string
+		details    brokerapi.BindDetails
+	}{ctx, instanceID, id, details})
 	fake.createBindingDetailsMutex.Unlock()
 	if fake.CreateBindingDetailsStub != nil {
-		return fake.CreateBindingDetailsStub(id, details)
+		return fake.CreateBindingDetailsStub(ctx, instanceID, id, details)
 	} else {
 		return fake.createBindingDetailsReturns.result1
 	}
@@ -223,10 +392,10 @@ func (fake *FakeStore) CreateBindingDetailsCallCount() int {
 	return len(fake.createBindingDetailsArgsForCall)
 }
 
-func (fake *FakeStore) CreateBindingDetailsArgsForCall(i int) (string, brokerapi.BindDetails) {
+func (fake *FakeStore) CreateBindingDetailsArgsForCall(i int) (context.Context, string, string, brokerapi.BindDetails) {
 	fake.createBindingDetailsMutex.RLock()
 	defer fake.createBindingDetailsMutex.RUnlock()
-	return fake.createBindingDetailsArgsForCall[i].id, fake.createBindingDetailsArgsForCall[i].details
+	return fake.createBindingDetailsArgsForCall[i].ctx, fake.createBindingDetailsArgsForCall[i].instanceID, fake.createBindingDetailsArgsForCall[i].id, fake.createBindingDetailsArgsForCall[i].details
 }
 
 func (fake *FakeStore) CreateBindingDetailsReturns(result1 error) {
@@ -236,14 +405,49 @@ func (fake *FakeStore) CreateBindingDetailsReturns(result1 error) {
 	}{result1}
 }
 
-func (fake *FakeStore) DeleteInstanceDetails(id string) error {
+func (fake *FakeStore) UpdateInstanceDetails(ctx context.Context, id string, details nfsbroker.ServiceInstance) error {
+	fake.updateInstanceDetailsMutex.Lock()
+	fake.updateInstanceDetailsArgsForCall = append(fake.updateInstanceDetailsArgsForCall, struct {
+		ctx     context.Context
+		id      string
+		details nfsbroker.ServiceInstance
+	}{ctx, id, details})
+	fake.updateInstanceDetailsMutex.Unlock()
+	if fake.UpdateInstanceDetailsStub != nil {
+		return fake.UpdateInstanceDetailsStub(ctx, id, details)
+	} else {
+		return fake.updateInstanceDetailsReturns.result1
+	}
+}
+
+func (fake *FakeStore) UpdateInstanceDetailsCallCount() int {
+	fake.updateInstanceDetailsMutex.RLock()
+	defer fake.updateInstanceDetailsMutex.RUnlock()
+	return len(fake.updateInstanceDetailsArgsForCall)
+}
+
+func (fake *FakeStore) UpdateInstanceDetailsArgsForCall(i int) (context.Context, string, nfsbroker.ServiceInstance) {
+	fake.updateInstanceDetailsMutex.RLock()
+	defer fake.updateInstanceDetailsMutex.RUnlock()
+	return fake.updateInstanceDetailsArgsForCall[i].ctx, fake.updateInstanceDetailsArgsForCall[i].id, fake.updateInstanceDetailsArgsForCall[i].details
+}
+
+func (fake *FakeStore) UpdateInstanceDetailsReturns(result1 error) {
+	fake.UpdateInstanceDetailsStub = nil
+	fake.updateInstanceDetailsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) DeleteInstanceDetails(ctx context.Context, id string) error {
 	fake.deleteInstanceDetailsMutex.Lock()
 	fake.deleteInstanceDetailsArgsForCall = append(fake.deleteInstanceDetailsArgsForCall, struct {
-		id string
-	}{id})
+		ctx context.Context
+		id  string
+	}{ctx, id})
 	fake.deleteInstanceDetailsMutex.Unlock()
 	if fake.DeleteInstanceDetailsStub != nil {
-		return fake.DeleteInstanceDetailsStub(id)
+		return fake.DeleteInstanceDetailsStub(ctx, id)
 	} else {
 		return fake.deleteInstanceDetailsReturns.result1
 	}
@@ -255,10 +459,10 @@ func (fake *FakeStore) DeleteInstanceDetailsCallCount() int {
 	return len(fake.deleteInstanceDetailsArgsForCall)
 }
 
-func (fake *FakeStore) DeleteInstanceDetailsArgsForCall(i int) string {
+func (fake *FakeStore) DeleteInstanceDetailsArgsForCall(i int) (context.Context, string) {
 	fake.deleteInstanceDetailsMutex.RLock()
 	defer fake.deleteInstanceDetailsMutex.RUnlock()
-	return fake.deleteInstanceDetailsArgsForCall[i].id
+	return fake.deleteInstanceDetailsArgsForCall[i].ctx, fake.deleteInstanceDetailsArgsForCall[i].id
 }
 
 func (fake *FakeStore) DeleteInstanceDetailsReturns(result1 error) {
@@ -268,14 +472,15 @@ func (fake *FakeStore) DeleteInstanceDetailsReturns(result1 error) {
 	}{result1}
 }
 
-func (fake *FakeStore) DeleteBindingDetails(id string) error {
+func (fake *FakeStore) DeleteBindingDetails(ctx context.Context, id string) error {
 	fake.deleteBindingDetailsMutex.Lock()
 	fake.deleteBindingDetailsArgsForCall = append(fake.deleteBindingDetailsArgsForCall, struct {
-		id string
-	}{id})
+		ctx context.Context
+		id  string
+	}{ctx, id})
 	fake.deleteBindingDetailsMutex.Unlock()
 	if fake.DeleteBindingDetailsStub != nil {
-		return fake.DeleteBindingDetailsStub(id)
+		return fake.DeleteBindingDetailsStub(ctx, id)
 	} else {
 		return fake.deleteBindingDetailsReturns.result1
 	}
@@ -287,10 +492,10 @@ func (fake *FakeStore) DeleteBindingDetailsCallCount() int {
 	return len(fake.deleteBindingDetailsArgsForCall)
 }
 
-func (fake *FakeStore) DeleteBindingDetailsArgsForCall(i int) string {
+func (fake *FakeStore) DeleteBindingDetailsArgsForCall(i int) (context.Context, string) {
 	fake.deleteBindingDetailsMutex.RLock()
 	defer fake.deleteBindingDetailsMutex.RUnlock()
-	return fake.deleteBindingDetailsArgsForCall[i].id
+	return fake.deleteBindingDetailsArgsForCall[i].ctx, fake.deleteBindingDetailsArgsForCall[i].id
 }
 
 func (fake *FakeStore) DeleteBindingDetailsReturns(result1 error) {
@@ -300,15 +505,519 @@ func (fake *FakeStore) DeleteBindingDetailsReturns(result1 error) {
 	}{result1}
 }
 
-func (fake *FakeStore) IsInstanceConflict(id string, details nfsbroker.ServiceInstance) bool {
+func (fake *FakeStore) ListInstanceDetails(ctx context.Context, opts nfsbroker.ListOptions) (map[string]nfsbroker.ServiceInstance, error) {
+	fake.listInstanceDetailsMutex.Lock()
+	fake.listInstanceDetailsArgsForCall = append(fake.listInstanceDetailsArgsForCall, struct {
+		ctx  context.Context
+		opts nfsbroker.ListOptions
+	}{ctx, opts})
+	fake.listInstanceDetailsMutex.Unlock()
+	if fake.ListInstanceDetailsStub != nil {
+		return fake.ListInstanceDetailsStub(ctx, opts)
+	} else {
+		return fake.listInstanceDetailsReturns.result1, fake.listInstanceDetailsReturns.result2
+	}
+}
+
+func (fake *FakeStore) ListInstanceDetailsCallCount() int {
+	fake.listInstanceDetailsMutex.RLock()
+	defer fake.listInstanceDetailsMutex.RUnlock()
+	return len(fake.listInstanceDetailsArgsForCall)
+}
+
+func (fake *FakeStore) ListInstanceDetailsArgsForCall(i int) (context.Context, nfsbroker.ListOptions) {
+	fake.listInstanceDetailsMutex.RLock()
+	defer fake.listInstanceDetailsMutex.RUnlock()
+	return fake.listInstanceDetailsArgsForCall[i].ctx, fake.listInstanceDetailsArgsForCall[i].opts
+}
+
+func (fake *FakeStore) ListInstanceDetailsReturns(result1 map[string]nfsbroker.ServiceInstance, result2 error) {
+	fake.ListInstanceDetailsStub = nil
+	fake.listInstanceDetailsReturns = struct {
+		result1 map[string]nfsbroker.ServiceInstance
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) ListBindingDetails(ctx context.Context, opts nfsbroker.ListOptions) (map[string]brokerapi.BindDetails, error) {
+	fake.listBindingDetailsMutex.Lock()
+	fake.listBindingDetailsArgsForCall = append(fake.listBindingDetailsArgsForCall, struct {
+		ctx  context.Context
+		opts nfsbroker.ListOptions
+	}{ctx, opts})
+	fake.listBindingDetailsMutex.Unlock()
+	if fake.ListBindingDetailsStub != nil {
+		return fake.ListBindingDetailsStub(ctx, opts)
+	} else {
+		return fake.listBindingDetailsReturns.result1, fake.listBindingDetailsReturns.result2
+	}
+}
+
+func (fake *FakeStore) ListBindingDetailsCallCount() int {
+	fake.listBindingDetailsMutex.RLock()
+	defer fake.listBindingDetailsMutex.RUnlock()
+	return len(fake.listBindingDetailsArgsForCall)
+}
+
+func (fake *FakeStore) ListBindingDetailsArgsForCall(i int) (context.Context, nfsbroker.ListOptions) {
+	fake.listBindingDetailsMutex.RLock()
+	defer fake.listBindingDetailsMutex.RUnlock()
+	return fake.listBindingDetailsArgsForCall[i].ctx, fake.listBindingDetailsArgsForCall[i].opts
+}
+
+func (fake *FakeStore) ListBindingDetailsReturns(result1 map[string]brokerapi.BindDetails, result2 error) {
+	fake.ListBindingDetailsStub = nil
+	fake.listBindingDetailsReturns = struct {
+		result1 map[string]brokerapi.BindDetails
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) ListBindingInstances(ctx context.Context) (map[string]string, error) {
+	fake.listBindingInstancesMutex.Lock()
+	fake.listBindingInstancesArgsForCall = append(fake.listBindingInstancesArgsForCall, struct {
+		ctx context.Context
+	}{ctx})
+	fake.listBindingInstancesMutex.Unlock()
+	if fake.ListBindingInstancesStub != nil {
+		return fake.ListBindingInstancesStub(ctx)
+	} else {
+		return fake.listBindingInstancesReturns.result1, fake.listBindingInstancesReturns.result2
+	}
+}
+
+func (fake *FakeStore) ListBindingInstancesCallCount() int {
+	fake.listBindingInstancesMutex.RLock()
+	defer fake.listBindingInstancesMutex.RUnlock()
+	return len(fake.listBindingInstancesArgsForCall)
+}
+
+func (fake *FakeStore) ListBindingInstancesArgsForCall(i int) context.Context {
+	fake.listBindingInstancesMutex.RLock()
+	defer fake.listBindingInstancesMutex.RUnlock()
+	return fake.listBindingInstancesArgsForCall[i].ctx
+}
+
+func (fake *FakeStore) ListBindingInstancesReturns(result1 map[string]string, result2 error) {
+	fake.ListBindingInstancesStub = nil
+	fake.listBindingInstancesReturns = struct {
+		result1 map[string]string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) CountInstances(ctx context.Context) (int, error) {
+	fake.countInstancesMutex.Lock()
+	fake.countInstancesArgsForCall = append(fake.countInstancesArgsForCall, struct {
+		ctx context.Context
+	}{ctx})
+	fake.countInstancesMutex.Unlock()
+	if fake.CountInstancesStub != nil {
+		return fake.CountInstancesStub(ctx)
+	} else {
+		return fake.countInstancesReturns.result1, fake.countInstancesReturns.result2
+	}
+}
+
+func (fake *FakeStore) CountInstancesCallCount() int {
+	fake.countInstancesMutex.RLock()
+	defer fake.countInstancesMutex.RUnlock()
+	return len(fake.countInstancesArgsForCall)
+}
+
+func (fake *FakeStore) CountInstancesArgsForCall(i int) context.Context {
+	fake.countInstancesMutex.RLock()
+	defer fake.countInstancesMutex.RUnlock()
+	return fake.countInstancesArgsForCall[i].ctx
+}
+
+func (fake *FakeStore) CountInstancesReturns(result1 int, result2 error) {
+	fake.CountInstancesStub = nil
+	fake.countInstancesReturns = struct {
+		result1 int
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) CountBindings(ctx context.Context) (int, error) {
+	fake.countBindingsMutex.Lock()
+	fake.countBindingsArgsForCall = append(fake.countBindingsArgsForCall, struct {
+		ctx context.Context
+	}{ctx})
+	fake.countBindingsMutex.Unlock()
+	if fake.CountBindingsStub != nil {
+		return fake.CountBindingsStub(ctx)
+	} else {
+		return fake.countBindingsReturns.result1, fake.countBindingsReturns.result2
+	}
+}
+
+func (fake *FakeStore) CountBindingsCallCount() int {
+	fake.countBindingsMutex.RLock()
+	defer fake.countBindingsMutex.RUnlock()
+	return len(fake.countBindingsArgsForCall)
+}
+
+func (fake *FakeStore) CountBindingsArgsForCall(i int) context.Context {
+	fake.countBindingsMutex.RLock()
+	defer fake.countBindingsMutex.RUnlock()
+	return fake.countBindingsArgsForCall[i].ctx
+}
+
+func (fake *FakeStore) CountBindingsReturns(result1 int, result2 error) {
+	fake.CountBindingsStub = nil
+	fake.countBindingsReturns = struct {
+		result1 int
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveJobNextRun(ctx context.Context, name string) (time.Time, error) {
+	fake.retrieveJobNextRunMutex.Lock()
+	fake.retrieveJobNextRunArgsForCall = append(fake.retrieveJobNextRunArgsForCall, struct {
+		ctx  context.Context
+		name string
+	}{ctx, name})
+	fake.retrieveJobNextRunMutex.Unlock()
+	if fake.RetrieveJobNextRunStub != nil {
+		return fake.RetrieveJobNextRunStub(ctx, name)
+	} else {
+		return fake.retrieveJobNextRunReturns.result1, fake.retrieveJobNextRunReturns.result2
+	}
+}
+
+func (fake *FakeStore) RetrieveJobNextRunCallCount() int {
+	fake.retrieveJobNextRunMutex.RLock()
+	defer fake.retrieveJobNextRunMutex.RUnlock()
+	return len(fake.retrieveJobNextRunArgsForCall)
+}
+
+func (fake *FakeStore) RetrieveJobNextRunArgsForCall(i int) (context.Context, string) {
+	fake.retrieveJobNextRunMutex.RLock()
+	defer fake.retrieveJobNextRunMutex.RUnlock()
+	return fake.retrieveJobNextRunArgsForCall[i].ctx, fake.retrieveJobNextRunArgsForCall[i].name
+}
+
+func (fake *FakeStore) RetrieveJobNextRunReturns(result1 time.Time, result2 error) {
+	fake.RetrieveJobNextRunStub = nil
+	fake.retrieveJobNextRunReturns = struct {
+		result1 time.Time
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) SaveJobNextRun(ctx context.Context, name string, next time.Time) error {
+	fake.saveJobNextRunMutex.Lock()
+	fake.saveJobNextRunArgsForCall = append(fake.saveJobNextRunArgsForCall, struct {
+		ctx  context.Context
+		name string
+		next time.Time
+	}{ctx, name, next})
+	fake.saveJobNextRunMutex.Unlock()
+	if fake.SaveJobNextRunStub != nil {
+		return fake.SaveJobNextRunStub(ctx, name, next)
+	} else {
+		return fake.saveJobNextRunReturns.result1
+	}
+}
+
+func (fake *FakeStore) SaveJobNextRunCallCount() int {
+	fake.saveJobNextRunMutex.RLock()
+	defer fake.saveJobNextRunMutex.RUnlock()
+	return len(fake.saveJobNextRunArgsForCall)
+}
+
+func (fake *FakeStore) SaveJobNextRunArgsForCall(i int) (context.Context, string, time.Time) {
+	fake.saveJobNextRunMutex.RLock()
+	defer fake.saveJobNextRunMutex.RUnlock()
+	return fake.saveJobNextRunArgsForCall[i].ctx, fake.saveJobNextRunArgsForCall[i].name, fake.saveJobNextRunArgsForCall[i].next
+}
+
+func (fake *FakeStore) SaveJobNextRunReturns(result1 error) {
+	fake.SaveJobNextRunStub = nil
+	fake.saveJobNextRunReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) RetrieveOperation(ctx context.Context, instanceID string) (nfsbroker.Operation, error) {
+	fake.retrieveOperationMutex.Lock()
+	fake.retrieveOperationArgsForCall = append(fake.retrieveOperationArgsForCall, struct {
+		ctx        context.Context
+		instanceID string
+	}{ctx, instanceID})
+	fake.retrieveOperationMutex.Unlock()
+	if fake.RetrieveOperationStub != nil {
+		return fake.RetrieveOperationStub(ctx, instanceID)
+	} else {
+		return fake.retrieveOperationReturns.result1, fake.retrieveOperationReturns.result2
+	}
+}
+
+func (fake *FakeStore) RetrieveOperationCallCount() int {
+	fake.retrieveOperationMutex.RLock()
+	defer fake.retrieveOperationMutex.RUnlock()
+	return len(fake.retrieveOperationArgsForCall)
+}
+
+func (fake *FakeStore) RetrieveOperationArgsForCall(i int) (context.Context, string) {
+	fake.retrieveOperationMutex.RLock()
+	defer fake.retrieveOperationMutex.RUnlock()
+	return fake.retrieveOperationArgsForCall[i].ctx, fake.retrieveOperationArgsForCall[i].instanceID
+}
+
+func (fake *FakeStore) RetrieveOperationReturns(result1 nfsbroker.Operation, result2 error) {
+	fake.RetrieveOperationStub = nil
+	fake.retrieveOperationReturns = struct {
+		result1 nfsbroker.Operation
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) SaveOperation(ctx context.Context, instanceID string, operation nfsbroker.Operation) error {
+	fake.saveOperationMutex.Lock()
+	fake.saveOperationArgsForCall = append(fake.saveOperationArgsForCall, struct {
+		ctx        context.Context
+		instanceID string
+		operation  nfsbroker.Operation
+	}{ctx, instanceID, operation})
+	fake.saveOperationMutex.Unlock()
+	if fake.SaveOperationStub != nil {
+		return fake.SaveOperationStub(ctx, instanceID, operation)
+	} else {
+		return fake.saveOperationReturns.result1
+	}
+}
+
+func (fake *FakeStore) SaveOperationCallCount() int {
+	fake.saveOperationMutex.RLock()
+	defer fake.saveOperationMutex.RUnlock()
+	return len(fake.saveOperationArgsForCall)
+}
+
+func (fake *FakeStore) SaveOperationArgsForCall(i int) (context.Context, string, nfsbroker.Operation) {
+	fake.saveOperationMutex.RLock()
+	defer fake.saveOperationMutex.RUnlock()
+	return fake.saveOperationArgsForCall[i].ctx, fake.saveOperationArgsForCall[i].instanceID, fake.saveOperationArgsForCall[i].operation
+}
+
+func (fake *FakeStore) SaveOperationReturns(result1 error) {
+	fake.SaveOperationStub = nil
+	fake.saveOperationReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) ListRetiredPlans(ctx context.Context) (map[string]string, error) {
+	fake.listRetiredPlansMutex.Lock()
+	fake.listRetiredPlansArgsForCall = append(fake.listRetiredPlansArgsForCall, struct {
+		ctx context.Context
+	}{ctx})
+	fake.listRetiredPlansMutex.Unlock()
+	if fake.ListRetiredPlansStub != nil {
+		return fake.ListRetiredPlansStub(ctx)
+	} else {
+		return fake.listRetiredPlansReturns.result1, fake.listRetiredPlansReturns.result2
+	}
+}
+
+func (fake *FakeStore) ListRetiredPlansCallCount() int {
+	fake.listRetiredPlansMutex.RLock()
+	defer fake.listRetiredPlansMutex.RUnlock()
+	return len(fake.listRetiredPlansArgsForCall)
+}
+
+func (fake *FakeStore) ListRetiredPlansArgsForCall(i int) context.Context {
+	fake.listRetiredPlansMutex.RLock()
+	defer fake.listRetiredPlansMutex.RUnlock()
+	return fake.listRetiredPlansArgsForCall[i].ctx
+}
+
+func (fake *FakeStore) ListRetiredPlansReturns(result1 map[string]string, result2 error) {
+	fake.ListRetiredPlansStub = nil
+	fake.listRetiredPlansReturns = struct {
+		result1 map[string]string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetirePlan(ctx context.Context, planID string, hint string) error {
+	fake.retirePlanMutex.Lock()
+	fake.retirePlanArgsForCall = append(fake.retirePlanArgsForCall, struct {
+		ctx    context.Context
+		planID string
+		hint   string
+	}{ctx, planID, hint})
+	fake.retirePlanMutex.Unlock()
+	if fake.RetirePlanStub != nil {
+		return fake.RetirePlanStub(ctx, planID, hint)
+	} else {
+		return fake.retirePlanReturns.result1
+	}
+}
+
+func (fake *FakeStore) RetirePlanCallCount() int {
+	fake.retirePlanMutex.RLock()
+	defer fake.retirePlanMutex.RUnlock()
+	return len(fake.retirePlanArgsForCall)
+}
+
+func (fake *FakeStore) RetirePlanArgsForCall(i int) (context.Context, string, string) {
+	fake.retirePlanMutex.RLock()
+	defer fake.retirePlanMutex.RUnlock()
+	return fake.retirePlanArgsForCall[i].ctx, fake.retirePlanArgsForCall[i].planID, fake.retirePlanArgsForCall[i].hint
+}
+
+func (fake *FakeStore) RetirePlanReturns(result1 error) {
+	fake.RetirePlanStub = nil
+	fake.retirePlanReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) ReinstatePlan(ctx context.Context, planID string) error {
+	fake.reinstatePlanMutex.Lock()
+	fake.reinstatePlanArgsForCall = append(fake.reinstatePlanArgsForCall, struct {
+		ctx    context.Context
+		planID string
+	}{ctx, planID})
+	fake.reinstatePlanMutex.Unlock()
+	if fake.ReinstatePlanStub != nil {
+		return fake.ReinstatePlanStub(ctx, planID)
+	} else {
+		return fake.reinstatePlanReturns.result1
+	}
+}
+
+func (fake *FakeStore) ReinstatePlanCallCount() int {
+	fake.reinstatePlanMutex.RLock()
+	defer fake.reinstatePlanMutex.RUnlock()
+	return len(fake.reinstatePlanArgsForCall)
+}
+
+func (fake *FakeStore) ReinstatePlanArgsForCall(i int) (context.Context, string) {
+	fake.reinstatePlanMutex.RLock()
+	defer fake.reinstatePlanMutex.RUnlock()
+	return fake.reinstatePlanArgsForCall[i].ctx, fake.reinstatePlanArgsForCall[i].planID
+}
+
+func (fake *FakeStore) ReinstatePlanReturns(result1 error) {
+	fake.ReinstatePlanStub = nil
+	fake.reinstatePlanReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) ListShareReservations(ctx context.Context) (map[string]string, error) {
+	fake.listShareReservationsMutex.Lock()
+	fake.listShareReservationsArgsForCall = append(fake.listShareReservationsArgsForCall, struct {
+		ctx context.Context
+	}{ctx})
+	fake.listShareReservationsMutex.Unlock()
+	if fake.ListShareReservationsStub != nil {
+		return fake.ListShareReservationsStub(ctx)
+	} else {
+		return fake.listShareReservationsReturns.result1, fake.listShareReservationsReturns.result2
+	}
+}
+
+func (fake *FakeStore) ListShareReservationsCallCount() int {
+	fake.listShareReservationsMutex.RLock()
+	defer fake.listShareReservationsMutex.RUnlock()
+	return len(fake.listShareReservationsArgsForCall)
+}
+
+func (fake *FakeStore) ListShareReservationsArgsForCall(i int) context.Context {
+	fake.listShareReservationsMutex.RLock()
+	defer fake.listShareReservationsMutex.RUnlock()
+	return fake.listShareReservationsArgsForCall[i].ctx
+}
+
+func (fake *FakeStore) ListShareReservationsReturns(result1 map[string]string, result2 error) {
+	fake.ListShareReservationsStub = nil
+	fake.listShareReservationsReturns = struct {
+		result1 map[string]string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) ReserveShares(ctx context.Context, prefix string, orgGUID string) error {
+	fake.reserveSharesMutex.Lock()
+	fake.reserveSharesArgsForCall = append(fake.reserveSharesArgsForCall, struct {
+		ctx     context.Context
+		prefix  string
+		orgGUID string
+	}{ctx, prefix, orgGUID})
+	fake.reserveSharesMutex.Unlock()
+	if fake.ReserveSharesStub != nil {
+		return fake.ReserveSharesStub(ctx, prefix, orgGUID)
+	} else {
+		return fake.reserveSharesReturns.result1
+	}
+}
+
+func (fake *FakeStore) ReserveSharesCallCount() int {
+	fake.reserveSharesMutex.RLock()
+	defer fake.reserveSharesMutex.RUnlock()
+	return len(fake.reserveSharesArgsForCall)
+}
+
+func (fake *FakeStore) ReserveSharesArgsForCall(i int) (context.Context, string, string) {
+	fake.reserveSharesMutex.RLock()
+	defer fake.reserveSharesMutex.RUnlock()
+	return fake.reserveSharesArgsForCall[i].ctx, fake.reserveSharesArgsForCall[i].prefix, fake.reserveSharesArgsForCall[i].orgGUID
+}
+
+func (fake *FakeStore) ReserveSharesReturns(result1 error) {
+	fake.ReserveSharesStub = nil
+	fake.reserveSharesReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) ReleaseShares(ctx context.Context, prefix string) error {
+	fake.releaseSharesMutex.Lock()
+	fake.releaseSharesArgsForCall = append(fake.releaseSharesArgsForCall, struct {
+		ctx    context.Context
+		prefix string
+	}{ctx, prefix})
+	fake.releaseSharesMutex.Unlock()
+	if fake.ReleaseSharesStub != nil {
+		return fake.ReleaseSharesStub(ctx, prefix)
+	} else {
+		return fake.releaseSharesReturns.result1
+	}
+}
+
+func (fake *FakeStore) ReleaseSharesCallCount() int {
+	fake.releaseSharesMutex.RLock()
+	defer fake.releaseSharesMutex.RUnlock()
+	return len(fake.releaseSharesArgsForCall)
+}
+
+func (fake *FakeStore) ReleaseSharesArgsForCall(i int) (context.Context, string) {
+	fake.releaseSharesMutex.RLock()
+	defer fake.releaseSharesMutex.RUnlock()
+	return fake.releaseSharesArgsForCall[i].ctx, fake.releaseSharesArgsForCall[i].prefix
+}
+
+func (fake *FakeStore) ReleaseSharesReturns(result1 error) {
+	fake.ReleaseSharesStub = nil
+	fake.releaseSharesReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) IsInstanceConflict(ctx context.Context, id string, details nfsbroker.ServiceInstance) bool {
 	fake.isInstanceConflictMutex.Lock()
 	fake.isInstanceConflictArgsForCall = append(fake.isInstanceConflictArgsForCall, struct {
+		ctx     context.Context
 		id      string
 		details nfsbroker.ServiceInstance
-	}{id, details})
+	}{ctx, id, details})
 	fake.isInstanceConflictMutex.Unlock()
 	if fake.IsInstanceConflictStub != nil {
-		return fake.IsInstanceConflictStub(id, details)
+		return fake.IsInstanceConflictStub(ctx, id, details)
 	} else {
 		return fake.isInstanceConflictReturns.result1
 	}
@@ -320,10 +1029,10 @@ func (fake *FakeStore) IsInstanceConflictCallCount() int {
 	return len(fake.isInstanceConflictArgsForCall)
 }
 
-func (fake *FakeStore) IsInstanceConflictArgsForCall(i int) (string, nfsbroker.ServiceInstance) {
+func (fake *FakeStore) IsInstanceConflictArgsForCall(i int) (context.Context, string, nfsbroker.ServiceInstance) {
 	fake.isInstanceConflictMutex.RLock()
 	defer fake.isInstanceConflictMutex.RUnlock()
-	return fake.isInstanceConflictArgsForCall[i].id, fake.isInstanceConflictArgsForCall[i].details
+	return fake.isInstanceConflictArgsForCall[i].ctx, fake.isInstanceConflictArgsForCall[i].id, fake.isInstanceConflictArgsForCall[i].details
 }
 
 func (fake *FakeStore) IsInstanceConflictReturns(result1 bool) {
@@ -333,15 +1042,16 @@ func (fake *FakeStore) IsInstanceConflictReturns(result1 bool) {
 	}{result1}
 }
 
-func (fake *FakeStore) IsBindingConflict(id string, details brokerapi.BindDetails) bool {
+func (fake *FakeStore) IsBindingConflict(ctx context.Context, id string, details brokerapi.BindDetails) bool {
 	fake.isBindingConflictMutex.Lock()
 	fake.isBindingConflictArgsForCall = append(fake.isBindingConflictArgsForCall, struct {
+		ctx     context.Context
 		id      string
 		details brokerapi.BindDetails
-	}{id, details})
+	}{ctx, id, details})
 	fake.isBindingConflictMutex.Unlock()
 	if fake.IsBindingConflictStub != nil {
-		return fake.IsBindingConflictStub(id, details)
+		return fake.IsBindingConflictStub(ctx, id, details)
 	} else {
 		return fake.isBindingConflictReturns.result1
 	}
@@ -353,10 +1063,10 @@ func (fake *FakeStore) IsBindingConflictCallCount() int {
 	return len(fake.isBindingConflictArgsForCall)
 }
 
-func (fake *FakeStore) IsBindingConflictArgsForCall(i int) (string, brokerapi.BindDetails) {
+func (fake *FakeStore) IsBindingConflictArgsForCall(i int) (context.Context, string, brokerapi.BindDetails) {
 	fake.isBindingConflictMutex.RLock()
 	defer fake.isBindingConflictMutex.RUnlock()
-	return fake.isBindingConflictArgsForCall[i].id, fake.isBindingConflictArgsForCall[i].details
+	return fake.isBindingConflictArgsForCall[i].ctx, fake.isBindingConflictArgsForCall[i].id, fake.isBindingConflictArgsForCall[i].details
 }
 
 func (fake *FakeStore) IsBindingConflictReturns(result1 bool) {
@@ -0,0 +1,41 @@
+// This file was generated by counterfeiter
+package nfsbrokerfakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+)
+
+type FakeWebhook struct {
+	SendStub        func(event interface{})
+	sendMutex       sync.RWMutex
+	sendArgsForCall []struct {
+		event interface{}
+	}
+}
+
+func (fake *FakeWebhook) Send(event interface{}) {
+	fake.sendMutex.Lock()
+	fake.sendArgsForCall = append(fake.sendArgsForCall, struct {
+		event interface{}
+	}{event})
+	fake.sendMutex.Unlock()
+	if fake.SendStub != nil {
+		fake.SendStub(event)
+	}
+}
+
+func (fake *FakeWebhook) SendCallCount() int {
+	fake.sendMutex.RLock()
+	defer fake.sendMutex.RUnlock()
+	return len(fake.sendArgsForCall)
+}
+
+func (fake *FakeWebhook) SendArgsForCall(i int) interface{} {
+	fake.sendMutex.RLock()
+	defer fake.sendMutex.RUnlock()
+	return fake.sendArgsForCall[i].event
+}
+
+var _ nfsbroker.Webhook = new(FakeWebhook)
@@ -0,0 +1,247 @@
+// Package nfscheck checks that NFS servers answer and export the shares users give the broker, so that typos in
+// server names and paths are reported on provision rather than when apps first mount their shares.
+package nfscheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The parts of ONC RPC (RFC 5531), the portmapper protocol (RFC 1833) and the NFSv3 mount protocol (RFC 1813) the
+// checker speaks.
+const (
+	PortmapperPort = 111
+	NFSPort        = 2049
+
+	portmapperProgram = 100000
+	portmapperVersion = 2
+	portmapperGetPort = 3
+
+	mountProgram = 100005
+	mountVersion = 3
+	mountExport  = 5
+
+	protoTCP = 6
+
+	rpcCall          = 0
+	rpcReply         = 1
+	rpcMsgAccepted   = 0
+	rpcSuccess       = 0
+	rpcLastFragment  = 1 << 31
+	rpcMaxReplySize  = 1 << 20
+	xdrMaxStringSize = 1 << 12
+)
+
+// Checker asks a server's portmapper where its mount daemon listens and asks the mount daemon for the server's
+// exports, as showmount -e does.  Servers that only speak NFSv4 need run neither; for them a TCP connection to the
+// NFS port is taken as an answer, and the export is not checked.
+type Checker struct {
+	timeout time.Duration
+	dial    func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// NewChecker returns a checker that gives each server timeout to answer.
+func NewChecker(timeout time.Duration) *Checker {
+	dialer := &net.Dialer{Timeout: timeout}
+	return NewCheckerWithDial(timeout, dialer.DialContext)
+}
+
+// NewCheckerWithDial returns a checker that opens its connections with dial.
+func NewCheckerWithDial(timeout time.Duration, dial func(ctx context.Context, network, address string) (net.Conn, error)) *Checker {
+	return &Checker{timeout: timeout, dial: dial}
+}
+
+// CheckShare returns an error describing why server cannot be asked for sharePath: that it cannot be found, that it
+// does not answer, or that sharePath is neither one of its exports nor under one.  Servers may be IPv6 addresses in
+// brackets.
+func (c *Checker) CheckShare(ctx context.Context, server, sharePath string) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	host := strings.TrimSuffix(strings.TrimPrefix(server, "["), "]")
+	if net.ParseIP(host) == nil {
+		if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+			return fmt.Errorf("server %s cannot be found: %w", server, err)
+		}
+	}
+
+	exports, portmapperErr := c.exports(ctx, host)
+	if portmapperErr != nil {
+		conn, err := c.dial(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(NFSPort)))
+		if err != nil {
+			return fmt.Errorf("server %s does not answer on port %d (portmapper) or %d (NFS): %s; %w", server, PortmapperPort, NFSPort, portmapperErr, err)
+		}
+		conn.Close()
+		return nil
+	}
+
+	for _, export := range exports {
+		if export == sharePath || export == "/" || strings.HasPrefix(sharePath, strings.TrimSuffix(export, "/")+"/") {
+			return nil
+		}
+	}
+	exported := "nothing"
+	if len(exports) > 0 {
+		exported = strings.Join(exports, ", ")
+	}
+	return fmt.Errorf("server %s does not export %s or a directory above it; it exports %s", server, path.Clean(sharePath), exported)
+}
+
+// exports asks the portmapper on host for the port of the mount daemon, and the mount daemon for the export list.
+func (c *Checker) exports(ctx context.Context, host string) ([]string, error) {
+	var args bytes.Buffer
+	binary.Write(&args, binary.BigEndian, []uint32{mountProgram, mountVersion, protoTCP, 0})
+	reply, err := c.call(ctx, net.JoinHostPort(host, strconv.Itoa(PortmapperPort)), portmapperProgram, portmapperVersion, portmapperGetPort, args.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	var port uint32
+	if err := binary.Read(reply, binary.BigEndian, &port); err != nil {
+		return nil, fmt.Errorf("failed to read the portmapper's reply: %w", err)
+	}
+	if port == 0 || port > 65535 {
+		return nil, errors.New("the mount daemon is not registered with the portmapper")
+	}
+
+	reply, err = c.call(ctx, net.JoinHostPort(host, strconv.Itoa(int(port))), mountProgram, mountVersion, mountExport, nil)
+	if err != nil {
+		return nil, err
+	}
+	return readExports(reply)
+}
+
+// call makes an RPC call with null credentials over a new TCP connection and returns the body of the reply.
+func (c *Checker) call(ctx context.Context, address string, program, version, procedure uint32, args []byte) (io.Reader, error) {
+	conn, err := c.dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// xid, call, RPC version 2, program, version, procedure, null credentials and verifier
+	header := []uint32{uint32(time.Now().UnixNano()), rpcCall, 2, program, version, procedure, 0, 0, 0, 0}
+	var call bytes.Buffer
+	binary.Write(&call, binary.BigEndian, uint32(rpcLastFragment|(4*len(header)+len(args))))
+	binary.Write(&call, binary.BigEndian, header)
+	call.Write(args)
+	if _, err := conn.Write(call.Bytes()); err != nil {
+		return nil, err
+	}
+
+	record, err := readRecord(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the reply from %s: %w", address, err)
+	}
+	reply := bytes.NewReader(record)
+	// xid, reply, accepted, verifier flavor and length
+	var head [5]uint32
+	if err := binary.Read(reply, binary.BigEndian, &head); err != nil {
+		return nil, fmt.Errorf("short reply from %s", address)
+	}
+	if head[0] != header[0] || head[1] != rpcReply || head[2] != rpcMsgAccepted {
+		return nil, fmt.Errorf("%s refused the call to program %d", address, program)
+	}
+	if _, err := reply.Seek(int64(pad(head[4])), io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	var status uint32
+	if err := binary.Read(reply, binary.BigEndian, &status); err != nil || status != rpcSuccess {
+		return nil, fmt.Errorf("%s did not accept the call to program %d", address, program)
+	}
+	return reply, nil
+}
+
+// readRecord reads the fragments of one record (RFC 5531, section 11).
+func readRecord(r io.Reader) ([]byte, error) {
+	var record []byte
+	for {
+		var marker uint32
+		if err := binary.Read(r, binary.BigEndian, &marker); err != nil {
+			return nil, err
+		}
+		length := marker &^ rpcLastFragment
+		if len(record)+int(length) > rpcMaxReplySize {
+			return nil, fmt.Errorf("reply is longer than %d bytes", rpcMaxReplySize)
+		}
+		fragment := make([]byte, length)
+		if _, err := io.ReadFull(r, fragment); err != nil {
+			return nil, err
+		}
+		record = append(record, fragment...)
+		if marker&rpcLastFragment != 0 {
+			return record, nil
+		}
+	}
+}
+
+// readExports reads the exports list of a MOUNTPROC3_EXPORT reply, ignoring the groups each directory is exported
+// to.
+func readExports(r io.Reader) ([]string, error) {
+	exports := []string{}
+	for {
+		more, err := readUint32(r)
+		if err != nil || more == 0 {
+			return exports, err
+		}
+		dir, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, dir)
+		for {
+			more, err := readUint32(r)
+			if err != nil {
+				return nil, err
+			}
+			if more == 0 {
+				break
+			}
+			if _, err := readString(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func readUint32(r io.Reader) (uint32, error) {
+	var value uint32
+	if err := binary.Read(r, binary.BigEndian, &value); err != nil {
+		return 0, fmt.Errorf("failed to read the export list: %w", err)
+	}
+	return value, nil
+}
+
+func readString(r io.Reader) (string, error) {
+	length, err := readUint32(r)
+	if err != nil {
+		return "", err
+	}
+	if length > xdrMaxStringSize {
+		return "", fmt.Errorf("export list has a string of %d bytes", length)
+	}
+	value := make([]byte, pad(length))
+	if _, err := io.ReadFull(r, value); err != nil {
+		return "", fmt.Errorf("failed to read the export list: %w", err)
+	}
+	return string(value[:length]), nil
+}
+
+// pad rounds length up to a whole number of XDR units.
+func pad(length uint32) uint32 {
+	return (length + 3) &^ 3
+}
@@ -0,0 +1,171 @@
+package nfscheck_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/nfsbroker/nfscheck"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// rpcServer answers portmapper GETPORT calls with mountPort and mount EXPORT calls with exports.
+type rpcServer struct {
+	listener  net.Listener
+	mountPort uint32
+	exports   []string
+}
+
+func newRPCServer() *rpcServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	server := &rpcServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.answer(conn)
+		}
+	}()
+	return server
+}
+
+func (s *rpcServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *rpcServer) answer(conn net.Conn) {
+	defer conn.Close()
+	var marker uint32
+	if binary.Read(conn, binary.BigEndian, &marker) != nil {
+		return
+	}
+	record := make([]byte, marker&^(1<<31))
+	if _, err := io.ReadFull(conn, record); err != nil {
+		return
+	}
+	// xid, call, RPC version, program, version, procedure
+	var call [6]uint32
+	binary.Read(bytes.NewReader(record), binary.BigEndian, &call)
+
+	// xid, reply, accepted, null verifier, success
+	reply := []uint32{call[0], 1, 0, 0, 0, 0}
+	switch call[3] {
+	case 100000:
+		reply = append(reply, s.mountPort)
+	case 100005:
+		for _, export := range s.exports {
+			reply = append(reply, 1, uint32(len(export)))
+			padded := make([]byte, (len(export)+3)&^3)
+			copy(padded, export)
+			for i := 0; i < len(padded); i += 4 {
+				reply = append(reply, binary.BigEndian.Uint32(padded[i:]))
+			}
+			// one group, "*"
+			reply = append(reply, 1, 1, binary.BigEndian.Uint32([]byte("*\x00\x00\x00")), 0)
+		}
+		reply = append(reply, 0)
+	default:
+		reply[5] = 1
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(1<<31|4*len(reply)))
+	binary.Write(&buf, binary.BigEndian, reply)
+	conn.Write(buf.Bytes())
+}
+
+var _ = Describe("Checker", func() {
+	var (
+		portmapper *rpcServer
+		mountd     *rpcServer
+		nfs        net.Listener
+		ports      map[string]int
+		checker    *nfscheck.Checker
+	)
+
+	BeforeEach(func() {
+		portmapper = newRPCServer()
+		mountd = newRPCServer()
+		mountd.exports = []string{"/exports/data", "/srv/"}
+		portmapper.mountPort = 20048
+
+		var err error
+		nfs, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		ports = map[string]int{
+			"111":   portmapper.port(),
+			"20048": mountd.port(),
+			"2049":  nfs.Addr().(*net.TCPAddr).Port,
+		}
+		checker = nfscheck.NewCheckerWithDial(time.Second, func(ctx context.Context, network, address string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(address)
+			Expect(err).NotTo(HaveOccurred())
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, net.JoinHostPort(host, strconv.Itoa(ports[port])))
+		})
+	})
+
+	AfterEach(func() {
+		portmapper.listener.Close()
+		mountd.listener.Close()
+		nfs.Close()
+	})
+
+	It("accepts exports and directories under them", func() {
+		Expect(checker.CheckShare(context.Background(), "127.0.0.1", "/exports/data")).To(Succeed())
+		Expect(checker.CheckShare(context.Background(), "127.0.0.1", "/exports/data/app")).To(Succeed())
+		Expect(checker.CheckShare(context.Background(), "127.0.0.1", "/srv/app")).To(Succeed())
+	})
+
+	It("refuses paths that are not exported", func() {
+		err := checker.CheckShare(context.Background(), "127.0.0.1", "/exports/dta")
+		Expect(err).To(MatchError("server 127.0.0.1 does not export /exports/dta or a directory above it; it exports /exports/data, /srv/"))
+
+		Expect(checker.CheckShare(context.Background(), "127.0.0.1", "/exports/database")).To(MatchError(ContainSubstring("does not export")))
+	})
+
+	It("says so when the server exports nothing", func() {
+		mountd.exports = nil
+		Expect(checker.CheckShare(context.Background(), "127.0.0.1", "/exports/data")).To(MatchError(ContainSubstring("it exports nothing")))
+	})
+
+	Context("when the mount daemon is not registered", func() {
+		BeforeEach(func() {
+			portmapper.mountPort = 0
+		})
+
+		It("accepts servers that answer on the NFS port", func() {
+			Expect(checker.CheckShare(context.Background(), "127.0.0.1", "/anything")).To(Succeed())
+		})
+	})
+
+	Context("when the server runs no portmapper", func() {
+		BeforeEach(func() {
+			portmapper.listener.Close()
+		})
+
+		It("accepts servers that answer on the NFS port", func() {
+			Expect(checker.CheckShare(context.Background(), "127.0.0.1", "/anything")).To(Succeed())
+		})
+
+		It("refuses servers that do not", func() {
+			nfs.Close()
+			err := checker.CheckShare(context.Background(), "127.0.0.1", "/exports/data")
+			Expect(err).To(MatchError(ContainSubstring("server 127.0.0.1 does not answer on port 111 (portmapper) or 2049 (NFS)")))
+		})
+	})
+
+	It("refuses servers that cannot be found", func() {
+		err := checker.CheckShare(context.Background(), "no-such-host.invalid", "/exports/data")
+		Expect(err).To(MatchError(ContainSubstring("server no-such-host.invalid cannot be found")))
+	})
+})
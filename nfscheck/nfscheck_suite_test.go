@@ -0,0 +1,13 @@
+package nfscheck_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestNfscheck(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "NFS Check Suite")
+}
@@ -0,0 +1,208 @@
+package provisioner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/nfsbroker/backoff"
+)
+
+// HandshakeTimeout is how long a starting plugin has to answer the broker's handshake.
+const HandshakeTimeout = 10 * time.Second
+
+// Client calls a plugin running as a subprocess of the broker.
+type Client struct {
+	logger  lager.Logger
+	name    string
+	path    string
+	command *exec.Cmd
+	rpc     *rpc.Client
+	exited  chan struct{}
+
+	clock       clock.Clock
+	retryPolicy backoff.Policy
+}
+
+// Start runs the plugin executable at path and checks that it speaks ProtocolVersion.
+func Start(logger lager.Logger, path string) (*Client, error) {
+	logger = logger.Session("plugin", lager.Data{"path": path})
+
+	command := exec.Command(path)
+	command.Env = append(os.Environ(), MagicCookieKey+"="+MagicCookieValue)
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := command.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := command.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %w", path, err)
+	}
+
+	client := &Client{
+		logger:      logger,
+		name:        filepath.Base(path),
+		path:        path,
+		command:     command,
+		rpc:         rpc.NewClientWithCodec(jsonrpc.NewClientCodec(pipe{stdout, stdin})),
+		exited:      make(chan struct{}),
+		clock:       clock.NewClock(),
+		retryPolicy: backoff.DefaultPolicy,
+	}
+	go logOutput(logger, stderr)
+	go func() {
+		err := command.Wait()
+		logger.Info("exited", lager.Data{"error": fmt.Sprint(err)})
+		close(client.exited)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), HandshakeTimeout)
+	defer cancel()
+	var handshake HandshakeResponse
+	if err := client.call(ctx, "Handshake", Empty{}, &handshake); err != nil {
+		client.Kill()
+		return nil, fmt.Errorf("plugin %s failed its handshake: %w", path, err)
+	}
+	if handshake.ProtocolVersion != ProtocolVersion {
+		client.Kill()
+		return nil, fmt.Errorf("plugin %s speaks protocol version %d, not %d", path, handshake.ProtocolVersion, ProtocolVersion)
+	}
+	if handshake.Name != "" {
+		client.name = handshake.Name
+	}
+	logger.Info("started", lager.Data{"name": client.name})
+	return client, nil
+}
+
+// Load starts every executable file in dir, in name order.  If any plugin fails to start, the ones already started
+// are killed.
+func Load(logger lager.Logger, dir string) ([]*Client, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := []*Client{}
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Mode()&0111 == 0 {
+			continue
+		}
+		client, err := Start(logger, filepath.Join(dir, entry.Name()))
+		if err != nil {
+			for _, started := range clients {
+				started.Kill()
+			}
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// Name is the name the plugin gave in its handshake, or its file name if it gave none.
+func (c *Client) Name() string {
+	return c.name
+}
+
+// SetRetryPolicy changes how Provision and Deprovision retry failed calls.  Clients retry with
+// backoff.DefaultPolicy until it is set.
+func (c *Client) SetRetryPolicy(clock clock.Clock, policy backoff.Policy) {
+	c.clock = clock
+	c.retryPolicy = policy
+}
+
+// Provision asks the plugin to create a share, retrying failed calls until the plugin exits or ctx is done.
+func (c *Client) Provision(ctx context.Context, request ProvisionRequest) error {
+	return c.retry(ctx, "Provision", request)
+}
+
+// Deprovision asks the plugin to remove a share, retrying failed calls like Provision.
+func (c *Client) Deprovision(ctx context.Context, request DeprovisionRequest) error {
+	return c.retry(ctx, "Deprovision", request)
+}
+
+// Kill asks the plugin to exit by closing its stdin, and kills it if it has not exited within a few seconds.
+func (c *Client) Kill() {
+	c.rpc.Close()
+	select {
+	case <-c.exited:
+	case <-time.After(5 * time.Second):
+		c.command.Process.Kill()
+		<-c.exited
+	}
+}
+
+func (c *Client) retry(ctx context.Context, method string, args interface{}) error {
+	logger := c.logger.Session("call", lager.Data{"method": method})
+	return backoff.Retry(ctx, logger, c.clock, c.retryPolicy, func(ctx context.Context) error {
+		err := c.call(ctx, method, args, &Empty{})
+		if err != nil && (ctx.Err() != nil || errors.Is(err, rpc.ErrShutdown) || c.hasExited()) {
+			return backoff.Permanent(err)
+		}
+		return err
+	})
+}
+
+func (c *Client) hasExited() bool {
+	select {
+	case <-c.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) call(ctx context.Context, method string, args, reply interface{}) error {
+	call := c.rpc.Go(serviceName+"."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if serverErr, ok := call.Error.(rpc.ServerError); ok {
+			return fmt.Errorf("plugin %s: %s", c.name, string(serverErr))
+		}
+		if call.Error != nil {
+			return fmt.Errorf("failed to call plugin %s: %w", c.name, call.Error)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to call plugin %s: %w", c.name, ctx.Err())
+	}
+}
+
+func logOutput(logger lager.Logger, output io.Reader) {
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		logger.Info("stderr", lager.Data{"line": scanner.Text()})
+	}
+}
+
+// pipe joins a plugin's stdout and stdin into a connection.
+type pipe struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+func (p pipe) Close() error {
+	err := p.WriteCloser.Close()
+	if readErr := p.ReadCloser.Close(); err == nil {
+		err = readErr
+	}
+	return err
+}
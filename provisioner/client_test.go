@@ -0,0 +1,137 @@
+package provisioner_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/backoff"
+	"code.cloudfoundry.org/nfsbroker/provisioner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		logger  *lagertest.TestLogger
+		tempDir string
+		logPath string
+		ctx     context.Context
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-provisioner")
+		var err error
+		tempDir, err = ioutil.TempDir("", "provisioner")
+		Expect(err).NotTo(HaveOccurred())
+		logPath = filepath.Join(tempDir, "requests.log")
+		os.Setenv("TEST_PROVISIONER_LOG", logPath)
+		ctx = context.TODO()
+	})
+
+	AfterEach(func() {
+		os.Unsetenv("TEST_PROVISIONER_LOG")
+		os.RemoveAll(tempDir)
+	})
+
+	Context("when the plugin is started", func() {
+		var client *provisioner.Client
+
+		BeforeEach(func() {
+			var err error
+			client, err = provisioner.Start(logger, pluginPath)
+			Expect(err).NotTo(HaveOccurred())
+			client.SetRetryPolicy(clock.NewClock(), backoff.Policy{InitialDelay: time.Millisecond, Multiplier: 2, MaxAttempts: 3})
+		})
+
+		AfterEach(func() {
+			client.Kill()
+		})
+
+		It("names the plugin after its handshake", func() {
+			Expect(client.Name()).To(Equal("test-provisioner"))
+		})
+
+		It("passes provision and deprovision requests to the plugin", func() {
+			share := provisioner.Share{Server: "filer", Path: "/export", Options: map[string]string{"uid": "1000"}}
+			Expect(client.Provision(ctx, provisioner.ProvisionRequest{InstanceID: "instance-id", OrganizationGUID: "org-guid", Share: share})).To(Succeed())
+			Expect(client.Deprovision(ctx, provisioner.DeprovisionRequest{InstanceID: "instance-id", Share: share})).To(Succeed())
+
+			requests, err := ioutil.ReadFile(logPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(requests)).To(ContainSubstring(`{"provision":{"instance_id":"instance-id","plan_id":"","organization_guid":"org-guid","space_guid":"","share":{"server":"filer","path":"/export","options":{"uid":"1000"}}}}`))
+			Expect(string(requests)).To(ContainSubstring(`{"deprovision":{"instance_id":"instance-id","plan_id":"","share":{"server":"filer","path":"/export","options":{"uid":"1000"}}}}`))
+		})
+
+		It("retries calls that fail", func() {
+			share := provisioner.Share{Server: "flaky-filer", Path: "/export"}
+			Expect(client.Provision(ctx, provisioner.ProvisionRequest{InstanceID: "instance-id", Share: share})).To(Succeed())
+			Expect(logger).To(gbytes.Say(`"attempt":2`))
+
+			requests, err := ioutil.ReadFile(logPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(requests)).To(ContainSubstring(`"server":"flaky-filer"`))
+		})
+
+		It("returns the plugin's errors once its retries are exhausted", func() {
+			err := client.Provision(ctx, provisioner.ProvisionRequest{Share: provisioner.Share{Server: "broken-filer"}})
+			Expect(err).To(MatchError("plugin test-provisioner: broken-filer is broken"))
+			Expect(logger).To(gbytes.Say(`"attempts":3`))
+		})
+
+		It("does not retry calls whose context is done", func() {
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			err := client.Provision(cancelled, provisioner.ProvisionRequest{Share: provisioner.Share{Server: "broken-filer"}})
+			Expect(err).To(HaveOccurred())
+			Expect(logger).NotTo(gbytes.Say("retrying"))
+		})
+
+		It("logs what the plugin writes to stderr", func() {
+			Expect(client.Provision(ctx, provisioner.ProvisionRequest{})).To(Succeed())
+			Eventually(logger).Should(gbytes.Say(`"line":"provision"`))
+		})
+
+		It("fails calls once the plugin has exited", func() {
+			client.Kill()
+			Expect(client.Provision(ctx, provisioner.ProvisionRequest{})).To(HaveOccurred())
+			Expect(logger).NotTo(gbytes.Say("retrying"))
+		})
+	})
+
+	It("fails to start executables that do not answer the handshake", func() {
+		_, err := provisioner.Start(logger, "/bin/cat")
+		Expect(err).To(MatchError(ContainSubstring("failed its handshake")))
+	})
+
+	Describe("Load", func() {
+		BeforeEach(func() {
+			data, err := ioutil.ReadFile(pluginPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "vendor-filer"), data, 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "README"), []byte("not a plugin"), 0644)).To(Succeed())
+		})
+
+		It("starts every executable in the directory", func() {
+			clients, err := provisioner.Load(logger, tempDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clients).To(HaveLen(1))
+			Expect(clients[0].Name()).To(Equal("test-provisioner"))
+			clients[0].Kill()
+		})
+	})
+
+	Describe("Serve", func() {
+		It("refuses to run when not started by the broker", func() {
+			session, err := exec.Command(pluginPath).CombinedOutput()
+			Expect(err).To(HaveOccurred())
+			Expect(string(session)).To(ContainSubstring("not meant to be run directly"))
+		})
+	})
+})
@@ -0,0 +1,56 @@
+// testprovisioner is a share provisioner plugin for tests.  It records each request in the file named by
+// TEST_PROVISIONER_LOG, fails to provision shares on the server "broken-filer", and fails the first two attempts to
+// provision each share on the server "flaky-filer".
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"code.cloudfoundry.org/nfsbroker/provisioner"
+)
+
+type testProvisioner struct {
+	mutex         sync.Mutex
+	flakyAttempts map[string]int
+}
+
+func (p *testProvisioner) Provision(request provisioner.ProvisionRequest) error {
+	switch request.Share.Server {
+	case "broken-filer":
+		return errors.New("broken-filer is broken")
+	case "flaky-filer":
+		p.mutex.Lock()
+		p.flakyAttempts[request.Share.Path]++
+		attempts := p.flakyAttempts[request.Share.Path]
+		p.mutex.Unlock()
+		if attempts <= 2 {
+			return errors.New("flaky-filer is busy")
+		}
+	}
+	return record("provision", request)
+}
+
+func (*testProvisioner) Deprovision(request provisioner.DeprovisionRequest) error {
+	return record("deprovision", request)
+}
+
+func record(kind string, request interface{}) error {
+	file, err := os.OpenFile(os.Getenv("TEST_PROVISIONER_LOG"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fmt.Fprintln(os.Stderr, kind)
+	return json.NewEncoder(file).Encode(map[string]interface{}{kind: request})
+}
+
+func main() {
+	if err := provisioner.Serve("test-provisioner", &testProvisioner{flakyAttempts: map[string]int{}}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,65 @@
+// Package provisioner is the contract between the broker and out-of-tree share provisioner plugins, which let storage
+// vendors create and remove shares on their filers without changes to the broker.
+//
+// A plugin is an executable that calls Serve with its ShareProvisioner.  The broker starts every plugin in its
+// plugins directory as a subprocess and calls it over JSON-RPC on the plugin's stdin and stdout; anything the plugin
+// writes to stderr is logged by the broker.  Every plugin is asked about every instance, and should succeed without
+// doing anything for shares on filers it does not manage.
+package provisioner
+
+import "errors"
+
+// ProtocolVersion is the version of the contract.  It changes only when a change would break existing plugins, and
+// the broker refuses to load plugins that speak another version.
+const ProtocolVersion = 1
+
+// MagicCookieKey and MagicCookieValue are set in the environment of plugins started by the broker, so that plugin
+// executables run by hand can explain that they are not meant to be.
+const (
+	MagicCookieKey   = "NFSBROKER_PLUGIN_MAGIC_COOKIE"
+	MagicCookieValue = "4b0a0bd3-ce3e-4f9b-8f4d-1a0e7c3b3d51"
+)
+
+// ErrNotLaunchedByBroker is returned by Serve when the plugin was not started by the broker.
+var ErrNotLaunchedByBroker = errors.New("this executable is a share provisioner plugin for nfsbroker and is not meant to be run directly")
+
+// ShareProvisioner creates and removes the shares of service instances.  Both methods must be idempotent, as the
+// broker may repeat calls after failures.
+type ShareProvisioner interface {
+	Provision(request ProvisionRequest) error
+	Deprovision(request DeprovisionRequest) error
+}
+
+// Share is the share a service instance offers.  Server is empty if the broker could not split the share into a
+// server and path, in which case Path is the whole share.
+type Share struct {
+	Server  string            `json:"server"`
+	Path    string            `json:"path"`
+	Version string            `json:"version,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// ProvisionRequest asks a plugin to make a new service instance's share usable.
+type ProvisionRequest struct {
+	InstanceID       string `json:"instance_id"`
+	PlanID           string `json:"plan_id"`
+	OrganizationGUID string `json:"organization_guid"`
+	SpaceGUID        string `json:"space_guid"`
+	Share            Share  `json:"share"`
+}
+
+// DeprovisionRequest asks a plugin to remove a service instance's share, or whatever it created for it.
+type DeprovisionRequest struct {
+	InstanceID string `json:"instance_id"`
+	PlanID     string `json:"plan_id"`
+	Share      Share  `json:"share"`
+}
+
+// HandshakeResponse identifies a plugin to the broker.
+type HandshakeResponse struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	Name            string `json:"name"`
+}
+
+// Empty is the reply of calls that only return an error.
+type Empty struct{}
@@ -0,0 +1,28 @@
+package provisioner_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+
+	"testing"
+)
+
+var pluginPath string
+
+func TestProvisioner(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Provisioner Suite")
+}
+
+var _ = SynchronizedBeforeSuite(func() []byte {
+	path, err := gexec.Build("code.cloudfoundry.org/nfsbroker/provisioner/fixtures/testprovisioner")
+	Expect(err).NotTo(HaveOccurred())
+	return []byte(path)
+}, func(path []byte) {
+	pluginPath = string(path)
+})
+
+var _ = SynchronizedAfterSuite(func() {}, func() {
+	gexec.CleanupBuildArtifacts()
+})
@@ -0,0 +1,54 @@
+package provisioner
+
+import (
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+)
+
+// serviceName is the name the plugin's RPC methods are registered under.
+const serviceName = "ShareProvisioner"
+
+// Serve answers the broker's calls to provisioner on stdin and stdout until the broker closes stdin.  name identifies
+// the plugin in the broker's logs.
+func Serve(name string, provisioner ShareProvisioner) error {
+	if os.Getenv(MagicCookieKey) != MagicCookieValue {
+		return ErrNotLaunchedByBroker
+	}
+	return serveConn(name, provisioner, stdio{})
+}
+
+func serveConn(name string, provisioner ShareProvisioner, conn io.ReadWriteCloser) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName(serviceName, &rpcServer{name: name, provisioner: provisioner}); err != nil {
+		return err
+	}
+	server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	return nil
+}
+
+// rpcServer adapts a ShareProvisioner to net/rpc's method conventions.
+type rpcServer struct {
+	name        string
+	provisioner ShareProvisioner
+}
+
+func (s *rpcServer) Handshake(_ Empty, response *HandshakeResponse) error {
+	*response = HandshakeResponse{ProtocolVersion: ProtocolVersion, Name: s.name}
+	return nil
+}
+
+func (s *rpcServer) Provision(request ProvisionRequest, _ *Empty) error {
+	return s.provisioner.Provision(request)
+}
+
+func (s *rpcServer) Deprovision(request DeprovisionRequest, _ *Empty) error {
+	return s.provisioner.Deprovision(request)
+}
+
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (stdio) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (stdio) Close() error                { return os.Stdout.Close() }
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/nfsbroker/credhub"
+	"code.cloudfoundry.org/nfsbroker/nfsbroker"
+)
+
+// settingsReloader reloads the settings that can change while the broker runs whenever it receives SIGHUP: the
+// service catalog, the mount options bindings are allowed and given by default, the broker's credentials and the log
+// level.  Settings are read again from -configPath and -catalogPath, except for those given on the command line or in
+// the environment.  A reload that fails changes nothing but the settings it reloaded before failing, and can be
+// retried.  SIGHUP also rotates database credentials; see sqlStoreRotations.
+type settingsReloader struct {
+	logger        lager.Logger
+	logSink       *lager.ReconfigurableSink
+	brokers       []*nfsbroker.Broker
+	credentials   *brokerCredentials
+	credhubClient *credhub.Client
+}
+
+func (r *settingsReloader) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	defer signal.Stop(reloadSignals)
+	close(ready)
+
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-reloadSignals:
+			r.Reload()
+		}
+	}
+}
+
+// Reload reloads the settings, logging what changed, and logging rather than returning failures.
+func (r *settingsReloader) Reload() {
+	logger := r.logger.Session("reload-settings")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := r.reload(logger); err != nil {
+		logger.Error("failed-to-reload-settings", err)
+	}
+}
+
+func (r *settingsReloader) reload(logger lager.Logger) error {
+	flags, catalog, err := reloadedFlags()
+	if err != nil {
+		return err
+	}
+	setting := func(name string) string {
+		return flags.Lookup(name).Value.String()
+	}
+
+	if path := setting("catalogPath"); path != "" {
+		if catalog, err = ioutil.ReadFile(path); err != nil {
+			return fmt.Errorf("failed to read catalog: %w", err)
+		}
+	}
+	if catalog != nil {
+		services, err := nfsbroker.ParseCatalog(catalog)
+		if err != nil {
+			return err
+		}
+		for _, broker := range r.brokers {
+			changes, err := broker.ReloadCatalogServices(services)
+			if err != nil {
+				return err
+			}
+			if broker == r.brokers[0] {
+				for _, change := range changes {
+					logger.Info("reloaded-catalog", lager.Data{"change": change})
+				}
+			}
+		}
+	} else if catalogData != nil || *catalogPath != "" {
+		return fmt.Errorf("the catalog cannot be removed without a restart")
+	}
+
+	mounts := nfsbroker.NewNfsBrokerConfigDetails()
+	mounts.ReadConf(setting("allowedOptions"), setting("defaultOptions"))
+	changed := false
+	for _, broker := range r.brokers {
+		changed = broker.ReloadMountOptions(mounts) || changed
+	}
+	if changed {
+		logger.Info("reloaded-mount-options", lager.Data{"allowedOptions": setting("allowedOptions"), "defaultOptions": setting("defaultOptions")})
+	}
+
+	level, err := lager.LogLevelFromString(setting("logLevel"))
+	if err != nil {
+		return err
+	}
+	if previous := r.logSink.GetMinLevel(); level != previous {
+		r.logSink.SetMinLevel(level)
+		logger.Info("reloaded-log-level", lager.Data{"from": previous.String(), "to": level.String()})
+	}
+
+	if changed, err := r.credentials.reload(r.credhubClient); err != nil {
+		return fmt.Errorf("failed to resolve credentials: %w", err)
+	} else if changed {
+		logger.Info("reloaded-credentials")
+	}
+	return nil
+}
+
+// reloadedFlags returns the flags as they would be were the broker started again, along with the catalog in the
+// config file, if any: flags given on the command line or in the environment keep their values, and the rest are read
+// from the config file again.  The returned flags hold their values as strings.
+func reloadedFlags() (*flag.FlagSet, []byte, error) {
+	flags := flag.NewFlagSet("reload", flag.ContinueOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		value := f.DefValue
+		if givenFlags[f.Name] {
+			value = f.Value.String()
+		}
+		flags.String(f.Name, value, f.Usage)
+	})
+	if *configPath == "" {
+		return flags, nil, nil
+	}
+	catalog, err := loadConfigFile(flags, *configPath, givenFlags)
+	return flags, catalog, err
+}
+
+// brokerCredentials are the default foundation's credentials.  Those in the environment are resolved in CredHub again
+// on reload, so that they can be rotated there, and those in -usernameFile and -passwordFile are read again whenever
+// the files change.
+type brokerCredentials struct {
+	mutex       sync.RWMutex
+	credentials []nfsbroker.BrokerCredential
+
+	// username and password read the secret files, if the credentials are given in files.
+	username, password func() string
+}
+
+func newBrokerCredentials(logger lager.Logger) *brokerCredentials {
+	credentials := &brokerCredentials{
+		credentials: append([]nfsbroker.BrokerCredential{{Username: username, Password: password}}, extraCredentials...),
+	}
+	if *usernameFile != "" {
+		credentials.username = secretValue(logger, *usernameFile, "")
+	}
+	if *passwordFile != "" {
+		credentials.password = secretValue(logger, *passwordFile, "")
+	}
+	return credentials
+}
+
+func (c *brokerCredentials) Credentials() []nfsbroker.BrokerCredential {
+	c.mutex.RLock()
+	credentials := append([]nfsbroker.BrokerCredential{}, c.credentials...)
+	c.mutex.RUnlock()
+
+	if c.username != nil {
+		credentials[0].Username = c.username()
+	}
+	if c.password != nil {
+		credentials[0].Password = c.password()
+	}
+	return credentials
+}
+
+// reload resolves the credentials in the environment in CredHub again, and reports whether they changed.  Without
+// CredHub they cannot change.
+func (c *brokerCredentials) reload(credhubClient *credhub.Client) (bool, error) {
+	if credhubClient == nil {
+		return false, nil
+	}
+
+	credentials := []nfsbroker.BrokerCredential{}
+	for i := 1; ; i++ {
+		suffix := ""
+		if i > 1 {
+			suffix = fmt.Sprintf("_%d", i)
+		}
+		username, ok := os.LookupEnv("USERNAME" + suffix)
+		if !ok && i > 1 {
+			break
+		}
+		username, err := credhubClient.Resolve(context.Background(), username)
+		if err != nil {
+			return false, err
+		}
+		password, err := credhubClient.Resolve(context.Background(), os.Getenv("PASSWORD"+suffix))
+		if err != nil {
+			return false, err
+		}
+		credentials = append(credentials, nfsbroker.BrokerCredential{Username: username, Password: password})
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	changed := !reflect.DeepEqual(credentials, c.credentials)
+	c.credentials = credentials
+	return changed, nil
+}
@@ -0,0 +1,231 @@
+// Package scheduler runs the broker's periodic jobs.  Each job's next run time is persisted so that restarts do
+// not reset its schedule, and a job never overlaps with its own previous run.
+package scheduler
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/nfsbroker/backoff"
+)
+
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+//go:generate counterfeiter -o ../schedulerfakes/fake_state_store.go . StateStore
+type StateStore interface {
+	// RetrieveJobNextRun returns the zero time for jobs that have never been scheduled.
+	RetrieveJobNextRun(ctx context.Context, name string) (time.Time, error)
+	SaveJobNextRun(ctx context.Context, name string, next time.Time) error
+}
+
+// Leadership reports whether this broker instance should run jobs.  Instances that are not the leader keep their
+// schedule without running or persisting it.
+type Leadership interface {
+	IsLeader() bool
+}
+
+type JobStats struct {
+	Runs            int
+	Failures        int
+	SkippedOverlaps int
+	Running         bool
+	NextRun         time.Time
+	LastRun         time.Time
+	LastDuration    time.Duration
+	LastError       string
+}
+
+type Scheduler struct {
+	logger lager.Logger
+	clock  clock.Clock
+	store  StateStore
+	jobs   []Job
+
+	leadership  Leadership
+	retryPolicy backoff.Policy
+
+	mutex sync.Mutex
+	stats map[string]*JobStats
+	wg    sync.WaitGroup
+}
+
+func New(logger lager.Logger, clock clock.Clock, store StateStore, jobs []Job) *Scheduler {
+	stats := map[string]*JobStats{}
+	for _, job := range jobs {
+		stats[job.Name] = &JobStats{}
+	}
+	return &Scheduler{
+		logger:      logger,
+		clock:       clock,
+		store:       store,
+		jobs:        jobs,
+		retryPolicy: backoff.DefaultPolicy,
+		stats:       stats,
+	}
+}
+
+// RequireLeadership runs jobs only while leadership reports that this instance is the leader.
+func (s *Scheduler) RequireLeadership(leadership Leadership) {
+	s.leadership = leadership
+}
+
+// SetRetryPolicy changes how a failed run is retried before it counts as a failure.  Runs are retried with
+// backoff.DefaultPolicy until it is set.
+func (s *Scheduler) SetRetryPolicy(policy backoff.Policy) {
+	s.retryPolicy = policy
+}
+
+// Stats returns a snapshot of each job's run history.
+func (s *Scheduler) Stats() map[string]JobStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats := map[string]JobStats{}
+	for name, jobStats := range s.stats {
+		stats[name] = *jobStats
+	}
+	return stats
+}
+
+func (s *Scheduler) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		s.wg.Wait()
+	}()
+
+	for _, job := range s.jobs {
+		s.setNextRun(job.Name, s.initialNextRun(ctx, job))
+	}
+	close(ready)
+
+	if len(s.jobs) == 0 {
+		<-signals
+		return nil
+	}
+
+	for {
+		timer := s.clock.NewTimer(s.untilNextRun())
+		select {
+		case <-signals:
+			timer.Stop()
+			return nil
+		case <-timer.C():
+			s.startDueJobs(ctx)
+		}
+	}
+}
+
+func (s *Scheduler) initialNextRun(ctx context.Context, job Job) time.Time {
+	next, err := s.store.RetrieveJobNextRun(ctx, job.Name)
+	if err != nil {
+		s.logger.Error("failed-to-retrieve-next-run", err, lager.Data{"job": job.Name})
+	}
+	if next.IsZero() {
+		next = s.clock.Now().Add(job.Interval)
+	}
+	return next
+}
+
+func (s *Scheduler) untilNextRun() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var earliest time.Time
+	for _, jobStats := range s.stats {
+		if earliest.IsZero() || jobStats.NextRun.Before(earliest) {
+			earliest = jobStats.NextRun
+		}
+	}
+	wait := earliest.Sub(s.clock.Now())
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+func (s *Scheduler) startDueJobs(ctx context.Context) {
+	now := s.clock.Now()
+	if s.leadership != nil && !s.leadership.IsLeader() {
+		s.logger.Debug("not-leader")
+		s.mutex.Lock()
+		for _, job := range s.jobs {
+			if jobStats := s.stats[job.Name]; !jobStats.NextRun.After(now) {
+				jobStats.NextRun = now.Add(job.Interval)
+			}
+		}
+		s.mutex.Unlock()
+		return
+	}
+
+	for _, job := range s.jobs {
+		s.mutex.Lock()
+		jobStats := s.stats[job.Name]
+		if jobStats.NextRun.After(now) {
+			s.mutex.Unlock()
+			continue
+		}
+
+		next := now.Add(job.Interval)
+		jobStats.NextRun = next
+		overlapping := jobStats.Running
+		if overlapping {
+			jobStats.SkippedOverlaps++
+		} else {
+			jobStats.Running = true
+		}
+		s.mutex.Unlock()
+
+		logger := s.logger.Session("job", lager.Data{"job": job.Name})
+		if err := s.store.SaveJobNextRun(ctx, job.Name, next); err != nil {
+			logger.Error("failed-to-save-next-run", err)
+		}
+		if overlapping {
+			logger.Info("skipped-overlapping-run")
+			continue
+		}
+
+		s.wg.Add(1)
+		go s.runJob(ctx, logger, job)
+	}
+}
+
+func (s *Scheduler) runJob(ctx context.Context, logger lager.Logger, job Job) {
+	defer s.wg.Done()
+
+	logger.Info("start")
+	started := s.clock.Now()
+	err := backoff.Retry(ctx, logger, s.clock, s.retryPolicy, job.Run)
+	duration := s.clock.Since(started)
+	if err != nil {
+		logger.Error("failed", err)
+	}
+	logger.Info("end", lager.Data{"duration": duration.String()})
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	jobStats := s.stats[job.Name]
+	jobStats.Running = false
+	jobStats.Runs++
+	jobStats.LastRun = started
+	jobStats.LastDuration = duration
+	jobStats.LastError = ""
+	if err != nil {
+		jobStats.Failures++
+		jobStats.LastError = err.Error()
+	}
+}
+
+func (s *Scheduler) setNextRun(name string, next time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stats[name].NextRun = next
+}
@@ -0,0 +1,191 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/backoff"
+	"code.cloudfoundry.org/nfsbroker/scheduler"
+	"code.cloudfoundry.org/nfsbroker/schedulerfakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tedsuo/ifrit"
+)
+
+var _ = Describe("Scheduler", func() {
+	var (
+		fakeClock *fakeclock.FakeClock
+		fakeStore *schedulerfakes.FakeStateStore
+		start     time.Time
+		runs      int32
+		release   chan struct{}
+		jobErr    error
+		failFirst int32
+		sched     *scheduler.Scheduler
+		process   ifrit.Process
+		leader    *fakeLeadership
+	)
+
+	BeforeEach(func() {
+		start = time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+		fakeClock = fakeclock.NewFakeClock(start)
+		fakeStore = &schedulerfakes.FakeStateStore{}
+		runs = 0
+		release = nil
+		jobErr = nil
+		failFirst = 0
+		leader = nil
+	})
+
+	JustBeforeEach(func() {
+		job := scheduler.Job{
+			Name:     "some-job",
+			Interval: time.Minute,
+			Run: func(ctx context.Context) error {
+				attempt := atomic.AddInt32(&runs, 1)
+				if release != nil {
+					<-release
+				}
+				if attempt <= failFirst {
+					return errors.New("job failed")
+				}
+				return jobErr
+			},
+		}
+		sched = scheduler.New(lagertest.NewTestLogger("test-scheduler"), fakeClock, fakeStore, []scheduler.Job{job})
+		sched.SetRetryPolicy(backoff.Policy{InitialDelay: time.Second, Multiplier: 2, MaxAttempts: 3})
+		if leader != nil {
+			sched.RequireLeadership(leader)
+		}
+		process = ifrit.Invoke(sched)
+	})
+
+	AfterEach(func() {
+		if release != nil {
+			close(release)
+		}
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+	})
+
+	runCount := func() int32 { return atomic.LoadInt32(&runs) }
+
+	// retryAfter waits for a failed run to start waiting to retry, alongside the schedule's own timer.
+	retryAfter := func(delay time.Duration) {
+		Eventually(fakeClock.WatcherCount).Should(Equal(2))
+		fakeClock.Increment(delay)
+	}
+
+	It("waits a full interval before the first run of a new job", func() {
+		fakeClock.WaitForWatcherAndIncrement(59 * time.Second)
+		Consistently(runCount).Should(BeZero())
+		fakeClock.Increment(time.Second)
+		Eventually(runCount).Should(Equal(int32(1)))
+	})
+
+	It("persists the next run time", func() {
+		fakeClock.WaitForWatcherAndIncrement(time.Minute)
+		Eventually(fakeStore.SaveJobNextRunCallCount).Should(Equal(1))
+		_, name, next := fakeStore.SaveJobNextRunArgsForCall(0)
+		Expect(name).To(Equal("some-job"))
+		Expect(next).To(Equal(start.Add(2 * time.Minute)))
+	})
+
+	It("records run statistics", func() {
+		fakeClock.WaitForWatcherAndIncrement(time.Minute)
+		Eventually(func() int { return sched.Stats()["some-job"].Runs }).Should(Equal(1))
+		Expect(sched.Stats()["some-job"].Failures).To(Equal(0))
+	})
+
+	Context("when the store has a next run time", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveJobNextRunReturns(start.Add(10*time.Second), nil)
+		})
+
+		It("resumes the persisted schedule", func() {
+			fakeClock.WaitForWatcherAndIncrement(10 * time.Second)
+			Eventually(runCount).Should(Equal(int32(1)))
+		})
+	})
+
+	Context("when the job fails", func() {
+		BeforeEach(func() {
+			jobErr = errors.New("job failed")
+		})
+
+		It("records the failure once its retries are exhausted and keeps the schedule", func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			retryAfter(time.Second)
+			retryAfter(2 * time.Second)
+			Eventually(func() int { return sched.Stats()["some-job"].Failures }).Should(Equal(1))
+			Expect(runCount()).To(Equal(int32(3)))
+			Expect(sched.Stats()["some-job"].Runs).To(Equal(1))
+			Expect(sched.Stats()["some-job"].LastError).To(Equal("job failed"))
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Eventually(runCount).Should(Equal(int32(4)))
+		})
+	})
+
+	Context("when the job fails and then succeeds", func() {
+		BeforeEach(func() {
+			failFirst = 2
+		})
+
+		It("retries it with backoff", func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Eventually(runCount).Should(Equal(int32(1)))
+			retryAfter(999 * time.Millisecond)
+			Consistently(runCount).Should(Equal(int32(1)))
+			fakeClock.Increment(time.Millisecond)
+			Eventually(runCount).Should(Equal(int32(2)))
+			retryAfter(2 * time.Second)
+			Eventually(func() int { return sched.Stats()["some-job"].Runs }).Should(Equal(1))
+			Expect(runCount()).To(Equal(int32(3)))
+			Expect(sched.Stats()["some-job"].Failures).To(Equal(0))
+			Expect(sched.Stats()["some-job"].LastError).To(BeEmpty())
+		})
+	})
+
+	Context("when leadership is required", func() {
+		BeforeEach(func() {
+			leader = &fakeLeadership{}
+		})
+
+		It("runs jobs only while this instance is the leader", func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Consistently(runCount).Should(BeZero())
+			Expect(fakeStore.SaveJobNextRunCallCount()).To(Equal(0))
+
+			atomic.StoreInt32(&leader.leader, 1)
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Eventually(runCount).Should(Equal(int32(1)))
+		})
+	})
+
+	Context("when a run is still going at the next interval", func() {
+		BeforeEach(func() {
+			release = make(chan struct{})
+		})
+
+		It("skips the overlapping run", func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Eventually(runCount).Should(Equal(int32(1)))
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Eventually(func() int { return sched.Stats()["some-job"].SkippedOverlaps }).Should(Equal(1))
+			Expect(runCount()).To(Equal(int32(1)))
+		})
+	})
+})
+
+type fakeLeadership struct {
+	leader int32
+}
+
+func (l *fakeLeadership) IsLeader() bool {
+	return atomic.LoadInt32(&l.leader) == 1
+}
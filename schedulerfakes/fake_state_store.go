@@ -0,0 +1,103 @@
+// This file was generated by counterfeiter
+package schedulerfakes
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/nfsbroker/scheduler"
+)
+
+type FakeStateStore struct {
+	RetrieveJobNextRunStub        func(ctx context.Context, name string) (time.Time, error)
+	retrieveJobNextRunMutex       sync.RWMutex
+	retrieveJobNextRunArgsForCall []struct {
+		ctx  context.Context
+		name string
+	}
+	retrieveJobNextRunReturns struct {
+		result1 time.Time
+		result2 error
+	}
+	SaveJobNextRunStub        func(ctx context.Context, name string, next time.Time) error
+	saveJobNextRunMutex       sync.RWMutex
+	saveJobNextRunArgsForCall []struct {
+		ctx  context.Context
+		name string
+		next time.Time
+	}
+	saveJobNextRunReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeStateStore) RetrieveJobNextRun(ctx context.Context, name string) (time.Time, error) {
+	fake.retrieveJobNextRunMutex.Lock()
+	fake.retrieveJobNextRunArgsForCall = append(fake.retrieveJobNextRunArgsForCall, struct {
+		ctx  context.Context
+		name string
+	}{ctx, name})
+	fake.retrieveJobNextRunMutex.Unlock()
+	if fake.RetrieveJobNextRunStub != nil {
+		return fake.RetrieveJobNextRunStub(ctx, name)
+	} else {
+		return fake.retrieveJobNextRunReturns.result1, fake.retrieveJobNextRunReturns.result2
+	}
+}
+
+func (fake *FakeStateStore) RetrieveJobNextRunCallCount() int {
+	fake.retrieveJobNextRunMutex.RLock()
+	defer fake.retrieveJobNextRunMutex.RUnlock()
+	return len(fake.retrieveJobNextRunArgsForCall)
+}
+
+func (fake *FakeStateStore) RetrieveJobNextRunArgsForCall(i int) (context.Context, string) {
+	fake.retrieveJobNextRunMutex.RLock()
+	defer fake.retrieveJobNextRunMutex.RUnlock()
+	return fake.retrieveJobNextRunArgsForCall[i].ctx, fake.retrieveJobNextRunArgsForCall[i].name
+}
+
+func (fake *FakeStateStore) RetrieveJobNextRunReturns(result1 time.Time, result2 error) {
+	fake.RetrieveJobNextRunStub = nil
+	fake.retrieveJobNextRunReturns = struct {
+		result1 time.Time
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStateStore) SaveJobNextRun(ctx context.Context, name string, next time.Time) error {
+	fake.saveJobNextRunMutex.Lock()
+	fake.saveJobNextRunArgsForCall = append(fake.saveJobNextRunArgsForCall, struct {
+		ctx  context.Context
+		name string
+		next time.Time
+	}{ctx, name, next})
+	fake.saveJobNextRunMutex.Unlock()
+	if fake.SaveJobNextRunStub != nil {
+		return fake.SaveJobNextRunStub(ctx, name, next)
+	} else {
+		return fake.saveJobNextRunReturns.result1
+	}
+}
+
+func (fake *FakeStateStore) SaveJobNextRunCallCount() int {
+	fake.saveJobNextRunMutex.RLock()
+	defer fake.saveJobNextRunMutex.RUnlock()
+	return len(fake.saveJobNextRunArgsForCall)
+}
+
+func (fake *FakeStateStore) SaveJobNextRunArgsForCall(i int) (context.Context, string, time.Time) {
+	fake.saveJobNextRunMutex.RLock()
+	defer fake.saveJobNextRunMutex.RUnlock()
+	return fake.saveJobNextRunArgsForCall[i].ctx, fake.saveJobNextRunArgsForCall[i].name, fake.saveJobNextRunArgsForCall[i].next
+}
+
+func (fake *FakeStateStore) SaveJobNextRunReturns(result1 error) {
+	fake.SaveJobNextRunStub = nil
+	fake.saveJobNextRunReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ scheduler.StateStore = new(FakeStateStore)
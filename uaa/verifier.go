@@ -0,0 +1,322 @@
+// Package uaa authenticates broker clients by the access tokens UAA issues them, checking each token's signature
+// against the UAA's published keys, its issuer, audience and scopes, and its validity period.
+package uaa
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/nfsbroker/validity"
+)
+
+// keyRefreshInterval limits how often tokens signed with unknown keys make the verifier fetch the UAA's keys again.
+const keyRefreshInterval = time.Minute
+
+// ErrInvalidToken is wrapped by the errors of tokens that are refused.
+var ErrInvalidToken = errors.New("invalid token")
+
+var signingHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+// Config describes the UAA that issues tokens and what the tokens must grant.
+type Config struct {
+	// URL is the UAA's base URL, such as https://uaa.example.com.  Tokens must name its token endpoint as their
+	// issuer, and its /token_keys endpoint serves the keys they are signed with.
+	URL string
+
+	// Audience, when given, must be one of the token's audiences.
+	Audience string
+
+	// Scopes must all be granted by the token.
+	Scopes []string
+}
+
+// Claims are the parts of a verified token the broker uses.
+type Claims struct {
+	Subject  string   `json:"sub"`
+	ClientID string   `json:"client_id"`
+	UserName string   `json:"user_name"`
+	Scopes   []string `json:"scope"`
+}
+
+type claims struct {
+	Claims
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+}
+
+// audience reads the aud claim, which may be a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// Verifier verifies UAA access tokens.  It fetches the UAA's signing keys when it first needs them and again when a
+// token is signed with a key it does not know, so that keys the UAA rotates in are picked up.
+type Verifier struct {
+	config     Config
+	issuer     string
+	keysURL    string
+	httpClient *http.Client
+	clock      clock.Clock
+	validity   validity.Checker
+	logger     lager.Logger
+
+	mutex     sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewVerifier returns a verifier of tokens issued by the UAA in config, which forgives tolerance of clock skew
+// between the broker's VM and the UAA's.
+func NewVerifier(config Config, httpClient *http.Client, clock clock.Clock, tolerance time.Duration, logger lager.Logger) *Verifier {
+	url := strings.TrimRight(config.URL, "/")
+	return &Verifier{
+		config:     config,
+		issuer:     url + "/oauth/token",
+		keysURL:    url + "/token_keys",
+		httpClient: httpClient,
+		clock:      clock,
+		validity:   validity.NewChecker(clock, tolerance, logger),
+		logger:     logger,
+	}
+}
+
+// Verify returns the claims of token, or an error wrapping ErrInvalidToken that says why it is refused.  Errors
+// fetching the UAA's keys are returned as they are.
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, invalid("it is not a signed JWT")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, invalid("its header is malformed: %s", err)
+	}
+	hash, ok := signingHashes[header.Algorithm]
+	if !ok {
+		return Claims{}, invalid("it is signed with %q, not RS256, RS384 or RS512", header.Algorithm)
+	}
+	key, err := v.key(ctx, header.KeyID)
+	if err != nil {
+		return Claims{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, invalid("its signature is malformed")
+	}
+	digest := hash.New()
+	digest.Write([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, hash, digest.Sum(nil), signature); err != nil {
+		return Claims{}, invalid("its signature does not match key %q", header.KeyID)
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return Claims{}, invalid("its claims are malformed: %s", err)
+	}
+	if c.Issuer != v.issuer {
+		return Claims{}, invalid("it was issued by %q, not %q", c.Issuer, v.issuer)
+	}
+	if v.config.Audience != "" && !contains(c.Audience, v.config.Audience) {
+		return Claims{}, invalid("it is not for audience %q", v.config.Audience)
+	}
+	missing := []string{}
+	for _, scope := range v.config.Scopes {
+		if !contains(c.Scopes, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		return Claims{}, invalid("it does not grant scopes %s", strings.Join(missing, ", "))
+	}
+	if c.ExpiresAt == 0 {
+		return Claims{}, invalid("it does not expire")
+	}
+	if err := v.validity.Check("token", unixTime(c.NotBefore), unixTime(c.ExpiresAt)); err != nil {
+		return Claims{}, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	return c.Claims, nil
+}
+
+// key returns the key with the given ID, fetching the UAA's keys if it is not known.  Tokens without a key ID are
+// verified with the UAA's only key.
+func (v *Verifier) key(ctx context.Context, id string) (*rsa.PublicKey, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if key, ok := v.knownKey(id); ok {
+		return key, nil
+	}
+	if v.keys != nil && v.clock.Since(v.fetchedAt) < keyRefreshInterval {
+		return nil, invalid("it is signed with unknown key %q", id)
+	}
+	keys, err := v.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v.keys = keys
+	v.fetchedAt = v.clock.Now()
+	v.logger.Info("fetched-token-keys", lager.Data{"keys": len(keys)})
+
+	if key, ok := v.knownKey(id); ok {
+		return key, nil
+	}
+	return nil, invalid("it is signed with unknown key %q", id)
+}
+
+func (v *Verifier) knownKey(id string) (*rsa.PublicKey, bool) {
+	if id == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	key, ok := v.keys[id]
+	return key, ok
+}
+
+// fetchKeys fetches the UAA's RSA signing keys, by key ID.
+func (v *Verifier) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequest("GET", v.keysURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := v.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the UAA's token keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch the UAA's token keys: %s returned %d", v.keysURL, resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Type     string `json:"kty"`
+			ID       string `json:"kid"`
+			Use      string `json:"use"`
+			Modulus  string `json:"n"`
+			Exponent string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to parse the UAA's token keys: %w", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Type != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.Modulus)
+		if err != nil {
+			return nil, fmt.Errorf("token key %q has a malformed modulus", jwk.ID)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.Exponent)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("token key %q has a malformed exponent", jwk.ID)
+		}
+		keys[jwk.ID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+// Wrap serves requests with a valid bearer token with handler.  Requests without a bearer token are served by
+// otherwise, such as handler behind basic auth, or refused when it is nil.
+func (v *Verifier) Wrap(handler, otherwise http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(strings.ToLower(authorization), "bearer ") {
+			if otherwise != nil {
+				otherwise.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "a bearer token is required")
+			return
+		}
+
+		claims, err := v.Verify(r.Context(), strings.TrimSpace(authorization[len("bearer "):]))
+		if errors.Is(err, ErrInvalidToken) {
+			v.logger.Info("token-refused", lager.Data{"error": err.Error()})
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			v.logger.Error("failed-to-verify-token", err)
+			writeError(w, http.StatusServiceUnavailable, "tokens cannot be verified right now")
+			return
+		}
+		v.logger.Debug("token-verified", lager.Data{"subject": claims.Subject, "client_id": claims.ClientID})
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"description": description})
+}
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidToken, fmt.Sprintf(format, args...))
+}
+
+func decodeSegment(segment string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func unixTime(seconds int64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+package uaa_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/uaa"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Verifier", func() {
+	var (
+		server    *ghttp.Server
+		key       *rsa.PrivateKey
+		keyID     string
+		fakeClock *fakeclock.FakeClock
+		verifier  *uaa.Verifier
+		claims    map[string]interface{}
+		ctx       context.Context
+	)
+
+	serveKeys := func(keys ...*rsa.PrivateKey) {
+		set := []map[string]string{}
+		for i, key := range keys {
+			id := keyID
+			if i > 0 {
+				id = keyID + "-old"
+			}
+			set = append(set, map[string]string{
+				"kty": "RSA",
+				"kid": id,
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		server.RouteToHandler("GET", "/token_keys", ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"keys": set}))
+	}
+
+	sign := func(header map[string]interface{}, key *rsa.PrivateKey) string {
+		headerJSON, err := json.Marshal(header)
+		Expect(err).NotTo(HaveOccurred())
+		claimsJSON, err := json.Marshal(claims)
+		Expect(err).NotTo(HaveOccurred())
+		signed := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+		digest := sha256.Sum256([]byte(signed))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		Expect(err).NotTo(HaveOccurred())
+		return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+
+	token := func() string {
+		return sign(map[string]interface{}{"alg": "RS256", "kid": keyID, "typ": "JWT"}, key)
+	}
+
+	BeforeEach(func() {
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		keyID = "key-1"
+
+		server = ghttp.NewServer()
+		serveKeys(key)
+		now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+		fakeClock = fakeclock.NewFakeClock(now)
+		verifier = uaa.NewVerifier(uaa.Config{
+			URL:      server.URL() + "/",
+			Audience: "nfsbroker",
+			Scopes:   []string{"nfsbroker.admin"},
+		}, http.DefaultClient, fakeClock, 30*time.Second, lagertest.NewTestLogger("test-uaa"))
+		claims = map[string]interface{}{
+			"iss":       server.URL() + "/oauth/token",
+			"aud":       []string{"cloud_controller_service_broker", "nfsbroker"},
+			"scope":     []string{"nfsbroker.admin", "uaa.none"},
+			"sub":       "cloud_controller",
+			"client_id": "cloud_controller",
+			"exp":       now.Add(10 * time.Minute).Unix(),
+			"iat":       now.Add(-time.Minute).Unix(),
+		}
+		ctx = context.Background()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("returns the claims of valid tokens", func() {
+		verified, err := verifier.Verify(ctx, token())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(verified.ClientID).To(Equal("cloud_controller"))
+		Expect(verified.Scopes).To(ConsistOf("nfsbroker.admin", "uaa.none"))
+	})
+
+	It("accepts a single audience as a string", func() {
+		claims["aud"] = "nfsbroker"
+		_, err := verifier.Verify(ctx, token())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("fetches the keys once", func() {
+		for i := 0; i < 3; i++ {
+			_, err := verifier.Verify(ctx, token())
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+	})
+
+	It("refuses tokens from another issuer", func() {
+		claims["iss"] = "https://uaa.example.com/oauth/token"
+		_, err := verifier.Verify(ctx, token())
+		Expect(errors.Is(err, uaa.ErrInvalidToken)).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring(`it was issued by "https://uaa.example.com/oauth/token"`)))
+	})
+
+	It("refuses tokens for another audience", func() {
+		claims["aud"] = []string{"cloud_controller"}
+		_, err := verifier.Verify(ctx, token())
+		Expect(err).To(MatchError(ContainSubstring(`it is not for audience "nfsbroker"`)))
+	})
+
+	It("refuses tokens without the scopes", func() {
+		claims["scope"] = []string{"uaa.none"}
+		_, err := verifier.Verify(ctx, token())
+		Expect(err).To(MatchError(ContainSubstring("it does not grant scopes nfsbroker.admin")))
+	})
+
+	It("refuses expired tokens beyond the tolerated skew", func() {
+		fakeClock.Increment(10*time.Minute + 10*time.Second)
+		_, err := verifier.Verify(ctx, token())
+		Expect(err).NotTo(HaveOccurred())
+
+		fakeClock.Increment(time.Minute)
+		_, err = verifier.Verify(ctx, token())
+		Expect(errors.Is(err, uaa.ErrInvalidToken)).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("token expired at")))
+	})
+
+	It("refuses tokens that do not expire", func() {
+		delete(claims, "exp")
+		_, err := verifier.Verify(ctx, token())
+		Expect(err).To(MatchError(ContainSubstring("it does not expire")))
+	})
+
+	It("refuses tokens that are unsigned or signed with another key", func() {
+		_, err := verifier.Verify(ctx, sign(map[string]interface{}{"alg": "none", "kid": keyID}, key))
+		Expect(err).To(MatchError(ContainSubstring(`it is signed with "none"`)))
+
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = verifier.Verify(ctx, sign(map[string]interface{}{"alg": "RS256", "kid": keyID}, other))
+		Expect(err).To(MatchError(ContainSubstring(`its signature does not match key "key-1"`)))
+
+		tampered := strings.Split(token(), ".")
+		claims["scope"] = []string{"nfsbroker.admin", "cloud_controller.admin"}
+		claimsJSON, _ := json.Marshal(claims)
+		tampered[1] = base64.RawURLEncoding.EncodeToString(claimsJSON)
+		_, err = verifier.Verify(ctx, strings.Join(tampered, "."))
+		Expect(err).To(MatchError(ContainSubstring("its signature does not match")))
+	})
+
+	It("refuses malformed tokens", func() {
+		_, err := verifier.Verify(ctx, "not-a-token")
+		Expect(err).To(MatchError(ContainSubstring("it is not a signed JWT")))
+	})
+
+	Context("when the UAA rotates in a new key", func() {
+		It("fetches the keys again, at most once a minute", func() {
+			_, err := verifier.Verify(ctx, token())
+			Expect(err).NotTo(HaveOccurred())
+
+			oldKey := key
+			key, _ = rsa.GenerateKey(rand.Reader, 2048)
+			keyID = "key-2"
+			_, err = verifier.Verify(ctx, token())
+			Expect(err).To(MatchError(ContainSubstring(`unknown key "key-2"`)))
+
+			serveKeys(key, oldKey)
+			fakeClock.Increment(time.Minute)
+			_, err = verifier.Verify(ctx, token())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+	})
+
+	It("returns errors fetching the keys as they are", func() {
+		server.RouteToHandler("GET", "/token_keys", ghttp.RespondWith(http.StatusBadGateway, ""))
+		_, err := verifier.Verify(ctx, token())
+		Expect(errors.Is(err, uaa.ErrInvalidToken)).To(BeFalse())
+		Expect(err).To(MatchError(ContainSubstring("returned 502")))
+	})
+
+	Describe("Wrap", func() {
+		var handler http.Handler
+
+		serve := func(authorization string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/v2/catalog", nil)
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			handler.ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		BeforeEach(func() {
+			ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+			handler = verifier.Wrap(ok, nil)
+		})
+
+		It("serves requests with valid tokens", func() {
+			Expect(serve("Bearer " + token()).Code).To(Equal(http.StatusOK))
+			Expect(serve("bearer " + token()).Code).To(Equal(http.StatusOK))
+		})
+
+		It("refuses requests with invalid tokens or none", func() {
+			claims["scope"] = []string{}
+			recorder := serve("Bearer " + token())
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			Expect(recorder.Header().Get("WWW-Authenticate")).To(Equal(`Bearer error="invalid_token"`))
+			Expect(recorder.Body.String()).To(ContainSubstring("does not grant scopes"))
+
+			Expect(serve("").Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("passes requests without a bearer token on when told to", func() {
+			handler = verifier.Wrap(http.NotFoundHandler(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}))
+			Expect(serve("Basic YWRtaW46cGFzc3dvcmQ=").Code).To(Equal(http.StatusTeapot))
+		})
+
+		It("reports keys that cannot be fetched as unavailable", func() {
+			server.RouteToHandler("GET", "/token_keys", ghttp.RespondWith(http.StatusBadGateway, ""))
+			Expect(serve("Bearer " + token()).Code).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+})
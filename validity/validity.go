@@ -0,0 +1,106 @@
+// Package validity checks the validity periods of tokens, certificates and signed timestamps with a tolerance for
+// clock skew, so that every auth integration forgives the same drift between VMs and reports failures in a way that
+// tells a credential that has really expired apart from a clock that is off.
+package validity
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+)
+
+// DefaultTolerance is the clock skew forgiven unless operators configure otherwise.
+const DefaultTolerance = 30 * time.Second
+
+// Checks fail with an *Error that wraps one of these, so callers should test for them with errors.Is.
+var (
+	ErrExpired     = errors.New("expired")
+	ErrNotYetValid = errors.New("not yet valid")
+)
+
+// Error describes a validity check that failed by more than the tolerated clock skew.
+type Error struct {
+	Subject   string
+	Reason    error
+	Boundary  time.Time
+	Now       time.Time
+	Tolerance time.Duration
+}
+
+func (e *Error) Unwrap() error {
+	return e.Reason
+}
+
+// Outside is how far the check's time was outside the validity period.
+func (e *Error) Outside() time.Duration {
+	if e.Reason == ErrNotYetValid {
+		return e.Boundary.Sub(e.Now)
+	}
+	return e.Now.Sub(e.Boundary)
+}
+
+func (e *Error) Error() string {
+	outside := e.Outside().Round(time.Second)
+	if e.Reason == ErrNotYetValid {
+		// credentials are not issued for the future, so this is almost always a clock that is behind
+		return fmt.Sprintf("%s is not valid until %s, %s from now, which is more than the %s of clock skew tolerated: the clock of this VM is probably behind the issuer's",
+			e.Subject, e.Boundary.UTC().Format(time.RFC3339), outside, e.Tolerance)
+	}
+	return fmt.Sprintf("%s expired at %s, %s ago, which is more than the %s of clock skew tolerated",
+		e.Subject, e.Boundary.UTC().Format(time.RFC3339), outside, e.Tolerance)
+}
+
+// Checker checks validity periods against its clock.  Times within Tolerance of the period are accepted.
+type Checker struct {
+	Clock     clock.Clock
+	Tolerance time.Duration
+
+	// Logger records times that were only accepted because of the tolerance, so that drifting clocks show up before
+	// they drift far enough to fail checks.  It may be nil.
+	Logger lager.Logger
+}
+
+func NewChecker(clock clock.Clock, tolerance time.Duration, logger lager.Logger) Checker {
+	return Checker{Clock: clock, Tolerance: tolerance, Logger: logger}
+}
+
+// Check checks that the current time is between notBefore and expiresAt.  Zero times are not checked.
+func (c Checker) Check(subject string, notBefore, expiresAt time.Time) error {
+	now := c.Clock.Now()
+	if !notBefore.IsZero() && now.Before(notBefore) {
+		if err := c.outside(subject, ErrNotYetValid, notBefore, now, notBefore.Sub(now)); err != nil {
+			return err
+		}
+	}
+	if !expiresAt.IsZero() && !now.Before(expiresAt) {
+		if err := c.outside(subject, ErrExpired, expiresAt, now, now.Sub(expiresAt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckTimestamp checks a signed timestamp, such as that of a webhook request, which is valid for maxAge after it
+// was made.
+func (c Checker) CheckTimestamp(subject string, timestamp time.Time, maxAge time.Duration) error {
+	return c.Check(subject, timestamp, timestamp.Add(maxAge))
+}
+
+// CheckCertificate checks the validity period of a certificate.
+func (c Checker) CheckCertificate(cert *x509.Certificate) error {
+	return c.Check(fmt.Sprintf("certificate %q", cert.Subject.CommonName), cert.NotBefore, cert.NotAfter)
+}
+
+func (c Checker) outside(subject string, reason error, boundary, now time.Time, by time.Duration) error {
+	if by > c.Tolerance {
+		return &Error{Subject: subject, Reason: reason, Boundary: boundary, Now: now, Tolerance: c.Tolerance}
+	}
+	if c.Logger != nil {
+		c.Logger.Info("clock-skew-tolerated", lager.Data{"subject": subject, "reason": reason.Error(), "skew": by.String()})
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package validity_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestValidity(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Validity Suite")
+}
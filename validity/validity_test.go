@@ -0,0 +1,98 @@
+package validity_test
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/validity"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Checker", func() {
+	var (
+		now       time.Time
+		fakeClock *fakeclock.FakeClock
+		logger    *lagertest.TestLogger
+		checker   validity.Checker
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+		fakeClock = fakeclock.NewFakeClock(now)
+		logger = lagertest.NewTestLogger("test-validity")
+		checker = validity.NewChecker(fakeClock, 30*time.Second, logger)
+	})
+
+	It("accepts times within the validity period", func() {
+		Expect(checker.Check("token", now.Add(-time.Minute), now.Add(time.Minute))).To(Succeed())
+		Expect(logger).NotTo(gbytes.Say("clock-skew-tolerated"))
+	})
+
+	It("does not check zero times", func() {
+		Expect(checker.Check("token", time.Time{}, time.Time{})).To(Succeed())
+	})
+
+	Context("when the period has ended", func() {
+		It("tolerates the configured skew and logs it", func() {
+			Expect(checker.Check("token", time.Time{}, now.Add(-20*time.Second))).To(Succeed())
+			Expect(logger).To(gbytes.Say("clock-skew-tolerated"))
+		})
+
+		It("reports expiry beyond the tolerance", func() {
+			err := checker.Check("token", time.Time{}, now.Add(-5*time.Minute))
+			Expect(errors.Is(err, validity.ErrExpired)).To(BeTrue())
+			Expect(err).To(MatchError("token expired at 2017-01-01T11:55:00Z, 5m0s ago, which is more than the 30s of clock skew tolerated"))
+
+			var validityErr *validity.Error
+			Expect(errors.As(err, &validityErr)).To(BeTrue())
+			Expect(validityErr.Outside()).To(Equal(5 * time.Minute))
+		})
+	})
+
+	Context("when the period has not started", func() {
+		It("tolerates the configured skew", func() {
+			Expect(checker.Check("token", now.Add(10*time.Second), time.Time{})).To(Succeed())
+		})
+
+		It("reports a clock that is behind", func() {
+			err := checker.Check("token", now.Add(2*time.Minute), now.Add(time.Hour))
+			Expect(errors.Is(err, validity.ErrNotYetValid)).To(BeTrue())
+			Expect(errors.Is(err, validity.ErrExpired)).To(BeFalse())
+			Expect(err).To(MatchError(ContainSubstring("2m0s from now")))
+			Expect(err).To(MatchError(ContainSubstring("the clock of this VM is probably behind the issuer's")))
+		})
+	})
+
+	Describe("CheckTimestamp", func() {
+		It("accepts timestamps younger than the maximum age", func() {
+			Expect(checker.CheckTimestamp("request timestamp", now.Add(-4*time.Minute), 5*time.Minute)).To(Succeed())
+		})
+
+		It("rejects older timestamps", func() {
+			err := checker.CheckTimestamp("request timestamp", now.Add(-10*time.Minute), 5*time.Minute)
+			Expect(errors.Is(err, validity.ErrExpired)).To(BeTrue())
+		})
+
+		It("rejects timestamps from the future", func() {
+			err := checker.CheckTimestamp("request timestamp", now.Add(time.Minute), 5*time.Minute)
+			Expect(errors.Is(err, validity.ErrNotYetValid)).To(BeTrue())
+		})
+	})
+
+	Describe("CheckCertificate", func() {
+		It("names the certificate", func() {
+			cert := &x509.Certificate{
+				Subject:   pkix.Name{CommonName: "uaa.example.com"},
+				NotBefore: now.Add(-48 * time.Hour),
+				NotAfter:  now.Add(-24 * time.Hour),
+			}
+			Expect(checker.CheckCertificate(cert)).To(MatchError(HavePrefix(`certificate "uaa.example.com" expired at`)))
+		})
+	})
+})
@@ -0,0 +1,152 @@
+// Package webhook delivers the broker's events to an operator's HTTP endpoint.  Each event is POSTed as JSON and
+// signed with an HMAC-SHA256 of its timestamp and body, so that receivers can check that it came from the broker and
+// is not a replay, and failed deliveries are retried with backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/nfsbroker/backoff"
+	"code.cloudfoundry.org/nfsbroker/validity"
+)
+
+// Deliveries carry the time they were signed, in seconds since the Unix epoch, and the hex-encoded signature.
+const (
+	TimestampHeader = "X-Broker-Webhook-Timestamp"
+	SignatureHeader = "X-Broker-Webhook-Signature"
+)
+
+// DefaultMaxAge is how long after it was signed a delivery is accepted by Verify, unless receivers choose otherwise.
+const DefaultMaxAge = 5 * time.Minute
+
+// QueueSize bounds the events waiting to be delivered.  Further events are dropped, so that an endpoint that is down
+// cannot hold up the broker.
+const QueueSize = 1000
+
+// Sender delivers events to the endpoint at its URL, one at a time and in the order they were sent.
+type Sender struct {
+	logger      lager.Logger
+	url         string
+	secret      []byte
+	httpClient  *http.Client
+	clock       clock.Clock
+	retryPolicy backoff.Policy
+	queue       chan interface{}
+}
+
+func NewSender(logger lager.Logger, url string, secret []byte, httpClient *http.Client, clock clock.Clock, retryPolicy backoff.Policy) *Sender {
+	return &Sender{
+		logger:      logger,
+		url:         url,
+		secret:      secret,
+		httpClient:  httpClient,
+		clock:       clock,
+		retryPolicy: retryPolicy,
+		queue:       make(chan interface{}, QueueSize),
+	}
+}
+
+// Send queues an event, which is marshalled as JSON, to be delivered by Run.
+func (s *Sender) Send(event interface{}) {
+	select {
+	case s.queue <- event:
+	default:
+		s.logger.Error("dropped-event", errors.New("too many events are waiting to be delivered"))
+	}
+}
+
+// Run delivers queued events until it is signalled.  Events that have not been delivered by then are dropped.
+func (s *Sender) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-s.queue:
+				if err := s.deliver(ctx, event); err != nil {
+					s.logger.Error("failed-to-deliver", err)
+				}
+			}
+		}
+	}()
+	close(ready)
+
+	<-signals
+	cancel()
+	<-done
+	if undelivered := len(s.queue); undelivered > 0 {
+		s.logger.Info("dropped-undelivered-events", lager.Data{"events": undelivered})
+	}
+	return nil
+}
+
+func (s *Sender) deliver(ctx context.Context, event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return backoff.Retry(ctx, s.logger.Session("deliver"), s.clock, s.retryPolicy, func(ctx context.Context) error {
+		req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		timestamp := s.clock.Now()
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+		req.Header.Set(SignatureHeader, Sign(s.secret, timestamp, body))
+
+		resp, err := s.httpClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			return nil
+		}
+		err = fmt.Errorf("webhook endpoint returned status %d", resp.StatusCode)
+		// the endpoint refused the event itself, so sending it again would not help
+		if resp.StatusCode >= 400 && resp.StatusCode <= 499 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			return backoff.Permanent(err)
+		}
+		return err
+	})
+}
+
+// Sign returns the hex-encoded HMAC-SHA256, keyed with secret, of the timestamp in seconds since the Unix epoch, a dot
+// and the body.
+func Sign(secret []byte, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks that a delivery's headers sign its body with secret, and that it was signed no more than maxAge ago,
+// give or take the clock skew checker tolerates.  Receivers written in Go can use it to check deliveries.
+func Verify(checker validity.Checker, secret []byte, maxAge time.Duration, header http.Header, body []byte) error {
+	seconds, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s header: %w", TimestampHeader, err)
+	}
+	timestamp := time.Unix(seconds, 0)
+	if !hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(Sign(secret, timestamp, body))) {
+		return errors.New("webhook signature does not match")
+	}
+	return checker.CheckTimestamp("webhook timestamp", timestamp, maxAge)
+}
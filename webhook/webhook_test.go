@@ -0,0 +1,130 @@
+package webhook_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/nfsbroker/backoff"
+	"code.cloudfoundry.org/nfsbroker/validity"
+	"code.cloudfoundry.org/nfsbroker/webhook"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/tedsuo/ifrit"
+)
+
+var _ = Describe("Sender", func() {
+	var (
+		server  *ghttp.Server
+		logger  *lagertest.TestLogger
+		sender  *webhook.Sender
+		process ifrit.Process
+		secret  []byte
+		checker validity.Checker
+	)
+
+	// verifySignature checks deliveries the way receivers would.
+	verifySignature := func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(webhook.Verify(checker, secret, webhook.DefaultMaxAge, req.Header, body)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		logger = lagertest.NewTestLogger("test-webhook")
+		secret = []byte("webhook-secret")
+		checker = validity.NewChecker(clock.NewClock(), validity.DefaultTolerance, nil)
+		policy := backoff.Policy{InitialDelay: time.Millisecond, Multiplier: 2, MaxAttempts: 3}
+		sender = webhook.NewSender(logger, server.URL()+"/events", secret, http.DefaultClient, clock.NewClock(), policy)
+		process = ifrit.Invoke(sender)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+		server.Close()
+	})
+
+	It("posts signed events as JSON", func() {
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("POST", "/events"),
+			ghttp.VerifyContentType("application/json"),
+			verifySignature,
+		))
+		server.AppendHandlers(ghttp.VerifyJSON(`{"operation":"provision"}`))
+		sender.Send(map[string]string{"operation": "provision"})
+		sender.Send(map[string]string{"operation": "provision"})
+		Eventually(server.ReceivedRequests).Should(HaveLen(2))
+	})
+
+	It("retries deliveries that fail", func() {
+		server.AppendHandlers(
+			ghttp.RespondWith(http.StatusServiceUnavailable, ""),
+			ghttp.RespondWith(http.StatusTooManyRequests, ""),
+			ghttp.CombineHandlers(verifySignature, ghttp.RespondWith(http.StatusNoContent, "")),
+		)
+		sender.Send(map[string]string{"operation": "bind"})
+		Eventually(server.ReceivedRequests).Should(HaveLen(3))
+		Consistently(logger).ShouldNot(gbytes.Say("failed-to-deliver"))
+	})
+
+	It("does not retry events the endpoint refuses", func() {
+		server.AppendHandlers(ghttp.RespondWith(http.StatusBadRequest, ""))
+		sender.Send(map[string]string{"operation": "unbind"})
+		Eventually(logger).Should(gbytes.Say("failed-to-deliver.*status 400"))
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("Verify", func() {
+	var (
+		fakeClock *fakeclock.FakeClock
+		checker   validity.Checker
+		secret    []byte
+		body      []byte
+		header    http.Header
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC))
+		checker = validity.NewChecker(fakeClock, 30*time.Second, nil)
+		secret = []byte("webhook-secret")
+		body = []byte(`{"operation":"provision"}`)
+		header = http.Header{}
+		signed := fakeClock.Now()
+		header.Set(webhook.TimestampHeader, strconv.FormatInt(signed.Unix(), 10))
+		header.Set(webhook.SignatureHeader, webhook.Sign(secret, signed, body))
+	})
+
+	It("accepts deliveries signed with the secret", func() {
+		Expect(webhook.Verify(checker, secret, time.Minute, header, body)).To(Succeed())
+	})
+
+	It("rejects deliveries whose body or secret differs", func() {
+		Expect(webhook.Verify(checker, secret, time.Minute, header, []byte(`{}`))).To(MatchError("webhook signature does not match"))
+		Expect(webhook.Verify(checker, []byte("other-secret"), time.Minute, header, body)).To(MatchError("webhook signature does not match"))
+	})
+
+	It("rejects replays older than the maximum age and the clock skew tolerated", func() {
+		fakeClock.Increment(time.Minute + 20*time.Second)
+		Expect(webhook.Verify(checker, secret, time.Minute, header, body)).To(Succeed())
+		fakeClock.Increment(20 * time.Second)
+		err := webhook.Verify(checker, secret, time.Minute, header, body)
+		Expect(err).To(MatchError(validity.ErrExpired))
+		Expect(err).To(MatchError(ContainSubstring("webhook timestamp expired")))
+	})
+
+	It("rejects deliveries signed further in the future than the clock skew tolerated", func() {
+		fakeClock.Increment(-time.Minute)
+		err := webhook.Verify(checker, secret, time.Minute, header, body)
+		Expect(err).To(MatchError(validity.ErrNotYetValid))
+	})
+})